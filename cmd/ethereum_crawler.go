@@ -64,12 +64,100 @@ var Eth2CrawlerCommand = &cli.Command{
 			EnvVars:     []string{"ARMIARMA_USER_AGENT"},
 			DefaultText: config.DefaultUserAgent,
 		},
+		&cli.IntFlag{
+			Name:        "max-user-agent-length",
+			Usage:       "Length (in bytes) above which the user agents of the peers get truncated",
+			EnvVars:     []string{"ARMIARMA_MAX_USER_AGENT_LENGTH"},
+			DefaultText: "1024",
+		},
+		&cli.IntFlag{
+			Name:        "metadata-workers",
+			Usage:       "Number of workers that identify and request the metadata of the new connections",
+			EnvVars:     []string{"ARMIARMA_METADATA_WORKERS"},
+			DefaultText: fmt.Sprintf("%d", config.DefaultMetadataWorkers),
+		},
+		&cli.IntFlag{
+			Name:        "metadata-queue-size",
+			Usage:       "Number of new connections that can wait for a metadata worker (the rest get recorded without metadata)",
+			EnvVars:     []string{"ARMIARMA_METADATA_QUEUE_SIZE"},
+			DefaultText: fmt.Sprintf("%d", config.DefaultMetadataQueueSize),
+		},
+		&cli.StringFlag{
+			Name:        "metadata-timeout",
+			Usage:       "Time that each new connection gets to be identified and to answer the metadata requests",
+			EnvVars:     []string{"ARMIARMA_METADATA_TIMEOUT"},
+			DefaultText: config.DefaultMetadataTimeout,
+		},
+		&cli.StringFlag{
+			Name:        "req-backoff-base",
+			Usage:       "Minimum time between two Status (or MetaData) requests to the same peer, doubled by each consecutive failure",
+			EnvVars:     []string{"ARMIARMA_REQ_BACKOFF_BASE"},
+			DefaultText: config.DefaultReqBackoffBase,
+		},
+		&cli.StringFlag{
+			Name:        "req-backoff-max",
+			Usage:       "Maximum time between two Status (or MetaData) requests to the same peer",
+			EnvVars:     []string{"ARMIARMA_REQ_BACKOFF_MAX"},
+			DefaultText: config.DefaultReqBackoffMax,
+		},
+		&cli.StringSliceFlag{
+			Name:    "dial-delay",
+			Usage:   "Delay of the next connection attempt to a peer after a failure with the given error, doubled by each consecutive failure (One --dial-delay <error>=<duration> per error, \"success\" and \"default\" set the delays after a success and after the other errors)",
+			EnvVars: []string{"ARMIARMA_DIAL_DELAYS"},
+		},
+		&cli.StringFlag{
+			Name:        "dial-delay-max",
+			Usage:       "Maximum delay of the next connection attempt to a peer",
+			EnvVars:     []string{"ARMIARMA_DIAL_DELAY_MAX"},
+			DefaultText: config.DefaultDialDelayMax,
+		},
+		&cli.BoolFlag{
+			Name:    "dialback",
+			Usage:   "Dial back the advertised public addresses of the peers that connect to us, to estimate the share of them behind a NAT",
+			EnvVars: []string{"ARMIARMA_DIALBACK"},
+		},
+		&cli.IntFlag{
+			Name:        "dialback-max-attempts",
+			Usage:       "Number of dial-backs that each inbound peer gets at most",
+			EnvVars:     []string{"ARMIARMA_DIALBACK_MAX_ATTEMPTS"},
+			DefaultText: fmt.Sprintf("%d", config.DefaultDialbackMaxAttempts),
+		},
+		&cli.StringFlag{
+			Name:        "dialback-interval",
+			Usage:       "Minimum time between two dial-backs to the same peer",
+			EnvVars:     []string{"ARMIARMA_DIALBACK_INTERVAL"},
+			DefaultText: config.DefaultDialbackInterval,
+		},
+		&cli.StringFlag{
+			Name:        "dialback-timeout",
+			Usage:       "Time that each dial-back gets to reach the peer",
+			EnvVars:     []string{"ARMIARMA_DIALBACK_TIMEOUT"},
+			DefaultText: config.DefaultDialbackTimeout,
+		},
+		&cli.StringFlag{
+			Name:        "seen-msgs-window",
+			Usage:       "Time that the gossip message IDs are remembered to tell the peers that deliver a message first from the ones that deliver duplicates",
+			EnvVars:     []string{"ARMIARMA_SEEN_MSGS_WINDOW"},
+			DefaultText: config.DefaultSeenMsgsWindow,
+		},
+		&cli.IntFlag{
+			Name:        "seen-msgs-capacity",
+			Usage:       "Number of gossip message IDs remembered at most (~150 bytes each), the oldest ones are forgotten first",
+			EnvVars:     []string{"ARMIARMA_SEEN_MSGS_CAPACITY"},
+			DefaultText: fmt.Sprintf("%d", config.DefaultSeenMsgsCapacity),
+		},
 		&cli.StringFlag{
 			Name:        "psql-endpoint",
-			Usage:       "PSQL enpoint where the crwaler will submit the all the gathered info",
+			Usage:       "PSQL enpoint where the crwaler will submit the all the gathered info (sqlite://path to keep it in a local SQLite file, file://dir to append it to rotating NDJSON files, empty to keep the crawl in memory)",
 			EnvVars:     []string{"ARMIARMA_PSQL"},
 			DefaultText: config.DefaultPSQLEndpoint,
 		},
+		&cli.StringFlag{
+			Name:        "psql-schema",
+			Usage:       "PSQL schema where the crawler keeps its tables, so that several crawlers can share a database",
+			EnvVars:     []string{"ARMIARMA_PSQL_SCHEMA"},
+			DefaultText: config.DefaultPSQLSchema,
+		},
 		&cli.StringFlag{
 			Name:        "peers-backup",
 			Usage:       "Time interval that will be use to backup the peer_ids into a single table - allowing to recontruct the network in past-crawled times",
@@ -88,6 +176,24 @@ var Eth2CrawlerCommand = &cli.Command{
 			EnvVars:     []string{"ARMIARMA_FORK_DIGEST"},
 			DefaultText: eth.DefaultForkDigest,
 		},
+		&cli.StringFlag{
+			Name:        "foreign-enrs",
+			Usage:       "What to do with the discovered ENRs of other networks: drop (only count them) or flag (persist them with the foreign_network flag)",
+			EnvVars:     []string{"ARMIARMA_FOREIGN_ENRS"},
+			DefaultText: config.DefaultForeignEnrs,
+		},
+		&cli.StringSliceFlag{
+			Name:        "fork-digest-allowlist",
+			Usage:       "Fork digests of the crawled network, the ENRs and the peers of any other one are skipped (by default the known fork digests of the fork-digest network, all of them for the \"all\" fork digest)",
+			EnvVars:     []string{"ARMIARMA_FORK_DIGEST_ALLOWLIST"},
+			DefaultText: "One --fork-digest-allowlist <fork-digest> per fork digest",
+		},
+		&cli.StringFlag{
+			Name:        "eth2less-enrs",
+			Usage:       "What to do with the discovered ENRs without eth2 entry: keep (persist and dial them) or drop (only count them)",
+			EnvVars:     []string{"ARMIARMA_ETH2LESS_ENRS"},
+			DefaultText: config.DefaultEth2lessEnrs,
+		},
 		&cli.StringSliceFlag{
 			Name:    "bootnode",
 			Usage:   "List of boondes that the crawler will use to discover more peers in the network (One --bootnode <bootnode> per bootnode)",
@@ -109,6 +215,61 @@ var Eth2CrawlerCommand = &cli.Command{
 			Usage:   "Decide whether we want to track the connection-events into the DB (Disk intense)",
 			EnvVars: []string{"ARMIARMA_PERSIST_CONNEVENTS"},
 		},
+		&cli.BoolFlag{
+			Name:    "ignore-addr-ports",
+			Usage:   "Don't flag as address mismatch the peers whose observed address only differs from their ENR in the port",
+			EnvVars: []string{"ARMIARMA_IGNORE_ADDR_PORTS"},
+		},
+		&cli.BoolFlag{
+			Name:    "psql-integrity-check",
+			Usage:   "Report at start the orphan rows that the crawl tables of the DB accumulated (i.e. through crashes), without repairing them",
+			EnvVars: []string{"ARMIARMA_PSQL_INTEGRITY_CHECK"},
+		},
+		&cli.BoolFlag{
+			Name:    "psql-resume-peers",
+			Usage:   "Seed the in-memory peer store at start with the non-deprecated peers of the DB, so that a restart doesn't identify them again",
+			EnvVars: []string{"ARMIARMA_PSQL_RESUME_PEERS"},
+		},
+		&cli.IntFlag{
+			Name:        "psql-persisters",
+			Usage:       "Number of workers persisting the crawled items into the DB, each with its own batch (the items of each peer are always persisted by the same one)",
+			EnvVars:     []string{"ARMIARMA_PSQL_PERSISTERS"},
+			DefaultText: fmt.Sprintf("%d", config.DefaultPsqlPersisters),
+		},
+		&cli.Int64Flag{
+			Name:        "file-sink-max-size",
+			Usage:       "Size in MB from which the files of a file:// psql-endpoint are rotated into new files (0 disables it)",
+			EnvVars:     []string{"ARMIARMA_FILE_SINK_MAX_SIZE"},
+			DefaultText: fmt.Sprintf("%d", config.DefaultFileSinkMaxSize),
+		},
+		&cli.StringFlag{
+			Name:        "file-sink-max-age",
+			Usage:       "Age from which the files of a file:// psql-endpoint are rotated into new files, i.e. 24h (0 disables it)",
+			EnvVars:     []string{"ARMIARMA_FILE_SINK_MAX_AGE"},
+			DefaultText: config.DefaultFileSinkMaxAge,
+		},
+		&cli.BoolFlag{
+			Name:    "file-sink-compress",
+			Usage:   "Gzip the files of a file:// psql-endpoint",
+			EnvVars: []string{"ARMIARMA_FILE_SINK_COMPRESS"},
+		},
+		&cli.StringFlag{
+			Name:        "ip-ttl",
+			Usage:       "Time after which the location of an IP is resolved again (the located ones are kept in the DB across restarts)",
+			EnvVars:     []string{"ARMIARMA_IP_TTL"},
+			DefaultText: config.DefaultIpTTL,
+		},
+		&cli.StringFlag{
+			Name:        "ip-provider",
+			Usage:       "Provider that locates the IPs of the peers: ip-api (online) or maxmind (offline, from the --geoip-db database)",
+			EnvVars:     []string{"ARMIARMA_IP_PROVIDER"},
+			DefaultText: config.DefaultIpProvider,
+		},
+		&cli.StringFlag{
+			Name:    "geoip-db",
+			Usage:   "Path of the MaxMind GeoLite2-City (or GeoIP2-City) .mmdb database that the maxmind ip-provider reads",
+			EnvVars: []string{"ARMIARMA_GEOIP_DB"},
+		},
 		&cli.BoolFlag{
 			Name:    "persist-msgs",
 			Usage:   "Decide whether we want to track the msgs-metadata into the DB",
@@ -119,6 +280,204 @@ var Eth2CrawlerCommand = &cli.Command{
 			Usage:   "Path of the file that has the pubkeys of those validators that we want to track (experimental)",
 			EnvVars: []string{"ARMIARMA_VAL_PUBKEYS"},
 		},
+		&cli.StringFlag{
+			Name:        "summary-interval",
+			Usage:       "Time interval between the summary reports of the crawl that are written in the logs (0 disables them)",
+			EnvVars:     []string{"ARMIARMA_SUMMARY_INTERVAL"},
+			DefaultText: config.DefaultSummaryInterval,
+		},
+		&cli.StringFlag{
+			Name:    "summary-file",
+			Usage:   "Path of the file where the summary reports will be appended (optional)",
+			EnvVars: []string{"ARMIARMA_SUMMARY_FILE"},
+		},
+		&cli.IntFlag{
+			Name:        "subnet-coverage-threshold",
+			Usage:       "Connected peers per attestation subnet below which the summary reports warn about the subnet (0 disables the warnings)",
+			EnvVars:     []string{"ARMIARMA_SUBNET_COVERAGE_THRESHOLD"},
+			DefaultText: "0",
+		},
+		&cli.StringFlag{
+			Name:    "checkpoint-file",
+			Usage:   "Path of the file where the in-memory peer store is periodically checkpointed and restored from at start (optional)",
+			EnvVars: []string{"ARMIARMA_CHECKPOINT_FILE"},
+		},
+		&cli.StringFlag{
+			Name:        "checkpoint-interval",
+			Usage:       "Time interval between the checkpoints of the in-memory peer store",
+			EnvVars:     []string{"ARMIARMA_CHECKPOINT_INTERVAL"},
+			DefaultText: config.DefaultCheckpointInterval,
+		},
+		&cli.StringFlag{
+			Name:        "max-open-session",
+			Usage:       "Time without activity after which an open session whose disconnection got lost is closed",
+			EnvVars:     []string{"ARMIARMA_MAX_OPEN_SESSION"},
+			DefaultText: config.DefaultMaxOpenSession,
+		},
+		&cli.StringFlag{
+			Name:    "csv-export",
+			Usage:   "Path of the CSV file where the in-memory peer store is exported when the crawler stops, next to a sessions_histogram.csv, a first_delivery_leaderboard.csv and a topic_messages.csv (optional)",
+			EnvVars: []string{"ARMIARMA_CSV_EXPORT"},
+		},
+		&cli.StringFlag{
+			Name:    "json-export",
+			Usage:   "Path of the JSON file where the full in-memory peer store is exported when the crawler stops (optional)",
+			EnvVars: []string{"ARMIARMA_JSON_EXPORT"},
+		},
+		&cli.StringFlag{
+			Name:        "json-export-format",
+			Usage:       "Format of the json-export: a single JSON array (json) or one JSON peer per line (ndjson)",
+			EnvVars:     []string{"ARMIARMA_JSON_EXPORT_FORMAT"},
+			DefaultText: config.DefaultJsonExportFormat,
+		},
+		&cli.Int64Flag{
+			Name:    "export-max-size",
+			Usage:   "Size in MB from which the summary file and the CSV export are rotated into a new file (0 disables it)",
+			EnvVars: []string{"ARMIARMA_EXPORT_MAX_SIZE"},
+		},
+		&cli.StringFlag{
+			Name:        "export-max-age",
+			Usage:       "Age from which the summary file and the CSV export are rotated into a new file, i.e. 24h (0 disables it)",
+			EnvVars:     []string{"ARMIARMA_EXPORT_MAX_AGE"},
+			DefaultText: config.DefaultExportMaxAge,
+		},
+		&cli.BoolFlag{
+			Name:    "export-compress",
+			Usage:   "Gzip the summary file and the CSV export",
+			EnvVars: []string{"ARMIARMA_EXPORT_COMPRESS"},
+		},
+		&cli.StringFlag{
+			Name:        "export-interval",
+			Usage:       "Time interval between the scheduled CSV exports of the peer store into timestamped files next to the csv-export, i.e. 30m (0 disables them)",
+			EnvVars:     []string{"ARMIARMA_EXPORT_INTERVAL"},
+			DefaultText: config.DefaultExportInterval,
+		},
+		&cli.IntFlag{
+			Name:        "export-keep",
+			Usage:       "Number of scheduled CSV exports that are kept, removing the older ones (0 keeps all of them)",
+			EnvVars:     []string{"ARMIARMA_EXPORT_KEEP"},
+			DefaultText: "24",
+		},
+		&cli.BoolFlag{
+			Name:    "export-countries",
+			Usage:   "Include the per-country summary (countries.csv) in the scheduled CSV exports",
+			EnvVars: []string{"ARMIARMA_EXPORT_COUNTRIES"},
+		},
+		&cli.BoolFlag{
+			Name:    "export-summary",
+			Usage:   "Include the aggregated network summary (network_summary.json and summary_*.csv) in the scheduled CSV exports",
+			EnvVars: []string{"ARMIARMA_EXPORT_SUMMARY"},
+		},
+		&cli.StringFlag{
+			Name:        "peer-eviction-interval",
+			Usage:       "Time interval between the evictions of the deprecated and inactive peers from the in-memory peer store, i.e. 1h (0 disables them)",
+			EnvVars:     []string{"ARMIARMA_PEER_EVICTION_INTERVAL"},
+			DefaultText: config.DefaultPeerEvictionInterval,
+		},
+		&cli.StringFlag{
+			Name:        "peer-eviction-window",
+			Usage:       "Time without any interaction after which a peer gets evicted from the in-memory peer store",
+			EnvVars:     []string{"ARMIARMA_PEER_EVICTION_WINDOW"},
+			DefaultText: config.DefaultPeerEvictionWindow,
+		},
+		&cli.StringFlag{
+			Name:        "peer-deprecation-interval",
+			Usage:       "Time interval between the deprecations of the stale peers, that leave the dial queue until they get rediscovered, i.e. 1h (0 disables them)",
+			EnvVars:     []string{"ARMIARMA_PEER_DEPRECATION_INTERVAL"},
+			DefaultText: config.DefaultPeerDeprecationInterval,
+		},
+		&cli.StringFlag{
+			Name:        "peer-deprecation-window",
+			Usage:       "Time without any activity after which an unreachable peer gets deprecated",
+			EnvVars:     []string{"ARMIARMA_PEER_DEPRECATION_WINDOW"},
+			DefaultText: config.DefaultPeerDeprecationWindow,
+		},
+		&cli.IntFlag{
+			Name:        "peer-deprecation-failures",
+			Usage:       "Minimum number of consecutive failed connection attempts to deprecate a peer",
+			EnvVars:     []string{"ARMIARMA_PEER_DEPRECATION_FAILURES"},
+			DefaultText: fmt.Sprintf("%d", config.DefaultPeerDeprecationFailures),
+		},
+		&cli.StringFlag{
+			Name:    "api-addr",
+			Usage:   "Address (host:port) of the HTTP API that serves the peers and the summary of the DB, i.e. 127.0.0.1:9081 (empty disables it)",
+			EnvVars: []string{"ARMIARMA_API_ADDR"},
+		},
+		&cli.StringFlag{
+			Name:        "api-timeout",
+			Usage:       "Time limit of the DB queries of each request to the HTTP API",
+			EnvVars:     []string{"ARMIARMA_API_TIMEOUT"},
+			DefaultText: config.DefaultApiTimeout,
+		},
+		&cli.StringFlag{
+			Name:        "provider-refresh-interval",
+			Usage:       "Time interval between the downloads of the IP ranges published by AWS and GCP, updating the bundled ones, i.e. 24h (0 disables them)",
+			EnvVars:     []string{"ARMIARMA_PROVIDER_REFRESH_INTERVAL"},
+			DefaultText: config.DefaultProviderRefreshInterval,
+		},
+		&cli.StringFlag{
+			Name:        "topic-deltas-interval",
+			Usage:       "Time interval between the persistences of the per-peer and per-topic message counts into the DB, i.e. 5m (0 disables them)",
+			EnvVars:     []string{"ARMIARMA_TOPIC_DELTAS_INTERVAL"},
+			DefaultText: config.DefaultTopicDeltasInterval,
+		},
+		&cli.StringFlag{
+			Name:        "peer-sync-interval",
+			Usage:       "Time interval between the persistences of the peers that changed in the in-memory peer store into the DB, i.e. 1m (0 disables them)",
+			EnvVars:     []string{"ARMIARMA_PEER_SYNC_INTERVAL"},
+			DefaultText: config.DefaultPeerSyncInterval,
+		},
+		&cli.StringFlag{
+			Name:    "influx-endpoint",
+			Usage:   "InfluxDB v2 URL (http(s)://), socket (udp://, tcp:// or unix://) or file where the time series of the crawl are exported in line protocol (optional)",
+			EnvVars: []string{"ARMIARMA_INFLUX_ENDPOINT"},
+		},
+		&cli.StringFlag{
+			Name:    "influx-org",
+			Usage:   "Organization of the InfluxDB bucket",
+			EnvVars: []string{"ARMIARMA_INFLUX_ORG"},
+		},
+		&cli.StringFlag{
+			Name:    "influx-bucket",
+			Usage:   "InfluxDB bucket where the time series are written",
+			EnvVars: []string{"ARMIARMA_INFLUX_BUCKET"},
+		},
+		&cli.StringFlag{
+			Name:    "influx-token",
+			Usage:   "Token that authorizes the writes into the InfluxDB bucket",
+			EnvVars: []string{"ARMIARMA_INFLUX_TOKEN"},
+		},
+		&cli.StringFlag{
+			Name:        "influx-interval",
+			Usage:       "Time interval between the exports of the time series to InfluxDB",
+			EnvVars:     []string{"ARMIARMA_INFLUX_INTERVAL"},
+			DefaultText: config.DefaultInfluxInterval,
+		},
+		&cli.StringFlag{
+			Name:    "influx-crawler-id",
+			Usage:   "Id of the crawler with which the time series are tagged (default: the peer ID of the crawler)",
+			EnvVars: []string{"ARMIARMA_INFLUX_CRAWLER_ID"},
+		},
+		&cli.StringFlag{
+			Name:    "next-fork-version",
+			Usage:   "Version of the next scheduled fork, i.e. 0x04000000, to report the readiness of the peers for it",
+			EnvVars: []string{"ARMIARMA_NEXT_FORK_VERSION"},
+		},
+		&cli.Uint64Flag{
+			Name:    "next-fork-epoch",
+			Usage:   "Activation epoch of the next scheduled fork",
+			EnvVars: []string{"ARMIARMA_NEXT_FORK_EPOCH"},
+		},
+		&cli.StringFlag{
+			Name:    "next-fork-announced",
+			Usage:   "Date (2006-01-02 or RFC3339) when the next fork was announced, the readiness of the peers with older ENRs is unknown",
+			EnvVars: []string{"ARMIARMA_NEXT_FORK_ANNOUNCED"},
+		},
+		&cli.StringSliceFlag{
+			Name:    "next-fork-client-version",
+			Usage:   "Lowest version of a client that supports the next fork (One --next-fork-client-version <client>=<version> per client)",
+			EnvVars: []string{"ARMIARMA_NEXT_FORK_CLIENT_VERSIONS"},
+		},
 	},
 }
 
@@ -0,0 +1,70 @@
+/*
+Copyright © 2021 Miga Labs
+*/
+package cmd
+
+import (
+	"context"
+
+	log "github.com/sirupsen/logrus"
+	cli "github.com/urfave/cli/v2"
+
+	"github.com/migalabs/armiarma/pkg/config"
+	psql "github.com/migalabs/armiarma/pkg/db/postgresql"
+	"github.com/migalabs/armiarma/pkg/utils"
+)
+
+// ReclassifyCommand contains the maintenance sub-command that parses again the stored user agents.
+var ReclassifyCommand = &cli.Command{
+	Name:   "reclassify-agents",
+	Usage:  "parse again the user agents stored in the DB, updating the peers whose client classification changed",
+	Action: LaunchReclassify,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:        "log-level",
+			Usage:       "Verbosity level for the logs",
+			EnvVars:     []string{"ARMIARMA_LOG_LEVEL"},
+			Value:       config.DefaultLogLevel,
+			DefaultText: config.DefaultLogLevel,
+		},
+		&cli.StringFlag{
+			Name:        "psql-endpoint",
+			Usage:       "PSQL enpoint of the crawled DB",
+			EnvVars:     []string{"ARMIARMA_PSQL"},
+			Value:       config.DefaultPSQLEndpoint,
+			DefaultText: config.DefaultPSQLEndpoint,
+		},
+		&cli.StringFlag{
+			Name:        "psql-schema",
+			Usage:       "PSQL schema of the crawled tables",
+			EnvVars:     []string{"ARMIARMA_PSQL_SCHEMA"},
+			Value:       config.DefaultPSQLSchema,
+			DefaultText: config.DefaultPSQLSchema,
+		},
+	},
+}
+
+func LaunchReclassify(c *cli.Context) error {
+	log.SetLevel(utils.ParseLogLevel(c.String("log-level")))
+
+	// the maintenance doesn't backup anything, only the peer_info rows are updated
+	dbClient, err := psql.NewDBClient(
+		context.Background(),
+		utils.EthereumNetwork,
+		c.String("psql-endpoint"),
+		0,
+		psql.WithSchema(c.String("psql-schema")),
+		psql.WithoutBackups(),
+	)
+	if err != nil {
+		return err
+	}
+	defer dbClient.Close()
+
+	changed, err := dbClient.ReclassifyUserAgents()
+	if err != nil {
+		return err
+	}
+	log.Infof("reclassification done, %d peers changed", changed)
+	return nil
+}
@@ -7,6 +7,7 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/migalabs/armiarma/pkg/utils"
 	"github.com/sirupsen/logrus"
@@ -26,6 +27,7 @@ var (
 func main() {
 	// read arguments from the command line
 	PrintVersion()
+	utils.CrawlerVersion = strings.TrimSpace(Version)
 
 	// Set the general log configurations for the entire tool
 	logrus.SetFormatter(utils.ParseLogFormatter("text"))
@@ -44,6 +46,7 @@ func main() {
 		EnableBashCompletion: true,
 		Commands: []*cli.Command{
 			cmd.Eth2CrawlerCommand,
+			cmd.ReclassifyCommand,
 			// cmd.IpfsCrawlerCommand,
 		},
 	}
@@ -0,0 +1,277 @@
+// Package api serves the crawl results stored in the database over HTTP, so that a running
+// crawl can be checked remotely.
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/migalabs/armiarma/pkg/db"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// paths of the endpoints (the peer details are served at PeersEndpoint + "/{peer_id}")
+	PeersEndpoint   = "/api/peers"
+	SummaryEndpoint = "/api/summary"
+
+	// DefaultRequestTimeout bounds the queries of each request when the server doesn't set any
+	DefaultRequestTimeout = 5 * time.Second
+	// time given to the ongoing requests when shutting down
+	shutdownTimeout = 5 * time.Second
+)
+
+// ErrorResponse is the body of the failed requests.
+type ErrorResponse struct {
+	Error string `json:"error"`
+}
+
+// Server serves the crawl results of a db.Reader as JSON:
+//   - GET /api/peers lists the peers, filtered by client, country, connected and deprecated, and paged
+//     by limit and offset, or by the cursor of the previous page
+//   - GET /api/peers/{peer_id} returns the details of a peer
+//   - GET /api/summary returns the aggregate counts of the peers
+//
+// Each request is bound by the request timeout, so that the slow queries don't pile up.
+type Server struct {
+	ctx     context.Context
+	reader  db.Reader
+	timeout time.Duration
+
+	srv      *http.Server
+	listener net.Listener
+	wg       sync.WaitGroup
+	closeC   chan struct{}
+	once     sync.Once
+}
+
+// NewServer returns a Server of the reader that will listen on the given address (host:port)
+// until Close is called or the context is done. A non-positive timeout uses DefaultRequestTimeout.
+func NewServer(ctx context.Context, reader db.Reader, addr string, timeout time.Duration) *Server {
+	if timeout <= 0 {
+		timeout = DefaultRequestTimeout
+	}
+	s := &Server{
+		ctx:     ctx,
+		reader:  reader,
+		timeout: timeout,
+		closeC:  make(chan struct{}),
+	}
+	s.srv = &http.Server{
+		Addr:              addr,
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: timeout,
+		// the response is written after the queries, which can take up to the timeout
+		WriteTimeout: 2 * timeout,
+		BaseContext: func(net.Listener) context.Context {
+			return ctx
+		},
+	}
+	return s
+}
+
+// Handler returns the handler of the endpoints of the server.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(PeersEndpoint, s.handlePeers)
+	mux.HandleFunc(PeersEndpoint+"/", s.handlePeer)
+	mux.HandleFunc(SummaryEndpoint, s.handleSummary)
+	return mux
+}
+
+// Start listens on the address of the server and spawns the routine that serves the requests.
+func (s *Server) Start() error {
+	listener, err := net.Listen("tcp", s.srv.Addr)
+	if err != nil {
+		return errors.Wrap(err, "unable to listen on "+s.srv.Addr)
+	}
+	s.listener = listener
+	log.Infof("serving the crawl API on %s", listener.Addr().String())
+
+	s.wg.Add(2)
+	go func() {
+		defer s.wg.Done()
+		err := s.srv.Serve(listener)
+		if err != nil && err != http.ErrServerClosed {
+			log.Error(errors.Wrap(err, "crawl API server failed"))
+		}
+	}()
+	go func() {
+		defer s.wg.Done()
+		select {
+		case <-s.ctx.Done():
+		case <-s.closeC:
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := s.srv.Shutdown(ctx); err != nil {
+			log.Warn(errors.Wrap(err, "unable to shut down the crawl API server"))
+		}
+	}()
+	return nil
+}
+
+// Addr returns the address that the server listens on (the configured one until it's started).
+func (s *Server) Addr() string {
+	if s.listener == nil {
+		return s.srv.Addr
+	}
+	return s.listener.Addr().String()
+}
+
+// Close shuts down the server, waiting for the ongoing requests.
+func (s *Server) Close() {
+	s.once.Do(func() {
+		close(s.closeC)
+	})
+	s.wg.Wait()
+}
+
+// handlePeers serves a page of the peers that match the filters of the request (see parsePeerQuery).
+func (s *Server) handlePeers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	query, err := parsePeerQuery(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), s.timeout)
+	defer cancel()
+	page, err := s.reader.QueryPeers(ctx, query)
+	if err != nil {
+		s.writeReadError(w, err, "peers")
+		return
+	}
+	writeJSON(w, page)
+}
+
+// handlePeer serves the details of the peer in the path (PeersEndpoint + "/{peer_id}").
+func (s *Server) handlePeer(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	pID, err := peer.Decode(strings.TrimPrefix(r.URL.Path, PeersEndpoint+"/"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid peer id")
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), s.timeout)
+	defer cancel()
+	details, err := s.reader.GetPeerDetails(ctx, pID)
+	if errors.Is(err, db.ErrPeerNotFound) {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		s.writeReadError(w, err, "peer details")
+		return
+	}
+	writeJSON(w, details)
+}
+
+// handleSummary serves the aggregate counts of the peers.
+func (s *Server) handleSummary(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), s.timeout)
+	defer cancel()
+	summary, err := s.reader.GetCrawlSummary(ctx)
+	if err != nil {
+		s.writeReadError(w, err, "summary")
+		return
+	}
+	writeJSON(w, summary)
+}
+
+// parsePeerQuery reads the filters (client, country, connected and deprecated) and the page
+// (limit, and either offset or cursor) of the peer list from the request.
+func parsePeerQuery(r *http.Request) (db.PeerQuery, error) {
+	params := r.URL.Query()
+	query := db.PeerQuery{
+		Client:  params.Get("client"),
+		Country: params.Get("country"),
+		Cursor:  params.Get("cursor"),
+	}
+	var err error
+	if query.Connected, err = parseBoolParam(params.Get("connected")); err != nil {
+		return db.PeerQuery{}, errors.Wrap(err, "invalid connected")
+	}
+	if query.Deprecated, err = parseBoolParam(params.Get("deprecated")); err != nil {
+		return db.PeerQuery{}, errors.Wrap(err, "invalid deprecated")
+	}
+	if v := params.Get("limit"); v != "" {
+		query.Limit, err = strconv.Atoi(v)
+		if err != nil || query.Limit <= 0 || query.Limit > db.MaxPeerQueryLimit {
+			return db.PeerQuery{}, errors.Errorf("invalid limit %s (1-%d)", v, db.MaxPeerQueryLimit)
+		}
+	}
+	if v := params.Get("offset"); v != "" {
+		query.Offset, err = strconv.Atoi(v)
+		if err != nil || query.Offset < 0 {
+			return db.PeerQuery{}, errors.New("invalid offset " + v)
+		}
+	}
+	if query.Cursor != "" {
+		if query.Offset > 0 {
+			return db.PeerQuery{}, errors.New("the pages are either given by offset or by cursor")
+		}
+		if _, err = db.ParsePeerCursor(query.Cursor); err != nil {
+			return db.PeerQuery{}, err
+		}
+	}
+	return query, nil
+}
+
+// parseBoolParam returns nil for an empty parameter (no filter).
+func parseBoolParam(v string) (*bool, error) {
+	if v == "" {
+		return nil, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+// writeReadError answers a failed read, as a timeout if the query ran out of time.
+func (s *Server) writeReadError(w http.ResponseWriter, err error, what string) {
+	if errors.Is(err, context.DeadlineExceeded) {
+		log.Warn(errors.Wrap(err, "timeout serving "+what))
+		writeError(w, http.StatusServiceUnavailable, "timeout reading "+what)
+		return
+	}
+	log.Error(errors.Wrap(err, "unable to serve "+what))
+	writeError(w, http.StatusInternalServerError, "unable to read "+what)
+}
+
+func writeError(w http.ResponseWriter, code int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	err := json.NewEncoder(w).Encode(ErrorResponse{Error: msg})
+	if err != nil {
+		log.Debug(errors.Wrap(err, "unable to write error response"))
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		log.Debug(errors.Wrap(err, "unable to write response"))
+	}
+}
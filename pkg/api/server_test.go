@@ -0,0 +1,311 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"net"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	gcrypto "github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/p2p/enr"
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/migalabs/armiarma/pkg/db"
+	"github.com/migalabs/armiarma/pkg/db/models"
+	"github.com/migalabs/armiarma/pkg/db/sqlite"
+	eth "github.com/migalabs/armiarma/pkg/networks/ethereum"
+	"github.com/migalabs/armiarma/pkg/utils"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/stretchr/testify/require"
+)
+
+// test peers: an identified and connected Ethereum peer with its ENR and Status, a deprecated one,
+// and one that was only discovered
+type testPeers struct {
+	eth, deprecated, discovered peer.ID
+}
+
+func newTestPeerID(t *testing.T) peer.ID {
+	_, pubKey, err := crypto.GenerateEd25519Key(rand.Reader)
+	require.NoError(t, err)
+	pID, err := peer.IDFromPublicKey(pubKey)
+	require.NoError(t, err)
+	return pID
+}
+
+// newTestReader stores the test peers into a SQLite DB, which is reopened to make sure that they are flushed.
+func newTestReader(t *testing.T) (*sqlite.DB, testPeers) {
+	path := filepath.Join(t.TempDir(), "crawl.db")
+	sqlDB, err := sqlite.NewDB(context.Background(), utils.EthereumNetwork, sqlite.Scheme+path)
+	require.NoError(t, err)
+
+	// the Ethereum peer is the one of the ENR
+	key, err := gcrypto.GenerateKey()
+	require.NoError(t, err)
+	var record enr.Record
+	record.Set(enr.IP(net.ParseIP("18.223.219.100")))
+	record.Set(enr.TCP(9000))
+	record.Set(enr.UDP(9000))
+	record.SetSeq(3)
+	require.NoError(t, enode.SignV4(&record, key))
+	node, err := enode.New(enode.ValidSchemes, &record)
+	require.NoError(t, err)
+	enrNode, err := eth.ParseEnr(node)
+	require.NoError(t, err)
+	ethPeer, err := enrNode.GetPeerID()
+	require.NoError(t, err)
+
+	peers := testPeers{
+		eth:        ethPeer,
+		deprecated: newTestPeerID(t),
+		discovered: newTestPeerID(t),
+	}
+
+	hInfo := models.NewHostInfo(peers.eth, utils.EthereumNetwork, models.WithIPAndPorts("18.223.219.100", 9000))
+	hInfo.IdentifyHost(models.NewPeerInfo(peers.eth, "Lighthouse/v4.1.0-693886b/x86_64-linux", "eth2/1.0.0", nil, 42*time.Millisecond))
+	hInfo.AddAtt("beacon-status", eth.BeaconStatusStamped{
+		Timestamp: time.Unix(1654084800, 0),
+		PeerID:    peers.eth,
+		Status: common.Status{
+			FinalizedEpoch: 1000,
+			HeadSlot:       32123,
+		},
+	})
+	hInfo.AddAtt(eth.EnrHostInfoAttribute, enrNode)
+	require.NoError(t, sqlDB.PersistHostInfo(hInfo))
+	require.NoError(t, sqlDB.PersistConnAttempt(models.NewConnAttempt(peers.eth, models.PossitiveAttempt, "None", false, false)))
+
+	hInfo = models.NewHostInfo(peers.deprecated, utils.EthereumNetwork, models.WithIPAndPorts("18.223.219.101", 9000))
+	hInfo.IdentifyHost(models.NewPeerInfo(peers.deprecated, "Prysm/v4.0.3/a1b2c3d", "eth2/1.0.0", nil, 80*time.Millisecond))
+	require.NoError(t, sqlDB.PersistHostInfo(hInfo))
+	require.NoError(t, sqlDB.PersistConnAttempt(models.NewConnAttempt(peers.deprecated, models.NegativeAttempt, "io_timeout", true, false)))
+
+	require.NoError(t, sqlDB.PersistHostInfo(models.NewHostInfo(peers.discovered, utils.EthereumNetwork, models.WithIPAndPorts("18.223.219.102", 9000))))
+
+	for ip, country := range map[string][2]string{
+		"18.223.219.100": {"Germany", "DE"},
+		"18.223.219.101": {"United States", "US"},
+	} {
+		ipInfo := models.IpInfo{ExpirationTime: time.Now().Add(time.Hour)}
+		ipInfo.IP = ip
+		ipInfo.Country = country[0]
+		ipInfo.CountryCode = country[1]
+		require.NoError(t, sqlDB.PersistIpInfo(ipInfo))
+	}
+	sqlDB.Close()
+
+	sqlDB, err = sqlite.NewDB(context.Background(), utils.EthereumNetwork, sqlite.Scheme+path)
+	require.NoError(t, err)
+	t.Cleanup(sqlDB.Close)
+	return sqlDB, peers
+}
+
+func startTestServer(ctx context.Context, t *testing.T, reader db.Reader, timeout time.Duration) *Server {
+	srv := NewServer(ctx, reader, "127.0.0.1:0", timeout)
+	require.NoError(t, srv.Start())
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// getJSON requests the path, decoding the JSON response into v, and returns the status code.
+func getJSON(t *testing.T, srv *Server, path string, v interface{}) int {
+	resp, err := http.Get("http://" + srv.Addr() + path)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(v))
+	return resp.StatusCode
+}
+
+func TestPeersEndpoint(t *testing.T) {
+	reader, peers := newTestReader(t)
+	srv := startTestServer(context.Background(), t, reader, DefaultRequestTimeout)
+
+	// the field names are part of the API
+	var raw struct {
+		Peers      []map[string]interface{} `json:"peers"`
+		NextCursor *string                  `json:"next_cursor"`
+	}
+	require.Equal(t, http.StatusOK, getJSON(t, srv, PeersEndpoint+"?client=lighthouse", &raw))
+	require.Len(t, raw.Peers, 1)
+	require.NotNil(t, raw.NextCursor)
+	fields := make([]string, 0)
+	for field := range raw.Peers[0] {
+		fields = append(fields, field)
+	}
+	require.ElementsMatch(t, []string{
+		"peer_id", "network", "ip", "country", "country_code", "client_name", "client_version", "user_agent",
+		"latency_ms", "deprecated", "attempted", "connected", "attempts", "successful_attempts", "last_error",
+		"first_activity", "last_activity",
+	}, fields)
+
+	var page db.PeerPage
+	require.Equal(t, http.StatusOK, getJSON(t, srv, PeersEndpoint+"?client=lighthouse", &page))
+	require.Len(t, page.Peers, 1)
+	require.Empty(t, page.NextCursor)
+	entry := page.Peers[0]
+	require.Equal(t, peers.eth.String(), entry.PeerID)
+	require.Equal(t, "DE", entry.CountryCode)
+	require.Equal(t, "Germany", entry.Country)
+	require.Equal(t, "v4.1.0", entry.ClientVersion)
+	require.Equal(t, int64(42), entry.LatencyMs)
+	require.True(t, entry.Connected)
+	require.True(t, entry.Attempted)
+	require.False(t, entry.Deprecated)
+
+	for query, expected := range map[string][]peer.ID{
+		"":                  {peers.eth, peers.deprecated, peers.discovered},
+		"?country=DE":       {peers.eth},
+		"?country=de":       {peers.eth},
+		"?country=Germany":  {peers.eth},
+		"?connected=true":   {peers.eth},
+		"?connected=false":  {peers.deprecated, peers.discovered},
+		"?deprecated=true":  {peers.deprecated},
+		"?deprecated=false": {peers.eth, peers.discovered},
+		"?client=teku":      {},
+	} {
+		require.Equal(t, http.StatusOK, getJSON(t, srv, PeersEndpoint+query, &page), query)
+		ids := make([]string, 0)
+		for _, entry := range page.Peers {
+			ids = append(ids, entry.PeerID)
+		}
+		expectedIDs := make([]string, 0)
+		for _, pID := range expected {
+			expectedIDs = append(expectedIDs, pID.String())
+		}
+		require.ElementsMatch(t, expectedIDs, ids, query)
+	}
+
+	// the connected peer comes first
+	require.Equal(t, http.StatusOK, getJSON(t, srv, PeersEndpoint+"?limit=1", &page))
+	require.Equal(t, peers.eth.String(), page.Peers[0].PeerID)
+
+	// the cursors go through all the peers once
+	seen := make(map[string]bool)
+	cursor := ""
+	for i := 0; i < 3; i++ {
+		require.Equal(t, http.StatusOK, getJSON(t, srv, PeersEndpoint+"?limit=1&cursor="+cursor, &page))
+		require.Len(t, page.Peers, 1)
+		seen[page.Peers[0].PeerID] = true
+		cursor = page.NextCursor
+	}
+	require.Len(t, seen, 3)
+	require.Empty(t, cursor)
+
+	// and so do the offsets
+	require.Equal(t, http.StatusOK, getJSON(t, srv, PeersEndpoint+"?limit=2&offset=2", &page))
+	require.Len(t, page.Peers, 1)
+	require.Empty(t, page.NextCursor)
+	require.Equal(t, http.StatusOK, getJSON(t, srv, PeersEndpoint+"?offset=3", &page))
+	require.Empty(t, page.Peers)
+
+	for _, query := range []string{
+		"?connected=maybe",
+		"?deprecated=1x",
+		"?limit=0",
+		"?limit=1001",
+		"?offset=-1",
+		"?cursor=not-a-cursor",
+		"?cursor=12." + peers.eth.String() + "&offset=1",
+	} {
+		var errResp ErrorResponse
+		require.Equal(t, http.StatusBadRequest, getJSON(t, srv, PeersEndpoint+query, &errResp), query)
+		require.NotEmpty(t, errResp.Error)
+	}
+}
+
+func TestPeerEndpoint(t *testing.T) {
+	reader, peers := newTestReader(t)
+	srv := startTestServer(context.Background(), t, reader, DefaultRequestTimeout)
+
+	var raw map[string]interface{}
+	require.Equal(t, http.StatusOK, getJSON(t, srv, PeersEndpoint+"/"+peers.eth.String(), &raw))
+	require.Contains(t, raw, "peer")
+	require.Contains(t, raw, "enr")
+	require.Contains(t, raw, "status")
+
+	var details db.PeerDetails
+	require.Equal(t, http.StatusOK, getJSON(t, srv, PeersEndpoint+"/"+peers.eth.String(), &details))
+	require.Equal(t, peers.eth.String(), details.Peer.PeerID)
+	require.NotNil(t, details.Enr)
+	require.Equal(t, uint64(3), details.Enr.Seq)
+	require.Equal(t, "18.223.219.100", details.Enr.IP)
+	require.NotNil(t, details.Status)
+	require.Equal(t, uint64(32123), details.Status.HeadSlot)
+	require.Equal(t, uint64(1000), details.Status.FinalizedEpoch)
+
+	// the sections without data are null
+	raw = nil
+	require.Equal(t, http.StatusOK, getJSON(t, srv, PeersEndpoint+"/"+peers.discovered.String(), &raw))
+	require.Nil(t, raw["enr"])
+	require.Nil(t, raw["status"])
+
+	var errResp ErrorResponse
+	require.Equal(t, http.StatusNotFound, getJSON(t, srv, PeersEndpoint+"/"+newTestPeerID(t).String(), &errResp))
+	require.Equal(t, db.ErrPeerNotFound.Error(), errResp.Error)
+	require.Equal(t, http.StatusBadRequest, getJSON(t, srv, PeersEndpoint+"/not-a-peer-id", &errResp))
+	require.Equal(t, http.StatusBadRequest, getJSON(t, srv, PeersEndpoint+"/", &errResp))
+}
+
+func TestSummaryEndpoint(t *testing.T) {
+	reader, _ := newTestReader(t)
+	srv := startTestServer(context.Background(), t, reader, DefaultRequestTimeout)
+
+	var summary db.CrawlSummary
+	require.Equal(t, http.StatusOK, getJSON(t, srv, SummaryEndpoint, &summary))
+	require.Equal(t, db.CrawlSummary{
+		Peers:      3,
+		Deprecated: 1,
+		Attempted:  2,
+		Connected:  1,
+		Identified: 2,
+		// the deprecated peer isn't counted
+		Clients:   map[string]int64{"lighthouse": 1},
+		Countries: map[string]int64{"DE": 1},
+	}, summary)
+}
+
+// slowReader blocks every query until its context is done.
+type slowReader struct{}
+
+func (slowReader) QueryPeers(ctx context.Context, query db.PeerQuery) (db.PeerPage, error) {
+	<-ctx.Done()
+	return db.PeerPage{}, ctx.Err()
+}
+
+func (slowReader) GetPeerDetails(ctx context.Context, pID peer.ID) (*db.PeerDetails, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (slowReader) GetCrawlSummary(ctx context.Context) (db.CrawlSummary, error) {
+	<-ctx.Done()
+	return db.CrawlSummary{}, ctx.Err()
+}
+
+func TestServerTimeoutAndShutdown(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	srv := startTestServer(ctx, t, slowReader{}, 100*time.Millisecond)
+
+	// the slow queries are given up
+	start := time.Now()
+	var errResp ErrorResponse
+	require.Equal(t, http.StatusServiceUnavailable, getJSON(t, srv, SummaryEndpoint, &errResp))
+	require.Less(t, time.Since(start), DefaultRequestTimeout)
+
+	// the server stops with the root context
+	cancel()
+	require.Eventually(t, func() bool {
+		resp, err := http.Get("http://" + srv.Addr() + SummaryEndpoint)
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err != nil
+	}, time.Second, 10*time.Millisecond)
+}
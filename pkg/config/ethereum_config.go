@@ -2,11 +2,15 @@ package config
 
 import (
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/migalabs/armiarma/pkg/metrics"
 	eth "github.com/migalabs/armiarma/pkg/networks/ethereum"
 	rendp "github.com/migalabs/armiarma/pkg/networks/ethereum/remoteendpoint"
 	"github.com/migalabs/armiarma/pkg/utils"
 	"github.com/pkg/errors"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
 
 	log "github.com/sirupsen/logrus"
 	cli "github.com/urfave/cli/v2"
@@ -31,16 +35,80 @@ type EthereumCrawlerConfig struct {
 	MetricsIP                 string   `json:"metrics-ip"`
 	MetricsPort               int      `json:"metrics-port"`
 	UserAgent                 string   `json:"user-agent"`
+	MaxUserAgentLength        int      `json:"max-user-agent-length"`
+	MetadataWorkers           int      `json:"metadata-workers"`
+	MetadataQueueSize         int      `json:"metadata-queue-size"`
+	MetadataTimeout           string   `json:"metadata-timeout"`
+	ReqBackoffBase            string   `json:"req-backoff-base"`
+	ReqBackoffMax             string   `json:"req-backoff-max"`
+	DialDelays                []string `json:"dial-delays"`
+	DialDelayMax              string   `json:"dial-delay-max"`
+	Dialback                  bool     `json:"dialback"`
+	DialbackMaxAttempts       int      `json:"dialback-max-attempts"`
+	DialbackInterval          string   `json:"dialback-interval"`
+	DialbackTimeout           string   `json:"dialback-timeout"`
+	SeenMsgsWindow            string   `json:"seen-msgs-window"`
+	SeenMsgsCapacity          int      `json:"seen-msgs-capacity"`
 	EthCLRemoteEndpoint       string   `json:"remote-cl-endpoint"`
 	PsqlEndpoint              string   `json:"psql-endpoint"`
+	PsqlSchema                string   `json:"psql-schema"`
+	IgnoreAddrPorts           bool     `json:"ignore-addr-ports"`
+	PsqlIntegrityCheck        bool     `json:"psql-integrity-check"`
+	PsqlResumePeers           bool     `json:"psql-resume-peers"`
+	PsqlPersisters            int      `json:"psql-persisters"`
+	FileSinkMaxSize           int64    `json:"file-sink-max-size"`
+	FileSinkMaxAge            string   `json:"file-sink-max-age"`
+	FileSinkCompress          bool     `json:"file-sink-compress"`
+	IpTTL                     string   `json:"ip-ttl"`
+	IpProvider                string   `json:"ip-provider"`
+	GeoIpDB                   string   `json:"geoip-db"`
 	ActivePeersBackupInterval string   `json:ActivePeersBackupInterval`
 	ForkDigest                string   `json:"fork-digest"`
+	ForeignEnrs               string   `json:"foreign-enrs"`
+	ForkDigestAllowlist       []string `json:"fork-digest-allowlist"`
+	Eth2lessEnrs              string   `json:"eth2less-enrs"`
 	Bootnodes                 []string `json:"bootnodes"`
 	GossipTopics              []string `json:"gossip-topics"`
 	Subnets                   []int    `json:"subnets"`
 	PersistConnEvents	  bool 	   `json:"persist-connevents"`
 	PersistMsgs               bool     `json:"persist-msgs"`
 	ValPubkeys                []string `json:"val-pubkeys"`
+	SummaryInterval           string   `json:"summary-interval"`
+	SummaryFile               string   `json:"summary-file"`
+	SubnetCoverageThreshold   int      `json:"subnet-coverage-threshold"`
+	CheckpointFile            string   `json:"checkpoint-file"`
+	CheckpointInterval        string   `json:"checkpoint-interval"`
+	MaxOpenSession            string   `json:"max-open-session"`
+	CsvExportFile             string   `json:"csv-export"`
+	ExportMaxSize             int64    `json:"export-max-size"`
+	ExportMaxAge              string   `json:"export-max-age"`
+	ExportCompress            bool     `json:"export-compress"`
+	ExportInterval            string   `json:"export-interval"`
+	ExportKeep                int      `json:"export-keep"`
+	ExportCountries           bool     `json:"export-countries"`
+	ExportSummary             bool     `json:"export-summary"`
+	JsonExportFile            string   `json:"json-export"`
+	JsonExportFormat          string   `json:"json-export-format"`
+	PeerEvictionInterval      string   `json:"peer-eviction-interval"`
+	PeerEvictionWindow        string   `json:"peer-eviction-window"`
+	PeerDeprecationInterval   string   `json:"peer-deprecation-interval"`
+	PeerDeprecationWindow     string   `json:"peer-deprecation-window"`
+	PeerDeprecationFailures   int      `json:"peer-deprecation-failures"`
+	ApiAddr                   string   `json:"api-addr"`
+	ApiTimeout                string   `json:"api-timeout"`
+	ProviderRefreshInterval   string   `json:"provider-refresh-interval"`
+	TopicDeltasInterval       string   `json:"topic-deltas-interval"`
+	PeerSyncInterval          string   `json:"peer-sync-interval"`
+	InfluxEndpoint            string   `json:"influx-endpoint"`
+	InfluxOrg                 string   `json:"influx-org"`
+	InfluxBucket              string   `json:"influx-bucket"`
+	InfluxToken               string   `json:"influx-token"`
+	InfluxInterval            string   `json:"influx-interval"`
+	InfluxCrawlerID           string   `json:"influx-crawler-id"`
+	NextForkVersion           string   `json:"next-fork-version"`
+	NextForkEpoch             uint64   `json:"next-fork-epoch"`
+	NextForkAnnounced         string   `json:"next-fork-announced"`
+	NextForkClientVersions    []string `json:"next-fork-client-versions"`
 }
 
 // TODO: read from config-file
@@ -54,16 +122,75 @@ func NewEthereumCrawlerConfig() *EthereumCrawlerConfig {
 		MetricsIP:                 DefaultMetricsIP,
 		MetricsPort:               DefaultMetricsPort,
 		UserAgent:                 DefaultUserAgent,
+		MaxUserAgentLength:        DefaultMaxUserAgentLength,
+		MetadataWorkers:           DefaultMetadataWorkers,
+		MetadataQueueSize:         DefaultMetadataQueueSize,
+		MetadataTimeout:           DefaultMetadataTimeout,
+		ReqBackoffBase:            DefaultReqBackoffBase,
+		ReqBackoffMax:             DefaultReqBackoffMax,
+		DialDelays:                []string{},
+		DialDelayMax:              DefaultDialDelayMax,
+		Dialback:                  DefaultDialback,
+		DialbackMaxAttempts:       DefaultDialbackMaxAttempts,
+		DialbackInterval:          DefaultDialbackInterval,
+		DialbackTimeout:           DefaultDialbackTimeout,
+		SeenMsgsWindow:            DefaultSeenMsgsWindow,
+		SeenMsgsCapacity:          DefaultSeenMsgsCapacity,
 		EthCLRemoteEndpoint:       DefaultCLRemoteEndpoint,
 		PsqlEndpoint:              DefaultPSQLEndpoint,
+		PsqlSchema:                DefaultPSQLSchema,
+		IgnoreAddrPorts:           DefaultIgnoreAddrPorts,
+		PsqlIntegrityCheck:        DefaultPsqlIntegrityCheck,
+		PsqlResumePeers:           DefaultPsqlResumePeers,
+		PsqlPersisters:            DefaultPsqlPersisters,
+		FileSinkMaxSize:           DefaultFileSinkMaxSize,
+		FileSinkMaxAge:            DefaultFileSinkMaxAge,
+		FileSinkCompress:          DefaultFileSinkCompress,
+		IpTTL:                     DefaultIpTTL,
+		IpProvider:                DefaultIpProvider,
+		GeoIpDB:                   DefaultGeoIpDB,
 		ActivePeersBackupInterval: DefaultActivePeersBackupInterval,
 		ForkDigest:                eth.DefaultForkDigest,
+		ForeignEnrs:               DefaultForeignEnrs,
+		Eth2lessEnrs:              DefaultEth2lessEnrs,
 		Bootnodes:                 DefaultEthereumBootnodes,
 		Subnets:                   DefaultSubnets,
 		GossipTopics:              DefaultEthereumGossipTopics,
 		PersistConnEvents: 	   DefaultPersistConnEvents,
 		PersistMsgs:               false,
 		ValPubkeys:                DefaultValPubkeys,
+		SummaryInterval:           DefaultSummaryInterval,
+		SummaryFile:               DefaultSummaryFile,
+		SubnetCoverageThreshold:   DefaultSubnetCoverageThreshold,
+		CheckpointFile:            DefaultCheckpointFile,
+		CheckpointInterval:        DefaultCheckpointInterval,
+		MaxOpenSession:            DefaultMaxOpenSession,
+		CsvExportFile:             DefaultCsvExportFile,
+		ExportMaxSize:             DefaultExportMaxSize,
+		ExportMaxAge:              DefaultExportMaxAge,
+		ExportCompress:            DefaultExportCompress,
+		ExportInterval:            DefaultExportInterval,
+		ExportKeep:                DefaultExportKeep,
+		ExportCountries:           DefaultExportCountries,
+		ExportSummary:             DefaultExportSummary,
+		JsonExportFile:            DefaultJsonExportFile,
+		JsonExportFormat:          DefaultJsonExportFormat,
+		PeerEvictionInterval:      DefaultPeerEvictionInterval,
+		PeerEvictionWindow:        DefaultPeerEvictionWindow,
+		PeerDeprecationInterval:   DefaultPeerDeprecationInterval,
+		PeerDeprecationWindow:     DefaultPeerDeprecationWindow,
+		PeerDeprecationFailures:   DefaultPeerDeprecationFailures,
+		ApiAddr:                   DefaultApiAddr,
+		ApiTimeout:                DefaultApiTimeout,
+		ProviderRefreshInterval:   DefaultProviderRefreshInterval,
+		TopicDeltasInterval:       DefaultTopicDeltasInterval,
+		PeerSyncInterval:          DefaultPeerSyncInterval,
+		InfluxEndpoint:            DefaultInfluxEndpoint,
+		InfluxInterval:            DefaultInfluxInterval,
+		NextForkVersion:           DefaultNextForkVersion,
+		NextForkEpoch:             DefaultNextForkEpoch,
+		NextForkAnnounced:         DefaultNextForkAnnounced,
+		NextForkClientVersions:    []string{},
 	}
 }
 
@@ -104,6 +231,58 @@ func (c *EthereumCrawlerConfig) Apply(ctx *cli.Context) {
 	if ctx.IsSet("user-agent") {
 		c.UserAgent = ctx.String("user-agent")
 	}
+	if ctx.IsSet("max-user-agent-length") {
+		c.MaxUserAgentLength = ctx.Int("max-user-agent-length")
+	}
+
+	// metadata fetching of the new connections
+	if ctx.IsSet("metadata-workers") {
+		c.MetadataWorkers = ctx.Int("metadata-workers")
+	}
+	if ctx.IsSet("metadata-queue-size") {
+		c.MetadataQueueSize = ctx.Int("metadata-queue-size")
+	}
+	if ctx.IsSet("metadata-timeout") {
+		c.MetadataTimeout = ctx.String("metadata-timeout")
+	}
+
+	// backoff of the Status and MetaData requests
+	if ctx.IsSet("req-backoff-base") {
+		c.ReqBackoffBase = ctx.String("req-backoff-base")
+	}
+	if ctx.IsSet("req-backoff-max") {
+		c.ReqBackoffMax = ctx.String("req-backoff-max")
+	}
+
+	// backoff of the connection attempts
+	if ctx.IsSet("dial-delay") {
+		c.DialDelays = ctx.StringSlice("dial-delay")
+	}
+	if ctx.IsSet("dial-delay-max") {
+		c.DialDelayMax = ctx.String("dial-delay-max")
+	}
+
+	// dial-backs to the inbound peers
+	if ctx.IsSet("dialback") {
+		c.Dialback = ctx.Bool("dialback")
+	}
+	if ctx.IsSet("dialback-max-attempts") {
+		c.DialbackMaxAttempts = ctx.Int("dialback-max-attempts")
+	}
+	if ctx.IsSet("dialback-interval") {
+		c.DialbackInterval = ctx.String("dialback-interval")
+	}
+	if ctx.IsSet("dialback-timeout") {
+		c.DialbackTimeout = ctx.String("dialback-timeout")
+	}
+
+	// message IDs remembered to tell the first deliveries from the duplicates
+	if ctx.IsSet("seen-msgs-window") {
+		c.SeenMsgsWindow = ctx.String("seen-msgs-window")
+	}
+	if ctx.IsSet("seen-msgs-capacity") {
+		c.SeenMsgsCapacity = ctx.Int("seen-msgs-capacity")
+	}
 
 	// fork digest
 	if ctx.IsSet("fork-digest") {
@@ -130,10 +309,41 @@ func (c *EthereumCrawlerConfig) Apply(ctx *cli.Context) {
 		c.ForkDigest = forkD.String()
 	}
 
+	// what to do with the ENRs of other networks
+	if ctx.IsSet("foreign-enrs") {
+		policy, err := eth.ParseForeignNetworkPolicy(ctx.String("foreign-enrs"))
+		if err != nil {
+			log.Panic(errors.Wrap(err, "invalid foreign-enrs policy"))
+		}
+		c.ForeignEnrs = string(policy)
+	}
+	// fork digests of the crawled network (all the known ones of the fork-digest network if none is given)
+	if ctx.IsSet("fork-digest-allowlist") {
+		c.ForkDigestAllowlist = make([]string, 0)
+		for _, digest := range ctx.StringSlice("fork-digest-allowlist") {
+			forkDigest, ok := eth.NormalizeForkDigest(digest)
+			if !ok {
+				log.Panic("invalid fork-digest-allowlist fork digest " + digest)
+			}
+			c.ForkDigestAllowlist = append(c.ForkDigestAllowlist, forkDigest)
+		}
+	}
+	// what to do with the ENRs without eth2 entry
+	if ctx.IsSet("eth2less-enrs") {
+		policy, err := eth.ParseEth2lessPolicy(ctx.String("eth2less-enrs"))
+		if err != nil {
+			log.Panic(errors.Wrap(err, "invalid eth2less-enrs policy"))
+		}
+		c.Eth2lessEnrs = string(policy)
+	}
+
 	// postgresql endpoint
 	if ctx.IsSet("psql-endpoint") {
 		c.PsqlEndpoint = ctx.String("psql-endpoint")
 	}
+	if ctx.IsSet("psql-schema") {
+		c.PsqlSchema = ctx.String("psql-schema")
+	}
 
 	// active peers' backup interval
 	if ctx.IsSet("peers-backup") {
@@ -175,6 +385,36 @@ func (c *EthereumCrawlerConfig) Apply(ctx *cli.Context) {
 	if ctx.IsSet("persist-connevents") {
 		c.PersistConnEvents = ctx.Bool("persist-connevents")
 	}
+	if ctx.IsSet("ignore-addr-ports") {
+		c.IgnoreAddrPorts = ctx.Bool("ignore-addr-ports")
+	}
+	if ctx.IsSet("psql-integrity-check") {
+		c.PsqlIntegrityCheck = ctx.Bool("psql-integrity-check")
+	}
+	if ctx.IsSet("psql-resume-peers") {
+		c.PsqlResumePeers = ctx.Bool("psql-resume-peers")
+	}
+	if ctx.IsSet("psql-persisters") {
+		c.PsqlPersisters = ctx.Int("psql-persisters")
+	}
+	if ctx.IsSet("file-sink-max-size") {
+		c.FileSinkMaxSize = ctx.Int64("file-sink-max-size")
+	}
+	if ctx.IsSet("file-sink-max-age") {
+		c.FileSinkMaxAge = ctx.String("file-sink-max-age")
+	}
+	if ctx.IsSet("file-sink-compress") {
+		c.FileSinkCompress = ctx.Bool("file-sink-compress")
+	}
+	if ctx.IsSet("ip-ttl") {
+		c.IpTTL = ctx.String("ip-ttl")
+	}
+	if ctx.IsSet("ip-provider") {
+		c.IpProvider = ctx.String("ip-provider")
+	}
+	if ctx.IsSet("geoip-db") {
+		c.GeoIpDB = ctx.String("geoip-db")
+	}
 
 	// check if we want to track the Msgs in the SQL database
 	if ctx.IsSet("persist-msgs") {
@@ -191,21 +431,292 @@ func (c *EthereumCrawlerConfig) Apply(ctx *cli.Context) {
 		c.ValPubkeys = append(c.ValPubkeys, valKeys...)
 	}
 
+	// periodic summary report
+	if ctx.IsSet("summary-interval") {
+		c.SummaryInterval = ctx.String("summary-interval")
+	}
+	if ctx.IsSet("summary-file") {
+		c.SummaryFile = ctx.String("summary-file")
+	}
+	if ctx.IsSet("subnet-coverage-threshold") {
+		c.SubnetCoverageThreshold = ctx.Int("subnet-coverage-threshold")
+	}
+
+	// checkpoints of the in-memory peer store
+	if ctx.IsSet("checkpoint-file") {
+		c.CheckpointFile = ctx.String("checkpoint-file")
+	}
+	if ctx.IsSet("checkpoint-interval") {
+		c.CheckpointInterval = ctx.String("checkpoint-interval")
+	}
+	if ctx.IsSet("max-open-session") {
+		c.MaxOpenSession = ctx.String("max-open-session")
+	}
+
+	// csv export of the peer store
+	if ctx.IsSet("csv-export") {
+		c.CsvExportFile = ctx.String("csv-export")
+	}
+
+	// json export of the peer store
+	if ctx.IsSet("json-export") {
+		c.JsonExportFile = ctx.String("json-export")
+	}
+	if ctx.IsSet("json-export-format") {
+		c.JsonExportFormat = ctx.String("json-export-format")
+	}
+
+	// rotation of the summary file and the csv export
+	if ctx.IsSet("export-max-size") {
+		c.ExportMaxSize = ctx.Int64("export-max-size")
+	}
+	if ctx.IsSet("export-max-age") {
+		c.ExportMaxAge = ctx.String("export-max-age")
+	}
+	if ctx.IsSet("export-compress") {
+		c.ExportCompress = ctx.Bool("export-compress")
+	}
+
+	// scheduled exports of the peer store
+	if ctx.IsSet("export-interval") {
+		c.ExportInterval = ctx.String("export-interval")
+	}
+	if ctx.IsSet("export-keep") {
+		c.ExportKeep = ctx.Int("export-keep")
+	}
+	if ctx.IsSet("export-countries") {
+		c.ExportCountries = ctx.Bool("export-countries")
+	}
+	if ctx.IsSet("export-summary") {
+		c.ExportSummary = ctx.Bool("export-summary")
+	}
+
+	// eviction of the dead peers from the in-memory peer store
+	if ctx.IsSet("peer-eviction-interval") {
+		c.PeerEvictionInterval = ctx.String("peer-eviction-interval")
+	}
+	if ctx.IsSet("peer-eviction-window") {
+		c.PeerEvictionWindow = ctx.String("peer-eviction-window")
+	}
+
+	// deprecation of the stale peers
+	if ctx.IsSet("peer-deprecation-interval") {
+		c.PeerDeprecationInterval = ctx.String("peer-deprecation-interval")
+	}
+	if ctx.IsSet("peer-deprecation-window") {
+		c.PeerDeprecationWindow = ctx.String("peer-deprecation-window")
+	}
+	if ctx.IsSet("peer-deprecation-failures") {
+		c.PeerDeprecationFailures = ctx.Int("peer-deprecation-failures")
+	}
+
+	// HTTP API of the crawl results in the DB
+	if ctx.IsSet("api-addr") {
+		c.ApiAddr = ctx.String("api-addr")
+	}
+	if ctx.IsSet("api-timeout") {
+		c.ApiTimeout = ctx.String("api-timeout")
+	}
+
+	// refresh of the published ranges of the cloud providers
+	if ctx.IsSet("provider-refresh-interval") {
+		c.ProviderRefreshInterval = ctx.String("provider-refresh-interval")
+	}
+
+	// periodic persistence of the message metrics
+	if ctx.IsSet("topic-deltas-interval") {
+		c.TopicDeltasInterval = ctx.String("topic-deltas-interval")
+	}
+	// periodic persistence of the peers that changed in the store
+	if ctx.IsSet("peer-sync-interval") {
+		c.PeerSyncInterval = ctx.String("peer-sync-interval")
+	}
+
+	// export of the crawl time series to InfluxDB
+	if ctx.IsSet("influx-endpoint") {
+		c.InfluxEndpoint = ctx.String("influx-endpoint")
+	}
+	if ctx.IsSet("influx-org") {
+		c.InfluxOrg = ctx.String("influx-org")
+	}
+	if ctx.IsSet("influx-bucket") {
+		c.InfluxBucket = ctx.String("influx-bucket")
+	}
+	if ctx.IsSet("influx-token") {
+		c.InfluxToken = ctx.String("influx-token")
+	}
+	if ctx.IsSet("influx-interval") {
+		c.InfluxInterval = ctx.String("influx-interval")
+	}
+	if ctx.IsSet("influx-crawler-id") {
+		c.InfluxCrawlerID = ctx.String("influx-crawler-id")
+	}
+
+	// readiness of the peers for the next scheduled fork
+	if ctx.IsSet("next-fork-version") {
+		c.NextForkVersion = ctx.String("next-fork-version")
+	}
+	if ctx.IsSet("next-fork-epoch") {
+		c.NextForkEpoch = ctx.Uint64("next-fork-epoch")
+	}
+	if ctx.IsSet("next-fork-announced") {
+		c.NextForkAnnounced = ctx.String("next-fork-announced")
+	}
+	if ctx.IsSet("next-fork-client-version") {
+		c.NextForkClientVersions = ctx.StringSlice("next-fork-client-version")
+	}
+
 	log.WithFields(log.Fields{
 		"log-level":       c.LogLevel,
 		"priv-key":        c.PrivateKey,
 		"ip":              c.IP,
 		"port":            c.Port,
 		"user-agent":      c.UserAgent,
+		"max-user-agent-length": c.MaxUserAgentLength,
+		"metadata-workers": c.MetadataWorkers,
+		"metadata-queue-size": c.MetadataQueueSize,
+		"metadata-timeout": c.MetadataTimeout,
+		"req-backoff-base": c.ReqBackoffBase,
+		"req-backoff-max": c.ReqBackoffMax,
+		"dial-delays":     c.DialDelays,
+		"dial-delay-max":  c.DialDelayMax,
+		"dialback":        c.Dialback,
+		"dialback-max-attempts": c.DialbackMaxAttempts,
+		"dialback-interval": c.DialbackInterval,
+		"dialback-timeout": c.DialbackTimeout,
+		"seen-msgs-window": c.SeenMsgsWindow,
+		"seen-msgs-capacity": c.SeenMsgsCapacity,
 		"psql":            c.PsqlEndpoint,
+		"psql-schema":     c.PsqlSchema,
 		"backup-interval": c.ActivePeersBackupInterval,
 		"fork-digest":     c.ForkDigest,
+		"foreign-enrs":    c.ForeignEnrs,
+		"fork-digest-allowlist": c.NetworkAllowlist(),
+		"eth2less-enrs":   c.Eth2lessEnrs,
 		"cl-endpoint":     c.EthCLRemoteEndpoint,
 		"bootnodes":       c.Bootnodes,
 		"gossip-topics":   c.GossipTopics,
 		"subnets":         c.Subnets,
 		"persist-connevents": c.PersistConnEvents,
+		"ignore-addr-ports": c.IgnoreAddrPorts,
+		"psql-integrity-check": c.PsqlIntegrityCheck,
+		"psql-resume-peers":    c.PsqlResumePeers,
+		"psql-persisters":      c.PsqlPersisters,
+		"file-sink-max-size":   c.FileSinkMaxSize,
+		"file-sink-max-age":    c.FileSinkMaxAge,
+		"file-sink-compress":   c.FileSinkCompress,
+		"ip-ttl":               c.IpTTL,
+		"ip-provider":          c.IpProvider,
+		"geoip-db":             c.GeoIpDB,
 		"persist-msgs":    c.PersistMsgs,
 		"val-pubkeys":     len(c.ValPubkeys),
+		"summary-interval": c.SummaryInterval,
+		"summary-file":    c.SummaryFile,
+		"subnet-coverage-threshold": c.SubnetCoverageThreshold,
+		"checkpoint-file": c.CheckpointFile,
+		"checkpoint-interval": c.CheckpointInterval,
+		"max-open-session": c.MaxOpenSession,
+		"csv-export":      c.CsvExportFile,
+		"export-max-size": c.ExportMaxSize,
+		"export-max-age":  c.ExportMaxAge,
+		"export-compress": c.ExportCompress,
+		"export-interval": c.ExportInterval,
+		"export-keep":     c.ExportKeep,
+		"export-countries": c.ExportCountries,
+		"export-summary":   c.ExportSummary,
+		"json-export":      c.JsonExportFile,
+		"json-export-format": c.JsonExportFormat,
+		"peer-eviction-interval": c.PeerEvictionInterval,
+		"peer-eviction-window":   c.PeerEvictionWindow,
+		"peer-deprecation-interval": c.PeerDeprecationInterval,
+		"peer-deprecation-window":   c.PeerDeprecationWindow,
+		"peer-deprecation-failures": c.PeerDeprecationFailures,
+		"api-addr":                  c.ApiAddr,
+		"api-timeout":               c.ApiTimeout,
+		"provider-refresh-interval": c.ProviderRefreshInterval,
+		"topic-deltas-interval": c.TopicDeltasInterval,
+		"peer-sync-interval":    c.PeerSyncInterval,
+		"influx-endpoint":       c.InfluxEndpoint,
+		"influx-bucket":         c.InfluxBucket,
+		"influx-interval":       c.InfluxInterval,
+		"influx-crawler-id":     c.InfluxCrawlerID,
+		"next-fork-version":   c.NextForkVersion,
+		"next-fork-epoch":     c.NextForkEpoch,
 	}).Info("config for the Ethereum crawler")
 }
+
+// ScheduledFork returns the fork whose readiness is reported, or false if none was configured.
+func (c *EthereumCrawlerConfig) ScheduledFork() (eth.ScheduledFork, bool, error) {
+	if c.NextForkVersion == "" {
+		return eth.ScheduledFork{}, false, nil
+	}
+	fork := eth.ScheduledFork{
+		Version:           c.NextForkVersion,
+		Epoch:             common.Epoch(c.NextForkEpoch),
+		MinClientVersions: make(map[string]string, len(c.NextForkClientVersions)),
+	}
+	if c.NextForkAnnounced != "" {
+		announced, err := parseDate(c.NextForkAnnounced)
+		if err != nil {
+			return fork, false, errors.Wrap(err, "unable to parse next-fork-announced")
+		}
+		fork.Announced = announced
+	}
+	for _, clientVersion := range c.NextForkClientVersions {
+		items := strings.SplitN(clientVersion, "=", 2)
+		if len(items) != 2 || items[0] == "" || items[1] == "" {
+			return fork, false, errors.New("next-fork-client-version " + clientVersion + " isn't <client>=<version>")
+		}
+		fork.MinClientVersions[strings.ToLower(items[0])] = items[1]
+	}
+	return fork, true, nil
+}
+
+// DialBackoff returns the backoff of the connection attempts: metrics.DefaultDialBackoff with the
+// delays of the given dial-delays (<error>=<duration>, where the error can also be "success" or
+// "default") and the dial-delay-max.
+func (c *EthereumCrawlerConfig) DialBackoff() (metrics.DialBackoff, error) {
+	backoff := metrics.DefaultDialBackoff.Copy()
+	var err error
+	backoff.Max, err = time.ParseDuration(c.DialDelayMax)
+	if err != nil {
+		return backoff, errors.Wrap(err, "unable to parse dial-delay-max")
+	}
+	for _, dialDelay := range c.DialDelays {
+		items := strings.SplitN(dialDelay, "=", 2)
+		if len(items) != 2 || items[0] == "" {
+			return backoff, errors.New("dial-delay " + dialDelay + " isn't <error>=<duration>")
+		}
+		delay, err := time.ParseDuration(items[1])
+		if err != nil || delay < 0 {
+			return backoff, errors.New("dial-delay " + dialDelay + " doesn't have a valid duration")
+		}
+		switch items[0] {
+		case "success":
+			backoff.Success = delay
+		case "default":
+			backoff.Default = delay
+		default:
+			backoff.Errors[items[0]] = delay
+		}
+	}
+	return backoff, nil
+}
+
+// NetworkAllowlist returns the fork digests that the crawler accepts: the fork-digest-allowlist if it was given,
+// or all the known fork digests of the fork-digest network (any of them for the "all" fork digest).
+func (c *EthereumCrawlerConfig) NetworkAllowlist() []string {
+	if len(c.ForkDigestAllowlist) > 0 {
+		return c.ForkDigestAllowlist
+	}
+	return eth.NetworkAllowlist(c.ForkDigest)
+}
+
+// parseDate parses a RFC3339 time, or a 2006-01-02 date (UTC).
+func parseDate(date string) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339, date)
+	if err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", date)
+}
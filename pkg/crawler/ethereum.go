@@ -6,14 +6,22 @@ package crawler
 import (
 	"context"
 	"crypto/ecdsa"
+	"net/http"
+	"path/filepath"
 	"strings"
 	"time"
 
 	"github.com/libp2p/go-libp2p-core/crypto"
 	cli "github.com/urfave/cli/v2"
 
+	"github.com/migalabs/armiarma/pkg/api"
 	"github.com/migalabs/armiarma/pkg/config"
+	"github.com/migalabs/armiarma/pkg/db"
+	"github.com/migalabs/armiarma/pkg/db/filesink"
+	"github.com/migalabs/armiarma/pkg/db/memory"
+	"github.com/migalabs/armiarma/pkg/db/models"
 	psql "github.com/migalabs/armiarma/pkg/db/postgresql"
+	"github.com/migalabs/armiarma/pkg/db/sqlite"
 	"github.com/migalabs/armiarma/pkg/discovery"
 	"github.com/migalabs/armiarma/pkg/discovery/dv5"
 	"github.com/migalabs/armiarma/pkg/gossipsub"
@@ -23,6 +31,9 @@ import (
 	"github.com/migalabs/armiarma/pkg/peering"
 	"github.com/migalabs/armiarma/pkg/utils"
 	"github.com/migalabs/armiarma/pkg/utils/apis"
+	"github.com/migalabs/armiarma/pkg/utils/influx"
+	"github.com/migalabs/armiarma/pkg/utils/providers"
+	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -32,17 +43,46 @@ type EthereumCrawler struct {
 	cancel    context.CancelFunc
 	Host      *hosts.BasicLibp2pHost
 	EthNode   *eth.LocalEthereumNode
-	DB        *psql.DBClient
+	DB        db.Persister
 	Disc      *discovery.Discovery
+	Dv5       *dv5.Discovery5
+	// skips the ENRs and the peers of other networks
+	NetworkFilter *eth.NetworkFilter
 	Peering   peering.PeeringService
 	Gossipsub *gossipsub.GossipSub
 	IpLocator *apis.IpLocator
 	Metrics   *metrics.PrometheusMetrics
+	PeerStore    *metrics.PeerStore
+	Summary      *SummaryReporter
+	Checkpointer *metrics.Checkpointer
+	Exports      *metrics.ExportScheduler
+	// scheduled per-country exports (if enabled)
+	CountryExports *metrics.ExportScheduler
+	// scheduled network summary exports, one per file (if enabled)
+	SummaryExports []*metrics.ExportScheduler
+	Evictor      *metrics.Evictor
+	TopicDeltas  *metrics.TopicDeltaPersister
+	PeerSync     *metrics.PeerSyncer
+	Influx       *InfluxReporter
+	Providers    *providers.Refresher
+	// HTTP API of the crawl results in the DB (if enabled)
+	Api          *api.Server
+	CsvExport    string
+	// seed the peer store with the peers of the DB at start
+	ResumePeers bool
+	JsonExport   string
+	JsonFormat   metrics.JsonExportFormat
+	// rotation of the summary file and the csv export
+	ExportRotation utils.RotationPolicy
 }
 
 func NewEthereumCrawler(mainCtx *cli.Context, conf config.EthereumCrawlerConfig) (*EthereumCrawler, error) {
 	// Setup the configuration
 	log.SetLevel(utils.ParseLogLevel(conf.LogLevel))
+	// cap of the user agents of the peers (a non-positive one keeps the default)
+	if conf.MaxUserAgentLength > 0 {
+		utils.MaxUserAgentLength = conf.MaxUserAgentLength
+	}
 
 	ctx, cancel := context.WithCancel(mainCtx.Context)
 	var err error
@@ -90,21 +130,122 @@ func NewEthereumCrawler(mainCtx *cli.Context, conf config.EthereumCrawlerConfig)
 		cancel()
 		return nil, err
 	}
-	dbClient, err := psql.NewDBClient(
-			ctx,
-			ethNode.Network(), 
-			conf.PsqlEndpoint, 
-			backupInterval, 
-			psql.InitializeTables(true),
-			psql.WithConnectionEventsPersist(conf.PersistConnEvents),
-	)
+	// readiness of the peers for the next fork (if configured)
+	nextFork, reportFork, err := conf.ScheduledFork()
 	if err != nil {
 		cancel()
 		return nil, err
 	}
+	dbOpts := []psql.DBOption{
+		psql.InitializeTables(true),
+		psql.WithSchema(conf.PsqlSchema),
+		psql.WithConnectionEventsPersist(conf.PersistConnEvents),
+		psql.WithIgnoredAddrPorts(conf.IgnoreAddrPorts),
+		psql.WithIntegrityCheck(conf.PsqlIntegrityCheck),
+		psql.WithPersisters(conf.PsqlPersisters),
+	}
+	if reportFork {
+		dbOpts = append(dbOpts, psql.WithScheduledForks(nextFork))
+	}
+	// without a DB endpoint the crawl is kept in memory, without the stats that postgresql aggregates
+	var dbClient db.Persister
+	var psqlClient *psql.DBClient
+	switch {
+	case conf.PsqlEndpoint == "":
+		log.Warn("no psql-endpoint given, the crawl won't be persisted")
+		dbClient = memory.NewDB()
+	case sqlite.IsEndpoint(conf.PsqlEndpoint):
+		// a single file for the local runs, without the stats that postgresql aggregates either
+		dbClient, err = sqlite.NewDB(
+			ctx,
+			ethNode.Network(),
+			conf.PsqlEndpoint,
+			sqlite.WithConnectionEventsPersist(conf.PersistConnEvents),
+		)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+	case filesink.IsEndpoint(conf.PsqlEndpoint):
+		// rotating files for the deployments without a DB, to be deduplicated by their readers
+		var fileSinkMaxAge time.Duration
+		fileSinkMaxAge, err = time.ParseDuration(conf.FileSinkMaxAge)
+		if err != nil {
+			cancel()
+			return nil, errors.Wrap(err, "unable to parse the file-sink-max-age")
+		}
+		dbClient, err = filesink.NewDB(
+			ctx,
+			ethNode.Network(),
+			conf.PsqlEndpoint,
+			filesink.WithRotation(utils.RotationPolicy{
+				MaxSize:  conf.FileSinkMaxSize * 1024 * 1024,
+				MaxAge:   fileSinkMaxAge,
+				Compress: conf.FileSinkCompress,
+			}),
+			filesink.WithConnectionEventsPersist(conf.PersistConnEvents),
+		)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+	default:
+		psqlClient, err = psql.NewDBClient(
+			ctx,
+			ethNode.Network(),
+			conf.PsqlEndpoint,
+			backupInterval,
+			dbOpts...,
+		)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		dbClient = psqlClient
+	}
 
 	// create an ip-locator instance
-	ipLocator := apis.NewIpLocator(ctx, dbClient)
+	ipTTL, err := time.ParseDuration(conf.IpTTL)
+	if err != nil {
+		cancel()
+		return nil, errors.Wrap(err, "unable to parse the ip-ttl")
+	}
+	ipOpts := []apis.IpLocatorOption{apis.WithIpTTL(ipTTL)}
+	switch conf.IpProvider {
+	case models.LocationSourceIpApi:
+	case models.LocationSourceMaxMind:
+		ipOpts = append(ipOpts, apis.WithMaxMindDB(conf.GeoIpDB))
+	default:
+		cancel()
+		return nil, errors.New("unknown ip-provider " + conf.IpProvider + ", expected ip-api or maxmind")
+	}
+	ipLocator, err := apis.NewIpLocator(ctx, dbClient, ipOpts...)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	// in-memory summary of the peers that we interact with
+	peerStore := metrics.NewPeerStore()
+	// the exports report the crawled network (the fork digest itself if it isn't a known one)
+	if network, ok := eth.ForkDigestNetwork(conf.ForkDigest); ok {
+		peerStore.SetNetwork(network)
+	} else {
+		peerStore.SetNetwork(conf.ForkDigest)
+	}
+
+	// the peers identified before their IP got located are backfilled once it is
+	ipLocator.OnLocated(func(ipInfo models.IpInfo) {
+		peerStore.LocatePeers(ipInfo)
+	})
+
+	// notify and record the client versions that we didn't see before
+	cliVersions, err := newClientVersionTracker(ctx, dbClient)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	peerStore.SetClientVersionTracker(cliVersions)
 
 	// generate libp2pHostd
 	host, err := hosts.NewBasicLibp2pEth2Host(
@@ -115,19 +256,84 @@ func NewEthereumCrawler(mainCtx *cli.Context, conf config.EthereumCrawlerConfig)
 		conf.UserAgent,
 		ethNode, // ethereum local node
 		ipLocator,
+		metrics.NewBandwidthReporter(peerStore), // per-peer traffic
 	)
 	if err != nil {
 		cancel()
 		return nil, err
 	}
+	metadataTimeout, err := time.ParseDuration(conf.MetadataTimeout)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	host.SetMetadataPool(conf.MetadataWorkers, conf.MetadataQueueSize, metadataTimeout)
+	// the Status and MetaData requests that keep failing get backed off
+	var reqBackoff metrics.RequestBackoff
+	reqBackoff.Base, err = time.ParseDuration(conf.ReqBackoffBase)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	reqBackoff.Max, err = time.ParseDuration(conf.ReqBackoffMax)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	peerStore.SetRequestBackoff(reqBackoff)
+	host.SetRequestScheduler(peerStore)
+	// and so do the connection attempts to the peers that keep failing
+	dialBackoff, err := conf.DialBackoff()
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	peerStore.SetDialBackoff(dialBackoff)
+	// the gossip messages get classified as first seen or duplicates by their ID
+	seenMsgsWindow, err := time.ParseDuration(conf.SeenMsgsWindow)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	peerStore.SetSeenMessagesLimits(seenMsgsWindow, conf.SeenMsgsCapacity)
+	if conf.Dialback {
+		dialbackConf := hosts.DefaultDialbackConfig()
+		dialbackConf.MaxAttempts = conf.DialbackMaxAttempts
+		dialbackConf.Interval, err = time.ParseDuration(conf.DialbackInterval)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		dialbackConf.Timeout, err = time.ParseDuration(conf.DialbackTimeout)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		host.EnableDialback(dialbackConf, nil)
+	}
+
+	// skip the ENRs and the peers of other networks
+	foreignPolicy, err := eth.ParseForeignNetworkPolicy(conf.ForeignEnrs)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	eth2lessPolicy, err := eth.ParseEth2lessPolicy(conf.Eth2lessEnrs)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	networkFilter := eth.NewNetworkFilter(conf.NetworkAllowlist(), foreignPolicy, eth2lessPolicy)
+	host.SetNetworkFilter(networkFilter)
 
 	// create a new discovery5 service to discover peers in the Ethereum network
-	dv5, err := dv5.NewDiscovery5(
+	dv5Serv, err := dv5.NewDiscovery5(
 		ctx,
 		ethNode,
 		gethPrivKey,
 		dv5.ParseBootnodesFromStringSlice(conf.Bootnodes),
 		conf.ForkDigest,
+		networkFilter,
 		conf.Port)
 	if err != nil {
 		cancel()
@@ -135,13 +341,17 @@ func NewEthereumCrawler(mainCtx *cli.Context, conf config.EthereumCrawlerConfig)
 	}
 	disc := discovery.NewDiscovery(
 		ctx,
-		dv5,
+		dv5Serv,
 		dbClient,
 		ipLocator,
+		peerStore,
 	)
 
+	// measure the arrival delays of the messages relative to the slots of the network
+	peerStore.SetSlotClock(metrics.NewSlotClock(ethNode.GetNetworkGenesis(), eth.SecondsPerSlot))
+
 	// create a gossipsub routing
-	gs := gossipsub.NewGossipSub(ctx, host.Host(), dbClient)
+	gs := gossipsub.NewGossipSub(ctx, host.Host(), ethNode.Network(), dbClient, peerStore)
 
 	// generate a new subnets-handler
 	ethMsgHandler, err := eth.NewEthMessageHandler(ethNode.GetNetworkGenesis(), conf.ValPubkeys)
@@ -155,6 +365,8 @@ func NewEthereumCrawler(mainCtx *cli.Context, conf config.EthereumCrawlerConfig)
 		switch top {
 		case eth.BeaconBlockTopicBase:
 			msgHandler = ethMsgHandler.BeaconBlockMessageHandler
+		case eth.LightClientFinalityUpdateTopicBase, eth.LightClientOptimisticUpdateTopicBase:
+			msgHandler = ethMsgHandler.LightClientUpdateMessageHandler
 		default:
 			log.Error("untraceable gossipsub topic", top)
 			continue
@@ -173,11 +385,28 @@ func NewEthereumCrawler(mainCtx *cli.Context, conf config.EthereumCrawlerConfig)
 		ctx,
 		ethNode.Network(),
 		dbClient,
+		peerStore,
 	)
 	if err != nil {
 		cancel()
 		return nil, err
 	}
+	// periodically deprecate the stale peers (disabled with a 0 interval)
+	deprecationInterval, err := time.ParseDuration(conf.PeerDeprecationInterval)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	deprecationWindow, err := time.ParseDuration(conf.PeerDeprecationWindow)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	pStrategy.SetDeprecationPolicy(peering.DeprecationPolicy{
+		Interval:       deprecationInterval,
+		InactiveWindow: deprecationWindow,
+		MinFailures:    conf.PeerDeprecationFailures,
+	})
 	// Generate the PeeringService
 	peeringServ, err := peering.NewPeeringService(
 		ctx,
@@ -190,6 +419,199 @@ func NewEthereumCrawler(mainCtx *cli.Context, conf config.EthereumCrawlerConfig)
 		return nil, err
 	}
 
+	// generate the periodic summary reporter (disabled with a 0 interval)
+	var summary *SummaryReporter
+	summaryInterval, err := time.ParseDuration(conf.SummaryInterval)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	exportMaxAge, err := time.ParseDuration(conf.ExportMaxAge)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	exportRotation := utils.RotationPolicy{
+		MaxSize:  conf.ExportMaxSize * 1024 * 1024,
+		MaxAge:   exportMaxAge,
+		Compress: conf.ExportCompress,
+	}
+	if summaryInterval > 0 {
+		summary = NewSummaryReporter(ctx, summaryInterval, conf.SummaryFile, peerStore, dbClient)
+		summary.SetDiscoveryStats(dv5Serv)
+		if psqlClient != nil {
+			if reportFork {
+				summary.SetForkReadiness(psqlClient, nextFork.Version)
+			}
+			summary.SetTopPeersStats(psqlClient)
+			summary.SetTopicBreadthStats(psqlClient)
+			summary.SetDBWriteStats(psqlClient)
+		}
+		summary.SetRotation(exportRotation)
+		summary.SetSubnetCoverageThreshold(conf.SubnetCoverageThreshold)
+	}
+
+	// inactivity after which the restored and exported sessions without disconnection get closed
+	maxOpenSession, err := time.ParseDuration(conf.MaxOpenSession)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	metrics.MaxOpenSession = maxOpenSession
+
+	// generate the periodic checkpoints of the peer store (if a file was given)
+	var checkpointer *metrics.Checkpointer
+	if conf.CheckpointFile != "" {
+		checkpointInterval, err := time.ParseDuration(conf.CheckpointInterval)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		checkpointer = metrics.NewCheckpointer(ctx, peerStore, conf.CheckpointFile, checkpointInterval)
+	}
+
+	// format of the json export of the peer store
+	jsonFormat, err := metrics.ParseJsonExportFormat(conf.JsonExportFormat)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	// generate the scheduled exports of the peer store next to the csv export (disabled with a 0 interval)
+	var exports, countryExports *metrics.ExportScheduler
+	summaryExports := make([]*metrics.ExportScheduler, 0)
+	exportInterval, err := time.ParseDuration(conf.ExportInterval)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	if exportInterval > 0 && conf.CsvExportFile != "" {
+		exports = metrics.NewExportScheduler(
+			ctx,
+			peerStore.PeerCsvExporter(),
+			filepath.Dir(conf.CsvExportFile),
+			filepath.Base(conf.CsvExportFile),
+			exportInterval,
+			conf.ExportKeep,
+		)
+		if conf.ExportCountries {
+			countryExports = metrics.NewExportScheduler(
+				ctx,
+				peerStore.CountriesCsvExporter(),
+				filepath.Dir(conf.CsvExportFile),
+				metrics.CountriesFile,
+				exportInterval,
+				conf.ExportKeep,
+			)
+		}
+		if conf.ExportSummary {
+			for name, exporter := range peerStore.NetworkSummaryExporters() {
+				summaryExports = append(summaryExports, metrics.NewExportScheduler(
+					ctx,
+					exporter,
+					filepath.Dir(conf.CsvExportFile),
+					name,
+					exportInterval,
+					conf.ExportKeep,
+				))
+			}
+		}
+	}
+
+	// generate the eviction of the dead peers from the peer store (disabled with a 0 interval)
+	var evictor *metrics.Evictor
+	evictionInterval, err := time.ParseDuration(conf.PeerEvictionInterval)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	evictionWindow, err := time.ParseDuration(conf.PeerEvictionWindow)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	if evictionInterval > 0 {
+		evictor = metrics.NewEvictor(ctx, peerStore, metrics.EvictionPolicy{
+			Interval:       evictionInterval,
+			InactiveWindow: evictionWindow,
+			// the peers have to be in the checkpoint or in the export before leaving the store
+			RequireSync: conf.CheckpointFile != "" || conf.CsvExportFile != "",
+		})
+	}
+
+	// generate the periodic persistence of the message metrics (disabled with a 0 interval)
+	var topicDeltas *metrics.TopicDeltaPersister
+	topicDeltasInterval, err := time.ParseDuration(conf.TopicDeltasInterval)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	if topicDeltasInterval > 0 {
+		topicDeltas = metrics.NewTopicDeltaPersister(ctx, peerStore, dbClient, topicDeltasInterval)
+	}
+
+	// generate the periodic persistence of the peers that changed in the store (disabled with a 0 interval)
+	var peerSync *metrics.PeerSyncer
+	peerSyncInterval, err := time.ParseDuration(conf.PeerSyncInterval)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	if peerSyncInterval > 0 {
+		peerSync = metrics.NewPeerSyncer(ctx, peerStore, dbClient, peerSyncInterval)
+	}
+
+	// generate the export of the time series of the crawl to InfluxDB (disabled without endpoint)
+	var influxReporter *InfluxReporter
+	if conf.InfluxEndpoint != "" {
+		influxInterval, err := time.ParseDuration(conf.InfluxInterval)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		influxWriter, err := influx.NewWriter(conf.InfluxEndpoint, conf.InfluxOrg, conf.InfluxBucket, conf.InfluxToken)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		crawlerID := conf.InfluxCrawlerID
+		if crawlerID == "" {
+			crawlerID = host.Host().ID().String()
+		}
+		influxSink := influx.NewSink(influxWriter, map[string]string{
+			InfluxNetworkTag:   peerStore.Network(),
+			InfluxCrawlerIDTag: crawlerID,
+		}, influx.DefaultMaxPending)
+		influxReporter = NewInfluxReporter(ctx, influxInterval, influxSink, peerStore, dbClient)
+	}
+
+	// generate the refresh of the published ranges of the cloud providers (disabled with a 0 interval)
+	var providerRefresher *providers.Refresher
+	providerRefreshInterval, err := time.ParseDuration(conf.ProviderRefreshInterval)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	if providerRefreshInterval > 0 {
+		providerRefresher = providers.NewRefresher(ctx, providers.Default(), providers.DefaultSources(), providerRefreshInterval)
+	}
+
+	// generate the HTTP API of the crawl results (disabled without address)
+	var apiServer *api.Server
+	if conf.ApiAddr != "" {
+		apiTimeout, err := time.ParseDuration(conf.ApiTimeout)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		reader, ok := dbClient.(db.Reader)
+		if ok {
+			apiServer = api.NewServer(ctx, reader, conf.ApiAddr, apiTimeout)
+		} else {
+			log.Warn("the crawl API needs the psql or the sqlite DB, not serving it")
+		}
+	}
+
 	// generate the CrawlerBase
 	crawler := &EthereumCrawler{
 		ctx:       ctx,
@@ -198,10 +620,30 @@ func NewEthereumCrawler(mainCtx *cli.Context, conf config.EthereumCrawlerConfig)
 		DB:        dbClient,
 		EthNode:   ethNode,
 		Disc:      disc,
+		Dv5:       dv5Serv,
+		NetworkFilter: networkFilter,
 		Peering:   peeringServ,
 		Gossipsub: gs,
 		IpLocator: ipLocator,
 		Metrics:   promethMetrics,
+		PeerStore:    peerStore,
+		Summary:      summary,
+		Checkpointer: checkpointer,
+		Exports:      exports,
+		CountryExports: countryExports,
+		SummaryExports: summaryExports,
+		Evictor:      evictor,
+		TopicDeltas:  topicDeltas,
+		PeerSync:     peerSync,
+		Influx:       influxReporter,
+		Providers:    providerRefresher,
+		Api:          apiServer,
+		CsvExport:    conf.CsvExportFile,
+		ResumePeers:  conf.PsqlResumePeers,
+		JsonExport:   conf.JsonExportFile,
+		JsonFormat:   jsonFormat,
+
+		ExportRotation: exportRotation,
 	}
 
 	// Register the metrics for the crawler and submodules
@@ -223,6 +665,9 @@ func NewEthereumCrawler(mainCtx *cli.Context, conf config.EthereumCrawlerConfig)
 	ethNodeMetricsMod := ethNode.GetMetrics()
 	promethMetrics.AddMeticsModule(ethNodeMetricsMod)
 
+	ipLocatorMetricsMod := ipLocator.GetMetrics()
+	promethMetrics.AddMeticsModule(ipLocatorMetricsMod)
+
 	return crawler, nil
 }
 
@@ -232,19 +677,209 @@ func (c *EthereumCrawler) Run() {
 	c.EthNode.ServeBeaconPing(c.Host.Host())
 	c.EthNode.ServeBeaconStatus(c.Host.Host())
 	c.EthNode.ServeBeaconMetadata(c.Host.Host())
+	// the Goodbyes tell us why the peers disconnect from us
+	c.EthNode.OnGoodbye(c.goodbyeHandler)
+	c.EthNode.ServeBeaconGoodbye(c.Host.Host())
+
+	// restore the previous checkpoint before any event reaches the peer store
+	if c.Checkpointer != nil {
+		c.Checkpointer.Start()
+	}
+	if c.ResumePeers {
+		c.resumePeerStore()
+	}
 
 	// initialization secuence for the crawler
 	c.IpLocator.Run()
 	c.Host.Start()
 	c.Disc.Start()
 	c.Peering.Run()
+	// the peer records, the bootnode list and the store stats are served next to the prometheus metrics
+	if psqlClient := c.psqlDB(); psqlClient != nil {
+		http.Handle(psql.PeerRecordEndpoint, psqlClient.PeerRecordHandler())
+		http.Handle(psql.BootnodesEndpoint, psqlClient.BootnodesHandler())
+		http.Handle(psql.TopPeersEndpoint, psqlClient.TopPeersHandler())
+	}
+	http.Handle(metrics.TopicTotalsEndpoint, c.PeerStore.TopicTotalsHandler())
+	http.Handle(metrics.FunnelEndpoint, c.PeerStore.FunnelHandler())
+	http.Handle(metrics.SubnetCoverageEndpoint, c.PeerStore.SubnetCoverageHandler())
 	c.Metrics.Start()
+	if c.Summary != nil {
+		c.Summary.Start()
+	}
+	if c.Exports != nil {
+		c.Exports.Start()
+	}
+	if c.CountryExports != nil {
+		c.CountryExports.Start()
+	}
+	for _, summaryExport := range c.SummaryExports {
+		summaryExport.Start()
+	}
+	if c.Evictor != nil {
+		c.Evictor.Start()
+	}
+	if c.TopicDeltas != nil {
+		c.TopicDeltas.Start()
+	}
+	if c.PeerSync != nil {
+		c.PeerSync.Start()
+	}
+	if c.Influx != nil {
+		c.Influx.Start()
+	}
+	if c.Providers != nil {
+		c.Providers.Start()
+	}
+	if c.Api != nil {
+		if err := c.Api.Start(); err != nil {
+			log.Error(errors.Wrap(err, "unable to start the crawl API"))
+		}
+	}
+}
+
+// psqlDB returns the postgresql client, or nil if the crawl is kept in memory.
+func (c *EthereumCrawler) psqlDB() *psql.DBClient {
+	psqlClient, _ := c.DB.(*psql.DBClient)
+	return psqlClient
+}
+
+// resumePeerStore seeds the peer store with the non-deprecated peers of peer_info that it doesn't have
+// yet (the checkpoint prevails), streaming them from the DB. Without the psql DB there is nothing to resume.
+func (c *EthereumCrawler) resumePeerStore() {
+	psqlClient := c.psqlDB()
+	if psqlClient == nil {
+		log.Warn("unable to resume the peer store without the psql DB")
+		return
+	}
+	resumed := 0
+	total, err := psqlClient.ForEachHostInfo(c.ctx, func(hInfo *models.HostInfo) error {
+		if c.PeerStore.ResumePeer(hInfo) {
+			resumed++
+		}
+		return nil
+	})
+	if err != nil {
+		log.Error(errors.Wrap(err, "unable to resume the peer store from the DB"))
+	}
+	log.Infof("resumed %d peers from the DB (%d read)", resumed, total)
+}
+
+// goodbyeHandler tracks the Goodbye received from a peer in the peer store and persists its reason.
+func (c *EthereumCrawler) goodbyeHandler(goodbye models.Goodbye) {
+	log.Debugf("goodbye from peer %s: %s", goodbye.PeerID.String(), goodbye.Reason())
+	c.PeerStore.GetOrCreatePeer(goodbye.PeerID).GoodbyeEvent(goodbye)
+	hInfo := models.NewHostInfo(goodbye.PeerID, c.EthNode.Network())
+	hInfo.AddAtt(models.GoodbyeAttribute, goodbye)
+	err := c.DB.PersistHostInfo(hInfo)
+	if err != nil {
+		log.Error(errors.Wrap(err, "unable to persist goodbye"))
+	}
 }
 
 func (c *EthereumCrawler) Close() {
+	if c.Api != nil {
+		c.Api.Close()
+	}
+	if c.Providers != nil {
+		c.Providers.Close()
+	}
+	if c.Evictor != nil {
+		c.Evictor.Close()
+	}
+	if c.Summary != nil {
+		// reports the last status before shutting down
+		c.Summary.Close()
+	}
+	if c.Checkpointer != nil {
+		c.Checkpointer.Close()
+	}
+	if c.Exports != nil {
+		c.Exports.Close()
+	}
+	if c.CountryExports != nil {
+		c.CountryExports.Close()
+	}
+	for _, summaryExport := range c.SummaryExports {
+		summaryExport.Close()
+	}
+	if c.TopicDeltas != nil {
+		c.TopicDeltas.Close()
+	}
+	if c.PeerSync != nil {
+		c.PeerSync.Close()
+	}
+	if c.Influx != nil {
+		// exports the last points before shutting down
+		c.Influx.Close()
+	}
+	if c.JsonExport != "" {
+		err := c.PeerStore.ExportJsonFile(c.JsonExport, c.JsonFormat)
+		if err != nil {
+			log.Error(errors.Wrap(err, "unable to export peer store into "+c.JsonExport))
+		}
+	}
+	if c.CsvExport != "" {
+		err := c.PeerStore.ExportCsvFile(c.CsvExport, c.ExportRotation)
+		if err != nil {
+			log.Error(errors.Wrap(err, "unable to export peer store into "+c.CsvExport))
+		}
+		// the sessions histogram is exported next to the peer store
+		histFile := filepath.Join(filepath.Dir(c.CsvExport), metrics.SessionsHistogramFile)
+		err = c.PeerStore.ExportSessionHistogramFile(histFile, metrics.DefaultSessionBuckets)
+		if err != nil {
+			log.Error(errors.Wrap(err, "unable to export sessions histogram into "+histFile))
+		}
+		leadersFile := filepath.Join(filepath.Dir(c.CsvExport), metrics.FirstDeliveryLeadersFile)
+		err = c.PeerStore.ExportFirstDeliveryLeadersFile(leadersFile)
+		if err != nil {
+			log.Error(errors.Wrap(err, "unable to export first-delivery leaderboard into "+leadersFile))
+		}
+		topicsFile := filepath.Join(filepath.Dir(c.CsvExport), metrics.TopicMessagesFile)
+		err = c.PeerStore.ExportTopicMessagesFile(topicsFile)
+		if err != nil {
+			log.Error(errors.Wrap(err, "unable to export topic messages into "+topicsFile))
+		}
+		hourlyFile := filepath.Join(filepath.Dir(c.CsvExport), metrics.HourlyMessagesFile)
+		err = c.PeerStore.ExportHourlyCountsFile(hourlyFile)
+		if err != nil {
+			log.Error(errors.Wrap(err, "unable to export hourly messages into "+hourlyFile))
+		}
+		countriesFile := filepath.Join(filepath.Dir(c.CsvExport), metrics.CountriesFile)
+		err = c.PeerStore.ExportCountriesFile(countriesFile)
+		if err != nil {
+			log.Error(errors.Wrap(err, "unable to export countries into "+countriesFile))
+		}
+		err = c.PeerStore.ExportNetworkSummaryFiles(filepath.Dir(c.CsvExport))
+		if err != nil {
+			log.Error(errors.Wrap(err, "unable to export network summary into "+filepath.Dir(c.CsvExport)))
+		}
+	}
 	c.Disc.Stop()
-	c.Host.Host().Close()
+	c.Host.Close()
 	c.DB.Close()
 	c.Metrics.Close()
 	c.cancel()
+	c.IpLocator.Close()
+}
+
+// newClientVersionTracker returns the tracker of the client versions seeded with the ones already
+// in the DB, which logs and records in the DB the ones seen for the first time.
+func newClientVersionTracker(ctx context.Context, dbClient db.Persister) (*metrics.ClientVersionTracker, error) {
+	tracker := metrics.NewClientVersionTracker(ctx)
+	knownVersions, err := dbClient.GetClientVersions()
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to seed the known client versions")
+	}
+	for _, cliVersion := range knownVersions {
+		tracker.Seed(cliVersion.Name, cliVersion.Version)
+	}
+	tracker.OnNewClientVersion(func(name, version, firstPeer string) {
+		log.Infof("new client version %s %s seen on peer %s", name, version, firstPeer)
+		err := dbClient.PersistToDB(models.NewClientVersion(name, version, firstPeer))
+		if err != nil {
+			log.Error(errors.Wrap(err, "unable to persist client version"))
+		}
+	})
+	return tracker, nil
 }
@@ -0,0 +1,123 @@
+package crawler
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/migalabs/armiarma/pkg/gossipsub"
+	"github.com/migalabs/armiarma/pkg/metrics"
+	"github.com/migalabs/armiarma/pkg/utils/influx"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// measurements and tags of the time series exported to InfluxDB
+const (
+	influxPeersConnected = "peers_connected"
+	influxPeersPerClient = "peers_per_client"
+	influxMsgsPerTopic   = "msgs_per_topic"
+	influxPersisterQueue = "persister_queue_depth"
+	influxClientTag      = "client"
+	influxTopicTag       = "topic"
+	InfluxNetworkTag     = "network"
+	InfluxCrawlerIDTag   = "crawler_id"
+)
+
+// InfluxReporter periodically exports the time series of the crawl (connected peers, peers per client,
+// messages per topic and depth of the persister queue) through an influx Sink, which tags them with
+// the network and the id of the crawler.
+type InfluxReporter struct {
+	ctx context.Context
+
+	interval  time.Duration
+	sink      *influx.Sink
+	peerStore *metrics.PeerStore
+	dbStats   PersisterStats
+	nowFn     func() time.Time
+
+	wg     sync.WaitGroup
+	closeC chan struct{}
+}
+
+// NewInfluxReporter returns an InfluxReporter that will export the points into the sink every interval.
+func NewInfluxReporter(
+	ctx context.Context,
+	interval time.Duration,
+	sink *influx.Sink,
+	peerStore *metrics.PeerStore,
+	dbStats PersisterStats) *InfluxReporter {
+
+	return &InfluxReporter{
+		ctx:       ctx,
+		interval:  interval,
+		sink:      sink,
+		peerStore: peerStore,
+		dbStats:   dbStats,
+		nowFn:     time.Now,
+		closeC:    make(chan struct{}),
+	}
+}
+
+// Start spawns the routine that exports the points on every tick.
+func (r *InfluxReporter) Start() {
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				r.Report()
+			case <-r.closeC:
+				log.Debug("closing influx reporter")
+				return
+			case <-r.ctx.Done():
+				log.Debug("context died, closing influx reporter")
+				return
+			}
+		}
+	}()
+}
+
+// Close stops the routine, exports the last points and closes the sink.
+func (r *InfluxReporter) Close() {
+	close(r.closeC)
+	r.wg.Wait()
+	r.Report()
+	err := r.sink.Close()
+	if err != nil {
+		log.Error(errors.Wrap(err, "unable to close influx sink"))
+	}
+}
+
+// Report writes the current points into the sink. The ones that fail are retried on the next report.
+func (r *InfluxReporter) Report() {
+	err := r.sink.Write(r.Points())
+	if err != nil {
+		log.Warn(errors.Wrap(err, "unable to export the crawl into influx"))
+	}
+}
+
+// Points returns the points of the current state of the crawl, all of them with the same timestamp.
+func (r *InfluxReporter) Points() []influx.Point {
+	now := r.nowFn()
+	_, _, currentlyConnected := r.peerStore.ConnectionStats()
+	points := []influx.Point{
+		influx.NewPoint(influxPeersConnected, nil, currentlyConnected, now),
+		influx.NewPoint(influxPersisterQueue, nil, r.dbStats.PersisterQueueDepth(), now),
+	}
+	for _, item := range rankItems(r.peerStore.ClientDistribution()) {
+		points = append(points, influx.NewPoint(influxPeersPerClient, map[string]string{influxClientTag: item.Key}, item.Count, now))
+	}
+	// the subnet topics are aggregated into their main topic, as in the summary
+	msgTotals := make(map[string]int)
+	for topic, count := range r.peerStore.MessageTotals() {
+		msgTotals[gossipsub.TopicLabel(topic)] += int(count)
+	}
+	for _, item := range rankItems(msgTotals) {
+		points = append(points, influx.NewPoint(influxMsgsPerTopic, map[string]string{influxTopicTag: item.Key}, item.Count, now))
+	}
+	return points
+}
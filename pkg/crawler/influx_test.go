@@ -0,0 +1,98 @@
+package crawler
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/migalabs/armiarma/pkg/metrics"
+	"github.com/migalabs/armiarma/pkg/utils/influx"
+	"github.com/stretchr/testify/require"
+)
+
+const goldenInfluxLines = `peers_connected,crawler_id=crawler-1,network=mainnet value=2i 1654084800000000000
+persister_queue_depth,crawler_id=crawler-1,network=mainnet value=42i 1654084800000000000
+peers_per_client,client=prysm,crawler_id=crawler-1,network=mainnet value=2i 1654084800000000000
+peers_per_client,client=lighthouse,crawler_id=crawler-1,network=mainnet value=1i 1654084800000000000
+msgs_per_topic,crawler_id=crawler-1,network=mainnet,topic=beacon_attestation value=4i 1654084800000000000
+msgs_per_topic,crawler_id=crawler-1,network=mainnet,topic=beacon_block value=1i 1654084800000000000
+`
+
+// fakeInfluxDB is the write endpoint of an InfluxDB v2 that fails the first writes.
+type fakeInfluxDB struct {
+	m        sync.Mutex
+	failures int
+	requests []*http.Request
+	bodies   []string
+}
+
+func (db *fakeInfluxDB) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	db.m.Lock()
+	defer db.m.Unlock()
+	body, _ := ioutil.ReadAll(r.Body)
+	db.requests = append(db.requests, r)
+	db.bodies = append(db.bodies, string(body))
+	if db.failures > 0 {
+		db.failures--
+		http.Error(w, `{"code":"unavailable","message":"down for maintenance"}`, http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func Test_InfluxReporter(t *testing.T) {
+	store := metrics.NewPeerStore()
+	t0 := time.Unix(1654084800, 0)
+	for i, client := range []string{"prysm", "prysm", "lighthouse"} {
+		p := store.GetOrCreatePeer(peer.ID(fmt.Sprintf("peer%d", i)))
+		p.ClientName = client
+		if i < 2 {
+			p.ConnectionEvent(t0)
+		}
+	}
+	p, _ := store.GetPeer(peer.ID("peer0"))
+	p.MessageEvent("/eth2/4a26c58b/beacon_block/ssz_snappy", t0)
+	for j := 0; j < 4; j++ {
+		p.MessageEvent(fmt.Sprintf("/eth2/4a26c58b/beacon_attestation_%d/ssz_snappy", j), t0)
+	}
+
+	db := &fakeInfluxDB{failures: 1}
+	server := httptest.NewServer(db)
+	defer server.Close()
+
+	writer, err := influx.NewWriter(server.URL, "migalabs", "crawls", "secret-token")
+	require.NoError(t, err)
+	sink := influx.NewSink(writer, map[string]string{
+		InfluxNetworkTag:   "mainnet",
+		InfluxCrawlerIDTag: "crawler-1",
+	}, influx.DefaultMaxPending)
+	reporter := NewInfluxReporter(context.Background(), time.Minute, sink, store, testPersisterStats{queue: 42})
+	reporter.nowFn = func() time.Time { return t0 }
+
+	// the first write fails, so its points are retried along with the next ones
+	reporter.Report()
+	require.Equal(t, len(strings.Split(strings.TrimSpace(goldenInfluxLines), "\n")), sink.Pending())
+	reporter.Report()
+	require.Zero(t, sink.Pending())
+
+	db.m.Lock()
+	defer db.m.Unlock()
+	require.Len(t, db.requests, 2)
+	for _, req := range db.requests {
+		require.Equal(t, http.MethodPost, req.Method)
+		require.Equal(t, "/api/v2/write", req.URL.Path)
+		require.Equal(t, "migalabs", req.URL.Query().Get("org"))
+		require.Equal(t, "crawls", req.URL.Query().Get("bucket"))
+		require.Equal(t, "ns", req.URL.Query().Get("precision"))
+		require.Equal(t, "Token secret-token", req.Header.Get("Authorization"))
+	}
+	require.Equal(t, goldenInfluxLines, db.bodies[0])
+	require.Equal(t, goldenInfluxLines+goldenInfluxLines, db.bodies[1])
+}
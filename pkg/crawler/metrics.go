@@ -3,7 +3,9 @@ package crawler
 import (
 	"fmt"
 
+	"github.com/migalabs/armiarma/pkg/db/models"
 	"github.com/migalabs/armiarma/pkg/metrics"
+	"github.com/migalabs/armiarma/pkg/utils"
 	"github.com/prometheus/client_golang/prometheus"
 
 	"github.com/pkg/errors"
@@ -28,12 +30,19 @@ var (
 	},
 		[]string{"client_version"},
 	)
+	ClientStatusSuccessRate = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: modName,
+		Name:      "client_status_success_rate",
+		Help:      "Ratio of the Status requests that succeeded for each of the clients observed",
+	},
+		[]string{"client"},
+	)
 	GeoDistribution = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Namespace: modName,
 		Name:      "geographical_distribution",
 		Help:      "Number of peers from each of the crawled countries",
 	},
-		[]string{"country"},
+		[]string{"country_code", "country"},
 	)
 	NodeDistribution = prometheus.NewGauge(prometheus.GaugeOpts{
 		Namespace: modName,
@@ -45,6 +54,45 @@ var (
 		Name:      "deprecated_nodes",
 		Help:      "Total number of deprecated peers",
 	})
+	ForeignEnrCount = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: modName,
+		Name:      "foreign_network_enrs",
+		Help:      "Total number of discovered ENRs that belong to a different network",
+	})
+	ForeignForkDigests = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: modName,
+		Name:      "foreign_fork_digests",
+		Help:      "Number of skipped ENRs (source enr) and connected peers (source status) of each foreign fork digest",
+	},
+		[]string{"source", "fork_digest"},
+	)
+	DroppedEth2lessEnrs = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: modName,
+		Name:      "dropped_eth2less_enrs",
+		Help:      "Total number of discovered ENRs without eth2 entry that were dropped",
+	})
+	EvictedPeers = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: modName,
+		Name:      "peer_store_evictions",
+		Help:      "Total number of peers evicted from the in-memory peer store",
+	})
+	UnknownAgents = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: modName,
+		Name:      "unknown_user_agents",
+		Help:      "Total number of parsed user agents that no client rule could classify",
+	})
+	FunnelPeers = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: modName,
+		Name:      "funnel_peers",
+		Help:      "Number of peers in each stage of the crawl funnel (discovered, attempted, connected, identified, metadata)",
+	},
+		[]string{"stage"},
+	)
+	LightClientSenders = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: modName,
+		Name:      "light_client_update_senders",
+		Help:      "Number of distinct peers that delivered at least one light client update",
+	})
 	OsDistribution = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Namespace: modName,
 		Name:      "os_distribution",
@@ -66,6 +114,13 @@ var (
 	},
 		[]string{"ip_host"},
 	)
+	ProviderDistribution = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: modName,
+		Name:      "provider_distribution",
+		Help:      "Distribution of nodes per cloud or hosting provider",
+	},
+		[]string{"provider"},
+	)
 	RttDist = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Namespace: modName,
 		Name:      "observed_rtt_distribution",
@@ -88,14 +143,29 @@ func (c *EthereumCrawler) GetMetrics() *metrics.MetricsModule {
 		modDetails,
 	)
 	// compose all the metrics
+	metricsMod.AddIndvMetric(c.nodeDistributionMetrics())
+	metricsMod.AddIndvMetric(c.foreignEnrMetrics())
+	metricsMod.AddIndvMetric(c.foreignForkDigestMetrics())
+	metricsMod.AddIndvMetric(c.droppedEth2lessMetrics())
+	metricsMod.AddIndvMetric(c.lightClientSendersMetrics())
+	metricsMod.AddIndvMetric(c.evictedPeersMetrics())
+	metricsMod.AddIndvMetric(c.unknownAgentsMetrics())
+	metricsMod.AddIndvMetric(c.funnelMetrics())
+	metricsMod.AddIndvMetric(c.peerStoreMetrics())
+
+	// the distributions are aggregated by postgresql, they aren't available if the crawl is kept in memory
+	if c.psqlDB() == nil {
+		return metricsMod
+	}
 	metricsMod.AddIndvMetric(c.clientDistributionMetrics())
 	metricsMod.AddIndvMetric(c.versionDistributionMetrics())
+	metricsMod.AddIndvMetric(c.clientStatusSuccessRateMetrics())
 	metricsMod.AddIndvMetric(c.geoDistributionMetrics())
-	metricsMod.AddIndvMetric(c.nodeDistributionMetrics())
 	metricsMod.AddIndvMetric(c.deprecatedNodeMetrics())
 	metricsMod.AddIndvMetric(c.getPeersOs())
 	metricsMod.AddIndvMetric(c.getPeersArch())
 	metricsMod.AddIndvMetric(c.getHostedPeers())
+	metricsMod.AddIndvMetric(c.getProviderDist())
 	metricsMod.AddIndvMetric(c.getRTTDist())
 	metricsMod.AddIndvMetric(c.getIPDist())
 
@@ -108,7 +178,7 @@ func (c *EthereumCrawler) clientDistributionMetrics() *metrics.IndvMetrics {
 		return nil
 	}
 	updateFn := func() (interface{}, error) {
-		summary, err := c.DB.GetClientDistribution()
+		summary, err := c.psqlDB().GetClientDistribution()
 		if err != nil {
 			return nil, err
 		}
@@ -128,13 +198,39 @@ func (c *EthereumCrawler) clientDistributionMetrics() *metrics.IndvMetrics {
 	return cliDist
 }
 
+func (c *EthereumCrawler) clientStatusSuccessRateMetrics() *metrics.IndvMetrics {
+	initFn := func() error {
+		prometheus.MustRegister(ClientStatusSuccessRate)
+		return nil
+	}
+	updateFn := func() (interface{}, error) {
+		rates, err := c.psqlDB().GetClientStatusSuccessRate()
+		if err != nil {
+			return nil, err
+		}
+		for cliName, rate := range rates {
+			ClientStatusSuccessRate.WithLabelValues(cliName).Set(rate)
+		}
+		return rates, nil
+	}
+	statusRates, err := metrics.NewIndvMetrics(
+		"client_status_success_rate",
+		initFn,
+		updateFn,
+	)
+	if err != nil {
+		return nil
+	}
+	return statusRates
+}
+
 func (c *EthereumCrawler) versionDistributionMetrics() *metrics.IndvMetrics {
 	initFn := func() error {
 		prometheus.MustRegister(VersionDistribution)
 		return nil
 	}
 	updateFn := func() (interface{}, error) {
-		summary, err := c.DB.GetVersionDistribution()
+		summary, err := c.psqlDB().GetVersionDistribution()
 		if err != nil {
 			return nil, err
 		}
@@ -160,13 +256,14 @@ func (c *EthereumCrawler) geoDistributionMetrics() *metrics.IndvMetrics {
 		return nil
 	}
 	updateFn := func() (interface{}, error) {
-		summary, err := c.DB.GetGeoDistribution()
+		summary, err := c.psqlDB().GetGeoDistribution()
 		if err != nil {
 			fmt.Println(errors.Wrap(err, "unable to get GeoDist"))
 			return nil, err
 		}
-		for country, cnt := range summary {
-			GeoDistribution.WithLabelValues(country).Set(float64(cnt.(int)))
+		for countryCode, cnt := range summary {
+			countryCount := cnt.(models.CountryCount)
+			GeoDistribution.WithLabelValues(countryCode, countryCount.Country).Set(float64(countryCount.Peers))
 		}
 		return summary, nil
 	}
@@ -187,14 +284,17 @@ func (c *EthereumCrawler) nodeDistributionMetrics() *metrics.IndvMetrics {
 		return nil
 	}
 	updateFn := func() (interface{}, error) {
-		peerLs, err := c.DB.GetNonDeprecatedPeers()
+		// only count them, no need to keep the peers
+		peerCnt, err := c.DB.ForEachNonDeprecatedPeer(c.ctx, func(*models.RemoteConnectablePeer) error {
+			return nil
+		})
 		if err != nil {
 			return nil, err
 		}
 
-		NodeDistribution.Set(float64(len(peerLs)))
+		NodeDistribution.Set(float64(peerCnt))
 
-		return len(peerLs), nil
+		return int(peerCnt), nil
 	}
 	nodeDist, err := metrics.NewIndvMetrics(
 		"geographical_distribution",
@@ -213,7 +313,7 @@ func (c *EthereumCrawler) deprecatedNodeMetrics() *metrics.IndvMetrics {
 		return nil
 	}
 	updateFn := func() (interface{}, error) {
-		nodeCnt, err := c.DB.GetDeprecatedNodes()
+		nodeCnt, err := c.psqlDB().GetDeprecatedNodes()
 		if err != nil {
 			return nil, err
 		}
@@ -231,13 +331,173 @@ func (c *EthereumCrawler) deprecatedNodeMetrics() *metrics.IndvMetrics {
 	return depNodes
 }
 
+func (c *EthereumCrawler) foreignEnrMetrics() *metrics.IndvMetrics {
+	initFn := func() error {
+		prometheus.MustRegister(ForeignEnrCount)
+		return nil
+	}
+	updateFn := func() (interface{}, error) {
+		enrCnt := c.Dv5.ForeignEnrCount()
+		ForeignEnrCount.Set(float64(enrCnt))
+		return enrCnt, nil
+	}
+	foreignEnrs, err := metrics.NewIndvMetrics(
+		"foreign_network_enrs",
+		initFn,
+		updateFn,
+	)
+	if err != nil {
+		return nil
+	}
+	return foreignEnrs
+}
+
+func (c *EthereumCrawler) foreignForkDigestMetrics() *metrics.IndvMetrics {
+	initFn := func() error {
+		prometheus.MustRegister(ForeignForkDigests)
+		return nil
+	}
+	updateFn := func() (interface{}, error) {
+		// the summary logs the number of peers skipped per source
+		skipped := make(map[string]uint64, 2)
+		for source, digests := range map[string]map[string]uint64{
+			"enr":    c.NetworkFilter.ForeignDigests(),
+			"status": c.NetworkFilter.ForeignStatusDigests(),
+		} {
+			for digest, cnt := range digests {
+				ForeignForkDigests.WithLabelValues(source, digest).Set(float64(cnt))
+				skipped[source] += cnt
+			}
+		}
+		return skipped, nil
+	}
+	foreignDigests, err := metrics.NewIndvMetrics(
+		"foreign_fork_digests",
+		initFn,
+		updateFn,
+	)
+	if err != nil {
+		return nil
+	}
+	return foreignDigests
+}
+
+func (c *EthereumCrawler) droppedEth2lessMetrics() *metrics.IndvMetrics {
+	initFn := func() error {
+		prometheus.MustRegister(DroppedEth2lessEnrs)
+		return nil
+	}
+	updateFn := func() (interface{}, error) {
+		dropped := c.NetworkFilter.DroppedEth2lessCount()
+		DroppedEth2lessEnrs.Set(float64(dropped))
+		return dropped, nil
+	}
+	droppedEnrs, err := metrics.NewIndvMetrics(
+		"dropped_eth2less_enrs",
+		initFn,
+		updateFn,
+	)
+	if err != nil {
+		return nil
+	}
+	return droppedEnrs
+}
+
+func (c *EthereumCrawler) evictedPeersMetrics() *metrics.IndvMetrics {
+	initFn := func() error {
+		prometheus.MustRegister(EvictedPeers)
+		return nil
+	}
+	updateFn := func() (interface{}, error) {
+		evictions := c.PeerStore.Evictions()
+		EvictedPeers.Set(float64(evictions))
+		return evictions, nil
+	}
+	evictedPeers, err := metrics.NewIndvMetrics(
+		"peer_store_evictions",
+		initFn,
+		updateFn,
+	)
+	if err != nil {
+		return nil
+	}
+	return evictedPeers
+}
+
+func (c *EthereumCrawler) unknownAgentsMetrics() *metrics.IndvMetrics {
+	initFn := func() error {
+		prometheus.MustRegister(UnknownAgents)
+		return nil
+	}
+	updateFn := func() (interface{}, error) {
+		unknown := utils.UnknownAgents()
+		UnknownAgents.Set(float64(unknown))
+		return unknown, nil
+	}
+	unknownAgents, err := metrics.NewIndvMetrics(
+		"unknown_user_agents",
+		initFn,
+		updateFn,
+	)
+	if err != nil {
+		return nil
+	}
+	return unknownAgents
+}
+
+func (c *EthereumCrawler) funnelMetrics() *metrics.IndvMetrics {
+	initFn := func() error {
+		prometheus.MustRegister(FunnelPeers)
+		return nil
+	}
+	updateFn := func() (interface{}, error) {
+		funnel := c.PeerStore.GetFunnel()
+		FunnelPeers.WithLabelValues("discovered").Set(float64(funnel.Discovered))
+		FunnelPeers.WithLabelValues("attempted").Set(float64(funnel.Attempted))
+		FunnelPeers.WithLabelValues("connected").Set(float64(funnel.Connected))
+		FunnelPeers.WithLabelValues("identified").Set(float64(funnel.Identified))
+		FunnelPeers.WithLabelValues("metadata").Set(float64(funnel.Metadata))
+		return funnel, nil
+	}
+	funnel, err := metrics.NewIndvMetrics(
+		"crawl_funnel",
+		initFn,
+		updateFn,
+	)
+	if err != nil {
+		return nil
+	}
+	return funnel
+}
+
+func (c *EthereumCrawler) lightClientSendersMetrics() *metrics.IndvMetrics {
+	initFn := func() error {
+		prometheus.MustRegister(LightClientSenders)
+		return nil
+	}
+	updateFn := func() (interface{}, error) {
+		senders := c.PeerStore.LightClientSenders()
+		LightClientSenders.Set(float64(senders))
+		return senders, nil
+	}
+	lcSenders, err := metrics.NewIndvMetrics(
+		"light_client_update_senders",
+		initFn,
+		updateFn,
+	)
+	if err != nil {
+		return nil
+	}
+	return lcSenders
+}
+
 func (c *EthereumCrawler) getPeersOs() *metrics.IndvMetrics {
 	initFn := func() error {
 		prometheus.MustRegister(OsDistribution)
 		return nil
 	}
 	updateFn := func() (interface{}, error) {
-		osDist, err := c.DB.GetOsDistribution()
+		osDist, err := c.psqlDB().GetOsDistribution()
 		if err != nil {
 			return nil, err
 		}
@@ -263,7 +523,7 @@ func (c *EthereumCrawler) getPeersArch() *metrics.IndvMetrics {
 		return nil
 	}
 	updateFn := func() (interface{}, error) {
-		archDist, err := c.DB.GetArchDistribution()
+		archDist, err := c.psqlDB().GetArchDistribution()
 		if err != nil {
 			return nil, err
 		}
@@ -289,7 +549,7 @@ func (c *EthereumCrawler) getHostedPeers() *metrics.IndvMetrics {
 		return nil
 	}
 	updateFn := func() (interface{}, error) {
-		ipSummary, err := c.DB.GetHostingDistribution()
+		ipSummary, err := c.psqlDB().GetHostingDistribution()
 		if err != nil {
 			return nil, err
 		}
@@ -309,6 +569,32 @@ func (c *EthereumCrawler) getHostedPeers() *metrics.IndvMetrics {
 	return ipHosting
 }
 
+func (c *EthereumCrawler) getProviderDist() *metrics.IndvMetrics {
+	initFn := func() error {
+		prometheus.MustRegister(ProviderDistribution)
+		return nil
+	}
+	updateFn := func() (interface{}, error) {
+		summary, err := c.psqlDB().GetProviderDistribution()
+		if err != nil {
+			return nil, err
+		}
+		for provider, peers := range summary {
+			ProviderDistribution.WithLabelValues(provider).Set(float64(peers.(int)))
+		}
+		return summary, nil
+	}
+	providerDist, err := metrics.NewIndvMetrics(
+		"provider_distribution",
+		initFn,
+		updateFn,
+	)
+	if err != nil {
+		return nil
+	}
+	return providerDist
+}
+
 
 func (c *EthereumCrawler) getRTTDist() *metrics.IndvMetrics {
 	initFn := func() error {
@@ -316,7 +602,7 @@ func (c *EthereumCrawler) getRTTDist() *metrics.IndvMetrics {
 		return nil
 	}
 	updateFn := func() (interface{}, error) {
-		summary, err := c.DB.GetRTTDistribution()
+		summary, err := c.psqlDB().GetRTTDistribution()
 		if err != nil {
 			return nil, err
 		}
@@ -342,7 +628,7 @@ func (c *EthereumCrawler) getIPDist() *metrics.IndvMetrics {
 		return nil
 	}
 	updateFn := func() (interface{}, error) {
-		summary, err := c.DB.GetIPDistribution()
+		summary, err := c.psqlDB().GetIPDistribution()
 		if err != nil {
 			return nil, err
 		}
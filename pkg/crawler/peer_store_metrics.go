@@ -0,0 +1,156 @@
+package crawler
+
+import (
+	"sync"
+
+	"github.com/migalabs/armiarma/pkg/gossipsub"
+	"github.com/migalabs/armiarma/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// descriptions of the metrics served from the snapshots of the in-memory peer store
+// (gauges, even the sums of attempts and messages, as they drop when the peers are evicted)
+var (
+	peerStorePeersDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(modName, "peer_store", "peers"),
+		"Number of peers discovered in the in-memory peer store",
+		nil, nil,
+	)
+	peerStoreConnectedDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(modName, "peer_store", "connected_peers"),
+		"Number of peers currently connected",
+		nil, nil,
+	)
+	peerStoreAttemptsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(modName, "peer_store", "connection_attempts"),
+		"Number of connection attempts made to the peers currently in the store",
+		nil, nil,
+	)
+	peerStoreFailedAttemptsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(modName, "peer_store", "failed_connection_attempts"),
+		"Number of connection attempts to the peers currently in the store that failed",
+		nil, nil,
+	)
+	peerStoreFailedPeersDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(modName, "peer_store", "failed_peers"),
+		"Number of peers whose last connection attempt failed, per filtered error",
+		[]string{"error"}, nil,
+	)
+	peerStoreClientsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(modName, "peer_store", "client_peers"),
+		"Number of identified peers per client name and version",
+		[]string{"client", "version"}, nil,
+	)
+	peerStoreCountriesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(modName, "peer_store", "country_peers"),
+		"Number of located peers per country",
+		[]string{"country"}, nil,
+	)
+	peerStoreMessagesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(modName, "peer_store", "messages"),
+		"Number of gossip messages received from the peers currently in the store per topic",
+		[]string{"topic"}, nil,
+	)
+	peerStoreLatencyDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(modName, "peer_store", "latency_seconds"),
+		"Distribution of the latency (RTT) of the peers per client",
+		[]string{"client"}, nil,
+	)
+)
+
+// PeerStoreCollector serves to Prometheus the last snapshot of the in-memory peer store, so that
+// the scrapes don't walk the peers. The snapshot is refreshed by Refresh on every update
+// of the metrics modules.
+type PeerStoreCollector struct {
+	peerStore      *metrics.PeerStore
+	latencyBuckets []float64
+
+	m        sync.RWMutex
+	snapshot metrics.PeerStoreSnapshot
+}
+
+// NewPeerStoreCollector returns a PeerStoreCollector of the store, with the given latency buckets (in secs).
+func NewPeerStoreCollector(peerStore *metrics.PeerStore, latencyBuckets []float64) *PeerStoreCollector {
+	return &PeerStoreCollector{
+		peerStore:      peerStore,
+		latencyBuckets: latencyBuckets,
+	}
+}
+
+// Refresh takes a new snapshot of the store, returning it.
+func (c *PeerStoreCollector) Refresh() metrics.PeerStoreSnapshot {
+	snapshot := c.peerStore.Snapshot(c.latencyBuckets)
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.snapshot = snapshot
+	return snapshot
+}
+
+// Describe sends the descriptions of the served metrics.
+func (c *PeerStoreCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- peerStorePeersDesc
+	ch <- peerStoreConnectedDesc
+	ch <- peerStoreAttemptsDesc
+	ch <- peerStoreFailedAttemptsDesc
+	ch <- peerStoreFailedPeersDesc
+	ch <- peerStoreClientsDesc
+	ch <- peerStoreCountriesDesc
+	ch <- peerStoreMessagesDesc
+	ch <- peerStoreLatencyDesc
+}
+
+// Collect sends the metrics of the last snapshot.
+func (c *PeerStoreCollector) Collect(ch chan<- prometheus.Metric) {
+	c.m.RLock()
+	defer c.m.RUnlock()
+	s := c.snapshot
+
+	ch <- prometheus.MustNewConstMetric(peerStorePeersDesc, prometheus.GaugeValue, float64(s.Peers))
+	ch <- prometheus.MustNewConstMetric(peerStoreConnectedDesc, prometheus.GaugeValue, float64(s.Connected))
+	ch <- prometheus.MustNewConstMetric(peerStoreAttemptsDesc, prometheus.GaugeValue, float64(s.Attempts))
+	ch <- prometheus.MustNewConstMetric(peerStoreFailedAttemptsDesc, prometheus.GaugeValue, float64(s.FailedAttempts))
+	for connErr, count := range s.FailedPeers {
+		ch <- prometheus.MustNewConstMetric(peerStoreFailedPeersDesc, prometheus.GaugeValue, float64(count), connErr)
+	}
+	for release, count := range s.Clients {
+		ch <- prometheus.MustNewConstMetric(peerStoreClientsDesc, prometheus.GaugeValue, float64(count), release.Client, release.Version)
+	}
+	for country, count := range s.Countries {
+		ch <- prometheus.MustNewConstMetric(peerStoreCountriesDesc, prometheus.GaugeValue, float64(count), country)
+	}
+	// the subnet topics are aggregated into their main topic, as in the summary
+	msgTotals := make(map[string]int64)
+	for topic, count := range s.Messages {
+		msgTotals[gossipsub.TopicLabel(topic)] += count
+	}
+	for topic, count := range msgTotals {
+		ch <- prometheus.MustNewConstMetric(peerStoreMessagesDesc, prometheus.GaugeValue, float64(count), topic)
+	}
+	for client, h := range s.Latencies {
+		ch <- prometheus.MustNewConstHistogram(peerStoreLatencyDesc, h.Count, h.Sum, h.Buckets, client)
+	}
+}
+
+func (c *EthereumCrawler) peerStoreMetrics() *metrics.IndvMetrics {
+	collector := NewPeerStoreCollector(c.PeerStore, metrics.DefaultLatencyBuckets)
+	initFn := func() error {
+		collector.Refresh()
+		return prometheus.Register(collector)
+	}
+	updateFn := func() (interface{}, error) {
+		snapshot := collector.Refresh()
+		return map[string]int{
+			"peers":     snapshot.Peers,
+			"connected": snapshot.Connected,
+		}, nil
+	}
+	peerStoreMetrics, err := metrics.NewIndvMetrics(
+		"peer_store",
+		initFn,
+		updateFn,
+	)
+	if err != nil {
+		return nil
+	}
+	return peerStoreMetrics
+}
@@ -0,0 +1,76 @@
+package crawler
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/migalabs/armiarma/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+const expectedPeerStoreMetrics = `
+# HELP crawler_peer_store_client_peers Number of identified peers per client name and version
+# TYPE crawler_peer_store_client_peers gauge
+crawler_peer_store_client_peers{client="lighthouse",version="v2.3.1"} 1
+crawler_peer_store_client_peers{client="prysm",version="v2.1.0"} 2
+# HELP crawler_peer_store_connected_peers Number of peers currently connected
+# TYPE crawler_peer_store_connected_peers gauge
+crawler_peer_store_connected_peers 2
+# HELP crawler_peer_store_latency_seconds Distribution of the latency (RTT) of the peers per client
+# TYPE crawler_peer_store_latency_seconds histogram
+crawler_peer_store_latency_seconds_bucket{client="prysm",le="0.1"} 1
+crawler_peer_store_latency_seconds_bucket{client="prysm",le="1"} 2
+crawler_peer_store_latency_seconds_bucket{client="prysm",le="+Inf"} 2
+crawler_peer_store_latency_seconds_sum{client="prysm"} 0.55
+crawler_peer_store_latency_seconds_count{client="prysm"} 2
+# HELP crawler_peer_store_messages Number of gossip messages received from the peers currently in the store per topic
+# TYPE crawler_peer_store_messages gauge
+crawler_peer_store_messages{topic="beacon_attestation"} 4
+crawler_peer_store_messages{topic="beacon_block"} 1
+# HELP crawler_peer_store_peers Number of peers discovered in the in-memory peer store
+# TYPE crawler_peer_store_peers gauge
+crawler_peer_store_peers 3
+`
+
+func Test_PeerStoreCollector(t *testing.T) {
+	store := metrics.NewPeerStore()
+	for i, client := range []string{"prysm", "prysm", "lighthouse"} {
+		p := store.GetOrCreatePeer(peer.ID(fmt.Sprintf("peer%d", i)))
+		p.ClientName = client
+		p.ClientVersion = map[string]string{"prysm": "v2.1.0", "lighthouse": "v2.3.1"}[client]
+		if client == "prysm" {
+			p.Latency = time.Duration(50+i*450) * time.Millisecond
+			p.ConnectionEvent(time.Now())
+		}
+	}
+	p, _ := store.GetPeer(peer.ID("peer0"))
+	p.MessageEvent("/eth2/4a26c58b/beacon_block/ssz_snappy", time.Now())
+	for j := 0; j < 4; j++ {
+		p.MessageEvent(fmt.Sprintf("/eth2/4a26c58b/beacon_attestation_%d/ssz_snappy", j), time.Now())
+	}
+
+	collector := NewPeerStoreCollector(store, []float64{0.1, 1})
+	names := []string{
+		"crawler_peer_store_peers",
+		"crawler_peer_store_connected_peers",
+		"crawler_peer_store_client_peers",
+		"crawler_peer_store_messages",
+		"crawler_peer_store_latency_seconds",
+	}
+	collector.Refresh()
+	require.NoError(t, testutil.CollectAndCompare(collector, strings.NewReader(expectedPeerStoreMetrics), names...))
+
+	// the scrapes serve the snapshot, not the live store
+	store.GetOrCreatePeer(peer.ID("peer3"))
+	require.NoError(t, testutil.CollectAndCompare(collector, strings.NewReader(expectedPeerStoreMetrics), names...))
+	collector.Refresh()
+	require.NoError(t, testutil.CollectAndCompare(collector, strings.NewReader(`
+# HELP crawler_peer_store_peers Number of peers discovered in the in-memory peer store
+# TYPE crawler_peer_store_peers gauge
+crawler_peer_store_peers 4
+`), "crawler_peer_store_peers"))
+}
@@ -0,0 +1,549 @@
+package crawler
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	psql "github.com/migalabs/armiarma/pkg/db/postgresql"
+	"github.com/migalabs/armiarma/pkg/gossipsub"
+	"github.com/migalabs/armiarma/pkg/metrics"
+	"github.com/migalabs/armiarma/pkg/utils"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	summaryTopItems   = 5
+	summaryTopIPItems = 10
+	summaryTopLeaders = 3
+	summaryTopTalkers = 3
+)
+
+// PersisterStats is the set of DB stats that are included in the summary report.
+type PersisterStats interface {
+	PersisterQueueDepth() int
+	BatchErrors() int64
+}
+
+// DBWriteStats are the per-table writes of the DB that are included in the summary report.
+type DBWriteStats interface {
+	Stats() psql.DBStats
+}
+
+// DiscoveryStats is the set of discovery stats that are included in the summary report.
+type DiscoveryStats interface {
+	ForeignEnrCount() uint64
+}
+
+// ForkReadinessStats is the readiness of the peers for a scheduled fork that is included in the summary report.
+type ForkReadinessStats interface {
+	GetForkReadiness(forkVersion string) (psql.ForkReadinessReport, error)
+}
+
+// TopPeersStats is the ranking of the peers by messages that is included in the summary report.
+type TopPeersStats interface {
+	GetTopPeersByMessages(topic string, n int, window time.Duration) ([]psql.PeerTopicCount, error)
+}
+
+// TopicBreadthStats is the distribution of the peers by distinct topics that is included in the summary report.
+type TopicBreadthStats interface {
+	GetTopicBreadth() (psql.TopicBreadth, error)
+}
+
+// SummaryReporter periodically logs a human-readable summary of the crawl,
+// optionally appending it to an output file (rotated by its RotationPolicy) as well.
+type SummaryReporter struct {
+	ctx context.Context
+
+	interval   time.Duration
+	outputFile string
+	rotation   utils.RotationPolicy
+	outM       sync.Mutex
+	out        *utils.RotatingFile
+	peerStore  *metrics.PeerStore
+	dbStats    PersisterStats
+	dbWrites   DBWriteStats
+	discStats  DiscoveryStats
+	forkStats  ForkReadinessStats
+	topStats   TopPeersStats
+	breadth    TopicBreadthStats
+	// version of the fork whose readiness is reported (if any)
+	forkVersion string
+	// connected peers per attestation subnet below which a warning is logged (0 disables it),
+	// and the subnets that were below it in the last report
+	coverageThreshold int
+	lowSubnets        map[int]bool
+	nowFn             func() time.Time
+
+	wg     sync.WaitGroup
+	closeC chan struct{}
+}
+
+// NewSummaryReporter returns a SummaryReporter that will report every interval.
+// An empty outputFile keeps the summary in the logs only.
+func NewSummaryReporter(
+	ctx context.Context,
+	interval time.Duration,
+	outputFile string,
+	peerStore *metrics.PeerStore,
+	dbStats PersisterStats) *SummaryReporter {
+
+	return &SummaryReporter{
+		ctx:        ctx,
+		interval:   interval,
+		outputFile: outputFile,
+		peerStore:  peerStore,
+		dbStats:    dbStats,
+		lowSubnets: make(map[int]bool),
+		nowFn:      time.Now,
+		closeC:     make(chan struct{}),
+	}
+}
+
+// SetRotation sets the rotation policy of the output file.
+func (r *SummaryReporter) SetRotation(rotation utils.RotationPolicy) {
+	r.rotation = rotation
+}
+
+// SetDBWriteStats sets the DB whose writes per table are included in the summary.
+func (r *SummaryReporter) SetDBWriteStats(stats DBWriteStats) {
+	r.dbWrites = stats
+}
+
+// SetDiscoveryStats sets the discovery whose stats are included in the summary.
+func (r *SummaryReporter) SetDiscoveryStats(stats DiscoveryStats) {
+	r.discStats = stats
+}
+
+// SetForkReadiness sets the stats of the readiness for the fork of the given version that are included in the summary.
+func (r *SummaryReporter) SetForkReadiness(stats ForkReadinessStats, forkVersion string) {
+	r.forkStats = stats
+	r.forkVersion = forkVersion
+}
+
+// SetTopPeersStats sets the ranking of the peers by messages whose top block propagator
+// (over the last report interval) is included in the summary.
+func (r *SummaryReporter) SetTopPeersStats(stats TopPeersStats) {
+	r.topStats = stats
+}
+
+// SetTopicBreadthStats sets the distribution of the peers by distinct topics that is included in the summary.
+func (r *SummaryReporter) SetTopicBreadthStats(stats TopicBreadthStats) {
+	r.breadth = stats
+}
+
+// SetSubnetCoverageThreshold sets the number of connected peers per attestation subnet below which
+// the reports warn about the subnet (0 disables the warnings).
+func (r *SummaryReporter) SetSubnetCoverageThreshold(threshold int) {
+	r.coverageThreshold = threshold
+}
+
+// Start spawns the routine that reports the summary on every tick.
+func (r *SummaryReporter) Start() {
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				r.Report()
+			case <-r.closeC:
+				log.Debug("closing summary reporter")
+				return
+			case <-r.ctx.Done():
+				log.Debug("context died, closing summary reporter")
+				return
+			}
+		}
+	}()
+}
+
+// Close stops the reporting routine, waits until it finishes and reports the last summary
+// before closing the output file.
+func (r *SummaryReporter) Close() {
+	close(r.closeC)
+	r.wg.Wait()
+	r.Report()
+
+	r.outM.Lock()
+	defer r.outM.Unlock()
+	if r.out != nil {
+		err := r.out.Close()
+		if err != nil {
+			log.Error(errors.Wrap(err, "unable to close summary file "+r.outputFile))
+		}
+		r.out = nil
+	}
+}
+
+// Report composes the summary, logs it and writes it into the output file (if any).
+func (r *SummaryReporter) Report() {
+	crawlSummary := r.Summary()
+	r.checkSubnetCoverage(crawlSummary.SubnetCoverage)
+	summary := crawlSummary.Format()
+	log.Info("crawler summary\n" + summary)
+	if r.outputFile == "" {
+		return
+	}
+	err := r.writeOutput(summary + "\n")
+	if err != nil {
+		log.Error(errors.Wrap(err, "unable to write summary into "+r.outputFile))
+	}
+}
+
+// checkSubnetCoverage warns about the attestation subnets whose coverage dropped below the threshold
+// since the last report, returning them.
+func (r *SummaryReporter) checkSubnetCoverage(coverage metrics.SubnetCoverage) []int {
+	if r.coverageThreshold <= 0 {
+		return nil
+	}
+	dropped := make([]int, 0)
+	lowSubnets := make(map[int]bool)
+	for _, subnet := range coverage.Below(r.coverageThreshold) {
+		lowSubnets[subnet] = true
+		if !r.lowSubnets[subnet] {
+			dropped = append(dropped, subnet)
+			log.Warnf("attestation subnet %d is covered by %d connected peers (threshold %d)",
+				subnet, coverage[subnet], r.coverageThreshold)
+		}
+	}
+	for subnet := range r.lowSubnets {
+		if !lowSubnets[subnet] {
+			log.Infof("attestation subnet %d is covered again by %d connected peers", subnet, coverage[subnet])
+		}
+	}
+	r.lowSubnets = lowSubnets
+	return dropped
+}
+
+// writeOutput appends the report into the output file, opening it on the first report.
+func (r *SummaryReporter) writeOutput(report string) error {
+	r.outM.Lock()
+	defer r.outM.Unlock()
+	if r.out == nil {
+		out, err := utils.NewRotatingFile(r.outputFile, r.rotation, nil, true)
+		if err != nil {
+			return err
+		}
+		r.out = out
+	}
+	return r.out.WriteRecord([]byte(report))
+}
+
+// Summary aggregates the current state of the peer store and the persister.
+func (r *SummaryReporter) Summary() CrawlSummary {
+	attempted, connected, currentlyConnected := r.peerStore.ConnectionStats()
+
+	// aggregate the message totals of the subnet topics into their main topic
+	msgTotals := make(map[string]int)
+	for topic, count := range r.peerStore.MessageTotals() {
+		msgTotals[gossipsub.TopicLabel(topic)] += int(count)
+	}
+
+	// refresh the number of peers behind each IP, keeping only the shared ones
+	sharedIPs := make(map[string]int)
+	for ip, pids := range r.peerStore.RefreshPeersOnSameIP() {
+		if len(pids) > 1 {
+			sharedIPs[ip] = len(pids)
+		}
+	}
+
+	var foreignEnrs uint64
+	if r.discStats != nil {
+		foreignEnrs = r.discStats.ForeignEnrCount()
+	}
+
+	var forkReadiness *psql.ForkReadinessReport
+	if r.forkStats != nil {
+		report, err := r.forkStats.GetForkReadiness(r.forkVersion)
+		if err != nil {
+			log.Warn(errors.Wrap(err, "unable to get the readiness for fork "+r.forkVersion))
+		} else {
+			forkReadiness = &report
+		}
+	}
+
+	var topPropagator *psql.PeerTopicCount
+	if r.topStats != nil {
+		top, err := r.topStats.GetTopPeersByMessages(metrics.BeaconBlockTopicName, 1, r.interval)
+		if err != nil {
+			log.Warn(errors.Wrap(err, "unable to get the top block propagator"))
+		} else if len(top) > 0 {
+			topPropagator = &top[0]
+		}
+	}
+
+	bytesIn, bytesOut := r.peerStore.BandwidthTotals()
+
+	var topicBreadth *psql.TopicBreadth
+	if r.breadth != nil {
+		breadth, err := r.breadth.GetTopicBreadth()
+		if err != nil {
+			log.Warn(errors.Wrap(err, "unable to get the distribution of peers by distinct topics"))
+		} else {
+			topicBreadth = &breadth
+		}
+	}
+
+	var dbWrites *psql.DBStats
+	if r.dbWrites != nil {
+		stats := r.dbWrites.Stats()
+		dbWrites = &stats
+	}
+
+	return CrawlSummary{
+		Timestamp:          r.nowFn(),
+		Discovered:         r.peerStore.Len(),
+		Attempted:          attempted,
+		Connected:          connected,
+		CurrentlyConnected: currentlyConnected,
+		RelayOnly:          r.peerStore.RelayOnlyCount(),
+		Funnel:             r.peerStore.GetFunnel(),
+		SubnetCoverage:     r.peerStore.GetSubnetCoverage(),
+		Clients:            rankItems(r.peerStore.ClientDistribution()),
+		Countries:          rankItems(r.peerStore.CountryDistribution()),
+		Messages:           rankItems(msgTotals),
+		SharedIPs:          rankItems(sharedIPs),
+		Sessions:           r.peerStore.SessionHistogram(metrics.DefaultSessionBuckets),
+		BlockLeaders:       r.peerStore.GetFirstDeliveryLeaders(metrics.BeaconBlockTopicName, summaryTopLeaders),
+		BytesIn:            bytesIn,
+		BytesOut:           bytesOut,
+		TopTalkers:         r.peerStore.GetTopTalkers(summaryTopTalkers),
+		Nat:                r.peerStore.GetNatEstimate(),
+		TopPropagator:      topPropagator,
+		TopicBreadth:       topicBreadth,
+		ForeignEnrs:        foreignEnrs,
+		ForkReadiness:      forkReadiness,
+		PersisterQueue:     r.dbStats.PersisterQueueDepth(),
+		BatchErrors:        r.dbStats.BatchErrors(),
+		DBWrites:           dbWrites,
+	}
+}
+
+// CrawlSummary is the snapshot of the crawl that gets reported.
+type CrawlSummary struct {
+	Timestamp          time.Time
+	Discovered         int
+	Attempted          int
+	Connected          int
+	CurrentlyConnected int
+	RelayOnly          int
+	Funnel             metrics.Funnel
+	Clients            []RankedItem
+	Countries          []RankedItem
+	Messages           []RankedItem
+	SharedIPs          []RankedItem
+	Sessions           *metrics.SessionHistogram
+	BlockLeaders       []metrics.FirstDeliveryLeader
+	BytesIn            uint64
+	BytesOut           uint64
+	TopTalkers         []metrics.PeerBandwidthTotal
+	Nat                metrics.NatEstimate
+	TopPropagator      *psql.PeerTopicCount // only if the ranking is available
+	TopicBreadth       *psql.TopicBreadth   // only if the distribution is available
+	SubnetCoverage     metrics.SubnetCoverage
+	ForeignEnrs        uint64
+	ForkReadiness      *psql.ForkReadinessReport // only if a fork is configured
+	PersisterQueue     int
+	BatchErrors        int64
+	DBWrites           *psql.DBStats // only with the postgresql DB
+}
+
+// RankedItem is a single key of a distribution with its count.
+type RankedItem struct {
+	Key   string
+	Count int
+}
+
+// rankItems sorts the given distribution by count (desc), breaking ties by key.
+func rankItems(dist map[string]int) []RankedItem {
+	items := make([]RankedItem, 0, len(dist))
+	for k, v := range dist {
+		items = append(items, RankedItem{Key: k, Count: v})
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Count == items[j].Count {
+			return items[i].Key < items[j].Key
+		}
+		return items[i].Count > items[j].Count
+	})
+	return items
+}
+
+// Format returns the compact multi-line representation of the summary.
+func (s CrawlSummary) Format() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "---- summary %s ----\n", s.Timestamp.UTC().Format(time.RFC3339))
+	fmt.Fprintf(&b, "peers:     discovered=%d attempted=%d connected=%d currently-connected=%d relay-only=%d\n",
+		s.Discovered, s.Attempted, s.Connected, s.CurrentlyConnected, s.RelayOnly)
+	fmt.Fprintf(&b, "funnel:    discovered=%d attempted=%d connected=%d identified=%d metadata=%d\n",
+		s.Funnel.Discovered, s.Funnel.Attempted, s.Funnel.Connected, s.Funnel.Identified, s.Funnel.Metadata)
+	fmt.Fprintf(&b, "clients:   %s\n", formatTopPercentages(s.Clients))
+	fmt.Fprintf(&b, "countries: %s\n", formatTopPercentages(s.Countries))
+	fmt.Fprintf(&b, "messages:  %s\n", formatTotals(s.Messages))
+	fmt.Fprintf(&b, "shared-ip: %s\n", formatTopCounts(s.SharedIPs, summaryTopIPItems))
+	fmt.Fprintf(&b, "sessions:  %s\n", formatSessions(s.Sessions))
+	fmt.Fprintf(&b, "1st-block: %s\n", formatLeaders(s.BlockLeaders))
+	if s.TopPropagator != nil {
+		fmt.Fprintf(&b, "top-block: %s\n", formatTopPropagator(s.TopPropagator))
+	}
+	if s.TopicBreadth != nil {
+		fmt.Fprintf(&b, "topics:    peers-per-distinct-topics 1=%d 2-5=%d 6+=%d\n",
+			s.TopicBreadth.Single, s.TopicBreadth.Few, s.TopicBreadth.Many)
+	}
+	fmt.Fprintf(&b, "bandwidth: in=%s out=%s top: %s\n",
+		formatBytes(s.BytesIn), formatBytes(s.BytesOut), formatTopTalkers(s.TopTalkers))
+	if share, ok := s.Nat.UnreachableShare(); ok {
+		fmt.Fprintf(&b, "nat:       inbound-only=%d dialed-back=%d unreachable=%d (%.1f%%)\n",
+			s.Nat.InboundOnly, s.Nat.DialedBack, s.Nat.Unreachable, share*100)
+	}
+	fmt.Fprintf(&b, "subnets:   connected-peers min=%d median=%d\n", s.SubnetCoverage.Min(), s.SubnetCoverage.Median())
+	fmt.Fprintf(&b, "discovery: foreign-network-enrs=%d\n", s.ForeignEnrs)
+	if s.ForkReadiness != nil {
+		fmt.Fprintf(&b, "fork:      %s\n", formatForkReadiness(s.ForkReadiness))
+	}
+	if s.DBWrites != nil {
+		fmt.Fprintf(&b, "db-writes: %s\n", formatDBWrites(s.DBWrites))
+	}
+	fmt.Fprintf(&b, "database:  persister-queue=%d batch-errors=%d", s.PersisterQueue, s.BatchErrors)
+	return b.String()
+}
+
+func formatTopPercentages(items []RankedItem) string {
+	if len(items) == 0 {
+		return "none"
+	}
+	total := 0
+	for _, item := range items {
+		total += item.Count
+	}
+	fields := make([]string, 0, summaryTopItems)
+	for i, item := range items {
+		if i >= summaryTopItems {
+			break
+		}
+		fields = append(fields, fmt.Sprintf("%s %.1f%%", item.Key, float64(item.Count)*100/float64(total)))
+	}
+	return strings.Join(fields, ", ")
+}
+
+func formatTotals(items []RankedItem) string {
+	if len(items) == 0 {
+		return "none"
+	}
+	fields := make([]string, 0, len(items))
+	for _, item := range items {
+		fields = append(fields, fmt.Sprintf("%s=%d", item.Key, item.Count))
+	}
+	return strings.Join(fields, " ")
+}
+
+func formatTopCounts(items []RankedItem, top int) string {
+	if len(items) == 0 {
+		return "none"
+	}
+	fields := make([]string, 0, top)
+	for i, item := range items {
+		if i >= top {
+			break
+		}
+		fields = append(fields, fmt.Sprintf("%s (%d)", item.Key, item.Count))
+	}
+	return strings.Join(fields, ", ")
+}
+
+// formatDBWrites returns the tables with the most executed statements, the average batch size,
+// and the tables whose statements failed.
+func formatDBWrites(stats *psql.DBStats) string {
+	executed := make(map[string]int)
+	failed := make(map[string]int)
+	for table, tableStats := range stats.Tables {
+		if tableStats.Executed > 0 {
+			executed[table] = int(tableStats.Executed)
+		}
+		if tableStats.Errors > 0 {
+			failed[table] = int(tableStats.Errors)
+		}
+	}
+	top := rankItems(executed)
+	if len(top) > summaryTopItems {
+		top = top[:summaryTopItems]
+	}
+	return fmt.Sprintf("%s avg-batch=%.1f errors: %s",
+		formatTotals(top), stats.AvgBatchSize, formatTotals(rankItems(failed)))
+}
+
+func formatSessions(h *metrics.SessionHistogram) string {
+	if h == nil || h.Total == 0 {
+		return "none"
+	}
+	fields := make([]string, 0, len(h.Counts)+3)
+	for i, label := range h.BucketLabels() {
+		fields = append(fields, fmt.Sprintf("%s=%d", label, h.Counts[i]))
+	}
+	for _, q := range []float64{50, 90, 99} {
+		fields = append(fields, fmt.Sprintf("p%.0f=%s", q, metrics.ShortDuration(h.Percentile(q).Round(time.Second))))
+	}
+	return strings.Join(fields, " ")
+}
+
+func formatForkReadiness(report *psql.ForkReadinessReport) string {
+	ready := "n/a"
+	if pct, ok := report.Total.ReadyPercentage(); ok {
+		ready = fmt.Sprintf("%.1f%%", pct)
+	}
+	return fmt.Sprintf("%s at epoch %d ready=%s (ready=%d not-ready=%d unknown=%d)",
+		report.Fork.Version, report.Fork.Epoch, ready,
+		report.Total.Ready, report.Total.NotReady, report.Total.Unknown)
+}
+
+func formatTopPropagator(top *psql.PeerTopicCount) string {
+	cliName := top.ClientName
+	if cliName == "" {
+		cliName = utils.Unknown
+	}
+	return fmt.Sprintf("%s (%s) messages=%d %.1f%%", top.PeerID, cliName, top.Messages, top.Share)
+}
+
+func formatLeaders(leaders []metrics.FirstDeliveryLeader) string {
+	if len(leaders) == 0 {
+		return "none"
+	}
+	fields := make([]string, 0, len(leaders))
+	for _, leader := range leaders {
+		fields = append(fields, fmt.Sprintf("%s (%s) %.1f%%", leader.PeerID.String(), leader.ClientName, leader.Share*100))
+	}
+	return strings.Join(fields, ", ")
+}
+
+func formatTopTalkers(talkers []metrics.PeerBandwidthTotal) string {
+	if len(talkers) == 0 {
+		return "none"
+	}
+	fields := make([]string, 0, len(talkers))
+	for _, talker := range talkers {
+		fields = append(fields, fmt.Sprintf("%s (%s) in=%s out=%s",
+			talker.PeerID.String(), talker.ClientName, formatBytes(talker.In), formatBytes(talker.Out)))
+	}
+	return strings.Join(fields, ", ")
+}
+
+// formatBytes returns the given amount of bytes in the largest binary unit that keeps it over 1.
+func formatBytes(bytes uint64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%dB", bytes)
+	}
+	div, exp := uint64(unit), 0
+	for n := bytes / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(bytes)/float64(div), "KMGTPE"[exp])
+}
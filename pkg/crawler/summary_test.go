@@ -0,0 +1,276 @@
+package crawler
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/migalabs/armiarma/pkg/db/models"
+	psql "github.com/migalabs/armiarma/pkg/db/postgresql"
+	"github.com/migalabs/armiarma/pkg/metrics"
+	eth "github.com/migalabs/armiarma/pkg/networks/ethereum"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+type testPersisterStats struct {
+	queue  int
+	errors int64
+}
+
+func (s testPersisterStats) PersisterQueueDepth() int { return s.queue }
+func (s testPersisterStats) BatchErrors() int64       { return s.errors }
+
+type testDiscoveryStats uint64
+
+func (s testDiscoveryStats) ForeignEnrCount() uint64 { return uint64(s) }
+
+const goldenSummary = `---- summary 2022-06-01T12:00:00Z ----
+peers:     discovered=12 attempted=10 connected=6 currently-connected=3 relay-only=2
+funnel:    discovered=12 attempted=10 connected=6 identified=0 metadata=0
+clients:   prysm 40.0%, lighthouse 30.0%, lodestar 10.0%, nimbus 10.0%, teku 10.0%
+countries: Germany 50.0%, United States 25.0%, France 12.5%, Japan 12.5%
+messages:  beacon_attestation=30 beacon_block=12
+shared-ip: 95.217.33.1 (3), 95.217.33.2 (2)
+sessions:  <10s=0 <1m=0 <10m=3 <1h=0 <6h=0 >=6h=0 p50=1m p90=1m p99=1m
+1st-block: {peer0} (prysm) 40.0%, {peer1} (prysm) 30.0%, {peer2} (prysm) 20.0%
+bandwidth: in=3.0MiB out=512B top: {peer4} (lighthouse) in=3.0MiB out=512B, {peer5} (lighthouse) in=2.0KiB out=0B
+subnets:   connected-peers min=0 median=0
+discovery: foreign-network-enrs=7
+database:  persister-queue=42 batch-errors=3`
+
+func Test_SummaryFormat(t *testing.T) {
+	store := metrics.NewPeerStore()
+
+	clients := []string{"prysm", "prysm", "prysm", "prysm", "lighthouse", "lighthouse", "lighthouse", "teku", "lodestar", "nimbus"}
+	countries := []string{"Germany", "Germany", "Germany", "Germany", "United States", "United States", "France", "Japan"}
+	ips := []string{"95.217.33.1", "95.217.33.1", "95.217.33.1", "95.217.33.2", "95.217.33.2", "95.217.33.3", "95.217.33.4", "10.0.0.1", "10.0.0.1", "", "", ""}
+	t0 := time.Unix(1654084800, 0)
+
+	for i := 0; i < 12; i++ {
+		p := store.GetOrCreatePeer(peer.ID(fmt.Sprintf("peer%d", i)))
+		if i < len(clients) {
+			p.ClientName = clients[i]
+		}
+		if i < len(countries) {
+			p.Country = countries[i]
+		}
+		p.Ip = ips[i]
+		// the peers without IP that we never attempted are behind relays
+		p.RelayOnly = i == 10 || i == 11
+		if i < 10 {
+			p.ConnectionAttemptEvent(i < 6, "")
+		}
+		if i < 6 {
+			p.ConnectionEvent(t0)
+		}
+		if i >= 3 && i < 6 {
+			p.DisconnectionEvent(t0.Add(time.Minute))
+		}
+		if i < 3 {
+			p.MessageEvent("/eth2/4a26c58b/beacon_block/ssz_snappy", t0)
+			for j := 0; j < 9; j++ {
+				p.MessageEvent(fmt.Sprintf("/eth2/4a26c58b/beacon_attestation_%d/ssz_snappy", j), t0)
+			}
+		}
+	}
+	// extra blocks from a single peer
+	p, _ := store.GetPeer(peer.ID("peer0"))
+	for i := 0; i < 9; i++ {
+		p.MessageEvent("/eth2/4a26c58b/beacon_block/ssz_snappy", t0)
+	}
+	// first deliveries of blocks (4, 3, 2 and 1 from peer0 to peer3)
+	for i := 0; i < 4; i++ {
+		p, _ := store.GetPeer(peer.ID(fmt.Sprintf("peer%d", i)))
+		for j := 0; j < 4-i; j++ {
+			p.FirstDeliveryEvent("/eth2/4a26c58b/beacon_block/ssz_snappy")
+		}
+	}
+	// and from a peer that only sent us messages
+	p = store.GetOrCreatePeer(peer.ID("peer11"))
+	for i := 0; i < 3; i++ {
+		p.MessageEvent("/eth2/4a26c58b/beacon_attestation_9/ssz_snappy", t0)
+	}
+
+	// traffic of a gossiping peer and of a req/resp one
+	p, _ = store.GetPeer(peer.ID("peer4"))
+	p.AddBandwidth(metrics.BandwidthIn, "/meshsub/1.1.0", 3<<20)
+	p.AddBandwidth(metrics.BandwidthOut, "/meshsub/1.1.0", 512)
+	p, _ = store.GetPeer(peer.ID("peer5"))
+	p.AddBandwidth(metrics.BandwidthIn, "/eth2/beacon_chain/req/status/1/ssz_snappy", 2048)
+
+	reporter := NewSummaryReporter(context.Background(), time.Minute, "", store, testPersisterStats{queue: 42, errors: 3})
+	reporter.SetDiscoveryStats(testDiscoveryStats(7))
+	reporter.nowFn = func() time.Time {
+		return time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+	}
+
+	expected := strings.NewReplacer(
+		"{peer0}", peer.ID("peer0").String(),
+		"{peer1}", peer.ID("peer1").String(),
+		"{peer2}", peer.ID("peer2").String(),
+		"{peer4}", peer.ID("peer4").String(),
+		"{peer5}", peer.ID("peer5").String(),
+	).Replace(goldenSummary)
+	require.Equal(t, expected, reporter.Summary().Format())
+}
+
+func Test_SummaryEmptyStore(t *testing.T) {
+	reporter := NewSummaryReporter(context.Background(), time.Minute, "", metrics.NewPeerStore(), testPersisterStats{})
+	reporter.nowFn = func() time.Time {
+		return time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+	}
+	require.Equal(t, `---- summary 2022-06-01T12:00:00Z ----
+peers:     discovered=0 attempted=0 connected=0 currently-connected=0 relay-only=0
+funnel:    discovered=0 attempted=0 connected=0 identified=0 metadata=0
+clients:   none
+countries: none
+messages:  none
+shared-ip: none
+sessions:  none
+1st-block: none
+bandwidth: in=0B out=0B top: none
+subnets:   connected-peers min=0 median=0
+discovery: foreign-network-enrs=0
+database:  persister-queue=0 batch-errors=0`, reporter.Summary().Format())
+}
+
+type testForkStats struct {
+	report psql.ForkReadinessReport
+	err    error
+}
+
+func (s testForkStats) GetForkReadiness(forkVersion string) (psql.ForkReadinessReport, error) {
+	return s.report, s.err
+}
+
+func Test_SummaryForkReadiness(t *testing.T) {
+	reporter := NewSummaryReporter(context.Background(), time.Minute, "", metrics.NewPeerStore(), testPersisterStats{})
+	reporter.SetForkReadiness(testForkStats{
+		report: psql.ForkReadinessReport{
+			Fork:  eth.ScheduledFork{Version: "0x04000000", Epoch: 269568},
+			Total: eth.ForkReadinessCount{Ready: 29, NotReady: 11, Unknown: 5},
+		},
+	}, "0x04000000")
+	require.Contains(t, reporter.Summary().Format(),
+		"\nfork:      0x04000000 at epoch 269568 ready=72.5% (ready=29 not-ready=11 unknown=5)\ndatabase:")
+
+	// the readiness is left out if it can't be fetched
+	reporter.SetForkReadiness(testForkStats{err: errors.New("db down")}, "0x04000000")
+	require.NotContains(t, reporter.Summary().Format(), "fork:")
+}
+
+func Test_SummarySubnetCoverageWarnings(t *testing.T) {
+	reporter := NewSummaryReporter(context.Background(), time.Minute, "", metrics.NewPeerStore(), testPersisterStats{})
+	var coverage metrics.SubnetCoverage
+	for subnet := range coverage {
+		coverage[subnet] = 5
+	}
+	// disabled by default
+	coverage[3] = 1
+	require.Empty(t, reporter.checkSubnetCoverage(coverage))
+
+	reporter.SetSubnetCoverageThreshold(2)
+	require.Equal(t, []int{3}, reporter.checkSubnetCoverage(coverage))
+	// only the subnets that drop below are reported again
+	coverage[7] = 0
+	require.Equal(t, []int{7}, reporter.checkSubnetCoverage(coverage))
+	require.Empty(t, reporter.checkSubnetCoverage(coverage))
+	// until they recover
+	coverage[3] = 2
+	require.Empty(t, reporter.checkSubnetCoverage(coverage))
+	coverage[3] = 0
+	require.Equal(t, []int{3}, reporter.checkSubnetCoverage(coverage))
+}
+
+type testTopPeersStats struct {
+	top []psql.PeerTopicCount
+	err error
+}
+
+func (s testTopPeersStats) GetTopPeersByMessages(topic string, n int, window time.Duration) ([]psql.PeerTopicCount, error) {
+	return s.top, s.err
+}
+
+func Test_SummaryTopPropagator(t *testing.T) {
+	reporter := NewSummaryReporter(context.Background(), time.Minute, "", metrics.NewPeerStore(), testPersisterStats{})
+	reporter.SetTopPeersStats(testTopPeersStats{
+		top: []psql.PeerTopicCount{{PeerID: "16Uiu2HAm", ClientName: "lighthouse", Messages: 42, Share: 12.5}},
+	})
+	require.Contains(t, reporter.Summary().Format(),
+		"\n1st-block: none\ntop-block: 16Uiu2HAm (lighthouse) messages=42 12.5%\nbandwidth: in=0B out=0B top: none\nsubnets:")
+
+	// the top propagator is left out if there isn't any, or it can't be fetched
+	reporter.SetTopPeersStats(testTopPeersStats{})
+	require.NotContains(t, reporter.Summary().Format(), "top-block:")
+	reporter.SetTopPeersStats(testTopPeersStats{err: errors.New("db down")})
+	require.NotContains(t, reporter.Summary().Format(), "top-block:")
+}
+
+type testTopicBreadthStats struct {
+	breadth psql.TopicBreadth
+	err     error
+}
+
+func (s testTopicBreadthStats) GetTopicBreadth() (psql.TopicBreadth, error) {
+	return s.breadth, s.err
+}
+
+func Test_SummaryTopicBreadth(t *testing.T) {
+	reporter := NewSummaryReporter(context.Background(), time.Minute, "", metrics.NewPeerStore(), testPersisterStats{})
+	require.NotContains(t, reporter.Summary().Format(), "topics:")
+
+	reporter.SetTopicBreadthStats(testTopicBreadthStats{breadth: psql.TopicBreadth{Single: 120, Few: 35, Many: 8}})
+	require.Contains(t, reporter.Summary().Format(),
+		"\n1st-block: none\ntopics:    peers-per-distinct-topics 1=120 2-5=35 6+=8\nbandwidth: in=0B out=0B top: none\nsubnets:")
+
+	// the distribution is left out if it can't be fetched
+	reporter.SetTopicBreadthStats(testTopicBreadthStats{err: errors.New("db down")})
+	require.NotContains(t, reporter.Summary().Format(), "topics:")
+}
+
+func Test_SummaryNatEstimate(t *testing.T) {
+	store := metrics.NewPeerStore()
+	reporter := NewSummaryReporter(context.Background(), time.Minute, "", store, testPersisterStats{})
+	// left out until an inbound-only peer gets dialed back
+	require.NotContains(t, reporter.Summary().Format(), "nat:")
+
+	for i, success := range []bool{true, false, false, false} {
+		p := store.GetOrCreatePeer(peer.ID(fmt.Sprintf("inbound-peer-%d", i)))
+		p.ConnectionTimes = append(p.ConnectionTimes, time.Now())
+		p.DialbackEvent(models.Dialback{PeerID: p.ID, Timestamp: time.Now(), Success: success})
+	}
+	require.Contains(t, reporter.Summary().Format(),
+		"\nbandwidth: in=0B out=0B top: none\nnat:       inbound-only=4 dialed-back=4 unreachable=3 (75.0%)\nsubnets:")
+}
+
+type testDBWriteStats psql.DBStats
+
+func (s testDBWriteStats) Stats() psql.DBStats { return psql.DBStats(s) }
+
+func Test_SummaryDBWrites(t *testing.T) {
+	reporter := NewSummaryReporter(context.Background(), time.Minute, "", metrics.NewPeerStore(), testPersisterStats{queue: 4})
+	require.NotContains(t, reporter.Summary().Format(), "db-writes:")
+
+	reporter.SetDBWriteStats(testDBWriteStats{
+		AvgBatchSize: 37.25,
+		Tables: map[string]psql.TableStats{
+			"peer_info":       {Queued: 1210, Executed: 1200, RowsAffected: 1180},
+			"conn_events":     {Queued: 800, Executed: 800, RowsAffected: 800},
+			"conn_attempts":   {Queued: 650, Executed: 640, RowsAffected: 640, Errors: 2},
+			"ips":             {Queued: 40, Executed: 40, RowsAffected: 40},
+			"client_versions": {Queued: 12, Executed: 12, RowsAffected: 3},
+			"eth_blocks":      {Queued: 9, Executed: 6, RowsAffected: 6, Errors: 3},
+			"eth_status":      {Queued: 1, Executed: 0},
+		},
+	})
+	require.Contains(t, reporter.Summary().Format(),
+		"\ndb-writes: peer_info=1200 conn_events=800 conn_attempts=640 ips=40 client_versions=12 avg-batch=37.2 errors: eth_blocks=3 conn_attempts=2"+
+			"\ndatabase:  persister-queue=4 batch-errors=0")
+
+	reporter.SetDBWriteStats(testDBWriteStats{})
+	require.Contains(t, reporter.Summary().Format(), "\ndb-writes: none avg-batch=0.0 errors: none\n")
+}
@@ -0,0 +1,217 @@
+// Package dbtest has the behaviour tests that every db.Persister has to pass.
+package dbtest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/migalabs/armiarma/pkg/db"
+	"github.com/migalabs/armiarma/pkg/db/models"
+	"github.com/migalabs/armiarma/pkg/utils"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	// the persisters might store the items in batches
+	waitFor = 10 * time.Second
+	tick    = 50 * time.Millisecond
+)
+
+// TestPersister runs the behaviour tests of the persister against p, which must start empty.
+func TestPersister(t *testing.T, p db.Persister) {
+	t.Run("InvalidItems", func(t *testing.T) { testInvalidItems(t, p) })
+	t.Run("HostInfoUpsert", func(t *testing.T) { testHostInfoUpsert(t, p) })
+	t.Run("ConnAttempts", func(t *testing.T) { testConnAttempts(t, p) })
+	t.Run("Deprecation", func(t *testing.T) { testDeprecation(t, p) })
+	t.Run("IpInfo", func(t *testing.T) { testIpInfo(t, p) })
+	t.Run("ClientVersions", func(t *testing.T) { testClientVersions(t, p) })
+}
+
+func newPeerID(t *testing.T) peer.ID {
+	priv, _, err := crypto.GenerateKeyPair(crypto.Ed25519, -1)
+	require.NoError(t, err)
+	pID, err := peer.IDFromPrivateKey(priv)
+	require.NoError(t, err)
+	return pID
+}
+
+// nonDeprecatedPeers returns the peers read by ForEachNonDeprecatedPeer.
+func nonDeprecatedPeers(t *testing.T, p db.Persister) map[peer.ID]*models.RemoteConnectablePeer {
+	peers := make(map[peer.ID]*models.RemoteConnectablePeer)
+	_, err := p.ForEachNonDeprecatedPeer(context.Background(), func(connectable *models.RemoteConnectablePeer) error {
+		peers[connectable.ID] = connectable
+		return nil
+	})
+	require.NoError(t, err)
+	return peers
+}
+
+func testInvalidItems(t *testing.T, p db.Persister) {
+	pID := newPeerID(t)
+
+	require.Error(t, p.PersistToDB("peer_info"))
+	require.Error(t, p.PersistToDB(nil))
+	require.Error(t, p.PersistHostInfo(nil))
+	require.Error(t, p.PersistConnEvent(models.NewConnEvent(pID)))
+	require.Error(t, p.PersistConnAttempt(&models.ConnectionAttempt{}))
+	require.Error(t, p.PersistIpInfo(models.IpInfo{}))
+
+	// empty host infos are skipped without error
+	require.NoError(t, p.PersistHostInfo(models.NewHostInfo(pID, utils.EthereumNetwork)))
+}
+
+func testHostInfoUpsert(t *testing.T, p db.Persister) {
+	pID := newPeerID(t)
+
+	hInfo := models.NewHostInfo(pID, utils.EthereumNetwork, models.WithIPAndPorts("18.223.219.100", 9000))
+	require.NoError(t, p.PersistHostInfo(hInfo))
+	require.Eventually(t, func() bool {
+		_, ok := nonDeprecatedPeers(t, p)[pID]
+		return ok
+	}, waitFor, tick)
+
+	// the identification alone doesn't overwrite the addresses
+	identified := models.NewHostInfo(pID, utils.EthereumNetwork)
+	identified.IdentifyHost(models.NewPeerInfo(pID, "Lighthouse/v3.5.1-319cc61/x86_64-linux", "ipfs/0.1.0", nil, 0))
+	require.NoError(t, p.PersistHostInfo(identified))
+
+	// the connection events are append-only
+	for i := 0; i < 2; i++ {
+		connEvent := models.NewConnEvent(pID)
+		connEvent.AddConnInfo(models.ConnInfo{ConnTime: time.Unix(int64(1000+i*100), 0)})
+		connEvent.AddDisconn(models.EndConnInfo{DiscTime: time.Unix(int64(1060+i*100), 0)})
+		require.NoError(t, p.PersistConnEvent(connEvent))
+	}
+
+	require.Eventually(t, func() bool {
+		connectable, ok := nonDeprecatedPeers(t, p)[pID]
+		return ok && len(connectable.Addrs) == 1 && connectable.Addrs[0].String() == "/ip4/18.223.219.100/tcp/9000"
+	}, waitFor, tick)
+}
+
+func testConnAttempts(t *testing.T, p db.Persister) {
+	pID := newPeerID(t)
+	require.NoError(t, p.PersistHostInfo(models.NewHostInfo(pID, utils.EthereumNetwork, models.WithIPAndPorts("18.223.219.101", 9000))))
+	require.Eventually(t, func() bool {
+		_, ok := nonDeprecatedPeers(t, p)[pID]
+		return ok
+	}, waitFor, tick)
+
+	// a failed deprecable attempt deprecates the peer
+	failed := models.NewConnAttempt(pID, models.NegativeAttempt, "dial backoff", true, false)
+	require.NoError(t, p.PersistConnAttempt(failed))
+	require.Eventually(t, func() bool {
+		_, ok := nonDeprecatedPeers(t, p)[pID]
+		return !ok
+	}, waitFor, tick)
+
+	// the replay of the attempt is ignored, and a successful one un-deprecates it
+	require.NoError(t, p.PersistConnAttempt(failed))
+	require.NoError(t, p.PersistConnAttempt(models.NewConnAttempt(pID, models.PossitiveAttempt, "None", false, false)))
+	require.Eventually(t, func() bool {
+		_, ok := nonDeprecatedPeers(t, p)[pID]
+		return ok
+	}, waitFor, tick)
+}
+
+func testDeprecation(t *testing.T, p db.Persister) {
+	pID := newPeerID(t)
+	hInfo := models.NewHostInfo(pID, utils.EthereumNetwork, models.WithIPAndPorts("18.223.219.105", 9000))
+	require.NoError(t, p.PersistHostInfo(hInfo))
+	require.Eventually(t, func() bool {
+		_, ok := nonDeprecatedPeers(t, p)[pID]
+		return ok
+	}, waitFor, tick)
+
+	require.Error(t, p.PersistToDB(&models.Deprecation{}))
+	deprecation := models.NewDeprecation(pID, time.Now())
+	require.NoError(t, p.PersistToDB(&deprecation))
+	require.Eventually(t, func() bool {
+		_, ok := nonDeprecatedPeers(t, p)[pID]
+		return !ok
+	}, waitFor, tick)
+
+	// the rediscovery of the peer un-deprecates it
+	require.NoError(t, p.PersistHostInfo(hInfo))
+	require.Eventually(t, func() bool {
+		_, ok := nonDeprecatedPeers(t, p)[pID]
+		return ok
+	}, waitFor, tick)
+
+	// the peers of foreign networks are deprecated without checking their activity
+	deprecation = models.NewForeignNetworkDeprecation(pID)
+	require.NoError(t, p.PersistToDB(&deprecation))
+	require.Eventually(t, func() bool {
+		_, ok := nonDeprecatedPeers(t, p)[pID]
+		return !ok
+	}, waitFor, tick)
+}
+
+func testIpInfo(t *testing.T, p db.Persister) {
+	located := models.IpInfo{
+		ExpirationTime: time.Now().Add(time.Hour),
+	}
+	located.IP = "18.223.219.102"
+	located.Country = "United States"
+	located.CountryCode = "US"
+	require.NoError(t, p.PersistToDB(located))
+
+	// without a country code it has to be located again
+	unlocated := models.IpInfo{
+		ExpirationTime: time.Now().Add(time.Hour),
+	}
+	unlocated.IP = "18.223.219.103"
+	require.NoError(t, p.PersistIpInfo(unlocated))
+
+	require.Eventually(t, func() bool {
+		exists, expired, err := p.CheckIpRecords(located.IP)
+		return err == nil && exists && !expired
+	}, waitFor, tick)
+	ipInfo, err := p.ReadIpInfo(located.IP)
+	require.NoError(t, err)
+	require.Equal(t, "US", ipInfo.CountryCode)
+	require.Equal(t, "United States", ipInfo.Country)
+
+	require.Eventually(t, func() bool {
+		expIps, err := p.GetExpiredIpInfo()
+		return err == nil && contains(expIps, unlocated.IP) && !contains(expIps, located.IP)
+	}, waitFor, tick)
+
+	exists, _, err := p.CheckIpRecords("18.223.219.104")
+	require.NoError(t, err)
+	require.False(t, exists)
+	_, err = p.ReadIpInfo("18.223.219.104")
+	require.Error(t, err)
+}
+
+func testClientVersions(t *testing.T, p db.Persister) {
+	pID := newPeerID(t)
+	require.NoError(t, p.PersistToDB(models.NewClientVersion("lighthouse", "v3.5.1", pID.String())))
+	// only the first peer that showed the version is kept
+	require.NoError(t, p.PersistToDB(models.NewClientVersion("lighthouse", "v3.5.1", newPeerID(t).String())))
+
+	require.Eventually(t, func() bool {
+		versions, err := p.GetClientVersions()
+		if err != nil {
+			return false
+		}
+		for _, cliVersion := range versions {
+			if cliVersion.Name == "lighthouse" && cliVersion.Version == "v3.5.1" {
+				return cliVersion.FirstPeer == pID.String()
+			}
+		}
+		return false
+	}, waitFor, tick)
+}
+
+func contains(items []string, item string) bool {
+	for _, i := range items {
+		if i == item {
+			return true
+		}
+	}
+	return false
+}
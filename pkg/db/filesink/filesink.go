@@ -0,0 +1,412 @@
+// Package filesink persists the crawl into rotating NDJSON files, for the deployments that can't
+// run a database. Every persisted model is appended as a line of the file of its type (see
+// Record), including the upserts (host infos, IPs, ENRs within the host infos), so the files are
+// a log of what the crawler persisted: deduplicating them is left to the reader, following the
+// keys described in the sink.meta.json of the directory (see SinkMeta).
+package filesink
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/migalabs/armiarma/pkg/db"
+	"github.com/migalabs/armiarma/pkg/db/models"
+	"github.com/migalabs/armiarma/pkg/gossipsub"
+	eth "github.com/migalabs/armiarma/pkg/networks/ethereum"
+	"github.com/migalabs/armiarma/pkg/utils"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// Scheme of the endpoints of the file sinks (i.e. file:///var/lib/armiarma/crawl)
+	Scheme = "file://"
+
+	batchFlushingTimeout = 1 * time.Second
+	batchSize            = 512
+)
+
+// DefaultRotation rotates the files every 256MB or every hour, without compressing them.
+var DefaultRotation = utils.RotationPolicy{
+	MaxSize: 256 * 1024 * 1024,
+	MaxAge:  time.Hour,
+}
+
+// ShutdownTimeout bounds the time that Close gives the persister to flush what it has left.
+var ShutdownTimeout = 10 * time.Second
+
+var _ db.Persister = (*DB)(nil)
+
+// ErrUnknownPersistable is returned when persisting an item that the sink can't store.
+var ErrUnknownPersistable = errors.New("unknown type of item to persist")
+
+// ErrClosed is returned when persisting through a sink that was already closed.
+var ErrClosed = errors.New("file sink closed")
+
+// ErrNotFound is returned when reading an item that isn't in the sink.
+var ErrNotFound = errors.New("not found")
+
+// DB is the file implementation of the db.Persister. It appends the host infos, peer infos,
+// connection events and attempts, located IPs, client versions, deprecations and topic deltas
+// into a file per type, rotated with its RotationPolicy (a rotated file is always fsynced and
+// complete). The gossip messages are validated and dropped.
+// The items are written by a single persister, in batches, and what the crawler reads back (the
+// dial queue, the IPs and the client versions) is kept in memory once written.
+type DB struct {
+	ctx      context.Context
+	network  utils.NetworkType
+	dir      string
+	rotation utils.RotationPolicy
+	files    map[RecordType]*utils.RotatingFile
+	state    *state
+
+	persistC chan pendingItem
+	doneC    chan struct{}
+	wg       sync.WaitGroup
+	// no more items are queued once closed (the senders hold the read lock)
+	closeM    sync.RWMutex
+	closed    bool
+	closeOnce sync.Once
+
+	persistConnEvents bool
+
+	// avoid flooding the logs with the same error when the files can't be written
+	errSampler *utils.ErrorSampler
+	// number of batches that failed to be written (atomic)
+	batchErrors int64
+	// number of items dropped for being queued after closing the sink (atomic)
+	droppedItems int64
+}
+
+// pendingItem is an item waiting for the persister, with the time it was persisted.
+type pendingItem struct {
+	item interface{}
+	ts   time.Time
+}
+
+// Option configures the DB.
+type Option func(*DB) error
+
+// WithRotation sets when the files are rotated, and whether they are compressed.
+func WithRotation(rotation utils.RotationPolicy) Option {
+	return func(d *DB) error {
+		d.rotation = rotation
+		return nil
+	}
+}
+
+// WithConnectionEventsPersist sets whether the conn_events are written (the last activity of
+// the peers is updated anyway).
+func WithConnectionEventsPersist(persist bool) Option {
+	return func(d *DB) error {
+		d.persistConnEvents = persist
+		return nil
+	}
+}
+
+// IsEndpoint returns whether the endpoint is the one of a file sink.
+func IsEndpoint(endpoint string) bool {
+	return strings.HasPrefix(endpoint, Scheme)
+}
+
+// NewDB opens the files in the directory of the endpoint (file://dir), which is created if needed,
+// appending to the files left by a previous run, and launches the persister.
+func NewDB(ctx context.Context, network utils.NetworkType, endpoint string, opts ...Option) (*DB, error) {
+	if !IsEndpoint(endpoint) {
+		return nil, errors.New("file sink endpoint " + endpoint + " doesn't start with " + Scheme)
+	}
+	dir := strings.TrimPrefix(endpoint, Scheme)
+	if dir == "" {
+		return nil, errors.New("empty directory of the file sink endpoint")
+	}
+	d := &DB{
+		ctx:               ctx,
+		network:           network,
+		dir:               dir,
+		rotation:          DefaultRotation,
+		files:             make(map[RecordType]*utils.RotatingFile, len(recordFiles)),
+		state:             newState(),
+		persistC:          make(chan pendingItem, batchSize),
+		doneC:             make(chan struct{}),
+		persistConnEvents: true,
+		errSampler:        utils.NewErrorSampler(utils.DefaultErrorSampleWindow, nil),
+	}
+	for _, opt := range opts {
+		if err := opt(d); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, errors.Wrap(err, "unable to create the directory of the file sink")
+	}
+	if err := writeSinkMeta(dir, newSinkMeta(network, d.rotation, time.Now())); err != nil {
+		return nil, err
+	}
+	for recType := range recordFiles {
+		f, err := utils.NewRotatingFile(d.filePath(recType), d.rotation, nil, true)
+		if err != nil {
+			d.closeFiles()
+			return nil, err
+		}
+		d.files[recType] = f
+	}
+	log.WithFields(log.Fields{
+		"dir": dir,
+	}).Debug("file sink opened")
+
+	d.launchPersister()
+	return d, nil
+}
+
+// filePath returns the path of the current file of the given type of record.
+func (d *DB) filePath(recType RecordType) string {
+	return filepath.Join(d.dir, fileName(recType, d.rotation.Compress))
+}
+
+// Files returns the paths of the files of the given type of record, from the oldest to the current one.
+func (d *DB) Files(recType RecordType) []string {
+	f, ok := d.files[recType]
+	if !ok {
+		return nil
+	}
+	return append(f.RotatedFiles(), d.filePath(recType))
+}
+
+// queue sends the item to the persister. The items queued once the sink is closed (or its context
+// is done) are dropped with ErrClosed.
+func (d *DB) queue(item interface{}) error {
+	d.closeM.RLock()
+	defer d.closeM.RUnlock()
+	if d.closed || d.ctx.Err() != nil {
+		atomic.AddInt64(&d.droppedItems, 1)
+		return ErrClosed
+	}
+	select {
+	case d.persistC <- pendingItem{item: item, ts: time.Now()}:
+		return nil
+	case <-d.ctx.Done():
+		atomic.AddInt64(&d.droppedItems, 1)
+		return ErrClosed
+	}
+}
+
+func (d *DB) launchPersister() {
+	logEntry := log.WithFields(log.Fields{
+		"mod": "file-persister",
+	})
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+
+		// items to write in the next flush
+		batch := make([]pendingItem, 0, batchSize)
+
+		// batch flushing ticker
+		ticker := time.NewTicker(batchFlushingTimeout)
+		defer ticker.Stop()
+
+	persistingLoop:
+		for {
+			select {
+			case <-d.ctx.Done():
+				logEntry.Info("context died, clossing persister")
+				break persistingLoop
+			case <-d.doneC:
+				logEntry.Info("closed detected, clossing persister")
+				break persistingLoop
+
+			case obj := <-d.persistC:
+				batch = append(batch, obj)
+				if len(batch) >= batchSize {
+					logEntry.Debug("batch full, ready to persist")
+					batch = d.flush(batch)
+				}
+
+			case <-ticker.C:
+				logEntry.Trace("ticker jumped - flushing content of batch")
+				batch = d.flush(batch)
+				d.errSampler.FlushExpired()
+			}
+		}
+
+		// write what is left, bounded by the ShutdownTimeout
+		deadline := time.Now().Add(ShutdownTimeout)
+		for len(d.persistC) > 0 && time.Now().Before(deadline) {
+			batch = append(batch, <-d.persistC)
+			if len(batch) >= batchSize {
+				batch = d.flush(batch)
+			}
+		}
+		d.flush(batch)
+	}()
+}
+
+// flush appends the items of the batch to their files, returning the emptied batch. The items
+// that fail to be written are logged and skipped, without discarding the rest of the batch.
+func (d *DB) flush(batch []pendingItem) []pendingItem {
+	if len(batch) == 0 {
+		return batch
+	}
+	failed := false
+	for _, pending := range batch {
+		if err := d.write(pending); err != nil {
+			failed = true
+			d.errSampler.Error(errors.Wrapf(err, "unable to persist %T", pending.item))
+			// the state only reflects what made it into the files
+			continue
+		}
+		d.state.apply(pending.item)
+	}
+	if failed {
+		atomic.AddInt64(&d.batchErrors, 1)
+	}
+	return batch[:0]
+}
+
+func (d *DB) write(pending pendingItem) error {
+	if _, ok := pending.item.(*models.ConnEvent); ok && !d.persistConnEvents {
+		return nil
+	}
+	recType, line, err := newRecord(pending.item, pending.ts)
+	if err != nil {
+		return err
+	}
+	return d.files[recType].WriteRecord(line)
+}
+
+// Close stops the persister once it wrote the queued items (see ShutdownTimeout), and flushes,
+// fsyncs and closes the current files. It can be called more than once, and concurrently with the
+// Persist methods, which return ErrClosed afterwards.
+func (d *DB) Close() {
+	d.closeOnce.Do(d.close)
+}
+
+func (d *DB) close() {
+	d.closeM.Lock()
+	d.closed = true
+	d.closeM.Unlock()
+
+	close(d.doneC)
+	d.wg.Wait()
+	d.errSampler.Flush()
+	atomic.AddInt64(&d.droppedItems, int64(len(d.persistC)))
+	d.closeFiles()
+}
+
+func (d *DB) closeFiles() {
+	for _, f := range d.files {
+		if err := f.Close(); err != nil {
+			log.Error(errors.Wrap(err, "unable to close file sink"))
+		}
+	}
+}
+
+// PersistToDB validates the item and queues it to be written, dispatching the known models
+// to their typed Persist methods. Unknown types are rejected with ErrUnknownPersistable.
+func (d *DB) PersistToDB(persItem interface{}) error {
+	switch item := persItem.(type) {
+	case *models.HostInfo:
+		return d.PersistHostInfo(item)
+	case *models.PeerInfo:
+		return d.PersistPeerInfo(item)
+	case *models.ConnEvent:
+		return d.PersistConnEvent(item)
+	case *models.ConnectionAttempt:
+		return d.PersistConnAttempt(item)
+	case models.IpInfo:
+		return d.PersistIpInfo(item)
+	case *models.ClientVersion:
+		if item == nil {
+			return errors.New("nil client_version")
+		}
+		return d.queue(item)
+	case *models.Deprecation:
+		if item == nil || item.PeerID == "" {
+			return errors.New("deprecation without peer_id")
+		}
+		return d.queue(item)
+	case *models.TopicMetricsDelta:
+		if item == nil || item.PeerID == "" || item.Topic == "" {
+			return errors.New("topic_metrics_delta without peer_id or topic")
+		}
+		return d.queue(item)
+	case gossipsub.PersistableMsg:
+		switch item.(type) {
+		case *eth.TrackedAttestation, *eth.TrackedBeaconBlock, *eth.TrackedLightClientUpdate:
+			return nil
+		default:
+			return errors.Wrapf(ErrUnknownPersistable, "gossip message %T", persItem)
+		}
+	default:
+		return errors.Wrapf(ErrUnknownPersistable, "%T", persItem)
+	}
+}
+
+// PersistHostInfo queues the host info (and its attributes) to be written.
+// Host infos without any information besides the peer.ID are skipped.
+func (d *DB) PersistHostInfo(hInfo *models.HostInfo) error {
+	if hInfo == nil || hInfo.ID == "" {
+		return errors.New("host_info without peer_id")
+	}
+	if !hInfo.HasIdentifyingInfo() {
+		log.Tracef("skipping empty host_info of peer %s", hInfo.ID.String())
+		return nil
+	}
+	return d.queue(hInfo)
+}
+
+// PersistPeerInfo queues the identification of the peer to be written.
+func (d *DB) PersistPeerInfo(pInfo *models.PeerInfo) error {
+	if pInfo == nil || pInfo.RemotePeer == "" {
+		return errors.New("peer_info without peer_id")
+	}
+	return d.queue(pInfo)
+}
+
+// PersistConnEvent queues the finished connection (with both the connection and the disconnection) to be written.
+func (d *DB) PersistConnEvent(connEvent *models.ConnEvent) error {
+	if connEvent == nil || connEvent.PeerID == "" {
+		return errors.New("conn_event without peer_id")
+	}
+	if !connEvent.IsReadyToPersist() {
+		return errors.New("incomplete conn_event of peer " + connEvent.PeerID.String())
+	}
+	return d.queue(connEvent)
+}
+
+// PersistConnAttempt queues the connection attempt to be written.
+func (d *DB) PersistConnAttempt(connAttempt *models.ConnectionAttempt) error {
+	if connAttempt == nil || connAttempt.RemotePeer == "" {
+		return errors.New("conn_attempt without peer_id")
+	}
+	return d.queue(connAttempt)
+}
+
+// PersistIpInfo queues the location of the IP to be written.
+func (d *DB) PersistIpInfo(ipInfo models.IpInfo) error {
+	if ipInfo.IP == "" {
+		return errors.New("ip_info without ip")
+	}
+	return d.queue(ipInfo)
+}
+
+// PersisterQueueDepth returns the number of items waiting to be picked by the persister.
+func (d *DB) PersisterQueueDepth() int {
+	return len(d.persistC)
+}
+
+// BatchErrors returns the number of batches that had items failing to be written since the start.
+func (d *DB) BatchErrors() int64 {
+	return atomic.LoadInt64(&d.batchErrors)
+}
+
+// DroppedItems returns the number of items that were dropped for being persisted once the sink was closed.
+func (d *DB) DroppedItems() int64 {
+	return atomic.LoadInt64(&d.droppedItems)
+}
@@ -0,0 +1,128 @@
+package filesink
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/migalabs/armiarma/pkg/utils"
+	"github.com/pkg/errors"
+)
+
+const (
+	// FormatVersion is the version of the layout of the records, to be bumped whenever it changes
+	FormatVersion = 1
+	// MetaFile is the name of the file that describes the content of the sink directory
+	MetaFile = "sink.meta.json"
+)
+
+// SinkMeta describes the files of a sink directory, and how to deduplicate their records, as the
+// sink appends every persisted item (including the replays and the successive upserts of the
+// same item).
+type SinkMeta struct {
+	FormatVersion int    `json:"format_version"`
+	Network       string `json:"network"`
+	// "gzip" if the files are compressed
+	Compression string `json:"compression,omitempty"`
+	// limits of the files before being rotated into name-<timestamp>.ndjson (0 for no limit)
+	MaxSize        int64               `json:"max_size"`
+	MaxAge         string              `json:"max_age"`
+	StartedAt      time.Time           `json:"started_at"`
+	Files          map[string]FileMeta `json:"files"`
+	CrawlerVersion string              `json:"crawler_version"`
+}
+
+// FileMeta describes the records of a file.
+type FileMeta struct {
+	Type RecordType `json:"type"`
+	// fields of the data of the records that identify the same item
+	Key []string `json:"key,omitempty"`
+	// how the records of the same item have to be combined
+	Dedup string `json:"dedup"`
+}
+
+// fileMetas are the descriptions of the files of each type of record.
+var fileMetas = map[RecordType]FileMeta{
+	HostInfoRecord: {
+		Key:   []string{"ID"},
+		Dedup: "merge the records in order: the empty addresses don't overwrite the previous ones, the identification replaces the previous one if it has any, and the attributes are merged",
+	},
+	PeerInfoRecord: {
+		Key:   []string{"RemotePeer"},
+		Dedup: "keep the last record",
+	},
+	ConnEventRecord: {
+		Key:   []string{"EventKey"},
+		Dedup: "keep the first record, the rest are replays of the same connection",
+	},
+	ConnAttemptRecord: {
+		Key:   []string{"ID"},
+		Dedup: "keep the first record, the rest are replays of the same attempt",
+	},
+	IpInfoRecord: {
+		Key:   []string{"query"},
+		Dedup: "keep the last record",
+	},
+	ClientVersionRecord: {
+		Key:   []string{"Name", "Version"},
+		Dedup: "keep the first record, with the first peer that showed the version",
+	},
+	DeprecationRecord: {
+		Key:   []string{"PeerID"},
+		Dedup: "apply the records in order, each one is ignored (unless ForeignNetwork) if the peer had activity after its InactiveSince",
+	},
+	TopicDeltaRecord: {
+		Dedup: "none, the deltas are increments to be summed",
+	},
+}
+
+// fileName returns the name of the current file of the given type of record.
+func fileName(recType RecordType, compressed bool) string {
+	name := recordFiles[recType]
+	if compressed {
+		name += ".gz"
+	}
+	return name
+}
+
+func newSinkMeta(network utils.NetworkType, rotation utils.RotationPolicy, startedAt time.Time) SinkMeta {
+	meta := SinkMeta{
+		FormatVersion:  FormatVersion,
+		Network:        string(network),
+		MaxSize:        rotation.MaxSize,
+		MaxAge:         rotation.MaxAge.String(),
+		StartedAt:      startedAt,
+		Files:          make(map[string]FileMeta, len(recordFiles)),
+		CrawlerVersion: utils.CrawlerVersion,
+	}
+	if rotation.Compress {
+		meta.Compression = "gzip"
+	}
+	for recType := range recordFiles {
+		fileMeta := fileMetas[recType]
+		fileMeta.Type = recType
+		meta.Files[fileName(recType, rotation.Compress)] = fileMeta
+	}
+	return meta
+}
+
+// writeSinkMeta writes the SinkMeta of the directory. It's written into a temporary file that
+// replaces the previous one once complete, so that it's never half-written.
+func writeSinkMeta(dir string, meta SinkMeta) error {
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "unable to encode file sink metadata")
+	}
+	path := filepath.Join(dir, MetaFile)
+	tmpPath := path + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, data, 0644); err != nil {
+		return errors.Wrap(err, "unable to write file sink metadata")
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return errors.Wrap(err, "unable to write file sink metadata")
+	}
+	return nil
+}
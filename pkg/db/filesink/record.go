@@ -0,0 +1,260 @@
+package filesink
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/migalabs/armiarma/pkg/db/models"
+	eth "github.com/migalabs/armiarma/pkg/networks/ethereum"
+	"github.com/migalabs/armiarma/pkg/utils"
+	"github.com/pkg/errors"
+)
+
+// RecordType is the model carried by a line of the files.
+type RecordType string
+
+const (
+	HostInfoRecord      RecordType = "host_info"
+	PeerInfoRecord      RecordType = "peer_info"
+	ConnEventRecord     RecordType = "conn_event"
+	ConnAttemptRecord   RecordType = "conn_attempt"
+	IpInfoRecord        RecordType = "ip_info"
+	ClientVersionRecord RecordType = "client_version"
+	DeprecationRecord   RecordType = "deprecation"
+	TopicDeltaRecord    RecordType = "topic_metrics_delta"
+)
+
+// recordFiles are the names of the files of each type of record (before the .gz extension of the
+// compressed ones).
+var recordFiles = map[RecordType]string{
+	HostInfoRecord:      "host_info.ndjson",
+	PeerInfoRecord:      "peer_info.ndjson",
+	ConnEventRecord:     "conn_events.ndjson",
+	ConnAttemptRecord:   "attempts.ndjson",
+	IpInfoRecord:        "ips.ndjson",
+	ClientVersionRecord: "client_versions.ndjson",
+	DeprecationRecord:   "deprecations.ndjson",
+	TopicDeltaRecord:    "topic_deltas.ndjson",
+}
+
+// Record is a line of the files: the persisted model, and when it was persisted.
+type Record struct {
+	Type      RecordType      `json:"type"`
+	Timestamp time.Time       `json:"timestamp"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// ParseRecord parses a line of the files, returning the record along with its model, of the same
+// type that was persisted (i.e. *models.HostInfo for the host_info records, models.IpInfo for the
+// ip_info ones).
+func ParseRecord(line []byte) (Record, interface{}, error) {
+	var record Record
+	if err := json.Unmarshal(line, &record); err != nil {
+		return record, nil, errors.Wrap(err, "unable to parse record")
+	}
+	item, err := record.Decode()
+	return record, item, err
+}
+
+// Decode returns the model of the record.
+func (r Record) Decode() (interface{}, error) {
+	var item interface{}
+	switch r.Type {
+	case HostInfoRecord:
+		var data hostInfoData
+		if err := json.Unmarshal(r.Data, &data); err != nil {
+			return nil, errors.Wrap(err, "unable to decode host_info record")
+		}
+		return data.hostInfo()
+	case PeerInfoRecord:
+		item = new(models.PeerInfo)
+	case ConnEventRecord:
+		item = new(models.ConnEvent)
+	case ConnAttemptRecord:
+		item = new(models.ConnectionAttempt)
+	case IpInfoRecord:
+		var ipInfo models.IpInfo
+		if err := json.Unmarshal(r.Data, &ipInfo); err != nil {
+			return nil, errors.Wrap(err, "unable to decode ip_info record")
+		}
+		return ipInfo, nil
+	case ClientVersionRecord:
+		item = new(models.ClientVersion)
+	case DeprecationRecord:
+		item = new(models.Deprecation)
+	case TopicDeltaRecord:
+		item = new(models.TopicMetricsDelta)
+	default:
+		return nil, errors.Wrapf(ErrUnknownPersistable, "record type %q", r.Type)
+	}
+	if err := json.Unmarshal(r.Data, item); err != nil {
+		return nil, errors.Wrapf(err, "unable to decode %s record", r.Type)
+	}
+	return item, nil
+}
+
+// newRecord returns the line of the item (with the trailing new line), along with its type.
+func newRecord(item interface{}, ts time.Time) (RecordType, []byte, error) {
+	var recType RecordType
+	var data interface{} = item
+	switch item := item.(type) {
+	case *models.HostInfo:
+		recType = HostInfoRecord
+		data = newHostInfoData(item)
+	case *models.PeerInfo:
+		recType = PeerInfoRecord
+	case *models.ConnEvent:
+		recType = ConnEventRecord
+	case *models.ConnectionAttempt:
+		recType = ConnAttemptRecord
+	case models.IpInfo:
+		recType = IpInfoRecord
+	case *models.ClientVersion:
+		recType = ClientVersionRecord
+	case *models.Deprecation:
+		recType = DeprecationRecord
+	case *models.TopicMetricsDelta:
+		recType = TopicDeltaRecord
+	default:
+		return "", nil, errors.Wrapf(ErrUnknownPersistable, "%T", item)
+	}
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return recType, nil, errors.Wrapf(err, "unable to encode %s record", recType)
+	}
+	line, err := json.Marshal(Record{Type: recType, Timestamp: ts, Data: raw})
+	if err != nil {
+		return recType, nil, errors.Wrapf(err, "unable to encode %s record", recType)
+	}
+	return recType, append(line, '\n'), nil
+}
+
+// hostInfoData is the serialization of a HostInfo, with the multiaddresses in their string format
+// and the attributes tagged with their type.
+type hostInfoData struct {
+	ID          peer.ID
+	IP          string
+	Port        int
+	MAddrs      []string
+	RelayOnly   bool
+	Network     utils.NetworkType
+	PeerInfo    models.PeerInfo
+	ControlInfo models.ControlInfo
+	Attr        map[string]attribute
+}
+
+// attribute is a HostInfo attribute, with its Go type so that it can be decoded back. The attributes
+// of unknown types are decoded as their raw JSON.
+type attribute struct {
+	Type  string          `json:"type"`
+	Value json.RawMessage `json:"value"`
+}
+
+// enrData is the serialization of an ENR attribute, which is parsed back from its text.
+type enrData struct {
+	Timestamp      time.Time
+	Raw            string
+	ForeignNetwork bool
+}
+
+// attributeDecoders decode the values of the attribute types that the crawler persists.
+var attributeDecoders = map[string]func(json.RawMessage) (interface{}, error){
+	fmt.Sprintf("%T", models.QualityScore(0)): func(raw json.RawMessage) (interface{}, error) {
+		var score models.QualityScore
+		err := json.Unmarshal(raw, &score)
+		return score, err
+	},
+	fmt.Sprintf("%T", models.DistinctTopics(0)): func(raw json.RawMessage) (interface{}, error) {
+		var topics models.DistinctTopics
+		err := json.Unmarshal(raw, &topics)
+		return topics, err
+	},
+	fmt.Sprintf("%T", eth.BeaconStatusStamped{}): func(raw json.RawMessage) (interface{}, error) {
+		var status eth.BeaconStatusStamped
+		err := json.Unmarshal(raw, &status)
+		return status, err
+	},
+	fmt.Sprintf("%T", eth.BeaconMetadataStamped{}): func(raw json.RawMessage) (interface{}, error) {
+		var metadata eth.BeaconMetadataStamped
+		err := json.Unmarshal(raw, &metadata)
+		return metadata, err
+	},
+	fmt.Sprintf("%T", &eth.EnrNode{}): func(raw json.RawMessage) (interface{}, error) {
+		var data enrData
+		if err := json.Unmarshal(raw, &data); err != nil {
+			return nil, err
+		}
+		node, err := enode.Parse(enode.ValidSchemes, data.Raw)
+		if err != nil {
+			return nil, err
+		}
+		enrNode, err := eth.ParseEnr(node)
+		if err != nil {
+			return nil, err
+		}
+		enrNode.Timestamp = data.Timestamp
+		enrNode.ForeignNetwork = data.ForeignNetwork
+		return enrNode, nil
+	},
+}
+
+// newHostInfoData needs the host info not to be locked.
+func newHostInfoData(hInfo *models.HostInfo) hostInfoData {
+	hInfo.RLock()
+	defer hInfo.RUnlock()
+
+	data := hostInfoData{
+		ID:          hInfo.ID,
+		IP:          hInfo.IP,
+		Port:        hInfo.Port,
+		RelayOnly:   hInfo.RelayOnly,
+		Network:     hInfo.Network,
+		PeerInfo:    hInfo.PeerInfo,
+		ControlInfo: hInfo.ControlInfo,
+		Attr:        make(map[string]attribute, len(hInfo.Attr)),
+	}
+	_, data.MAddrs = utils.CanonicalMAddrs(hInfo.MAddrs)
+	for attName, att := range hInfo.Attr {
+		var value interface{} = att
+		if enrNode, ok := att.(*eth.EnrNode); ok {
+			// the public key and the parsed entries are recovered from the text of the ENR
+			value = enrData{Timestamp: enrNode.Timestamp, Raw: enrNode.Raw, ForeignNetwork: enrNode.ForeignNetwork}
+		}
+		raw, err := json.Marshal(value)
+		if err != nil {
+			// a single attribute that can't be encoded doesn't lose the rest of the host info
+			raw, _ = json.Marshal(err.Error())
+		}
+		data.Attr[attName] = attribute{Type: fmt.Sprintf("%T", att), Value: raw}
+	}
+	return data
+}
+
+func (d hostInfoData) hostInfo() (*models.HostInfo, error) {
+	hInfo := models.NewHostInfo(d.ID, d.Network)
+	mAddrs, _, err := utils.ParseCanonicalMAddrs(d.MAddrs)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to parse multiaddress of peer "+d.ID.String())
+	}
+	hInfo.SetMAddrs(mAddrs)
+	hInfo.IP = d.IP
+	hInfo.Port = d.Port
+	hInfo.RelayOnly = d.RelayOnly
+	hInfo.PeerInfo = d.PeerInfo
+	hInfo.ControlInfo = d.ControlInfo
+	for attName, att := range d.Attr {
+		hInfo.Attr[attName] = att.Value
+		decode, ok := attributeDecoders[att.Type]
+		if !ok {
+			continue
+		}
+		// the values that can't be decoded (i.e. an ENR without its text) are kept raw as well
+		if value, err := decode(att.Value); err == nil {
+			hInfo.Attr[attName] = value
+		}
+	}
+	return hInfo, nil
+}
@@ -0,0 +1,194 @@
+package filesink
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/migalabs/armiarma/pkg/db/models"
+	"github.com/migalabs/armiarma/pkg/utils"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/pkg/errors"
+)
+
+// peerState is what the crawler reads back about a peer: where to dial it and whether it's deprecated.
+type peerState struct {
+	network      utils.NetworkType
+	mAddrs       []ma.Multiaddr
+	deprecated   bool
+	lastActivity time.Time
+}
+
+// state keeps in memory the little that the crawler reads back from the DB (the dial queue, the
+// located IPs and the client versions), with the upsert semantics of the memory DB. The files
+// are never read.
+type state struct {
+	m sync.RWMutex
+
+	peers          map[peer.ID]*peerState
+	ipInfos        map[string]models.IpInfo
+	clientVersions map[string]*models.ClientVersion
+}
+
+func newState() *state {
+	return &state{
+		peers:          make(map[peer.ID]*peerState),
+		ipInfos:        make(map[string]models.IpInfo),
+		clientVersions: make(map[string]*models.ClientVersion),
+	}
+}
+
+// apply updates the state with the persisted item.
+func (s *state) apply(obj interface{}) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	switch item := obj.(type) {
+	case *models.HostInfo:
+		item.RLock()
+		defer item.RUnlock()
+		stored, ok := s.peers[item.ID]
+		if !ok {
+			stored = &peerState{network: item.Network}
+			s.peers[item.ID] = stored
+		}
+		if len(item.MAddrs) > 0 || len(item.RelayAddrs) > 0 {
+			stored.mAddrs = append([]ma.Multiaddr{}, item.MAddrs...)
+			for _, relayAddr := range item.RelayAddrs {
+				if !containsMAddr(stored.mAddrs, relayAddr) {
+					stored.mAddrs = append(stored.mAddrs, relayAddr)
+				}
+			}
+		}
+		if stored.network == "" {
+			stored.network = item.Network
+		}
+		stored.deprecated = false
+	case *models.ConnEvent:
+		if stored, ok := s.peers[item.PeerID]; ok && item.DiscTime.After(stored.lastActivity) {
+			stored.lastActivity = item.DiscTime
+		}
+	case *models.ConnectionAttempt:
+		stored, ok := s.peers[item.RemotePeer]
+		if !ok {
+			return
+		}
+		// a successful attempt un-deprecates the peer
+		stored.deprecated = item.Status != models.PossitiveAttempt && item.Deprecable
+		if item.Status == models.PossitiveAttempt {
+			stored.lastActivity = item.Timestamp
+		}
+	case *models.Deprecation:
+		// the peers active after InactiveSince are not deprecated (unless they are foreign)
+		if stored, ok := s.peers[item.PeerID]; ok && (item.ForeignNetwork || stored.lastActivity.Before(item.InactiveSince)) {
+			stored.deprecated = true
+		}
+	case models.IpInfo:
+		s.ipInfos[item.IP] = item
+	case *models.ClientVersion:
+		// only the first time that we saw the version is kept
+		key := item.Name + "/" + item.Version
+		if _, ok := s.clientVersions[key]; !ok {
+			cliVersion := *item
+			s.clientVersions[key] = &cliVersion
+		}
+	}
+}
+
+func containsMAddr(maddrs []ma.Multiaddr, maddr ma.Multiaddr) bool {
+	for _, m := range maddrs {
+		if m.Equal(maddr) {
+			return true
+		}
+	}
+	return false
+}
+
+// ReadIpInfo returns the location of the IP, or ErrNotFound if it wasn't located yet.
+func (d *DB) ReadIpInfo(ip string) (models.IpInfo, error) {
+	d.state.m.RLock()
+	defer d.state.m.RUnlock()
+	ipInfo, ok := d.state.ipInfos[ip]
+	if !ok {
+		return models.IpInfo{}, errors.Wrap(ErrNotFound, "ip_info of "+ip)
+	}
+	return ipInfo, nil
+}
+
+// CheckIpRecords checks if a given IP is already stored and whether its TTL has expired.
+// The records without a country code are reported as expired, so that they get backfilled on the next refresh.
+func (d *DB) CheckIpRecords(ip string) (exists bool, expired bool, err error) {
+	d.state.m.RLock()
+	defer d.state.m.RUnlock()
+	ipInfo, ok := d.state.ipInfos[ip]
+	if !ok {
+		return false, false, nil
+	}
+	return true, ipExpired(ipInfo, time.Now()), nil
+}
+
+// GetExpiredIpInfo returns all the IPs whose TTL has already expired (or that were stored without a country code).
+func (d *DB) GetExpiredIpInfo() ([]string, error) {
+	d.state.m.RLock()
+	defer d.state.m.RUnlock()
+	now := time.Now()
+	expIps := make([]string, 0)
+	for ip, ipInfo := range d.state.ipInfos {
+		if ipExpired(ipInfo, now) {
+			expIps = append(expIps, ip)
+		}
+	}
+	sort.Strings(expIps)
+	return expIps, nil
+}
+
+func ipExpired(ipInfo models.IpInfo, now time.Time) bool {
+	return ipInfo.ExpirationTime.Before(now) || ipInfo.CountryCode == ""
+}
+
+// ForEachNonDeprecatedPeer calls fn for each of the peers that are not deprecated, with both its
+// direct and circuit-relay addresses. Returns the number of read peers.
+func (d *DB) ForEachNonDeprecatedPeer(ctx context.Context, fn func(*models.RemoteConnectablePeer) error) (int64, error) {
+	// the peers are read before calling fn, so that fn can persist into the DB
+	d.state.m.RLock()
+	peers := make([]*models.RemoteConnectablePeer, 0, len(d.state.peers))
+	for pID, stored := range d.state.peers {
+		if stored.deprecated {
+			continue
+		}
+		maddrs := append([]ma.Multiaddr{}, stored.mAddrs...)
+		peers = append(peers, models.NewRemoteConnectablePeer(pID, maddrs, stored.network))
+	}
+	d.state.m.RUnlock()
+
+	var total int64
+	for _, connectable := range peers {
+		if err := ctx.Err(); err != nil {
+			return total, errors.Wrap(err, "unable to retrieve peers in the network")
+		}
+		total++
+		if err := fn(connectable); err != nil {
+			return total, errors.Wrap(err, "unable to retrieve peers in the network")
+		}
+	}
+	return total, nil
+}
+
+// GetClientVersions returns the client versions that were already seen, ordered by name and version.
+func (d *DB) GetClientVersions() ([]*models.ClientVersion, error) {
+	d.state.m.RLock()
+	defer d.state.m.RUnlock()
+	versions := make([]*models.ClientVersion, 0, len(d.state.clientVersions))
+	for _, cliVersion := range d.state.clientVersions {
+		v := *cliVersion
+		versions = append(versions, &v)
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		if versions[i].Name != versions[j].Name {
+			return versions[i].Name < versions[j].Name
+		}
+		return versions[i].Version < versions[j].Version
+	})
+	return versions, nil
+}
@@ -0,0 +1,391 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/migalabs/armiarma/pkg/db"
+	"github.com/migalabs/armiarma/pkg/db/models"
+	"github.com/migalabs/armiarma/pkg/gossipsub"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+var _ db.Persister = (*DB)(nil)
+
+// ErrUnknownPersistable is returned when persisting an item that the DB can't store.
+var ErrUnknownPersistable = errors.New("unknown type of item to persist")
+
+// ErrNotFound is returned when reading an item that isn't in the DB.
+var ErrNotFound = errors.New("not found")
+
+// DB is the in-memory implementation of the db.Persister, for the tests and the runs without
+// a database. It keeps the latest host info of each peer, the connection events and attempts
+// (append-only), the located IPs and the client versions, with the same upsert semantics as
+// the postgresql DBClient. The gossip messages and the topic deltas are validated and dropped.
+// It is safe for concurrent use.
+type DB struct {
+	m sync.RWMutex
+
+	hosts          map[peer.ID]*models.HostInfo
+	connEvents     map[peer.ID][]models.ConnEvent
+	connAttempts   map[peer.ID][]models.ConnectionAttempt
+	attemptIDs     map[string]struct{}
+	eventKeys      map[string]struct{}
+	ipInfos        map[string]models.IpInfo
+	clientVersions map[string]*models.ClientVersion
+}
+
+func NewDB() *DB {
+	return &DB{
+		hosts:          make(map[peer.ID]*models.HostInfo),
+		connEvents:     make(map[peer.ID][]models.ConnEvent),
+		connAttempts:   make(map[peer.ID][]models.ConnectionAttempt),
+		attemptIDs:     make(map[string]struct{}),
+		eventKeys:      make(map[string]struct{}),
+		ipInfos:        make(map[string]models.IpInfo),
+		clientVersions: make(map[string]*models.ClientVersion),
+	}
+}
+
+// PersistToDB validates the item and stores it, dispatching the known models to their typed
+// Persist methods. Unknown types are rejected with ErrUnknownPersistable.
+func (d *DB) PersistToDB(persItem interface{}) error {
+	switch item := persItem.(type) {
+	case *models.HostInfo:
+		return d.PersistHostInfo(item)
+	case *models.PeerInfo:
+		return d.PersistPeerInfo(item)
+	case *models.ConnEvent:
+		return d.PersistConnEvent(item)
+	case *models.ConnectionAttempt:
+		return d.PersistConnAttempt(item)
+	case models.IpInfo:
+		return d.PersistIpInfo(item)
+	case *models.ClientVersion:
+		if item == nil {
+			return errors.New("nil client_version")
+		}
+		d.m.Lock()
+		defer d.m.Unlock()
+		// only the first time that we saw the version is kept
+		key := item.Name + "/" + item.Version
+		if _, ok := d.clientVersions[key]; !ok {
+			cliVersion := *item
+			d.clientVersions[key] = &cliVersion
+		}
+		return nil
+	case *models.TopicMetricsDelta:
+		if item == nil || item.PeerID == "" || item.Topic == "" {
+			return errors.New("topic_metrics_delta without peer_id or topic")
+		}
+		return nil
+	case *models.Deprecation:
+		if item == nil || item.PeerID == "" {
+			return errors.New("deprecation without peer_id")
+		}
+		d.m.Lock()
+		defer d.m.Unlock()
+		// as the UPDATE of postgresql, the peers active after InactiveSince are not deprecated (unless they are foreign)
+		if stored, ok := d.hosts[item.PeerID]; ok && (item.ForeignNetwork || stored.ControlInfo.LastActivity.Before(item.InactiveSince)) {
+			stored.ControlInfo.Deprecated = true
+		}
+		return nil
+	case gossipsub.PersistableMsg:
+		return nil
+	default:
+		return errors.Wrapf(ErrUnknownPersistable, "%T", persItem)
+	}
+}
+
+// PersistHostInfo upserts the host info of the peer: the empty IPs, ports or addresses don't
+// overwrite the stored ones, the identification is replaced if it has any, and the attributes
+// are merged. Host infos without any information besides the peer.ID are skipped.
+func (d *DB) PersistHostInfo(hInfo *models.HostInfo) error {
+	if hInfo == nil || hInfo.ID == "" {
+		return errors.New("host_info without peer_id")
+	}
+	if !hInfo.HasIdentifyingInfo() {
+		log.Tracef("skipping empty host_info of peer %s", hInfo.ID.String())
+		return nil
+	}
+	hInfo.RLock()
+	defer hInfo.RUnlock()
+
+	d.m.Lock()
+	defer d.m.Unlock()
+	stored, ok := d.hosts[hInfo.ID]
+	if !ok {
+		stored = models.NewHostInfo(hInfo.ID, hInfo.Network)
+		stored.ControlInfo.RemotePeer = hInfo.ID
+		d.hosts[hInfo.ID] = stored
+	}
+	if len(hInfo.MAddrs) > 0 || len(hInfo.RelayAddrs) > 0 {
+		stored.MAddrs = append([]ma.Multiaddr{}, hInfo.MAddrs...)
+		stored.RelayAddrs = append([]ma.Multiaddr{}, hInfo.RelayAddrs...)
+		stored.RelayOnly = hInfo.RelayOnly
+	}
+	if hInfo.IP != "" {
+		stored.IP = hInfo.IP
+	}
+	if hInfo.Port > 0 {
+		stored.Port = hInfo.Port
+	}
+	if stored.Network == "" {
+		stored.Network = hInfo.Network
+	}
+	stored.ControlInfo.Deprecated = false
+	if hInfo.PeerInfo.IsPeerIdentified() {
+		stored.PeerInfo = copyPeerInfo(hInfo.PeerInfo)
+	}
+	for attName, att := range hInfo.Attr {
+		stored.Attr[attName] = att
+	}
+	return nil
+}
+
+// PersistPeerInfo replaces the identification of the peer.
+func (d *DB) PersistPeerInfo(pInfo *models.PeerInfo) error {
+	if pInfo == nil || pInfo.RemotePeer == "" {
+		return errors.New("peer_info without peer_id")
+	}
+	d.m.Lock()
+	defer d.m.Unlock()
+	stored, ok := d.hosts[pInfo.RemotePeer]
+	if !ok {
+		// as the UPDATE of postgresql, the identification alone doesn't add the peer
+		return nil
+	}
+	stored.PeerInfo = copyPeerInfo(*pInfo)
+	return nil
+}
+
+// PersistConnEvent appends the finished connection (with both the connection and the disconnection)
+// of the peer, updating its last activity. The replays of an event (same key) are only stored once.
+func (d *DB) PersistConnEvent(connEvent *models.ConnEvent) error {
+	if connEvent == nil || connEvent.PeerID == "" {
+		return errors.New("conn_event without peer_id")
+	}
+	if !connEvent.IsReadyToPersist() {
+		return errors.New("incomplete conn_event of peer " + connEvent.PeerID.String())
+	}
+	d.m.Lock()
+	defer d.m.Unlock()
+	if _, ok := d.eventKeys[connEvent.Key()]; ok {
+		return nil
+	}
+	d.eventKeys[connEvent.Key()] = struct{}{}
+	d.connEvents[connEvent.PeerID] = append(d.connEvents[connEvent.PeerID], *connEvent)
+	if stored, ok := d.hosts[connEvent.PeerID]; ok && connEvent.DiscTime.After(stored.ControlInfo.LastActivity) {
+		stored.ControlInfo.LastActivity = connEvent.DiscTime
+	}
+	return nil
+}
+
+// PersistConnAttempt appends the connection attempt to the peer, updating its control info.
+// The replays of an attempt (same id) are only counted once.
+func (d *DB) PersistConnAttempt(connAttempt *models.ConnectionAttempt) error {
+	if connAttempt == nil || connAttempt.RemotePeer == "" {
+		return errors.New("conn_attempt without peer_id")
+	}
+	d.m.Lock()
+	defer d.m.Unlock()
+	if connAttempt.ID != "" {
+		if _, ok := d.attemptIDs[connAttempt.ID]; ok {
+			return nil
+		}
+		d.attemptIDs[connAttempt.ID] = struct{}{}
+	}
+	d.connAttempts[connAttempt.RemotePeer] = append(d.connAttempts[connAttempt.RemotePeer], *connAttempt)
+	stored, ok := d.hosts[connAttempt.RemotePeer]
+	if !ok {
+		return nil
+	}
+	stored.ControlInfo.Attempted = true
+	stored.ControlInfo.LastConnAttempt = connAttempt.Timestamp
+	stored.ControlInfo.LastError = connAttempt.Error
+	// a successful attempt un-deprecates the peer
+	stored.ControlInfo.Deprecated = connAttempt.Status != models.PossitiveAttempt && connAttempt.Deprecable
+	if connAttempt.Status == models.PossitiveAttempt {
+		stored.ControlInfo.LastActivity = connAttempt.Timestamp
+	}
+	return nil
+}
+
+// PersistIpInfo stores the location of the IP, replacing the previous one.
+func (d *DB) PersistIpInfo(ipInfo models.IpInfo) error {
+	if ipInfo.IP == "" {
+		return errors.New("ip_info without ip")
+	}
+	d.m.Lock()
+	defer d.m.Unlock()
+	d.ipInfos[ipInfo.IP] = ipInfo
+	return nil
+}
+
+// ReadIpInfo returns the location of the IP, or ErrNotFound if it wasn't located yet.
+func (d *DB) ReadIpInfo(ip string) (models.IpInfo, error) {
+	d.m.RLock()
+	defer d.m.RUnlock()
+	ipInfo, ok := d.ipInfos[ip]
+	if !ok {
+		return models.IpInfo{}, errors.Wrap(ErrNotFound, "ip_info of "+ip)
+	}
+	return ipInfo, nil
+}
+
+// CheckIpRecords checks if a given IP is already stored and whether its TTL has expired.
+// The records without a country code are reported as expired, so that they get backfilled on the next refresh.
+func (d *DB) CheckIpRecords(ip string) (exists bool, expired bool, err error) {
+	d.m.RLock()
+	defer d.m.RUnlock()
+	ipInfo, ok := d.ipInfos[ip]
+	if !ok {
+		return false, false, nil
+	}
+	return true, ipExpired(ipInfo, time.Now()), nil
+}
+
+// GetExpiredIpInfo returns all the IPs whose TTL has already expired (or that were stored without a country code).
+func (d *DB) GetExpiredIpInfo() ([]string, error) {
+	d.m.RLock()
+	defer d.m.RUnlock()
+	now := time.Now()
+	expIps := make([]string, 0)
+	for ip, ipInfo := range d.ipInfos {
+		if ipExpired(ipInfo, now) {
+			expIps = append(expIps, ip)
+		}
+	}
+	sort.Strings(expIps)
+	return expIps, nil
+}
+
+func ipExpired(ipInfo models.IpInfo, now time.Time) bool {
+	return ipInfo.ExpirationTime.Before(now) || ipInfo.CountryCode == ""
+}
+
+// ForEachNonDeprecatedPeer calls fn for each of the peers that are not deprecated, with both its
+// direct and circuit-relay addresses. Returns the number of read peers.
+func (d *DB) ForEachNonDeprecatedPeer(ctx context.Context, fn func(*models.RemoteConnectablePeer) error) (int64, error) {
+	// the peers are read before calling fn, so that fn can persist into the DB
+	d.m.RLock()
+	peers := make([]*models.RemoteConnectablePeer, 0, len(d.hosts))
+	for _, stored := range d.hosts {
+		if stored.ControlInfo.Deprecated {
+			continue
+		}
+		maddrs := append([]ma.Multiaddr{}, stored.MAddrs...)
+		for _, relayAddr := range stored.RelayAddrs {
+			if !containsMAddr(maddrs, relayAddr) {
+				maddrs = append(maddrs, relayAddr)
+			}
+		}
+		peers = append(peers, models.NewRemoteConnectablePeer(stored.ID, maddrs, stored.Network))
+	}
+	d.m.RUnlock()
+
+	var total int64
+	for _, connectable := range peers {
+		if err := ctx.Err(); err != nil {
+			return total, errors.Wrap(err, "unable to retrieve peers in the network")
+		}
+		total++
+		if err := fn(connectable); err != nil {
+			return total, errors.Wrap(err, "unable to retrieve peers in the network")
+		}
+	}
+	return total, nil
+}
+
+func containsMAddr(maddrs []ma.Multiaddr, maddr ma.Multiaddr) bool {
+	for _, m := range maddrs {
+		if m.Equal(maddr) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetClientVersions returns the client versions that were already seen, ordered by name and version.
+func (d *DB) GetClientVersions() ([]*models.ClientVersion, error) {
+	d.m.RLock()
+	defer d.m.RUnlock()
+	versions := make([]*models.ClientVersion, 0, len(d.clientVersions))
+	for _, cliVersion := range d.clientVersions {
+		v := *cliVersion
+		versions = append(versions, &v)
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		if versions[i].Name != versions[j].Name {
+			return versions[i].Name < versions[j].Name
+		}
+		return versions[i].Version < versions[j].Version
+	})
+	return versions, nil
+}
+
+// GetHostInfo returns a copy of the stored host info of the peer, or ErrNotFound if the peer isn't stored.
+func (d *DB) GetHostInfo(pID peer.ID) (*models.HostInfo, error) {
+	d.m.RLock()
+	defer d.m.RUnlock()
+	stored, ok := d.hosts[pID]
+	if !ok {
+		return nil, errors.Wrap(ErrNotFound, "host_info of "+pID.String())
+	}
+	hInfo := models.NewHostInfo(stored.ID, stored.Network)
+	hInfo.IP = stored.IP
+	hInfo.Port = stored.Port
+	hInfo.MAddrs = append(hInfo.MAddrs, stored.MAddrs...)
+	hInfo.RelayAddrs = append(hInfo.RelayAddrs, stored.RelayAddrs...)
+	hInfo.RelayOnly = stored.RelayOnly
+	hInfo.PeerInfo = copyPeerInfo(stored.PeerInfo)
+	hInfo.ControlInfo = stored.ControlInfo
+	for attName, att := range stored.Attr {
+		hInfo.Attr[attName] = att
+	}
+	return hInfo, nil
+}
+
+// GetConnEvents returns the connection events of the peer, in the order they were persisted.
+func (d *DB) GetConnEvents(pID peer.ID) []models.ConnEvent {
+	d.m.RLock()
+	defer d.m.RUnlock()
+	return append([]models.ConnEvent{}, d.connEvents[pID]...)
+}
+
+// GetConnAttempts returns the connection attempts to the peer, in the order they were persisted.
+func (d *DB) GetConnAttempts(pID peer.ID) []models.ConnectionAttempt {
+	d.m.RLock()
+	defer d.m.RUnlock()
+	return append([]models.ConnectionAttempt{}, d.connAttempts[pID]...)
+}
+
+// Len returns the number of stored peers.
+func (d *DB) Len() int {
+	d.m.RLock()
+	defer d.m.RUnlock()
+	return len(d.hosts)
+}
+
+// PersisterQueueDepth is always 0, as the items are stored right away.
+func (d *DB) PersisterQueueDepth() int {
+	return 0
+}
+
+// BatchErrors is always 0, as there are no batches.
+func (d *DB) BatchErrors() int64 {
+	return 0
+}
+
+// Close is a no-op, the content of the DB is lost when the process exits.
+func (d *DB) Close() {}
+
+func copyPeerInfo(pInfo models.PeerInfo) models.PeerInfo {
+	pInfo.Protocols = append([]string{}, pInfo.Protocols...)
+	return pInfo
+}
@@ -0,0 +1,150 @@
+package memory
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/migalabs/armiarma/pkg/db/dbtest"
+	"github.com/migalabs/armiarma/pkg/db/models"
+	"github.com/migalabs/armiarma/pkg/utils"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPersister(t *testing.T) {
+	dbtest.TestPersister(t, NewDB())
+}
+
+func TestHostInfoUpsert(t *testing.T) {
+	memDB := NewDB()
+	pID, err := peer.Decode("12D3KooWLRPJAA5o6m3ZQbJsu9EVEFvLx2ke4cSg8LxpwYXmsd3d")
+	require.NoError(t, err)
+
+	_, err = memDB.GetHostInfo(pID)
+	require.True(t, errors.Is(err, ErrNotFound))
+
+	require.NoError(t, memDB.PersistHostInfo(models.NewHostInfo(pID, utils.EthereumNetwork, models.WithIPAndPorts("18.223.219.100", 9000))))
+	identified := models.NewHostInfo(pID, utils.EthereumNetwork)
+	identified.IdentifyHost(models.NewPeerInfo(pID, "Lighthouse/v3.5.1-319cc61/x86_64-linux", "", nil, 0))
+	identified.AddAtt(models.QualityScoreAttribute, models.QualityScore(80))
+	require.NoError(t, memDB.PersistHostInfo(identified))
+
+	// the latest identification and attributes, without losing the address
+	hInfo, err := memDB.GetHostInfo(pID)
+	require.NoError(t, err)
+	require.Equal(t, "18.223.219.100", hInfo.IP)
+	require.Equal(t, 9000, hInfo.Port)
+	require.Len(t, hInfo.MAddrs, 1)
+	require.Equal(t, "Lighthouse/v3.5.1-319cc61/x86_64-linux", hInfo.PeerInfo.UserAgent)
+	require.Equal(t, models.QualityScore(80), hInfo.Attr[models.QualityScoreAttribute])
+	require.Equal(t, 1, memDB.Len())
+
+	// the returned host info is a copy
+	hInfo.IP = "1.1.1.1"
+	hInfo, err = memDB.GetHostInfo(pID)
+	require.NoError(t, err)
+	require.Equal(t, "18.223.219.100", hInfo.IP)
+
+	// the connection events are appended (the replays only once), updating the last activity
+	for i := 0; i < 2; i++ {
+		connEvent := models.NewConnEvent(pID)
+		connEvent.AddConnInfo(models.ConnInfo{ConnTime: time.Unix(int64(1000+i*100), 0)})
+		connEvent.AddDisconn(models.EndConnInfo{DiscTime: time.Unix(int64(1060+i*100), 0)})
+		require.NoError(t, memDB.PersistConnEvent(connEvent))
+		require.NoError(t, memDB.PersistConnEvent(connEvent))
+	}
+	require.Len(t, memDB.GetConnEvents(pID), 2)
+	hInfo, err = memDB.GetHostInfo(pID)
+	require.NoError(t, err)
+	require.Equal(t, time.Unix(1160, 0), hInfo.ControlInfo.LastActivity)
+
+	// the replays of an attempt are counted once
+	attempt := models.NewConnAttempt(pID, models.NegativeAttempt, "dial backoff", true, false)
+	require.NoError(t, memDB.PersistConnAttempt(attempt))
+	require.NoError(t, memDB.PersistConnAttempt(attempt))
+	require.Len(t, memDB.GetConnAttempts(pID), 1)
+	hInfo, err = memDB.GetHostInfo(pID)
+	require.NoError(t, err)
+	require.True(t, hInfo.ControlInfo.Deprecated)
+	require.Equal(t, "dial backoff", hInfo.ControlInfo.LastError)
+}
+
+func TestDeprecationAfterActivity(t *testing.T) {
+	memDB := NewDB()
+	pID, err := peer.Decode("12D3KooWLRPJAA5o6m3ZQbJsu9EVEFvLx2ke4cSg8LxpwYXmsd3d")
+	require.NoError(t, err)
+	require.NoError(t, memDB.PersistHostInfo(models.NewHostInfo(pID, utils.EthereumNetwork, models.WithIPAndPorts("18.223.219.100", 9000))))
+	connEvent := models.NewConnEvent(pID)
+	connEvent.AddConnInfo(models.ConnInfo{ConnTime: time.Unix(1000, 0)})
+	connEvent.AddDisconn(models.EndConnInfo{DiscTime: time.Unix(1060, 0)})
+	require.NoError(t, memDB.PersistConnEvent(connEvent))
+
+	// the peer was active after the given time, so it isn't deprecated
+	require.NoError(t, memDB.PersistToDB(&models.Deprecation{PeerID: pID, InactiveSince: time.Unix(1030, 0)}))
+	hInfo, err := memDB.GetHostInfo(pID)
+	require.NoError(t, err)
+	require.False(t, hInfo.ControlInfo.Deprecated)
+
+	require.NoError(t, memDB.PersistToDB(&models.Deprecation{PeerID: pID, InactiveSince: time.Unix(1090, 0)}))
+	hInfo, err = memDB.GetHostInfo(pID)
+	require.NoError(t, err)
+	require.True(t, hInfo.ControlInfo.Deprecated)
+}
+
+func TestPersistToDBUnknownType(t *testing.T) {
+	memDB := NewDB()
+	for _, item := range []interface{}{
+		"peer_info",
+		struct{}{},
+		models.HostInfo{},
+		&models.IpInfo{},
+		nil,
+	} {
+		err := memDB.PersistToDB(item)
+		require.Error(t, err)
+		require.True(t, errors.Is(err, ErrUnknownPersistable), "%T", item)
+	}
+}
+
+func TestConcurrentPersist(t *testing.T) {
+	memDB := NewDB()
+	peers := make([]peer.ID, 0, 8)
+	for i := 0; i < cap(peers); i++ {
+		priv, _, err := crypto.GenerateKeyPair(crypto.Ed25519, -1)
+		require.NoError(t, err)
+		pID, err := peer.IDFromPrivateKey(priv)
+		require.NoError(t, err)
+		peers = append(peers, pID)
+	}
+
+	var wg sync.WaitGroup
+	for i, pID := range peers {
+		wg.Add(1)
+		go func(i int, pID peer.ID) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				ip := fmt.Sprintf("18.223.%d.%d", i, j)
+				require.NoError(t, memDB.PersistHostInfo(models.NewHostInfo(pID, utils.EthereumNetwork, models.WithIPAndPorts(ip, 9000))))
+				require.NoError(t, memDB.PersistConnAttempt(models.NewConnAttempt(pID, models.PossitiveAttempt, "None", false, false)))
+				ipInfo := models.IpInfo{}
+				ipInfo.IP = ip
+				require.NoError(t, memDB.PersistIpInfo(ipInfo))
+				_, err := memDB.GetExpiredIpInfo()
+				require.NoError(t, err)
+			}
+		}(i, pID)
+	}
+	wg.Wait()
+
+	require.Equal(t, len(peers), memDB.Len())
+	for _, pID := range peers {
+		require.Len(t, memDB.GetConnAttempts(pID), 100)
+	}
+	expIps, err := memDB.GetExpiredIpInfo()
+	require.NoError(t, err)
+	require.Len(t, expIps, len(peers)*100)
+}
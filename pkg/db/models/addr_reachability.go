@@ -0,0 +1,73 @@
+package models
+
+import (
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// AddrReachabilityAttribute is the HostInfo attribute with the outcome of our last dial to each address of the peer.
+const AddrReachabilityAttribute = "addr-reachability"
+
+// Transports of the dialed addresses
+const (
+	TransportTCP   = "tcp"
+	TransportQUIC  = "quic"
+	TransportOther = "other"
+)
+
+// AddrDial is the outcome of dialing one of the addresses of a peer.
+type AddrDial struct {
+	Addr ma.Multiaddr
+	// whether the dialer got to try the address (it stops at the first successful one)
+	Attempted bool
+	Success   bool
+	// category of the dial error (see hosts.ParseConError), empty if it wasn't attempted
+	Error string
+	// duration of the whole dial to the peer, the addresses are dialed concurrently
+	Duration time.Duration
+}
+
+// Transport returns the transport of the dialed address.
+func (d AddrDial) Transport() string {
+	return AddrTransport(d.Addr)
+}
+
+// AddrTransport returns whether the address is a QUIC, a TCP or any other kind of address.
+func AddrTransport(addr ma.Multiaddr) string {
+	if addr == nil {
+		return TransportOther
+	}
+	// QUIC runs over UDP, so it has to be checked first
+	if _, err := addr.ValueForProtocol(ma.P_QUIC); err == nil {
+		return TransportQUIC
+	}
+	if _, err := addr.ValueForProtocol(ma.P_TCP); err == nil {
+		return TransportTCP
+	}
+	return TransportOther
+}
+
+// AddrReachability is the outcome of a dial to a peer, address by address.
+type AddrReachability struct {
+	PeerID    peer.ID
+	Timestamp time.Time
+	Dials     []AddrDial
+}
+
+func NewAddrReachability(remotePeer peer.ID, dials []AddrDial) AddrReachability {
+	return AddrReachability{
+		PeerID:    remotePeer,
+		Timestamp: time.Now(),
+		Dials:     dials,
+	}
+}
+
+// AddrReachability returns the outcome of the last dial to each address of the host, if it was attached to it.
+func (h *HostInfo) AddrReachability() (AddrReachability, bool) {
+	h.RLock()
+	defer h.RUnlock()
+	reachability, ok := h.Attr[AddrReachabilityAttribute].(AddrReachability)
+	return reachability, ok
+}
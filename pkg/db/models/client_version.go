@@ -0,0 +1,22 @@
+package models
+
+import (
+	"time"
+)
+
+// ClientVersion is a (client name, client version) pair, with the first time that we saw it in the network
+type ClientVersion struct {
+	Name      string
+	Version   string
+	FirstPeer string
+	FirstSeen time.Time
+}
+
+func NewClientVersion(name, version, firstPeer string) *ClientVersion {
+	return &ClientVersion{
+		Name:      name,
+		Version:   version,
+		FirstPeer: firstPeer,
+		FirstSeen: time.Now(),
+	}
+}
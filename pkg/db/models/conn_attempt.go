@@ -3,6 +3,7 @@ package models
 import (
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/libp2p/go-libp2p-core/peer"
 )
 
@@ -13,8 +14,11 @@ const (
 	NegativeAttempt  AttemptStatus = "negative"
 )
 
+// NewConnAttempt returns a ConnectionAttempt to the remote peer, with the given (filtered) error
+// as the ErrorKind of the failed ones.
 func NewConnAttempt(remotePeer peer.ID, connStatus AttemptStatus, err string, dep, leftNet bool) *ConnectionAttempt {
-	return &ConnectionAttempt{
+	connAttempt := &ConnectionAttempt{
+		ID:          uuid.NewString(),
 		RemotePeer:  remotePeer,
 		Timestamp:   time.Now(),
 		Status:      connStatus,
@@ -22,14 +26,26 @@ func NewConnAttempt(remotePeer peer.ID, connStatus AttemptStatus, err string, de
 		Deprecable:  dep,
 		LeftNetwork: leftNet,
 	}
+	if connStatus != PossitiveAttempt {
+		connAttempt.ErrorKind = err
+	}
+	return connAttempt
 }
 
 // ConnectionAttempt is the basic struct that tracks the status of any proactive-attempt to connect any peer in the network
 type ConnectionAttempt struct {
-	RemotePeer  peer.ID
-	Timestamp   time.Time
-	Status      AttemptStatus
-	Error       string
+	// unique id of the attempt, so that it is only counted once even if it gets persisted twice
+	ID         string
+	RemotePeer peer.ID
+	Timestamp  time.Time
+	Status     AttemptStatus
+	Error      string
+	// category of the error of a failed attempt (see hosts.ClassifyConnError), empty for the successful ones
+	ErrorKind   string
 	Deprecable  bool
 	LeftNetwork bool
+	// delay until the next attempt to the peer chosen after this one, and the dial score of the peer
+	// then (see metrics.DialBackoff), so that the dial policy can be audited
+	DialDelay time.Duration
+	DialScore float64
 }
@@ -1,9 +1,15 @@
 package models
 
 import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
 	"time"
 
+	"github.com/libp2p/go-libp2p-core/network"
 	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/migalabs/armiarma/pkg/utils"
+	log "github.com/sirupsen/logrus"
 )
 
 type ConnDirection int8
@@ -41,6 +47,9 @@ type EventTrace struct {
 // the struct of a connection and its info to a given peer
 type ConnEvent struct {
 	PeerID peer.ID
+	// deterministic identifier of the event, set once the connection is known,
+	// so that the replays of the event are persisted only once
+	EventKey string
 
 	ConnInfo
 	EndConnInfo
@@ -53,11 +62,32 @@ type ConnInfo struct {
 	Identified bool
 	Att        map[string]interface{}
 	Error      string
+	// our listen address that the connection used, and the remote address that answered
+	LocalAddr  string
+	RemoteAddr string
+	// the connection went through a circuit relay, so its latency and location are the relay's
+	Relayed bool
+}
+
+// SetConnAddrs takes the local and remote addresses from the libp2p connection.
+func (c *ConnInfo) SetConnAddrs(conn network.ConnMultiaddrs) {
+	if addr := conn.LocalMultiaddr(); addr != nil {
+		c.LocalAddr = addr.String()
+	}
+	if addr := conn.RemoteMultiaddr(); addr != nil {
+		c.RemoteAddr = addr.String()
+		c.Relayed = utils.IsRelayMAddr(addr)
+	}
 }
 
 type EndConnInfo struct {
-	DiscTime     time.Time
+	DiscTime time.Time
+	// time connected, computed once both the connection and the disconnection are known
 	ConnDuration time.Duration
+	// the disconnection came before the connection (clock adjustments, out of order events)
+	TimestampAnomaly bool
+	// reason of the Goodbye that the peer sent right before disconnecting (if any)
+	GoodbyeReason string
 }
 
 // Create a new connection event that will summarize the interaction with a given peer
@@ -77,6 +107,10 @@ func (c *ConnEvent) AddConnInfo(connInfo ConnInfo) {
 	c.ConnTime = connInfo.ConnTime
 	c.Latency = connInfo.Latency
 	c.Identified = connInfo.Identified
+	c.LocalAddr = connInfo.LocalAddr
+	c.RemoteAddr = connInfo.RemoteAddr
+	c.Relayed = connInfo.Relayed
+	c.EventKey = ConnEventKey(c.PeerID, c.ConnTime, c.Direction)
 
 	// filter in the Error to avoid overwriting important info
 	// only write the error if it's none or err_requesting_metadata
@@ -92,22 +126,66 @@ func (c *ConnEvent) AddConnInfo(connInfo ConnInfo) {
 	}
 	// check if there was already a DisconnectionEvent to calculate the Duration
 	if c.DiscTime != (time.Time{}) {
-		c.ConnDuration = c.DiscTime.Sub(c.ConnTime)
+		c.updateDuration()
+	}
+}
+
+// Key returns the EventKey of the event, composing it if the connection info was set directly.
+// The disconnections without connection are identified by their disconnection time instead.
+func (c *ConnEvent) Key() string {
+	if c.EventKey != "" {
+		return c.EventKey
+	}
+	if c.ConnTime.IsZero() {
+		return ConnEventKey(c.PeerID, c.DiscTime, UnsetConnection)
 	}
+	return ConnEventKey(c.PeerID, c.ConnTime, c.Direction)
+}
+
+// ConnEventKey returns the md5 (hex) of "peer_id:conn_time:direction", with the connection time in seconds
+// as it is persisted, so that the keys of the already persisted events can be composed by the DB as well.
+func ConnEventKey(pID peer.ID, connTime time.Time, direction ConnDirection) string {
+	sum := md5.Sum([]byte(fmt.Sprintf("%s:%d:%s", pID.String(), connTime.Unix(), DirectionIndexToString(direction))))
+	return hex.EncodeToString(sum[:])
 }
 
 // AddDisconn aggregates the disconnection time and precalculates the total duration time
 func (c *ConnEvent) AddDisconn(discEv EndConnInfo) {
+	c.DiscTime = discEv.DiscTime
+	c.GoodbyeReason = discEv.GoodbyeReason
 	// check if the ConnectionEvent has alredy a a connection
 	if c.ConnTime != (time.Time{}) {
 		// only calculate the duration if we have the connection time and the disconnection time (same for the connections)
-		c.ConnDuration = discEv.DiscTime.Sub(c.ConnTime)
+		c.updateDuration()
 	}
-	c.DiscTime = discEv.DiscTime
 }
 
+// updateDuration calculates the duration of the connection, clamping it to zero
+// and flagging the anomaly if the disconnection is older than the connection (clock skew)
+func (c *ConnEvent) updateDuration() {
+	c.ConnDuration = c.DiscTime.Sub(c.ConnTime)
+	c.TimestampAnomaly = c.ConnDuration < 0
+	if c.TimestampAnomaly {
+		log.Warnf("disconnection of peer %s (%s) before its connection (%s), clamping its duration to zero",
+			c.PeerID.String(), c.DiscTime.Format(time.RFC3339Nano), c.ConnTime.Format(time.RFC3339Nano))
+		c.ConnDuration = 0
+	}
+}
+
+// IsOpen returns whether the connection is known but its disconnection didn't come yet.
+func (c *ConnEvent) IsOpen() bool {
+	return c.ConnTime != (time.Time{}) && c.DiscTime == (time.Time{})
+}
+
+// IsOrphanDisconn returns whether only the disconnection is known (i.e. the connection happened
+// before the crawler started, or its identification never finished).
+func (c *ConnEvent) IsOrphanDisconn() bool {
+	return c.ConnTime == (time.Time{}) && c.DiscTime != (time.Time{})
+}
+
+// IsReadyToPersist returns whether both the connection and the disconnection are known.
 func (c *ConnEvent) IsReadyToPersist() bool {
 	return (c.ConnTime != (time.Time{}) &&
 		c.DiscTime != (time.Time{}) &&
-		c.ConnDuration != time.Duration(uint64(0)))
+		(c.ConnDuration != time.Duration(uint64(0)) || c.TimestampAnomaly))
 }
@@ -0,0 +1,130 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnEventTimestampAnomaly(t *testing.T) {
+	t0 := time.Unix(1000, 0)
+
+	// regular session
+	connEv := NewConnEvent("")
+	connEv.AddConnInfo(ConnInfo{ConnTime: t0})
+	connEv.AddDisconn(EndConnInfo{DiscTime: t0.Add(time.Minute)})
+	require.False(t, connEv.TimestampAnomaly)
+	require.Equal(t, time.Minute, connEv.ConnDuration)
+	require.True(t, connEv.IsReadyToPersist())
+
+	// disconnection before the connection
+	connEv = NewConnEvent("")
+	connEv.AddConnInfo(ConnInfo{ConnTime: t0})
+	connEv.AddDisconn(EndConnInfo{DiscTime: t0.Add(-time.Minute)})
+	require.True(t, connEv.TimestampAnomaly)
+	require.Equal(t, time.Duration(0), connEv.ConnDuration)
+	require.True(t, connEv.IsReadyToPersist())
+
+	// disconnection delivered before the connection info
+	connEv = NewConnEvent("")
+	connEv.AddDisconn(EndConnInfo{DiscTime: t0})
+	require.False(t, connEv.IsReadyToPersist())
+	connEv.AddConnInfo(ConnInfo{ConnTime: t0.Add(time.Second)})
+	require.True(t, connEv.TimestampAnomaly)
+	require.Equal(t, time.Duration(0), connEv.ConnDuration)
+}
+
+func TestConnEventSessionStates(t *testing.T) {
+	t0 := time.Unix(1000, 0)
+
+	// the connection is known, the session is open until the disconnection comes
+	connEv := NewConnEvent("")
+	require.False(t, connEv.IsOpen())
+	require.False(t, connEv.IsOrphanDisconn())
+	connEv.AddConnInfo(ConnInfo{ConnTime: t0})
+	require.True(t, connEv.IsOpen())
+	require.False(t, connEv.IsReadyToPersist())
+	connEv.AddDisconn(EndConnInfo{DiscTime: t0.Add(time.Hour)})
+	require.False(t, connEv.IsOpen())
+	require.True(t, connEv.IsReadyToPersist())
+	require.Equal(t, time.Hour, connEv.ConnDuration)
+
+	// only the disconnection is known
+	pID, err := peer.Decode("12D3KooW9pdHR2n4xvYU1RBEgrJMH1kd557QSXYURzEFWeEECjGn")
+	require.NoError(t, err)
+	orphan := NewConnEvent(pID)
+	orphan.AddDisconn(EndConnInfo{DiscTime: t0})
+	require.True(t, orphan.IsOrphanDisconn())
+	require.False(t, orphan.IsOpen())
+	require.Equal(t, time.Duration(0), orphan.ConnDuration)
+	// identified by the disconnection time
+	require.Equal(t, ConnEventKey(pID, t0, UnsetConnection), orphan.Key())
+	other := NewConnEvent(pID)
+	other.AddDisconn(EndConnInfo{DiscTime: t0.Add(time.Minute)})
+	require.NotEqual(t, orphan.Key(), other.Key())
+}
+
+// testConnAddrs is a synthetic libp2p connection, only with its addresses
+type testConnAddrs struct {
+	local, remote ma.Multiaddr
+}
+
+func (c testConnAddrs) LocalMultiaddr() ma.Multiaddr  { return c.local }
+func (c testConnAddrs) RemoteMultiaddr() ma.Multiaddr { return c.remote }
+
+func TestConnInfoAddrs(t *testing.T) {
+	var cInfo ConnInfo
+	cInfo.SetConnAddrs(testConnAddrs{
+		local:  ma.StringCast("/ip4/10.0.0.2/tcp/9000"),
+		remote: ma.StringCast("/ip4/95.217.33.10/tcp/13000"),
+	})
+	connEv := NewConnEvent("")
+	connEv.AddConnInfo(cInfo)
+	require.Equal(t, "/ip4/10.0.0.2/tcp/9000", connEv.LocalAddr)
+	require.Equal(t, "/ip4/95.217.33.10/tcp/13000", connEv.RemoteAddr)
+	require.False(t, connEv.Relayed)
+
+	// connections through a circuit relay
+	cInfo = ConnInfo{}
+	cInfo.SetConnAddrs(testConnAddrs{
+		local:  ma.StringCast("/ip4/10.0.0.2/tcp/9000"),
+		remote: ma.StringCast("/ip4/95.217.33.10/tcp/4001/p2p/12D3KooW9pdHR2n4xvYU1RBEgrJMH1kd557QSXYURzEFWeEECjGn/p2p-circuit"),
+	})
+	connEv = NewConnEvent("")
+	connEv.AddConnInfo(cInfo)
+	require.True(t, connEv.Relayed)
+
+	// connections without addresses leave them empty
+	cInfo = ConnInfo{}
+	cInfo.SetConnAddrs(testConnAddrs{})
+	require.Equal(t, "", cInfo.LocalAddr)
+	require.Equal(t, "", cInfo.RemoteAddr)
+}
+
+func TestConnEventKey(t *testing.T) {
+	t0 := time.Unix(1000, 0)
+	pID, err := peer.Decode("12D3KooW9pdHR2n4xvYU1RBEgrJMH1kd557QSXYURzEFWeEECjGn")
+	require.NoError(t, err)
+
+	connEv := NewConnEvent(pID)
+	require.Empty(t, connEv.EventKey)
+	connEv.AddConnInfo(ConnInfo{ConnTime: t0, Direction: InboundConnection})
+	// md5("12D3KooW9pdH...:1000:inbound"), same as md5(peer_id || ':' || conn_time || ':' || direction) in psql
+	require.Equal(t, "b0350d1ea94a8678e402a091045f7b69", connEv.EventKey)
+	require.Equal(t, connEv.EventKey, connEv.Key())
+
+	// the key only depends on the peer, the connection time (in seconds) and the direction
+	replay := NewConnEvent(pID)
+	replay.AddConnInfo(ConnInfo{ConnTime: t0.Add(time.Millisecond), Direction: InboundConnection, Error: "None"})
+	require.Equal(t, connEv.EventKey, replay.EventKey)
+	outbound := NewConnEvent(pID)
+	outbound.AddConnInfo(ConnInfo{ConnTime: t0, Direction: OutboundConnection})
+	require.NotEqual(t, connEv.EventKey, outbound.EventKey)
+
+	// composed on the fly if the connection info was set directly
+	direct := &ConnEvent{PeerID: pID, ConnInfo: ConnInfo{ConnTime: t0, Direction: InboundConnection}}
+	require.Equal(t, connEv.EventKey, direct.Key())
+}
@@ -0,0 +1,36 @@
+package models
+
+import (
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// Deprecation marks a peer as deprecated: it has been unreachable for too long, so it is
+// left out of the dial queue and of the active metrics until it gets discovered again.
+type Deprecation struct {
+	PeerID    peer.ID
+	Timestamp time.Time
+	// the peer showed no activity after InactiveSince, the deprecation is ignored if the
+	// database has seen a more recent activity (i.e. from a concurrent rediscovery)
+	InactiveSince time.Time
+	// the peer belongs to a different network, so it is deprecated regardless of its activity
+	ForeignNetwork bool
+}
+
+func NewDeprecation(remotePeer peer.ID, inactiveSince time.Time) Deprecation {
+	return Deprecation{
+		PeerID:        remotePeer,
+		Timestamp:     time.Now(),
+		InactiveSince: inactiveSince,
+	}
+}
+
+// NewForeignNetworkDeprecation returns the Deprecation of a peer whose BeaconStatus belongs to a different network.
+func NewForeignNetworkDeprecation(remotePeer peer.ID) Deprecation {
+	return Deprecation{
+		PeerID:         remotePeer,
+		Timestamp:      time.Now(),
+		ForeignNetwork: true,
+	}
+}
@@ -0,0 +1,41 @@
+package models
+
+import (
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// DialbackAttribute is the HostInfo attribute with the outcome of our dial back to a peer that connected to us.
+const DialbackAttribute = "dialback"
+
+// Dialback is the outcome of dialing back the advertised public addresses of a peer
+// after it connected to us, which tells whether the peer is itself dialable.
+type Dialback struct {
+	PeerID    peer.ID
+	Timestamp time.Time
+	// address that answered (the last one tried if none did)
+	Addr    ma.Multiaddr
+	Success bool
+	// category of the dial error (see hosts.ParseConError), empty if it succeeded
+	Error string
+}
+
+func NewDialback(remotePeer peer.ID, addr ma.Multiaddr, dialErr string) Dialback {
+	return Dialback{
+		PeerID:    remotePeer,
+		Timestamp: time.Now(),
+		Addr:      addr,
+		Success:   dialErr == "",
+		Error:     dialErr,
+	}
+}
+
+// AddrString returns the dialed address, empty if there was none.
+func (d Dialback) AddrString() string {
+	if d.Addr == nil {
+		return ""
+	}
+	return d.Addr.String()
+}
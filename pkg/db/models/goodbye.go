@@ -0,0 +1,66 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// GoodbyeAttribute is the HostInfo attribute with the last Goodbye received from the peer.
+const GoodbyeAttribute = "goodbye"
+
+// GoodbyeCode is the reason code of a req/resp Goodbye message.
+type GoodbyeCode uint64
+
+const (
+	// codes of the consensus specs
+	GoodbyeClientShutdown    GoodbyeCode = 1
+	GoodbyeIrrelevantNetwork GoodbyeCode = 2
+	GoodbyeFaultError        GoodbyeCode = 3
+	// codes used by the clients beyond the specs
+	GoodbyeUnableToVerifyNetwork GoodbyeCode = 128
+	GoodbyeTooManyPeers          GoodbyeCode = 129
+	GoodbyeBadScore              GoodbyeCode = 250
+	GoodbyeBanned                GoodbyeCode = 251
+	GoodbyeBannedIP              GoodbyeCode = 252
+)
+
+var goodbyeReasons = map[GoodbyeCode]string{
+	GoodbyeClientShutdown:        "client_shutdown",
+	GoodbyeIrrelevantNetwork:     "irrelevant_network",
+	GoodbyeFaultError:            "fault_error",
+	GoodbyeUnableToVerifyNetwork: "unable_to_verify_network",
+	GoodbyeTooManyPeers:          "too_many_peers",
+	GoodbyeBadScore:              "bad_score",
+	GoodbyeBanned:                "banned",
+	GoodbyeBannedIP:              "banned_ip",
+}
+
+// Reason returns the name of the reason code, or "unknown_<code>" for the codes that we don't know.
+func (c GoodbyeCode) Reason() string {
+	if reason, ok := goodbyeReasons[c]; ok {
+		return reason
+	}
+	return fmt.Sprintf("unknown_%d", uint64(c))
+}
+
+// Goodbye is a Goodbye message received from a remote peer.
+type Goodbye struct {
+	PeerID    peer.ID
+	Timestamp time.Time
+	Code      GoodbyeCode
+}
+
+func NewGoodbye(remotePeer peer.ID, code uint64) Goodbye {
+	return Goodbye{
+		PeerID:    remotePeer,
+		Timestamp: time.Now(),
+		Code:      GoodbyeCode(code),
+	}
+}
+
+// Reason returns the name of the reason code of the Goodbye.
+func (g Goodbye) Reason() string {
+	return g.Code.Reason()
+}
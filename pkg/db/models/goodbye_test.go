@@ -0,0 +1,23 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGoodbyeReason(t *testing.T) {
+	for code, reason := range map[uint64]string{
+		1:   "client_shutdown",
+		2:   "irrelevant_network",
+		3:   "fault_error",
+		128: "unable_to_verify_network",
+		129: "too_many_peers",
+		250: "bad_score",
+		251: "banned",
+		252: "banned_ip",
+		42:  "unknown_42",
+	} {
+		require.Equal(t, reason, NewGoodbye("", code).Reason())
+	}
+}
@@ -1,9 +1,20 @@
 package models
 
-import "time"
+import (
+	"strings"
+	"time"
+)
 
 const (
 	IpInfoTTL = 30 * 24 * time.Hour // 30 days
+
+	// Source of the locations resolved by ip-api.com
+	LocationSourceIpApi = "ip-api"
+	// Source of the locations resolved offline from a MaxMind GeoLite2-City (or GeoIP2-City) database
+	LocationSourceMaxMind = "maxmind"
+
+	// Country of the private IPs (i.e. RFC1918), which can't be located
+	PrivateCountry = "Private"
 )
 
 // IP-API message structure
@@ -34,6 +45,13 @@ func (m *IpApiMsg) IsEmpty() bool {
 	return m.Country == "" && m.City == ""
 }
 
+// CountryCount is the number of peers located in a country, identified by its ISO 3166-1 alpha-2 code.
+type CountryCount struct {
+	CountryCode string
+	Country     string
+	Peers       int
+}
+
 type ApiResp struct {
 	IpInfo       IpInfo
 	DelayTime    time.Duration
@@ -44,4 +62,65 @@ type ApiResp struct {
 type IpInfo struct {
 	IpApiMsg
 	ExpirationTime time.Time
+	// cloud or hosting provider of the IP (empty if it isn't in the ranges of any known provider)
+	Provider string
+	// geolocation provider that located the IP, and when (empty for the IPs located before they were tracked)
+	Source     string
+	ResolvedAt time.Time
+}
+
+// Location is where an IP is, and who operates it, as resolved by a geolocation provider.
+// Fields that the provider didn't give are left empty.
+type Location struct {
+	Country     string
+	CountryCode string
+	City        string
+	Lat         float64
+	Lon         float64
+	// autonomous system of the IP (i.e. "AS24940"), and the organization that operates it
+	ASN   string
+	ASOrg string
+	ISP   string
+	// the IP belongs to a hosting provider or a data center
+	Hosting bool
+	// geolocation provider that answered, and when
+	Source     string
+	ResolvedAt time.Time
+}
+
+// IsEmpty returns true if the provider didn't locate the IP.
+func (l Location) IsEmpty() bool {
+	return l.Country == "" && l.City == ""
+}
+
+// Location returns the location of the IP.
+func (i IpInfo) Location() Location {
+	loc := Location{
+		Country:     i.Country,
+		CountryCode: i.CountryCode,
+		City:        i.City,
+		Lat:         i.Lat,
+		Lon:         i.Lon,
+		ASOrg:       i.AsName,
+		ISP:         i.Isp,
+		Hosting:     i.Hosting,
+		Source:      i.Source,
+		ResolvedAt:  i.ResolvedAt,
+	}
+	// the as field comes as "AS24940 Hetzner Online GmbH"
+	if asn, org, _ := cutSpace(i.As); strings.HasPrefix(asn, "AS") {
+		loc.ASN = asn
+		if org != "" {
+			loc.ASOrg = org
+		}
+	}
+	return loc
+}
+
+// cutSpace splits the text around the first space.
+func cutSpace(s string) (before, after string, found bool) {
+	if i := strings.Index(s, " "); i >= 0 {
+		return s[:i], strings.TrimSpace(s[i+1:]), true
+	}
+	return s, "", false
 }
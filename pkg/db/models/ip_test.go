@@ -0,0 +1,55 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIpInfoLocation(t *testing.T) {
+	resolvedAt := time.Unix(1650000000, 0)
+	ipInfo := IpInfo{
+		IpApiMsg: IpApiMsg{
+			IP:          "95.217.33.10",
+			Country:     "Finland",
+			CountryCode: "FI",
+			City:        "Helsinki",
+			Lat:         60.1719,
+			Lon:         24.9347,
+			Isp:         "Hetzner Online GmbH",
+			As:          "AS24940 Hetzner Online GmbH",
+			AsName:      "HETZNER-AS",
+			Hosting:     true,
+		},
+		Provider:   "Hetzner",
+		Source:     LocationSourceIpApi,
+		ResolvedAt: resolvedAt,
+	}
+	require.Equal(t, Location{
+		Country:     "Finland",
+		CountryCode: "FI",
+		City:        "Helsinki",
+		Lat:         60.1719,
+		Lon:         24.9347,
+		ASN:         "AS24940",
+		ASOrg:       "Hetzner Online GmbH",
+		ISP:         "Hetzner Online GmbH",
+		Hosting:     true,
+		Source:      LocationSourceIpApi,
+		ResolvedAt:  resolvedAt,
+	}, ipInfo.Location())
+
+	// the autonomous system without organization, or without number
+	ipInfo.As = "AS24940"
+	require.Equal(t, "AS24940", ipInfo.Location().ASN)
+	require.Equal(t, "HETZNER-AS", ipInfo.Location().ASOrg)
+	ipInfo.As = "Hetzner"
+	require.Empty(t, ipInfo.Location().ASN)
+
+	// located before the source was tracked
+	loc := IpInfo{IpApiMsg: IpApiMsg{IP: "86.85.31.80", CountryCode: "NL"}}.Location()
+	require.True(t, loc.IsEmpty())
+	require.Empty(t, loc.Source)
+	require.True(t, loc.ResolvedAt.IsZero())
+}
@@ -0,0 +1,9 @@
+package models
+
+// PersistableAttr is a HostInfo attribute without a table of its own, which the persister
+// keeps as JSON in the peer_attributes table (under the key of the attribute).
+// Attributes that don't implement it are stored as long as they can be marshaled into JSON.
+type PersistableAttr interface {
+	// AttrPayload returns the JSON payload of the attribute
+	AttrPayload() ([]byte, error)
+}
@@ -1,6 +1,7 @@
 package models
 
 import (
+	"encoding/hex"
 	"fmt"
 	"sync"
 	"time"
@@ -19,8 +20,27 @@ const (
 	// say that it left the network
 	// unless discv5 says the opposite
 	LeftNetworkTime = 24 * time.Hour * 60
+
+	// QualityScoreAttribute is the HostInfo attribute with the quality score of the peer,
+	// persisted by the peer_info upsert
+	QualityScoreAttribute = "quality-score"
+	// MetadataAttribute is the HostInfo attribute with the metadata that the peer answered
+	MetadataAttribute = "beaconmetadata"
+	// MaxStoredMAddrs is the number of multiaddresses kept at most per peer by the DBs, which merge
+	// the addresses of each upsert with the stored ones (dropping the oldest ones first)
+	MaxStoredMAddrs = 16
 )
 
+// AttnetsAttr is a HostInfo attribute that advertises the attestation subnets of the peer
+// (i.e. its metadata or its ENR).
+type AttnetsAttr interface {
+	// AttnetsBitvector returns the SSZ bitvector of the subnets, nil if it has none
+	AttnetsBitvector() []byte
+}
+
+// QualityScore is the 0-100 quality score of a peer (see metrics.Peer.QualityScore).
+type QualityScore float64
+
 type RemoteConnectablePeer struct {
 	ID      peer.ID
 	Addrs   []ma.Multiaddr
@@ -46,6 +66,10 @@ type HostInfo struct {
 	IP     string
 	Port   int
 	MAddrs []ma.Multiaddr
+	// circuit-relay addresses (also included in MAddrs)
+	RelayAddrs []ma.Multiaddr
+	// the peer is only reachable through circuit-relay addresses
+	RelayOnly bool
 
 	// network
 	Network utils.NetworkType
@@ -62,10 +86,11 @@ type HostInfo struct {
 // NewHostInfo returns a new structure of the PeerInfo field for the specific network passed as argk
 func NewHostInfo(peerID peer.ID, network utils.NetworkType, opts ...RemoteHostOptions) *HostInfo {
 	hInfo := &HostInfo{
-		ID:      peerID,
-		MAddrs:  make([]ma.Multiaddr, 0),
-		Network: network,
-		Attr:    make(map[string]interface{}),
+		ID:         peerID,
+		MAddrs:     make([]ma.Multiaddr, 0),
+		RelayAddrs: make([]ma.Multiaddr, 0),
+		Network:    network,
+		Attr:       make(map[string]interface{}),
 	}
 
 	// apply all the Options
@@ -96,6 +121,7 @@ func WithIPAndPorts(ip string, port int) RemoteHostOptions {
 		}
 		// add single address to the HostInfo
 		h.MAddrs = append(h.MAddrs, mAddr)
+		h.classifyAddrs()
 		return nil
 	}
 }
@@ -105,25 +131,50 @@ func WithMultiaddress(mAddrs []ma.Multiaddr) RemoteHostOptions {
 		h.Lock()
 		defer h.Unlock()
 
-		h.MAddrs = append(h.MAddrs, mAddrs...)
+		h.MAddrs, _ = utils.CanonicalMAddrs(append(h.MAddrs, mAddrs...))
+		h.classifyAddrs()
+		// the public addresses are preferred, to locate the peer
+		h.IP, h.Port = utils.SelectIPAndPort(mAddrs)
 
-		var pubIp string
-		var port int
+		return nil
+	}
+}
 
-		for _, addr := range mAddrs {
-			ip := utils.ExtractIPFromMAddr(addr)
-			if utils.IsIPPublic(ip) {
-				pubIp = ip.String()
-				port = utils.GetPortFromMaddrs(addr)
-				break
-			}
-		}
+// classifyAddrs refreshes the RelayAddrs and RelayOnly fields from the MAddrs (the caller must hold the lock).
+func (h *HostInfo) classifyAddrs() {
+	direct, relay := utils.SplitRelayMAddrs(h.MAddrs)
+	h.RelayAddrs = relay
+	h.RelayOnly = len(direct) == 0 && len(relay) > 0
+}
 
-		h.IP = pubIp
-		h.Port = port
+// SetMAddrs replaces the multiaddresses of the host, classifying the relay ones.
+func (h *HostInfo) SetMAddrs(mAddrs []ma.Multiaddr) {
+	h.Lock()
+	defer h.Unlock()
 
-		return nil
-	}
+	h.MAddrs, _ = utils.CanonicalMAddrs(mAddrs)
+	h.classifyAddrs()
+}
+
+// SetIdentifiedMAddrs replaces the multiaddresses of the host with the ones of its latest
+// identification, selecting its IP and port among them (see utils.SelectMAddr, the first ones
+// win the ties). The addresses that the peer stopped advertising are dropped.
+func (h *HostInfo) SetIdentifiedMAddrs(mAddrs []ma.Multiaddr) {
+	h.Lock()
+	defer h.Unlock()
+
+	h.MAddrs, _ = utils.CanonicalMAddrs(mAddrs)
+	h.classifyAddrs()
+	h.IP, h.Port = utils.SelectIPAndPort(mAddrs)
+}
+
+// DirectAddrs returns the multiaddresses of the host that are not circuit-relay addresses.
+func (h *HostInfo) DirectAddrs() []ma.Multiaddr {
+	h.RLock()
+	defer h.RUnlock()
+
+	direct, _ := utils.SplitRelayMAddrs(h.MAddrs)
+	return direct
 }
 
 // ComposeAddrsInfo returns the PeerId and Multiaddres in the peer.AddrsInfo format
@@ -164,6 +215,14 @@ func (h *HostInfo) IdentifyHost(pInfo *PeerInfo) {
 	h.PeerInfo = *pInfo
 }
 
+// HasIdentifyingInfo returns whether the host carries anything worth persisting
+// besides its peer.ID (addresses, identification or attributes).
+func (h *HostInfo) HasIdentifyingInfo() bool {
+	h.RLock()
+	defer h.RUnlock()
+	return h.IP != "" || h.Port > 0 || len(h.MAddrs) > 0 || h.PeerInfo.IsPeerIdentified() || len(h.Attr) > 0
+}
+
 func (h *HostInfo) IsHostIdentified() bool {
 	return h.PeerInfo.IsPeerIdentified()
 }
@@ -176,6 +235,12 @@ type PeerInfo struct {
 	ProtocolVersion string
 	Protocols       []string
 	Latency         time.Duration
+	// the identification went through a circuit relay, so the latency isn't the peer's
+	Relayed bool
+	// the user agent had to be sanitized (see SetUserAgent), and the first bytes of the
+	// raw one hex-encoded (for debugging)
+	UserAgentSanitized bool
+	RawUserAgent       string
 }
 
 func NewEmptyPeerInfo() *PeerInfo {
@@ -188,17 +253,34 @@ func NewEmptyPeerInfo() *PeerInfo {
 func NewPeerInfo(remotePeer peer.ID, userAgent, protocolVersion string, protocols []string, latency time.Duration) *PeerInfo {
 	pInfo := &PeerInfo{
 		RemotePeer:      remotePeer,
-		UserAgent:       userAgent,
 		ProtocolVersion: protocolVersion,
 		Protocols:       make([]string, 0),
 		Latency:         latency,
 	}
 
+	pInfo.SetUserAgent(userAgent)
 	pInfo.Protocols = append(pInfo.Protocols, protocols...)
 
 	return pInfo
 }
 
+// SetUserAgent sets the user agent of the peer, stripped of the control characters and truncated
+// to utils.MaxUserAgentLength. If it had to be sanitized, the raw one (up to the same length)
+// is kept hex-encoded in RawUserAgent.
+func (p *PeerInfo) SetUserAgent(userAgent string) {
+	sanitized, modified := utils.SanitizeUserAgent(userAgent)
+	p.UserAgent = sanitized
+	p.UserAgentSanitized = modified
+	p.RawUserAgent = ""
+	if modified {
+		raw := userAgent
+		if len(raw) > utils.MaxUserAgentLength {
+			raw = raw[:utils.MaxUserAgentLength]
+		}
+		p.RawUserAgent = hex.EncodeToString([]byte(raw))
+	}
+}
+
 // IsHostIdentified checks if the Peer was already identified before
 func (p *PeerInfo) IsPeerIdentified() bool {
 	return p.UserAgent != "" || p.ProtocolVersion != "" || len(p.Protocols) > 0
@@ -216,6 +298,9 @@ type ControlInfo struct {
 	LastActivity    time.Time
 	LastConnAttempt time.Time
 	LastError       string
+	// connection attempts made to the peer, and the ones that succeeded
+	Attempts           int
+	SuccessfulAttempts int
 }
 
 func NewControlInfo() *ControlInfo {
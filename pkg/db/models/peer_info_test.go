@@ -0,0 +1,31 @@
+package models
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/migalabs/armiarma/pkg/utils"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPeerInfoUserAgent(t *testing.T) {
+	pInfo := NewPeerInfo("", "Lighthouse/v3.1.0-aa022f4/x86_64-linux", "", nil, time.Duration(0))
+	require.Equal(t, "Lighthouse/v3.1.0-aa022f4/x86_64-linux", pInfo.UserAgent)
+	require.False(t, pInfo.UserAgentSanitized)
+	require.Empty(t, pInfo.RawUserAgent)
+
+	// NULs and ANSI escapes are stripped, keeping the raw agent for debugging
+	raw := "\x1b[31mPrysm\x1b[0m/v3.1.1\x00"
+	pInfo.SetUserAgent(raw)
+	require.Equal(t, "Prysm/v3.1.1", pInfo.UserAgent)
+	require.True(t, pInfo.UserAgentSanitized)
+	require.Equal(t, hex.EncodeToString([]byte(raw)), pInfo.RawUserAgent)
+
+	// absurdly long agents get truncated, and so does the raw one
+	pInfo.SetUserAgent(strings.Repeat("x", 1024*1024))
+	require.Len(t, pInfo.UserAgent, utils.MaxUserAgentLength)
+	require.True(t, pInfo.UserAgentSanitized)
+	require.Len(t, pInfo.RawUserAgent, 2*utils.MaxUserAgentLength)
+}
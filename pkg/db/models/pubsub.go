@@ -0,0 +1,54 @@
+package models
+
+import (
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// PubsubProtocolAttribute is the HostInfo attribute with the pubsub protocol negotiated with the peer.
+const PubsubProtocolAttribute = "pubsub-protocol"
+
+// protocol IDs of the pubsub routers
+const (
+	FloodSubID   = "/floodsub/1.0.0"
+	GossipSubV10 = "/meshsub/1.0.0"
+	GossipSubV11 = "/meshsub/1.1.0"
+	GossipSubV12 = "/meshsub/1.2.0"
+)
+
+var pubsubVersions = map[string]string{
+	FloodSubID:   "floodsub",
+	GossipSubV10: "v1.0",
+	GossipSubV11: "v1.1",
+	GossipSubV12: "v1.2",
+}
+
+// PubsubVersion returns the short name of the pubsub protocol ID (e.g. "v1.1" for "/meshsub/1.1.0"),
+// or the protocol ID itself for the protocols that we don't know.
+func PubsubVersion(protocolID string) string {
+	if version, ok := pubsubVersions[protocolID]; ok {
+		return version
+	}
+	return protocolID
+}
+
+// PubsubProtocol is the pubsub protocol negotiated with a remote peer.
+type PubsubProtocol struct {
+	PeerID    peer.ID
+	Timestamp time.Time
+	Protocol  string
+}
+
+func NewPubsubProtocol(remotePeer peer.ID, protocolID string) PubsubProtocol {
+	return PubsubProtocol{
+		PeerID:    remotePeer,
+		Timestamp: time.Now(),
+		Protocol:  protocolID,
+	}
+}
+
+// Version returns the short name of the negotiated protocol.
+func (p PubsubProtocol) Version() string {
+	return PubsubVersion(p.Protocol)
+}
@@ -0,0 +1,19 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPubsubVersion(t *testing.T) {
+	for protocolID, version := range map[string]string{
+		"/floodsub/1.0.0": "floodsub",
+		"/meshsub/1.0.0":  "v1.0",
+		"/meshsub/1.1.0":  "v1.1",
+		"/meshsub/1.2.0":  "v1.2",
+		"/meshsub/2.0.0":  "/meshsub/2.0.0",
+	} {
+		require.Equal(t, version, NewPubsubProtocol("", protocolID).Version())
+	}
+}
@@ -0,0 +1,67 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// StatusRequestAttribute is the HostInfo attribute with the outcome of our Status request to the peer.
+const StatusRequestAttribute = "status-request"
+
+// MetadataRequestAttribute is the HostInfo attribute with the outcome of our MetaData request to the peer.
+const MetadataRequestAttribute = "metadata-request"
+
+// ReqRespCategory is the outcome category of a req/resp request.
+type ReqRespCategory string
+
+const (
+	ReqRespSuccess       ReqRespCategory = "success"
+	ReqRespStreamReset   ReqRespCategory = "stream_reset"
+	ReqRespTimeout       ReqRespCategory = "timeout"
+	ReqRespErrorResponse ReqRespCategory = "error_response"
+	ReqRespOther         ReqRespCategory = "other"
+)
+
+// ReqRespOutcome is the outcome of a req/resp request sent to a remote peer.
+type ReqRespOutcome struct {
+	PeerID    peer.ID
+	Timestamp time.Time
+	Category  ReqRespCategory
+	// response code of the error responses (-1 otherwise)
+	ResponseCode int
+}
+
+func NewReqRespOutcome(remotePeer peer.ID, category ReqRespCategory, responseCode int) ReqRespOutcome {
+	return ReqRespOutcome{
+		PeerID:       remotePeer,
+		Timestamp:    time.Now(),
+		Category:     category,
+		ResponseCode: responseCode,
+	}
+}
+
+// Succeeded returns whether the request got a successful response.
+func (o ReqRespOutcome) Succeeded() bool {
+	return o.Category == ReqRespSuccess
+}
+
+// ErrorKey returns the key under which the failure is counted, which includes the response code
+// for the error responses (i.e. "error_response_3"). Empty if the request succeeded.
+func (o ReqRespOutcome) ErrorKey() string {
+	switch o.Category {
+	case ReqRespSuccess:
+		return ""
+	case ReqRespErrorResponse:
+		return fmt.Sprintf("%s_%d", o.Category, o.ResponseCode)
+	default:
+		return string(o.Category)
+	}
+}
+
+// MetadataRequest is the outcome of a MetaData request sent to a remote peer, kept apart from the
+// ReqRespOutcome of the Status requests.
+type MetadataRequest struct {
+	ReqRespOutcome
+}
@@ -0,0 +1,32 @@
+package models
+
+import (
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// TopicMetricsDelta is the increment of the gossip messages that a peer sent us on a topic
+// between two persistences of the message metrics.
+type TopicMetricsDelta struct {
+	PeerID          peer.ID
+	Topic           string
+	From            time.Time // zero for the first delta of the peer and topic
+	To              time.Time
+	Messages        int64
+	Bytes           int64
+	FirstDeliveries int64
+	Duplicates      int64
+}
+
+// IsZero returns whether the delta doesn't carry any increment.
+func (d *TopicMetricsDelta) IsZero() bool {
+	return d.Messages == 0 && d.Bytes == 0 && d.FirstDeliveries == 0 && d.Duplicates == 0
+}
+
+// DistinctTopicsAttribute is the HostInfo attribute with the number of distinct topics
+// (subnets collapsed into their family) on which the peer delivered messages.
+const DistinctTopicsAttribute = "distinct-topics"
+
+// DistinctTopics is the number of distinct topics on which a peer delivered messages.
+type DistinctTopics int
@@ -0,0 +1,47 @@
+package db
+
+import (
+	"context"
+
+	"github.com/migalabs/armiarma/pkg/db/models"
+)
+
+// Persister is the storage of the crawl: the postgresql DBClient, the SQLite DB of the
+// local runs, the rotating files of the filesink, or the in-memory DB of the runs without
+// a database.
+type Persister interface {
+	// PersistToDB validates the item and stores it, rejecting the types that it doesn't know
+	PersistToDB(interface{}) error
+	// PersistHostInfo upserts the latest host info of the peer (empty ones are skipped)
+	PersistHostInfo(*models.HostInfo) error
+	// PersistConnEvent appends the finished connection of the peer
+	PersistConnEvent(*models.ConnEvent) error
+	// PersistConnAttempt records the connection attempt (replays of the same attempt are counted once)
+	PersistConnAttempt(*models.ConnectionAttempt) error
+
+	// location of the IPs (see apis.DBWriter)
+	PersistIpInfo(models.IpInfo) error
+	ReadIpInfo(ip string) (models.IpInfo, error)
+	CheckIpRecords(ip string) (exists bool, expired bool, err error)
+	GetExpiredIpInfo() ([]string, error)
+
+	// ForEachNonDeprecatedPeer calls fn for each of the peers that are not deprecated,
+	// returning the number of read peers
+	ForEachNonDeprecatedPeer(ctx context.Context, fn func(*models.RemoteConnectablePeer) error) (int64, error)
+	// GetClientVersions returns the client versions that were already seen
+	GetClientVersions() ([]*models.ClientVersion, error)
+
+	// stats of the persisters (see crawler.PersisterStats)
+	PersisterQueueDepth() int
+	BatchErrors() int64
+
+	// Close stores whatever is pending and releases the storage
+	Close()
+}
+
+// SessionPersister is implemented by the persisters that also store the partial connections:
+// the sessions still open, whose disconnection gets filled once the finished connection is
+// persisted, and the disconnections whose connection is unknown (i.e. after a restart).
+type SessionPersister interface {
+	PersistPartialConnEvent(*models.ConnEvent) error
+}
@@ -21,8 +21,7 @@ func (c *DBClient) DropActivePeersTable() error {
 func (c *DBClient) InitActivePeersTable() error {
 	log.Info("init active_peers table")
 
-	_, err := c.psqlPool.Exec(
-		c.ctx,
+	err := c.execSchema(
 		`
 			CREATE TABLE IF NOT EXISTS active_peers(
 				id SERIAL,
@@ -37,18 +36,21 @@ func (c *DBClient) InitActivePeersTable() error {
 }
 
 func (c *DBClient) getActivePeers() ([]int, error) {
+	ctx, cancel := c.readCtx()
+	defer cancel()
 	activePeers := make([]int, 0)
 
 	rows, err := c.psqlPool.Query(
-		c.ctx,
+		ctx,
 		`
 		SELECT
 			id,
 			peer_id
 		FROM peer_info
-		WHERE deprecated = 'false' and attempted = 'true' and client_name IS NOT NULL and to_timestamp(last_activity) > CURRENT_TIMESTAMP - ($1 * INTERVAL '1 DAY')
+		WHERE deprecated = 'false' and attempted = 'true' and client_name IS NOT NULL and to_timestamp(last_activity) > CURRENT_TIMESTAMP - ($1 * INTERVAL '1 DAY') and network = $2
 		`,
 		LastActivityValidRange,
+		string(c.Network),
 	)
 	if err != nil {
 		return activePeers, errors.Wrap(err, "unable to retrieve active peer's ids")
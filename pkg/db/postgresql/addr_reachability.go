@@ -0,0 +1,220 @@
+package postgresql
+
+import (
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/migalabs/armiarma/pkg/db/models"
+)
+
+// AddrReachabilityEntry is the outcome of the dials to one of the addresses of a peer.
+type AddrReachabilityEntry struct {
+	PeerID      peer.ID       `json:"peer_id"`
+	Multiaddr   string        `json:"multiaddr"`
+	Transport   string        `json:"transport"`
+	Attempted   bool          `json:"attempted"`
+	Success     bool          `json:"success"`
+	Error       string        `json:"error"`
+	Duration    time.Duration `json:"duration"`
+	Attempts    int           `json:"attempts"`
+	Successes   int           `json:"successes"`
+	LastAttempt time.Time     `json:"last_attempt"`
+	LastSuccess time.Time     `json:"last_success"`
+}
+
+// TransportReachability is the number of dials to the addresses of a transport, and how many succeeded.
+type TransportReachability struct {
+	Addrs     int `json:"addrs"`
+	Attempts  int `json:"attempts"`
+	Successes int `json:"successes"`
+}
+
+// SuccessRate returns the fraction of the dials to the transport that succeeded, or false if there were none.
+func (t TransportReachability) SuccessRate() (float64, bool) {
+	if t.Attempts == 0 {
+		return 0, false
+	}
+	return float64(t.Successes) / float64(t.Attempts), true
+}
+
+// InitAddrReachabilityTable keeps the outcome of the last dial to each address of the peers,
+// and how many dials to it succeeded.
+func (d *DBClient) InitAddrReachabilityTable() error {
+	log.Debug("init addr_reachability table in psql-db")
+	err := d.execSchema(`
+		CREATE TABLE IF NOT EXISTS addr_reachability(
+			peer_id TEXT NOT NULL,
+			multiaddr TEXT NOT NULL,
+			transport TEXT NOT NULL,
+			attempted BOOL NOT NULL,
+			success BOOL NOT NULL,
+			error TEXT,
+			duration_ms BIGINT,
+			attempts INT NOT NULL,
+			successes INT NOT NULL,
+			last_attempt BIGINT,
+			last_success BIGINT,
+
+			PRIMARY KEY (peer_id, multiaddr)
+		);
+	`)
+	return err
+}
+
+// UpsertAddrDial persists the outcome of the dial to an address of the peer.
+// The addresses that weren't attempted are only added if they are new, so they don't overwrite
+// the outcome of a previous dial.
+func (d *DBClient) UpsertAddrDial(peerID peer.ID, timestamp time.Time, dial models.AddrDial) (query string, args []interface{}) {
+	log.Trace("upserting addr dial to addr_reachability in psql-db")
+	query = `
+		INSERT INTO addr_reachability(
+			peer_id,
+			multiaddr,
+			transport,
+			attempted,
+			success,
+			error,
+			duration_ms,
+			attempts,
+			successes,
+			last_attempt,
+			last_success)
+		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11)
+		ON CONFLICT (peer_id, multiaddr)
+		DO UPDATE SET
+			attempted = true,
+			success = EXCLUDED.success,
+			error = EXCLUDED.error,
+			duration_ms = EXCLUDED.duration_ms,
+			attempts = addr_reachability.attempts + 1,
+			successes = addr_reachability.successes + EXCLUDED.successes,
+			last_attempt = EXCLUDED.last_attempt,
+			last_success = COALESCE(EXCLUDED.last_success, addr_reachability.last_success)
+		WHERE EXCLUDED.attempted;
+		`
+
+	var attempts, successes int
+	var errorStr, durationMs, lastAttempt, lastSuccess interface{}
+	if dial.Attempted {
+		attempts = 1
+		errorStr = dial.Error
+		durationMs = dial.Duration.Milliseconds()
+		lastAttempt = timestamp.Unix()
+		if dial.Success {
+			successes = 1
+			lastSuccess = timestamp.Unix()
+		}
+	}
+
+	args = append(args, peerID.String())
+	args = append(args, dial.Addr.String())
+	args = append(args, dial.Transport())
+	args = append(args, dial.Attempted)
+	args = append(args, dial.Success)
+	args = append(args, errorStr)
+	args = append(args, durationMs)
+	args = append(args, attempts)
+	args = append(args, successes)
+	args = append(args, lastAttempt)
+	args = append(args, lastSuccess)
+
+	return query, args
+}
+
+// GetAddrReachability returns the outcome of the dials to each address of the peer, sorted by address.
+func (d *DBClient) GetAddrReachability(peerID peer.ID) ([]AddrReachabilityEntry, error) {
+	ctx, cancel := d.readCtx()
+	defer cancel()
+	log.Tracef("fetching addr reachability of peer %s", peerID.String())
+	entries := make([]AddrReachabilityEntry, 0)
+
+	rows, err := d.psqlPool.Query(ctx, `
+		SELECT multiaddr, transport, attempted, success, error, duration_ms, attempts, successes, last_attempt, last_success
+		FROM addr_reachability
+		WHERE peer_id = $1
+		ORDER BY multiaddr;
+		`, peerID.String())
+	if err != nil {
+		return entries, errors.Wrap(err, "unable to fetch addr reachability of peer "+peerID.String())
+	}
+	// make sure we close the rows and we free the connection/session
+	defer rows.Close()
+
+	for rows.Next() {
+		entry := AddrReachabilityEntry{PeerID: peerID}
+		var errorStr *string
+		var durationMs, lastAttempt, lastSuccess *int64
+		err = rows.Scan(
+			&entry.Multiaddr,
+			&entry.Transport,
+			&entry.Attempted,
+			&entry.Success,
+			&errorStr,
+			&durationMs,
+			&entry.Attempts,
+			&entry.Successes,
+			&lastAttempt,
+			&lastSuccess,
+		)
+		if err != nil {
+			return entries, errors.Wrap(err, "unable to parse fetched addr reachability")
+		}
+		if errorStr != nil {
+			entry.Error = *errorStr
+		}
+		if durationMs != nil {
+			entry.Duration = time.Duration(*durationMs) * time.Millisecond
+		}
+		if lastAttempt != nil {
+			entry.LastAttempt = time.Unix(*lastAttempt, 0)
+		}
+		if lastSuccess != nil {
+			entry.LastSuccess = time.Unix(*lastSuccess, 0)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+// GetTransportSuccessRate returns the number of dials to the addresses of each transport (tcp, quic or other)
+// and how many of them succeeded.
+func (d *DBClient) GetTransportSuccessRate() (map[string]TransportReachability, error) {
+	ctx, cancel := d.readCtx()
+	defer cancel()
+	log.Debug("fetching dial success rate per transport")
+	transports := make(map[string]TransportReachability)
+
+	rows, err := d.psqlPool.Query(ctx, `
+		SELECT
+			transport,
+			COUNT(*) AS addrs,
+			SUM(attempts) AS attempts,
+			SUM(successes) AS successes
+		FROM addr_reachability
+		WHERE attempted = true
+		GROUP BY transport;
+		`)
+	if err != nil {
+		return transports, errors.Wrap(err, "unable to fetch dial success rate per transport")
+	}
+	// make sure we close the rows and we free the connection/session
+	defer rows.Close()
+
+	for rows.Next() {
+		var transport string
+		var addrs, attempts, successes int64
+		err = rows.Scan(&transport, &addrs, &attempts, &successes)
+		if err != nil {
+			return transports, errors.Wrap(err, "unable to parse fetched dial success rate")
+		}
+		transports[transport] = TransportReachability{
+			Addrs:     int(addrs),
+			Attempts:  int(attempts),
+			Successes: int(successes),
+		}
+	}
+	return transports, rows.Err()
+}
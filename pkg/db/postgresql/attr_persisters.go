@@ -0,0 +1,70 @@
+package postgresql
+
+import (
+	"reflect"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrAttrPersisterExists is returned when registering a second persister for the same type of attribute.
+var ErrAttrPersisterExists = errors.New("attribute persister already registered")
+
+// AttrQuery is a statement that persists (part of) an attribute of a HostInfo.
+type AttrQuery struct {
+	Query string
+	Args  []interface{}
+}
+
+// AttrPersister returns the statements that persist the attribute of the given peer. If it returns
+// an error, none of its statements are added to the batch.
+type AttrPersister func(pID peer.ID, attr interface{}) ([]AttrQuery, error)
+
+// RegisterAttrPersister registers the persister of the HostInfo attributes of the same type as
+// sample, so that new types of attributes don't need their own case in the persisters. The
+// registered persisters are consulted before the built-in ones, and they can be registered while
+// the persisters are running (applying to the items added to the batches afterwards).
+func (c *DBClient) RegisterAttrPersister(sample interface{}, fn AttrPersister) error {
+	if sample == nil || fn == nil {
+		return errors.New("attribute persister needs a sample of the attribute and a function")
+	}
+	attrType := reflect.TypeOf(sample)
+	c.attrPersistersM.Lock()
+	defer c.attrPersistersM.Unlock()
+	if _, ok := c.attrPersisters[attrType]; ok {
+		return errors.Wrap(ErrAttrPersisterExists, attrType.String())
+	}
+	if c.attrPersisters == nil {
+		c.attrPersisters = make(map[reflect.Type]AttrPersister)
+	}
+	c.attrPersisters[attrType] = fn
+	return nil
+}
+
+// attrPersister returns the persister registered for the type of the attribute, if any.
+func (c *DBClient) attrPersister(attr interface{}) (AttrPersister, bool) {
+	c.attrPersistersM.RLock()
+	defer c.attrPersistersM.RUnlock()
+	fn, ok := c.attrPersisters[reflect.TypeOf(attr)]
+	return fn, ok
+}
+
+// addAttrToBatch adds the statements of the registered persister of the attribute to the batch,
+// returning false if there is no persister for its type. The attributes whose persister fails
+// are skipped, without affecting the rest of the batch.
+func (c *DBClient) addAttrToBatch(batch *QueryBatch, pID peer.ID, attName string, attr interface{}) bool {
+	fn, ok := c.attrPersister(attr)
+	if !ok {
+		return false
+	}
+	queries, err := fn(pID, attr)
+	if err != nil {
+		log.Warnf("unable to persist attr %s - %T of peer %s: %s", attName, attr, pID.String(), err.Error())
+		return true
+	}
+	for _, q := range queries {
+		batch.AddQuery(q.Query, q.Args...)
+	}
+	return true
+}
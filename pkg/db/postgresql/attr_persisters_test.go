@@ -0,0 +1,91 @@
+package postgresql
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/migalabs/armiarma/pkg/db/models"
+	eth "github.com/migalabs/armiarma/pkg/networks/ethereum"
+	"github.com/migalabs/armiarma/pkg/utils"
+	"github.com/pkg/errors"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeProbe struct {
+	Protocol string
+}
+
+type failingProbe struct{}
+
+func TestRegisterAttrPersister(t *testing.T) {
+	dbCli := &DBClient{
+		Network:        utils.EthereumNetwork,
+		attnetsChecker: eth.NewAttnetsChecker(),
+		addrChecker:    eth.NewAddrChecker(false),
+	}
+	pID, err := peer.Decode("12D3KooWLRPJAA5o6m3ZQbJsu9EVEFvLx2ke4cSg8LxpwYXmsd3d")
+	require.NoError(t, err)
+
+	probeFn := func(pID peer.ID, attr interface{}) ([]AttrQuery, error) {
+		probe := attr.(fakeProbe)
+		return []AttrQuery{{
+			Query: `UPDATE fake_probes SET protocol=$2 WHERE peer_id=$1;`,
+			Args:  []interface{}{pID.String(), probe.Protocol},
+		}}, nil
+	}
+	require.NoError(t, dbCli.RegisterAttrPersister(fakeProbe{}, probeFn))
+	require.True(t, errors.Is(dbCli.RegisterAttrPersister(fakeProbe{Protocol: "other"}, probeFn), ErrAttrPersisterExists))
+	require.Error(t, dbCli.RegisterAttrPersister(nil, probeFn))
+	require.Error(t, dbCli.RegisterAttrPersister(failingProbe{}, nil))
+	require.NoError(t, dbCli.RegisterAttrPersister(failingProbe{}, func(peer.ID, interface{}) ([]AttrQuery, error) {
+		return []AttrQuery{{Query: `UPDATE failing_probes SET failed=true;`}}, errors.New("probe failed")
+	}))
+	// the ethereum ones can't be registered twice either
+	require.NoError(t, dbCli.registerEthAttrPersisters())
+	require.True(t, errors.Is(dbCli.registerEthAttrPersisters(), ErrAttrPersisterExists))
+
+	hInfo := models.NewHostInfo(pID, utils.EthereumNetwork, models.WithIPAndPorts("18.223.219.100", 9000))
+	hInfo.AddAtt("fake-probe", fakeProbe{Protocol: "/fake/probe/1"})
+	hInfo.AddAtt("failing-probe", failingProbe{})
+	hInfo.AddAtt("beacon-status", eth.NewBeaconStatus(pID, common.Status{FinalizedEpoch: 1000}))
+	hInfo.AddAtt(models.QualityScoreAttribute, models.QualityScore(80))
+	batch := NewQueryBatch(context.Background(), nil, batchSize, DefaultBatchTimeout)
+	dbCli.addToBatch(batch, hInfo)
+
+	// the failing attribute is skipped, without affecting the rest of the host info
+	tables := make(map[string]int)
+	for _, q := range batch.queries {
+		tables[q.table]++
+		if q.table == "fake_probes" {
+			require.Equal(t, []interface{}{pID.String(), "/fake/probe/1"}, q.args)
+		}
+	}
+	require.Equal(t, map[string]int{"peer_info": 1, "fake_probes": 1, "eth_status": 1}, tables)
+}
+
+func TestRegisterAttrPersisterWhilePersisting(t *testing.T) {
+	dbCli := &DBClient{Network: utils.EthereumNetwork}
+	pID, err := peer.Decode("12D3KooWLRPJAA5o6m3ZQbJsu9EVEFvLx2ke4cSg8LxpwYXmsd3d")
+	require.NoError(t, err)
+	hInfo := models.NewHostInfo(pID, utils.EthereumNetwork, models.WithIPAndPorts("18.223.219.100", 9000))
+	hInfo.AddAtt("fake-probe", fakeProbe{Protocol: "/fake/probe/1"})
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		batch := NewQueryBatch(context.Background(), nil, batchSize, DefaultBatchTimeout)
+		for i := 0; i < 100; i++ {
+			dbCli.addToBatch(batch, hInfo)
+		}
+	}()
+	require.NoError(t, dbCli.RegisterAttrPersister(fakeProbe{}, func(pID peer.ID, attr interface{}) ([]AttrQuery, error) {
+		return nil, nil
+	}))
+	wg.Wait()
+	_, ok := dbCli.attrPersister(fakeProbe{})
+	require.True(t, ok)
+}
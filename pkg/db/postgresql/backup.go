@@ -0,0 +1,368 @@
+package postgresql
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	pgx "github.com/jackc/pgx/v4"
+	"github.com/migalabs/armiarma/pkg/utils"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// BackupSchemaVersion is the version of the layout of the backup archives (and of the tables inside)
+	BackupSchemaVersion = 1
+	// BackupManifestFile is the first entry of the backup archives
+	BackupManifestFile = "manifest.json"
+)
+
+// backupTable is a table included in the backups, and whether it has a SERIAL id to restore.
+type backupTable struct {
+	name     string
+	serialID bool
+}
+
+// backupTables returns the crawl tables of the network, in restoring order.
+func backupTables(network utils.NetworkType) []backupTable {
+	tables := []backupTable{
+		{"peer_info", true},
+		{"conn_events", true},
+		{"conn_attempts", false},
+		{"ips", true},
+		{"active_peers", true},
+		{"client_versions", false},
+		{"addr_reachability", false},
+		{"topic_metrics_deltas", true},
+		{"peer_attributes", false},
+	}
+	if network == utils.EthereumNetwork {
+		tables = append(tables,
+			backupTable{"eth_nodes", true},
+			backupTable{"eth_status", true},
+			backupTable{"metadata_history", false},
+			backupTable{"eth_attestations", true},
+			backupTable{"eth_blocks", true},
+			backupTable{"eth_light_client_updates", true},
+		)
+	}
+	return tables
+}
+
+// BackupManifest describes the content of a backup archive.
+type BackupManifest struct {
+	SchemaVersion int               `json:"schema_version"`
+	Network       utils.NetworkType `json:"network"`
+	CreatedAt     time.Time         `json:"created_at"`
+	// time range of the crawled connections
+	From   time.Time           `json:"from"`
+	To     time.Time           `json:"to"`
+	Tables []BackupTableRecord `json:"tables"`
+}
+
+// BackupTableRecord is a table (CSV file) of the backup archive.
+type BackupTableRecord struct {
+	Name    string   `json:"name"`
+	File    string   `json:"file"`
+	Columns []string `json:"columns"`
+	Rows    int64    `json:"rows"`
+}
+
+// Backup writes all the crawl tables into w (see BackupContext).
+func (c *DBClient) Backup(w io.Writer) error {
+	return c.BackupContext(c.ctx, w)
+}
+
+// BackupContext writes all the crawl tables into w as a gzipped tar with the BackupManifest followed by one CSV per table.
+// The tables are read from a consistent snapshot with COPY, spooled into temporary files (never into memory),
+// as the tar entries need their size upfront. It stops as soon as the context dies.
+func (c *DBClient) BackupContext(ctx context.Context, w io.Writer) error {
+	log.Info("backing up the crawl database")
+	conn, err := c.connect(ctx)
+	if err != nil {
+		return errors.Wrap(err, "unable to connect the backup")
+	}
+	defer conn.Close(context.Background())
+
+	// a single snapshot for all the tables
+	tx, err := conn.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.RepeatableRead, AccessMode: pgx.ReadOnly})
+	if err != nil {
+		return errors.Wrap(err, "unable to begin the backup")
+	}
+	defer tx.Rollback(context.Background())
+
+	spoolDir, err := os.MkdirTemp("", "armiarma-backup")
+	if err != nil {
+		return errors.Wrap(err, "unable to create the backup spool dir")
+	}
+	defer os.RemoveAll(spoolDir)
+
+	manifest := BackupManifest{
+		SchemaVersion: BackupSchemaVersion,
+		Network:       c.Network,
+		CreatedAt:     time.Now().UTC(),
+		Tables:        make([]BackupTableRecord, 0),
+	}
+	var from, to *int64
+	err = tx.QueryRow(ctx, `
+		SELECT min(conn_time), max(COALESCE(disconn_time, conn_time)) FROM conn_events;
+	`).Scan(&from, &to)
+	if err != nil {
+		return errors.Wrap(err, "unable to read the time range of the backup")
+	}
+	if from != nil && to != nil {
+		manifest.From = time.Unix(*from, 0).UTC()
+		manifest.To = time.Unix(*to, 0).UTC()
+	}
+
+	for _, table := range backupTables(c.Network) {
+		record, err := spoolTable(ctx, tx, table.name, spoolDir)
+		if err != nil {
+			return err
+		}
+		manifest.Tables = append(manifest.Tables, record)
+	}
+
+	// compose the archive
+	gzW := gzip.NewWriter(w)
+	tarW := tar.NewWriter(gzW)
+	manifestBytes, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "unable to encode the backup manifest")
+	}
+	err = tarW.WriteHeader(&tar.Header{
+		Name:    BackupManifestFile,
+		Mode:    0644,
+		Size:    int64(len(manifestBytes)),
+		ModTime: manifest.CreatedAt,
+	})
+	if err != nil {
+		return errors.Wrap(err, "unable to write the backup manifest")
+	}
+	if _, err = tarW.Write(manifestBytes); err != nil {
+		return errors.Wrap(err, "unable to write the backup manifest")
+	}
+	for _, record := range manifest.Tables {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		err = archiveFile(tarW, filepath.Join(spoolDir, record.File), record.File, manifest.CreatedAt)
+		if err != nil {
+			return err
+		}
+	}
+	if err = tarW.Close(); err != nil {
+		return errors.Wrap(err, "unable to close the backup archive")
+	}
+	if err = gzW.Close(); err != nil {
+		return errors.Wrap(err, "unable to close the backup archive")
+	}
+	log.Infof("crawl database backed up (%d tables)", len(manifest.Tables))
+	return nil
+}
+
+// spoolTable copies the table as CSV into a file of the spool dir.
+func spoolTable(ctx context.Context, tx pgx.Tx, table string, spoolDir string) (BackupTableRecord, error) {
+	record := BackupTableRecord{
+		Name: table,
+		File: table + ".csv",
+	}
+	rows, err := tx.Query(ctx, `
+		SELECT column_name
+		FROM information_schema.columns
+		WHERE table_schema = current_schema() AND table_name = $1
+		ORDER BY ordinal_position;
+	`, table)
+	if err != nil {
+		return record, errors.Wrap(err, "unable to read the columns of table "+table)
+	}
+	for rows.Next() {
+		var column string
+		if err := rows.Scan(&column); err != nil {
+			rows.Close()
+			return record, errors.Wrap(err, "unable to read the columns of table "+table)
+		}
+		record.Columns = append(record.Columns, column)
+	}
+	rows.Close()
+	if len(record.Columns) == 0 {
+		return record, errors.New("table " + table + " doesn't exist")
+	}
+
+	f, err := os.Create(filepath.Join(spoolDir, record.File))
+	if err != nil {
+		return record, errors.Wrap(err, "unable to create the spool file of table "+table)
+	}
+	defer f.Close()
+	tag, err := tx.Conn().PgConn().CopyTo(ctx, f, fmt.Sprintf(
+		"COPY %s (%s) TO STDOUT WITH (FORMAT csv)", table, quoteColumns(record.Columns)))
+	if err != nil {
+		return record, errors.Wrap(err, "unable to copy table "+table)
+	}
+	record.Rows = tag.RowsAffected()
+	log.Debugf("backed up %d rows of table %s", record.Rows, table)
+	return record, nil
+}
+
+// archiveFile adds the file into the archive with the given name.
+func archiveFile(tarW *tar.Writer, path string, name string, modTime time.Time) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return errors.Wrap(err, "unable to open the spool file "+path)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return errors.Wrap(err, "unable to stat the spool file "+path)
+	}
+	err = tarW.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    info.Size(),
+		ModTime: modTime,
+	})
+	if err != nil {
+		return errors.Wrap(err, "unable to archive "+name)
+	}
+	_, err = io.Copy(tarW, f)
+	return errors.Wrap(err, "unable to archive "+name)
+}
+
+// Restore loads a backup archive into the (empty) crawl tables (see RestoreContext).
+func (c *DBClient) Restore(r io.Reader) error {
+	return c.RestoreContext(c.ctx, r)
+}
+
+// RestoreContext loads a backup archive written by Backup into the crawl tables, which have to be empty.
+// The CSVs are streamed from the archive into the tables with COPY, in a single transaction,
+// so a failed or cancelled restore leaves the DB untouched.
+func (c *DBClient) RestoreContext(ctx context.Context, r io.Reader) error {
+	log.Info("restoring the crawl database from backup")
+	gzR, err := gzip.NewReader(r)
+	if err != nil {
+		return errors.Wrap(err, "unable to read the backup archive")
+	}
+	defer gzR.Close()
+	tarR := tar.NewReader(gzR)
+
+	// the manifest goes always first
+	hdr, err := tarR.Next()
+	if err != nil {
+		return errors.Wrap(err, "unable to read the backup manifest")
+	}
+	if hdr.Name != BackupManifestFile {
+		return errors.New("backup archive doesn't start with " + BackupManifestFile)
+	}
+	var manifest BackupManifest
+	if err = json.NewDecoder(tarR).Decode(&manifest); err != nil {
+		return errors.Wrap(err, "unable to decode the backup manifest")
+	}
+	if manifest.SchemaVersion != BackupSchemaVersion {
+		return fmt.Errorf("unsupported backup schema version %d (expected %d)", manifest.SchemaVersion, BackupSchemaVersion)
+	}
+	if manifest.Network != c.Network {
+		return fmt.Errorf("backup of network %s can't be restored into a %s DB", manifest.Network, c.Network)
+	}
+	knownTables := make(map[string]backupTable)
+	for _, table := range backupTables(c.Network) {
+		knownTables[table.name] = table
+	}
+	records := make(map[string]BackupTableRecord)
+	for _, record := range manifest.Tables {
+		if _, ok := knownTables[record.Name]; !ok {
+			return errors.New("unknown table " + record.Name + " in the backup")
+		}
+		records[record.File] = record
+	}
+
+	// make sure that the tables exist
+	if err = c.initTables(); err != nil {
+		return errors.Wrap(err, "unable to initialize the tables to restore")
+	}
+
+	conn, err := c.connect(ctx)
+	if err != nil {
+		return errors.Wrap(err, "unable to connect the restore")
+	}
+	defer conn.Close(context.Background())
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return errors.Wrap(err, "unable to begin the restore")
+	}
+	defer tx.Rollback(context.Background())
+
+	restored := 0
+	for {
+		hdr, err = tarR.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return errors.Wrap(err, "unable to read the backup archive")
+		}
+		record, ok := records[hdr.Name]
+		if !ok {
+			return errors.New("unexpected file " + hdr.Name + " in the backup")
+		}
+		err = restoreTable(ctx, tx, record, knownTables[record.Name], tarR)
+		if err != nil {
+			return err
+		}
+		restored++
+	}
+	if restored != len(records) {
+		return fmt.Errorf("truncated backup, restored %d of %d tables", restored, len(records))
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return errors.Wrap(err, "unable to commit the restore")
+	}
+	log.Infof("crawl database restored (%d tables)", restored)
+	return nil
+}
+
+// restoreTable copies the CSV into the table, checking that it was empty.
+func restoreTable(ctx context.Context, tx pgx.Tx, record BackupTableRecord, table backupTable, r io.Reader) error {
+	var existing int64
+	err := tx.QueryRow(ctx, "SELECT count(*) FROM "+record.Name).Scan(&existing)
+	if err != nil {
+		return errors.Wrap(err, "unable to check the table "+record.Name)
+	}
+	if existing > 0 {
+		return fmt.Errorf("table %s is not empty (%d rows)", record.Name, existing)
+	}
+	tag, err := tx.Conn().PgConn().CopyFrom(ctx, r, fmt.Sprintf(
+		"COPY %s (%s) FROM STDIN WITH (FORMAT csv)", record.Name, quoteColumns(record.Columns)))
+	if err != nil {
+		return errors.Wrap(err, "unable to restore table "+record.Name)
+	}
+	if tag.RowsAffected() != record.Rows {
+		return fmt.Errorf("restored %d rows of table %s, expected %d", tag.RowsAffected(), record.Name, record.Rows)
+	}
+	// the next ids have to follow the restored ones
+	if table.serialID {
+		_, err = tx.Exec(ctx, fmt.Sprintf(
+			"SELECT setval(pg_get_serial_sequence('%s', 'id'), COALESCE(max(id), 0) + 1, false) FROM %s",
+			record.Name, record.Name))
+		if err != nil {
+			return errors.Wrap(err, "unable to restore the ids of table "+record.Name)
+		}
+	}
+	log.Debugf("restored %d rows of table %s", record.Rows, record.Name)
+	return nil
+}
+
+func quoteColumns(columns []string) string {
+	quoted := make([]string, 0, len(columns))
+	for _, column := range columns {
+		quoted = append(quoted, pgx.Identifier{column}.Sanitize())
+	}
+	return strings.Join(quoted, ",")
+}
@@ -2,53 +2,200 @@ package postgresql
 
 import (
 	"context"
+	"fmt"
+	"io"
+	"strings"
+	"syscall"
 	"time"
 
+	"github.com/jackc/pgconn"
 	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/migalabs/armiarma/pkg/utils"
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 )
 
 var (
-	QueryTimeout = 5 * time.Minute
-	MaxRetries   = 2
+	// deadlines of the batches of queries and of the read queries (configurable through the DBOptions)
+	DefaultBatchTimeout = 30 * time.Second
+	DefaultReadTimeout  = 10 * time.Second
+	// statement_timeout of the connections of the pool, so that the DB aborts any wedged query by itself
+	StatementTimeout = 30 * time.Second
+	MaxRetries       = 2
+	// times that a statement that failed for a transient reason is requeued into the next batches,
+	// waiting QueryRetryBackoff, doubled on every retry, before being persisted again
+	MaxQueryRetries   = 3
+	QueryRetryBackoff = 1 * time.Second
+	// pending conn_events from which a batch copies them at once into the table, instead of
+	// inserting them one by one (configurable through WithConnEventsCopyThreshold)
+	DefaultConnEventsCopyThreshold = 100
 
 	ErrorNoConnFree = "no connection adquirable"
+
+	// ErrQueriesFailed is the cause of the errors of the batches whose statements failed one by one
+	ErrQueriesFailed = errors.New("queries of the batch failed")
 )
 
+// execFn executes a single statement (i.e. pgxpool.Pool.Exec).
+type execFn func(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error)
+
+// queuedQuery is a statement of the batch, kept to execute it on its own if the batch fails.
+type queuedQuery struct {
+	query string
+	args  []interface{}
+	table string
+	// times that it was requeued, and the time until it has to wait to be persisted again
+	retries   int
+	notBefore time.Time
+}
+
 type QueryBatch struct {
 	ctx     context.Context
 	pgxPool *pgxpool.Pool
 	batch   *pgx.Batch
 	size    int
+	timeout time.Duration
+	// latest disconnection time per peer in the current batch window,
+	// queued as a single last_activity update per peer (of the network) when persisting the batch
+	lastActivity map[peer.ID]time.Time
+	network      utils.NetworkType
+	// queued statements, and the counters where they are accounted (optional)
+	queries []queuedQuery
+	stats   *persisterStats
+	// sampler of the failures of the statements (optional, logged right away if nil)
+	sampler *utils.ErrorSampler
+	// statements that failed for a transient reason, waiting to be persisted again
+	requeued []queuedQuery
+	// rows of the pending conn_events (see connEventRow), copied at once when there are
+	// at least copyThreshold of them (never if 0), and inserted one by one otherwise
+	connEvents    [][]interface{}
+	copyThreshold int
 }
 
-func NewQueryBatch(ctx context.Context, pgxPool *pgxpool.Pool, batchSize int) *QueryBatch {
+func NewQueryBatch(ctx context.Context, pgxPool *pgxpool.Pool, batchSize int, timeout time.Duration) *QueryBatch {
 	return &QueryBatch{
-		ctx:     ctx,
-		pgxPool: pgxPool,
-		batch:   &pgx.Batch{},
-		size:    batchSize,
+		ctx:           ctx,
+		pgxPool:       pgxPool,
+		batch:         &pgx.Batch{},
+		size:          batchSize,
+		timeout:       timeout,
+		lastActivity:  make(map[peer.ID]time.Time),
+		copyThreshold: DefaultConnEventsCopyThreshold,
 	}
 }
 
 func (q *QueryBatch) IsReadyToPersist() bool {
-	return q.batch.Len() >= q.size
+	return q.batch.Len()+len(q.connEvents) >= q.size
 }
 
 func (q *QueryBatch) AddQuery(query string, args ...interface{}) {
-	q.batch.Queue(query, args...)
+	stmt := queuedQuery{
+		query: query,
+		args:  args,
+		table: statementTable(query),
+	}
+	q.queue(stmt)
+	q.stats.queued(stmt.table)
+}
+
+func (q *QueryBatch) queue(stmt queuedQuery) {
+	q.batch.Queue(stmt.query, stmt.args...)
+	q.queries = append(q.queries, stmt)
+}
+
+// AddConnEvent accumulates the row of a conn_event (see connEventRow) until the batch gets persisted.
+func (q *QueryBatch) AddConnEvent(row []interface{}) {
+	q.connEvents = append(q.connEvents, row)
+	q.stats.queued(connEventsTable)
+}
+
+// copiesConnEvents returns whether the pending conn_events are enough to copy them at once.
+func (q *QueryBatch) copiesConnEvents() bool {
+	return q.copyThreshold > 0 && len(q.connEvents) >= q.copyThreshold
+}
+
+// queueConnEvents queues the pending conn_events into the batch as single inserts.
+func (q *QueryBatch) queueConnEvents() {
+	for _, row := range q.connEvents {
+		q.queue(queuedQuery{
+			query: insertConnEventQuery,
+			args:  row,
+			table: connEventsTable,
+		})
+	}
+	q.connEvents = nil
+}
+
+// AddLastActivity accumulates the activity of the peer until t, keeping only the latest time
+// of each peer until the batch gets persisted.
+func (q *QueryBatch) AddLastActivity(peerID peer.ID, t time.Time) {
+	if last, ok := q.lastActivity[peerID]; ok && !t.After(last) {
+		return
+	}
+	q.lastActivity[peerID] = t
+}
+
+// queueLastActivity queues the accumulated last_activity updates into the batch, one per peer.
+func (q *QueryBatch) queueLastActivity() {
+	for peerID, t := range q.lastActivity {
+		q.AddQuery(lastActivityQuery(q.network, peerID, t))
+	}
+	q.lastActivity = make(map[peer.ID]time.Time)
+}
+
+// requeue keeps the statement for the next batches, once its backoff expires.
+func (q *QueryBatch) requeue(stmt queuedQuery, now time.Time) {
+	stmt.notBefore = now.Add(QueryRetryBackoff << stmt.retries)
+	stmt.retries++
+	q.requeued = append(q.requeued, stmt)
 }
 
+// queueRequeued queues into the batch the requeued statements whose backoff expired at now.
+func (q *QueryBatch) queueRequeued(now time.Time) {
+	pending := q.requeued[:0]
+	for _, stmt := range q.requeued {
+		if now.Before(stmt.notBefore) {
+			pending = append(pending, stmt)
+			continue
+		}
+		q.queue(stmt)
+	}
+	q.requeued = pending
+}
+
+// Requeued returns the number of statements waiting to be persisted again.
+func (q *QueryBatch) Requeued() int {
+	return len(q.requeued)
+}
+
+// Len returns the number of queries in the batch, counting one per peer with pending activity
+// and one per pending conn_event.
 func (q *QueryBatch) Len() int {
-	return q.batch.Len()
+	return q.batch.Len() + len(q.lastActivity) + len(q.connEvents)
+}
+
+// FlushRequeued persists the batch along with all the requeued statements, regardless of their
+// backoff (i.e. when closing the persister).
+func (q *QueryBatch) FlushRequeued() error {
+	for i := range q.requeued {
+		q.requeued[i].notBefore = time.Time{}
+	}
+	return q.PersistBatch()
 }
 
 func (q *QueryBatch) PersistBatch() error {
 	logEntry := log.WithFields(log.Fields{
 		"mod": "batch-persister",
 	})
+	// the activity updates go last, after the peer_info rows of the batch were inserted
+	q.queueLastActivity()
+	q.queueRequeued(time.Now())
+	// the few conn_events don't make up for the copy
+	if !q.copiesConnEvents() {
+		q.queueConnEvents()
+	}
 	logEntry.Debugf("persisting batch of queries with len(%d)", q.Len())
 	var err error
 persistRetryLoop:
@@ -56,18 +203,58 @@ persistRetryLoop:
 		t := time.Now()
 		err = q.persistBatch()
 		duration := time.Since(t)
-		switch err {
-		case nil:
+		switch {
+		case err == nil:
 			logEntry.Debugf("persisted %d queries in %s seconds", q.Len(), duration)
 			break persistRetryLoop
+		case IsTimeoutError(err) && i < MaxRetries:
+			// the timed out connection is discarded by the pool, the retry gets a new one
+			logEntry.Warnf("attempt numb %d timed out after %s, retrying", i+1, duration)
 		default:
-			logEntry.Debugf("attempt numb %d failed %s", i+1, err.Error())
+			// including the batches that kept timing out, they are requeued
+			err = q.recoverBatch(err, q.pgxPool.Exec)
+			break persistRetryLoop
 		}
 	}
 	q.cleanBatch()
 	return errors.Wrap(err, "unable to persist batch query")
 }
 
+// recoverBatch handles a batch that failed for a reason other than a timeout, or that ran out of
+// retries on timeouts. If the DB rejected one of its statements, the rest are persisted one by one,
+// so that it doesn't take the whole batch down with it. Otherwise the DB couldn't take the batch at
+// all (i.e. it refused the connection or kept timing out), and the whole batch is requeued instead
+// of failing each of its statements against it.
+func (q *QueryBatch) recoverBatch(err error, exec execFn) error {
+	logEntry := log.WithFields(log.Fields{
+		"mod": "batch-persister",
+	})
+	q.queueConnEvents()
+	if isStatementRejection(err) {
+		logEntry.Warnf("batch rejected, persisting its %d queries one by one: %s", len(q.queries), err.Error())
+		return q.persistEach(exec)
+	}
+	dropped := q.requeueAll(time.Now())
+	if dropped > 0 {
+		return errors.Wrapf(err, "%d of %d queries of the batch ran out of retries", dropped, len(q.queries))
+	}
+	return errors.Wrap(err, "batch requeued")
+}
+
+// requeueAll requeues every statement of the batch that has retries left, returning the number of
+// the ones that ran out of them (accounted as failed).
+func (q *QueryBatch) requeueAll(now time.Time) (dropped int) {
+	for _, stmt := range q.queries {
+		if stmt.retries < MaxQueryRetries {
+			q.requeue(stmt, now)
+			continue
+		}
+		q.stats.failed(stmt.table)
+		dropped++
+	}
+	return dropped
+}
+
 func (q *QueryBatch) persistBatch() error {
 	logEntry := log.WithFields(log.Fields{
 		"mod": "batch-persister",
@@ -79,7 +266,7 @@ func (q *QueryBatch) persistBatch() error {
 	}
 
 	// generate a timeout to the batch-persisting
-	ctx, cancel := context.WithTimeout(q.ctx, QueryTimeout)
+	ctx, cancel := context.WithTimeout(q.ctx, q.timeout)
 	defer cancel()
 
 	// begin pgx.Tx
@@ -92,26 +279,178 @@ func (q *QueryBatch) persistBatch() error {
 	logEntry.Trace("sending batch over transaction")
 	batchResults := tx.SendBatch(ctx, q.batch)
 
-	// Exec the queries
-	var qerr error
-	var rows pgx.Rows
-	var cnt int
-	for qerr == nil {
-		rows, qerr = batchResults.Query()
-		rows.Close()
-		cnt++
+	// command tags of the statements, accounted once the transaction commits
+	tags := make([]pgconn.CommandTag, 0, q.batch.Len())
+	for i := 0; i < q.batch.Len(); i++ {
+		tag, err := batchResults.Exec()
+		if err != nil {
+			err = &statementError{i: i, table: q.statementTable(i), err: err}
+			batchResults.Close()
+			tx.Rollback(context.Background())
+			return err
+		}
+		tags = append(tags, tag)
 	}
 	logEntry.Trace("readed all the result of the queries inside the batch")
-	// check if there was any error
-	if qerr.Error() != noQueryResult {
-		log.Errorf("unable to persist betch because an error on row %d \n %+v \n %+v", cnt, rows, err)
-		errRollback := tx.Commit(q.ctx)
-		log.Errorf("rolled back with err %+v", errRollback)
+	if err := batchResults.Close(); err != nil {
+		tx.Rollback(context.Background())
+		return err
+	}
+	var copyTag pgconn.CommandTag
+	if len(q.connEvents) > 0 {
+		logEntry.Tracef("copying %d conn_events", len(q.connEvents))
+		copyTag, err = copyConnEvents(ctx, tx, q.connEvents)
+		if err != nil {
+			tx.Rollback(context.Background())
+			return err
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
 		return err
 	}
-	return tx.Commit(q.ctx)
+	for i, tag := range tags {
+		q.stats.executed(q.statementTable(i), tag)
+	}
+	if len(q.connEvents) > 0 {
+		q.stats.copied(connEventsTable, len(q.connEvents), copyTag)
+	}
+	return nil
+}
+
+// persistEach executes the statements of a rejected batch one by one, outside of any transaction,
+// logging the ones that fail. The statements that failed for a transient reason are requeued,
+// up to MaxQueryRetries times.
+func (q *QueryBatch) persistEach(exec execFn) error {
+	logEntry := log.WithFields(log.Fields{
+		"mod": "batch-persister",
+	})
+	var failed int
+	for _, stmt := range q.queries {
+		ctx, cancel := context.WithTimeout(q.ctx, q.timeout)
+		tag, err := exec(ctx, stmt.query, stmt.args...)
+		cancel()
+		if err == nil {
+			q.stats.executed(stmt.table, tag)
+			continue
+		}
+		// the args are only worth it when debugging, the failures of every statement are sampled
+		logEntry.WithFields(log.Fields{
+			"table": stmt.table,
+			"query": stmt.query,
+			"args":  fmt.Sprintf("%+v", stmt.args),
+		}).Debugf("query failed: %s", err.Error())
+		if IsTransientError(err) && stmt.retries < MaxQueryRetries {
+			q.logFailure(stmt.table, errors.Wrapf(err, "requeuing query of %s", stmt.table))
+			q.requeue(stmt, time.Now())
+			continue
+		}
+		q.logFailure(stmt.table, errors.Wrapf(err, "unable to persist query of %s", stmt.table))
+		q.stats.failed(stmt.table)
+		failed++
+	}
+	if failed > 0 {
+		return errors.Wrapf(ErrQueriesFailed, "%d of %d", failed, len(q.queries))
+	}
+	return nil
+}
+
+// logFailure logs the failure of a statement of the table through the sampler of the batch, if it
+// has one, sampling the failures of the table by their cause (see SampleKey).
+func (q *QueryBatch) logFailure(table string, err error) {
+	if q.sampler != nil {
+		q.sampler.ErrorWithKey(table+": "+SampleKey(err), err)
+		return
+	}
+	log.WithField("mod", "batch-persister").Warn(err.Error())
+}
+
+// statementError is the error of a statement of the batch, telling which one it was.
+type statementError struct {
+	i     int
+	table string
+	err   error
+}
+
+func (e *statementError) Error() string {
+	return fmt.Sprintf("statement %d (%s) of the batch: %s", e.i, e.table, e.err.Error())
+}
+
+func (e *statementError) Cause() error  { return e.err }
+func (e *statementError) Unwrap() error { return e.err }
+
+// isStatementRejection returns whether the batch failed because the DB rejected one of its
+// statements, rather than because it couldn't be reached (i.e. a connection refused on Begin)
+// or in time (i.e. the statement_timeout of the DB).
+func isStatementRejection(err error) bool {
+	var stmtErr *statementError
+	if !errors.As(err, &stmtErr) || IsTimeoutError(err) {
+		return false
+	}
+	var pgErr *pgconn.PgError
+	// class 08 = connection_exception
+	return errors.As(stmtErr.err, &pgErr) && !strings.HasPrefix(pgErr.Code, "08")
+}
+
+// statementTable returns the table of the i-th statement of the batch.
+func (q *QueryBatch) statementTable(i int) string {
+	if i < len(q.queries) {
+		return q.queries[i].table
+	}
+	return otherTable
+}
+
+// IsTransientError returns whether the statement failed for a reason unrelated to the statement
+// itself (a dropped connection, a deadlock, or the DB running out of connections), so that it
+// can be persisted again.
+func IsTransientError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if IsTimeoutError(err) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch pgErr.Code {
+		// 40001 = serialization_failure, 40P01 = deadlock_detected, 53300 = too_many_connections,
+		// 57P03 = cannot_connect_now
+		case "40001", "40P01", "53300", "57P03":
+			return true
+		}
+		// class 08 = connection_exception
+		return strings.HasPrefix(pgErr.Code, "08")
+	}
+	// the statement didn't reach the DB
+	return pgconn.SafeToRetry(err)
+}
+
+// SampleKey returns the key under which the error is sampled in the logs (see utils.ErrorSampler):
+// its SQLSTATE if the DB returned it, or else its root cause. The errors of a DB that is down only
+// differ in their context (i.e. the failed statement), so they are logged once per window.
+func SampleKey(err error) string {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return "SQLSTATE " + pgErr.Code
+	}
+	return errors.Cause(err).Error()
+}
+
+// IsTimeoutError returns whether the error was caused by a deadline of the context or by the
+// statement_timeout of the DB, rather than by the query itself.
+func IsTimeoutError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, context.DeadlineExceeded) || pgconn.Timeout(err) {
+		return true
+	}
+	var pgErr *pgconn.PgError
+	// 57014 = query_canceled (statement_timeout)
+	return errors.As(err, &pgErr) && pgErr.Code == "57014"
 }
 
 func (q *QueryBatch) cleanBatch() {
 	q.batch = &pgx.Batch{}
+	q.queries = nil
+	q.connEvents = nil
 }
@@ -0,0 +1,228 @@
+package postgresql
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// path of the HTTP endpoint that serves the bootnode list as plain text
+	BootnodesEndpoint = "/bootnodes"
+	// hours of activity of the peers of the bootnode list when the request doesn't set any
+	DefaultBootnodeHours = 24
+)
+
+// BootnodeFormat is the format of the records of the bootnode list.
+type BootnodeFormat string
+
+const (
+	BootnodeFormatEnr   BootnodeFormat = "enr"
+	BootnodeFormatEnode BootnodeFormat = "enode"
+)
+
+// BootnodeFilter selects the peers of the bootnode list.
+type BootnodeFilter struct {
+	// peers with any activity since then (no limit if zero)
+	ActiveSince time.Time
+	// include the deprecated peers
+	IncludeDeprecated bool
+}
+
+// BootnodeList is the list of records of the discovered peers, one per node.
+type BootnodeList struct {
+	// sorted records
+	Nodes []string
+	// peers that match the filter but have no ENR stored
+	WithoutEnr int
+	// ENRs that couldn't be parsed, or converted into an enode (i.e. without IP)
+	Unconvertible int
+}
+
+// bootnodeRow is a peer that matches the filter, with its latest ENR (empty if it has none).
+type bootnodeRow struct {
+	peerID string
+	enr    string
+}
+
+// GetBootnodes returns the latest ENR of the peers that match the filter, in the given format.
+// ENRs of a different network than the crawled one are left out.
+func (c *DBClient) GetBootnodes(filter BootnodeFilter, format BootnodeFormat) (BootnodeList, error) {
+	ctx, cancel := c.readCtx()
+	defer cancel()
+	log.Debugf("fetching bootnode list with filter %+v", filter)
+
+	query, args := bootnodesQuery(filter, c.readNetwork())
+	rows, err := c.psqlPool.Query(ctx, query, args...)
+	if err != nil {
+		return BootnodeList{}, errors.Wrap(err, "unable to fetch bootnodes")
+	}
+	// make sure we close the rows and we free the connection/session
+	defer rows.Close()
+
+	bootnodes := make([]bootnodeRow, 0)
+	for rows.Next() {
+		var row bootnodeRow
+		err = rows.Scan(&row.peerID, &row.enr)
+		if err != nil {
+			return BootnodeList{}, errors.Wrap(err, "unable to parse fetched bootnode")
+		}
+		bootnodes = append(bootnodes, row)
+	}
+	if err = rows.Err(); err != nil {
+		return BootnodeList{}, errors.Wrap(err, "unable to fetch bootnodes")
+	}
+	return formatBootnodes(bootnodes, format)
+}
+
+// bootnodesQuery composes the query of the peers of the network (all of them if empty) that match
+// the filter, with their latest ENR.
+func bootnodesQuery(filter BootnodeFilter, network string) (query string, args []interface{}) {
+	conditions := make([]string, 0)
+	if !filter.ActiveSince.IsZero() {
+		args = append(args, filter.ActiveSince.Unix())
+		conditions = append(conditions, fmt.Sprintf("p.last_activity >= $%d", len(args)))
+	}
+	if !filter.IncludeDeprecated {
+		conditions = append(conditions, "NOT COALESCE(p.deprecated, false)")
+	}
+	if network != "" {
+		args = append(args, network)
+		conditions = append(conditions, fmt.Sprintf("p.network = $%d", len(args)))
+	}
+	query = `
+		SELECT
+			p.peer_id,
+			COALESCE(e.enr, '')
+		FROM peer_info AS p
+		LEFT JOIN LATERAL (
+			SELECT enr
+			FROM eth_nodes
+			WHERE peer_id = p.peer_id AND network = p.network AND enr IS NOT NULL AND enr <> ''
+				AND NOT COALESCE(foreign_network, false)
+			ORDER BY timestamp DESC
+			LIMIT 1
+		) AS e ON true` + whereClause(conditions) + `
+		ORDER BY p.peer_id;`
+	return query, args
+}
+
+// formatBootnodes converts the ENRs of the peers into the records of the list, skipping and
+// counting the peers without ENR and the ENRs that can't be converted.
+func formatBootnodes(rows []bootnodeRow, format BootnodeFormat) (BootnodeList, error) {
+	if format != BootnodeFormatEnr && format != BootnodeFormatEnode {
+		return BootnodeList{}, errors.New("unknown bootnode format " + string(format))
+	}
+	list := BootnodeList{
+		Nodes: make([]string, 0, len(rows)),
+	}
+	// a node can be stored for more than one peer (i.e. after a key rotation)
+	seen := make(map[string]struct{})
+	for _, row := range rows {
+		if row.enr == "" {
+			list.WithoutEnr++
+			continue
+		}
+		record := row.enr
+		if format == BootnodeFormatEnode {
+			node, err := enode.Parse(enode.ValidSchemes, row.enr)
+			if err != nil || node.IP() == nil {
+				log.Tracef("unable to convert the ENR of peer %s into an enode", row.peerID)
+				list.Unconvertible++
+				continue
+			}
+			record = node.URLv4()
+		}
+		if _, ok := seen[record]; ok {
+			continue
+		}
+		seen[record] = struct{}{}
+		list.Nodes = append(list.Nodes, record)
+	}
+	sort.Strings(list.Nodes)
+	return list, nil
+}
+
+// WriteBootnodes writes the records of the list into w, one per line.
+func WriteBootnodes(w io.Writer, list BootnodeList) error {
+	for _, node := range list.Nodes {
+		_, err := io.WriteString(w, node+"\n")
+		if err != nil {
+			return errors.Wrap(err, "unable to write bootnode")
+		}
+	}
+	return nil
+}
+
+// parseBootnodesRequest reads the filter and the format of the bootnode list from the query parameters
+// (format=enr|enode, hours of activity, and deprecated=true to include the deprecated peers).
+func parseBootnodesRequest(r *http.Request, now time.Time) (BootnodeFilter, BootnodeFormat, error) {
+	params := r.URL.Query()
+	format := BootnodeFormatEnr
+	if f := params.Get("format"); f != "" {
+		format = BootnodeFormat(f)
+		if format != BootnodeFormatEnr && format != BootnodeFormatEnode {
+			return BootnodeFilter{}, format, errors.New("invalid format " + f)
+		}
+	}
+	hours := DefaultBootnodeHours
+	if h := params.Get("hours"); h != "" {
+		var err error
+		hours, err = strconv.Atoi(h)
+		if err != nil || hours < 0 {
+			return BootnodeFilter{}, format, errors.New("invalid hours " + h)
+		}
+	}
+	var filter BootnodeFilter
+	// 0 hours doesn't limit the activity
+	if hours > 0 {
+		filter.ActiveSince = now.Add(-time.Duration(hours) * time.Hour)
+	}
+	if d := params.Get("deprecated"); d != "" {
+		include, err := strconv.ParseBool(d)
+		if err != nil {
+			return BootnodeFilter{}, format, errors.New("invalid deprecated " + d)
+		}
+		filter.IncludeDeprecated = include
+	}
+	return filter, format, nil
+}
+
+// BootnodesHandler serves the bootnode list as plain text, one record per line (see parseBootnodesRequest).
+func (c *DBClient) BootnodesHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		filter, format, err := parseBootnodesRequest(r, time.Now())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		list, err := c.GetBootnodes(filter, format)
+		if err != nil {
+			log.Error(errors.Wrap(err, "unable to serve bootnodes"))
+			http.Error(w, "unable to read bootnodes", http.StatusInternalServerError)
+			return
+		}
+		log.WithFields(log.Fields{
+			"nodes":         len(list.Nodes),
+			"without-enr":   list.WithoutEnr,
+			"unconvertible": list.Unconvertible,
+		}).Debug("serving bootnode list")
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("X-Peers-Without-Enr", strconv.Itoa(list.WithoutEnr))
+		err = WriteBootnodes(w, list)
+		if err != nil {
+			log.Debug(errors.Wrap(err, "unable to write bootnodes"))
+		}
+	})
+}
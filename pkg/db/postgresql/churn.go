@@ -0,0 +1,101 @@
+package postgresql
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// DailyChurn summarizes the peers that joined and left the network on a given day (UTC).
+type DailyChurn struct {
+	Day time.Time
+	// peers with any activity during the day
+	Active int
+	// peers seen for the first time ever
+	New int
+	// peers that were seen before, but not the previous day
+	Returning int
+	// peers active the previous day that weren't seen during the day
+	Disappeared int
+}
+
+// GetChurn returns the churn of each day between from and to (both included).
+// A peer is active during a day if any of its connections spans that day, or if its last
+// activity or its first activity happened that day.
+func (c *DBClient) GetChurn(from, to time.Time) ([]DailyChurn, error) {
+	ctx, cancel := c.readCtx()
+	defer cancel()
+	log.Debugf("fetching daily churn from %s to %s", from.String(), to.String())
+	churn := make([]DailyChurn, 0)
+
+	rows, err := c.psqlPool.Query(ctx, `
+		WITH activity AS (
+			SELECT DISTINCT peer_id, day
+			FROM (
+				SELECT
+					peer_id,
+					generate_series(
+						date_trunc('day', to_timestamp(COALESCE(conn_time, disconn_time)) AT TIME ZONE 'UTC'),
+						date_trunc('day', to_timestamp(COALESCE(disconn_time, conn_time)) AT TIME ZONE 'UTC'),
+						'1 day') AS day
+				FROM conn_events
+				WHERE `+networkCond("network", 3)+`
+				UNION ALL
+				SELECT peer_id, date_trunc('day', to_timestamp(last_activity) AT TIME ZONE 'UTC') AS day
+				FROM peer_info
+				WHERE last_activity > 0 AND `+networkCond("network", 3)+`
+				UNION ALL
+				SELECT peer_id, date_trunc('day', to_timestamp(first_activity) AT TIME ZONE 'UTC') AS day
+				FROM peer_info
+				WHERE first_activity > 0 AND `+networkCond("network", 3)+`
+			) AS a
+		),
+		first_seen AS (
+			SELECT peer_id, min(day) AS day
+			FROM activity
+			GROUP BY peer_id
+		),
+		days AS (
+			SELECT generate_series(
+				date_trunc('day', $1::timestamptz AT TIME ZONE 'UTC'),
+				date_trunc('day', $2::timestamptz AT TIME ZONE 'UTC'),
+				'1 day') AS day
+		)
+		SELECT
+			d.day,
+			(SELECT count(*) FROM activity AS t WHERE t.day = d.day) AS active_peers,
+			(SELECT count(*) FROM first_seen AS f WHERE f.day = d.day) AS new_peers,
+			(SELECT count(*)
+				FROM activity AS t
+				JOIN first_seen AS f ON f.peer_id = t.peer_id
+				WHERE t.day = d.day AND f.day < d.day AND NOT EXISTS (
+					SELECT 1 FROM activity AS y
+					WHERE y.peer_id = t.peer_id AND y.day = d.day - INTERVAL '1 day')
+			) AS returning_peers,
+			(SELECT count(*)
+				FROM activity AS y
+				WHERE y.day = d.day - INTERVAL '1 day' AND NOT EXISTS (
+					SELECT 1 FROM activity AS t
+					WHERE t.peer_id = y.peer_id AND t.day = d.day)
+			) AS disappeared_peers
+		FROM days AS d
+		ORDER BY d.day;
+		`, from, to, c.readNetwork())
+	if err != nil {
+		return churn, errors.Wrap(err, "unable to fetch daily churn")
+	}
+	// make sure we close the rows and we free the connection/session
+	defer rows.Close()
+
+	for rows.Next() {
+		var dayChurn DailyChurn
+		err = rows.Scan(&dayChurn.Day, &dayChurn.Active, &dayChurn.New, &dayChurn.Returning, &dayChurn.Disappeared)
+		if err != nil {
+			return churn, errors.Wrap(err, "unable to parse fetched daily churn")
+		}
+		dayChurn.Day = dayChurn.Day.UTC()
+		churn = append(churn, dayChurn)
+	}
+	return churn, rows.Err()
+}
@@ -0,0 +1,85 @@
+package postgresql
+
+import (
+	"time"
+
+	"github.com/migalabs/armiarma/pkg/db/models"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// InitClientVersionsTable keeps the first time that each client version was seen in the network
+func (c *DBClient) InitClientVersionsTable() error {
+	log.Debug("initializing client_versions table in psql-db")
+
+	err := c.execSchema(`
+		CREATE TABLE IF NOT EXISTS client_versions(
+			client_name TEXT NOT NULL,
+			client_version TEXT NOT NULL,
+			first_peer TEXT,
+			first_seen BIGINT NOT NULL,
+
+			PRIMARY KEY (client_name, client_version)
+		);
+		`)
+	if err != nil {
+		return errors.Wrap(err, "initializing client_versions table")
+	}
+	return nil
+}
+
+// InsertClientVersion records a new client version, keeping the first sighting if it already existed
+func (c *DBClient) InsertClientVersion(cliVersion *models.ClientVersion) (query string, args []interface{}) {
+	log.Trace("inserting new client version to client_versions in psql-db")
+	query = `
+		INSERT INTO client_versions(
+			client_name,
+			client_version,
+			first_peer,
+			first_seen)
+		VALUES ($1,$2,$3,$4)
+		ON CONFLICT (client_name, client_version)
+		DO NOTHING;
+		`
+
+	args = append(args, cliVersion.Name)
+	args = append(args, cliVersion.Version)
+	args = append(args, cliVersion.FirstPeer)
+	args = append(args, cliVersion.FirstSeen.Unix())
+
+	return query, args
+}
+
+// GetClientVersions returns all the client versions that were already recorded
+func (c *DBClient) GetClientVersions() ([]*models.ClientVersion, error) {
+	ctx, cancel := c.readCtx()
+	defer cancel()
+	log.Debug("fetching known client versions")
+	cliVersions := make([]*models.ClientVersion, 0)
+
+	rows, err := c.psqlPool.Query(ctx, `
+		SELECT client_name, client_version, first_peer, first_seen
+		FROM client_versions;
+		`)
+	if err != nil {
+		return cliVersions, errors.Wrap(err, "unable to fetch client versions")
+	}
+	// make sure we close the rows and we free the connection/session
+	defer rows.Close()
+
+	for rows.Next() {
+		cliVersion := &models.ClientVersion{}
+		var firstPeer *string
+		var firstSeen int64
+		err = rows.Scan(&cliVersion.Name, &cliVersion.Version, &firstPeer, &firstSeen)
+		if err != nil {
+			return cliVersions, errors.Wrap(err, "unable to parse fetched client versions")
+		}
+		if firstPeer != nil {
+			cliVersion.FirstPeer = *firstPeer
+		}
+		cliVersion.FirstSeen = time.Unix(firstSeen, 0)
+		cliVersions = append(cliVersions, cliVersion)
+	}
+	return cliVersions, nil
+}
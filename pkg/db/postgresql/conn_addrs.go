@@ -0,0 +1,88 @@
+package postgresql
+
+import (
+	"sort"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/migalabs/armiarma/pkg/db/models"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// RemoteTransportCount is the number of connections whose remote address used the transport and port.
+type RemoteTransportCount struct {
+	Transport   string `json:"transport"`
+	Port        string `json:"port"`
+	Connections int    `json:"connections"`
+}
+
+// remoteTransportPort returns the transport (tcp, quic or other) and the port of the remote address.
+// Addresses that can't be parsed are counted as other, without port.
+func remoteTransportPort(remoteAddr string) (string, string) {
+	addr, err := ma.NewMultiaddr(remoteAddr)
+	if err != nil {
+		return models.TransportOther, ""
+	}
+	transport := models.AddrTransport(addr)
+	for _, proto := range []int{ma.P_TCP, ma.P_UDP} {
+		if port, err := addr.ValueForProtocol(proto); err == nil {
+			return transport, port
+		}
+	}
+	return transport, ""
+}
+
+// GetConnCountsByRemoteTransport returns the number of connections per transport and port of the remote
+// address that answered, sorted by connections. The events persisted without remote address are left out.
+func (c *DBClient) GetConnCountsByRemoteTransport() ([]RemoteTransportCount, error) {
+	ctx, cancel := c.readCtx()
+	defer cancel()
+	log.Debug("fetching connection counts per remote transport")
+	counts := make([]RemoteTransportCount, 0)
+
+	rows, err := c.psqlPool.Query(ctx, `
+		SELECT remote_addr, COUNT(*) AS connections
+		FROM conn_events
+		WHERE remote_addr IS NOT NULL AND `+networkCond("network", 1)+`
+		GROUP BY remote_addr;
+		`, c.readNetwork())
+	if err != nil {
+		return counts, errors.Wrap(err, "unable to fetch connection counts per remote address")
+	}
+	// make sure we close the rows and we free the connection/session
+	defer rows.Close()
+
+	// the same transport and port is shared by many remote IPs
+	idx := make(map[RemoteTransportCount]int)
+	for rows.Next() {
+		var remoteAddr string
+		var connections int64
+		err = rows.Scan(&remoteAddr, &connections)
+		if err != nil {
+			return counts, errors.Wrap(err, "unable to parse fetched connection count")
+		}
+		var key RemoteTransportCount
+		key.Transport, key.Port = remoteTransportPort(remoteAddr)
+		i, ok := idx[key]
+		if !ok {
+			i = len(counts)
+			idx[key] = i
+			counts = append(counts, key)
+		}
+		counts[i].Connections += int(connections)
+	}
+	if err = rows.Err(); err != nil {
+		return counts, errors.Wrap(err, "unable to fetch connection counts per remote address")
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Connections != counts[j].Connections {
+			return counts[i].Connections > counts[j].Connections
+		}
+		if counts[i].Transport != counts[j].Transport {
+			return counts[i].Transport < counts[j].Transport
+		}
+		return counts[i].Port < counts[j].Port
+	})
+	return counts, nil
+}
@@ -0,0 +1,79 @@
+package postgresql
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// InitConnAttemptsTable keeps the id of every connection attempt applied to the counters of peer_info,
+// so that an attempt persisted twice (i.e. a retried batch) is only counted once.
+func (c *DBClient) InitConnAttemptsTable() error {
+	log.Debug("init conn_attempts table in psql-db")
+	err := c.execSchema(`
+		CREATE TABLE IF NOT EXISTS conn_attempts(
+			attempt_id UUID NOT NULL,
+			peer_id TEXT NOT NULL,
+			timestamp BIGINT NOT NULL,
+			status TEXT NOT NULL,
+			error TEXT,
+
+			PRIMARY KEY (attempt_id)
+		);
+	`)
+	if err != nil {
+		return err
+	}
+
+	// the failed attempts are grouped by the category of their error (see GetConnAttemptErrors)
+	err = c.execSchema(`
+		ALTER TABLE conn_attempts
+			ADD COLUMN IF NOT EXISTS error_kind TEXT;
+	`)
+	if err != nil {
+		return errors.Wrap(err, "adding error_kind to conn_attempts table")
+	}
+	err = c.execSchema(`
+		CREATE INDEX IF NOT EXISTS conn_attempts_error_kind_time
+			ON conn_attempts(error_kind, timestamp);
+	`)
+	if err != nil {
+		return errors.Wrap(err, "indexing conn_attempts by error kind")
+	}
+	return nil
+}
+
+// GetConnAttemptErrors returns the number of failed connection attempts per category of error
+// (see models.ConnectionAttempt.ErrorKind) since the given time, for the peers of the read network.
+// The attempts persisted before the categories were recorded aren't counted.
+func (c *DBClient) GetConnAttemptErrors(since time.Time) (map[string]int64, error) {
+	ctx, cancel := c.readCtx()
+	defer cancel()
+	log.Debugf("fetching the errors of the conn attempts since %s", since.String())
+
+	rows, err := c.psqlPool.Query(ctx, `
+		SELECT a.error_kind, count(*)
+		FROM conn_attempts AS a
+		WHERE a.error_kind IS NOT NULL AND a.timestamp >= $1 AND EXISTS (
+			SELECT 1
+			FROM peer_info AS p
+			WHERE p.peer_id = a.peer_id AND `+networkCond("p.network", 2)+`)
+		GROUP BY a.error_kind;
+		`, since.Unix(), c.readNetwork())
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to fetch the errors of the conn attempts")
+	}
+	defer rows.Close()
+
+	errCounts := make(map[string]int64)
+	for rows.Next() {
+		var errKind string
+		var count int64
+		if err := rows.Scan(&errKind, &count); err != nil {
+			return nil, errors.Wrap(err, "unable to read the errors of the conn attempts")
+		}
+		errCounts[errKind] = count
+	}
+	return errCounts, rows.Err()
+}
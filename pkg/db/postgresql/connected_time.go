@@ -0,0 +1,89 @@
+package postgresql
+
+import (
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// ConnectedTimeStats aggregates the duration of the sessions of all the peers. Only the finished
+// sessions count for the durations, the open ones and the disconnections without connection don't.
+type ConnectedTimeStats struct {
+	Peers          int64         `json:"peers"`
+	Sessions       int64         `json:"sessions"`
+	OpenSessions   int64         `json:"open_sessions"`
+	TotalConnected time.Duration `json:"total_connected"`
+	AvgSession     time.Duration `json:"avg_session"`
+}
+
+// GetTotalConnectedTime returns the time that the peer was connected, summing its finished sessions.
+func (c *DBClient) GetTotalConnectedTime(pID peer.ID) (time.Duration, error) {
+	ctx, cancel := c.readCtx()
+	defer cancel()
+	log.Tracef("fetching total connected time of peer %s", pID.String())
+
+	var seconds int64
+	err := c.psqlPool.QueryRow(ctx, `
+		SELECT COALESCE(sum(duration), 0)::BIGINT
+		FROM conn_events
+		WHERE peer_id=$1 AND `+networkCond("network", 2)+`;
+		`, pID.String(), c.readNetwork()).Scan(&seconds)
+	if err != nil {
+		return 0, errors.Wrap(err, "unable to fetch total connected time of peer "+pID.String())
+	}
+	return time.Duration(seconds) * time.Second, nil
+}
+
+// GetAvgSessionDuration returns the average duration of the finished sessions of the peer,
+// 0 if it has none.
+func (c *DBClient) GetAvgSessionDuration(pID peer.ID) (time.Duration, error) {
+	ctx, cancel := c.readCtx()
+	defer cancel()
+	log.Tracef("fetching average session duration of peer %s", pID.String())
+
+	var seconds float64
+	err := c.psqlPool.QueryRow(ctx, `
+		SELECT COALESCE(avg(duration), 0)::float8
+		FROM conn_events
+		WHERE peer_id=$1 AND `+networkCond("network", 2)+`;
+		`, pID.String(), c.readNetwork()).Scan(&seconds)
+	if err != nil {
+		return 0, errors.Wrap(err, "unable to fetch average session duration of peer "+pID.String())
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+// GetConnectedTimeStats returns the duration of the sessions aggregated across all the peers.
+func (c *DBClient) GetConnectedTimeStats() (ConnectedTimeStats, error) {
+	ctx, cancel := c.readCtx()
+	defer cancel()
+	log.Debug("fetching connected time of all the peers")
+
+	var stats ConnectedTimeStats
+	var totalSeconds int64
+	var avgSeconds float64
+	err := c.psqlPool.QueryRow(ctx, `
+		SELECT
+			count(DISTINCT peer_id) FILTER (WHERE duration IS NOT NULL),
+			count(duration),
+			count(*) FILTER (WHERE duration IS NULL AND conn_time IS NOT NULL AND disconn_time IS NULL),
+			COALESCE(sum(duration), 0)::BIGINT,
+			COALESCE(avg(duration), 0)::float8
+		FROM conn_events
+		WHERE `+networkCond("network", 1)+`;
+		`, c.readNetwork()).Scan(
+		&stats.Peers,
+		&stats.Sessions,
+		&stats.OpenSessions,
+		&totalSeconds,
+		&avgSeconds,
+	)
+	if err != nil {
+		return ConnectedTimeStats{}, errors.Wrap(err, "unable to fetch connected time of the peers")
+	}
+	stats.TotalConnected = time.Duration(totalSeconds) * time.Second
+	stats.AvgSession = time.Duration(avgSeconds * float64(time.Second))
+	return stats, nil
+}
@@ -0,0 +1,144 @@
+package postgresql
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/migalabs/armiarma/pkg/db/models"
+	"github.com/migalabs/armiarma/pkg/utils"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnEventRowDuration(t *testing.T) {
+	dbCli := &DBClient{Network: utils.EthereumNetwork}
+	pID, err := peer.Decode("12D3KooW9pdHR2n4xvYU1RBEgrJMH1kd557QSXYURzEFWeEECjGn")
+	require.NoError(t, err)
+	t0 := time.Unix(1650000000, 0)
+
+	// open session
+	connEv := models.NewConnEvent(pID)
+	connEv.AddConnInfo(models.ConnInfo{ConnTime: t0, Direction: models.OutboundConnection})
+	row := dbCli.connEventRow(connEv)
+	require.Equal(t, t0.Unix(), row[2])
+	require.Nil(t, row[4])
+	require.Nil(t, row[14])
+
+	// finished
+	connEv.AddDisconn(models.EndConnInfo{DiscTime: t0.Add(90 * time.Second)})
+	row = dbCli.connEventRow(connEv)
+	require.Equal(t, t0.Add(90*time.Second).Unix(), row[4])
+	require.Equal(t, int64(90), row[14])
+
+	// clock skew, clamped to zero
+	skewed := models.NewConnEvent(pID)
+	skewed.AddConnInfo(models.ConnInfo{ConnTime: t0})
+	skewed.AddDisconn(models.EndConnInfo{DiscTime: t0.Add(-time.Minute)})
+	row = dbCli.connEventRow(skewed)
+	require.Nil(t, row[4])
+	require.Equal(t, true, row[7])
+	require.Equal(t, int64(0), row[14])
+
+	// disconnection without connection
+	orphan := models.NewConnEvent(pID)
+	orphan.AddDisconn(models.EndConnInfo{DiscTime: t0})
+	row = dbCli.connEventRow(orphan)
+	require.Nil(t, row[2])
+	require.Equal(t, t0.Unix(), row[4])
+	require.Equal(t, false, row[7])
+	require.Nil(t, row[14])
+}
+
+func TestConnectedTimeInPSQL(t *testing.T) {
+	dbCli, err := NewDBClient(context.Background(), utils.EthereumNetwork, loginStr, 24*time.Hour, WithReset())
+	require.NoError(t, err)
+	defer dbCli.Close()
+
+	pID, err := peer.Decode("12D3KooW9pdHR2n4xvYU1RBEgrJMH1kd557QSXYURzEFWeEECjGn")
+	require.NoError(t, err)
+	other, err := peer.Decode("12D3KooWLRPJAA5o6m3ZQbJsu9EVEFvLx2ke4cSg8LxpwYXmsd3d")
+	require.NoError(t, err)
+	q, args := dbCli.UpsertHostInfo(genNewTestHostInfo(t, utils.EthereumNetwork, pID.String(), "95.217.33.10", 9000))
+	_, err = dbCli.SingleQuery(q, args...)
+	require.NoError(t, err)
+	persist := func(connEv *models.ConnEvent) {
+		q, args := dbCli.InsertNewConnEvent(connEv)
+		_, err := dbCli.SingleQuery(q, args...)
+		require.NoError(t, err)
+	}
+	duration := func(connEv *models.ConnEvent) *int64 {
+		var seconds *int64
+		err := dbCli.psqlPool.QueryRow(dbCli.ctx, `SELECT duration FROM conn_events WHERE event_key=$1;`, connEv.Key()).Scan(&seconds)
+		require.NoError(t, err)
+		return seconds
+	}
+	t0 := time.Unix(1650000000, 0)
+
+	// the open session is persisted without duration
+	session := models.NewConnEvent(pID)
+	session.AddConnInfo(models.ConnInfo{ConnTime: t0, Direction: models.InboundConnection, Error: "None"})
+	persist(session)
+	require.Nil(t, duration(session))
+
+	// until it gets disconnected
+	session.AddDisconn(models.EndConnInfo{DiscTime: t0.Add(10 * time.Minute)})
+	persist(session)
+	require.Equal(t, int64(600), *duration(session))
+	// and the replays don't change it
+	replay := *session
+	replay.DiscTime = t0.Add(time.Hour)
+	persist(&replay)
+	require.Equal(t, int64(600), *duration(session))
+
+	second := models.NewConnEvent(pID)
+	second.AddConnInfo(models.ConnInfo{ConnTime: t0.Add(time.Hour), Direction: models.OutboundConnection, Error: "None"})
+	second.AddDisconn(models.EndConnInfo{DiscTime: t0.Add(time.Hour + 20*time.Minute)})
+	persist(second)
+
+	// the clock skews count as empty sessions
+	skewed := models.NewConnEvent(other)
+	skewed.AddConnInfo(models.ConnInfo{ConnTime: t0, Direction: models.InboundConnection, Error: "None"})
+	skewed.AddDisconn(models.EndConnInfo{DiscTime: t0.Add(-time.Minute)})
+	persist(skewed)
+	require.Equal(t, int64(0), *duration(skewed))
+
+	// the disconnections without connection are kept, without conn_time
+	orphan := models.NewConnEvent(pID)
+	orphan.AddDisconn(models.EndConnInfo{DiscTime: t0.Add(2 * time.Hour)})
+	persist(orphan)
+	var connTime *int64
+	err = dbCli.psqlPool.QueryRow(dbCli.ctx, `SELECT conn_time FROM conn_events WHERE event_key=$1;`, orphan.Key()).Scan(&connTime)
+	require.NoError(t, err)
+	require.Nil(t, connTime)
+
+	// another session still open
+	open := models.NewConnEvent(other)
+	open.AddConnInfo(models.ConnInfo{ConnTime: t0.Add(3 * time.Hour), Direction: models.InboundConnection, Error: "None"})
+	persist(open)
+
+	total, err := dbCli.GetTotalConnectedTime(pID)
+	require.NoError(t, err)
+	require.Equal(t, 30*time.Minute, total)
+	avg, err := dbCli.GetAvgSessionDuration(pID)
+	require.NoError(t, err)
+	require.Equal(t, 15*time.Minute, avg)
+
+	stats, err := dbCli.GetConnectedTimeStats()
+	require.NoError(t, err)
+	require.Equal(t, ConnectedTimeStats{
+		Peers:          2,
+		Sessions:       3,
+		OpenSessions:   1,
+		TotalConnected: 30 * time.Minute,
+		AvgSession:     10 * time.Minute,
+	}, stats)
+
+	// the peer record includes the orphan disconnection
+	record, err := dbCli.GetPeerFullRecord(pID)
+	require.NoError(t, err)
+	require.Len(t, record.ConnEvents, 3)
+	require.Nil(t, record.ConnEvents[0].ConnTime)
+	require.Nil(t, record.ConnEvents[0].DurationSecs)
+	require.Equal(t, int64(1200), *record.ConnEvents[1].DurationSecs)
+}
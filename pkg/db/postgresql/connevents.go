@@ -1,24 +1,44 @@
 package postgresql
 
 import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
 	"github.com/migalabs/armiarma/pkg/db/models"
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 )
 
+const (
+	// rows deleted per transaction when pruning the conn_events
+	pruneChunkSize = 10000
+	// pause between the pruning chunks, to not hold the table for too long
+	pruneChunkSleep = 100 * time.Millisecond
+)
+
 func (c *DBClient) InitConnEventTable() error {
 	log.Debugf("init conn_events table in psql-db\n")
 
-	_, err := c.psqlPool.Exec(c.ctx, `
+	err := c.execSchema(`
 		CREATE TABLE IF NOT EXISTS conn_events(
 			id SERIAL,
 			peer_id TEXT NOT NULL,
+			network TEXT NOT NULL,
 			direction TEXT NOT NULL,
-			conn_time BIGINT NOT NULL, 
+			conn_time BIGINT,
 			latency BIGINT,
-			disconn_time BIGINT NOT NULL,
+			disconn_time BIGINT,
 			identified BOOL,
 			error TEXT NOT NULL,
+			timestamp_anomaly BOOL,
+			goodbye_reason TEXT,
+			local_addr TEXT,
+			remote_addr TEXT,
+			relayed BOOL,
+			duration BIGINT,
 
 			PRIMARY KEY (id)
 		);
@@ -28,31 +48,329 @@ func (c *DBClient) InitConnEventTable() error {
 		return errors.Wrap(err, "initializing conn_events table")
 	}
 
+	// the disconn_time is null for the events with a timestamp anomaly
+	err = c.execSchema(`
+		ALTER TABLE conn_events
+			ALTER COLUMN disconn_time DROP NOT NULL,
+			ADD COLUMN IF NOT EXISTS timestamp_anomaly BOOL;
+		`)
+	if err != nil {
+		return errors.Wrap(err, "adding timestamp_anomaly to conn_events table")
+	}
+
+	err = c.execSchema(`
+		ALTER TABLE conn_events
+			ADD COLUMN IF NOT EXISTS goodbye_reason TEXT;
+		`)
+	if err != nil {
+		return errors.Wrap(err, "adding goodbye_reason to conn_events table")
+	}
+
+	err = c.execSchema(`
+		ALTER TABLE conn_events
+			ADD COLUMN IF NOT EXISTS local_addr TEXT,
+			ADD COLUMN IF NOT EXISTS remote_addr TEXT;
+		`)
+	if err != nil {
+		return errors.Wrap(err, "adding local_addr and remote_addr to conn_events table")
+	}
+
+	err = c.execSchema(`
+		ALTER TABLE conn_events
+			ADD COLUMN IF NOT EXISTS relayed BOOL;
+		`)
+	if err != nil {
+		return errors.Wrap(err, "adding relayed to conn_events table")
+	}
+
+	// the duration (seconds) is null while the session is open, and for the disconnections
+	// without connection, whose conn_time is null
+	hasDuration, err := c.columnExists("conn_events", "duration")
+	if err != nil {
+		return err
+	}
+	err = c.execSchema(`
+		ALTER TABLE conn_events
+			ALTER COLUMN conn_time DROP NOT NULL,
+			ADD COLUMN IF NOT EXISTS duration BIGINT;
+		`)
+	if err != nil {
+		return errors.Wrap(err, "adding duration to conn_events table")
+	}
+	if !hasDuration {
+		err = c.backfillConnEventDurations()
+		if err != nil {
+			return err
+		}
+	}
+
+	// the events persisted before the network column existed belong to the network of the client
+	err = c.execSchema(`
+		ALTER TABLE conn_events
+			ADD COLUMN IF NOT EXISTS ` + c.networkColumn() + `;
+		`)
+	if err != nil {
+		return errors.Wrap(err, "adding network to conn_events table")
+	}
+
+	// the event_key makes the replays of the same event (batch retries, spill replays) idempotent
+	err = c.execSchema(`
+		ALTER TABLE conn_events
+			ADD COLUMN IF NOT EXISTS event_key TEXT;
+		`)
+	if err != nil {
+		return errors.Wrap(err, "adding event_key to conn_events table")
+	}
+	err = c.backfillConnEventKeys()
+	if err != nil {
+		return err
+	}
+	// the same event can be stored once per network (the key doesn't include it)
+	err = c.execSchema(`
+		CREATE UNIQUE INDEX IF NOT EXISTS conn_events_network_event_key
+		ON conn_events (event_key, network);
+		`)
+	if err != nil {
+		return errors.Wrap(err, "indexing event_key of conn_events table")
+	}
+	err = c.execSchema(`
+		DROP INDEX IF EXISTS conn_events_event_key;
+		`)
+	if err != nil {
+		return errors.Wrap(err, "dropping the event_key index without network of conn_events table")
+	}
+
 	return nil
 }
 
+// columnExists returns whether the table has the column, false while composing the expected schema.
+func (c *DBClient) columnExists(table, column string) (bool, error) {
+	if c.schemaStmts != nil {
+		return false, nil
+	}
+	var exists bool
+	err := c.schemaDB().QueryRow(c.ctx, `
+		SELECT EXISTS (
+			SELECT 1
+			FROM pg_attribute
+			WHERE attrelid = to_regclass($1) AND attname = $2 AND NOT attisdropped
+		);`, c.qualify(table), column).Scan(&exists)
+	if err != nil {
+		return false, errors.Wrapf(err, "unable to check the column %s of table %s", column, table)
+	}
+	return exists, nil
+}
+
+// backfillConnEventDurations computes the duration of the finished sessions persisted before it
+// existed, clamped to zero as the ones of the timestamp anomalies.
+func (c *DBClient) backfillConnEventDurations() error {
+	if c.schemaStmts != nil {
+		return nil
+	}
+	log.Info("backfilling the duration of the conn_events")
+	tag, err := c.schemaDB().Exec(c.ctx, `
+		UPDATE conn_events
+		SET duration = CASE WHEN timestamp_anomaly THEN 0 ELSE GREATEST(disconn_time - conn_time, 0) END
+		WHERE duration IS NULL AND conn_time IS NOT NULL AND (disconn_time IS NOT NULL OR timestamp_anomaly);`)
+	if err != nil {
+		return errors.Wrap(err, "unable to backfill the duration of conn_events table")
+	}
+	log.Infof("backfilled the duration of %d conn_events", tag.RowsAffected())
+	return nil
+}
+
+// backfillConnEventKeys composes the event_key of the rows persisted before it existed (same as
+// models.ConnEventKey), and removes the duplicated events of each network, keeping the first one,
+// so that the unique index can be created. It only runs until the index exists.
+func (c *DBClient) backfillConnEventKeys() error {
+	// nothing to migrate while composing the expected schema
+	if c.schemaStmts != nil {
+		return nil
+	}
+	var indexed bool
+	err := c.schemaDB().QueryRow(c.ctx, `SELECT to_regclass($1) IS NOT NULL;`, c.qualify("conn_events_network_event_key")).Scan(&indexed)
+	if err != nil {
+		return errors.Wrap(err, "unable to check the event_key index of conn_events table")
+	}
+	if indexed {
+		return nil
+	}
+	log.Info("backfilling the event_key of the conn_events")
+	tag, err := c.schemaDB().Exec(c.ctx, `
+		UPDATE conn_events
+		SET event_key = md5(peer_id || ':' || conn_time || ':' || direction)
+		WHERE event_key IS NULL;
+		`)
+	if err != nil {
+		return errors.Wrap(err, "unable to backfill event_key of conn_events table")
+	}
+	log.Infof("backfilled the event_key of %d conn_events", tag.RowsAffected())
+	tag, err = c.schemaDB().Exec(c.ctx, `
+		DELETE FROM conn_events dup
+		USING conn_events first
+		WHERE dup.event_key = first.event_key AND dup.network = first.network AND dup.id > first.id;
+		`)
+	if err != nil {
+		return errors.Wrap(err, "unable to remove duplicated conn_events")
+	}
+	log.Infof("removed %d duplicated conn_events", tag.RowsAffected())
+	return nil
+}
+
+// connEventColumns are the columns of the conn_events rows, in the order of the values of connEventRow.
+var connEventColumns = []string{
+	"peer_id",
+	"direction",
+	"conn_time",
+	"latency",
+	"disconn_time",
+	"identified",
+	"error",
+	"timestamp_anomaly",
+	"goodbye_reason",
+	"local_addr",
+	"remote_addr",
+	"relayed",
+	"event_key",
+	"network",
+	"duration",
+}
+
+// connEventConflict fills the disconnection of the sessions persisted while they were open,
+// skipping the replays of the already persisted events.
+const connEventConflict = `
+		ON CONFLICT (event_key, network) DO UPDATE SET
+			disconn_time = EXCLUDED.disconn_time,
+			timestamp_anomaly = EXCLUDED.timestamp_anomaly,
+			goodbye_reason = EXCLUDED.goodbye_reason,
+			duration = EXCLUDED.duration
+		WHERE conn_events.duration IS NULL AND EXCLUDED.duration IS NOT NULL`
+
+// insertConnEventQuery inserts a single conn_event (see connEventConflict).
+var insertConnEventQuery = `
+		INSERT INTO conn_events (` + strings.Join(connEventColumns, ", ") + `)
+		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15)` + connEventConflict + `
+		`
+
 func (c *DBClient) InsertNewConnEvent(connEv *models.ConnEvent) (query string, args []interface{}) {
 	log.Trace("inserting new connection event to conn_event in psql-db")
-	// compose query
-	query = `
-		INSERT INTO conn_events (
-			peer_id,
-			direction,
-			conn_time, 
-			latency,
-			disconn_time,
-			identified,
-			error)
-			VALUES ($1,$2,$3,$4,$5,$6,$7)
-		`
+	return insertConnEventQuery, c.connEventRow(connEv)
+}
+
+// connEventRow returns the values of the conn_event in the order of connEventColumns,
+// the same for the single inserts and for the bulk copies. The open sessions have neither
+// disconn_time nor duration, and the disconnections without connection have no conn_time
+// nor duration.
+func (c *DBClient) connEventRow(connEv *models.ConnEvent) []interface{} {
+	var connTime, discTime, duration interface{}
+	if !connEv.ConnTime.IsZero() {
+		connTime = connEv.ConnTime.Unix()
+	}
+	anomaly := false
+	if !connEv.DiscTime.IsZero() {
+		discTime = connEv.DiscTime.Unix()
+		if !connEv.ConnTime.IsZero() {
+			// never persist a disconnection older than its connection
+			anomaly = connEv.TimestampAnomaly || connEv.DiscTime.Before(connEv.ConnTime)
+			if anomaly {
+				log.Debugf("conn_event of peer %s with disconnection before connection, persisting it without disconn_time", connEv.PeerID.String())
+				discTime = nil
+			}
+			duration = sessionDuration(connEv)
+		}
+	}
+	return []interface{}{
+		connEv.PeerID.String(),
+		models.DirectionIndexToString(connEv.Direction),
+		connTime,
+		connEv.Latency.Milliseconds(),
+		discTime,
+		connEv.Identified,
+		connEv.Error,
+		anomaly,
+		nullIfEmpty(connEv.GoodbyeReason),
+		nullIfEmpty(connEv.LocalAddr),
+		nullIfEmpty(connEv.RemoteAddr),
+		connEv.Relayed,
+		connEv.Key(),
+		string(c.Network),
+		duration,
+	}
+}
 
-	args = append(args, connEv.PeerID.String())
-	args = append(args, models.DirectionIndexToString(connEv.Direction))
-	args = append(args, connEv.ConnTime.Unix())
-	args = append(args, connEv.Latency.Milliseconds())
-	args = append(args, connEv.DiscTime.Unix())
-	args = append(args, connEv.Identified)
-	args = append(args, connEv.Error)
+// sessionDuration returns the seconds connected of the finished session, zero for the timestamp anomalies.
+func sessionDuration(connEv *models.ConnEvent) int64 {
+	seconds := connEv.DiscTime.Unix() - connEv.ConnTime.Unix()
+	if connEv.TimestampAnomaly || seconds < 0 {
+		return 0
+	}
+	return seconds
+}
 
-	return query, args
+// nullIfEmpty persists the empty strings as NULL.
+func nullIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// copyConnEvents copies the rows of the conn_events (see connEventRow) at once within the transaction.
+// As COPY can't handle the already persisted events, the rows are copied into a temporary table,
+// dropped on commit, from which they are inserted as the single ones (see connEventConflict). A row
+// can't be updated twice by the same statement, so only one row per event is kept, the finished one
+// if the copy also has the open session.
+func copyConnEvents(ctx context.Context, tx pgx.Tx, rows [][]interface{}) (pgconn.CommandTag, error) {
+	columns := strings.Join(connEventColumns, ", ")
+	_, err := tx.Exec(ctx, `
+		CREATE TEMPORARY TABLE conn_events_copy ON COMMIT DROP AS
+		SELECT `+columns+` FROM conn_events WITH NO DATA;`)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to create the temporary table of the conn_events")
+	}
+	_, err = tx.CopyFrom(ctx, pgx.Identifier{"conn_events_copy"}, connEventColumns, pgx.CopyFromRows(rows))
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to copy the conn_events")
+	}
+	tag, err := tx.Exec(ctx, `
+		INSERT INTO conn_events (`+columns+`)
+		SELECT DISTINCT ON (event_key, network) `+columns+`
+		FROM conn_events_copy
+		ORDER BY event_key, network, duration IS NULL`+connEventConflict+`;`)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to insert the copied conn_events")
+	}
+	return tag, nil
+}
+
+// PruneConnEvents deletes the conn_events of the network whose connection is older than the given duration,
+// in chunks of pruneChunkSize rows (one transaction each) so that the table isn't locked.
+// Returns the total number of deleted rows.
+func (c *DBClient) PruneConnEvents(olderThan time.Duration) (int64, error) {
+	cutoff := time.Now().Add(-olderThan).Unix()
+	log.Debugf("pruning conn_events older than %s", time.Unix(cutoff, 0).String())
+
+	var total int64
+	for {
+		tag, err := c.psqlPool.Exec(c.ctx, `
+			DELETE FROM conn_events
+			WHERE id IN (
+				SELECT id FROM conn_events
+				WHERE COALESCE(conn_time, disconn_time) < $1 AND network = $3
+				LIMIT $2
+			);
+			`, cutoff, pruneChunkSize, string(c.Network))
+		if err != nil {
+			return total, errors.Wrap(err, "unable to prune conn_events")
+		}
+		total += tag.RowsAffected()
+		if tag.RowsAffected() < pruneChunkSize {
+			return total, nil
+		}
+		select {
+		case <-time.After(pruneChunkSleep):
+		case <-c.ctx.Done():
+			return total, c.ctx.Err()
+		}
+	}
 }
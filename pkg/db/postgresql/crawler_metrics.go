@@ -2,6 +2,9 @@ package postgresql
 
 import (
 	"fmt"
+
+	"github.com/migalabs/armiarma/pkg/db/models"
+	"github.com/migalabs/armiarma/pkg/utils"
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 )
@@ -14,11 +17,13 @@ var (
 
 // Basic call over the whole list of non-deprecated peers
 func (db *DBClient) GetClientDistribution() (map[string]interface{}, error) {
+	ctx, cancel := db.readCtx()
+	defer cancel()
 	log.Debug("fetching client distribution metrics")
 	cliDist := make(map[string]interface{}, 0)
 
 	rows, err := db.psqlPool.Query(
-		db.ctx,
+		ctx,
 		`
 		SELECT 
 			client_name, count(client_name) as count
@@ -27,11 +32,16 @@ func (db *DBClient) GetClientDistribution() (map[string]interface{}, error) {
 			deprecated = 'false' and 
 		    attempted = 'true' and 
 		    client_name IS NOT NULL and 
-		    to_timestamp(last_activity) > CURRENT_TIMESTAMP - ($1 * INTERVAL '1 DAY')
+		    to_timestamp(last_activity) > CURRENT_TIMESTAMP - ($1 * INTERVAL '1 DAY') and
+		    ($2 or peer_category IS DISTINCT FROM $3) and
+		    `+networkCond("network", 4)+`
 		GROUP BY client_name
 		ORDER BY count DESC;
 		`,
 		LastActivityValidRange,
+		db.includeOtherLibp2p,
+		string(utils.OtherLibp2pCategory),
+		db.readNetwork(),
 	)
 	// make sure we close the rows and we free the connection/session
 	defer rows.Close()
@@ -53,13 +63,111 @@ func (db *DBClient) GetClientDistribution() (map[string]interface{}, error) {
 	return cliDist, nil
 }
 
+// GetClientStatusSuccessRate returns the ratio of succeeded Status requests per client,
+// aggregating the requests sent to all the non-deprecated peers of each client.
+func (db *DBClient) GetClientStatusSuccessRate() (map[string]float64, error) {
+	ctx, cancel := db.readCtx()
+	defer cancel()
+	log.Debug("fetching client status success rate metrics")
+	rates := make(map[string]float64, 0)
+
+	rows, err := db.psqlPool.Query(
+		ctx,
+		`
+		SELECT
+			pi.client_name,
+			sum(es.status_successes)::float / sum(es.status_requests) as rate
+		FROM peer_info as pi
+		INNER JOIN eth_status as es ON pi.peer_id = es.peer_id AND pi.network = es.network
+		WHERE
+			pi.deprecated = 'false' and
+			pi.client_name IS NOT NULL and
+			es.status_requests > 0 and
+			($1 or pi.peer_category IS DISTINCT FROM $2) and
+			`+networkCond("pi.network", 3)+`
+		GROUP BY pi.client_name;
+		`,
+		db.includeOtherLibp2p,
+		string(utils.OtherLibp2pCategory),
+		db.readNetwork(),
+	)
+	// make sure we close the rows and we free the connection/session
+	defer rows.Close()
+	if err != nil {
+		return rates, errors.Wrap(err, "unable to fetch client status success rate")
+	}
+
+	for rows.Next() {
+		var cliName string
+		var rate float64
+		err = rows.Scan(&cliName, &rate)
+		if err != nil {
+			return rates, errors.Wrap(err, "unable to parse client status success rate")
+		}
+		rates[cliName] = rate
+	}
+
+	return rates, nil
+}
+
+// GetQualityScoreDistribution returns the number of non-deprecated peers per client and range of
+// quality score (20 points wide, the last one including 100), keyed as "<client>_<range>" (i.e. "prysm_60-80").
+func (db *DBClient) GetQualityScoreDistribution() (map[string]interface{}, error) {
+	ctx, cancel := db.readCtx()
+	defer cancel()
+	log.Debug("fetching quality score distribution metrics")
+	scoreDist := make(map[string]interface{}, 0)
+
+	rows, err := db.psqlPool.Query(
+		ctx,
+		`
+		SELECT
+			client_name,
+			LEAST(floor(quality_score / 20)::INT, 4) * 20 as score_range,
+			count(*) as cnt
+		FROM peer_info
+		WHERE
+			deprecated = 'false' and
+			client_name IS NOT NULL and
+			quality_score IS NOT NULL and
+			($1 or peer_category IS DISTINCT FROM $2) and
+			`+networkCond("network", 3)+`
+		GROUP BY client_name, score_range
+		ORDER BY client_name, score_range;
+		`,
+		db.includeOtherLibp2p,
+		string(utils.OtherLibp2pCategory),
+		db.readNetwork(),
+	)
+	// make sure we close the rows and we free the connection/session
+	defer rows.Close()
+	if err != nil {
+		return scoreDist, errors.Wrap(err, "unable to fetch quality score distribution")
+	}
+
+	for rows.Next() {
+		var cliName string
+		var scoreRange int
+		var count int
+		err = rows.Scan(&cliName, &scoreRange, &count)
+		if err != nil {
+			return scoreDist, errors.Wrap(err, "unable to parse quality score distribution")
+		}
+		scoreDist[fmt.Sprintf("%s_%d-%d", cliName, scoreRange, scoreRange+20)] = count
+	}
+
+	return scoreDist, nil
+}
+
 // Basic call over the whole list of non-deprecated peers
 func (db *DBClient) GetVersionDistribution() (map[string]interface{}, error) {
+	ctx, cancel := db.readCtx()
+	defer cancel()
 	log.Debug("fetching client distribution metrics")
 	verDist := make(map[string]interface{}, 0)
 
 	rows, err := db.psqlPool.Query(
-		db.ctx,
+		ctx,
 		`
 		SELECT client_name,
 			client_version, 
@@ -69,11 +177,16 @@ func (db *DBClient) GetVersionDistribution() (map[string]interface{}, error) {
 			deprecated = 'false' and 
 			attempted = 'true' and 
 			client_name IS NOT NULL and 
-			to_timestamp(last_activity) > CURRENT_TIMESTAMP - ($1 * INTERVAL '1 DAY')
+			to_timestamp(last_activity) > CURRENT_TIMESTAMP - ($1 * INTERVAL '1 DAY') and
+			($2 or peer_category IS DISTINCT FROM $3) and
+			`+networkCond("network", 4)+`
 		GROUP BY client_name, client_version
 		ORDER BY client_name DESC, cnt DESC;
 		`,
 		LastActivityValidRange,
+		db.includeOtherLibp2p,
+		string(utils.OtherLibp2pCategory),
+		db.readNetwork(),
 	)
 	// make sure we close the rows and we free the connection/session
 	defer rows.Close()
@@ -95,32 +208,39 @@ func (db *DBClient) GetVersionDistribution() (map[string]interface{}, error) {
 	return verDist, nil
 }
 
-// Basic call over the whole list of non-deprecated peers
+// GetGeoDistribution returns the number of non-deprecated peers per country, grouped by the ISO 3166-1
+// alpha-2 code of the country (names differ between providers) and carrying the name for display.
 func (db *DBClient) GetGeoDistribution() (map[string]interface{}, error) {
+	ctx, cancel := db.readCtx()
+	defer cancel()
 	log.Debug("fetching client distribution metrics")
 	geoDist := make(map[string]interface{}, 0)
 
 	rows, err := db.psqlPool.Query(
-		db.ctx,
+		ctx,
 		`
 		SELECT 
 			aux.country_code as country_code,
+			max(aux.country) as country,
 			count(aux.country_code) as cnt
 		FROM (
 			SELECT peer_info.peer_id, 
 				ips.ip,
-				ips.country_code
+				ips.country_code,
+				ips.country
 			FROM peer_info
 			RIGHT JOIN ips on peer_info.ip = ips.ip
 			WHERE deprecated = 'false' and 
 			      attempted = 'true' and 
 			      client_name IS NOT NULL and 
-			      to_timestamp(last_activity) > CURRENT_TIMESTAMP - ($1 * INTERVAL '1 DAY')
+			      to_timestamp(last_activity) > CURRENT_TIMESTAMP - ($1 * INTERVAL '1 DAY') and
+			      `+networkCond("peer_info.network", 2)+`
 		) as aux 
 		GROUP BY country_code
 		ORDER BY cnt DESC;
 		`,
 		LastActivityValidRange,
+		db.readNetwork(),
 	)
 	// make sure we close the rows and we free the connection/session
 	defer rows.Close()
@@ -129,22 +249,23 @@ func (db *DBClient) GetGeoDistribution() (map[string]interface{}, error) {
 	}
 
 	for rows.Next() {
-		var country string
-		var count int
-		err = rows.Scan(&country, &count)
+		var countryCount models.CountryCount
+		err = rows.Scan(&countryCount.CountryCode, &countryCount.Country, &countryCount.Peers)
 		if err != nil {
 			return geoDist, errors.Wrap(err, "unable to parse fetch client distribution")
 		}
-		geoDist[country] = count
+		geoDist[countryCount.CountryCode] = countryCount
 	}
 
 	return geoDist, nil
 }
 
 func (db *DBClient) GetOsDistribution() (map[string]interface{}, error) {
+	ctx, cancel := db.readCtx()
+	defer cancel()
 	summary := make(map[string]interface{}, 0)
 	rows, err := db.psqlPool.Query(
-		db.ctx,
+		ctx,
 		`
 		SELECT
 			client_os,
@@ -153,11 +274,13 @@ func (db *DBClient) GetOsDistribution() (map[string]interface{}, error) {
 		WHERE deprecated='false' and 
 		      attempted='true' and 
 		      client_name IS NOT NULL and 
-		      to_timestamp(last_activity) > CURRENT_TIMESTAMP - ($1 * INTERVAL '1 DAY')
+		      to_timestamp(last_activity) > CURRENT_TIMESTAMP - ($1 * INTERVAL '1 DAY') and
+		      `+networkCond("network", 2)+`
 		GROUP BY client_os
 		ORDER BY nodes DESC;
 		`,
 		LastActivityValidRange,
+		db.readNetwork(),
 	)
 	if err != nil {
 		return summary, err
@@ -172,9 +295,11 @@ func (db *DBClient) GetOsDistribution() (map[string]interface{}, error) {
 }
 
 func (db *DBClient) GetArchDistribution() (map[string]interface{}, error) {
+	ctx, cancel := db.readCtx()
+	defer cancel()
 	summary := make(map[string]interface{}, 0)
 	rows, err := db.psqlPool.Query(
-		db.ctx,
+		ctx,
 		`
 		SELECT
 			client_arch,
@@ -183,11 +308,13 @@ func (db *DBClient) GetArchDistribution() (map[string]interface{}, error) {
 		WHERE deprecated='false' and 
 		      attempted='true' and 
 		      client_name IS NOT NULL and 
-		      to_timestamp(last_activity) > CURRENT_TIMESTAMP - ($1 * INTERVAL '1 DAY')
+		      to_timestamp(last_activity) > CURRENT_TIMESTAMP - ($1 * INTERVAL '1 DAY') and
+		      `+networkCond("network", 2)+`
 		GROUP BY client_arch
 		ORDER BY nodes DESC;
 		`,
 		LastActivityValidRange,
+		db.readNetwork(),
 	)
 	if err != nil {
 		return summary, err
@@ -202,11 +329,13 @@ func (db *DBClient) GetArchDistribution() (map[string]interface{}, error) {
 }
 
 func (db *DBClient) GetHostingDistribution() (map[string]interface{}, error) {
+	ctx, cancel := db.readCtx()
+	defer cancel()
 	summary := make(map[string]interface{})
 	// get the number of mobile hosts
 	var mobile int
 	err := db.psqlPool.QueryRow(
-		db.ctx,
+		ctx,
 		`
 		SELECT 
 			count(aux.mobile) as mobile
@@ -224,10 +353,12 @@ func (db *DBClient) GetHostingDistribution() (map[string]interface{}, error) {
 			      attempted = 'true' and 
 			      client_name IS NOT NULL and 
 			      ips.mobile='true' and 
-			      to_timestamp(last_activity) > CURRENT_TIMESTAMP - ($1 * INTERVAL '1 DAY')
+			      to_timestamp(last_activity) > CURRENT_TIMESTAMP - ($1 * INTERVAL '1 DAY') and
+			      `+networkCond("pi.network", 2)+`
 		) as aux
 		`,
 		LastActivityValidRange,
+		db.readNetwork(),
 	).Scan(&mobile)
 	if err != nil {
 		return summary, err
@@ -237,7 +368,7 @@ func (db *DBClient) GetHostingDistribution() (map[string]interface{}, error) {
 	// get the number of proxy peers
 	var proxy int
 	err = db.psqlPool.QueryRow(
-		db.ctx,
+		ctx,
 		`
 		SELECT 
 			count(aux.proxy) as under_proxy
@@ -254,10 +385,12 @@ func (db *DBClient) GetHostingDistribution() (map[string]interface{}, error) {
 			WHERE pi.deprecated='false' and 
 			      attempted = 'true' and 
 			      client_name IS NOT NULL and ips.proxy='true' and 
-			      to_timestamp(last_activity) > CURRENT_TIMESTAMP - ($1 * INTERVAL '1 DAY')
+			      to_timestamp(last_activity) > CURRENT_TIMESTAMP - ($1 * INTERVAL '1 DAY') and
+			      `+networkCond("pi.network", 2)+`
 		) as aux
 		`,
 		LastActivityValidRange,
+		db.readNetwork(),
 	).Scan(&proxy)
 	if err != nil {
 		return summary, err
@@ -267,7 +400,7 @@ func (db *DBClient) GetHostingDistribution() (map[string]interface{}, error) {
 	// get the number of hosted IPs
 	var hosted int
 	err = db.psqlPool.QueryRow(
-		db.ctx,
+		ctx,
 		`
 		SELECT 
 			count(aux.hosting) as hosted_ips
@@ -285,10 +418,12 @@ func (db *DBClient) GetHostingDistribution() (map[string]interface{}, error) {
 			      attempted = 'true' and 
 			      client_name IS NOT NULL and 
 			      ips.hosting='true' and 
-			      to_timestamp(last_activity) > CURRENT_TIMESTAMP - ($1 * INTERVAL '1 DAY')
+			      to_timestamp(last_activity) > CURRENT_TIMESTAMP - ($1 * INTERVAL '1 DAY') and
+			      `+networkCond("pi.network", 2)+`
 		) as aux		
 		`,
 		LastActivityValidRange,
+		db.readNetwork(),
 	).Scan(&hosted)
 	if err != nil {
 		return summary, err
@@ -297,11 +432,57 @@ func (db *DBClient) GetHostingDistribution() (map[string]interface{}, error) {
 	return summary, nil
 }
 
+// GetProviderDistribution returns the number of non-deprecated peers per cloud or hosting provider,
+// counting the located peers outside the ranges of the known providers as utils.Unknown.
+func (db *DBClient) GetProviderDistribution() (map[string]interface{}, error) {
+	ctx, cancel := db.readCtx()
+	defer cancel()
+	summary := make(map[string]interface{}, 0)
+
+	rows, err := db.psqlPool.Query(
+		ctx,
+		`
+		SELECT
+			COALESCE(NULLIF(ips.provider, ''), $2) as provider,
+			count(pi.peer_id) as peers
+		FROM peer_info as pi
+		INNER JOIN ips ON pi.ip=ips.ip
+		WHERE pi.deprecated='false' and 
+		      attempted = 'true' and 
+		      client_name IS NOT NULL and 
+		      to_timestamp(last_activity) > CURRENT_TIMESTAMP - ($1 * INTERVAL '1 DAY') and
+		      `+networkCond("pi.network", 3)+`
+		GROUP BY 1
+		ORDER BY peers DESC;
+		`,
+		LastActivityValidRange,
+		utils.Unknown,
+		db.readNetwork(),
+	)
+	if err != nil {
+		return summary, errors.Wrap(err, "unable to fetch provider distribution")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var provider string
+		var peers int
+		err = rows.Scan(&provider, &peers)
+		if err != nil {
+			return summary, errors.Wrap(err, "unable to parse provider distribution")
+		}
+		summary[provider] = peers
+	}
+	return summary, rows.Err()
+}
+
 func (db *DBClient) GetRTTDistribution() (map[string]interface{}, error) {
+	ctx, cancel := db.readCtx()
+	defer cancel()
 	summary := make(map[string]interface{}, 0)
 
 	rows, err := db.psqlPool.Query(
-		db.ctx,
+		ctx,
 		`
 		SELECT 
 			t.latency as latency_range,
@@ -324,12 +505,14 @@ func (db *DBClient) GetRTTDistribution() (map[string]interface{}, error) {
 			FROM peer_info 
 			WHERE deprecated=false and 
 			      client_name IS NOT NULL and 
-			      to_timestamp(last_activity) > CURRENT_TIMESTAMP - ($1 * INTERVAL '1 DAY')
+			      to_timestamp(last_activity) > CURRENT_TIMESTAMP - ($1 * INTERVAL '1 DAY') and
+			      `+networkCond("network", 2)+`
 		) as t 
 		GROUP BY t.latency 
 		ORDER BY nodes DESC;	
 		`,
 		LastActivityValidRange,
+		db.readNetwork(),
 	)
 	if err != nil {
 		return summary, err
@@ -351,10 +534,12 @@ func (db *DBClient) GetRTTDistribution() (map[string]interface{}, error) {
 }
 
 func (db *DBClient) GetIPDistribution() (map[string]interface{}, error) {
+	ctx, cancel := db.readCtx()
+	defer cancel()
 	summary := make(map[string]interface{}, 0)
 
 	rows, err := db.psqlPool.Query(
-		db.ctx,
+		ctx,
 		`
 		SELECT 
 			nodes as nodes_per_ip, 
@@ -366,7 +551,8 @@ func (db *DBClient) GetIPDistribution() (map[string]interface{}, error) {
 			FROM peer_info 
 			WHERE deprecated = false and 
 			      client_name IS NOT NULL and 
-			      to_timestamp(last_activity) > CURRENT_TIMESTAMP - ($1 * INTERVAL '1 DAY')
+			      to_timestamp(last_activity) > CURRENT_TIMESTAMP - ($1 * INTERVAL '1 DAY') and
+			      `+networkCond("network", 2)+`
 			GROUP BY ip 
 			ORDER BY nodes DESC 
 		) as t 
@@ -374,6 +560,7 @@ func (db *DBClient) GetIPDistribution() (map[string]interface{}, error) {
 		ORDER BY number_of_ips DESC;	
 		`,
 		LastActivityValidRange,
+		db.readNetwork(),
 	)
 	if err != nil {
 		return summary, err
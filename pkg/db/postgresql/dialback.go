@@ -0,0 +1,65 @@
+package postgresql
+
+import (
+	"github.com/migalabs/armiarma/pkg/db/models"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// UpdateDialback stores the outcome of the last dial-back to the peer, unless the stored one is more recent.
+func (c *DBClient) UpdateDialback(dialback models.Dialback) (query string, args []interface{}) {
+	log.Trace("updating dialback in peer_info in psql-db")
+	query = `
+		UPDATE peer_info
+		SET
+			dialback_attempted=true,
+			dialback_succeeded=$2,
+			dialback_error=NULLIF($3, ''),
+			dialback_time=$4
+		WHERE peer_id=$1 AND network=$5 AND COALESCE(dialback_time, 0) <= $4;
+	`
+
+	args = append(args, dialback.PeerID.String())
+	args = append(args, dialback.Success)
+	args = append(args, dialback.Error)
+	args = append(args, dialback.Timestamp.Unix())
+	args = append(args, string(c.Network))
+
+	return query, args
+}
+
+// GetDialbackUnreachableRatePerClient returns the ratio of the inbound-only peers of each client
+// (that we never managed to dial ourselves) that didn't answer the dial-back either, i.e. that are
+// likely behind a NAT. Only the dialed-back peers are considered.
+func (d *DBClient) GetDialbackUnreachableRatePerClient() (map[string]interface{}, error) {
+	ctx, cancel := d.readCtx()
+	defer cancel()
+	log.Debug("fetching dialback unreachable rate per client")
+	clientUnreachable := make(map[string]interface{})
+
+	rows, err := d.psqlPool.Query(ctx, `
+		SELECT
+			COALESCE(NULLIF(client_name, ''), 'unknown') AS client,
+			avg(CASE WHEN dialback_succeeded THEN 0.0 ELSE 1.0 END) AS unreachable_rate
+		FROM peer_info
+		WHERE dialback_attempted AND successful_attempts = 0 AND `+networkCond("network", 1)+`
+		GROUP BY client
+		ORDER BY client;
+		`, d.readNetwork())
+	if err != nil {
+		return clientUnreachable, errors.Wrap(err, "unable to fetch dialback unreachable rate per client")
+	}
+	// make sure we close the rows and we free the connection/session
+	defer rows.Close()
+
+	for rows.Next() {
+		var client string
+		var rate float64
+		err = rows.Scan(&client, &rate)
+		if err != nil {
+			return clientUnreachable, errors.Wrap(err, "unable to parse fetched dialback unreachable rate per client")
+		}
+		clientUnreachable[client] = rate
+	}
+	return clientUnreachable, rows.Err()
+}
@@ -0,0 +1,58 @@
+package postgresql
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/migalabs/armiarma/pkg/db/models"
+	"github.com/migalabs/armiarma/pkg/utils"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUpdateDialback(t *testing.T) {
+	dbCli := &DBClient{}
+	pID := peer.ID("peer")
+	ts := time.Unix(1654084800, 0)
+
+	query, args := dbCli.UpdateDialback(models.Dialback{PeerID: pID, Timestamp: ts, Error: "connection_refused"})
+	require.Contains(t, query, "dialback_attempted=true")
+	require.Contains(t, query, "COALESCE(dialback_time, 0) <= $4")
+	require.Equal(t, []interface{}{pID.String(), false, "connection_refused", ts.Unix()}, args)
+}
+
+func TestDialbackInPSQL(t *testing.T) {
+	dbCli, err := NewDBClient(context.Background(), utils.EthereumNetwork, loginStr, 24*time.Hour, WithReset())
+	require.NoError(t, err)
+	defer dbCli.Close()
+
+	// three inbound-only peers, two of them unreachable, and one that we dialed ourselves
+	for i, success := range []bool{true, false, false, false} {
+		_, pubKey, err := crypto.GenerateEd25519Key(rand.Reader)
+		require.NoError(t, err)
+		pID, err := peer.IDFromPublicKey(pubKey)
+		require.NoError(t, err)
+		hInfo := models.NewHostInfo(pID, utils.EthereumNetwork, models.WithIPAndPorts(fmt.Sprintf("95.217.33.%d", 10+i), 9000))
+		hInfo.AddAtt(models.DialbackAttribute, models.Dialback{PeerID: pID, Timestamp: time.Now(), Success: success})
+		require.NoError(t, dbCli.PersistHostInfo(hInfo))
+		if i == 3 {
+			require.Eventually(t, func() bool {
+				tag, err := dbCli.psqlPool.Exec(dbCli.ctx, `UPDATE peer_info SET successful_attempts = 1 WHERE peer_id = $1;`, pID.String())
+				return err == nil && tag.RowsAffected() == 1
+			}, 10*time.Second, 100*time.Millisecond)
+		}
+	}
+
+	require.Eventually(t, func() bool {
+		rates, err := dbCli.GetDialbackUnreachableRatePerClient()
+		if err != nil || len(rates) != 1 {
+			return false
+		}
+		rate, ok := rates[utils.Unknown].(float64)
+		return ok && rate > 0.66 && rate < 0.67
+	}, 10*time.Second, 100*time.Millisecond)
+}
@@ -0,0 +1,67 @@
+package postgresql
+
+import (
+	"github.com/libp2p/go-libp2p-core/peer"
+	eth "github.com/migalabs/armiarma/pkg/networks/ethereum"
+	"github.com/pkg/errors"
+)
+
+// registerEthAttrPersisters registers the persisters of the Ethereum attributes of the HostInfos:
+// the beacon status and metadata into eth_status (and the metadata history), and the ENR into
+// eth_nodes, along with the attnets and address mismatches they reveal. They live here, as the
+// ethereum package can't import the DB client.
+func (c *DBClient) registerEthAttrPersisters() error {
+	persisters := []struct {
+		sample interface{}
+		fn     AttrPersister
+	}{
+		{eth.BeaconStatusStamped{}, c.persistBeaconStatus},
+		{eth.BeaconMetadataStamped{}, c.persistBeaconMetadata},
+		{&eth.EnrNode{}, c.persistEnrNode},
+	}
+	for _, persister := range persisters {
+		if err := c.RegisterAttrPersister(persister.sample, persister.fn); err != nil {
+			return errors.Wrap(err, "unable to register the ethereum attribute persisters")
+		}
+	}
+	return nil
+}
+
+func (c *DBClient) persistBeaconStatus(pID peer.ID, attr interface{}) ([]AttrQuery, error) {
+	bstatus := attr.(eth.BeaconStatusStamped)
+	q, args := c.UpsertEthereumNodeStatus(bstatus)
+	return []AttrQuery{{Query: q, Args: args}}, nil
+}
+
+func (c *DBClient) persistBeaconMetadata(pID peer.ID, attr interface{}) ([]AttrQuery, error) {
+	bmetadata := attr.(eth.BeaconMetadataStamped)
+	queries := make([]AttrQuery, 0, 3)
+	q, args := c.UpsertEthereumNodeMetadata(bmetadata)
+	queries = append(queries, AttrQuery{Query: q, Args: args})
+	q, args = c.InsertMetadataHistory(bmetadata)
+	queries = append(queries, AttrQuery{Query: q, Args: args})
+	if mismatch, ok := c.attnetsChecker.AddMetadata(bmetadata); ok {
+		q, args = c.UpdateAttnetsMismatch(mismatch)
+		queries = append(queries, AttrQuery{Query: q, Args: args})
+	}
+	return queries, nil
+}
+
+func (c *DBClient) persistEnrNode(pID peer.ID, attr interface{}) ([]AttrQuery, error) {
+	enrNode := attr.(*eth.EnrNode)
+	if enrNode == nil {
+		return nil, errors.New("nil ENR")
+	}
+	queries := make([]AttrQuery, 0, 3)
+	q, args := c.UpsertEnrInfo(enrNode)
+	queries = append(queries, AttrQuery{Query: q, Args: args})
+	if mismatch, ok := c.attnetsChecker.AddEnr(enrNode); ok {
+		q, args = c.UpdateAttnetsMismatch(mismatch)
+		queries = append(queries, AttrQuery{Query: q, Args: args})
+	}
+	if mismatch, ok := c.addrChecker.AddEnr(enrNode); ok {
+		q, args = c.UpdateAddrMismatch(mismatch)
+		queries = append(queries, AttrQuery{Query: q, Args: args})
+	}
+	return queries, nil
+}
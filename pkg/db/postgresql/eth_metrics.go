@@ -8,11 +8,13 @@ import (
 )
 
 func (db *DBClient) GetNodePerForkDistribution() (map[string]interface{}, error) {
+	ctx, cancel := db.readCtx()
+	defer cancel()
 	log.Debug("fetching node per fork distribution")
 	nodeDist := make(map[string]interface{})
 
 	rows, err := db.psqlPool.Query(
-		db.ctx,
+		ctx,
 		`
 		SELECT 
 			aux.fork_digest,
@@ -23,11 +25,13 @@ func (db *DBClient) GetNodePerForkDistribution() (map[string]interface{}, error)
 				to_timestamp(timestamp) as t_s,
 				fork_digest
 			FROM eth_nodes
-			WHERE fork_digest IS NOT NULL and to_timestamp(timestamp) > CURRENT_TIMESTAMP - INTERVAL '1 DAY'
+			WHERE fork_digest IS NOT NULL and to_timestamp(timestamp) > CURRENT_TIMESTAMP - INTERVAL '1 DAY' and
+				`+networkCond("network", 1)+`
 		) as aux
 		GROUP BY fork_digest
 		ORDER BY cnt DESC;
 		`,
+		db.readNetwork(),
 	)
 	// make sure we close the rows and we free the connection/session
 	defer rows.Close()
@@ -49,11 +53,13 @@ func (db *DBClient) GetNodePerForkDistribution() (map[string]interface{}, error)
 }
 
 func (db *DBClient) GetAttnetsDistribution() (map[string]interface{}, error) {
+	ctx, cancel := db.readCtx()
+	defer cancel()
 	log.Debug("fetching attnets distribution")
 	nodeDist := make(map[string]interface{})
 
 	rows, err := db.psqlPool.Query(
-		db.ctx,
+		ctx,
 		`
 		SELECT aux.attnets_number as attnets,
 			count(aux.attnets_number) as cnt
@@ -64,11 +70,13 @@ func (db *DBClient) GetAttnetsDistribution() (map[string]interface{}, error) {
 				fork_digest,
 				attnets_number
 			FROM eth_nodes
-			WHERE fork_digest IS NOT NULL and to_timestamp(timestamp) > CURRENT_TIMESTAMP - INTERVAL '1 DAY'
+			WHERE fork_digest IS NOT NULL and to_timestamp(timestamp) > CURRENT_TIMESTAMP - INTERVAL '1 DAY' and
+				`+networkCond("network", 1)+`
 		) as aux
 		GROUP BY attnets
 		ORDER BY cnt DESC;	
 		`,
+		db.readNetwork(),
 	)
 	// make sure we close the rows and we free the connection/session
 	defer rows.Close()
@@ -89,18 +97,62 @@ func (db *DBClient) GetAttnetsDistribution() (map[string]interface{}, error) {
 	return nodeDist, nil
 }
 
+// GetAttnetsMismatchPerClient returns the number of peers per client whose ENR attnets
+// don't match the ones in their beacon MetaData.
+func (db *DBClient) GetAttnetsMismatchPerClient() (map[string]interface{}, error) {
+	ctx, cancel := db.readCtx()
+	defer cancel()
+	log.Debug("fetching attnets mismatches per client")
+	clientDist := make(map[string]interface{})
+
+	rows, err := db.psqlPool.Query(
+		ctx,
+		`
+		SELECT
+			COALESCE(NULLIF(pi.client_name, ''), 'unknown') as client,
+			count(es.peer_id) as cnt
+		FROM eth_status as es
+		LEFT JOIN peer_info as pi
+			ON es.peer_id = pi.peer_id AND es.network = pi.network
+		WHERE es.attnets_mismatch = 'true' AND `+networkCond("es.network", 1)+`
+		GROUP BY client
+		ORDER BY cnt DESC;
+		`,
+		db.readNetwork(),
+	)
+	// make sure we close the rows and we free the connection/session
+	defer rows.Close()
+	if err != nil {
+		return clientDist, errors.Wrap(err, "unable to fetch attnets mismatches per client")
+	}
+
+	for rows.Next() {
+		var client string
+		var count int
+		err = rows.Scan(&client, &count)
+		if err != nil {
+			return clientDist, errors.Wrap(err, "unable to parse fetched attnets mismatches per client")
+		}
+		clientDist[client] = count
+	}
+
+	return clientDist, nil
+}
+
 func (db *DBClient) GetDeprecatedNodes() (int, error) {
+	ctx, cancel := db.readCtx()
+	defer cancel()
 	log.Debug("fetching deprecated node count")
 
 	var deprecatedCount int
 	err := db.psqlPool.QueryRow(
-		db.ctx,
+		ctx,
 		`
 		select
 			count(deprecated)
 		from peer_info
-		where deprecated='true';
-		`).Scan(
+		where deprecated='true' and `+networkCond("network", 1)+`;
+		`, db.readNetwork()).Scan(
 		&deprecatedCount,
 	)
 	if err != nil {
@@ -1,7 +1,12 @@
 package postgresql
 
 import (
+	"encoding/json"
+	"math"
+	"net"
+
 	"github.com/pkg/errors"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
 
 	eth "github.com/migalabs/armiarma/pkg/networks/ethereum"
 	log "github.com/sirupsen/logrus"
@@ -21,12 +26,12 @@ func (d *DBClient) InitEthNodesTable() error {
 	log.Debugf("init eth_nodes table in psql-db")
 
 	// try create the table in the DB
-	_, err := d.psqlPool.Exec(
-		d.ctx, `
+	err := d.execSchema(`
 		CREATE TABLE IF NOT EXISTS eth_nodes(
 			id SERIAL,
 			timestamp BIGINT NOT NULL,
 			peer_id TEXT,
+			network TEXT NOT NULL,
 			node_id TEXT NOT NULL,
 			seq BIGINT NOT NULL,
 			ip TEXT NOT NULL,
@@ -37,9 +42,12 @@ func (d *DBClient) InitEthNodesTable() error {
 			next_fork_version TEXT,
 			attnets TEXT, 
 			attnets_number INT,
+			enr TEXT,
+			enr_entries JSONB,
+			foreign_network BOOLEAN DEFAULT false,
 
-			PRIMARY KEY(node_id),	
-			UNIQUE(peer_id, pubkey)
+			PRIMARY KEY(node_id, network),
+			UNIQUE(peer_id, pubkey, network)
 		);
 		`,
 	)
@@ -47,11 +55,83 @@ func (d *DBClient) InitEthNodesTable() error {
 		return errors.Wrap(err, "unable to create table eth_nodes in the db")
 	}
 
-	return nil
+	// add the raw ENR columns to the tables created before they existed
+	err = d.execSchema(`
+		ALTER TABLE eth_nodes
+			ADD COLUMN IF NOT EXISTS enr TEXT,
+			ADD COLUMN IF NOT EXISTS enr_entries JSONB;
+		`)
+	if err != nil {
+		return errors.Wrap(err, "unable to add the enr columns to eth_nodes in the db")
+	}
+
+	// flag of the nodes that belong to a different network than the crawled one
+	err = d.execSchema(`
+		ALTER TABLE eth_nodes
+			ADD COLUMN IF NOT EXISTS foreign_network BOOLEAN DEFAULT false;
+		`)
+	if err != nil {
+		return errors.Wrap(err, "unable to add the foreign_network column to eth_nodes in the db")
+	}
+
+	// epoch of the next fork advertised in the ENR (NULL if none is scheduled)
+	err = d.execSchema(`
+		ALTER TABLE eth_nodes
+			ADD COLUMN IF NOT EXISTS next_fork_epoch BIGINT;
+		`)
+	if err != nil {
+		return errors.Wrap(err, "unable to add the next_fork_epoch column to eth_nodes in the db")
+	}
+
+	// addresses advertised in the ENR entries (NULL if missing), and whether they disagree
+	// with the address from which the peer talked with us
+	err = d.execSchema(`
+		ALTER TABLE eth_nodes
+			ADD COLUMN IF NOT EXISTS enr_ip TEXT,
+			ADD COLUMN IF NOT EXISTS enr_ip6 TEXT,
+			ADD COLUMN IF NOT EXISTS enr_tcp INT,
+			ADD COLUMN IF NOT EXISTS enr_udp INT,
+			ADD COLUMN IF NOT EXISTS enr_quic INT,
+			ADD COLUMN IF NOT EXISTS addr_mismatch BOOL,
+			ADD COLUMN IF NOT EXISTS addr_mismatch_values TEXT[];
+		`)
+	if err != nil {
+		return errors.Wrap(err, "unable to add the enr address columns to eth_nodes in the db")
+	}
+
+	// attnets and syncnets as masks whose bit i is the subnet i, and the syncnets with their
+	// number of subnets (NULL if the ENR doesn't advertise them)
+	err = d.execSchema(`
+		ALTER TABLE eth_nodes
+			ADD COLUMN IF NOT EXISTS attnets_mask BIGINT,
+			ADD COLUMN IF NOT EXISTS syncnets TEXT,
+			ADD COLUMN IF NOT EXISTS syncnets_mask INT,
+			ADD COLUMN IF NOT EXISTS syncnets_number INT;
+		`)
+	if err != nil {
+		return errors.Wrap(err, "unable to add the subnet mask columns to eth_nodes in the db")
+	}
+
+	// the nodes persisted before the network column existed belong to the network of the client,
+	// and the same node can be stored once per network
+	err = d.execSchema(`
+		ALTER TABLE eth_nodes
+			ADD COLUMN IF NOT EXISTS ` + d.networkColumn() + `;
+		`)
+	if err != nil {
+		return errors.Wrap(err, "unable to add the network column to eth_nodes in the db")
+	}
+	err = d.migrateNetworkKey("eth_nodes", "eth_nodes_pkey", "PRIMARY KEY (node_id, network)")
+	if err != nil {
+		return err
+	}
+	return d.migrateNetworkKey("eth_nodes", "eth_nodes_peer_id_pubkey_key", "UNIQUE (peer_id, pubkey, network)")
 }
 
 // Insert ENR in the DB
-// insert into the db if new one, update the data if the ENR has a higher Seq number
+// insert into the db if new one, update the data only if the ENR has a higher Seq number
+// (stale copies of the ENR handed by the discovery never overwrite a newer one).
+// The eth2, attnets and syncnets columns are NULL for the ENRs that don't advertise them (plain discv5 nodes)
 func (d *DBClient) UpsertEnrInfo(enr *eth.EnrNode) (query string, args []interface{}) {
 	log.Trace("upserting new enr to eth_nodes in psql-db")
 
@@ -68,9 +148,25 @@ func (d *DBClient) UpsertEnrInfo(enr *eth.EnrNode) (query string, args []interfa
 			fork_digest,
 			next_fork_version,
 			attnets,
-			attnets_number)
-		VALUES($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12)	
-		ON CONFLICT (node_id)
+			attnets_number,
+			enr,
+			enr_entries,
+			foreign_network,
+			next_fork_epoch,
+			enr_ip,
+			enr_ip6,
+			enr_tcp,
+			enr_udp,
+			enr_quic,
+			attnets_mask,
+			syncnets,
+			syncnets_mask,
+			syncnets_number,
+			network)
+		VALUES($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15,$16,
+			NULLIF($17, ''),NULLIF($18, ''),NULLIF($19, 0),NULLIF($20, 0),NULLIF($21, 0),
+			$22,$23,$24,$25,$26)
+		ON CONFLICT (node_id, network)
 		DO UPDATE SET
 			timestamp = excluded.timestamp,
 			seq = excluded.seq,
@@ -80,7 +176,21 @@ func (d *DBClient) UpsertEnrInfo(enr *eth.EnrNode) (query string, args []interfa
 			fork_digest = excluded.fork_digest,
 			next_fork_version = excluded.next_fork_version,
 			attnets = excluded.attnets,
-			attnets_number = excluded.attnets_number;
+			attnets_number = excluded.attnets_number,
+			enr = excluded.enr,
+			enr_entries = excluded.enr_entries,
+			foreign_network = excluded.foreign_network,
+			next_fork_epoch = excluded.next_fork_epoch,
+			enr_ip = excluded.enr_ip,
+			enr_ip6 = excluded.enr_ip6,
+			enr_tcp = excluded.enr_tcp,
+			enr_udp = excluded.enr_udp,
+			enr_quic = excluded.enr_quic,
+			attnets_mask = excluded.attnets_mask,
+			syncnets = excluded.syncnets,
+			syncnets_mask = excluded.syncnets_mask,
+			syncnets_number = excluded.syncnets_number
+		WHERE excluded.seq > eth_nodes.seq OR eth_nodes.seq IS NULL;
 		`
 
 	// if peer_id goes empty, not my fault here we should have checked it before
@@ -98,10 +208,161 @@ func (d *DBClient) UpsertEnrInfo(enr *eth.EnrNode) (query string, args []interfa
 	args = append(args, enr.TCP)
 	args = append(args, enr.UDP)
 	args = append(args, enr.GetPubkeyString())
-	args = append(args, enr.Eth2Data.ForkDigest.String())
-	args = append(args, enr.Eth2Data.NextForkVersion.String())
-	args = append(args, enr.GetAttnetsString())
-	args = append(args, enr.Attnets.NetNumber)
+	forkDigest, nextForkVersion, nextEpoch := eth2Args(enr)
+	args = append(args, forkDigest)
+	args = append(args, nextForkVersion)
+	attnets, attnetsMask, attnetsNumber := attnetsArgs(enr)
+	args = append(args, attnets)
+	args = append(args, attnetsNumber)
+	args = append(args, enr.Raw)
+	args = append(args, enrEntriesJson(enr))
+	args = append(args, enr.ForeignNetwork)
+	args = append(args, nextEpoch)
+	enrAddrs := enr.Addrs()
+	args = append(args, ipEntryString(enrAddrs.IP))
+	args = append(args, ipEntryString(enrAddrs.IP6))
+	args = append(args, enrAddrs.TCP)
+	args = append(args, enrAddrs.UDP)
+	args = append(args, enrAddrs.QUIC)
+	args = append(args, attnetsMask)
+	syncnets, syncnetsMask, syncnetsNumber := syncnetsArgs(enr)
+	args = append(args, syncnets)
+	args = append(args, syncnetsMask)
+	args = append(args, syncnetsNumber)
+	args = append(args, string(d.Network))
 
 	return query, args
 }
+
+// eth2Args returns the fork digest, the next fork version and the next fork epoch to persist,
+// all of them nil if the ENR has no eth2 entry.
+func eth2Args(enr *eth.EnrNode) (forkDigest, nextForkVersion, nextEpoch interface{}) {
+	if !enr.HasEth2Data {
+		return nil, nil, nil
+	}
+	return enr.Eth2Data.ForkDigest.String(), enr.Eth2Data.NextForkVersion.String(), nextForkEpoch(enr.Eth2Data.NextForkEpoch)
+}
+
+// attnetsArgs returns the hex, the mask and the number of subnets of the attnets to persist, all of them nil
+// if the ENR has no attnets. The mask is stored as a signed BIGINT, so the subnet 63 makes it negative.
+func attnetsArgs(enr *eth.EnrNode) (attnets, mask, number interface{}) {
+	attnetsMask, ok := enr.AttnetsMask()
+	if !ok {
+		return nil, nil, nil
+	}
+	return enr.GetAttnetsString(), int64(attnetsMask), enr.Attnets.NetNumber
+}
+
+// syncnetsArgs returns the hex, the mask and the number of subnets of the syncnets to persist,
+// all of them nil if the ENR has no syncnets.
+func syncnetsArgs(enr *eth.EnrNode) (syncnets, mask, number interface{}) {
+	syncnetsMask, ok := enr.SyncnetsMask()
+	if !ok {
+		return nil, nil, nil
+	}
+	return enr.GetSyncnetsString(), int64(syncnetsMask), enr.Syncnets.NetNumber
+}
+
+// ipEntryString returns the text of the ENR ip entry, empty if the entry is missing.
+func ipEntryString(ip net.IP) string {
+	if ip == nil {
+		return ""
+	}
+	return ip.String()
+}
+
+// UpdateAddrMismatch flags whether the ENR addresses of the peer disagree with the address
+// from which it talked with us, keeping the differing values.
+func (d *DBClient) UpdateAddrMismatch(mismatch *eth.AddrMismatch) (query string, args []interface{}) {
+	log.Trace("updating addr mismatch in eth_nodes in psql-db")
+	query = `
+		UPDATE eth_nodes
+		SET
+			addr_mismatch = $2,
+			addr_mismatch_values = $3
+		WHERE peer_id = $1 AND network = $4;
+	`
+
+	args = append(args, mismatch.PeerID.String())
+	args = append(args, mismatch.Mismatch)
+	args = append(args, mismatch.Values)
+	args = append(args, string(d.Network))
+
+	return query, args
+}
+
+// GetAddrMismatchRatePerClient returns the ratio of the peers of each client whose ENR addresses
+// disagree with the address from which they talked with us.
+// Only the peers whose addresses could be compared are considered.
+func (d *DBClient) GetAddrMismatchRatePerClient() (map[string]interface{}, error) {
+	ctx, cancel := d.readCtx()
+	defer cancel()
+	log.Debug("fetching addr mismatch rate per client")
+	clientMismatch := make(map[string]interface{})
+
+	rows, err := d.psqlPool.Query(ctx, `
+		SELECT
+			COALESCE(NULLIF(pi.client_name, ''), 'unknown') AS client,
+			avg(CASE WHEN en.addr_mismatch THEN 1.0 ELSE 0.0 END) AS mismatch_rate
+		FROM eth_nodes AS en
+		LEFT JOIN peer_info AS pi
+			ON pi.peer_id = en.peer_id AND pi.network = en.network
+		WHERE en.addr_mismatch IS NOT NULL AND `+networkCond("en.network", 1)+`
+		GROUP BY client
+		ORDER BY client;
+		`, d.readNetwork())
+	if err != nil {
+		return clientMismatch, errors.Wrap(err, "unable to fetch addr mismatch rate per client")
+	}
+	// make sure we close the rows and we free the connection/session
+	defer rows.Close()
+
+	for rows.Next() {
+		var client string
+		var rate float64
+		err = rows.Scan(&client, &rate)
+		if err != nil {
+			return clientMismatch, errors.Wrap(err, "unable to parse fetched addr mismatch rate per client")
+		}
+		clientMismatch[client] = rate
+	}
+	return clientMismatch, rows.Err()
+}
+
+// enrEntriesJson returns the JSON object of the ENR key-values (empty object if it can't be encoded)
+func enrEntriesJson(enr *eth.EnrNode) string {
+	if enr.Entries == nil {
+		return "{}"
+	}
+	entries, err := json.Marshal(enr.Entries)
+	if err != nil {
+		log.Error(errors.Wrap(err, "unable to encode the enr entries of node "+enr.ID.String()))
+		return "{}"
+	}
+	return string(entries)
+}
+
+// nextForkEpoch returns the epoch of the next fork to persist, nil for the FAR_FUTURE_EPOCH
+// (no fork scheduled), that doesn't fit in a BIGINT.
+func nextForkEpoch(epoch common.Epoch) interface{} {
+	if uint64(epoch) > math.MaxInt64 {
+		return nil
+	}
+	return int64(epoch)
+}
+
+// CountNodesWithEnrKey returns the number of nodes whose ENR advertises the given key (i.e. "quic").
+func (d *DBClient) CountNodesWithEnrKey(key string) (int, error) {
+	ctx, cancel := d.readCtx()
+	defer cancel()
+	var count int
+	err := d.psqlPool.QueryRow(ctx, `
+		SELECT COUNT(*)
+		FROM eth_nodes
+		WHERE enr_entries ? $1 AND `+networkCond("network", 2)+`;
+	`, key, d.readNetwork()).Scan(&count)
+	if err != nil {
+		return 0, errors.Wrap(err, "unable to count nodes with enr key "+key)
+	}
+	return count, nil
+}
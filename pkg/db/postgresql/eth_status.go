@@ -3,6 +3,7 @@ package postgresql
 import (
 	log "github.com/sirupsen/logrus"
 
+	"github.com/migalabs/armiarma/pkg/db/models"
 	eth "github.com/migalabs/armiarma/pkg/networks/ethereum"
 )
 
@@ -17,11 +18,11 @@ func (d *DBClient) DropEthereumNodeStatus() error {
 
 func (d *DBClient) InitEthereumNodeStatus() error {
 	log.Debug("init eth_status table in psql-db")
-	_, err := d.psqlPool.Exec(
-		d.ctx, `
+	err := d.execSchema(`
 		CREATE TABLE IF NOT EXISTS eth_status(
 			id SERIAL,
 			peer_id TEXT,
+			network TEXT NOT NULL,
 			timestamp BIGINT,
 			fork_digest TEXT,
 			finalized_root TEXT,
@@ -31,11 +32,50 @@ func (d *DBClient) InitEthereumNodeStatus() error {
 			seq_number BIGINT,
 			attnets TEXT,
 			syncnets TEXT,
+			attnets_mismatch BOOL,
+			attnets_mismatch_subnets INT[],
+			status_requests INT DEFAULT 0,
+			status_successes INT DEFAULT 0,
+			status_request_errors JSONB DEFAULT '{}',
 
-			PRIMARY KEY (peer_id)
+			PRIMARY KEY (peer_id, network)
 		);
 	`)
-	return err
+	if err != nil {
+		return err
+	}
+
+	// add the attnets mismatch columns to the tables created before they existed
+	err = d.execSchema(`
+		ALTER TABLE eth_status
+			ADD COLUMN IF NOT EXISTS attnets_mismatch BOOL,
+			ADD COLUMN IF NOT EXISTS attnets_mismatch_subnets INT[];
+	`)
+	if err != nil {
+		return err
+	}
+
+	// add the status request counters to the tables created before they existed
+	err = d.execSchema(`
+		ALTER TABLE eth_status
+			ADD COLUMN IF NOT EXISTS status_requests INT DEFAULT 0,
+			ADD COLUMN IF NOT EXISTS status_successes INT DEFAULT 0,
+			ADD COLUMN IF NOT EXISTS status_request_errors JSONB DEFAULT '{}';
+	`)
+	if err != nil {
+		return err
+	}
+
+	// the status persisted before the network column existed belong to the network of the client,
+	// and the same peer can be stored once per network
+	err = d.execSchema(`
+		ALTER TABLE eth_status
+			ADD COLUMN IF NOT EXISTS ` + d.networkColumn() + `;
+	`)
+	if err != nil {
+		return err
+	}
+	return d.migrateNetworkKey("eth_status", "eth_status_pkey", "PRIMARY KEY (peer_id, network)")
 }
 
 func (d *DBClient) UpsertEthereumNodeStatus(bstatus eth.BeaconStatusStamped) (query string, args []interface{}) {
@@ -48,9 +88,10 @@ func (d *DBClient) UpsertEthereumNodeStatus(bstatus eth.BeaconStatusStamped) (qu
 			finalized_root,
 			finalized_epoch,
 			head_root,
-			head_slot)
-		VALUES ($1,$2,$3,$4,$5,$6,$7)
-		ON CONFLICT (peer_id)
+			head_slot,
+			network)
+		VALUES ($1,$2,$3,$4,$5,$6,$7,$8)
+		ON CONFLICT (peer_id, network)
 		DO UPDATE SET
 			peer_id = excluded.peer_id,
 			timestamp = excluded.timestamp,
@@ -68,6 +109,7 @@ func (d *DBClient) UpsertEthereumNodeStatus(bstatus eth.BeaconStatusStamped) (qu
 	args = append(args, bstatus.Status.FinalizedEpoch)
 	args = append(args, bstatus.Status.HeadRoot.String())
 	args = append(args, bstatus.Status.HeadSlot)
+	args = append(args, string(d.Network))
 
 	return query, args
 }
@@ -80,9 +122,10 @@ func (d *DBClient) UpsertEthereumNodeMetadata(bmetadata eth.BeaconMetadataStampe
 			timestamp,
 			seq_number,
 			attnets,
-			syncnets)
-		VALUES ($1,$2,$3,$4,$5)
-		ON CONFLICT (peer_id)
+			syncnets,
+			network)
+		VALUES ($1,$2,$3,$4,$5,$6)
+		ON CONFLICT (peer_id, network)
 		DO UPDATE SET
 			peer_id = excluded.peer_id,
 			timestamp = excluded.timestamp,
@@ -96,6 +139,62 @@ func (d *DBClient) UpsertEthereumNodeMetadata(bmetadata eth.BeaconMetadataStampe
 	args = append(args, bmetadata.Metadata.SeqNumber)
 	args = append(args, bmetadata.Metadata.Attnets.String())
 	args = append(args, bmetadata.Metadata.Syncnets.String())
+	args = append(args, string(d.Network))
+
+	return query, args
+}
+
+// UpdateAttnetsMismatch flags whether the ENR and the MetaData attnets of the peer disagree,
+// keeping the subnets that differ between both.
+func (d *DBClient) UpdateAttnetsMismatch(mismatch *eth.AttnetsMismatch) (query string, args []interface{}) {
+	log.Trace("updating attnets mismatch in eth_status in psql-db")
+	query = `
+		UPDATE eth_status
+		SET
+			attnets_mismatch = $2,
+			attnets_mismatch_subnets = $3
+		WHERE peer_id = $1 AND network = $4;
+	`
+
+	args = append(args, mismatch.PeerID.String())
+	args = append(args, mismatch.Mismatch)
+	args = append(args, mismatch.Subnets)
+	args = append(args, string(d.Network))
+
+	return query, args
+}
+
+// UpdateStatusRequest counts the outcome of a Status request sent to the peer,
+// accumulating the failures per error in the status_request_errors object.
+func (d *DBClient) UpdateStatusRequest(outcome models.ReqRespOutcome) (query string, args []interface{}) {
+	log.Trace("updating status request counters in eth_status in psql-db")
+	query = `
+		INSERT INTO eth_status(
+			peer_id,
+			status_requests,
+			status_successes,
+			status_request_errors,
+			network)
+		VALUES ($1, 1, $2, CASE WHEN $3::text = '' THEN '{}'::jsonb ELSE jsonb_build_object($3::text, 1) END, $4)
+		ON CONFLICT (peer_id, network)
+		DO UPDATE SET
+			status_requests = COALESCE(eth_status.status_requests, 0) + 1,
+			status_successes = COALESCE(eth_status.status_successes, 0) + excluded.status_successes,
+			status_request_errors = CASE
+				WHEN $3::text = '' THEN COALESCE(eth_status.status_request_errors, '{}'::jsonb)
+				ELSE COALESCE(eth_status.status_request_errors, '{}'::jsonb) || jsonb_build_object(
+					$3::text, COALESCE((eth_status.status_request_errors->>$3::text)::int, 0) + 1)
+			END;
+	`
+
+	successes := 0
+	if outcome.Succeeded() {
+		successes = 1
+	}
+	args = append(args, outcome.PeerID.String())
+	args = append(args, successes)
+	args = append(args, outcome.ErrorKey())
+	args = append(args, string(d.Network))
 
 	return query, args
 }
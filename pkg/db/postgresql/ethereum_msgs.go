@@ -4,6 +4,7 @@ import (
 	"time"
 
 	eth "github.com/migalabs/armiarma/pkg/networks/ethereum"
+	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -19,8 +20,7 @@ func (c *DBClient) dropEtherumAttestationsTable() error {
 
 func (c *DBClient) initEthereumAttestationsTable() error {
 	log.Info("init eth_attestations table in psql-db")
-	_, err := c.psqlPool.Exec(
-		c.ctx,
+	err := c.execSchema(
 		`
 		CREATE TABLE IF NOT EXISTS eth_attestations(
 			id SERIAL,
@@ -79,8 +79,7 @@ func (c *DBClient) dropEtherumBeaconBlocksTable() error {
 
 func (c *DBClient) initEthereumBeaconBlocksTable() error {
 	log.Info("init eth_blocks table in psql-db")
-	_, err := c.psqlPool.Exec(
-		c.ctx,
+	err := c.execSchema(
 		`
 		CREATE TABLE IF NOT EXISTS eth_blocks(
 			id SERIAL,
@@ -122,3 +121,64 @@ func (c *DBClient) InsertNewEthereumBeaconBlock(bblock *eth.TrackedBeaconBlock)
 
 	return query, args
 }
+
+// Light client updates
+func (c *DBClient) initEthereumLightClientUpdatesTable() error {
+	log.Info("init eth_light_client_updates table in psql-db")
+	err := c.execSchema(
+		`
+		CREATE TABLE IF NOT EXISTS eth_light_client_updates(
+			id SERIAL,
+			msg_id TEXT NOT NULL,
+			sender TEXT NOT NULL,
+			update_type TEXT NOT NULL,
+			slot BIGINT NOT NULL,
+			arrival_time TIME NOT NULL,
+			time_in_slot REAL NOT NULL,
+
+			PRIMARY KEY(msg_id)
+		)
+		`)
+
+	return err
+}
+
+func (c *DBClient) InsertNewEthereumLightClientUpdate(update *eth.TrackedLightClientUpdate) (query string, args []interface{}) {
+
+	query = `
+	INSERT INTO eth_light_client_updates(
+		msg_id,
+		sender,
+		update_type,
+		slot,
+		arrival_time,
+		time_in_slot)
+	VALUES($1,$2,$3,$4,$5,$6)
+	ON CONFLICT (msg_id) DO NOTHING
+	`
+
+	// args
+	args = append(args, update.MsgID)
+	args = append(args, update.Sender.String())
+	args = append(args, update.UpdateType)
+	args = append(args, update.Slot)
+	args = append(args, update.ArrivalTime)
+	args = append(args, float64(update.TimeInSlot)/float64(time.Second))
+
+	return query, args
+}
+
+// GetLightClientUpdateSenders returns the number of distinct peers that delivered at least one light client update.
+func (c *DBClient) GetLightClientUpdateSenders() (int, error) {
+	ctx, cancel := c.readCtx()
+	defer cancel()
+	var senders int
+	err := c.psqlPool.QueryRow(ctx, `
+		SELECT COUNT(DISTINCT sender)
+		FROM eth_light_client_updates;
+	`).Scan(&senders)
+	if err != nil {
+		return 0, errors.Wrap(err, "unable to count the senders of light client updates")
+	}
+	return senders, nil
+}
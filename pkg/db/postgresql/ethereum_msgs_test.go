@@ -0,0 +1,43 @@
+package postgresql
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	eth "github.com/migalabs/armiarma/pkg/networks/ethereum"
+	"github.com/migalabs/armiarma/pkg/utils"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLightClientUpdateSendersInPSQL(t *testing.T) {
+	dbCli, err := NewDBClient(
+		context.Background(),
+		utils.EthereumNetwork,
+		loginStr,
+		24*time.Hour,
+		InitializeTables(true),
+	)
+	require.NoError(t, err)
+	defer dbCli.Close()
+
+	_, err = dbCli.SingleQuery(`TRUNCATE eth_light_client_updates;`)
+	require.NoError(t, err)
+
+	// two updates from the same sender, and one from another one
+	updates := []*eth.TrackedLightClientUpdate{
+		{MsgID: "lc-1", Sender: peer.ID("lc-sender1"), UpdateType: eth.LightClientFinalityUpdateTopicBase, Slot: 10, ArrivalTime: time.Now()},
+		{MsgID: "lc-2", Sender: peer.ID("lc-sender1"), UpdateType: eth.LightClientOptimisticUpdateTopicBase, Slot: 11, ArrivalTime: time.Now()},
+		{MsgID: "lc-3", Sender: peer.ID("lc-sender2"), UpdateType: eth.LightClientOptimisticUpdateTopicBase, Slot: 11, ArrivalTime: time.Now()},
+	}
+	for _, update := range updates {
+		q, args := dbCli.InsertNewEthereumLightClientUpdate(update)
+		_, err = dbCli.SingleQuery(q, args...)
+		require.NoError(t, err)
+	}
+
+	senders, err := dbCli.GetLightClientUpdateSenders()
+	require.NoError(t, err)
+	require.Equal(t, 2, senders)
+}
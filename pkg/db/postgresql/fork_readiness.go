@@ -0,0 +1,117 @@
+package postgresql
+
+import (
+	"time"
+
+	eth "github.com/migalabs/armiarma/pkg/networks/ethereum"
+	"github.com/pkg/errors"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	log "github.com/sirupsen/logrus"
+)
+
+// fork digest of the ENRs without eth2 entry
+const emptyForkDigest = "0x00000000"
+
+var ErrUnknownFork = errors.New("fork not scheduled")
+
+// ForkReadinessReport is the readiness of the active peers for a scheduled fork.
+type ForkReadinessReport struct {
+	Fork    eth.ScheduledFork
+	Total   eth.ForkReadinessCount
+	Clients map[string]eth.ForkReadinessCount // by client name
+}
+
+// GetForkReadiness classifies the active peers as ready, not ready or unknown for the scheduled fork
+// of the given version (see eth.ClassifyForkReadiness), grouped by client.
+// Returns ErrUnknownFork if the fork wasn't configured with WithScheduledForks.
+func (c *DBClient) GetForkReadiness(forkVersion string) (ForkReadinessReport, error) {
+	fork, ok := c.scheduledFork(forkVersion)
+	if !ok {
+		return ForkReadinessReport{}, errors.Wrap(ErrUnknownFork, forkVersion)
+	}
+	ctx, cancel := c.readCtx()
+	defer cancel()
+	log.Debugf("fetching readiness for fork %s", fork.Version)
+
+	// the latest ENR of each of the active peers
+	rows, err := c.psqlPool.Query(ctx, `
+		SELECT
+			pi.client_name,
+			COALESCE(pi.client_version, ''),
+			COALESCE(en.fork_digest, ''),
+			COALESCE(en.next_fork_version, ''),
+			COALESCE(en.next_fork_epoch, -1),
+			COALESCE(en.timestamp, 0)
+		FROM peer_info AS pi
+		LEFT JOIN (
+			SELECT DISTINCT ON (peer_id, network)
+				peer_id,
+				network,
+				fork_digest,
+				next_fork_version,
+				next_fork_epoch,
+				timestamp
+			FROM eth_nodes
+			ORDER BY peer_id, network, timestamp DESC
+		) AS en ON en.peer_id = pi.peer_id AND en.network = pi.network
+		WHERE pi.deprecated = 'false' and
+		      pi.attempted = 'true' and
+		      pi.client_name IS NOT NULL and
+		      to_timestamp(pi.last_activity) > CURRENT_TIMESTAMP - ($1 * INTERVAL '1 DAY') and
+		      `+networkCond("pi.network", 2)+`;
+		`,
+		LastActivityValidRange,
+		c.readNetwork(),
+	)
+	if err != nil {
+		return ForkReadinessReport{}, errors.Wrap(err, "unable to fetch fork readiness")
+	}
+	defer rows.Close()
+
+	report := ForkReadinessReport{
+		Fork:    fork,
+		Clients: make(map[string]eth.ForkReadinessCount),
+	}
+	for rows.Next() {
+		var forkDigest string
+		var epoch, timestamp int64
+		var info eth.PeerForkInfo
+		err = rows.Scan(&info.ClientName, &info.ClientVersion, &forkDigest, &info.NextForkVersion, &epoch, &timestamp)
+		if err != nil {
+			return ForkReadinessReport{}, errors.Wrap(err, "unable to parse fork readiness")
+		}
+		info.NextForkEpoch, info.EnrTime = enrForkSchedule(forkDigest, epoch, timestamp)
+		if info.EnrTime.IsZero() {
+			info.NextForkVersion = ""
+		}
+		readiness := eth.ClassifyForkReadiness(fork, info)
+		report.Total.Add(readiness)
+		clientCount := report.Clients[info.ClientName]
+		clientCount.Add(readiness)
+		report.Clients[info.ClientName] = clientCount
+	}
+	return report, rows.Err()
+}
+
+// enrForkSchedule returns the next fork epoch and the time of the persisted ENR,
+// with a zero time if there is no ENR or it has no eth2 entry.
+func enrForkSchedule(forkDigest string, epoch, timestamp int64) (common.Epoch, time.Time) {
+	if timestamp == 0 || forkDigest == "" || forkDigest == emptyForkDigest {
+		return 0, time.Time{}
+	}
+	// no fork scheduled
+	if epoch < 0 {
+		return common.FAR_FUTURE_EPOCH, time.Unix(timestamp, 0)
+	}
+	return common.Epoch(epoch), time.Unix(timestamp, 0)
+}
+
+// scheduledFork returns the scheduled fork of the given version.
+func (c *DBClient) scheduledFork(forkVersion string) (eth.ScheduledFork, bool) {
+	for _, fork := range c.scheduledForks {
+		if eth.SameForkVersion(fork.Version, forkVersion) {
+			return fork, true
+		}
+	}
+	return eth.ScheduledFork{}, false
+}
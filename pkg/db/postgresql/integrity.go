@@ -0,0 +1,172 @@
+package postgresql
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/migalabs/armiarma/pkg/utils"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// anomaly classes of the integrity check
+const (
+	EthStatusOrphans       = "eth_status_orphans"
+	MetadataHistoryOrphans = "metadata_history_orphans"
+	ConnEventsNullPeer     = "conn_events_null_peer"
+	ConnEventsOrphans      = "conn_events_orphans"
+	ConnAttemptsOrphans    = "conn_attempts_orphans"
+	PeerAttributesOrphans  = "peer_attributes_orphans"
+	IpsOrphans             = "ips_orphans"
+)
+
+// integrityCheck is a class of anomalous rows of a table, the ones matching the condition.
+// The condition refers to the table as t.
+type integrityCheck struct {
+	name      string
+	table     string
+	condition string
+}
+
+// notInPeerInfo is the condition of the rows of the peers missing from peer_info.
+const notInPeerInfo = `NOT EXISTS (SELECT 1 FROM peer_info p WHERE p.peer_id = t.peer_id)`
+
+// notInNetworkPeerInfo is the condition of the rows of the tables with network column
+// whose peers are missing from the peer_info of their network.
+const notInNetworkPeerInfo = `NOT EXISTS (SELECT 1 FROM peer_info p WHERE p.peer_id = t.peer_id AND p.network = t.network)`
+
+// integrityChecks returns the checks that apply to the tables of the network.
+// The rows without peer id count as orphans, besides the conn_events, whose ones are a class of their own.
+func integrityChecks(network utils.NetworkType) []integrityCheck {
+	checks := []integrityCheck{
+		{ConnEventsNullPeer, "conn_events", `t.peer_id IS NULL OR t.peer_id = ''`},
+		{ConnEventsOrphans, "conn_events", `t.peer_id <> '' AND ` + notInNetworkPeerInfo},
+		{ConnAttemptsOrphans, "conn_attempts", notInPeerInfo},
+		{PeerAttributesOrphans, "peer_attributes", notInPeerInfo},
+		{IpsOrphans, "ips", `NOT EXISTS (SELECT 1 FROM peer_info p WHERE p.ip = t.ip)`},
+	}
+	if network == utils.EthereumNetwork {
+		checks = append(checks,
+			integrityCheck{EthStatusOrphans, "eth_status", notInNetworkPeerInfo},
+			integrityCheck{MetadataHistoryOrphans, "metadata_history", notInPeerInfo},
+		)
+	}
+	return checks
+}
+
+// IntegrityReport is the number of anomalous rows found per class by CheckIntegrity,
+// and the ones deleted per class if it was asked to repair them.
+type IntegrityReport struct {
+	Anomalies map[string]int64 `json:"anomalies"`
+	Repaired  map[string]int64 `json:"repaired,omitempty"`
+}
+
+// Total returns the number of anomalous rows found.
+func (r IntegrityReport) Total() int64 {
+	var total int64
+	for _, count := range r.Anomalies {
+		total += count
+	}
+	return total
+}
+
+// String returns the anomalies (and repairs) of the report sorted by class, i.e.
+// "conn_events_orphans=3 (repaired 3), ips_orphans=1 (repaired 1)".
+func (r IntegrityReport) String() string {
+	names := make([]string, 0, len(r.Anomalies))
+	for name, count := range r.Anomalies {
+		if count > 0 {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return "no anomalies"
+	}
+	sort.Strings(names)
+	items := make([]string, 0, len(names))
+	for _, name := range names {
+		item := fmt.Sprintf("%s=%d", name, r.Anomalies[name])
+		if r.Repaired != nil {
+			item += fmt.Sprintf(" (repaired %d)", r.Repaired[name])
+		}
+		items = append(items, item)
+	}
+	return strings.Join(items, ", ")
+}
+
+// CheckIntegrity counts the rows of the crawl tables that break their consistency with peer_info
+// (i.e. left behind by crashes): the rows of the peers missing from it, the conn_events without peer,
+// and the ips that no peer has. With repair, the anomalous rows get deleted in chunks of
+// pruneChunkSize rows (one transaction each), as the conn_events retention does.
+func (c *DBClient) CheckIntegrity(repair bool) (IntegrityReport, error) {
+	report := IntegrityReport{
+		Anomalies: make(map[string]int64),
+	}
+	if repair {
+		if c.readOnly {
+			return report, errors.New("unable to repair the integrity of a read-only DB")
+		}
+		report.Repaired = make(map[string]int64)
+	}
+	for _, check := range integrityChecks(c.Network) {
+		var count int64
+		err := c.psqlPool.QueryRow(c.ctx,
+			`SELECT count(*) FROM `+check.table+` t WHERE (`+check.condition+`);`).Scan(&count)
+		if err != nil {
+			return report, errors.Wrap(err, "unable to check "+check.name)
+		}
+		report.Anomalies[check.name] = count
+		if !repair || count == 0 {
+			continue
+		}
+		deleted, err := c.deleteAnomalies(check)
+		report.Repaired[check.name] = deleted
+		if err != nil {
+			return report, errors.Wrap(err, "unable to repair "+check.name)
+		}
+	}
+	return report, nil
+}
+
+// deleteAnomalies deletes the rows of the check in chunks, returning the number of deleted rows.
+func (c *DBClient) deleteAnomalies(check integrityCheck) (int64, error) {
+	var total int64
+	for {
+		tag, err := c.psqlPool.Exec(c.ctx, `
+			DELETE FROM `+check.table+`
+			WHERE ctid IN (
+				SELECT t.ctid FROM `+check.table+` t
+				WHERE (`+check.condition+`)
+				LIMIT $1
+			);
+			`, pruneChunkSize)
+		if err != nil {
+			return total, err
+		}
+		total += tag.RowsAffected()
+		if tag.RowsAffected() < pruneChunkSize {
+			return total, nil
+		}
+		select {
+		case <-time.After(pruneChunkSleep):
+		case <-c.ctx.Done():
+			return total, c.ctx.Err()
+		}
+	}
+}
+
+// logIntegrity runs the report-only integrity check, logging its outcome.
+func (c *DBClient) logIntegrity() {
+	report, err := c.CheckIntegrity(false)
+	if err != nil {
+		log.Warn(errors.Wrap(err, "unable to check the integrity of the DB"))
+		return
+	}
+	if report.Total() > 0 {
+		log.Warnf("integrity check of the DB found anomalies: %s", report.String())
+		return
+	}
+	log.Info("integrity check of the DB found no anomalies")
+}
@@ -0,0 +1,125 @@
+package postgresql
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/migalabs/armiarma/pkg/db/models"
+	"github.com/migalabs/armiarma/pkg/utils"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIntegrityReport(t *testing.T) {
+	names := func(network utils.NetworkType) []string {
+		checks := make([]string, 0)
+		for _, check := range integrityChecks(network) {
+			checks = append(checks, check.name)
+		}
+		return checks
+	}
+	require.Contains(t, names(utils.EthereumNetwork), EthStatusOrphans)
+	require.Contains(t, names(utils.EthereumNetwork), MetadataHistoryOrphans)
+	require.NotContains(t, names(utils.IpfsNetwork), EthStatusOrphans)
+	require.Contains(t, names(utils.IpfsNetwork), ConnEventsNullPeer)
+
+	report := IntegrityReport{Anomalies: map[string]int64{IpsOrphans: 1, ConnEventsOrphans: 3, ConnAttemptsOrphans: 0}}
+	require.Equal(t, int64(4), report.Total())
+	require.Equal(t, "conn_events_orphans=3, ips_orphans=1", report.String())
+	report.Repaired = map[string]int64{IpsOrphans: 1, ConnEventsOrphans: 3}
+	require.Equal(t, "conn_events_orphans=3 (repaired 3), ips_orphans=1 (repaired 1)", report.String())
+	require.Equal(t, "no anomalies", IntegrityReport{}.String())
+
+	dbCli := &DBClient{}
+	require.NoError(t, WithIntegrityCheck(true)(dbCli))
+	require.True(t, dbCli.integrityCheck)
+}
+
+func TestIntegrityInPSQL(t *testing.T) {
+	network := utils.EthereumNetwork
+	dbCli, err := NewDBClient(context.Background(), network, loginStr, 24*time.Hour, WithReset())
+	require.NoError(t, err)
+	defer dbCli.Close()
+
+	// a consistent peer, with its rows in the other tables
+	peerStr := "12D3KooWLRPJAA5o6m3ZQbJsu9EVEFvLx2ke4cSg8LxpwYXmsd3d"
+	host := genNewTestHostInfo(t, network, peerStr, "95.217.33.10", 9000)
+	q, args := dbCli.UpsertHostInfo(host)
+	_, err = dbCli.SingleQuery(q, args...)
+	require.NoError(t, err)
+	seed := func(peerID string) {
+		_, err := dbCli.psqlPool.Exec(dbCli.ctx, `
+			INSERT INTO conn_events(peer_id, network, direction, conn_time, error) VALUES ($1, $2, 'inbound', 1654084800, '');`,
+			peerID, string(network))
+		require.NoError(t, err)
+		_, err = dbCli.psqlPool.Exec(dbCli.ctx, `
+			INSERT INTO conn_attempts(attempt_id, peer_id, timestamp, status) VALUES ($1, $2, 1654084800, 'negative');`,
+			uuid.NewString(), peerID)
+		require.NoError(t, err)
+		_, err = dbCli.psqlPool.Exec(dbCli.ctx, `INSERT INTO eth_status(peer_id, network) VALUES ($1, $2);`, peerID, string(network))
+		require.NoError(t, err)
+		_, err = dbCli.psqlPool.Exec(dbCli.ctx, `
+			INSERT INTO metadata_history(peer_id, received_at, seq_number) VALUES ($1, 1654084800, 1);`, peerID)
+		require.NoError(t, err)
+		_, err = dbCli.psqlPool.Exec(dbCli.ctx, `
+			INSERT INTO peer_attributes(peer_id, attr_name, payload, updated_at) VALUES ($1, 'test', '{}', 1654084800);`, peerID)
+		require.NoError(t, err)
+	}
+	seed(peerStr)
+	for _, ip := range []string{"95.217.33.10", "10.10.10.10"} {
+		var ipInfo models.IpInfo
+		ipInfo.IP = ip
+		ipInfo.ExpirationTime = time.Now().UTC().Add(24 * time.Hour)
+		q, args = dbCli.UpsertIpInfo(ipInfo)
+		_, err = dbCli.SingleQuery(q, args...)
+		require.NoError(t, err)
+	}
+
+	// every anomaly class: the rows of a peer missing from peer_info, the conn_events without peer,
+	// and the ip that no peer has
+	seed("12D3KooWGhostPeer")
+	for i := 0; i < 2; i++ {
+		_, err = dbCli.psqlPool.Exec(dbCli.ctx, `
+			INSERT INTO conn_events(peer_id, network, direction, conn_time, error) VALUES ('', $1, 'outbound', 1654084800, '');`,
+			string(network))
+		require.NoError(t, err)
+	}
+
+	expected := map[string]int64{
+		ConnEventsNullPeer:     2,
+		ConnEventsOrphans:      1,
+		ConnAttemptsOrphans:    1,
+		PeerAttributesOrphans:  1,
+		IpsOrphans:             1,
+		EthStatusOrphans:       1,
+		MetadataHistoryOrphans: 1,
+	}
+	// the check alone doesn't touch them
+	for i := 0; i < 2; i++ {
+		report, err := dbCli.CheckIntegrity(false)
+		require.NoError(t, err)
+		require.Equal(t, expected, report.Anomalies)
+		require.Nil(t, report.Repaired)
+	}
+
+	report, err := dbCli.CheckIntegrity(true)
+	require.NoError(t, err)
+	require.Equal(t, expected, report.Anomalies)
+	require.Equal(t, expected, report.Repaired)
+
+	report, err = dbCli.CheckIntegrity(false)
+	require.NoError(t, err)
+	require.Zero(t, report.Total())
+
+	// the rows of the consistent peer are kept
+	for _, table := range []string{"conn_events", "conn_attempts", "eth_status", "metadata_history", "peer_attributes"} {
+		var count int
+		err = dbCli.psqlPool.QueryRow(dbCli.ctx, `SELECT count(*) FROM `+table+` WHERE peer_id = $1;`, peerStr).Scan(&count)
+		require.NoError(t, err)
+		require.Equal(t, 1, count, table)
+	}
+	exists, _, err := dbCli.CheckIpRecords("95.217.33.10")
+	require.NoError(t, err)
+	require.True(t, exists)
+}
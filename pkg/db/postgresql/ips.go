@@ -12,7 +12,7 @@ import (
 
 func (c *DBClient) InitIpTable() error {
 	log.Debug("init ips table in psql-db")
-	_, err := c.psqlPool.Exec(c.ctx, `
+	err := c.execSchema(`
 		CREATE TABLE IF NOT EXISTS ips(
 			id SERIAL,
 			ip TEXT NOT NULL,
@@ -41,10 +41,30 @@ func (c *DBClient) InitIpTable() error {
 	if err != nil {
 		return errors.Wrap(err, "error init ips table")
 	}
+
+	err = c.execSchema(`
+		ALTER TABLE ips
+			ADD COLUMN IF NOT EXISTS provider TEXT NOT NULL DEFAULT '';
+		`)
+	if err != nil {
+		return errors.Wrap(err, "adding provider to ips table")
+	}
+
+	// geolocation provider that located the ip, and when
+	err = c.execSchema(`
+		ALTER TABLE ips
+			ADD COLUMN IF NOT EXISTS source TEXT NOT NULL DEFAULT '',
+			ADD COLUMN IF NOT EXISTS resolved_at TIMESTAMP;
+		`)
+	if err != nil {
+		return errors.Wrap(err, "adding source and resolved_at to ips table")
+	}
 	return nil
 }
 
 // UpsertIP attemtps to insert IP in the DB - or Updates the data info if they where already there
+// A sparse lookup (i.e. without country code) keeps the whole record that was already stored, along
+// with its expiration, so that the IP gets located again.
 func (c *DBClient) UpsertIpInfo(ipInfo models.IpInfo) (query string, args []interface{}) {
 	log.Trace("upsert ip_info in psql-db")
 	// compose query
@@ -68,28 +88,34 @@ func (c *DBClient) UpsertIpInfo(ipInfo models.IpInfo) (query string, args []inte
 			asname,
 			mobile,
 			proxy,
-			hosting)
-		VALUES($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15,$16,$17,$18,$19)
+			hosting,
+			provider,
+			source,
+			resolved_at)
+		VALUES($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15,$16,$17,$18,$19,$20,$21,$22)
 		ON CONFLICT (ip)
 		DO UPDATE SET
-			expiration_time = excluded.expiration_time,
-			continent = excluded.continent,
-			continent_code = excluded.continent_code,
-			country = excluded.country,
-			country_code = excluded.country_code,
-			region = excluded.region,
-			region_name = excluded.region_name,
-			city = excluded.city,
-			zip = excluded.zip,
-			lat = excluded.lat,
-			lon = excluded.lon,
-			isp = excluded.isp,
-			org = excluded.org,
-			as_raw = excluded.as_raw,
-			asname = excluded.asname,
-			mobile = excluded.mobile,
-			proxy = excluded.proxy,
-			hosting = excluded.hosting;
+			expiration_time = CASE WHEN excluded.country_code = '' THEN ips.expiration_time ELSE excluded.expiration_time END,
+			continent = CASE WHEN excluded.country_code = '' THEN ips.continent ELSE excluded.continent END,
+			continent_code = CASE WHEN excluded.country_code = '' THEN ips.continent_code ELSE excluded.continent_code END,
+			country = CASE WHEN excluded.country_code = '' THEN ips.country ELSE excluded.country END,
+			country_code = CASE WHEN excluded.country_code = '' THEN ips.country_code ELSE excluded.country_code END,
+			region = CASE WHEN excluded.country_code = '' THEN ips.region ELSE excluded.region END,
+			region_name = CASE WHEN excluded.country_code = '' THEN ips.region_name ELSE excluded.region_name END,
+			city = CASE WHEN excluded.country_code = '' THEN ips.city ELSE excluded.city END,
+			zip = CASE WHEN excluded.country_code = '' THEN ips.zip ELSE excluded.zip END,
+			lat = CASE WHEN excluded.country_code = '' THEN ips.lat ELSE excluded.lat END,
+			lon = CASE WHEN excluded.country_code = '' THEN ips.lon ELSE excluded.lon END,
+			isp = CASE WHEN excluded.country_code = '' THEN ips.isp ELSE excluded.isp END,
+			org = CASE WHEN excluded.country_code = '' THEN ips.org ELSE excluded.org END,
+			as_raw = CASE WHEN excluded.country_code = '' THEN ips.as_raw ELSE excluded.as_raw END,
+			asname = CASE WHEN excluded.country_code = '' THEN ips.asname ELSE excluded.asname END,
+			mobile = CASE WHEN excluded.country_code = '' THEN ips.mobile ELSE excluded.mobile END,
+			proxy = CASE WHEN excluded.country_code = '' THEN ips.proxy ELSE excluded.proxy END,
+			hosting = CASE WHEN excluded.country_code = '' THEN ips.hosting ELSE excluded.hosting END,
+			provider = CASE WHEN excluded.country_code = '' THEN ips.provider ELSE excluded.provider END,
+			source = CASE WHEN excluded.country_code = '' THEN ips.source ELSE excluded.source END,
+			resolved_at = CASE WHEN excluded.country_code = '' THEN ips.resolved_at ELSE excluded.resolved_at END;
 		`
 
 	args = append(args, ipInfo.IP)
@@ -111,15 +137,26 @@ func (c *DBClient) UpsertIpInfo(ipInfo models.IpInfo) (query string, args []inte
 	args = append(args, ipInfo.Mobile)
 	args = append(args, ipInfo.Proxy)
 	args = append(args, ipInfo.Hosting)
+	args = append(args, ipInfo.Provider)
+	args = append(args, ipInfo.Source)
+	// NULL for the locations that don't know when they were resolved
+	var resolvedAt interface{}
+	if !ipInfo.ResolvedAt.IsZero() {
+		resolvedAt = ipInfo.ResolvedAt
+	}
+	args = append(args, resolvedAt)
 
 	return query, args
 }
 
 // ReadIpInfo reads all the information available for that specific IP in the DB
 func (c *DBClient) ReadIpInfo(ip string) (models.IpInfo, error) {
+	ctx, cancel := c.readCtx()
+	defer cancel()
 	log.Tracef("reading ip_info for ip %s from psql-db", ip)
 	var ipInfo models.IpInfo
-	err := c.psqlPool.QueryRow(c.ctx, `
+	var resolvedAt *time.Time
+	err := c.psqlPool.QueryRow(ctx, `
 		SELECT 
 			ip,
 			expiration_time,
@@ -139,7 +176,10 @@ func (c *DBClient) ReadIpInfo(ip string) (models.IpInfo, error) {
 			asname,
 			mobile,
 			proxy,
-			hosting
+			hosting,
+			provider,
+			source,
+			resolved_at
 		FROM ips
 		WHERE ip=$1
 	`, ip).Scan(
@@ -162,23 +202,31 @@ func (c *DBClient) ReadIpInfo(ip string) (models.IpInfo, error) {
 		&ipInfo.Mobile,
 		&ipInfo.Proxy,
 		&ipInfo.Hosting,
+		&ipInfo.Provider,
+		&ipInfo.Source,
+		&resolvedAt,
 	)
 	if err != nil {
 		return models.IpInfo{}, err
 	}
+	if resolvedAt != nil {
+		ipInfo.ResolvedAt = *resolvedAt
+	}
 
 	return ipInfo, nil
 
 }
 
-// GetExpiredIpInfo returns all the IP whos' TTL has already expired
+// GetExpiredIpInfo returns all the IP whos' TTL has already expired (or that were stored without a country code)
 func (c *DBClient) GetExpiredIpInfo() ([]string, error) {
+	ctx, cancel := c.readCtx()
+	defer cancel()
 	log.Trace("fetching expired ips from psql-db")
 	expIps := make([]string, 0)
-	ipRows, err := c.psqlPool.Query(c.ctx, `
+	ipRows, err := c.psqlPool.Query(ctx, `
 		SELECT ip 
 		FROM ips
-		WHERE expiration_time < NOW();
+		WHERE expiration_time < NOW() OR country_code = '';
 	`)
 	if err != nil {
 		return expIps, errors.Wrap(err, "unable to get expired ip records")
@@ -198,21 +246,26 @@ func (c *DBClient) GetExpiredIpInfo() ([]string, error) {
 	return expIps, nil
 }
 
-// CheckIpRecords checks if a given IP is already stored in the DB as whether its TTL has expired
+// CheckIpRecords checks if a given IP is already stored in the DB as whether its TTL has expired.
+// The records without a country code are reported as expired, so that they get backfilled on the next refresh.
 func (c *DBClient) CheckIpRecords(ip string) (exists bool, expired bool, err error) {
+	ctx, cancel := c.readCtx()
+	defer cancel()
 	log.Tracef("checking if ip %s exists in ips table", ip)
 	var readIp string
 	var expTime time.Time
+	var countryCode string
 
-	row := c.psqlPool.QueryRow(c.ctx, `
+	row := c.psqlPool.QueryRow(ctx, `
 		SELECT 
 			ip,
-			expiration_time
+			expiration_time,
+			country_code
 		FROM ips
 		WHERE ip=$1;
 	`, ip)
 
-	err = row.Scan(&readIp, &expTime)
+	err = row.Scan(&readIp, &expTime, &countryCode)
 	if err == pgx.ErrNoRows {
 		return false, false, nil
 	} else if err != nil {
@@ -222,7 +275,7 @@ func (c *DBClient) CheckIpRecords(ip string) (exists bool, expired bool, err err
 	if readIp == ip {
 		exists = true
 	}
-	if expTime.Before(time.Now()) {
+	if expTime.Before(time.Now()) || countryCode == "" {
 		expired = true
 	}
 	return
@@ -3,7 +3,9 @@ package postgresql
 import (
 	"context"
 	"testing"
+	"time"
 
+	"github.com/migalabs/armiarma/pkg/db/models"
 	"github.com/migalabs/armiarma/pkg/utils"
 	"github.com/migalabs/armiarma/pkg/utils/apis"
 	log "github.com/sirupsen/logrus"
@@ -271,6 +273,7 @@ func TestIpTable(t *testing.T) {
 	require.Equal(t, ipInfo.Mobile, readIpInfo.Mobile)
 	require.Equal(t, ipInfo.Proxy, readIpInfo.Proxy)
 	require.Equal(t, ipInfo.Hosting, readIpInfo.Hosting)
+	require.Equal(t, ipInfo.Provider, readIpInfo.Provider)
 
 	log.Info("expTime ", readIpInfo.ExpirationTime)
 	log.Info("force expire ", readIpInfo.ExpirationTime.AddDate(0, -1, -1))
@@ -293,6 +296,91 @@ func TestIpTable(t *testing.T) {
 
 }
 
+func TestIpWithoutCountryCodeInPSQL(t *testing.T) {
+	dbCli, err := NewDBClient(
+		context.Background(),
+		utils.EthereumNetwork,
+		loginStr,
+		24*time.Hour,
+		InitializeTables(true),
+	)
+	require.NoError(t, err)
+	defer dbCli.Close()
+
+	// stored before the country codes were kept
+	var ipInfo models.IpInfo
+	ipInfo.IP = "10.10.10.10"
+	ipInfo.Country = "Germany"
+	ipInfo.ExpirationTime = time.Now().UTC().Add(24 * time.Hour)
+	q, args := dbCli.UpsertIpInfo(ipInfo)
+	_, err = dbCli.SingleQuery(q, args...)
+	require.NoError(t, err)
+
+	// it has to be located again
+	exists, isExpired, err := dbCli.CheckIpRecords(ipInfo.IP)
+	require.NoError(t, err)
+	require.Equal(t, true, exists)
+	require.Equal(t, true, isExpired)
+	expired, err := dbCli.GetExpiredIpInfo()
+	require.NoError(t, err)
+	require.Contains(t, expired, ipInfo.IP)
+
+	// once backfilled, it isn't expired anymore
+	ipInfo.CountryCode = "DE"
+	q, args = dbCli.UpsertIpInfo(ipInfo)
+	_, err = dbCli.SingleQuery(q, args...)
+	require.NoError(t, err)
+	exists, isExpired, err = dbCli.CheckIpRecords(ipInfo.IP)
+	require.NoError(t, err)
+	require.Equal(t, true, exists)
+	require.Equal(t, false, isExpired)
+}
+
+func TestIpLocationSourceInPSQL(t *testing.T) {
+	dbCli, err := NewDBClient(
+		context.Background(),
+		utils.EthereumNetwork,
+		loginStr,
+		24*time.Hour,
+		InitializeTables(true),
+	)
+	require.NoError(t, err)
+	defer dbCli.Close()
+
+	// fully located
+	var full models.IpInfo
+	full.IP = "95.217.33.10"
+	full.Country = "Finland"
+	full.CountryCode = "FI"
+	full.As = "AS24940 Hetzner Online GmbH"
+	full.Source = models.LocationSourceIpApi
+	full.ResolvedAt = time.Now().UTC().Truncate(time.Second)
+	full.ExpirationTime = full.ResolvedAt.Add(24 * time.Hour)
+	q, args := dbCli.UpsertIpInfo(full)
+	_, err = dbCli.SingleQuery(q, args...)
+	require.NoError(t, err)
+
+	readIpInfo, err := dbCli.ReadIpInfo(full.IP)
+	require.NoError(t, err)
+	require.Equal(t, full.Location(), readIpInfo.Location())
+
+	// located before the source was tracked
+	var partial models.IpInfo
+	partial.IP = "86.85.31.80"
+	partial.CountryCode = "NL"
+	partial.ExpirationTime = time.Now().UTC().Add(24 * time.Hour)
+	q, args = dbCli.UpsertIpInfo(partial)
+	_, err = dbCli.SingleQuery(q, args...)
+	require.NoError(t, err)
+
+	readIpInfo, err = dbCli.ReadIpInfo(partial.IP)
+	require.NoError(t, err)
+	loc := readIpInfo.Location()
+	require.Equal(t, "NL", loc.CountryCode)
+	require.Empty(t, loc.Source)
+	require.True(t, loc.ResolvedAt.IsZero())
+}
+
 // test the requestCache individually
 func TestApiCall(t *testing.T) {
 
@@ -315,7 +403,8 @@ func TestIpLocator(t *testing.T) {
 	err = dbCli.InitIpTable()
 	require.NoError(t, err)
 
-	ipLocator := apis.NewIpLocator(context.Background(), dbCli)
+	ipLocator, err := apis.NewIpLocator(context.Background(), dbCli)
+	require.NoError(t, err)
 
 	ipLocator.Run()
 	defer ipLocator.Close()
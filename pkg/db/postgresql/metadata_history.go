@@ -0,0 +1,154 @@
+package postgresql
+
+import (
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	eth "github.com/migalabs/armiarma/pkg/networks/ethereum"
+)
+
+// MetadataHistoryEntry is a beacon MetaData of a peer, as it was received for a given seq_number.
+type MetadataHistoryEntry struct {
+	PeerID     peer.ID   `json:"peer_id"`
+	ReceivedAt time.Time `json:"received_at"`
+	SeqNumber  uint64    `json:"seq_number"`
+	Attnets    string    `json:"attnets"`
+	Syncnets   string    `json:"syncnets"`
+}
+
+// InitMetadataHistoryTable keeps every beacon MetaData version (seq_number) received from each peer,
+// while eth_status only keeps the latest one.
+func (d *DBClient) InitMetadataHistoryTable() error {
+	log.Debug("init metadata_history table in psql-db")
+	err := d.execSchema(`
+		CREATE TABLE IF NOT EXISTS metadata_history(
+			peer_id TEXT NOT NULL,
+			received_at BIGINT NOT NULL,
+			seq_number BIGINT NOT NULL,
+			attnets TEXT,
+			syncnets TEXT,
+
+			PRIMARY KEY (peer_id, seq_number)
+		);
+	`)
+	return err
+}
+
+// InsertMetadataHistory adds the beacon MetaData to the history of the peer, unless its seq_number was already there.
+func (d *DBClient) InsertMetadataHistory(bmetadata eth.BeaconMetadataStamped) (query string, args []interface{}) {
+	log.Trace("inserting beacon metadata to metadata_history in psql-db")
+	query = `
+		INSERT INTO metadata_history(
+			peer_id,
+			received_at,
+			seq_number,
+			attnets,
+			syncnets)
+		VALUES ($1,$2,$3,$4,$5)
+		ON CONFLICT (peer_id, seq_number)
+		DO NOTHING;
+		`
+
+	args = append(args, bmetadata.PeerID.String())
+	args = append(args, bmetadata.Timestamp.Unix())
+	args = append(args, bmetadata.Metadata.SeqNumber)
+	args = append(args, bmetadata.Metadata.Attnets.String())
+	args = append(args, bmetadata.Metadata.Syncnets.String())
+
+	return query, args
+}
+
+// GetMetadataHistory returns the beacon MetaData history of the peer, sorted by seq_number.
+func (d *DBClient) GetMetadataHistory(peerID peer.ID) ([]MetadataHistoryEntry, error) {
+	ctx, cancel := d.readCtx()
+	defer cancel()
+	log.Tracef("fetching metadata history of peer %s", peerID.String())
+	history := make([]MetadataHistoryEntry, 0)
+
+	rows, err := d.psqlPool.Query(ctx, `
+		SELECT received_at, seq_number, attnets, syncnets
+		FROM metadata_history
+		WHERE peer_id = $1
+		ORDER BY seq_number;
+		`, peerID.String())
+	if err != nil {
+		return history, errors.Wrap(err, "unable to fetch metadata history of peer "+peerID.String())
+	}
+	// make sure we close the rows and we free the connection/session
+	defer rows.Close()
+
+	for rows.Next() {
+		entry := MetadataHistoryEntry{PeerID: peerID}
+		var receivedAt int64
+		var attnets, syncnets *string
+		err = rows.Scan(&receivedAt, &entry.SeqNumber, &attnets, &syncnets)
+		if err != nil {
+			return history, errors.Wrap(err, "unable to parse fetched metadata history")
+		}
+		entry.ReceivedAt = time.Unix(receivedAt, 0)
+		if attnets != nil {
+			entry.Attnets = *attnets
+		}
+		if syncnets != nil {
+			entry.Syncnets = *syncnets
+		}
+		history = append(history, entry)
+	}
+	return history, rows.Err()
+}
+
+// GetAttnetsRotationPerClient returns the average number of attnets rotations per day of the peers of each client.
+// Only the peers with at least two MetaData versions received at different times are considered.
+func (d *DBClient) GetAttnetsRotationPerClient() (map[string]interface{}, error) {
+	ctx, cancel := d.readCtx()
+	defer cancel()
+	log.Debug("fetching attnets rotation per client")
+	clientRotation := make(map[string]interface{})
+
+	rows, err := d.psqlPool.Query(ctx, `
+		WITH changes AS (
+			SELECT
+				peer_id,
+				received_at,
+				attnets,
+				LAG(attnets) OVER (PARTITION BY peer_id ORDER BY seq_number) AS prev_attnets
+			FROM metadata_history
+		),
+		per_peer AS (
+			SELECT
+				peer_id,
+				count(*) FILTER (WHERE prev_attnets IS NOT NULL AND prev_attnets <> attnets) AS rotations,
+				max(received_at) - min(received_at) AS span_secs
+			FROM changes
+			GROUP BY peer_id
+			HAVING max(received_at) > min(received_at)
+		)
+		SELECT
+			COALESCE(NULLIF(pi.client_name, ''), 'unknown') AS client,
+			avg(pp.rotations::FLOAT * 86400 / pp.span_secs) AS rotations_per_day
+		FROM per_peer AS pp
+		LEFT JOIN peer_info AS pi
+			ON pi.peer_id = pp.peer_id AND `+networkCond("pi.network", 1)+`
+		GROUP BY client
+		ORDER BY client;
+		`, d.readNetwork())
+	if err != nil {
+		return clientRotation, errors.Wrap(err, "unable to fetch attnets rotation per client")
+	}
+	// make sure we close the rows and we free the connection/session
+	defer rows.Close()
+
+	for rows.Next() {
+		var client string
+		var rotations float64
+		err = rows.Scan(&client, &rotations)
+		if err != nil {
+			return clientRotation, errors.Wrap(err, "unable to parse fetched attnets rotation per client")
+		}
+		clientRotation[client] = rotations
+	}
+	return clientRotation, rows.Err()
+}
@@ -0,0 +1,305 @@
+package postgresql
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"hash/fnv"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// The schema of the crawl tables is versioned in the schema_version table. Version 1 is the baseline
+// created by initTables, which keeps running on every Migrate, as its statements only create what is
+// missing and depend on the network of the client (i.e. the eth_* tables). The later changes of the
+// existing tables go into the embedded migrations (migrations/NNNN_name.up.sql and .down.sql, only
+// made of plain statements), which get applied once and in order. The DBs created before the
+// versions existed get the baseline recorded, and the pending migrations applied, on their first Migrate.
+
+// BaselineVersion is the version of the schema created by initTables.
+const BaselineVersion = 1
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+var migrationFileRe = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// migration is a versioned change of the schema, with the statements that apply it and revert it.
+type migration struct {
+	version int
+	name    string
+	up      []string
+	down    []string
+}
+
+// loadMigrations returns the embedded migrations sorted by version, which have to follow the
+// baseline without gaps and come with both the up and the down statements.
+func loadMigrations() ([]migration, error) {
+	entries, err := migrationFiles.ReadDir("migrations")
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to read the embedded migrations")
+	}
+	byVersion := make(map[int]*migration)
+	for _, entry := range entries {
+		m := migrationFileRe.FindStringSubmatch(entry.Name())
+		if m == nil {
+			return nil, errors.New("invalid migration file name " + entry.Name())
+		}
+		version, _ := strconv.Atoi(m[1])
+		mig, ok := byVersion[version]
+		if !ok {
+			mig = &migration{version: version, name: m[2]}
+			byVersion[version] = mig
+		} else if mig.name != m[2] {
+			return nil, errors.Errorf("migration %d named both %s and %s", version, mig.name, m[2])
+		}
+		content, err := migrationFiles.ReadFile(path.Join("migrations", entry.Name()))
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to read migration "+entry.Name())
+		}
+		if m[3] == "up" {
+			mig.up = splitStatements(string(content))
+		} else {
+			mig.down = splitStatements(string(content))
+		}
+	}
+
+	migrations := make([]migration, 0, len(byVersion))
+	for _, mig := range byVersion {
+		migrations = append(migrations, *mig)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].version < migrations[j].version
+	})
+	for i, mig := range migrations {
+		if mig.version != BaselineVersion+1+i {
+			return nil, errors.Errorf("migration %d doesn't follow version %d", mig.version, BaselineVersion+i)
+		}
+		if len(mig.up) == 0 || len(mig.down) == 0 {
+			return nil, errors.Errorf("migration %d needs both up and down statements", mig.version)
+		}
+	}
+	return migrations, nil
+}
+
+// LatestVersion returns the version of the schema once all the embedded migrations are applied.
+func LatestVersion() (int, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return 0, err
+	}
+	return BaselineVersion + len(migrations), nil
+}
+
+// splitStatements splits the SQL by the semicolons that are not between quotes, without the comment lines.
+func splitStatements(sql string) []string {
+	lines := make([]string, 0)
+	for _, line := range strings.Split(sql, "\n") {
+		if !strings.HasPrefix(strings.TrimSpace(line), "--") {
+			lines = append(lines, line)
+		}
+	}
+	stmts := make([]string, 0)
+	quoted := false
+	start := 0
+	text := strings.Join(lines, "\n")
+	add := func(stmt string) {
+		if stmt = strings.TrimSpace(stmt); stmt != "" {
+			stmts = append(stmts, stmt+";")
+		}
+	}
+	for i, r := range text {
+		switch {
+		case r == '\'':
+			quoted = !quoted
+		case r == ';' && !quoted:
+			add(text[start:i])
+			start = i + 1
+		}
+	}
+	add(text[start:])
+	return stmts
+}
+
+// schemaQuerier is what the schema statements need from either the pool or the migration transaction.
+type schemaQuerier interface {
+	Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error)
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+}
+
+// schemaDB returns where the schema statements run: the migration transaction while migrating.
+func (c *DBClient) schemaDB() schemaQuerier {
+	if c.schemaTx != nil {
+		return c.schemaTx
+	}
+	return c.psqlPool
+}
+
+// migrationLockKey returns the key of the advisory lock that serializes the migrations of the schema
+// of the client, so that the clients of other schemas don't wait for them.
+func (c *DBClient) migrationLockKey() int64 {
+	h := fnv.New64a()
+	h.Write([]byte("armiarma-migrations:" + c.Schema()))
+	return int64(h.Sum64())
+}
+
+// Migrate brings the schema of the client up to the latest version: it runs the baseline (initTables),
+// recording it if the DB didn't have any version yet (i.e. the ones created by initTables alone),
+// and applies the pending migrations. Everything runs within a single transaction that holds an
+// advisory lock, so that the clients starting at the same time apply each migration once, and an
+// interrupted migration leaves the schema as it was.
+func (c *DBClient) Migrate(ctx context.Context) error {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+	return c.migrationTx(ctx, func(tx pgx.Tx, current int) error {
+		if current == 0 {
+			var existing bool
+			err := tx.QueryRow(ctx, `SELECT to_regclass($1) IS NOT NULL;`, c.qualify("peer_info")).Scan(&existing)
+			if err != nil {
+				return errors.Wrap(err, "unable to check the existing tables")
+			}
+			if existing {
+				log.Infof("baselining the existing db schema %s at version %d", c.Schema(), BaselineVersion)
+			}
+		}
+		err := c.initTables()
+		if err != nil {
+			return err
+		}
+		if current < BaselineVersion {
+			err = recordVersion(ctx, tx, BaselineVersion, "baseline")
+			if err != nil {
+				return err
+			}
+			current = BaselineVersion
+		}
+		for _, mig := range migrations {
+			if mig.version <= current {
+				continue
+			}
+			log.Infof("applying migration %d (%s) to db schema %s", mig.version, mig.name, c.Schema())
+			err = execStatements(ctx, tx, mig.up)
+			if err != nil {
+				return errors.Wrapf(err, "applying migration %d (%s)", mig.version, mig.name)
+			}
+			err = recordVersion(ctx, tx, mig.version, mig.name)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// MigrateDown reverts the migrations applied over the given version (which can't be below the baseline),
+// from the latest one backwards, within a single transaction.
+func (c *DBClient) MigrateDown(ctx context.Context, version int) error {
+	if version < BaselineVersion {
+		return errors.Errorf("unable to migrate below the baseline version %d", BaselineVersion)
+	}
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+	return c.migrationTx(ctx, func(tx pgx.Tx, current int) error {
+		for i := len(migrations) - 1; i >= 0; i-- {
+			mig := migrations[i]
+			if mig.version <= version || mig.version > current {
+				continue
+			}
+			log.Infof("reverting migration %d (%s) of db schema %s", mig.version, mig.name, c.Schema())
+			err := execStatements(ctx, tx, mig.down)
+			if err != nil {
+				return errors.Wrapf(err, "reverting migration %d (%s)", mig.version, mig.name)
+			}
+			_, err = tx.Exec(ctx, `DELETE FROM schema_version WHERE version = $1;`, mig.version)
+			if err != nil {
+				return errors.Wrapf(err, "unable to unrecord version %d", mig.version)
+			}
+		}
+		return nil
+	})
+}
+
+// SchemaVersion returns the latest version applied to the schema of the client, 0 if it has none.
+func (c *DBClient) SchemaVersion(ctx context.Context) (int, error) {
+	var exists bool
+	err := c.psqlPool.QueryRow(ctx, `SELECT to_regclass($1) IS NOT NULL;`, c.qualify("schema_version")).Scan(&exists)
+	if err != nil || !exists {
+		return 0, errors.Wrap(err, "unable to check the schema_version table")
+	}
+	var version int
+	err = c.psqlPool.QueryRow(ctx, `SELECT COALESCE(max(version), 0) FROM schema_version;`).Scan(&version)
+	if err != nil {
+		return 0, errors.Wrap(err, "unable to read the schema version")
+	}
+	return version, nil
+}
+
+// migrationTx runs fn within a transaction that holds the migration lock of the schema, along with the
+// current version of the schema (0 if it has none). The schema statements of the client run within it.
+func (c *DBClient) migrationTx(ctx context.Context, fn func(tx pgx.Tx, current int) error) error {
+	tx, err := c.psqlPool.Begin(ctx)
+	if err != nil {
+		return errors.Wrap(err, "unable to begin the migration of the db schema")
+	}
+	defer tx.Rollback(context.Background())
+
+	// the backfills of the big tables, and the wait for the lock, can exceed the statement timeout
+	_, err = tx.Exec(ctx, `SET LOCAL statement_timeout = 0;`)
+	if err != nil {
+		return errors.Wrap(err, "unable to disable the statement timeout of the migration")
+	}
+	_, err = tx.Exec(ctx, `SELECT pg_advisory_xact_lock($1);`, c.migrationLockKey())
+	if err != nil {
+		return errors.Wrap(err, "unable to take the migration lock")
+	}
+	_, err = tx.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_version(
+			version INT NOT NULL,
+			name TEXT NOT NULL,
+			applied_at TIMESTAMP NOT NULL DEFAULT now(),
+
+			PRIMARY KEY (version)
+		);`)
+	if err != nil {
+		return errors.Wrap(err, "unable to create the schema_version table")
+	}
+	var current int
+	err = tx.QueryRow(ctx, `SELECT COALESCE(max(version), 0) FROM schema_version;`).Scan(&current)
+	if err != nil {
+		return errors.Wrap(err, "unable to read the schema version")
+	}
+
+	c.schemaTx = tx
+	err = fn(tx, current)
+	c.schemaTx = nil
+	if err != nil {
+		return err
+	}
+	return errors.Wrap(tx.Commit(ctx), "unable to commit the migration of the db schema")
+}
+
+func recordVersion(ctx context.Context, tx pgx.Tx, version int, name string) error {
+	_, err := tx.Exec(ctx, `INSERT INTO schema_version(version, name) VALUES ($1, $2);`, version, name)
+	return errors.Wrap(err, fmt.Sprintf("unable to record version %d", version))
+}
+
+func execStatements(ctx context.Context, tx pgx.Tx, stmts []string) error {
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1 @@
+DROP INDEX IF EXISTS conn_events_peer_network;
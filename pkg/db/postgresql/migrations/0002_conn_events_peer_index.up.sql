@@ -0,0 +1,2 @@
+-- the per-peer reads of conn_events (peer records, connected time) filter by the peer and its network
+CREATE INDEX IF NOT EXISTS conn_events_peer_network ON conn_events(peer_id, network);
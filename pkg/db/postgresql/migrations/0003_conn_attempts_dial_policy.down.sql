@@ -0,0 +1,2 @@
+ALTER TABLE conn_attempts DROP COLUMN IF EXISTS dial_score;
+ALTER TABLE conn_attempts DROP COLUMN IF EXISTS dial_delay;
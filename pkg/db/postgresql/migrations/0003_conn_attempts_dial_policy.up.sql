@@ -0,0 +1,3 @@
+-- the delay until the next attempt to the peer chosen after each attempt, and the dial score of the peer then
+ALTER TABLE conn_attempts ADD COLUMN IF NOT EXISTS dial_delay BIGINT;
+ALTER TABLE conn_attempts ADD COLUMN IF NOT EXISTS dial_score DOUBLE PRECISION;
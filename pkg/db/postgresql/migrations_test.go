@@ -0,0 +1,125 @@
+package postgresql
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/migalabs/armiarma/pkg/utils"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitStatements(t *testing.T) {
+	stmts := splitStatements(`
+		-- first the table
+		CREATE TABLE IF NOT EXISTS t(a TEXT DEFAULT 'x;y');
+		-- then its index
+		CREATE INDEX IF NOT EXISTS t_a ON t(a);
+
+		`)
+	require.Equal(t, []string{
+		`CREATE TABLE IF NOT EXISTS t(a TEXT DEFAULT 'x;y');`,
+		`CREATE INDEX IF NOT EXISTS t_a ON t(a);`,
+	}, stmts)
+	require.Empty(t, splitStatements("-- nothing to do\n"))
+}
+
+func TestLoadMigrations(t *testing.T) {
+	migrations, err := loadMigrations()
+	require.NoError(t, err)
+	require.NotEmpty(t, migrations)
+	for i, mig := range migrations {
+		require.Equal(t, BaselineVersion+1+i, mig.version)
+		require.NotEmpty(t, mig.up, mig.name)
+		require.NotEmpty(t, mig.down, mig.name)
+	}
+	latest, err := LatestVersion()
+	require.NoError(t, err)
+	require.Equal(t, migrations[len(migrations)-1].version, latest)
+
+	// the expected schema understands the statements of the migrations
+	_, err = ExpectedSchema(utils.EthereumNetwork)
+	require.NoError(t, err)
+}
+
+func TestMigrateInPSQL(t *testing.T) {
+	ctx := context.Background()
+	schema := "armiarma_migrate_test"
+	conn, err := pgx.Connect(ctx, loginStr)
+	require.NoError(t, err)
+	defer conn.Close(ctx)
+	_, err = conn.Exec(ctx, `DROP SCHEMA IF EXISTS `+schema+` CASCADE; CREATE SCHEMA `+schema+`;`)
+	require.NoError(t, err)
+	defer conn.Exec(ctx, `DROP SCHEMA IF EXISTS `+schema+` CASCADE;`)
+
+	latest, err := LatestVersion()
+	require.NoError(t, err)
+	newClient := func() *DBClient {
+		dbCli, err := NewDBClient(ctx, utils.EthereumNetwork, loginStr, 24*time.Hour,
+			InitializeTables(true), WithSchema(schema), WithoutBackups())
+		require.NoError(t, err)
+		return dbCli
+	}
+	versions := func() []int {
+		rows, err := conn.Query(ctx, `SELECT version FROM `+schema+`.schema_version ORDER BY version;`)
+		require.NoError(t, err)
+		defer rows.Close()
+		found := make([]int, 0)
+		for rows.Next() {
+			var version int
+			require.NoError(t, rows.Scan(&version))
+			found = append(found, version)
+		}
+		require.NoError(t, rows.Err())
+		return found
+	}
+	allVersions := make([]int, 0)
+	for version := BaselineVersion; version <= latest; version++ {
+		allVersions = append(allVersions, version)
+	}
+
+	// the clients starting at the same time on a fresh schema apply each version once
+	clients := make([]*DBClient, 2)
+	var wg sync.WaitGroup
+	for i := range clients {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			clients[i] = newClient()
+		}(i)
+	}
+	wg.Wait()
+	for _, dbCli := range clients {
+		defer dbCli.Close()
+	}
+	require.Equal(t, allVersions, versions())
+	dbCli := clients[0]
+	version, err := dbCli.SchemaVersion(ctx)
+	require.NoError(t, err)
+	require.Equal(t, latest, version)
+	require.NoError(t, dbCli.CheckSchema())
+
+	// the incremental upgrade only applies the pending migrations
+	require.NoError(t, dbCli.MigrateDown(ctx, BaselineVersion))
+	require.Equal(t, []int{BaselineVersion}, versions())
+	require.Error(t, dbCli.MigrateDown(ctx, BaselineVersion-1))
+	require.NoError(t, dbCli.Migrate(ctx))
+	require.Equal(t, allVersions, versions())
+	// and migrating an up to date schema is a no-op
+	require.NoError(t, dbCli.Migrate(ctx))
+	require.Equal(t, allVersions, versions())
+
+	// the DBs created before the versions get the baseline recorded
+	require.NoError(t, dbCli.MigrateDown(ctx, BaselineVersion))
+	_, err = conn.Exec(ctx, `DROP TABLE `+schema+`.schema_version;`)
+	require.NoError(t, err)
+	version, err = dbCli.SchemaVersion(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 0, version)
+	legacyCli := newClient()
+	defer legacyCli.Close()
+	require.Equal(t, allVersions, versions())
+	require.NoError(t, legacyCli.CheckSchema())
+}
@@ -0,0 +1,77 @@
+package postgresql
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// Several crawlers of different networks can share the same tables: the peer_info, conn_events,
+// eth_nodes and eth_status rows carry the network of the client that persisted them, which is part
+// of their keys, so that the same peer seen on two networks is stored twice. The reads of a client
+// only return the rows of its own network, unless it's created WithAllNetworks.
+
+// WithAllNetworks makes the reads of the client return the rows of all the networks stored in the DB,
+// instead of only the ones of the network of the client. The writes always go to its own network.
+func WithAllNetworks() DBOption {
+	return func(dbCli *DBClient) error {
+		dbCli.allNetworks = true
+		return nil
+	}
+}
+
+// readNetwork returns the network of the rows that the reads return, empty for all of them.
+func (c *DBClient) readNetwork() string {
+	if c.allNetworks {
+		return ""
+	}
+	return string(c.Network)
+}
+
+// networkCond returns the condition that keeps the rows of the given network column that belong to
+// the read network, passed as the argument of the given placeholder (see readNetwork).
+func networkCond(column string, placeholder int) string {
+	return fmt.Sprintf("($%d::TEXT = '' OR %s = $%d::TEXT)", placeholder, column, placeholder)
+}
+
+// networkColumn returns the definition of the network column for the tables created before it existed,
+// whose rows get the network of the client.
+func (c *DBClient) networkColumn() string {
+	return `network TEXT NOT NULL DEFAULT '` + strings.ReplaceAll(string(c.Network), `'`, `''`) + `'`
+}
+
+// migrateNetworkKey replaces the given constraint of a table created before the network column existed
+// by the given key, that includes the network. It only runs while the constraint doesn't include it.
+func (c *DBClient) migrateNetworkKey(table, constraint, key string) error {
+	// nothing to migrate while composing the expected schema
+	if c.schemaStmts != nil {
+		return nil
+	}
+	var outdated bool
+	err := c.schemaDB().QueryRow(c.ctx, `
+		SELECT EXISTS (
+			SELECT 1
+			FROM pg_constraint AS con
+			WHERE con.conrelid = to_regclass($1) AND con.conname = $2 AND NOT EXISTS (
+				SELECT 1
+				FROM pg_attribute AS att
+				WHERE att.attrelid = con.conrelid AND att.attnum = ANY(con.conkey) AND att.attname = 'network')
+		);`, c.qualify(table), constraint).Scan(&outdated)
+	if err != nil {
+		return errors.Wrap(err, "unable to check the key of table "+table)
+	}
+	if !outdated {
+		return nil
+	}
+	log.Infof("adding the network to the key of table %s", table)
+	_, err = c.schemaDB().Exec(c.ctx, `
+		ALTER TABLE `+table+`
+			DROP CONSTRAINT `+constraint+`,
+			ADD `+key+`;`)
+	if err != nil {
+		return errors.Wrap(err, "unable to add the network to the key of table "+table)
+	}
+	return nil
+}
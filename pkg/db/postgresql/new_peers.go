@@ -0,0 +1,75 @@
+package postgresql
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// NewPeerChannel is the Postgres channel notified when a new peer is inserted in peer_info
+	// (suffixed with "_<schema>" for the clients of a dedicated schema, see WithSchema)
+	NewPeerChannel = "new_peer"
+	// time to wait before reconnecting the listener after an error
+	listenReconnectDelay = 5 * time.Second
+)
+
+// NewPeerNotification is the payload of the notifications of the NewPeerChannel.
+type NewPeerNotification struct {
+	PeerID string `json:"peer_id"`
+	Client string `json:"client"`
+	IP     string `json:"ip"`
+}
+
+// ListenNewPeers listens to the NewPeerChannel until the context dies, calling handler for each new peer.
+// It uses a dedicated connection (outside the pool of the persisters) that is re-established on any error.
+func (c *DBClient) ListenNewPeers(ctx context.Context, handler func(NewPeerNotification)) {
+	logEntry := log.WithFields(log.Fields{
+		"mod": "new-peer-listener",
+	})
+	for {
+		err := c.listenNewPeers(ctx, handler)
+		if ctx.Err() != nil {
+			logEntry.Debug("context died, closing new peer listener")
+			return
+		}
+		logEntry.Debugf("new peer listener interrupted, reconnecting in %s: %s", listenReconnectDelay, err.Error())
+		// a DB that is down interrupts every reconnection the same way
+		c.errSampler.ErrorWithKey("new-peer-listener: "+SampleKey(err), errors.Wrap(err, "new peer listener interrupted, reconnecting"))
+		select {
+		case <-time.After(listenReconnectDelay):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (c *DBClient) listenNewPeers(ctx context.Context, handler func(NewPeerNotification)) error {
+	conn, err := c.connect(ctx)
+	if err != nil {
+		return errors.Wrap(err, "unable to connect the new peer listener")
+	}
+	defer conn.Close(context.Background())
+
+	channel := c.newPeerChannel()
+	_, err = conn.Exec(ctx, "LISTEN "+channel+";")
+	if err != nil {
+		return errors.Wrap(err, "unable to listen to "+channel)
+	}
+	for {
+		notification, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return errors.Wrap(err, "unable to wait for notifications of "+channel)
+		}
+		var newPeer NewPeerNotification
+		err = json.Unmarshal([]byte(notification.Payload), &newPeer)
+		if err != nil {
+			log.Warnf("unable to parse new peer notification %s: %s", notification.Payload, err.Error())
+			continue
+		}
+		handler(newPeer)
+	}
+}
@@ -1,20 +1,29 @@
 package postgresql
 
 import (
+	"time"
+
+	eth "github.com/migalabs/armiarma/pkg/networks/ethereum"
 	"github.com/pkg/errors"
 )
 
 type DBOption func(*DBClient) error 
 
+// InitializeTables creates the SQL tables (if needed) once the client is connected
 func InitializeTables(init bool) DBOption {
 	return func (dbCli *DBClient) error {
-		// initialize all the tables
-		if init {
-		    err := dbCli.initTables()
-			if err != nil {
-				return errors.Wrap(err, "unable to initialize the SQL tables at "+dbCli.loginStr)
-			}
-		}
+		dbCli.initializeTables = init
+		return nil
+	}
+}
+
+// WithReset drops all the crawl tables and views and creates them again once the client is connected,
+// and allows calling ResetTables afterwards. Meant for the ephemeral test and benchmark DBs,
+// as it deletes all the crawled data.
+func WithReset() DBOption {
+	return func(dbCli *DBClient) error {
+		dbCli.resetTables = true
+		dbCli.allowReset = true
 		return nil
 	}
 }
@@ -26,3 +35,154 @@ func WithConnectionEventsPersist(persist bool) DBOption {
 	}
 }
 
+
+// WithRetention prunes daily the conn_events older than the given duration (0 disables it)
+func WithRetention(retention time.Duration) DBOption {
+	return func(dbCli *DBClient) error {
+		if retention < 0 {
+			return errors.New("negative retention for the conn_events")
+		}
+		dbCli.retention = retention
+		return nil
+	}
+}
+
+// WithNewPeerNotifications makes the insertion of new peers into peer_info notify the NewPeerChannel
+func WithNewPeerNotifications(notify bool) DBOption {
+	return func(dbCli *DBClient) error {
+		dbCli.notifyNewPeers = notify
+		return nil
+	}
+}
+
+// WithPersisters sets the number of persisters, each with its own batch of queries. The items of
+// each peer are always persisted by the same one, in the order they were queued.
+func WithPersisters(persisters int) DBOption {
+	return func(dbCli *DBClient) error {
+		if persisters < 1 {
+			return errors.New("non-positive number of persisters")
+		}
+		dbCli.persisters = persisters
+		return nil
+	}
+}
+
+// WithConnEventsCopyThreshold sets the pending conn_events from which a batch copies them at once
+// into the table instead of inserting them one by one (0 always inserts them one by one)
+func WithConnEventsCopyThreshold(threshold int) DBOption {
+	return func(dbCli *DBClient) error {
+		if threshold < 0 {
+			return errors.New("negative copy threshold of the conn_events")
+		}
+		dbCli.connEventsCopyThreshold = threshold
+		return nil
+	}
+}
+
+// WithBatchTimeout sets the deadline of each batch of persisted queries
+func WithBatchTimeout(timeout time.Duration) DBOption {
+	return func(dbCli *DBClient) error {
+		if timeout <= 0 {
+			return errors.New("non-positive batch timeout")
+		}
+		dbCli.batchTimeout = timeout
+		return nil
+	}
+}
+
+// WithReadTimeout sets the deadline of each read query
+func WithReadTimeout(timeout time.Duration) DBOption {
+	return func(dbCli *DBClient) error {
+		if timeout <= 0 {
+			return errors.New("non-positive read timeout")
+		}
+		dbCli.readTimeout = timeout
+		return nil
+	}
+}
+
+// WithFetchSize sets the number of rows fetched at once by the streamed reads
+func WithFetchSize(fetchSize int) DBOption {
+	return func(dbCli *DBClient) error {
+		if fetchSize <= 0 {
+			return errors.New("non-positive fetch size")
+		}
+		dbCli.fetchSize = fetchSize
+		return nil
+	}
+}
+
+// WithOtherLibp2pPeers includes the non-Ethereum libp2p peers (IPFS, Filecoin, etc) in the client distributions
+func WithOtherLibp2pPeers(include bool) DBOption {
+	return func(dbCli *DBClient) error {
+		dbCli.includeOtherLibp2p = include
+		return nil
+	}
+}
+
+// WithIgnoredAddrPorts doesn't flag as addr_mismatch the peers whose observed address only differs
+// in the port from the ones of their ENR (i.e. NATs that remap the ports)
+func WithIgnoredAddrPorts(ignore bool) DBOption {
+	return func(dbCli *DBClient) error {
+		dbCli.ignoreAddrPorts = ignore
+		return nil
+	}
+}
+
+// WithPoolConfig sets the size and the lifetime of the connections of the pgx pool,
+// overriding any pool_* parameter of the login string
+func WithPoolConfig(poolConf PoolConfig) DBOption {
+	return func(dbCli *DBClient) error {
+		if poolConf.MaxConns <= 0 {
+			return errors.New("non-positive max connections of the pool")
+		}
+		if poolConf.MinConns < 0 || poolConf.MinConns > poolConf.MaxConns {
+			return errors.New("min connections of the pool out of [0, max connections]")
+		}
+		if poolConf.MaxConnLifetime < 0 || poolConf.MaxConnIdleTime < 0 || poolConf.HealthCheckPeriod < 0 {
+			return errors.New("negative duration in the pool config")
+		}
+		dbCli.poolConf = poolConf
+		return nil
+	}
+}
+
+// WithScheduledForks sets the announced forks whose readiness can be reported (see GetForkReadiness)
+func WithScheduledForks(forks ...eth.ScheduledFork) DBOption {
+	return func(dbCli *DBClient) error {
+		for _, fork := range forks {
+			if fork.Version == "" {
+				return errors.New("scheduled fork without version")
+			}
+		}
+		dbCli.scheduledForks = forks
+		return nil
+	}
+}
+
+// WithReadOnlyFallback lets the client start when the DB schema doesn't match the expected one,
+// logging the differences and refusing to persist anything. Meant for tooling that only reads.
+func WithReadOnlyFallback() DBOption {
+	return func(dbCli *DBClient) error {
+		dbCli.readOnlyFallback = true
+		return nil
+	}
+}
+
+// WithIntegrityCheck reports (without repairing them) the orphan rows of the crawl tables
+// once the client is connected, see CheckIntegrity
+func WithIntegrityCheck(check bool) DBOption {
+	return func(dbCli *DBClient) error {
+		dbCli.integrityCheck = check
+		return nil
+	}
+}
+
+// WithoutBackups disables the daily backup and retention heartbeats, and the backup of the
+// active peers on Close. Meant for the maintenance tasks that run next to the crawler.
+func WithoutBackups() DBOption {
+	return func(dbCli *DBClient) error {
+		dbCli.withoutBackups = true
+		return nil
+	}
+}
@@ -0,0 +1,100 @@
+package postgresql
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/migalabs/armiarma/pkg/db/models"
+)
+
+// PeerAttribute is a HostInfo attribute without a table of its own, as stored in peer_attributes.
+type PeerAttribute struct {
+	PeerID    peer.ID         `json:"peer_id"`
+	Name      string          `json:"attr_name"`
+	Payload   json.RawMessage `json:"payload"`
+	UpdatedAt time.Time       `json:"updated_at"`
+}
+
+// InitPeerAttributesTable keeps the latest value of the attributes of the peers that the
+// persister doesn't recognize, so new attributes can be stored without a table of their own.
+func (d *DBClient) InitPeerAttributesTable() error {
+	log.Debug("init peer_attributes table in psql-db")
+	err := d.execSchema(`
+		CREATE TABLE IF NOT EXISTS peer_attributes(
+			peer_id TEXT NOT NULL,
+			attr_name TEXT NOT NULL,
+			payload JSONB NOT NULL,
+			updated_at BIGINT NOT NULL,
+
+			PRIMARY KEY (peer_id, attr_name)
+		);
+	`)
+	return err
+}
+
+// peerAttributePayload encodes the attribute as JSON, through its own AttrPayload if it
+// implements models.PersistableAttr.
+func peerAttributePayload(att interface{}) ([]byte, error) {
+	persistable, ok := att.(models.PersistableAttr)
+	if !ok {
+		return json.Marshal(att)
+	}
+	payload, err := persistable.AttrPayload()
+	if err != nil {
+		return nil, err
+	}
+	if !json.Valid(payload) {
+		return nil, errors.New("invalid JSON payload")
+	}
+	return payload, nil
+}
+
+// UpsertPeerAttribute persists the latest payload of the attribute of the peer.
+func (d *DBClient) UpsertPeerAttribute(peerID peer.ID, name string, payload []byte, updatedAt time.Time) (query string, args []interface{}) {
+	log.Trace("upserting attribute to peer_attributes in psql-db")
+	query = `
+		INSERT INTO peer_attributes(
+			peer_id,
+			attr_name,
+			payload,
+			updated_at)
+		VALUES ($1,$2,$3,$4)
+		ON CONFLICT (peer_id, attr_name)
+		DO UPDATE SET
+			payload = EXCLUDED.payload,
+			updated_at = EXCLUDED.updated_at;
+		`
+
+	args = append(args, peerID.String())
+	args = append(args, name)
+	args = append(args, string(payload))
+	args = append(args, updatedAt.Unix())
+
+	return query, args
+}
+
+// GetPeerAttribute returns the latest payload stored for the attribute of the peer.
+func (d *DBClient) GetPeerAttribute(peerID peer.ID, name string) (PeerAttribute, error) {
+	ctx, cancel := d.readCtx()
+	defer cancel()
+	log.Tracef("fetching attribute %s of peer %s", name, peerID.String())
+	attr := PeerAttribute{PeerID: peerID, Name: name}
+
+	var payload string
+	var updatedAt int64
+	err := d.psqlPool.QueryRow(ctx, `
+		SELECT payload::text, updated_at
+		FROM peer_attributes
+		WHERE peer_id = $1 AND attr_name = $2;
+		`, peerID.String(), name).Scan(&payload, &updatedAt)
+	if err != nil {
+		return attr, errors.Wrap(err, "unable to fetch attribute "+name+" of peer "+peerID.String())
+	}
+	attr.Payload = json.RawMessage(payload)
+	attr.UpdatedAt = time.Unix(updatedAt, 0)
+	return attr, nil
+}
@@ -1,13 +1,15 @@
 package postgresql
 
 import (
+	"context"
+	"strconv"
 	"time"
 
+	"github.com/google/uuid"
 	pgx "github.com/jackc/pgx/v4"
 	"github.com/libp2p/go-libp2p-core/peer"
 	"github.com/migalabs/armiarma/pkg/db/models"
 	"github.com/migalabs/armiarma/pkg/utils"
-	ma "github.com/multiformats/go-multiaddr"
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 )
@@ -17,12 +19,14 @@ import (
 func (c *DBClient) InitPeerInfoTable() error {
 	log.Debug("initializing peer_info table in psql-db")
 
-	_, err := c.psqlPool.Exec(c.ctx, `
+	err := c.execSchema(`
 		CREATE TABLE IF NOT EXISTS peer_info(
 			id SERIAL,
 			peer_id TEXT NOT NULL,
 			network TEXT NOT NULL,
 			multi_addrs TEXT[] NOT NULL,
+			relay_addrs TEXT[],
+			relay_only BOOL,
 			ip TEXT NOT NULL,
 			port INT,
 
@@ -31,17 +35,37 @@ func (c *DBClient) InitPeerInfoTable() error {
 			client_version TEXT, 
 			client_os TEXT,
 			client_arch TEXT,
+			peer_category TEXT,
 			protocol_version TEXT,
 			sup_protocols TEXT[],
 			latency INT,
 			
 			deprecated BOOL,
 			attempted BOOL,
+			first_activity BIGINT,
 			last_activity BIGINT, 
 			last_conn_attempt BIGINT,
 			last_error TEXT,
-
-			PRIMARY KEY (peer_id)
+			quality_score REAL,
+			last_goodbye TEXT,
+			last_goodbye_time BIGINT,
+			attempts INT NOT NULL DEFAULT 0,
+			successful_attempts INT NOT NULL DEFAULT 0,
+			failed_attempts INT NOT NULL DEFAULT 0,
+			pubsub_version TEXT,
+			user_agent_sanitized BOOL,
+			distinct_topics INT,
+			dialback_attempted BOOL,
+			dialback_succeeded BOOL,
+			dialback_error TEXT,
+			dialback_time BIGINT,
+			client_confidence REAL,
+			metadata_requests INT NOT NULL DEFAULT 0,
+			metadata_successes INT NOT NULL DEFAULT 0,
+			last_metadata_attempt BIGINT,
+			last_metadata_error TEXT,
+
+			PRIMARY KEY (peer_id, network)
 		);
 		`)
 
@@ -49,203 +73,424 @@ func (c *DBClient) InitPeerInfoTable() error {
 		return errors.Wrap(err, "initializing peer_info table")
 	}
 
+	// add the relay, first_activity and peer_category columns to the tables created before they existed
+	err = c.execSchema(`
+		ALTER TABLE peer_info
+			ADD COLUMN IF NOT EXISTS relay_addrs TEXT[],
+			ADD COLUMN IF NOT EXISTS relay_only BOOL,
+			ADD COLUMN IF NOT EXISTS first_activity BIGINT,
+			ADD COLUMN IF NOT EXISTS peer_category TEXT;
+		`)
+	if err != nil {
+		return errors.Wrap(err, "adding relay, first_activity and peer_category columns to peer_info table")
+	}
+
+	err = c.execSchema(`
+		ALTER TABLE peer_info
+			ADD COLUMN IF NOT EXISTS quality_score REAL;
+		`)
+	if err != nil {
+		return errors.Wrap(err, "adding quality_score column to peer_info table")
+	}
+
+	err = c.execSchema(`
+		ALTER TABLE peer_info
+			ADD COLUMN IF NOT EXISTS last_goodbye TEXT,
+			ADD COLUMN IF NOT EXISTS last_goodbye_time BIGINT;
+		`)
+	if err != nil {
+		return errors.Wrap(err, "adding last_goodbye columns to peer_info table")
+	}
+
+	err = c.execSchema(`
+		ALTER TABLE peer_info
+			ADD COLUMN IF NOT EXISTS attempts INT NOT NULL DEFAULT 0,
+			ADD COLUMN IF NOT EXISTS successful_attempts INT NOT NULL DEFAULT 0,
+			ADD COLUMN IF NOT EXISTS failed_attempts INT NOT NULL DEFAULT 0;
+		`)
+	if err != nil {
+		return errors.Wrap(err, "adding attempt counters to peer_info table")
+	}
+
+	err = c.execSchema(`
+		ALTER TABLE peer_info
+			ADD COLUMN IF NOT EXISTS pubsub_version TEXT;
+		`)
+	if err != nil {
+		return errors.Wrap(err, "adding pubsub_version column to peer_info table")
+	}
+
+	err = c.execSchema(`
+		ALTER TABLE peer_info
+			ADD COLUMN IF NOT EXISTS user_agent_sanitized BOOL;
+		`)
+	if err != nil {
+		return errors.Wrap(err, "adding user_agent_sanitized column to peer_info table")
+	}
+
+	err = c.execSchema(`
+		ALTER TABLE peer_info
+			ADD COLUMN IF NOT EXISTS distinct_topics INT;
+		`)
+	if err != nil {
+		return errors.Wrap(err, "adding distinct_topics column to peer_info table")
+	}
+
+	err = c.execSchema(`
+		ALTER TABLE peer_info
+			ADD COLUMN IF NOT EXISTS dialback_attempted BOOL,
+			ADD COLUMN IF NOT EXISTS dialback_succeeded BOOL,
+			ADD COLUMN IF NOT EXISTS dialback_error TEXT,
+			ADD COLUMN IF NOT EXISTS dialback_time BIGINT;
+		`)
+	if err != nil {
+		return errors.Wrap(err, "adding dialback columns to peer_info table")
+	}
+
+	err = c.execSchema(`
+		ALTER TABLE peer_info
+			ADD COLUMN IF NOT EXISTS client_confidence REAL;
+		`)
+	if err != nil {
+		return errors.Wrap(err, "adding client_confidence column to peer_info table")
+	}
+
+	err = c.execSchema(`
+		ALTER TABLE peer_info
+			ADD COLUMN IF NOT EXISTS metadata_requests INT NOT NULL DEFAULT 0,
+			ADD COLUMN IF NOT EXISTS metadata_successes INT NOT NULL DEFAULT 0,
+			ADD COLUMN IF NOT EXISTS last_metadata_attempt BIGINT,
+			ADD COLUMN IF NOT EXISTS last_metadata_error TEXT;
+		`)
+	if err != nil {
+		return errors.Wrap(err, "adding metadata request counters to peer_info table")
+	}
+
+	// the same peer can be stored once per network
+	err = c.migrateNetworkKey("peer_info", "peer_info_pkey", "PRIMARY KEY (peer_id, network)")
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
 
-// InsertNewPeerInfo
+// UpsertHostInfo inserts the peer into peer_info, or updates its addresses if it already existed.
+// The new addresses are merged with the stored ones, ahead of them (see mergedMAddrs), and empty
+// IP or port never overwrite the ones that were already stored, and neither does a missing quality
+// score (models.QualityScoreAttribute).
+// If the new peer notifications are enabled, the insertion of a new row also notifies
+// the NewPeerChannel (delivered once the batch's transaction commits).
 func (c *DBClient) UpsertHostInfo(hInfo *models.HostInfo) (q string, args []interface{}) {
 	log.Trace("upserting host in peer_info table")
+	// the incoming host info has any address (direct or relay)
+	hasAddrs := `(COALESCE(cardinality(excluded.multi_addrs), 0) + COALESCE(cardinality(excluded.relay_addrs), 0)) > 0`
 	// compose the query
 	q = `INSERT INTO peer_info (
 			peer_id,
 			network,
 			multi_addrs,
+			relay_addrs,
+			relay_only,
 			ip,
 			port,
-			deprecated)
-		VALUES ($1,$2,$3,$4,$5,$6)
-		ON CONFLICT (peer_id)
+			deprecated,
+			quality_score)
+		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9)
+		ON CONFLICT (peer_id, network)
 		DO UPDATE SET
-			multi_addrs = excluded.multi_addrs,
-			ip = excluded.ip,
-			port = excluded.port,
-			deprecated = excluded.deprecated;
-		`
+			multi_addrs = ` + mergedMAddrs("multi_addrs") + `,
+			relay_addrs = ` + mergedMAddrs("relay_addrs") + `,
+			relay_only = CASE WHEN ` + hasAddrs + ` THEN excluded.relay_only ELSE peer_info.relay_only END,
+			ip = COALESCE(NULLIF(excluded.ip, ''), peer_info.ip),
+			port = CASE WHEN excluded.port > 0 THEN excluded.port ELSE peer_info.port END,
+			deprecated = excluded.deprecated,
+			quality_score = COALESCE(excluded.quality_score, peer_info.quality_score)`
 
 	args = append(args, hInfo.ID.String())
-	args = append(args, string(hInfo.Network))
-	args = append(args, hInfo.MAddrs)
+	args = append(args, string(c.Network))
+	// direct and circuit-relay addresses are stored separately, in their canonical form
+	hInfo.RLock()
+	relayAddrs := hInfo.RelayAddrs
+	hInfo.RUnlock()
+	_, directAddrStrs := utils.CanonicalMAddrs(hInfo.DirectAddrs())
+	_, relayAddrStrs := utils.CanonicalMAddrs(relayAddrs)
+	args = append(args, directAddrStrs)
+	args = append(args, relayAddrStrs)
+	args = append(args, hInfo.RelayOnly)
 	args = append(args, hInfo.IP)
 	args = append(args, hInfo.Port)
-	args = append(args, false)
+	args = append(args, hInfo.ControlInfo.Deprecated)
+	args = append(args, hostQualityScore(hInfo))
+
+	if !c.notifyNewPeers {
+		return q + ";", args
+	}
+
+	// xmax is 0 only for the rows that were inserted (not updated) by the upsert
+	q = `
+		WITH upserted AS (
+		` + q + `
+		RETURNING peer_id, ip, (xmax = 0) AS inserted
+		)
+		SELECT pg_notify($10, json_build_object(
+			'peer_id', peer_id,
+			'client', $11::TEXT,
+			'ip', ip)::TEXT)
+		FROM upserted
+		WHERE inserted;
+		`
+	// the client is only known if the peer was already identified
+	var client string
+	if hInfo.IsHostIdentified() {
+		client, _, _, _ = utils.ParseClientType(hInfo.Network, hInfo.PeerInfo.UserAgent)
+	}
+	args = append(args, c.newPeerChannel())
+	args = append(args, client)
 
 	return q, args
 }
 
-// InsertNewPeerInfo
+// mergedMAddrs returns the expression of the upserted multiaddresses of the column merged with the
+// stored ones: the new ones first, followed by the ones that the peer stopped advertising, up to
+// models.MaxStoredMAddrs of them.
+func mergedMAddrs(column string) string {
+	return `ARRAY(
+				SELECT maddr
+				FROM unnest(excluded.` + column + ` || COALESCE(peer_info.` + column + `, '{}')) WITH ORDINALITY AS merged(maddr, i)
+				GROUP BY maddr
+				ORDER BY MIN(i)
+				LIMIT ` + strconv.Itoa(models.MaxStoredMAddrs) + `)`
+}
+
+// hostQualityScore returns the quality score attribute of the host, nil (NULL) if it has none.
+func hostQualityScore(hInfo *models.HostInfo) *float64 {
+	hInfo.RLock()
+	defer hInfo.RUnlock()
+	score, ok := hInfo.Attr[models.QualityScoreAttribute].(models.QualityScore)
+	if !ok {
+		return nil
+	}
+	value := float64(score)
+	return &value
+}
+
+// UpdatePeerInfo updates the identification of the peer in peer_info.
+// A partial identification never overwrites the values that were already stored: the client
+// columns follow the user agent, so they are only replaced along with a non-empty one, the
+// protocols are only replaced by a non-empty list, and the latency by a positive one.
 func (c *DBClient) UpdatePeerInfo(pInfo *models.PeerInfo) (q string, args []interface{}) {
 	log.Trace("upserting peer in peer_info table")
 	// compose the query
 	q = `
 		UPDATE peer_info
 		SET
-			user_agent=$2,
-			client_name=$3,
-			client_version=$4,
-			client_os=$5,
-			client_arch=$6,
-			protocol_version=$7,
-			sup_protocols=$8,
-			latency=$9
-		WHERE peer_id=$1;
+			user_agent=COALESCE(NULLIF($2::TEXT, ''), user_agent),
+			client_name=COALESCE($3, client_name),
+			client_version=COALESCE($4, client_version),
+			client_os=COALESCE($5, client_os),
+			client_arch=COALESCE($6, client_arch),
+			protocol_version=COALESCE(NULLIF($7::TEXT, ''), protocol_version),
+			sup_protocols=COALESCE($8, sup_protocols),
+			latency=COALESCE($9, latency),
+			peer_category=COALESCE($10, peer_category),
+			user_agent_sanitized=COALESCE($11, user_agent_sanitized),
+			client_confidence=COALESCE($12, client_confidence)
+		WHERE peer_id=$1 AND network=$13;
 		`
 
-	// filter UserAgent to get client name, version, os, and arch
-	cliName, cliVers, cliOS, cliArch := utils.ParseClientType(c.Network, pInfo.UserAgent)
+	// the user agents that didn't go through PeerInfo.SetUserAgent would break the text column
+	userAgent, sanitized := utils.SanitizeUserAgent(pInfo.UserAgent)
+	sanitized = sanitized || pInfo.UserAgentSanitized
+
+	// filter UserAgent to get client name, version, os, and arch (NULL to keep the previous ones)
+	var cliName, cliVers, cliOS, cliArch, category, confidence, uaSanitized interface{}
+	if userAgent != "" {
+		cliInfo := utils.ParseClient(c.Network, userAgent)
+		cliName, cliVers, cliOS, cliArch = cliInfo.Name, cliInfo.Version, cliInfo.OS, cliInfo.Arch
+		category = string(utils.ParsePeerCategory(cliInfo.Name))
+		confidence = cliInfo.Confidence
+		uaSanitized = sanitized
+	}
+	var protocols interface{}
+	if len(pInfo.Protocols) > 0 {
+		protocols = pInfo.Protocols
+	}
 
 	args = append(args, pInfo.RemotePeer.String())
-	args = append(args, pInfo.UserAgent)
+	args = append(args, userAgent)
 	args = append(args, cliName)
 	args = append(args, cliVers)
 	args = append(args, cliOS)
 	args = append(args, cliArch)
 	args = append(args, pInfo.ProtocolVersion)
-	args = append(args, pInfo.Protocols)
-	args = append(args, pInfo.Latency.Milliseconds())
+	args = append(args, protocols)
+	args = append(args, peerLatency(pInfo))
+	args = append(args, category)
+	args = append(args, uaSanitized)
+	args = append(args, confidence)
+	args = append(args, string(c.Network))
 
 	return q, args
 }
 
+// peerLatency returns the latency of the peer in milliseconds, nil (NULL) to keep the previous one
+// if it wasn't measured or if it was measured through a relay (it's the relay's).
+func peerLatency(pInfo *models.PeerInfo) interface{} {
+	if pInfo.Relayed || pInfo.Latency <= 0 {
+		return nil
+	}
+	return pInfo.Latency.Milliseconds()
+}
+
+// UpdateConnAttempt updates the attempt status and counters of the peer, recording the attempt in conn_attempts.
+// The counters are incremented relative to their current value, so concurrent writers never lose an
+// attempt, and the update is gated by the insertion of the attempt's id, so that replaying the same
+// attempt (i.e. a retried batch) doesn't count it twice. The activity and the last attempt never
+// move back in time, so an attempt persisted out of order doesn't replace the last error either.
 func (c *DBClient) UpdateConnAttempt(connAttempt *models.ConnectionAttempt) (query string, args []interface{}) {
 	log.Tracef("updating peer_info because of new conn attempt %+v", connAttempt)
+	if connAttempt.ID == "" {
+		// without an id we can't detect the replays of the attempt, but we still count it
+		connAttempt.ID = uuid.NewString()
+	}
+	// the attempt is only applied to peer_info if its id wasn't recorded before
+	newAttempt := `
+			WITH new_attempt AS (
+				INSERT INTO conn_attempts(
+					attempt_id,
+					peer_id,
+					timestamp,
+					status,
+					error,
+					error_kind,
+					dial_delay,
+					dial_score)
+				VALUES ($1,$2,$3,$4,$5,$10,$11,$12)
+				ON CONFLICT (attempt_id) DO NOTHING
+				RETURNING peer_id
+			)`
+	var successes, failures int
 	// logic to determine how to update the table
 	if connAttempt.Status == models.PossitiveAttempt {
+		successes = 1
 		// we have the chance to un-deprecate the peer
-		query = `
+		query = newAttempt + `
 				UPDATE peer_info
 				SET 
-					deprecated=$2,
-					attempted=$3,
-					last_activity=$4, 
-					last_conn_attempt=$5,
-					last_error=$6
-				WHERE peer_id=$1;
+					deprecated=$6,
+					attempted=true,
+					first_activity=LEAST(COALESCE(first_activity, $3), $3),
+					last_activity=GREATEST(COALESCE(last_activity, $3), $3),
+					last_conn_attempt=GREATEST(COALESCE(last_conn_attempt, $3), $3),
+					last_error=CASE WHEN COALESCE(last_conn_attempt, 0) <= $3 THEN $5 ELSE last_error END,
+					attempts=peer_info.attempts + 1,
+					successful_attempts=peer_info.successful_attempts + $7,
+					failed_attempts=peer_info.failed_attempts + $8
+				WHERE peer_id IN (SELECT peer_id FROM new_attempt) AND network=$9;
 			`
-		args = append(args, connAttempt.RemotePeer.String())
-		args = append(args, false)                        // Un-Deprecate peer
-		args = append(args, true)                         // Connection was attempted
-		args = append(args, connAttempt.Timestamp.Unix()) // attempt timestamp (same as our new last activity)
-		args = append(args, connAttempt.Timestamp.Unix()) // attempt timestamp (same as our new last activity)
-		args = append(args, connAttempt.Error)
 	} else {
-		query = `
+		failures = 1
+		query = newAttempt + `
 			UPDATE peer_info
 			SET 
-				deprecated=$2,
-				attempted=$3,
-				last_conn_attempt=$4,
-				last_error=$5
-			WHERE peer_id=$1;
+				deprecated=$6,
+				attempted=true,
+				last_conn_attempt=GREATEST(COALESCE(last_conn_attempt, $3), $3),
+				last_error=CASE WHEN COALESCE(last_conn_attempt, 0) <= $3 THEN $5 ELSE last_error END,
+				attempts=peer_info.attempts + 1,
+				successful_attempts=peer_info.successful_attempts + $7,
+				failed_attempts=peer_info.failed_attempts + $8
+			WHERE peer_id IN (SELECT peer_id FROM new_attempt) AND network=$9;
 		`
-		args = append(args, connAttempt.RemotePeer.String())
-		args = append(args, connAttempt.Deprecable)
-		args = append(args, true) // connection attempted
-		args = append(args, connAttempt.Timestamp.Unix())
-		args = append(args, connAttempt.Error)
 	}
+	args = append(args, connAttempt.ID)
+	args = append(args, connAttempt.RemotePeer.String())
+	args = append(args, connAttempt.Timestamp.Unix()) // attempt timestamp (our new last activity if it succeeded)
+	args = append(args, string(connAttempt.Status))
+	args = append(args, connAttempt.Error)
+	args = append(args, connAttempt.Status != models.PossitiveAttempt && connAttempt.Deprecable) // un-deprecate the peer if it succeeded
+	args = append(args, successes)
+	args = append(args, failures)
+	args = append(args, string(c.Network))
+	args = append(args, nullIfEmpty(connAttempt.ErrorKind)) // the successful attempts don't have any
+	args = append(args, int64(connAttempt.DialDelay.Seconds()))
+	args = append(args, connAttempt.DialScore)
 
 	return query, args
 }
 
-func (c *DBClient) GetFullHostInfo(pID peer.ID) (*models.HostInfo, error) {
-	log.Tracef("reading info for peer %s", pID.String())
+// UpdateAttemptAggregates updates the connection attempt aggregates of the peer with the ones of its ControlInfo
+// (i.e. the ones kept in memory by the crawler, see metrics.Peer.ToHostInfo). The counters are also incremented
+// by each persisted attempt (see UpdateConnAttempt), so the aggregates never lower them, and the last error
+// is only replaced by the one of a more recent attempt.
+func (c *DBClient) UpdateAttemptAggregates(peerID peer.ID, cInfo models.ControlInfo) (query string, args []interface{}) {
+	query = `
+		UPDATE peer_info
+		SET
+			attempted=true,
+			attempts=GREATEST(attempts, $2),
+			successful_attempts=GREATEST(successful_attempts, $3),
+			failed_attempts=GREATEST(failed_attempts, $4),
+			last_conn_attempt=GREATEST(COALESCE(last_conn_attempt, 0), $5),
+			last_error=CASE WHEN COALESCE(last_conn_attempt, 0) <= $5 THEN $6 ELSE last_error END
+		WHERE peer_id=$1 AND network=$7;
+	`
+	var lastAttempt int64
+	if !cInfo.LastConnAttempt.IsZero() {
+		lastAttempt = cInfo.LastConnAttempt.Unix()
+	}
 
-	hInfo := models.NewHostInfo(pID, utils.EthereumNetwork)
-	pInfo := models.NewEmptyPeerInfo()
-	cInfo := models.NewControlInfo()
-	pInfo.RemotePeer = pID
+	args = append(args, peerID.String())
+	args = append(args, cInfo.Attempts)
+	args = append(args, cInfo.SuccessfulAttempts)
+	args = append(args, cInfo.Attempts-cInfo.SuccessfulAttempts)
+	args = append(args, lastAttempt)
+	args = append(args, cInfo.LastError)
+	args = append(args, string(c.Network))
 
-	var maddresses []string
-	var lastActivity int64
-	var lastConnAttempt int64
-	var latencyMillis int64
+	return query, args
+}
+
+// GetFullHostInfo returns the HostInfo of the peer stored in peer_info. Its invalid multiaddresses
+// are dropped with a warning.
+func (c *DBClient) GetFullHostInfo(pID peer.ID) (*models.HostInfo, error) {
+	ctx, cancel := c.readCtx()
+	defer cancel()
+	log.Tracef("reading info for peer %s", pID.String())
 
 	// read the Peer from the SQL database
-	err := c.psqlPool.QueryRow(c.ctx, `
-		SELECT
-			network,
-			multi_addrs,
-			ip,
-			port,
-			user_agent,
-			protocol_version,
-			sup_protocols,
-			latency,
-			deprecated,
-			attempted,
-			last_activity,
-			last_conn_attempt,
-			last_error
-		FROM peer_info
-		WHERE peer_id=$1;
-	`, pID.String()).Scan(
-		&hInfo.Network,
-		&maddresses,
-		&hInfo.IP,
-		&hInfo.Port,
-		&pInfo.UserAgent,
-		&pInfo.ProtocolVersion,
-		&pInfo.Protocols,
-		&latencyMillis,
-		&cInfo.Deprecated,
-		&cInfo.Attempted,
-		&lastActivity,
-		&lastConnAttempt,
-		&cInfo.LastError,
-	)
+	hInfo, mAddrsErr, _, err := scanHostInfo(c.psqlPool.QueryRow(ctx, `
+		SELECT`+hostInfoColumns+`
+		FROM v_peer_overview
+		WHERE peer_id=$1 AND `+networkCond("network", 2)+`;
+	`, pID.String(), c.readNetwork()))
 	// Check if there was any error reading the peer from the SQL table
 	if err != nil {
 		return &models.HostInfo{}, errors.Wrap(err, "unable to retrieve full peer_info")
 	}
-
-	// parse the multiaddresses from the []string
-	var mAddrs []ma.Multiaddr
-	// translate []string to maddress
-	for _, maStr := range maddresses {
-		mAddr, err := ma.NewMultiaddr(maStr)
-		if err != nil {
-			return &models.HostInfo{}, errors.Wrap(err, "unable to parse mAddrs reading full peer_info")
-		}
-		mAddrs = append(mAddrs, mAddr)
+	if mAddrsErr != nil {
+		log.Warn(errors.Wrap(mAddrsErr, "dropping invalid multiaddress of peer "+pID.String()))
 	}
-
-	// parse times from received Unix() timestamps
-	cInfo.LastActivity = time.Unix(lastActivity, int64(0))
-	cInfo.LastConnAttempt = time.Unix(lastConnAttempt, int64(0))
-	// parse latency in millisecods
-	pInfo.Latency = time.Duration(latencyMillis) * time.Millisecond
-
-	hInfo.MAddrs = mAddrs
-	hInfo.PeerInfo = *pInfo
-	hInfo.ControlInfo = *cInfo
-
 	return hInfo, nil
 }
 
 func (c *DBClient) GetPersistable(pID string) (models.RemoteConnectablePeer, error) {
+	ctx, cancel := c.readCtx()
+	defer cancel()
 	log.Tracef("reading persistable info for peer %s", pID)
 
 	var maddresses []string
 	var network utils.NetworkType
 
 	// read the Peer from the SQL database
-	err := c.psqlPool.QueryRow(c.ctx, `
+	err := c.psqlPool.QueryRow(ctx, `
 		SELECT
 			network,
-			multi_addrs
-		FROM peer_info
-		WHERE peer_id=$1;
-	`, pID).Scan(
+			multi_addrs || COALESCE(relay_addrs, '{}')
+		FROM v_peer_overview
+		WHERE peer_id=$1 AND `+networkCond("network", 2)+`;
+	`, pID, c.readNetwork()).Scan(
 		&network,
 		&maddresses,
 	)
@@ -255,14 +500,9 @@ func (c *DBClient) GetPersistable(pID string) (models.RemoteConnectablePeer, err
 	}
 
 	// parse the multiaddresses from the []string
-	var mAddrs []ma.Multiaddr
-	// translate []string to maddress
-	for _, maStr := range maddresses {
-		mAddr, err := ma.NewMultiaddr(maStr)
-		if err != nil {
-			return models.RemoteConnectablePeer{}, errors.Wrap(err, "unable to parse mAddrs reading full peer_info")
-		}
-		mAddrs = append(mAddrs, mAddr)
+	mAddrs, _, err := utils.ParseCanonicalMAddrs(maddresses)
+	if err != nil {
+		return models.RemoteConnectablePeer{}, errors.Wrap(err, "unable to parse mAddrs reading full peer_info")
 	}
 
 	peerID, err := peer.Decode(pID)
@@ -279,14 +519,16 @@ func (c *DBClient) GetPersistable(pID string) (models.RemoteConnectablePeer, err
 }
 
 func (c *DBClient) PeerInfoExists(pID peer.ID) bool {
+	ctx, cancel := c.readCtx()
+	defer cancel()
 	// get the ip
 	var id string
-	err := c.psqlPool.QueryRow(c.ctx, `
+	err := c.psqlPool.QueryRow(ctx, `
 		SELECT 
 			peer_id
-		FROM peer_info
-		WHERE peer_id=$1;
-	`, pID).Scan(&id)
+		FROM v_peer_overview
+		WHERE peer_id=$1 AND `+networkCond("network", 2)+`;
+	`, pID, c.readNetwork()).Scan(&id)
 
 	if err != nil && err != pgx.ErrNoRows {
 		log.Debugf("peer %s doesn't exist", id)
@@ -296,67 +538,187 @@ func (c *DBClient) PeerInfoExists(pID peer.ID) bool {
 	return true
 }
 
+// UpdateGoodbye stores the reason of the last Goodbye received from the peer,
+// unless the stored one is more recent.
+func (c *DBClient) UpdateGoodbye(goodbye models.Goodbye) (query string, args []interface{}) {
+	log.Trace("updating last goodbye in peer_info in psql-db")
+	query = `
+		UPDATE peer_info
+		SET
+			last_goodbye=$2,
+			last_goodbye_time=$3
+		WHERE peer_id=$1 AND network=$4 AND COALESCE(last_goodbye_time, 0) <= $3;
+	`
+
+	args = append(args, goodbye.PeerID.String())
+	args = append(args, goodbye.Reason())
+	args = append(args, goodbye.Timestamp.Unix())
+	args = append(args, string(c.Network))
+
+	return query, args
+}
+
+// UpdateMetadataRequest counts the outcome of a MetaData request sent to the peer. The time of the
+// last request and the error of the last failed one only move forward.
+func (c *DBClient) UpdateMetadataRequest(outcome models.MetadataRequest) (query string, args []interface{}) {
+	log.Trace("updating metadata request counters in peer_info in psql-db")
+	query = `
+		UPDATE peer_info
+		SET
+			metadata_requests = metadata_requests + 1,
+			metadata_successes = metadata_successes + $2,
+			last_metadata_attempt = GREATEST(COALESCE(last_metadata_attempt, 0), $3),
+			last_metadata_error = CASE
+				WHEN $4 <> '' AND COALESCE(last_metadata_attempt, 0) <= $3 THEN $4
+				ELSE last_metadata_error
+			END
+		WHERE peer_id = $1 AND network = $5;
+	`
+
+	successes := 0
+	if outcome.Succeeded() {
+		successes = 1
+	}
+	args = append(args, outcome.PeerID.String())
+	args = append(args, successes)
+	args = append(args, outcome.Timestamp.Unix())
+	args = append(args, outcome.ErrorKey())
+	args = append(args, string(c.Network))
+
+	return query, args
+}
+
+// UpdatePubsubVersion stores the pubsub protocol negotiated with the peer.
+// The peers with which we never exchanged pubsub keep it NULL.
+func (c *DBClient) UpdatePubsubVersion(pubsubProto models.PubsubProtocol) (query string, args []interface{}) {
+	log.Trace("updating pubsub version in peer_info in psql-db")
+	query = `
+		UPDATE peer_info
+		SET pubsub_version=$2
+		WHERE peer_id=$1 AND network=$3;
+	`
+
+	args = append(args, pubsubProto.PeerID.String())
+	args = append(args, pubsubProto.Version())
+	args = append(args, string(c.Network))
+
+	return query, args
+}
+
+// UpdateDistinctTopics stores the number of distinct topics on which the peer delivered messages.
+// The peers that never delivered any keep it NULL.
+func (c *DBClient) UpdateDistinctTopics(peerID peer.ID, topics models.DistinctTopics) (query string, args []interface{}) {
+	log.Trace("updating distinct topics in peer_info in psql-db")
+	query = `
+		UPDATE peer_info
+		SET distinct_topics=$2
+		WHERE peer_id=$1 AND network=$3;
+	`
+
+	args = append(args, peerID.String())
+	args = append(args, int(topics))
+	args = append(args, string(c.Network))
+
+	return query, args
+}
+
+// UpdateLastActivityTimestamp returns the query that extends the activity window of the peer up to t.
+// The last_activity never goes backwards, so the updates can be applied in any order.
 func (c *DBClient) UpdateLastActivityTimestamp(peerID peer.ID, t time.Time) (query string, args []interface{}) {
+	return lastActivityQuery(c.Network, peerID, t)
+}
+
+func lastActivityQuery(network utils.NetworkType, peerID peer.ID, t time.Time) (query string, args []interface{}) {
 	query = `
 		UPDATE peer_info
 		SET
-			last_activity=$2
-		WHERE peer_id=$1;
+			first_activity=LEAST(COALESCE(first_activity, $2), $2),
+			last_activity=GREATEST(COALESCE(last_activity, $2), $2)
+		WHERE peer_id=$1 AND network=$3;
 	`
 
 	args = append(args, peerID.String())
 	args = append(args, t.Unix())
+	args = append(args, string(network))
 
 	return query, args
 }
 
-func (c *DBClient) GetNonDeprecatedPeers() ([]*models.RemoteConnectablePeer, error) {
-	log.Tracef("retrieving the list of peer_ids from the DB that are not deprecated\n")
+// UpdateDeprecation marks the peer as deprecated, unless the database has seen it active after
+// the InactiveSince of the deprecation (the next upsert of its host info undeprecates it again).
+// The peers of foreign networks are deprecated regardless of their activity.
+func (c *DBClient) UpdateDeprecation(deprecation models.Deprecation) (query string, args []interface{}) {
+	query = `
+		UPDATE peer_info
+		SET deprecated=true
+		WHERE peer_id=$1 AND network=$4 AND ($3 OR COALESCE(last_activity, 0) < $2);
+	`
+	var inactiveSince int64
+	if !deprecation.InactiveSince.IsZero() {
+		inactiveSince = deprecation.InactiveSince.Unix()
+	}
+	args = append(args, deprecation.PeerID.String())
+	args = append(args, inactiveSince)
+	args = append(args, deprecation.ForeignNetwork)
+	args = append(args, string(c.Network))
+
+	return query, args
+}
+
+// GetNonDeprecatedPeers returns up to limit peers that are not deprecated (all of them with a
+// non-positive limit). Prefer ForEachNonDeprecatedPeer, which doesn't hold all of them in memory.
+func (c *DBClient) GetNonDeprecatedPeers(limit int) ([]*models.RemoteConnectablePeer, error) {
 	var connectPeers []*models.RemoteConnectablePeer
+	_, err := c.forEachNonDeprecatedPeer(c.ctx, limit, func(connectable *models.RemoteConnectablePeer) error {
+		connectPeers = append(connectPeers, connectable)
+		return nil
+	})
+	return connectPeers, err
+}
 
-	rows, err := c.psqlPool.Query(c.ctx, `
-		SELECT
-			peer_id,
-			network,
-			multi_addrs
-		FROM peer_info
-		WHERE deprecated='false';`)
+// ForEachNonDeprecatedPeer streams the peers that are not deprecated, calling fn for each of them.
+// The peers whose stored multiaddresses don't parse anymore are skipped with a warning.
+// Returns the number of read peers.
+func (c *DBClient) ForEachNonDeprecatedPeer(ctx context.Context, fn func(*models.RemoteConnectablePeer) error) (int64, error) {
+	return c.forEachNonDeprecatedPeer(ctx, 0, fn)
+}
 
-	// If there are no rows, don't panic
-	if err != nil && err != pgx.ErrNoRows {
-		return connectPeers, errors.Wrap(err, "unable to retrieve peers in the network")
+// forEachNonDeprecatedPeer is ForEachNonDeprecatedPeer, reading up to limit peers (all of them
+// with a non-positive limit).
+func (c *DBClient) forEachNonDeprecatedPeer(ctx context.Context, limit int, fn func(*models.RemoteConnectablePeer) error) (int64, error) {
+	log.Tracef("retrieving the list of peer_ids from the DB that are not deprecated\n")
+	if limit < 0 {
+		limit = 0
 	}
-	defer rows.Close()
 
-	for rows.Next() {
+	total, err := c.StreamQuery(ctx, func(rows pgx.Rows) error {
 		var peerIDStr string
 		var mAddrsStr []string
 		var networkStr string
 
 		err := rows.Scan(&peerIDStr, &networkStr, &mAddrsStr)
 		if err != nil {
-			return connectPeers, err
+			return err
 		}
 
 		// persist peerID
 		peerID, err := peer.Decode(peerIDStr)
 		if err != nil {
 			log.Errorf("unable to get peerID from DB %s \n", peerIDStr)
-			continue // if error, go for the next one
+			return nil // if error, go for the next one
 		}
 
 		// parse the network type
 		network := utils.NetworkType(networkStr)
 
-		// parse the multiaddress
-		maddrs := make([]ma.Multiaddr, 0, len(mAddrsStr))
-		for _, element := range mAddrsStr {
-			mAddr, err := ma.NewMultiaddr(element)
-			if err != nil {
-				log.Error(errors.Wrap(err, "unable to parse mAddrs reading full peer_info"))
-				continue
+		// parse the multiaddress (the invalid ones are skipped)
+		maddrs, _, err := utils.ParseCanonicalMAddrs(mAddrsStr)
+		if err != nil {
+			if len(maddrs) == 0 {
+				log.Warn(errors.Wrap(err, "skipping peer "+peerIDStr+" without valid multiaddresses"))
+				return nil
 			}
-			maddrs = append(maddrs, mAddr)
+			log.Warn(errors.Wrap(err, "dropping invalid multiaddress of peer "+peerIDStr))
 		}
 		// create the persistable instance
 		connectable := models.NewRemoteConnectablePeer(
@@ -364,9 +726,17 @@ func (c *DBClient) GetNonDeprecatedPeers() ([]*models.RemoteConnectablePeer, err
 			maddrs,
 			network,
 		)
-
-		// decode peerID to have proper OBJ
-		connectPeers = append(connectPeers, connectable)
+		return fn(connectable)
+	}, `
+		SELECT
+			peer_id,
+			network,
+			multi_addrs || COALESCE(relay_addrs, '{}')
+		FROM v_peer_overview
+		WHERE deprecated='false' AND `+networkCond("network", 2)+`
+		LIMIT NULLIF($1, 0)`, limit, c.readNetwork())
+	if err != nil {
+		return total, errors.Wrap(err, "unable to retrieve peers in the network")
 	}
-	return connectPeers, nil
+	return total, nil
 }
@@ -0,0 +1,203 @@
+package postgresql
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/migalabs/armiarma/pkg/db/models"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultPageLimit is the number of peers of a page when the request doesn't set any
+	DefaultPageLimit = 100
+	// MaxPageLimit is the maximum number of peers of a page
+	MaxPageLimit = 1000
+)
+
+// PeerFilter selects the peers of ListPeers. The zero value of each field doesn't filter.
+type PeerFilter struct {
+	// exact client name (i.e. "lighthouse")
+	ClientName string
+	// prefix of the client version (i.e. "v4.1")
+	VersionPrefix string
+	// ISO code of the country of the peer's IP (i.e. "DE")
+	CountryCode string
+	// peers with any activity since then
+	ConnectedSince time.Time
+	// deprecated state of the peers
+	Deprecated *bool
+}
+
+// PageRequest is a keyset-based page of ListPeers: the peers sorted by peer_id after the given one.
+type PageRequest struct {
+	// peer_id after which the page starts (the NextPage of the previous page), empty for the first page
+	After string
+	// peers per page (DefaultPageLimit if unset, at most MaxPageLimit)
+	Limit int
+	// also count all the peers matching the filter, which is expensive over big tables
+	WithTotal bool
+}
+
+// PeerPage is a page of the peers that match a PeerFilter.
+type PeerPage struct {
+	Peers []models.PeerInfo
+	// token to request the following page, empty if this is the last one
+	NextPage string
+	// number of peers matching the filter (-1 unless requested WithTotal)
+	Total int64
+}
+
+// ListPeers returns the page of peers that match the filter, sorted by peer_id.
+func (c *DBClient) ListPeers(filter PeerFilter, page PageRequest) (PeerPage, error) {
+	ctx, cancel := c.readCtx()
+	defer cancel()
+	log.Debugf("listing peers with filter %+v after %q", filter, page.After)
+
+	if page.After != "" {
+		if _, err := peer.Decode(page.After); err != nil {
+			return PeerPage{}, errors.Wrap(err, "invalid page token")
+		}
+	}
+	if page.Limit <= 0 {
+		page.Limit = DefaultPageLimit
+	}
+	if page.Limit > MaxPageLimit {
+		page.Limit = MaxPageLimit
+	}
+
+	peerPage := PeerPage{
+		Peers: make([]models.PeerInfo, 0, page.Limit),
+		Total: -1,
+	}
+	query, args := listPeersQuery(filter, page, c.readNetwork())
+	rows, err := c.psqlPool.Query(ctx, query, args...)
+	if err != nil {
+		return PeerPage{}, errors.Wrap(err, "unable to list peers")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var peerStr string
+		var latencyMillis int64
+		pInfo := models.NewEmptyPeerInfo()
+		err = rows.Scan(
+			&peerStr,
+			&pInfo.UserAgent,
+			&pInfo.ProtocolVersion,
+			&pInfo.Protocols,
+			&latencyMillis,
+		)
+		if err != nil {
+			return PeerPage{}, errors.Wrap(err, "unable to parse listed peer")
+		}
+		pInfo.RemotePeer, err = peer.Decode(peerStr)
+		if err != nil {
+			return PeerPage{}, errors.Wrap(err, "unable to parse peer_id of listed peer "+peerStr)
+		}
+		pInfo.Latency = time.Duration(latencyMillis) * time.Millisecond
+		peerPage.Peers = append(peerPage.Peers, *pInfo)
+	}
+	if err = rows.Err(); err != nil {
+		return PeerPage{}, errors.Wrap(err, "unable to list peers")
+	}
+
+	// one peer more than the limit is read to know whether there is a following page
+	if len(peerPage.Peers) > page.Limit {
+		peerPage.Peers = peerPage.Peers[:page.Limit]
+		peerPage.NextPage = peerPage.Peers[page.Limit-1].RemotePeer.String()
+	}
+
+	if page.WithTotal {
+		query, args = countPeersQuery(filter, c.readNetwork())
+		err = c.psqlPool.QueryRow(ctx, query, args...).Scan(&peerPage.Total)
+		if err != nil {
+			return PeerPage{}, errors.Wrap(err, "unable to count listed peers")
+		}
+	}
+	return peerPage, nil
+}
+
+// listPeersQuery composes the query of a page of peers (plus one to detect the following page).
+func listPeersQuery(filter PeerFilter, page PageRequest, network string) (query string, args []interface{}) {
+	conditions, args := peerFilterConditions(filter, network)
+	if page.After != "" {
+		args = append(args, page.After)
+		conditions = append(conditions, fmt.Sprintf("p.peer_id > $%d", len(args)))
+	}
+	args = append(args, page.Limit+1)
+	query = `
+		SELECT
+			p.peer_id,
+			COALESCE(p.user_agent, ''),
+			COALESCE(p.protocol_version, ''),
+			COALESCE(p.sup_protocols, '{}'),
+			COALESCE(p.latency, 0)
+		FROM peer_info AS p` + peerFilterJoin(filter) + whereClause(conditions) + `
+		ORDER BY p.peer_id
+		LIMIT $` + fmt.Sprintf("%d", len(args)) + `;`
+	return query, args
+}
+
+// countPeersQuery composes the query counting all the peers that match the filter.
+func countPeersQuery(filter PeerFilter, network string) (query string, args []interface{}) {
+	conditions, args := peerFilterConditions(filter, network)
+	query = `
+		SELECT COUNT(*)
+		FROM peer_info AS p` + peerFilterJoin(filter) + whereClause(conditions) + `;`
+	return query, args
+}
+
+// peerFilterConditions returns the SQL conditions of the filter, whose values are passed as arguments.
+// An empty network doesn't filter the peers by network.
+func peerFilterConditions(filter PeerFilter, network string) (conditions []string, args []interface{}) {
+	if filter.ClientName != "" {
+		args = append(args, filter.ClientName)
+		conditions = append(conditions, fmt.Sprintf("p.client_name = $%d", len(args)))
+	}
+	if filter.VersionPrefix != "" {
+		args = append(args, escapeLike(filter.VersionPrefix)+"%")
+		conditions = append(conditions, fmt.Sprintf("p.client_version LIKE $%d", len(args)))
+	}
+	if filter.CountryCode != "" {
+		args = append(args, strings.ToUpper(filter.CountryCode))
+		conditions = append(conditions, fmt.Sprintf("i.country_code = $%d", len(args)))
+	}
+	if !filter.ConnectedSince.IsZero() {
+		args = append(args, filter.ConnectedSince.Unix())
+		conditions = append(conditions, fmt.Sprintf("p.last_activity >= $%d", len(args)))
+	}
+	if filter.Deprecated != nil {
+		args = append(args, *filter.Deprecated)
+		conditions = append(conditions, fmt.Sprintf("COALESCE(p.deprecated, false) = $%d", len(args)))
+	}
+	if network != "" {
+		args = append(args, network)
+		conditions = append(conditions, fmt.Sprintf("p.network = $%d", len(args)))
+	}
+	return conditions, args
+}
+
+// peerFilterJoin returns the join with the ips table, only needed to filter by country.
+func peerFilterJoin(filter PeerFilter) string {
+	if filter.CountryCode == "" {
+		return ""
+	}
+	return `
+		JOIN ips AS i ON i.ip = p.ip`
+}
+
+func whereClause(conditions []string) string {
+	if len(conditions) == 0 {
+		return ""
+	}
+	return `
+		WHERE ` + strings.Join(conditions, " AND ")
+}
+
+// escapeLike escapes the wildcards of a LIKE pattern (with the default \ escape character).
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
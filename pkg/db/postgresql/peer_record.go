@@ -0,0 +1,487 @@
+package postgresql
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	pgx "github.com/jackc/pgx/v4"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/migalabs/armiarma/pkg/db"
+	"github.com/migalabs/armiarma/pkg/db/models"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// number of (most recent) conn_events included in the PeerFullRecord
+	PeerRecordConnEvents = 50
+	// path of the HTTP endpoint that serves the PeerFullRecords (followed by the peer ID)
+	PeerRecordEndpoint = "/peers/"
+)
+
+// ErrPeerNotFound is returned when the peer is not in the peer_info table.
+var ErrPeerNotFound = db.ErrPeerNotFound
+
+// PeerFullRecord is everything stored about a peer. The sections of the tables
+// without any data of the peer are nil (i.e. no Ethereum status for a non-Ethereum peer).
+type PeerFullRecord struct {
+	PeerID          string                 `json:"peer_id"`
+	Info            PeerInfoRecord         `json:"info"`
+	Location        *models.IpInfo         `json:"location"`
+	Enr             *PeerEnrRecord         `json:"enr"`
+	Status          *PeerStatusRecord      `json:"status"`
+	MetadataHistory []MetadataHistoryEntry `json:"metadata_history"`
+	ConnEvents      []PeerConnEventRecord  `json:"conn_events"`
+	ConnAttempts    *PeerConnAttempts      `json:"conn_attempts"`
+}
+
+// PeerInfoRecord is the peer_info row of the peer.
+type PeerInfoRecord struct {
+	Network         string    `json:"network"`
+	MultiAddrs      []string  `json:"multi_addrs"`
+	RelayAddrs      []string  `json:"relay_addrs"`
+	RelayOnly       bool      `json:"relay_only"`
+	IP              string    `json:"ip"`
+	Port            int       `json:"port"`
+	UserAgent       string    `json:"user_agent"`
+	ClientName      string    `json:"client_name"`
+	ClientVersion   string    `json:"client_version"`
+	ClientOS        string    `json:"client_os"`
+	ClientArch      string    `json:"client_arch"`
+	PeerCategory    string    `json:"peer_category"`
+	ProtocolVersion string    `json:"protocol_version"`
+	Protocols       []string  `json:"protocols"`
+	LatencyMs       int64     `json:"latency_ms"`
+	Deprecated      bool      `json:"deprecated"`
+	Attempted       bool      `json:"attempted"`
+	FirstActivity   time.Time `json:"first_activity"`
+	LastActivity    time.Time `json:"last_activity"`
+	LastConnAttempt time.Time `json:"last_conn_attempt"`
+	LastError       string    `json:"last_error"`
+	QualityScore    *float64  `json:"quality_score"`
+	LastGoodbye     string    `json:"last_goodbye"`
+	LastGoodbyeTime time.Time `json:"last_goodbye_time"`
+	// number of connection attempts, and how many of them succeeded or failed
+	Attempts           int `json:"attempts"`
+	SuccessfulAttempts int `json:"successful_attempts"`
+	FailedAttempts     int `json:"failed_attempts"`
+}
+
+// PeerEnrRecord is the latest eth_nodes row (ENR) of the peer.
+type PeerEnrRecord struct {
+	Timestamp       time.Time `json:"timestamp"`
+	NodeID          string    `json:"node_id"`
+	Seq             uint64    `json:"seq"`
+	IP              string    `json:"ip"`
+	TCP             int       `json:"tcp"`
+	UDP             int       `json:"udp"`
+	Pubkey          string    `json:"pubkey"`
+	ForkDigest      string    `json:"fork_digest"`
+	NextForkVersion string    `json:"next_fork_version"`
+	Attnets         string    `json:"attnets"`
+	AttnetsNumber   int       `json:"attnets_number"`
+	Enr             string    `json:"enr"`
+	ForeignNetwork  bool      `json:"foreign_network"`
+}
+
+// PeerStatusRecord is the eth_status row of the peer, with its latest beacon Status and MetaData.
+type PeerStatusRecord struct {
+	Timestamp           time.Time        `json:"timestamp"`
+	ForkDigest          string           `json:"fork_digest"`
+	FinalizedRoot       string           `json:"finalized_root"`
+	FinalizedEpoch      uint64           `json:"finalized_epoch"`
+	HeadRoot            string           `json:"head_root"`
+	HeadSlot            uint64           `json:"head_slot"`
+	SeqNumber           uint64           `json:"seq_number"`
+	Attnets             string           `json:"attnets"`
+	Syncnets            string           `json:"syncnets"`
+	AttnetsMismatch     bool             `json:"attnets_mismatch"`
+	StatusRequests      int64            `json:"status_requests"`
+	StatusSuccesses     int64            `json:"status_successes"`
+	StatusRequestErrors map[string]int64 `json:"status_request_errors"`
+}
+
+// PeerConnEventRecord is a conn_events row of the peer.
+type PeerConnEventRecord struct {
+	Direction        string     `json:"direction"`
+	ConnTime         *time.Time `json:"conn_time"` // nil for the disconnections without connection
+	LatencyMs        int64      `json:"latency_ms"`
+	DiscTime         *time.Time `json:"disconn_time"`  // nil for the open sessions and the timestamp anomalies
+	DurationSecs     *int64     `json:"duration_secs"` // nil unless the session finished
+	Identified       bool       `json:"identified"`
+	Error            string     `json:"error"`
+	TimestampAnomaly bool       `json:"timestamp_anomaly"`
+	GoodbyeReason    string     `json:"goodbye_reason"`
+}
+
+// PeerConnAttempts aggregates all the conn_events of the peer.
+type PeerConnAttempts struct {
+	Connections   int64     `json:"connections"`
+	Identified    int64     `json:"identified"`
+	Errors        int64     `json:"errors"`
+	FirstConnTime time.Time `json:"first_conn_time"`
+	LastConnTime  time.Time `json:"last_conn_time"`
+}
+
+// GetPeerFullRecord returns everything stored about the peer, or ErrPeerNotFound if it isn't in peer_info.
+func (c *DBClient) GetPeerFullRecord(pID peer.ID) (*PeerFullRecord, error) {
+	log.Tracef("reading full record of peer %s", pID.String())
+	record := &PeerFullRecord{
+		PeerID: pID.String(),
+	}
+	info, err := c.getPeerInfoRecord(pID)
+	if err != nil {
+		return nil, err
+	}
+	record.Info = *info
+
+	if info.IP != "" {
+		ipInfo, err := c.ReadIpInfo(info.IP)
+		switch {
+		case err == nil:
+			record.Location = &ipInfo
+		case !errors.Is(err, pgx.ErrNoRows):
+			return nil, errors.Wrap(err, "unable to read location of peer "+pID.String())
+		}
+	}
+	record.Enr, err = c.getPeerEnrRecord(pID)
+	if err != nil {
+		return nil, err
+	}
+	record.Status, err = c.getPeerStatusRecord(pID)
+	if err != nil {
+		return nil, err
+	}
+	history, err := c.GetMetadataHistory(pID)
+	if err != nil {
+		return nil, err
+	}
+	if len(history) > 0 {
+		record.MetadataHistory = history
+	}
+	record.ConnEvents, err = c.getPeerConnEvents(pID, PeerRecordConnEvents)
+	if err != nil {
+		return nil, err
+	}
+	record.ConnAttempts, err = c.getPeerConnAttempts(pID)
+	if err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+func (c *DBClient) getPeerInfoRecord(pID peer.ID) (*PeerInfoRecord, error) {
+	ctx, cancel := c.readCtx()
+	defer cancel()
+
+	info := &PeerInfoRecord{}
+	var firstActivity, lastActivity, lastConnAttempt, lastGoodbyeTime, latency int64
+	err := c.psqlPool.QueryRow(ctx, `
+		SELECT
+			network,
+			multi_addrs,
+			COALESCE(relay_addrs, '{}'),
+			COALESCE(relay_only, false),
+			ip,
+			COALESCE(port, 0),
+			COALESCE(user_agent, ''),
+			COALESCE(client_name, ''),
+			COALESCE(client_version, ''),
+			COALESCE(client_os, ''),
+			COALESCE(client_arch, ''),
+			COALESCE(peer_category, ''),
+			COALESCE(protocol_version, ''),
+			COALESCE(sup_protocols, '{}'),
+			COALESCE(latency, 0),
+			COALESCE(deprecated, false),
+			COALESCE(attempted, false),
+			COALESCE(first_activity, 0),
+			COALESCE(last_activity, 0),
+			COALESCE(last_conn_attempt, 0),
+			COALESCE(last_error, ''),
+			quality_score,
+			COALESCE(last_goodbye, ''),
+			COALESCE(last_goodbye_time, 0),
+			attempts,
+			successful_attempts,
+			failed_attempts
+		FROM peer_info
+		WHERE peer_id=$1 AND `+networkCond("network", 2)+`;
+		`, pID.String(), c.readNetwork()).Scan(
+		&info.Network,
+		&info.MultiAddrs,
+		&info.RelayAddrs,
+		&info.RelayOnly,
+		&info.IP,
+		&info.Port,
+		&info.UserAgent,
+		&info.ClientName,
+		&info.ClientVersion,
+		&info.ClientOS,
+		&info.ClientArch,
+		&info.PeerCategory,
+		&info.ProtocolVersion,
+		&info.Protocols,
+		&latency,
+		&info.Deprecated,
+		&info.Attempted,
+		&firstActivity,
+		&lastActivity,
+		&lastConnAttempt,
+		&info.LastError,
+		&info.QualityScore,
+		&info.LastGoodbye,
+		&lastGoodbyeTime,
+		&info.Attempts,
+		&info.SuccessfulAttempts,
+		&info.FailedAttempts,
+	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrPeerNotFound
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to read peer_info of peer "+pID.String())
+	}
+	info.LatencyMs = latency
+	info.FirstActivity = unixOrZero(firstActivity)
+	info.LastActivity = unixOrZero(lastActivity)
+	info.LastConnAttempt = unixOrZero(lastConnAttempt)
+	info.LastGoodbyeTime = unixOrZero(lastGoodbyeTime)
+	return info, nil
+}
+
+func (c *DBClient) getPeerEnrRecord(pID peer.ID) (*PeerEnrRecord, error) {
+	ctx, cancel := c.readCtx()
+	defer cancel()
+
+	enr := &PeerEnrRecord{}
+	var timestamp int64
+	err := c.psqlPool.QueryRow(ctx, `
+		SELECT
+			timestamp,
+			node_id,
+			seq,
+			ip,
+			COALESCE(tcp, 0),
+			COALESCE(udp, 0),
+			pubkey,
+			COALESCE(fork_digest, ''),
+			COALESCE(next_fork_version, ''),
+			COALESCE(attnets, ''),
+			COALESCE(attnets_number, 0),
+			COALESCE(enr, ''),
+			COALESCE(foreign_network, false)
+		FROM eth_nodes
+		WHERE peer_id=$1 AND `+networkCond("network", 2)+`
+		ORDER BY timestamp DESC
+		LIMIT 1;
+		`, pID.String(), c.readNetwork()).Scan(
+		&timestamp,
+		&enr.NodeID,
+		&enr.Seq,
+		&enr.IP,
+		&enr.TCP,
+		&enr.UDP,
+		&enr.Pubkey,
+		&enr.ForkDigest,
+		&enr.NextForkVersion,
+		&enr.Attnets,
+		&enr.AttnetsNumber,
+		&enr.Enr,
+		&enr.ForeignNetwork,
+	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to read eth_nodes of peer "+pID.String())
+	}
+	enr.Timestamp = time.Unix(timestamp, 0)
+	return enr, nil
+}
+
+func (c *DBClient) getPeerStatusRecord(pID peer.ID) (*PeerStatusRecord, error) {
+	ctx, cancel := c.readCtx()
+	defer cancel()
+
+	status := &PeerStatusRecord{}
+	var timestamp int64
+	var statusErrors []byte
+	err := c.psqlPool.QueryRow(ctx, `
+		SELECT
+			COALESCE(timestamp, 0),
+			COALESCE(fork_digest, ''),
+			COALESCE(finalized_root, ''),
+			COALESCE(finalized_epoch, 0),
+			COALESCE(head_root, ''),
+			COALESCE(head_slot, 0),
+			COALESCE(seq_number, 0),
+			COALESCE(attnets, ''),
+			COALESCE(syncnets, ''),
+			COALESCE(attnets_mismatch, false),
+			COALESCE(status_requests, 0),
+			COALESCE(status_successes, 0),
+			COALESCE(status_request_errors, '{}')::TEXT
+		FROM eth_status
+		WHERE peer_id=$1 AND `+networkCond("network", 2)+`;
+		`, pID.String(), c.readNetwork()).Scan(
+		&timestamp,
+		&status.ForkDigest,
+		&status.FinalizedRoot,
+		&status.FinalizedEpoch,
+		&status.HeadRoot,
+		&status.HeadSlot,
+		&status.SeqNumber,
+		&status.Attnets,
+		&status.Syncnets,
+		&status.AttnetsMismatch,
+		&status.StatusRequests,
+		&status.StatusSuccesses,
+		&statusErrors,
+	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to read eth_status of peer "+pID.String())
+	}
+	status.Timestamp = unixOrZero(timestamp)
+	status.StatusRequestErrors = make(map[string]int64)
+	err = json.Unmarshal(statusErrors, &status.StatusRequestErrors)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to parse status request errors of peer "+pID.String())
+	}
+	return status, nil
+}
+
+// getPeerConnEvents returns the latest conn_events of the peer (newest first), nil if it has none.
+func (c *DBClient) getPeerConnEvents(pID peer.ID, limit int) ([]PeerConnEventRecord, error) {
+	ctx, cancel := c.readCtx()
+	defer cancel()
+
+	rows, err := c.psqlPool.Query(ctx, `
+		SELECT
+			direction,
+			conn_time,
+			COALESCE(latency, 0),
+			disconn_time,
+			COALESCE(identified, false),
+			error,
+			COALESCE(timestamp_anomaly, false),
+			COALESCE(goodbye_reason, ''),
+			duration
+		FROM conn_events
+		WHERE peer_id=$1 AND `+networkCond("network", 3)+`
+		ORDER BY COALESCE(conn_time, disconn_time) DESC, id DESC
+		LIMIT $2;
+		`, pID.String(), limit, c.readNetwork())
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to read conn_events of peer "+pID.String())
+	}
+	// make sure we close the rows and we free the connection/session
+	defer rows.Close()
+
+	var events []PeerConnEventRecord
+	for rows.Next() {
+		var event PeerConnEventRecord
+		var connTime, discTime *int64
+		err = rows.Scan(
+			&event.Direction,
+			&connTime,
+			&event.LatencyMs,
+			&discTime,
+			&event.Identified,
+			&event.Error,
+			&event.TimestampAnomaly,
+			&event.GoodbyeReason,
+			&event.DurationSecs,
+		)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to parse conn_events of peer "+pID.String())
+		}
+		if connTime != nil {
+			t := time.Unix(*connTime, 0)
+			event.ConnTime = &t
+		}
+		if discTime != nil {
+			t := time.Unix(*discTime, 0)
+			event.DiscTime = &t
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+// getPeerConnAttempts aggregates all the conn_events of the peer, nil if it has none.
+func (c *DBClient) getPeerConnAttempts(pID peer.ID) (*PeerConnAttempts, error) {
+	ctx, cancel := c.readCtx()
+	defer cancel()
+
+	attempts := &PeerConnAttempts{}
+	var firstConn, lastConn int64
+	err := c.psqlPool.QueryRow(ctx, `
+		SELECT
+			count(*),
+			count(*) FILTER (WHERE identified),
+			count(*) FILTER (WHERE error <> '' and error <> 'None'),
+			COALESCE(min(conn_time), 0),
+			COALESCE(max(conn_time), 0)
+		FROM conn_events
+		WHERE peer_id=$1 AND `+networkCond("network", 2)+`;
+		`, pID.String(), c.readNetwork()).Scan(
+		&attempts.Connections,
+		&attempts.Identified,
+		&attempts.Errors,
+		&firstConn,
+		&lastConn,
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to aggregate conn_events of peer "+pID.String())
+	}
+	if attempts.Connections == 0 {
+		return nil, nil
+	}
+	attempts.FirstConnTime = time.Unix(firstConn, 0)
+	attempts.LastConnTime = time.Unix(lastConn, 0)
+	return attempts, nil
+}
+
+// unixOrZero returns the time of the unix timestamp, or the zero time for a 0 timestamp.
+func unixOrZero(timestamp int64) time.Time {
+	if timestamp == 0 {
+		return time.Time{}
+	}
+	return time.Unix(timestamp, 0)
+}
+
+// PeerRecordHandler serves the PeerFullRecord of the peer in the path (PeerRecordEndpoint + peer ID) as JSON.
+func (c *DBClient) PeerRecordHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		pID, err := peer.Decode(strings.TrimPrefix(r.URL.Path, PeerRecordEndpoint))
+		if err != nil {
+			http.Error(w, "invalid peer id", http.StatusBadRequest)
+			return
+		}
+		record, err := c.GetPeerFullRecord(pID)
+		if errors.Is(err, ErrPeerNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Error(errors.Wrap(err, "unable to serve peer record"))
+			http.Error(w, "unable to read peer record", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		err = json.NewEncoder(w).Encode(record)
+		if err != nil {
+			log.Debug(errors.Wrap(err, "unable to write peer record"))
+		}
+	})
+}
@@ -0,0 +1,148 @@
+package postgresql
+
+import (
+	"context"
+	"time"
+
+	pgx "github.com/jackc/pgx/v4"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/migalabs/armiarma/pkg/db/models"
+	"github.com/migalabs/armiarma/pkg/utils"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// hostInfoColumns are the columns of v_peer_overview read by scanHostInfo.
+const hostInfoColumns = `
+	peer_id,
+	network,
+	multi_addrs || COALESCE(relay_addrs, '{}'),
+	ip,
+	port,
+	user_agent,
+	protocol_version,
+	sup_protocols,
+	latency,
+	deprecated,
+	attempted,
+	last_activity,
+	last_conn_attempt,
+	last_error,
+	attempts,
+	successful_attempts`
+
+// scanHostInfo reads a row of hostInfoColumns into a HostInfo. Along with it, returns the error
+// of the multiaddresses that couldn't be parsed (the valid ones are kept), and whether the peer
+// had stored multiaddresses but none of them is valid anymore.
+func scanHostInfo(row pgx.Row) (hInfo *models.HostInfo, mAddrsErr error, unreachable bool, err error) {
+	var peerIDStr string
+	var network utils.NetworkType
+	var maddresses []string
+	var ip string
+	var port int
+	var latencyMillis int64
+	var lastActivity int64
+	var lastConnAttempt int64
+	pInfo := models.NewEmptyPeerInfo()
+	cInfo := models.NewControlInfo()
+
+	err = row.Scan(
+		&peerIDStr,
+		&network,
+		&maddresses,
+		&ip,
+		&port,
+		&pInfo.UserAgent,
+		&pInfo.ProtocolVersion,
+		&pInfo.Protocols,
+		&latencyMillis,
+		&cInfo.Deprecated,
+		&cInfo.Attempted,
+		&lastActivity,
+		&lastConnAttempt,
+		&cInfo.LastError,
+		&cInfo.Attempts,
+		&cInfo.SuccessfulAttempts,
+	)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	pID, err := peer.Decode(peerIDStr)
+	if err != nil {
+		return nil, nil, false, errors.Wrap(err, "unable to decode peer id "+peerIDStr)
+	}
+
+	// parse the multiaddresses from the []string
+	mAddrs, _, mAddrsErr := utils.ParseCanonicalMAddrs(maddresses)
+	unreachable = len(maddresses) > 0 && len(mAddrs) == 0
+
+	hInfo = models.NewHostInfo(pID, network)
+	hInfo.IP = ip
+	hInfo.Port = port
+	// parse times from received Unix() timestamps
+	cInfo.LastActivity = time.Unix(lastActivity, int64(0))
+	cInfo.LastConnAttempt = time.Unix(lastConnAttempt, int64(0))
+	cInfo.RemotePeer = pID
+	// parse latency in millisecods
+	pInfo.Latency = time.Duration(latencyMillis) * time.Millisecond
+	pInfo.RemotePeer = pID
+
+	hInfo.SetMAddrs(mAddrs)
+	hInfo.PeerInfo = *pInfo
+	hInfo.ControlInfo = *cInfo
+	return hInfo, mAddrsErr, unreachable, nil
+}
+
+// GetPeerInfo returns the identification of the peer stored in peer_info.
+func (c *DBClient) GetPeerInfo(pID peer.ID) (*models.PeerInfo, error) {
+	hInfo, err := c.GetFullHostInfo(pID)
+	if err != nil {
+		return nil, err
+	}
+	return &hInfo.PeerInfo, nil
+}
+
+// ForEachHostInfo streams the full HostInfo (multiaddresses, identification and control info,
+// including the last activity) of the peers that are not deprecated, calling fn for each of them,
+// to resume a crawl from an existing peer_info table. The peers whose stored multiaddresses
+// don't parse anymore are skipped with a warning. Returns the number of read peers.
+func (c *DBClient) ForEachHostInfo(ctx context.Context, fn func(*models.HostInfo) error) (int64, error) {
+	log.Trace("streaming the host info of the peers that are not deprecated")
+
+	skipped := 0
+	total, err := c.StreamQuery(ctx, func(rows pgx.Rows) error {
+		hInfo, mAddrsErr, unreachable, err := scanHostInfo(rows)
+		if err != nil {
+			return err
+		}
+		if unreachable {
+			skipped++
+			log.Warn(errors.Wrap(mAddrsErr, "skipping peer "+hInfo.ID.String()+" without valid multiaddresses"))
+			return nil
+		}
+		if mAddrsErr != nil {
+			log.Warn(errors.Wrap(mAddrsErr, "dropping invalid multiaddress of peer "+hInfo.ID.String()))
+		}
+		return fn(hInfo)
+	}, `SELECT`+hostInfoColumns+`
+		FROM v_peer_overview
+		WHERE deprecated='false' AND `+networkCond("network", 1), c.readNetwork())
+	if skipped > 0 {
+		log.Warnf("skipped %d peers without valid multiaddresses", skipped)
+	}
+	if err != nil {
+		return total, errors.Wrap(err, "unable to stream the host info of the peers")
+	}
+	return total, nil
+}
+
+// GetPersistablePeers returns the full HostInfo of the peers that are not deprecated.
+// Prefer ForEachHostInfo, which doesn't hold all of them in memory.
+func (c *DBClient) GetPersistablePeers(ctx context.Context) ([]*models.HostInfo, error) {
+	hInfos := make([]*models.HostInfo, 0)
+	_, err := c.ForEachHostInfo(ctx, func(hInfo *models.HostInfo) error {
+		hInfos = append(hInfos, hInfo)
+		return nil
+	})
+	return hInfos, err
+}
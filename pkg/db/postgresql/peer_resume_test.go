@@ -0,0 +1,125 @@
+package postgresql
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/migalabs/armiarma/pkg/db/models"
+	"github.com/migalabs/armiarma/pkg/utils"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeRow is a pgx.Row of hostInfoColumns with the given values.
+type fakeRow []interface{}
+
+func (r fakeRow) Scan(dest ...interface{}) error {
+	for i, d := range dest {
+		reflect.ValueOf(d).Elem().Set(reflect.ValueOf(r[i]))
+	}
+	return nil
+}
+
+func hostInfoRow(peerID string, mAddrs []string) fakeRow {
+	return fakeRow{
+		peerID,
+		utils.EthereumNetwork,
+		mAddrs,
+		"95.217.33.10",
+		9000,
+		"Lighthouse/v2.3.1-1a2b3c4/x86_64-linux",
+		"eth2/1.0.0",
+		[]string{"/meshsub/1.1.0"},
+		int64(42),
+		false,
+		true,
+		int64(1654084800),
+		int64(1654088400),
+		"io_timeout",
+		3,
+		1,
+	}
+}
+
+func TestScanHostInfo(t *testing.T) {
+	peerStr := "16Uiu2HAm9bv5TSrR1VzzhyBrGFSYc9nk6ebifkoBYEBgUFMGqKq5"
+	hInfo, mAddrsErr, unreachable, err := scanHostInfo(hostInfoRow(peerStr, []string{
+		"/ip4/95.217.33.10/tcp/9000",
+		"/ip4/95.217.33.10/tcp/not-a-port",
+	}))
+	require.NoError(t, err)
+	// the invalid multiaddress is dropped, the peer is kept
+	require.Error(t, mAddrsErr)
+	require.False(t, unreachable)
+	require.Equal(t, peerStr, hInfo.ID.String())
+	require.Equal(t, utils.EthereumNetwork, hInfo.Network)
+	require.Len(t, hInfo.MAddrs, 1)
+	require.Equal(t, "95.217.33.10", hInfo.IP)
+	require.Equal(t, 9000, hInfo.Port)
+	require.Equal(t, hInfo.ID, hInfo.PeerInfo.RemotePeer)
+	require.Equal(t, 42*time.Millisecond, hInfo.PeerInfo.Latency)
+	require.Equal(t, time.Unix(1654084800, 0), hInfo.ControlInfo.LastActivity)
+	require.Equal(t, time.Unix(1654088400, 0), hInfo.ControlInfo.LastConnAttempt)
+	require.Equal(t, 3, hInfo.ControlInfo.Attempts)
+	require.Equal(t, 1, hInfo.ControlInfo.SuccessfulAttempts)
+
+	// without any valid multiaddress it can't be dialed anymore
+	_, mAddrsErr, unreachable, err = scanHostInfo(hostInfoRow(peerStr, []string{"/ip4/95.217.33.10/tcp/not-a-port"}))
+	require.NoError(t, err)
+	require.Error(t, mAddrsErr)
+	require.True(t, unreachable)
+
+	// but the peers that never had them are fine
+	_, mAddrsErr, unreachable, err = scanHostInfo(hostInfoRow(peerStr, []string{}))
+	require.NoError(t, err)
+	require.NoError(t, mAddrsErr)
+	require.False(t, unreachable)
+
+	_, _, _, err = scanHostInfo(hostInfoRow("not-a-peer-id", []string{}))
+	require.Error(t, err)
+}
+
+func TestResumePeersInPSQL(t *testing.T) {
+	network := utils.EthereumNetwork
+	dbCli, err := NewDBClient(context.Background(), network, loginStr, 24*time.Hour, WithReset())
+	require.NoError(t, err)
+	defer dbCli.Close()
+
+	peers := []string{
+		"12D3KooWLRPJAA5o6m3ZQbJsu9EVEFvLx2ke4cSg8LxpwYXmsd3d",
+		"16Uiu2HAm9bv5TSrR1VzzhyBrGFSYc9nk6ebifkoBYEBgUFMGqKq5",
+		"16Uiu2HAmPEupv8BZVCv8i7qH4Jf8Ni6gQaXK1c6XtTcnz7idfxn8",
+	}
+	for _, peerStr := range peers {
+		host := genNewTestHostInfo(t, network, peerStr, "95.217.33.10", 9000)
+		host.PeerInfo = *models.NewPeerInfo(host.ID, "Lighthouse/v2.3.1-1a2b3c4/x86_64-linux", "eth2/1.0.0", []string{}, 42*time.Millisecond)
+		q, args := dbCli.UpsertHostInfo(host)
+		_, err = dbCli.SingleQuery(q, args...)
+		require.NoError(t, err)
+	}
+	// the multiaddress of the last peer doesn't parse anymore
+	_, err = dbCli.psqlPool.Exec(dbCli.ctx, `
+		UPDATE peer_info SET multi_addrs = '{"/ip4/95.217.33.10/tcp/not-a-port"}' WHERE peer_id = $1;`, peers[2])
+	require.NoError(t, err)
+
+	hInfos, err := dbCli.GetPersistablePeers(context.Background())
+	require.NoError(t, err)
+	require.Len(t, hInfos, 2)
+	for _, hInfo := range hInfos {
+		require.NotEqual(t, peers[2], hInfo.ID.String())
+		require.Len(t, hInfo.MAddrs, 1)
+		require.True(t, hInfo.PeerInfo.IsPeerIdentified())
+	}
+
+	limited, err := dbCli.GetNonDeprecatedPeers(1)
+	require.NoError(t, err)
+	require.Len(t, limited, 1)
+	all, err := dbCli.GetNonDeprecatedPeers(0)
+	require.NoError(t, err)
+	require.Len(t, all, 2)
+
+	pInfo, err := dbCli.GetPeerInfo(hInfos[0].ID)
+	require.NoError(t, err)
+	require.Equal(t, "Lighthouse/v2.3.1-1a2b3c4/x86_64-linux", pInfo.UserAgent)
+}
@@ -0,0 +1,139 @@
+package postgresql
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/csv"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/migalabs/armiarma/pkg/db/models"
+	"github.com/migalabs/armiarma/pkg/metrics"
+	"github.com/migalabs/armiarma/pkg/utils"
+	"github.com/stretchr/testify/require"
+)
+
+// newSyncedPeer returns an in-memory peer with every field that gets persisted into peer_info,
+// and its row of the CSV export by column.
+func newSyncedPeer(t *testing.T) (*metrics.Peer, map[string]string) {
+	_, pubKey, err := crypto.GenerateEd25519Key(rand.Reader)
+	require.NoError(t, err)
+	pID, err := peer.IDFromPublicKey(pubKey)
+	require.NoError(t, err)
+
+	t0 := time.Now().Add(-time.Hour)
+	p := metrics.NewPeer(pID)
+	hInfo := models.NewHostInfo(pID, utils.EthereumNetwork, models.WithIPAndPorts("95.217.33.10", 9000))
+	hInfo.PeerInfo = *models.NewPeerInfo(pID, "Lighthouse/v2.3.1-1a2b3c4/x86_64-linux", "eth2/1.0.0", []string{"/meshsub/1.1.0"}, 42*time.Millisecond)
+	p.FetchHostInfo(hInfo)
+	p.ConnectionAttemptEvent(true, "")
+	p.ConnectionAttemptEvent(false, "io_timeout")
+	p.ConnectionEvent(t0)
+	p.DisconnectionEvent(t0.Add(30 * time.Minute))
+	p.MessageEvent(metrics.BeaconBlockTopicName, t0.Add(time.Minute))
+
+	record, err := csv.NewReader(strings.NewReader(p.ToCsvLine())).Read()
+	require.NoError(t, err)
+	require.Len(t, record, len(metrics.PeerCsvHeader))
+	columns := make(map[string]string, len(record))
+	for i, header := range metrics.PeerCsvHeader {
+		columns[header] = record[i]
+	}
+	return p, columns
+}
+
+func TestPeerSyncMatchesCsv(t *testing.T) {
+	dbCli := &DBClient{Network: utils.EthereumNetwork}
+	p, csvRow := newSyncedPeer(t)
+	hInfo := p.ToHostInfo(metrics.DefaultQualityWeights, time.Now())
+
+	_, args := dbCli.UpsertHostInfo(hInfo)
+	require.Equal(t, csvRow["peer_id"], args[0])
+	require.Equal(t, csvRow["network"], args[1])
+	require.Equal(t, []string{"/ip4/95.217.33.10/tcp/9000"}, args[2])
+	require.Equal(t, csvRow["relay_only"], strconv.FormatBool(args[4].(bool)))
+	require.Equal(t, csvRow["ip"], args[5])
+	require.Equal(t, 9000, args[6])
+	require.Equal(t, false, args[7])
+	score, err := strconv.ParseFloat(csvRow["quality_score"], 64)
+	require.NoError(t, err)
+	require.InDelta(t, score, *args[8].(*float64), 0.01)
+
+	_, args = dbCli.UpdatePeerInfo(&hInfo.PeerInfo)
+	require.Equal(t, csvRow["user_agent"], args[1])
+	require.Equal(t, csvRow["client_name"], args[2])
+	require.Equal(t, csvRow["client_version"], args[3])
+	require.Equal(t, csvRow["client_os"], args[4])
+	require.Equal(t, csvRow["client_arch"], args[5])
+	require.Equal(t, csvRow["latency_ms"], strconv.FormatInt(args[8].(int64), 10))
+	require.Equal(t, csvRow["peer_category"], args[9])
+
+	_, args = dbCli.UpdateAttemptAggregates(hInfo.ID, hInfo.ControlInfo)
+	require.Equal(t, csvRow["attempts"], strconv.Itoa(args[1].(int)))
+	require.Equal(t, 1, args[2])
+	require.Equal(t, 1, args[3])
+	require.Equal(t, csvRow["last_error"], args[5])
+
+	_, args = dbCli.UpdateDistinctTopics(hInfo.ID, hInfo.Attr[models.DistinctTopicsAttribute].(models.DistinctTopics))
+	require.Equal(t, csvRow["distinct_topics"], strconv.Itoa(args[1].(int)))
+}
+
+func TestPeerSyncInPSQL(t *testing.T) {
+	dbCli, err := NewDBClient(context.Background(), utils.EthereumNetwork, loginStr, 24*time.Hour, WithReset())
+	require.NoError(t, err)
+	defer dbCli.Close()
+
+	store := metrics.NewPeerStore()
+	p, csvRow := newSyncedPeer(t)
+	store.GetOrCreatePeer(p.ID).Merge(p)
+	syncer := metrics.NewPeerSyncer(context.Background(), store, dbCli, time.Hour)
+	syncer.Start()
+	syncer.Close()
+
+	type peerInfoRow struct {
+		network, userAgent, clientName, clientVersion, ip, lastError string
+		relayOnly, attempted                                         bool
+		latency, attempts, distinctTopics                            int64
+		qualityScore                                                 float64
+	}
+	var row peerInfoRow
+	require.Eventually(t, func() bool {
+		err := dbCli.psqlPool.QueryRow(dbCli.ctx, `
+			SELECT network, user_agent, client_name, client_version, ip, last_error, relay_only, attempted,
+				latency, attempts, COALESCE(distinct_topics, 0), COALESCE(quality_score, 0)
+			FROM peer_info
+			WHERE peer_id = $1 AND attempts > 0;
+		`, p.ID.String()).Scan(&row.network, &row.userAgent, &row.clientName, &row.clientVersion, &row.ip, &row.lastError,
+			&row.relayOnly, &row.attempted, &row.latency, &row.attempts, &row.distinctTopics, &row.qualityScore)
+		return err == nil
+	}, 10*time.Second, 100*time.Millisecond)
+
+	require.Equal(t, csvRow["network"], row.network)
+	require.Equal(t, csvRow["user_agent"], row.userAgent)
+	require.Equal(t, csvRow["client_name"], row.clientName)
+	require.Equal(t, csvRow["client_version"], row.clientVersion)
+	require.Equal(t, csvRow["ip"], row.ip)
+	require.Equal(t, csvRow["last_error"], row.lastError)
+	require.Equal(t, csvRow["relay_only"], strconv.FormatBool(row.relayOnly))
+	require.Equal(t, csvRow["attempted"], strconv.FormatBool(row.attempted))
+	require.Equal(t, csvRow["latency_ms"], strconv.FormatInt(row.latency, 10))
+	require.Equal(t, csvRow["attempts"], strconv.FormatInt(row.attempts, 10))
+	require.Equal(t, csvRow["distinct_topics"], strconv.FormatInt(row.distinctTopics, 10))
+	score, err := strconv.ParseFloat(csvRow["quality_score"], 64)
+	require.NoError(t, err)
+	require.InDelta(t, score, row.qualityScore, 0.01)
+
+	// and the peer resumes from the DB with the same attempts
+	hInfo, err := dbCli.GetFullHostInfo(p.ID)
+	require.NoError(t, err)
+	resumed := metrics.PeerFromHostInfo(hInfo)
+	require.Equal(t, p.ID, resumed.ID)
+	require.Equal(t, 2, resumed.Attempts)
+	require.Equal(t, 1, resumed.SuccessfulAttempts)
+	require.Equal(t, "io_timeout", resumed.LastError)
+	require.Equal(t, csvRow["client_name"], resumed.ClientName)
+}
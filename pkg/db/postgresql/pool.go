@@ -0,0 +1,108 @@
+package postgresql
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// PoolConfig is the sizing of the pgx pool of connections of the DBClient.
+// The zero durations keep the defaults of pgx.
+type PoolConfig struct {
+	MaxConns          int32
+	MinConns          int32
+	MaxConnLifetime   time.Duration
+	MaxConnIdleTime   time.Duration
+	HealthCheckPeriod time.Duration
+}
+
+// DefaultPoolConfig keeps a single connection, shared by the persisters and the reads.
+var DefaultPoolConfig = PoolConfig{
+	MaxConns: 1,
+	MinConns: 0,
+}
+
+// apply sets the pool config into the pgxpool.Config parsed from the login string.
+func (p PoolConfig) apply(pgxConf *pgxpool.Config) {
+	pgxConf.MaxConns = p.MaxConns
+	pgxConf.MinConns = p.MinConns
+	if p.MaxConnLifetime > 0 {
+		pgxConf.MaxConnLifetime = p.MaxConnLifetime
+	}
+	if p.MaxConnIdleTime > 0 {
+		pgxConf.MaxConnIdleTime = p.MaxConnIdleTime
+	}
+	if p.HealthCheckPeriod > 0 {
+		pgxConf.HealthCheckPeriod = p.HealthCheckPeriod
+	}
+}
+
+// DBStats are the state of the pgx pool and the throughput of the persisters of the DBClient.
+type DBStats struct {
+	// pgx pool (see pgxpool.Stat)
+	MaxConns             int32         `json:"max_conns"`
+	TotalConns           int32         `json:"total_conns"`
+	IdleConns            int32         `json:"idle_conns"`
+	AcquiredConns        int32         `json:"acquired_conns"`
+	ConstructingConns    int32         `json:"constructing_conns"`
+	AcquireCount         int64         `json:"acquire_count"`
+	AcquireDuration      time.Duration `json:"acquire_duration"`
+	EmptyAcquireCount    int64         `json:"empty_acquire_count"`
+	CanceledAcquireCount int64         `json:"canceled_acquire_count"`
+
+	// persisters
+	Uptime           time.Duration `json:"uptime"`
+	QueueDepth       int           `json:"queue_depth"`
+	PersistedItems   int64         `json:"persisted_items"`
+	PersistedQueries int64         `json:"persisted_queries"`
+	PersistedBatches int64         `json:"persisted_batches"`
+	BatchErrors      int64         `json:"batch_errors"`
+	EmptyHostInfos   int64         `json:"empty_host_infos"`
+	DroppedItems     int64         `json:"dropped_items"`
+	AvgBatchSize     float64       `json:"avg_batch_size"`
+	LastFlush        time.Time     `json:"last_flush"` // zero until a batch got persisted
+	// statements of the persisters per table (see statementTable)
+	Tables map[string]TableStats `json:"tables"`
+}
+
+// ItemsPerSecond returns the average number of items persisted per second since the start.
+func (s DBStats) ItemsPerSecond() float64 {
+	if s.Uptime <= 0 {
+		return 0
+	}
+	return float64(s.PersistedItems) / s.Uptime.Seconds()
+}
+
+// Stats returns the current state of the pgx pool and the persisters.
+func (c *DBClient) Stats() DBStats {
+	stats := DBStats{
+		Uptime:           time.Since(c.startTime),
+		QueueDepth:       c.PersisterQueueDepth(),
+		PersistedItems:   atomic.LoadInt64(&c.persistedItems),
+		PersistedQueries: atomic.LoadInt64(&c.persistedQueries),
+		PersistedBatches: atomic.LoadInt64(&c.persistedBatches),
+		BatchErrors:      c.BatchErrors(),
+		EmptyHostInfos:   c.EmptyHostInfos(),
+		DroppedItems:     c.DroppedItems(),
+		LastFlush:        c.stats.lastFlushTime(),
+		Tables:           c.stats.snapshot(),
+	}
+	if stats.PersistedBatches > 0 {
+		stats.AvgBatchSize = float64(stats.PersistedQueries) / float64(stats.PersistedBatches)
+	}
+	// the pool is only there once connected
+	if c.psqlPool != nil {
+		poolStat := c.psqlPool.Stat()
+		stats.MaxConns = poolStat.MaxConns()
+		stats.TotalConns = poolStat.TotalConns()
+		stats.IdleConns = poolStat.IdleConns()
+		stats.AcquiredConns = poolStat.AcquiredConns()
+		stats.ConstructingConns = poolStat.ConstructingConns()
+		stats.AcquireCount = poolStat.AcquireCount()
+		stats.AcquireDuration = poolStat.AcquireDuration()
+		stats.EmptyAcquireCount = poolStat.EmptyAcquireCount()
+		stats.CanceledAcquireCount = poolStat.CanceledAcquireCount()
+	}
+	return stats
+}
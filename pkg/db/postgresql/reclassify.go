@@ -0,0 +1,205 @@
+package postgresql
+
+import (
+	"context"
+	"sort"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/migalabs/armiarma/pkg/utils"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// number of user agents updated in each transaction of ReclassifyUserAgents
+var ReclassifyBatchSize = 500
+
+// agentClassification is the client parsed out of a user agent, as stored in peer_info.
+type agentClassification struct {
+	userAgent     string
+	clientName    string
+	clientVersion string
+	clientOS      string
+	clientArch    string
+	peerCategory  string
+	// confidence of the client name (see utils.ParseClient)
+	clientConfidence float64
+}
+
+// reclassify runs the user agent through the current parser, returning the new classification
+// and whether it differs from the stored one.
+func reclassify(network utils.NetworkType, stored agentClassification) (agentClassification, bool) {
+	current := agentClassification{userAgent: stored.userAgent}
+	cliInfo := utils.ParseClient(network, stored.userAgent)
+	current.clientName, current.clientVersion, current.clientOS, current.clientArch =
+		cliInfo.Name, cliInfo.Version, cliInfo.OS, cliInfo.Arch
+	current.clientConfidence = float64(float32(cliInfo.Confidence))
+	current.peerCategory = string(utils.ParsePeerCategory(current.clientName))
+	return current, current != stored
+}
+
+// ReclassifyUserAgents parses again the user agents stored in peer_info with the current parser,
+// updating the client name, version, OS, arch and category of the peers whose classification changed
+// (i.e. clients that were unknown when the peers were identified). Returns the number of updated rows.
+func (c *DBClient) ReclassifyUserAgents() (changed int64, err error) {
+	byClient, err := c.reclassifyUserAgents()
+	fields := log.Fields{}
+	for client, rows := range byClient {
+		fields[client] = rows
+		changed += rows
+	}
+	log.WithFields(fields).Infof("reclassified %d peers", changed)
+	return changed, err
+}
+
+// reclassifyUserAgents returns the number of updated rows per new client name.
+func (c *DBClient) reclassifyUserAgents() (map[string]int64, error) {
+	if c.readOnly {
+		return nil, ErrReadOnly
+	}
+	changes, err := c.getAgentReclassifications()
+	if err != nil {
+		return nil, err
+	}
+	log.Debugf("%d user agents changed their classification", len(changes))
+
+	byClient := make(map[string]int64)
+	for start := 0; start < len(changes); start += ReclassifyBatchSize {
+		end := start + ReclassifyBatchSize
+		if end > len(changes) {
+			end = len(changes)
+		}
+		err = c.updateAgentClassifications(changes[start:end], byClient)
+		if err != nil {
+			return byClient, err
+		}
+	}
+	return byClient, nil
+}
+
+// getAgentReclassifications streams the distinct classifications stored in peer_info,
+// returning the ones that the current parser classifies differently (sorted by user agent).
+func (c *DBClient) getAgentReclassifications() ([]agentClassification, error) {
+	ctx, cancel := c.readCtx()
+	defer cancel()
+	log.Debug("fetching the distinct user agents of peer_info")
+
+	rows, err := c.psqlPool.Query(ctx, `
+		SELECT DISTINCT
+			user_agent,
+			COALESCE(client_name, ''),
+			COALESCE(client_version, ''),
+			COALESCE(client_os, ''),
+			COALESCE(client_arch, ''),
+			COALESCE(peer_category, ''),
+			COALESCE(client_confidence, 0)
+		FROM peer_info
+		WHERE user_agent IS NOT NULL AND user_agent <> '' AND network = $1;
+		`, string(c.Network))
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to fetch the user agents")
+	}
+	// make sure we close the rows and we free the connection/session
+	defer rows.Close()
+
+	// the same user agent can be stored with different classifications (one per parser version)
+	changes := make(map[string]agentClassification)
+	for rows.Next() {
+		var stored agentClassification
+		err = rows.Scan(
+			&stored.userAgent,
+			&stored.clientName,
+			&stored.clientVersion,
+			&stored.clientOS,
+			&stored.clientArch,
+			&stored.peerCategory,
+			&stored.clientConfidence,
+		)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to parse fetched user agent")
+		}
+		if current, ok := reclassify(c.Network, stored); ok {
+			changes[current.userAgent] = current
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "unable to fetch the user agents")
+	}
+	sorted := make([]agentClassification, 0, len(changes))
+	for _, change := range changes {
+		sorted = append(sorted, change)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].userAgent < sorted[j].userAgent })
+	return sorted, nil
+}
+
+// updateAgentClassifications updates the peers of the user agents in a single transaction,
+// adding the updated rows to the count of their new client.
+func (c *DBClient) updateAgentClassifications(changes []agentClassification, byClient map[string]int64) error {
+	ctx, cancel := context.WithTimeout(c.ctx, c.batchTimeout)
+	defer cancel()
+
+	tx, err := c.psqlPool.Begin(ctx)
+	if err != nil {
+		return errors.Wrap(err, "unable to begin the reclassification")
+	}
+	// no-op once committed
+	defer tx.Rollback(context.Background())
+
+	batch := &pgx.Batch{}
+	for _, change := range changes {
+		batch.Queue(updateAgentClassificationQuery(c.Network, change))
+	}
+	results := tx.SendBatch(ctx, batch)
+	counts := make([]int64, len(changes))
+	for i, change := range changes {
+		tag, err := results.Exec()
+		if err != nil {
+			results.Close()
+			return errors.Wrap(err, "unable to reclassify user agent "+change.userAgent)
+		}
+		counts[i] = tag.RowsAffected()
+	}
+	err = results.Close()
+	if err != nil {
+		return errors.Wrap(err, "unable to reclassify user agents")
+	}
+	err = tx.Commit(ctx)
+	if err != nil {
+		return errors.Wrap(err, "unable to commit the reclassification")
+	}
+	for i, change := range changes {
+		byClient[change.clientName] += counts[i]
+	}
+	return nil
+}
+
+// updateAgentClassificationQuery composes the update of the peers of the network and user agent
+// whose classification differs from the given one.
+func updateAgentClassificationQuery(network utils.NetworkType, change agentClassification) (query string, args []interface{}) {
+	query = `
+		UPDATE peer_info
+		SET
+			client_name=$2,
+			client_version=$3,
+			client_os=$4,
+			client_arch=$5,
+			peer_category=$6,
+			client_confidence=$7
+		WHERE user_agent=$1 AND network=$8 AND (
+			client_name IS DISTINCT FROM $2 OR
+			client_version IS DISTINCT FROM $3 OR
+			client_os IS DISTINCT FROM $4 OR
+			client_arch IS DISTINCT FROM $5 OR
+			peer_category IS DISTINCT FROM $6 OR
+			client_confidence IS DISTINCT FROM $7);
+		`
+	args = append(args, change.userAgent)
+	args = append(args, change.clientName)
+	args = append(args, change.clientVersion)
+	args = append(args, change.clientOS)
+	args = append(args, change.clientArch)
+	args = append(args, change.peerCategory)
+	args = append(args, float32(change.clientConfidence))
+	args = append(args, string(network))
+	return query, args
+}
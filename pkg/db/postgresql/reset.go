@@ -0,0 +1,61 @@
+package postgresql
+
+import (
+	"context"
+
+	"github.com/migalabs/armiarma/pkg/utils"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrResetNotAllowed is returned by ResetTables when the client wasn't created WithReset.
+var ErrResetNotAllowed = errors.New("reset of the tables not allowed (see WithReset)")
+
+// crawlViews are the views on top of the crawl tables, in dropping order.
+var crawlViews = []string{
+	"v_daily_sessions",
+	"v_client_diversity",
+	"v_peer_overview",
+}
+
+// ResetTables drops all the crawl views and tables (with their indexes and sequences), and creates
+// them again empty, at the latest schema version. The tables of every network are dropped, so a DB
+// shared with other crawls is left clean as well. It is only allowed for the clients created WithReset, as it deletes
+// all the crawled data.
+func (c *DBClient) ResetTables() error {
+	if !c.allowReset {
+		return ErrResetNotAllowed
+	}
+	log.Warn("resetting all the crawl tables of the psql-db")
+
+	tx, err := c.psqlPool.Begin(c.ctx)
+	if err != nil {
+		return errors.Wrap(err, "unable to begin the reset of the tables")
+	}
+	defer tx.Rollback(context.Background())
+
+	// the views go first, as they depend on the tables
+	for _, view := range crawlViews {
+		_, err = tx.Exec(c.ctx, `DROP VIEW IF EXISTS `+view+`;`)
+		if err != nil {
+			return errors.Wrap(err, "unable to drop view "+view)
+		}
+	}
+	// the tables in the reverse restoring order, dependants first
+	tables := backupTables(utils.EthereumNetwork)
+	for i := len(tables) - 1; i >= 0; i-- {
+		_, err = tx.Exec(c.ctx, `DROP TABLE IF EXISTS `+tables[i].name+`;`)
+		if err != nil {
+			return errors.Wrap(err, "unable to drop table "+tables[i].name)
+		}
+	}
+	_, err = tx.Exec(c.ctx, `DROP TABLE IF EXISTS schema_version;`)
+	if err != nil {
+		return errors.Wrap(err, "unable to drop table schema_version")
+	}
+	if err = tx.Commit(c.ctx); err != nil {
+		return errors.Wrap(err, "unable to commit the reset of the tables")
+	}
+
+	return c.Migrate(c.ctx)
+}
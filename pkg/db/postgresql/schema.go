@@ -0,0 +1,88 @@
+package postgresql
+
+import (
+	"context"
+	"regexp"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/pkg/errors"
+)
+
+const (
+	// DefaultSchema is the Postgres schema of the crawl tables unless WithSchema is given
+	DefaultSchema = "public"
+	// maxSchemaLen keeps the per-schema NewPeerChannel within the identifier length of Postgres (63)
+	maxSchemaLen = 63 - len(NewPeerChannel) - 1
+)
+
+// only plain lowercase identifiers, so that they never need any escaping beyond the quotes
+var schemaNameRegex = regexp.MustCompile(`^[a-z_][a-z0-9_]*$`)
+
+// WithSchema keeps all the tables, views and indexes of the client in a dedicated Postgres schema
+// (created if needed), so that several crawlers can share the same database. Every connection of the
+// client resolves the unqualified names of the queries in it (see applySchema), and the new peer
+// notifications get their own channel.
+func WithSchema(name string) DBOption {
+	return func(dbCli *DBClient) error {
+		if !schemaNameRegex.MatchString(name) || len(name) > maxSchemaLen {
+			return errors.Errorf("invalid db schema %q, it has to match %s with up to %d chars",
+				name, schemaNameRegex.String(), maxSchemaLen)
+		}
+		dbCli.schema = name
+		return nil
+	}
+}
+
+// Schema returns the Postgres schema of the crawl tables.
+func (c *DBClient) Schema() string {
+	if c.schema == "" {
+		return DefaultSchema
+	}
+	return c.schema
+}
+
+// qualify returns the schema-qualified (and quoted) name of a table, view or index of the client.
+func (c *DBClient) qualify(name string) string {
+	return pgx.Identifier{c.Schema(), name}.Sanitize()
+}
+
+// applySchema makes the connections of the given runtime params resolve the unqualified names
+// of the queries in the schema of the client. This is the single place where the queries get
+// schema-qualified: the public schema keeps the default search_path of the server.
+func (c *DBClient) applySchema(runtimeParams map[string]string) {
+	if c.Schema() == DefaultSchema {
+		return
+	}
+	runtimeParams["search_path"] = pgx.Identifier{c.Schema()}.Sanitize()
+}
+
+// connect opens a dedicated connection (outside the pool) within the schema of the client.
+func (c *DBClient) connect(ctx context.Context) (*pgx.Conn, error) {
+	connConf, err := pgx.ParseConfig(c.loginStr)
+	if err != nil {
+		return nil, err
+	}
+	c.applySchema(connConf.RuntimeParams)
+	return pgx.ConnectConfig(ctx, connConf)
+}
+
+// createSchema creates the schema of the client if it doesn't exist yet.
+func (c *DBClient) createSchema() error {
+	if c.Schema() == DefaultSchema {
+		return nil
+	}
+	_, err := c.psqlPool.Exec(c.ctx, `CREATE SCHEMA IF NOT EXISTS `+pgx.Identifier{c.Schema()}.Sanitize()+`;`)
+	if err != nil {
+		return errors.Wrap(err, "unable to create db schema "+c.Schema())
+	}
+	return nil
+}
+
+// newPeerChannel returns the channel of the new peer notifications of the schema of the client,
+// as the notifications are shared by the whole database.
+func (c *DBClient) newPeerChannel() string {
+	if c.Schema() == DefaultSchema {
+		return NewPeerChannel
+	}
+	return NewPeerChannel + "_" + c.Schema()
+}
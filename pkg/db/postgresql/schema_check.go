@@ -0,0 +1,323 @@
+package postgresql
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/migalabs/armiarma/pkg/utils"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// The expected schema is composed by recording the statements of initTables instead of
+// running them, so it always matches what the init functions create.
+
+var (
+	createTableRe = regexp.MustCompile(`(?is)^\s*CREATE TABLE IF NOT EXISTS\s+(\w+)\s*\((.*)\)\s*;?\s*$`)
+	alterTableRe  = regexp.MustCompile(`(?is)^\s*ALTER TABLE\s+(\w+)\s+(.*?)\s*;?\s*$`)
+	createViewRe  = regexp.MustCompile(`(?is)^\s*CREATE OR REPLACE VIEW\s+(\w+)\s+AS\s`)
+	createIndexRe = regexp.MustCompile(`(?is)^\s*CREATE (?:UNIQUE\s+)?INDEX IF NOT EXISTS\s+\w+\s+ON\s+(\w+)\s*\(`)
+	dropIndexRe   = regexp.MustCompile(`(?is)^\s*DROP INDEX IF EXISTS\s+\w+\s*;?\s*$`)
+	addColumnRe   = regexp.MustCompile(`(?is)^ADD COLUMN\s+(?:IF NOT EXISTS\s+)?(\w+)`)
+	dropColumnRe  = regexp.MustCompile(`(?is)^DROP COLUMN\s+(?:IF EXISTS\s+)?(\w+)`)
+	alterColumnRe = regexp.MustCompile(`(?is)^ALTER COLUMN\s`)
+)
+
+// table constraints that can be listed among the columns of a CREATE TABLE
+var tableConstraints = map[string]struct{}{
+	"PRIMARY":    {},
+	"UNIQUE":     {},
+	"CONSTRAINT": {},
+	"FOREIGN":    {},
+	"CHECK":      {},
+	"EXCLUDE":    {},
+}
+
+// Schema is the set of tables (with their columns, in creation order) and views of a DB.
+type Schema struct {
+	Tables map[string][]string
+	Views  []string
+}
+
+// SchemaMismatch lists the differences between the schema expected by the client and the one of the DB.
+// Columns are given as "table.column".
+type SchemaMismatch struct {
+	MissingTables     []string
+	MissingColumns    []string
+	UnexpectedColumns []string
+	MissingViews      []string
+}
+
+// Empty returns whether the DB schema matches the expected one.
+func (m SchemaMismatch) Empty() bool {
+	return len(m.MissingTables) == 0 && len(m.MissingColumns) == 0 &&
+		len(m.UnexpectedColumns) == 0 && len(m.MissingViews) == 0
+}
+
+func (m SchemaMismatch) Error() string {
+	parts := make([]string, 0)
+	add := func(desc string, items []string) {
+		if len(items) > 0 {
+			parts = append(parts, desc+": "+strings.Join(items, ", "))
+		}
+	}
+	add("missing tables", m.MissingTables)
+	add("missing columns", m.MissingColumns)
+	add("unexpected columns", m.UnexpectedColumns)
+	add("missing views", m.MissingViews)
+	return "db schema mismatch (" + strings.Join(parts, "; ") + ")"
+}
+
+// execSchema runs a statement that creates or upgrades the schema, or only records it
+// when the client is composing the expected schema.
+func (c *DBClient) execSchema(sql string) error {
+	if c.schemaStmts != nil {
+		*c.schemaStmts = append(*c.schemaStmts, sql)
+		return nil
+	}
+	_, err := c.schemaDB().Exec(c.ctx, sql)
+	return err
+}
+
+// ExpectedSchema returns the tables, columns and views that initTables and the migrations create
+// for the given network.
+func ExpectedSchema(network utils.NetworkType) (Schema, error) {
+	recorder := &DBClient{
+		Network:     network,
+		schemaStmts: new([]string),
+	}
+	err := recorder.initTables()
+	if err != nil {
+		return Schema{}, errors.Wrap(err, "recording the init statements")
+	}
+	migrations, err := loadMigrations()
+	if err != nil {
+		return Schema{}, err
+	}
+	for _, mig := range migrations {
+		*recorder.schemaStmts = append(*recorder.schemaStmts, mig.up...)
+	}
+	return parseSchema(*recorder.schemaStmts)
+}
+
+// parseSchema composes the schema out of the init statements. Any statement that it doesn't
+// understand is returned as error, so that the expected schema can't silently fall behind.
+func parseSchema(stmts []string) (Schema, error) {
+	schema := Schema{
+		Tables: make(map[string][]string),
+		Views:  make([]string, 0),
+	}
+	for _, stmt := range stmts {
+		if m := createTableRe.FindStringSubmatch(stmt); m != nil {
+			table := strings.ToLower(m[1])
+			columns := make([]string, 0)
+			for _, item := range splitTopLevel(m[2]) {
+				fields := strings.Fields(item)
+				if len(fields) == 0 {
+					continue
+				}
+				if _, ok := tableConstraints[strings.ToUpper(fields[0])]; ok {
+					continue
+				}
+				columns = append(columns, strings.ToLower(fields[0]))
+			}
+			schema.Tables[table] = columns
+			continue
+		}
+		if m := alterTableRe.FindStringSubmatch(stmt); m != nil {
+			table := strings.ToLower(m[1])
+			columns, ok := schema.Tables[table]
+			if !ok {
+				return schema, errors.New("altering table " + table + " before creating it")
+			}
+			for _, action := range splitTopLevel(m[2]) {
+				action = strings.TrimSpace(action)
+				switch {
+				case addColumnRe.MatchString(action):
+					column := strings.ToLower(addColumnRe.FindStringSubmatch(action)[1])
+					if !containsStr(columns, column) {
+						columns = append(columns, column)
+					}
+				case dropColumnRe.MatchString(action):
+					column := strings.ToLower(dropColumnRe.FindStringSubmatch(action)[1])
+					columns = removeStr(columns, column)
+				case alterColumnRe.MatchString(action):
+					// constraints and types are not checked
+				default:
+					return schema, errors.New("unknown alteration of table " + table + ": " + action)
+				}
+			}
+			schema.Tables[table] = columns
+			continue
+		}
+		if m := createViewRe.FindStringSubmatch(stmt); m != nil {
+			schema.Views = append(schema.Views, strings.ToLower(m[1]))
+			continue
+		}
+		if m := createIndexRe.FindStringSubmatch(stmt); m != nil {
+			// the indexes are not checked, but they need their table
+			table := strings.ToLower(m[1])
+			if _, ok := schema.Tables[table]; !ok {
+				return schema, errors.New("indexing table " + table + " before creating it")
+			}
+			continue
+		}
+		if dropIndexRe.MatchString(stmt) {
+			// the replaced indexes are not checked either
+			continue
+		}
+		return schema, errors.New("unknown schema statement: " + strings.TrimSpace(stmt))
+	}
+	return schema, nil
+}
+
+// splitTopLevel splits the text by the commas that are not between parentheses or quotes.
+func splitTopLevel(text string) []string {
+	items := make([]string, 0)
+	depth := 0
+	quoted := false
+	start := 0
+	for i, r := range text {
+		switch {
+		case r == '\'':
+			quoted = !quoted
+		case quoted:
+		case r == '(':
+			depth++
+		case r == ')':
+			depth--
+		case r == ',' && depth == 0:
+			items = append(items, text[start:i])
+			start = i + 1
+		}
+	}
+	return append(items, text[start:])
+}
+
+// diffSchema returns what the found schema is missing from the expected one, and the columns of the
+// expected tables that the client doesn't know about. Tables outside the expected ones are ignored.
+func diffSchema(expected, found Schema) SchemaMismatch {
+	var mismatch SchemaMismatch
+	for table, columns := range expected.Tables {
+		foundColumns, ok := found.Tables[table]
+		if !ok {
+			mismatch.MissingTables = append(mismatch.MissingTables, table)
+			continue
+		}
+		for _, column := range columns {
+			if !containsStr(foundColumns, column) {
+				mismatch.MissingColumns = append(mismatch.MissingColumns, table+"."+column)
+			}
+		}
+		for _, column := range foundColumns {
+			if !containsStr(columns, column) {
+				mismatch.UnexpectedColumns = append(mismatch.UnexpectedColumns, table+"."+column)
+			}
+		}
+	}
+	for _, view := range expected.Views {
+		if !containsStr(found.Views, view) {
+			mismatch.MissingViews = append(mismatch.MissingViews, view)
+		}
+	}
+	sort.Strings(mismatch.MissingTables)
+	sort.Strings(mismatch.MissingColumns)
+	sort.Strings(mismatch.UnexpectedColumns)
+	sort.Strings(mismatch.MissingViews)
+	return mismatch
+}
+
+// GetSchema reads the tables, columns and views of the current schema from information_schema.
+func (c *DBClient) GetSchema() (Schema, error) {
+	ctx, cancel := c.readCtx()
+	defer cancel()
+	log.Debug("fetching the db schema from information_schema")
+	schema := Schema{
+		Tables: make(map[string][]string),
+		Views:  make([]string, 0),
+	}
+
+	rows, err := c.psqlPool.Query(ctx, `
+		SELECT c.table_name, c.column_name
+		FROM information_schema.columns AS c
+		INNER JOIN information_schema.tables AS t
+			ON t.table_schema = c.table_schema AND t.table_name = c.table_name
+		WHERE c.table_schema = current_schema() AND t.table_type = 'BASE TABLE'
+		ORDER BY c.table_name, c.ordinal_position;
+		`)
+	if err != nil {
+		return schema, errors.Wrap(err, "unable to fetch the columns of the db")
+	}
+	// make sure we close the rows and we free the connection/session
+	defer rows.Close()
+
+	for rows.Next() {
+		var table, column string
+		err = rows.Scan(&table, &column)
+		if err != nil {
+			return schema, errors.Wrap(err, "unable to parse fetched column")
+		}
+		schema.Tables[table] = append(schema.Tables[table], column)
+	}
+	if err = rows.Err(); err != nil {
+		return schema, errors.Wrap(err, "unable to fetch the columns of the db")
+	}
+
+	viewRows, err := c.psqlPool.Query(ctx, `
+		SELECT table_name
+		FROM information_schema.views
+		WHERE table_schema = current_schema();
+		`)
+	if err != nil {
+		return schema, errors.Wrap(err, "unable to fetch the views of the db")
+	}
+	defer viewRows.Close()
+
+	for viewRows.Next() {
+		var view string
+		err = viewRows.Scan(&view)
+		if err != nil {
+			return schema, errors.Wrap(err, "unable to parse fetched view")
+		}
+		schema.Views = append(schema.Views, view)
+	}
+	return schema, viewRows.Err()
+}
+
+// CheckSchema compares the schema of the DB with the one the client expects for its network,
+// returning a SchemaMismatch error that lists every difference.
+func (c *DBClient) CheckSchema() error {
+	expected, err := ExpectedSchema(c.Network)
+	if err != nil {
+		return errors.Wrap(err, "unable to compose the expected db schema")
+	}
+	found, err := c.GetSchema()
+	if err != nil {
+		return err
+	}
+	mismatch := diffSchema(expected, found)
+	if !mismatch.Empty() {
+		return mismatch
+	}
+	return nil
+}
+
+func containsStr(items []string, item string) bool {
+	for _, i := range items {
+		if i == item {
+			return true
+		}
+	}
+	return false
+}
+
+func removeStr(items []string, item string) []string {
+	kept := make([]string, 0, len(items))
+	for _, i := range items {
+		if i != item {
+			kept = append(kept, i)
+		}
+	}
+	return kept
+}
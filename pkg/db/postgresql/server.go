@@ -2,7 +2,11 @@ package postgresql
 
 import (
 	"context"
+	"fmt"
+	"hash/fnv"
+	"reflect"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/migalabs/armiarma/pkg/db/models"
@@ -11,6 +15,7 @@ import (
 	"github.com/migalabs/armiarma/pkg/utils"
 	log "github.com/sirupsen/logrus"
 
+	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
 	"github.com/pkg/errors"
 )
@@ -18,13 +23,12 @@ import (
 const (
 	batchFlushingTimeout = 1 * time.Second
 	batchSize            = 512
-	maxPersisters        = 2
+	// number of persisters (workers with their own batch) of the clients by default
+	DefaultPersisters = 2
 )
 
-var (
-	noQueryError  string = "no error"
-	noQueryResult string = "no result"
-)
+// ShutdownTimeout bounds the time that Close gives the persisters to flush what they have left.
+var ShutdownTimeout = 10 * time.Second
 
 type DBClient struct {
 	// Control Variables
@@ -37,14 +41,83 @@ type DBClient struct {
 	// Pgx Postgres variables
 	loginStr string
 	psqlPool *pgxpool.Pool
-
-	// Request channels
-	persistC chan interface{}
-	doneC    chan struct{}
-	wg       *sync.WaitGroup
+	// Postgres schema of the tables (DefaultSchema if empty)
+	schema string
+
+	// Request channels, one per persister (the items of each peer always go to the same one)
+	persisters int
+	persistCs  []chan interface{}
+	doneC      chan struct{}
+	wg         *sync.WaitGroup
+	// no more items are queued once closed (the senders hold the read lock)
+	closeM    sync.RWMutex
+	closed    bool
+	closeOnce sync.Once
 
 	// Control Variables
 	persistConnEvents bool
+	// pending conn_events from which a batch copies them at once (never if 0)
+	connEventsCopyThreshold int
+
+	// samples the errors of the batches, the queries and the new peer listener by their cause (see
+	// SampleKey), so that a DB that is down doesn't flood the logs with each failed statement
+	errSampler *utils.ErrorSampler
+	// number of batches that failed to be persisted (atomic)
+	batchErrors int64
+	// number of host infos skipped for being empty (atomic)
+	emptyHostInfos int64
+	// number of items dropped for being queued after closing the client (atomic)
+	droppedItems int64
+	// persisters of the HostInfo attributes by their type (see RegisterAttrPersister)
+	attrPersisters  map[reflect.Type]AttrPersister
+	attrPersistersM sync.RWMutex
+	// compares the ENR and MetaData attnets of the persisted peers
+	attnetsChecker *eth.AttnetsChecker
+	// compares the ENR addresses with the observed address of the persisted peers
+	addrChecker *eth.AddrChecker
+	// don't flag the port-only differences between the ENR and the observed addresses
+	ignoreAddrPorts bool
+	// conn_events older than this are pruned daily (disabled if 0)
+	retention time.Duration
+	// notify the NewPeerChannel when a new peer is inserted
+	notifyNewPeers bool
+	// deadlines of the batches of queries and of the read queries
+	batchTimeout time.Duration
+	readTimeout  time.Duration
+	// rows fetched at once by the streamed reads
+	fetchSize int
+	// whether the non-Ethereum libp2p peers count in the client distributions
+	includeOtherLibp2p bool
+	// read the rows of all the networks, not only the ones of Network
+	allNetworks bool
+	// announced forks whose readiness can be reported
+	scheduledForks []eth.ScheduledFork
+	// create the tables once connected
+	initializeTables bool
+	// drop and create again the tables once connected (only allowed WithReset)
+	resetTables bool
+	allowReset  bool
+	// size of the pgx pool
+	poolConf PoolConfig
+	// continue without writing when the DB schema doesn't match the expected one
+	readOnlyFallback bool
+	readOnly         bool
+	// skip the periodic and the closing backups (i.e. for the maintenance tasks)
+	withoutBackups bool
+	// report the orphan rows of the tables once connected
+	integrityCheck bool
+	// when set, the schema statements are recorded instead of run (see ExpectedSchema)
+	schemaStmts *[]string
+	// when set, the schema statements run within this migration transaction (see Migrate)
+	schemaTx pgx.Tx
+
+	// throughput of the persisters since the start (atomic)
+	startTime        time.Time
+	persistedItems   int64
+	persistedQueries int64
+	persistedBatches int64
+	// statements of the persisters per table
+	stats *persisterStats
 }
 
 func NewDBClient(
@@ -52,27 +125,78 @@ func NewDBClient(
 	p2pNetwork utils.NetworkType,
 	loginStr string,
 	dailyBackupInt time.Duration,
-	options ...DBOption) (*DBClient, error) {
+	options ...DBOption) (_ *DBClient, err error) {
 	// check if the login string has enough len
 	if len(loginStr) == 0 {
 		return nil, errors.New("empty db-endpoint provided")
 	}
 
+	var wg sync.WaitGroup
+
+	// compose the DBClient
+	dbClient := &DBClient{
+		ctx:                     ctx,
+		dailyBackupInterval:     dailyBackupInt,
+		Network:                 p2pNetwork,
+		loginStr:                loginStr,
+		persisters:              DefaultPersisters,
+		doneC:                   make(chan struct{}),
+		wg:                      &wg,
+		persistConnEvents:       true,
+		connEventsCopyThreshold: DefaultConnEventsCopyThreshold,
+		errSampler:              utils.NewErrorSampler(utils.DefaultErrorSampleWindow, nil),
+		attnetsChecker:          eth.NewAttnetsChecker(),
+		batchTimeout:            DefaultBatchTimeout,
+		readTimeout:             DefaultReadTimeout,
+		fetchSize:               DefaultFetchSize,
+		poolConf:                DefaultPoolConfig,
+		stats:                   newPersisterStats(),
+	}
+
+	// Check for all the available options
+	for _, opt := range options {
+		err := opt(dbClient)
+		if err != nil {
+			return nil, err
+		}
+	}
+	dbClient.addrChecker = eth.NewAddrChecker(dbClient.ignoreAddrPorts)
+	// the Ethereum attributes are persisted through their registered persisters
+	err = dbClient.registerEthAttrPersisters()
+	if err != nil {
+		return nil, err
+	}
+	// generate the channels of the persisters
+	dbClient.persistCs = newPersistChans(dbClient.persisters)
+
 	// setup the configuration for the pgx.Pool
 	pgxConf, err := pgxpool.ParseConfig(loginStr)
 	if err != nil {
 		return nil, err
 	}
 	// update the number of concurrent connections
-	pgxConf.MinConns = 0
-	pgxConf.MaxConns = 1
+	dbClient.poolConf.apply(pgxConf)
+	// let the DB abort any wedged query
+	pgxConf.ConnConfig.RuntimeParams["statement_timeout"] = fmt.Sprintf("%d", StatementTimeout.Milliseconds())
+	// keep the tables of the client in its own schema (if any)
+	dbClient.applySchema(pgxConf.ConnConfig.RuntimeParams)
 
 	// try connecting to the DB from the given logingStr
 	psqlPool, err := pgxpool.ConnectConfig(ctx, pgxConf)
 	if err != nil {
 		return nil, err
 	}
-	log.WithFields(log.Fields{"endpoint": loginStr}).Debug("successful connection to DB")
+	// don't leak the connections of the pool if the client can't be used
+	defer func() {
+		if err != nil {
+			psqlPool.Close()
+		}
+	}()
+	log.WithFields(log.Fields{
+		"endpoint":  loginStr,
+		"max-conns": pgxConf.MaxConns,
+		"min-conns": pgxConf.MinConns,
+	}).Debug("successful connection to DB")
 
 	// check if the connection is successful
 	err = psqlPool.Ping(ctx)
@@ -85,45 +209,64 @@ func NewDBClient(
 	if err != nil {
 		return nil, errors.Wrap(err, "unable to ping db through dbReader")
 	}
+	dbClient.psqlPool = psqlPool
+	dbClient.startTime = time.Now()
 
-	// generate all the necessary/control channels
-	persistC := make(chan interface{}, batchSize)
-
-	var wg sync.WaitGroup
-
-	// compose the DBClient
-	dbClient := &DBClient{
-		ctx:                 ctx,
-		dailyBackupInterval: dailyBackupInt,
-		Network:             p2pNetwork,
-		loginStr:            loginStr,
-		psqlPool:            psqlPool,
-		persistC:            persistC,
-		doneC:               make(chan struct{}),
-		wg:                  &wg,
-		persistConnEvents:   true,
-	}
-
-	// Check for all the available options
-	for _, opt := range options {
-		err := opt(dbClient)
+	// initialize all the tables up to the latest schema version (from scratch if the client was asked to reset them)
+	if dbClient.resetTables || dbClient.initializeTables {
+		err = dbClient.createSchema()
 		if err != nil {
 			return nil, err
 		}
 	}
+	if dbClient.resetTables {
+		err = dbClient.ResetTables()
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to reset the SQL tables at "+dbClient.loginStr)
+		}
+	} else if dbClient.initializeTables {
+		err = dbClient.Migrate(ctx)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to initialize the SQL tables at "+dbClient.loginStr)
+		}
+	}
+
+	// fail fast if the tables don't match the ones the client writes to
+	err = dbClient.CheckSchema()
+	if err != nil {
+		var mismatch SchemaMismatch
+		if !errors.As(err, &mismatch) || !dbClient.readOnlyFallback {
+			return nil, errors.Wrap(err, "unable to check the SQL schema at "+dbClient.loginStr)
+		}
+		log.Warnf("%s, continuing in read-only mode", err.Error())
+		dbClient.readOnly = true
+	}
+
+	// report the state of the inherited DBs before anything gets persisted
+	if dbClient.integrityCheck {
+		dbClient.logIntegrity()
+	}
 
 	// run the db persisters
-	for i := 0; i < maxPersisters; i++ {
-		go dbClient.launchPersister()
+	for i := range dbClient.persistCs {
+		dbClient.launchPersister(i)
+	}
+	if dbClient.readOnly || dbClient.withoutBackups {
+		return dbClient, nil
 	}
 	// launch the daily backup heartbeat
 	go dbClient.dailyBackupheartbeat()
+	// launch the daily conn_events retention (if any)
+	if dbClient.retention > 0 {
+		go dbClient.dailyRetentionHeartbeat()
+	}
 	return dbClient, nil
 }
 
+// initTables creates the tables and views needed to perform the crawl, upgrading the ones
+// created by previous versions. Every step only creates what is missing, so it is safe to call
+// it repeatedly, or on a DB left half-initialized by an interrupted run.
 func (c *DBClient) initTables() error {
-	// initialize all the necesary tables to perform the crawl
-
 	var err error
 
 	// peer_info table
@@ -138,6 +281,12 @@ func (c *DBClient) initTables() error {
 		return errors.Wrap(err, "initializing conn_events table")
 	}
 
+	// ids of the connection attempts counted in peer_info
+	err = c.InitConnAttemptsTable()
+	if err != nil {
+		return errors.Wrap(err, "initializing conn_attempts table")
+	}
+
 	// ip table
 	err = c.InitIpTable()
 	if err != nil {
@@ -150,6 +299,30 @@ func (c *DBClient) initTables() error {
 		return errors.Wrap(err, "initializing active_peers backup")
 	}
 
+	// first sighting of each client version
+	err = c.InitClientVersionsTable()
+	if err != nil {
+		return errors.Wrap(err, "initializing client_versions table")
+	}
+
+	// outcome of the dials to each address
+	err = c.InitAddrReachabilityTable()
+	if err != nil {
+		return errors.Wrap(err, "initializing addr_reachability table")
+	}
+
+	// increments of the gossip messages of each peer and topic
+	err = c.InitTopicMetricsDeltasTable()
+	if err != nil {
+		return errors.Wrap(err, "initializing topic_metrics_deltas table")
+	}
+
+	// attributes of the peers without a table of their own
+	err = c.InitPeerAttributesTable()
+	if err != nil {
+		return errors.Wrap(err, "initializing peer_attributes table")
+	}
+
 	switch c.Network {
 	// ETHEREUM
 	case utils.EthereumNetwork:
@@ -165,6 +338,12 @@ func (c *DBClient) initTables() error {
 			return errors.Wrap(err, "initializing eth_status table")
 		}
 
+		// metadata_history table
+		err = c.InitMetadataHistoryTable()
+		if err != nil {
+			return errors.Wrap(err, "initializing metadata_history table")
+		}
+
 		// gossipsub messages
 		// eth_attestation
 		err = c.initEthereumAttestationsTable()
@@ -176,162 +355,359 @@ func (c *DBClient) initTables() error {
 		if err != nil {
 			return errors.Wrap(err, "initializing eth_blocks table")
 		}
+		// light client updates
+		err = c.initEthereumLightClientUpdatesTable()
+		if err != nil {
+			return errors.Wrap(err, "initializing eth_light_client_updates table")
+		}
 	//IPFS
 	// FILECOIN
 	default:
 
 	}
 
+	// views over the tables (refreshed on every start)
+	err = c.InitViews()
+	if err != nil {
+		return errors.Wrap(err, "initializing views")
+	}
+
 	return err
 }
 
-func (c *DBClient) launchPersister() {
+// newPersistChans returns the channels of the given number of persisters.
+func newPersistChans(persisters int) []chan interface{} {
+	persistCs := make([]chan interface{}, persisters)
+	for i := range persistCs {
+		persistCs[i] = make(chan interface{}, batchSize)
+	}
+	return persistCs
+}
+
+// persisterOf returns the persister of the items of the given key (i.e. the peer.ID), so that
+// the items of the same peer are persisted in the order they were queued.
+func persisterOf(key string, persisters int) int {
+	if persisters <= 1 {
+		return 0
+	}
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % uint32(persisters))
+}
+
+// queue sends the item to the persister of its key. The items queued once the client is closed
+// (or its context is done) are dropped with ErrClosed.
+func (c *DBClient) queue(key string, item interface{}) error {
+	c.closeM.RLock()
+	defer c.closeM.RUnlock()
+	if c.closed || c.ctx.Err() != nil {
+		atomic.AddInt64(&c.droppedItems, 1)
+		return ErrClosed
+	}
+	select {
+	case c.persistCs[persisterOf(key, len(c.persistCs))] <- item:
+		return nil
+	case <-c.ctx.Done():
+		// the persisters won't pick it anymore
+		atomic.AddInt64(&c.droppedItems, 1)
+		return ErrClosed
+	}
+}
+
+func (c *DBClient) launchPersister(worker int) {
 	logEntry := log.WithFields(log.Fields{
-		"mod": "db-persister",
+		"mod":       "db-persister",
+		"persister": worker,
 	})
+	persistC := c.persistCs[worker]
 	c.wg.Add(1)
 	go func() {
 		defer c.wg.Done()
 
 		// batch to aggregate all the queries
-		batch := NewQueryBatch(c.ctx, c.psqlPool, batchSize)
+		batch := NewQueryBatch(c.ctx, c.psqlPool, batchSize, c.batchTimeout)
+		batch.stats = c.stats
+		batch.sampler = c.errSampler
+		batch.network = c.Network
+		batch.copyThreshold = c.connEventsCopyThreshold
 
 		// batch flushing ticker
 		ticker := time.NewTicker(batchFlushingTimeout)
+		defer ticker.Stop()
 
-		var readyToFinish bool
+		// items added to the batch since the last flush
+		var batchItems int64
 
 	persistingLoop:
 		for {
-			if readyToFinish && len(c.persistC) == 0 {
-				break persistingLoop
-			}
-
-			// check with higher priority if the main-ctx died
 			select {
 			case <-c.ctx.Done(): // check if the context of the tool died
 				logEntry.Info("context died, clossing persister")
-				readyToFinish = true
+				break persistingLoop
 			case <-c.doneC:
 				logEntry.Info("closed detected, clossing persister")
-				readyToFinish = true
-			default:
-			}
-
-			// load  or flush after
-			select {
-			case obj := <-c.persistC: // persist any kind of item
-				// Every item/SQL query  has to return (string. []interfaces)
-				switch obj.(type) {
-				case (*models.HostInfo):
-					hostInfo := obj.(*models.HostInfo)
-					logEntry.Tracef("persisting host_info %s\n", hostInfo.ID.String())
-					// // double-check when are we rewriting hInfo without IP, and port
-					// if hostInfo.IP == "" {
-					// 	log.Error("error trying to add host info without IP and ports", hostInfo)
-					// }
-					// add raw new HostInfo
-					q, args := c.UpsertHostInfo(hostInfo)
-					batch.AddQuery(q, args...)
-
-					// check if the peerInfo needs to update anything else
-					if hostInfo.IsHostIdentified() {
-						logEntry.Tracef("host_info has peer_info %s\n", hostInfo.PeerInfo.RemotePeer.String())
-						q, args = c.UpdatePeerInfo(&hostInfo.PeerInfo)
-						batch.AddQuery(q, args...)
-					}
-					// Read all the Attributes in hInfo
-					for attName, att := range hostInfo.Attr {
-						log.Debugf("detected attribute %s on peer", attName)
-						switch att.(type) {
-						case eth.BeaconStatusStamped:
-							bstatus := att.(eth.BeaconStatusStamped)
-							q, args = c.UpsertEthereumNodeStatus(bstatus)
-							batch.AddQuery(q, args...)
-						case eth.BeaconMetadataStamped:
-							bmetadata := att.(eth.BeaconMetadataStamped)
-							q, args = c.UpsertEthereumNodeMetadata(bmetadata)
-							batch.AddQuery(q, args...)
-						case (*eth.EnrNode):
-							enrNode := att.(*eth.EnrNode)
-							logEntry.Tracef("persisting eth node_info %s\n", enrNode.ID.String())
-							q, args := c.UpsertEnrInfo(enrNode)
-							batch.AddQuery(q, args...)
-						default:
-							log.Warnf("not yet recognized type for attr %s - %T - %+v", attName, att, att)
-						}
-					}
-
-				case (*models.PeerInfo):
-					peerInfo := obj.(*models.PeerInfo)
-					logEntry.Tracef("persisting new peer_info %s\n", peerInfo.RemotePeer.String())
-					q, args := c.UpdatePeerInfo(peerInfo)
-					batch.AddQuery(q, args...)
-
-				case (*models.ConnectionAttempt):
-					connAttempt := obj.(*models.ConnectionAttempt)
-					logEntry.Tracef("persisting conn_attempt")
-					q, args := c.UpdateConnAttempt(connAttempt)
-					batch.AddQuery(q, args...)
-
-				case (*models.ConnEvent):
-					connEvent := obj.(*models.ConnEvent)
-					logEntry.Tracef("persisting conn_event for peer %s\n", connEvent.PeerID.String())
-					if c.persistConnEvents {
-						q, args := c.InsertNewConnEvent(connEvent)
-						batch.AddQuery(q, args...)
-					}
-					// Control Info LastActivity based on last disconnection
-					// get the disconnection time to update the LastActivity timestamp in the peer_info table
-					q, args := c.UpdateLastActivityTimestamp(connEvent.PeerID, connEvent.DiscTime)
-					batch.AddQuery(q, args...)
+				break persistingLoop
 
-				case (models.IpInfo):
-					ipInfo := obj.(models.IpInfo)
-					logEntry.Tracef("persisting ip_info %s\n", ipInfo.IP)
-					q, args := c.UpsertIpInfo(ipInfo)
-					batch.AddQuery(q, args...)
-
-				// GossipSub Messages
-				case (gossipsub.PersistableMsg):
-					prsMsg := obj.(gossipsub.PersistableMsg)
-					// select the type of message inside the list of messages
-					switch prsMsg.(type) {
-					case (*eth.TrackedAttestation):
-						attMsg := prsMsg.(*eth.TrackedAttestation)
-						log.Tracef("persisting eth_attestation %s", attMsg.MsgID)
-						q, args := c.InsertNewEthereumAttestation(attMsg)
-						batch.AddQuery(q, args...)
-					case (*eth.TrackedBeaconBlock):
-						bblockMsg := prsMsg.(*eth.TrackedBeaconBlock)
-						log.Tracef("persisting eth_block %s", bblockMsg.MsgID)
-						q, args := c.InsertNewEthereumBeaconBlock(bblockMsg)
-						batch.AddQuery(q, args...)
-					}
-				default:
-					logEntry.Errorf("unrecognized type of object received to persist into DB %T", obj)
-					logEntry.Error(obj)
-				}
+			case obj := <-persistC: // persist any kind of item
+				batchItems++
+				c.addToBatch(batch, obj)
 
 				// after adding whatever query we got check if we need to persist the batch
 				if batch.IsReadyToPersist() {
 					logEntry.Debug("batch-query full, ready to persist")
-					err := batch.PersistBatch()
-					if err != nil {
-						log.Error(err)
-					}
+					c.flushBatch(batch, batchItems)
+					batchItems = 0
 				}
 
 			case <-ticker.C:
 				logEntry.Trace("ticker jumped - flushing content of query-batch")
 				// flush the batched queries
-				err := batch.PersistBatch()
+				c.flushBatch(batch, batchItems)
+				batchItems = 0
+				// report the errors repeated during the last window
+				c.errSampler.FlushExpired()
+			}
+		}
+		c.drainPersister(batch, persistC, batchItems)
+	}()
+}
+
+// drainPersister persists the items left in the channel of the persister and the last batch,
+// including the requeued queries. As the context of the client might be already done, the
+// batches get their own deadline of ShutdownTimeout.
+func (c *DBClient) drainPersister(batch *QueryBatch, persistC chan interface{}, batchItems int64) {
+	ctx, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+	defer cancel()
+	batch.ctx = ctx
+
+	for len(persistC) > 0 && ctx.Err() == nil {
+		batchItems++
+		c.addToBatch(batch, <-persistC)
+		if batch.IsReadyToPersist() {
+			c.flushBatch(batch, batchItems)
+			batchItems = 0
+		}
+	}
+	if batch.Len() > 0 || batch.Requeued() > 0 {
+		c.flushRequeued(batch, batchItems)
+	}
+}
+
+// addToBatch queues into the batch the queries that persist the item.
+func (c *DBClient) addToBatch(batch *QueryBatch, obj interface{}) {
+	logEntry := log.WithFields(log.Fields{
+		"mod": "db-persister",
+	})
+	// Every item/SQL query  has to return (string. []interfaces)
+	switch obj.(type) {
+	case (*models.HostInfo):
+		hostInfo := obj.(*models.HostInfo)
+		logEntry.Tracef("persisting host_info %s\n", hostInfo.ID.String())
+		// empty IPs, ports or addresses don't overwrite the stored ones (see UpsertHostInfo)
+		// add raw new HostInfo
+		q, args := c.UpsertHostInfo(hostInfo)
+		batch.AddQuery(q, args...)
+
+		// check if the peerInfo needs to update anything else
+		if hostInfo.IsHostIdentified() {
+			logEntry.Tracef("host_info has peer_info %s\n", hostInfo.PeerInfo.RemotePeer.String())
+			q, args = c.UpdatePeerInfo(&hostInfo.PeerInfo)
+			batch.AddQuery(q, args...)
+		}
+		// the peers synced from the crawler's store carry their attempt aggregates and activity
+		if hostInfo.ControlInfo.Attempted {
+			q, args = c.UpdateAttemptAggregates(hostInfo.ID, hostInfo.ControlInfo)
+			batch.AddQuery(q, args...)
+		}
+		if !hostInfo.ControlInfo.LastActivity.IsZero() {
+			q, args = c.UpdateLastActivityTimestamp(hostInfo.ID, hostInfo.ControlInfo.LastActivity)
+			batch.AddQuery(q, args...)
+		}
+		// Read all the Attributes in hInfo
+		for attName, att := range hostInfo.Attr {
+			log.Debugf("detected attribute %s on peer", attName)
+			// the registered persisters come before the built-in ones
+			if c.addAttrToBatch(batch, hostInfo.ID, attName, att) {
+				continue
+			}
+			switch att.(type) {
+			case models.ReqRespOutcome:
+				outcome := att.(models.ReqRespOutcome)
+				q, args = c.UpdateStatusRequest(outcome)
+				batch.AddQuery(q, args...)
+			case models.MetadataRequest:
+				outcome := att.(models.MetadataRequest)
+				q, args = c.UpdateMetadataRequest(outcome)
+				batch.AddQuery(q, args...)
+			case models.Goodbye:
+				goodbye := att.(models.Goodbye)
+				q, args = c.UpdateGoodbye(goodbye)
+				batch.AddQuery(q, args...)
+			case models.PubsubProtocol:
+				pubsubProto := att.(models.PubsubProtocol)
+				q, args = c.UpdatePubsubVersion(pubsubProto)
+				batch.AddQuery(q, args...)
+			case models.DistinctTopics:
+				topics := att.(models.DistinctTopics)
+				q, args = c.UpdateDistinctTopics(hostInfo.ID, topics)
+				batch.AddQuery(q, args...)
+			case models.AddrReachability:
+				reachability := att.(models.AddrReachability)
+				for _, dial := range reachability.Dials {
+					q, args = c.UpsertAddrDial(reachability.PeerID, reachability.Timestamp, dial)
+					batch.AddQuery(q, args...)
+				}
+			case models.Dialback:
+				dialback := att.(models.Dialback)
+				q, args = c.UpdateDialback(dialback)
+				batch.AddQuery(q, args...)
+			case models.QualityScore:
+				// already persisted by the peer_info upsert
+			default:
+				// keep the unknown attributes as JSON, instead of dropping them
+				payload, err := peerAttributePayload(att)
 				if err != nil {
-					log.Error(err)
+					log.Warnf("not yet recognized type for attr %s - %T - %+v: %s", attName, att, att, err.Error())
+					continue
 				}
+				q, args = c.UpsertPeerAttribute(hostInfo.ID, attName, payload, time.Now())
+				batch.AddQuery(q, args...)
 			}
 		}
-	}()
+
+	case (*models.PeerInfo):
+		peerInfo := obj.(*models.PeerInfo)
+		logEntry.Tracef("persisting new peer_info %s\n", peerInfo.RemotePeer.String())
+		q, args := c.UpdatePeerInfo(peerInfo)
+		batch.AddQuery(q, args...)
+
+	case (*models.ConnectionAttempt):
+		connAttempt := obj.(*models.ConnectionAttempt)
+		logEntry.Tracef("persisting conn_attempt")
+		q, args := c.UpdateConnAttempt(connAttempt)
+		batch.AddQuery(q, args...)
+
+	case (*models.ConnEvent):
+		connEvent := obj.(*models.ConnEvent)
+		logEntry.Tracef("persisting conn_event for peer %s\n", connEvent.PeerID.String())
+		if c.persistConnEvents {
+			batch.AddConnEvent(c.connEventRow(connEvent))
+		}
+		// Control Info LastActivity based on last disconnection
+		// the batch keeps the latest disconnection time of each peer and updates the peer_info once per flush
+		// (the open sessions update it once they get disconnected)
+		if !connEvent.DiscTime.IsZero() {
+			batch.AddLastActivity(connEvent.PeerID, connEvent.DiscTime)
+		}
+		// the relayed connections come from the address of the relay
+		if connEvent.RemoteAddr != "" && !connEvent.Relayed {
+			observed, err := eth.ParseObservedAddr(connEvent.RemoteAddr, connEvent.Direction == models.OutboundConnection)
+			if err != nil {
+				logEntry.Trace(err)
+			} else if mismatch, ok := c.addrChecker.AddObserved(connEvent.PeerID, observed); ok {
+				q, args := c.UpdateAddrMismatch(mismatch)
+				batch.AddQuery(q, args...)
+			}
+		}
+
+	case (*models.ClientVersion):
+		cliVersion := obj.(*models.ClientVersion)
+		logEntry.Tracef("persisting client_version %s %s", cliVersion.Name, cliVersion.Version)
+		q, args := c.InsertClientVersion(cliVersion)
+		batch.AddQuery(q, args...)
+
+	case (models.IpInfo):
+		ipInfo := obj.(models.IpInfo)
+		logEntry.Tracef("persisting ip_info %s\n", ipInfo.IP)
+		q, args := c.UpsertIpInfo(ipInfo)
+		batch.AddQuery(q, args...)
+
+	case (*models.TopicMetricsDelta):
+		delta := obj.(*models.TopicMetricsDelta)
+		logEntry.Tracef("persisting topic_metrics_delta %s %s", delta.PeerID.String(), delta.Topic)
+		q, args := c.InsertTopicMetricsDelta(delta)
+		batch.AddQuery(q, args...)
+
+	case (*models.Deprecation):
+		deprecation := obj.(*models.Deprecation)
+		logEntry.Tracef("persisting deprecation of peer %s", deprecation.PeerID.String())
+		q, args := c.UpdateDeprecation(*deprecation)
+		batch.AddQuery(q, args...)
+
+	// GossipSub Messages
+	case (gossipsub.PersistableMsg):
+		prsMsg := obj.(gossipsub.PersistableMsg)
+		// select the type of message inside the list of messages
+		switch prsMsg.(type) {
+		case (*eth.TrackedAttestation):
+			attMsg := prsMsg.(*eth.TrackedAttestation)
+			log.Tracef("persisting eth_attestation %s", attMsg.MsgID)
+			q, args := c.InsertNewEthereumAttestation(attMsg)
+			batch.AddQuery(q, args...)
+		case (*eth.TrackedBeaconBlock):
+			bblockMsg := prsMsg.(*eth.TrackedBeaconBlock)
+			log.Tracef("persisting eth_block %s", bblockMsg.MsgID)
+			q, args := c.InsertNewEthereumBeaconBlock(bblockMsg)
+			batch.AddQuery(q, args...)
+		case (*eth.TrackedLightClientUpdate):
+			updateMsg := prsMsg.(*eth.TrackedLightClientUpdate)
+			log.Tracef("persisting eth_light_client_update %s", updateMsg.MsgID)
+			q, args := c.InsertNewEthereumLightClientUpdate(updateMsg)
+			batch.AddQuery(q, args...)
+		}
+	default:
+		logEntry.Errorf("unrecognized type of object received to persist into DB %T", obj)
+		logEntry.Error(obj)
+	}
+}
+
+// flushBatch persists the batch, accounting the given items of the batch window in the persister throughput.
+func (c *DBClient) flushBatch(batch *QueryBatch, items int64) {
+	c.flush(batch, items, batch.PersistBatch)
+}
+
+// flushRequeued flushes the batch along with all its requeued queries.
+func (c *DBClient) flushRequeued(batch *QueryBatch, items int64) {
+	c.flush(batch, items, batch.FlushRequeued)
+}
+
+func (c *DBClient) flush(batch *QueryBatch, items int64, persistFn func() error) {
+	queries := int64(batch.Len())
+	err := persistFn()
+	if err != nil {
+		atomic.AddInt64(&c.batchErrors, 1)
+		c.errSampler.ErrorWithKey(SampleKey(err), err)
+		return
+	}
+	if queries == 0 {
+		return
+	}
+	atomic.AddInt64(&c.persistedBatches, 1)
+	atomic.AddInt64(&c.persistedQueries, queries)
+	atomic.AddInt64(&c.persistedItems, items)
+	c.stats.flushed(time.Now())
+}
+
+// dailyRetentionHeartbeat prunes the conn_events older than the retention once a day
+func (c *DBClient) dailyRetentionHeartbeat() {
+	prune := func() {
+		deleted, err := c.PruneConnEvents(c.retention)
+		if err != nil {
+			log.Error(err)
+		}
+		log.Infof("pruned %d conn_events older than %s", deleted, c.retention)
+	}
+	prune()
+	ticker := time.NewTicker(24 * time.Hour)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			prune()
+		case <-c.ctx.Done():
+			return
+		}
+	}
 }
 
 func (c *DBClient) dailyBackupheartbeat() {
@@ -355,24 +731,225 @@ func (c *DBClient) dailyBackupheartbeat() {
 
 }
 
+// Close stops the persisters once they flushed the queued items (see ShutdownTimeout), and closes
+// the connections with the DB. It can be called more than once, and concurrently with the Persist
+// methods, which return ErrClosed afterwards.
 func (c *DBClient) Close() {
-	// Let the persister finish cleaning the batch
-	c.doneC <- struct{}{}
+	c.closeOnce.Do(c.close)
+}
+
+func (c *DBClient) close() {
+	// wait for the items being queued, and don't accept any other
+	c.closeM.Lock()
+	c.closed = true
+	c.closeM.Unlock()
+
+	// Let the persisters finish cleaning their batches
+	close(c.doneC)
 	c.wg.Wait()
 
-	err := c.activePeersBackup()
-	if err != nil {
-		log.Error(err)
+	if !c.readOnly && !c.withoutBackups {
+		err := c.activePeersBackup()
+		if err != nil {
+			log.Error(err)
+		}
 	}
+	c.errSampler.Flush()
 	// close safelly the connection with PSQL
-	c.psqlPool.Close()
+	if c.psqlPool != nil {
+		c.psqlPool.Close()
+	}
+
+	// close all the exisiting channels, accounting what the persisters couldn't pick
+	for _, persistC := range c.persistCs {
+		atomic.AddInt64(&c.droppedItems, int64(len(persistC)))
+		close(persistC)
+	}
+}
+
+// ErrUnknownPersistable is returned when persisting an item that the persisters can't store.
+var ErrUnknownPersistable = errors.New("unknown type of item to persist")
+
+// ErrClosed is returned when persisting through a client that was already closed.
+var ErrClosed = errors.New("db client closed")
+
+// ErrReadOnly is returned when persisting through a client that fell back to read-only mode.
+var ErrReadOnly = errors.New("db client in read-only mode")
+
+// ReadOnly returns whether the client fell back to read-only mode, as the DB schema didn't match
+// the expected one (see WithReadOnlyFallback).
+func (c *DBClient) ReadOnly() bool {
+	return c.readOnly
+}
+
+// PersistToDB validates the item and queues it to be persisted, dispatching the known models
+// to their typed Persist methods. Unknown types are rejected with ErrUnknownPersistable.
+func (c *DBClient) PersistToDB(persItem interface{}) error {
+	if c.readOnly {
+		return ErrReadOnly
+	}
+	switch item := persItem.(type) {
+	case *models.HostInfo:
+		return c.PersistHostInfo(item)
+	case *models.PeerInfo:
+		return c.PersistPeerInfo(item)
+	case *models.ConnEvent:
+		return c.PersistConnEvent(item)
+	case *models.ConnectionAttempt:
+		return c.PersistConnAttempt(item)
+	case models.IpInfo:
+		return c.PersistIpInfo(item)
+	case *models.ClientVersion:
+		if item == nil {
+			return errors.New("nil client_version")
+		}
+	case *models.TopicMetricsDelta:
+		if item == nil || item.PeerID == "" || item.Topic == "" {
+			return errors.New("topic_metrics_delta without peer_id or topic")
+		}
+	case *models.Deprecation:
+		if item == nil || item.PeerID == "" {
+			return errors.New("deprecation without peer_id")
+		}
+	case gossipsub.PersistableMsg:
+		switch item.(type) {
+		case *eth.TrackedAttestation, *eth.TrackedBeaconBlock, *eth.TrackedLightClientUpdate:
+		default:
+			return errors.Wrapf(ErrUnknownPersistable, "gossip message %T", persItem)
+		}
+	default:
+		return errors.Wrapf(ErrUnknownPersistable, "%T", persItem)
+	}
+	return c.queue(persistKey(persItem), persItem)
+}
+
+// PersistHostInfo queues the host info (and its attributes) to be persisted.
+// Host infos without any information besides the peer.ID are skipped.
+func (c *DBClient) PersistHostInfo(hInfo *models.HostInfo) error {
+	if c.readOnly {
+		return ErrReadOnly
+	}
+	if hInfo == nil || hInfo.ID == "" {
+		return errors.New("host_info without peer_id")
+	}
+	// host infos without any information (i.e. identify raced the disconnection) are not worth a query
+	if !hInfo.HasIdentifyingInfo() {
+		log.Tracef("skipping empty host_info of peer %s", hInfo.ID.String())
+		atomic.AddInt64(&c.emptyHostInfos, 1)
+		return nil
+	}
+	return c.queue(hInfo.ID.String(), hInfo)
+}
+
+// PersistPeerInfo queues the identification of the peer to be persisted.
+func (c *DBClient) PersistPeerInfo(pInfo *models.PeerInfo) error {
+	if c.readOnly {
+		return ErrReadOnly
+	}
+	if pInfo == nil || pInfo.RemotePeer == "" {
+		return errors.New("peer_info without peer_id")
+	}
+	return c.queue(pInfo.RemotePeer.String(), pInfo)
+}
+
+// PersistConnEvent queues the finished connection (with both the connection and the disconnection) to be persisted.
+func (c *DBClient) PersistConnEvent(connEvent *models.ConnEvent) error {
+	if c.readOnly {
+		return ErrReadOnly
+	}
+	if connEvent == nil || connEvent.PeerID == "" {
+		return errors.New("conn_event without peer_id")
+	}
+	if !connEvent.IsReadyToPersist() {
+		return errors.New("incomplete conn_event of peer " + connEvent.PeerID.String())
+	}
+	return c.queue(connEvent.PeerID.String(), connEvent)
+}
+
+// PersistPartialConnEvent queues the open session (only with the connection), whose disconnection
+// is filled once the finished event is persisted, or the disconnection whose connection is unknown.
+func (c *DBClient) PersistPartialConnEvent(connEvent *models.ConnEvent) error {
+	if c.readOnly {
+		return ErrReadOnly
+	}
+	if connEvent == nil || connEvent.PeerID == "" {
+		return errors.New("conn_event without peer_id")
+	}
+	if !connEvent.IsOpen() && !connEvent.IsOrphanDisconn() {
+		return errors.New("conn_event of peer " + connEvent.PeerID.String() + " is neither open nor only a disconnection")
+	}
+	return c.queue(connEvent.PeerID.String(), connEvent)
+}
+
+// PersistConnAttempt queues the connection attempt to be persisted.
+func (c *DBClient) PersistConnAttempt(connAttempt *models.ConnectionAttempt) error {
+	if c.readOnly {
+		return ErrReadOnly
+	}
+	if connAttempt == nil || connAttempt.RemotePeer == "" {
+		return errors.New("conn_attempt without peer_id")
+	}
+	return c.queue(connAttempt.RemotePeer.String(), connAttempt)
+}
+
+// PersistIpInfo queues the location of the IP to be persisted.
+func (c *DBClient) PersistIpInfo(ipInfo models.IpInfo) error {
+	if c.readOnly {
+		return ErrReadOnly
+	}
+	if ipInfo.IP == "" {
+		return errors.New("ip_info without ip")
+	}
+	return c.queue(ipInfo.IP, ipInfo)
+}
+
+// DroppedItems returns the number of items that were dropped for being persisted once the client was closed.
+func (c *DBClient) DroppedItems() int64 {
+	return atomic.LoadInt64(&c.droppedItems)
+}
+
+// EmptyHostInfos returns the number of host infos that were skipped for not carrying any information.
+func (c *DBClient) EmptyHostInfos() int64 {
+	return atomic.LoadInt64(&c.emptyHostInfos)
+}
+
+// PersisterQueueDepth returns the number of items waiting to be picked by the persisters.
+func (c *DBClient) PersisterQueueDepth() int {
+	var depth int
+	for _, persistC := range c.persistCs {
+		depth += len(persistC)
+	}
+	return depth
+}
+
+// persistKey returns the key of the persister of the items queued through PersistToDB that
+// don't have their own typed Persist method.
+func persistKey(item interface{}) string {
+	switch item := item.(type) {
+	case *models.ClientVersion:
+		return item.Name
+	case *models.TopicMetricsDelta:
+		return item.PeerID.String()
+	case *models.Deprecation:
+		return item.PeerID.String()
+	case *eth.TrackedAttestation:
+		return item.Sender.String()
+	case *eth.TrackedBeaconBlock:
+		return item.Sender.String()
+	case *eth.TrackedLightClientUpdate:
+		return item.Sender.String()
+	}
+	return ""
+}
 
-	// close all the exisiting channels
-	close(c.persistC)
+// BatchErrors returns the number of query batches that failed to be persisted since the start.
+func (c *DBClient) BatchErrors() int64 {
+	return atomic.LoadInt64(&c.batchErrors)
 }
 
-func (c *DBClient) PersistToDB(persItem interface{}) {
-	c.persistC <- persItem
+// readCtx returns the context for a read query, with the read timeout as deadline.
+func (c *DBClient) readCtx() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(c.ctx, c.readTimeout)
 }
 
 func (c *DBClient) SingleQuery(query string, args ...interface{}) (interface{}, error) {
@@ -0,0 +1,161 @@
+package postgresql
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgconn"
+)
+
+const (
+	// otherTable gathers the statements that don't write into any table (i.e. the bare SELECTs).
+	otherTable = "other"
+	// connEventsTable accounts the conn_events, either inserted or copied.
+	connEventsTable = "conn_events"
+)
+
+// statementTableRe matches the first table written by a statement (the one of the CTE of the upserts
+// that notify, or of the conn_attempts insertion of the attempts).
+var statementTableRe = regexp.MustCompile(`(?i)\b(?:INSERT\s+INTO|UPDATE|DELETE\s+FROM)\s+([a-z_][a-z0-9_.]*)`)
+
+// statementTables caches the table of each statement, as the queries are constant templates.
+var statementTables sync.Map
+
+// statementTable returns the first table written by the query, otherTable if it doesn't write any.
+func statementTable(query string) string {
+	if table, ok := statementTables.Load(query); ok {
+		return table.(string)
+	}
+	table := otherTable
+	if match := statementTableRe.FindStringSubmatch(query); match != nil {
+		table = strings.ToLower(match[1])
+	}
+	statementTables.Store(query, table)
+	return table
+}
+
+// TableStats are the counters of the statements persisted into a table since the start.
+type TableStats struct {
+	Queued       int64 `json:"queued"`
+	Executed     int64 `json:"executed"`
+	RowsAffected int64 `json:"rows_affected"`
+	Errors       int64 `json:"errors"`
+}
+
+// tableCounters are the counters of a table, updated atomically by the persister.
+type tableCounters struct {
+	queued       int64
+	executed     int64
+	rowsAffected int64
+	errors       int64
+}
+
+// persisterStats accounts the statements of the persister per table. Its methods are no-ops on a nil
+// receiver, so that the batches built outside the persister (i.e. in the tests) don't need it.
+type persisterStats struct {
+	m      sync.RWMutex
+	tables map[string]*tableCounters
+	// time of the last flush that persisted any statement (unix nanoseconds, atomic)
+	lastFlush int64
+}
+
+func newPersisterStats() *persisterStats {
+	return &persisterStats{
+		tables: make(map[string]*tableCounters),
+	}
+}
+
+// table returns the counters of the table, adding them if it had none yet.
+func (s *persisterStats) table(name string) *tableCounters {
+	s.m.RLock()
+	counters, ok := s.tables[name]
+	s.m.RUnlock()
+	if ok {
+		return counters
+	}
+	s.m.Lock()
+	defer s.m.Unlock()
+	if counters, ok = s.tables[name]; !ok {
+		counters = &tableCounters{}
+		s.tables[name] = counters
+	}
+	return counters
+}
+
+// queued counts a statement queued into a batch.
+func (s *persisterStats) queued(table string) {
+	if s == nil {
+		return
+	}
+	atomic.AddInt64(&s.table(table).queued, 1)
+}
+
+// executed counts a statement of a committed batch, with the rows that it affected.
+func (s *persisterStats) executed(table string, tag pgconn.CommandTag) {
+	if s == nil {
+		return
+	}
+	counters := s.table(table)
+	atomic.AddInt64(&counters.executed, 1)
+	atomic.AddInt64(&counters.rowsAffected, tag.RowsAffected())
+}
+
+// copied counts the rows of a committed bulk copy as executed statements, with the rows that it inserted.
+func (s *persisterStats) copied(table string, rows int, tag pgconn.CommandTag) {
+	if s == nil {
+		return
+	}
+	counters := s.table(table)
+	atomic.AddInt64(&counters.executed, int64(rows))
+	atomic.AddInt64(&counters.rowsAffected, tag.RowsAffected())
+}
+
+// failed counts a statement that the DB rejected.
+func (s *persisterStats) failed(table string) {
+	if s == nil {
+		return
+	}
+	atomic.AddInt64(&s.table(table).errors, 1)
+}
+
+// flushed records the time of a flush that persisted any statement.
+func (s *persisterStats) flushed(t time.Time) {
+	if s == nil {
+		return
+	}
+	atomic.StoreInt64(&s.lastFlush, t.UnixNano())
+}
+
+// lastFlushTime returns the time of the last flush that persisted any statement, zero if none did.
+func (s *persisterStats) lastFlushTime() time.Time {
+	if s == nil {
+		return time.Time{}
+	}
+	nanos := atomic.LoadInt64(&s.lastFlush)
+	if nanos == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, nanos)
+}
+
+// snapshot returns the current counters of every table.
+func (s *persisterStats) snapshot() map[string]TableStats {
+	tables := make(map[string]TableStats)
+	if s == nil {
+		return tables
+	}
+	s.m.RLock()
+	defer s.m.RUnlock()
+	for name, counters := range s.tables {
+		tables[name] = TableStats{
+			Queued:       atomic.LoadInt64(&counters.queued),
+			Executed:     atomic.LoadInt64(&counters.executed),
+			RowsAffected: atomic.LoadInt64(&counters.rowsAffected),
+			Errors:       atomic.LoadInt64(&counters.errors),
+		}
+	}
+	return tables
+}
@@ -0,0 +1,106 @@
+package postgresql
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/migalabs/armiarma/pkg/db/models"
+	"github.com/migalabs/armiarma/pkg/utils"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatementTable(t *testing.T) {
+	dbCli := &DBClient{Network: utils.EthereumNetwork}
+	pID, err := peer.Decode("12D3KooWLRPJAA5o6m3ZQbJsu9EVEFvLx2ke4cSg8LxpwYXmsd3d")
+	require.NoError(t, err)
+
+	q, _ := dbCli.UpsertHostInfo(models.NewHostInfo(pID, utils.EthereumNetwork))
+	require.Equal(t, "peer_info", statementTable(q))
+	// the upserts that notify the new peers are a CTE
+	dbCli.notifyNewPeers = true
+	q, _ = dbCli.UpsertHostInfo(models.NewHostInfo(pID, utils.EthereumNetwork))
+	require.Equal(t, "peer_info", statementTable(q))
+	q, _ = dbCli.UpdateConnAttempt(models.NewConnAttempt(pID, models.PossitiveAttempt, "None", false, false))
+	require.Equal(t, "conn_attempts", statementTable(q))
+	q, _ = dbCli.UpdateLastActivityTimestamp(pID, time.Now())
+	require.Equal(t, "peer_info", statementTable(q))
+	require.Equal(t, otherTable, statementTable("SELECT pg_sleep(0.1);"))
+}
+
+// persistStatsMix persists a known mix of items: 5 peer_info statements (2 host infos, one of them identified,
+// a peer info and the activity of the conn event), and one statement of each of the other tables.
+func persistStatsMix(t *testing.T, persist func(interface{})) {
+	pID, err := peer.Decode("12D3KooWLRPJAA5o6m3ZQbJsu9EVEFvLx2ke4cSg8LxpwYXmsd3d")
+	require.NoError(t, err)
+
+	persist(models.NewHostInfo(pID, utils.EthereumNetwork, models.WithIPAndPorts("18.223.219.100", 9000)))
+	identified := models.NewHostInfo(pID, utils.EthereumNetwork, models.WithIPAndPorts("18.223.219.100", 9000))
+	identified.PeerInfo = *models.NewPeerInfo(pID, "Lighthouse/v3.5.1-319cc61/x86_64-linux", "", nil, 0)
+	persist(identified)
+	persist(models.NewPeerInfo(pID, "Lighthouse/v3.5.1-319cc61/x86_64-linux", "", nil, 0))
+	persist(models.NewConnAttempt(pID, models.PossitiveAttempt, "None", false, false))
+	connEvent := models.NewConnEvent(pID)
+	connEvent.AddConnInfo(models.ConnInfo{ConnTime: time.Unix(1000, 0)})
+	connEvent.AddDisconn(models.EndConnInfo{DiscTime: time.Unix(1060, 0)})
+	persist(connEvent)
+	ipInfo := models.IpInfo{}
+	ipInfo.IP = "18.223.219.100"
+	persist(ipInfo)
+	persist(models.NewClientVersion("lighthouse", "v3.5.1", pID.String()))
+}
+
+func TestPersisterStatsQueued(t *testing.T) {
+	dbCli := &DBClient{
+		Network:           utils.EthereumNetwork,
+		persistCs:         newPersistChans(1),
+		persistConnEvents: true,
+		stats:             newPersisterStats(),
+	}
+	batch := NewQueryBatch(context.Background(), nil, batchSize, DefaultBatchTimeout)
+	batch.stats = dbCli.stats
+	persistStatsMix(t, func(item interface{}) {
+		dbCli.addToBatch(batch, item)
+	})
+	batch.queueLastActivity()
+
+	stats := dbCli.Stats()
+	require.Equal(t, map[string]TableStats{
+		"peer_info":       {Queued: 5},
+		"conn_attempts":   {Queued: 1},
+		"conn_events":     {Queued: 1},
+		"ips":             {Queued: 1},
+		"client_versions": {Queued: 1},
+	}, stats.Tables)
+	require.True(t, stats.LastFlush.IsZero())
+	require.Zero(t, stats.AvgBatchSize)
+
+	// a batch that was never sent doesn't count as executed
+	batch.cleanBatch()
+	require.Equal(t, int64(0), dbCli.Stats().Tables["peer_info"].Executed)
+}
+
+func TestPersisterStatsInPSQL(t *testing.T) {
+	dbCli, err := NewDBClient(context.Background(), utils.EthereumNetwork, loginStr, 24*time.Hour, WithReset())
+	require.NoError(t, err)
+	defer dbCli.Close()
+
+	persistStatsMix(t, func(item interface{}) {
+		require.NoError(t, dbCli.PersistToDB(item))
+	})
+	require.Eventually(t, func() bool {
+		return dbCli.Stats().Tables["client_versions"].Executed == 1 && dbCli.Stats().Tables["peer_info"].Executed == 5
+	}, 10*time.Second, 100*time.Millisecond)
+
+	stats := dbCli.Stats()
+	for table, expected := range map[string]int64{"peer_info": 5, "conn_attempts": 1, "conn_events": 1, "ips": 1, "client_versions": 1} {
+		require.Equal(t, expected, stats.Tables[table].Queued, table)
+		require.Equal(t, expected, stats.Tables[table].Executed, table)
+		require.Zero(t, stats.Tables[table].Errors, table)
+	}
+	require.Equal(t, int64(1), stats.Tables["conn_events"].RowsAffected)
+	require.Equal(t, int64(1), stats.Tables["ips"].RowsAffected)
+	require.False(t, stats.LastFlush.IsZero())
+	require.Greater(t, stats.AvgBatchSize, 0.0)
+}
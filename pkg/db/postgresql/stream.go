@@ -0,0 +1,68 @@
+package postgresql
+
+import (
+	"context"
+	"fmt"
+
+	pgx "github.com/jackc/pgx/v4"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// default number of rows fetched at once from the cursor of the streamed reads
+	DefaultFetchSize = 1000
+	streamCursor     = "armiarma_stream"
+)
+
+// StreamQuery reads the result of the query through a server-side cursor, fetching fetchSize rows at a time
+// and calling fn for each of them, so that the whole result is never held in memory.
+// The cursor lives in a dedicated connection (the persisters keep the pool), and the context
+// is checked between fetches. Returns the number of rows passed to fn.
+func (c *DBClient) StreamQuery(ctx context.Context, fn func(pgx.Rows) error, query string, args ...interface{}) (int64, error) {
+	var total int64
+	conn, err := c.connect(ctx)
+	if err != nil {
+		return total, errors.Wrap(err, "unable to connect the streamed read")
+	}
+	defer conn.Close(context.Background())
+
+	// cursors only live inside a transaction, which is read-only and rolled back at the end
+	tx, err := conn.BeginTx(ctx, pgx.TxOptions{AccessMode: pgx.ReadOnly})
+	if err != nil {
+		return total, errors.Wrap(err, "unable to begin the streamed read")
+	}
+	defer tx.Rollback(context.Background())
+
+	_, err = tx.Exec(ctx, "DECLARE "+streamCursor+" NO SCROLL CURSOR FOR "+query, args...)
+	if err != nil {
+		return total, errors.Wrap(err, "unable to declare the cursor of the streamed read")
+	}
+	fetchQuery := fmt.Sprintf("FETCH FORWARD %d FROM %s;", c.fetchSize, streamCursor)
+	for {
+		if err := ctx.Err(); err != nil {
+			log.Debugf("streamed read interrupted after %d rows", total)
+			return total, err
+		}
+		rows, err := tx.Query(ctx, fetchQuery)
+		if err != nil {
+			return total, errors.Wrap(err, "unable to fetch the next rows of the streamed read")
+		}
+		fetched := 0
+		for rows.Next() {
+			fetched++
+			total++
+			if err := fn(rows); err != nil {
+				rows.Close()
+				return total, err
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return total, errors.Wrap(err, "unable to read the rows of the streamed read")
+		}
+		if fetched < c.fetchSize {
+			return total, nil
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package postgresql
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// path of the HTTP endpoint that serves the top peers by messages (followed by "{topic}/top")
+	TopPeersEndpoint = "/stats/topics/"
+	// topic of the endpoint that aggregates the messages of all the topics
+	AllTopics = "all"
+	// number of peers and time window of the top peers when the request doesn't set them
+	DefaultTopPeers       = 10
+	DefaultTopPeersWindow = time.Hour
+)
+
+// PeerTopicCount is the number of messages received from a peer within a time window,
+// and its share (percentage) of all the messages received within the window.
+type PeerTopicCount struct {
+	PeerID     string  `json:"peer_id"`
+	ClientName string  `json:"client_name"`
+	Messages   int64   `json:"messages"`
+	Share      float64 `json:"share"`
+}
+
+// GetTopPeersByMessages returns the n peers that sent the most messages on the topic (by name, i.e.
+// "beacon_block") within the last window, or across all the topics if the topic is empty.
+// A zero window doesn't limit the time.
+func (c *DBClient) GetTopPeersByMessages(topic string, n int, window time.Duration) ([]PeerTopicCount, error) {
+	ctx, cancel := c.readCtx()
+	defer cancel()
+	log.Debugf("fetching top %d peers by messages on topic %q", n, topic)
+
+	var since time.Time
+	if window > 0 {
+		since = time.Now().Add(-window)
+	}
+	query, args := topPeersByMessagesQuery(topic, n, since, c.readNetwork())
+	rows, err := c.psqlPool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to fetch top peers by messages")
+	}
+	// make sure we close the rows and we free the connection/session
+	defer rows.Close()
+
+	counts := make([]PeerTopicCount, 0, n)
+	for rows.Next() {
+		var count PeerTopicCount
+		err = rows.Scan(&count.PeerID, &count.ClientName, &count.Messages, &count.Share)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to parse fetched top peer")
+		}
+		counts = append(counts, count)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "unable to fetch top peers by messages")
+	}
+	return counts, nil
+}
+
+// topPeersByMessagesQuery composes the query of the top peers by messages. The conditions match
+// the indexes of topic_metrics_deltas (topic name and to_time), and the shares are computed
+// against the total of the window before limiting the rows. The client name is read from the
+// peer_info of the network, empty for all of them.
+func topPeersByMessagesQuery(topic string, n int, since time.Time, network string) (query string, args []interface{}) {
+	conditions := make([]string, 0)
+	if topic != "" {
+		args = append(args, topic)
+		conditions = append(conditions, fmt.Sprintf("split_part(topic, '/', 4) = $%d", len(args)))
+	}
+	if !since.IsZero() {
+		args = append(args, since.Unix())
+		conditions = append(conditions, fmt.Sprintf("to_time >= $%d", len(args)))
+	}
+	args = append(args, n)
+	limitArg := len(args)
+	peerJoin := "p.peer_id = counts.peer_id"
+	if network != "" {
+		args = append(args, network)
+		peerJoin += fmt.Sprintf(" AND p.network = $%d", len(args))
+	}
+	query = `
+		WITH counts AS (
+			SELECT
+				peer_id,
+				sum(messages)::BIGINT AS messages
+			FROM topic_metrics_deltas` + whereClause(conditions) + `
+			GROUP BY peer_id
+		)
+		SELECT
+			counts.peer_id,
+			COALESCE(p.client_name, ''),
+			counts.messages,
+			COALESCE(100.0 * counts.messages / NULLIF(sum(counts.messages) OVER (), 0), 0)::float8
+		FROM counts
+		LEFT JOIN peer_info AS p ON ` + peerJoin + `
+		ORDER BY counts.messages DESC, counts.peer_id
+		LIMIT $` + strconv.Itoa(limitArg) + `;`
+	return query, args
+}
+
+// parseTopPeersRequest reads the topic (TopPeersEndpoint + "{topic}/top", AllTopics for all of them),
+// and the number of peers (n) and the time window (window, i.e. "30m", 0 doesn't limit it) from the request.
+func parseTopPeersRequest(r *http.Request) (topic string, n int, window time.Duration, err error) {
+	path := strings.TrimPrefix(r.URL.Path, TopPeersEndpoint)
+	if !strings.HasSuffix(path, "/top") {
+		return "", 0, 0, errors.New("unknown path " + r.URL.Path)
+	}
+	topic = strings.TrimSuffix(path, "/top")
+	if topic == "" || strings.Contains(topic, "/") {
+		return "", 0, 0, errors.New("invalid topic " + topic)
+	}
+	if topic == AllTopics {
+		topic = ""
+	}
+	params := r.URL.Query()
+	n = DefaultTopPeers
+	if v := params.Get("n"); v != "" {
+		n, err = strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return "", 0, 0, errors.New("invalid n " + v)
+		}
+	}
+	window = DefaultTopPeersWindow
+	if v := params.Get("window"); v != "" {
+		window, err = time.ParseDuration(v)
+		if err != nil || window < 0 {
+			return "", 0, 0, errors.New("invalid window " + v)
+		}
+	}
+	return topic, n, window, nil
+}
+
+// TopPeersHandler serves the top peers by messages of a topic as JSON (see parseTopPeersRequest).
+func (c *DBClient) TopPeersHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		topic, n, window, err := parseTopPeersRequest(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		counts, err := c.GetTopPeersByMessages(topic, n, window)
+		if err != nil {
+			log.Error(errors.Wrap(err, "unable to serve top peers"))
+			http.Error(w, "unable to read top peers", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		err = json.NewEncoder(w).Encode(counts)
+		if err != nil {
+			log.Debug(errors.Wrap(err, "unable to write top peers"))
+		}
+	})
+}
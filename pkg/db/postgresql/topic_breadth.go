@@ -0,0 +1,42 @@
+package postgresql
+
+import (
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// TopicBreadth is the number of non-deprecated peers that delivered messages on a single topic,
+// on 2 to 5, and on 6 or more distinct topics (subnets collapsed into their family).
+// The full-featured nodes gossip on many topics, the attestation-only listeners on one.
+type TopicBreadth struct {
+	Single int `json:"1"`
+	Few    int `json:"2-5"`
+	Many   int `json:"6+"`
+}
+
+// Total returns the number of peers that delivered messages on any topic.
+func (b TopicBreadth) Total() int {
+	return b.Single + b.Few + b.Many
+}
+
+// GetTopicBreadth returns the distribution of the peers by the number of distinct topics
+// on which they delivered messages.
+func (c *DBClient) GetTopicBreadth() (TopicBreadth, error) {
+	ctx, cancel := c.readCtx()
+	defer cancel()
+	log.Debug("fetching distribution of peers by distinct topics")
+
+	var breadth TopicBreadth
+	err := c.psqlPool.QueryRow(ctx, `
+		SELECT
+			count(*) FILTER (WHERE distinct_topics = 1),
+			count(*) FILTER (WHERE distinct_topics BETWEEN 2 AND 5),
+			count(*) FILTER (WHERE distinct_topics >= 6)
+		FROM peer_info
+		WHERE deprecated = 'false' AND `+networkCond("network", 1)+`;
+	`, c.readNetwork()).Scan(&breadth.Single, &breadth.Few, &breadth.Many)
+	if err != nil {
+		return TopicBreadth{}, errors.Wrap(err, "unable to fetch distribution of peers by distinct topics")
+	}
+	return breadth, nil
+}
@@ -0,0 +1,46 @@
+package postgresql
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/migalabs/armiarma/pkg/db/models"
+	"github.com/migalabs/armiarma/pkg/utils"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUpdateDistinctTopics(t *testing.T) {
+	dbCli := &DBClient{}
+	pID := peer.ID("peer")
+
+	query, args := dbCli.UpdateDistinctTopics(pID, models.DistinctTopics(3))
+	require.Contains(t, query, "SET distinct_topics=$2")
+	require.Equal(t, []interface{}{pID.String(), 3}, args)
+}
+
+func TestTopicBreadthInPSQL(t *testing.T) {
+	dbCli, err := NewDBClient(context.Background(), utils.EthereumNetwork, loginStr, 24*time.Hour, WithReset())
+	require.NoError(t, err)
+	defer dbCli.Close()
+
+	// one peer on a single topic, two on a few of them, and one on many
+	for i, topics := range []int{1, 2, 5, 8} {
+		_, pubKey, err := crypto.GenerateEd25519Key(rand.Reader)
+		require.NoError(t, err)
+		pID, err := peer.IDFromPublicKey(pubKey)
+		require.NoError(t, err)
+		hInfo := models.NewHostInfo(pID, utils.EthereumNetwork, models.WithIPAndPorts(fmt.Sprintf("95.217.33.%d", 10+i), 9000))
+		hInfo.AddAtt(models.DistinctTopicsAttribute, models.DistinctTopics(topics))
+		require.NoError(t, dbCli.PersistHostInfo(hInfo))
+	}
+
+	require.Eventually(t, func() bool {
+		breadth, err := dbCli.GetTopicBreadth()
+		return err == nil && breadth == TopicBreadth{Single: 1, Few: 2, Many: 1}
+	}, 10*time.Second, 100*time.Millisecond)
+}
@@ -0,0 +1,83 @@
+package postgresql
+
+import (
+	"github.com/migalabs/armiarma/pkg/db/models"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// InitTopicMetricsDeltasTable keeps the increments of the gossip messages of each peer and topic,
+// persisted periodically during the crawl.
+func (c *DBClient) InitTopicMetricsDeltasTable() error {
+	log.Debug("initializing topic_metrics_deltas table in psql-db")
+
+	err := c.execSchema(`
+		CREATE TABLE IF NOT EXISTS topic_metrics_deltas(
+			id SERIAL,
+			peer_id TEXT NOT NULL,
+			topic TEXT NOT NULL,
+			from_time BIGINT,
+			to_time BIGINT NOT NULL,
+			messages BIGINT NOT NULL,
+			bytes BIGINT NOT NULL,
+			first_deliveries BIGINT NOT NULL,
+			duplicates BIGINT NOT NULL,
+
+			PRIMARY KEY (id)
+		);
+		`)
+	if err != nil {
+		return errors.Wrap(err, "initializing topic_metrics_deltas table")
+	}
+
+	// the top peers by messages filter by the name of the topic (i.e. "beacon_block" for
+	// "/eth2/4a26c58b/beacon_block/ssz_snappy") and by the time window
+	err = c.execSchema(`
+		CREATE INDEX IF NOT EXISTS topic_metrics_deltas_name_time
+			ON topic_metrics_deltas(split_part(topic, '/', 4), to_time);
+		`)
+	if err != nil {
+		return errors.Wrap(err, "indexing topic_metrics_deltas by topic name")
+	}
+	err = c.execSchema(`
+		CREATE INDEX IF NOT EXISTS topic_metrics_deltas_time
+			ON topic_metrics_deltas(to_time);
+		`)
+	if err != nil {
+		return errors.Wrap(err, "indexing topic_metrics_deltas by time")
+	}
+	return nil
+}
+
+// InsertTopicMetricsDelta records the increment of the messages of the peer on the topic.
+func (c *DBClient) InsertTopicMetricsDelta(delta *models.TopicMetricsDelta) (query string, args []interface{}) {
+	log.Trace("inserting new topic metrics delta to topic_metrics_deltas in psql-db")
+	query = `
+		INSERT INTO topic_metrics_deltas(
+			peer_id,
+			topic,
+			from_time,
+			to_time,
+			messages,
+			bytes,
+			first_deliveries,
+			duplicates)
+		VALUES ($1,$2,$3,$4,$5,$6,$7,$8);
+		`
+
+	var fromTime interface{}
+	if !delta.From.IsZero() {
+		fromTime = delta.From.Unix()
+	}
+
+	args = append(args, delta.PeerID.String())
+	args = append(args, delta.Topic)
+	args = append(args, fromTime)
+	args = append(args, delta.To.Unix())
+	args = append(args, delta.Messages)
+	args = append(args, delta.Bytes)
+	args = append(args, delta.FirstDeliveries)
+	args = append(args, delta.Duplicates)
+
+	return query, args
+}
@@ -0,0 +1,142 @@
+package postgresql
+
+import (
+	"github.com/migalabs/armiarma/pkg/utils"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// Views that simplify the queries of the dashboards (Grafana) and the peer read APIs.
+// They are created with CREATE OR REPLACE so that an upgrade of the crawler refreshes them.
+
+// InitViews creates or refreshes all the views on top of the crawler tables.
+// It needs to be called after the tables have been initialized.
+func (c *DBClient) InitViews() error {
+	log.Debug("init views in psql-db")
+
+	err := c.InitPeerOverviewView()
+	if err != nil {
+		return err
+	}
+	err = c.InitClientDiversityView()
+	if err != nil {
+		return err
+	}
+	return c.InitDailySessionsView()
+}
+
+// InitPeerOverviewView creates the v_peer_overview view, which joins each peer_info
+// row with its location and (for Ethereum) its latest beacon status and metadata.
+func (c *DBClient) InitPeerOverviewView() error {
+	log.Debug("init v_peer_overview view in psql-db")
+
+	// the Ethereum status is only available when crawling the Ethereum network
+	ethColumns := `
+			NULL::BIGINT AS status_timestamp,
+			NULL::TEXT AS fork_digest,
+			NULL::BIGINT AS head_slot,
+			NULL::BIGINT AS finalized_epoch,
+			NULL::BIGINT AS seq_number,
+			NULL::TEXT AS attnets,
+			NULL::TEXT AS syncnets`
+	ethJoin := ""
+	if c.Network == utils.EthereumNetwork {
+		ethColumns = `
+			s.timestamp AS status_timestamp,
+			s.fork_digest,
+			s.head_slot,
+			s.finalized_epoch,
+			s.seq_number,
+			s.attnets,
+			s.syncnets`
+		ethJoin = `
+		LEFT JOIN eth_status AS s ON s.peer_id = p.peer_id AND s.network = p.network`
+	}
+
+	err := c.execSchema(`
+		CREATE OR REPLACE VIEW v_peer_overview AS
+		SELECT
+			p.peer_id,
+			p.network,
+			p.multi_addrs,
+			p.ip,
+			p.port,
+			p.user_agent,
+			p.client_name,
+			p.client_version,
+			p.client_os,
+			p.client_arch,
+			p.protocol_version,
+			p.sup_protocols,
+			p.latency,
+			p.deprecated,
+			p.attempted,
+			p.last_activity,
+			p.last_conn_attempt,
+			p.last_error,
+			i.country,
+			i.country_code,
+			i.city,
+			i.asname,
+			i.hosting,` + ethColumns + `,
+			p.relay_addrs,
+			p.relay_only,
+			p.attempts,
+			p.successful_attempts
+		FROM peer_info AS p
+		LEFT JOIN ips AS i ON i.ip = p.ip` + ethJoin + `;
+	`)
+	if err != nil {
+		return errors.Wrap(err, "initializing v_peer_overview view")
+	}
+	return nil
+}
+
+// InitClientDiversityView creates the v_client_diversity view, with the number of
+// non-deprecated peers per client, version and negotiated pubsub version (NULL if unknown)
+// of each network.
+func (c *DBClient) InitClientDiversityView() error {
+	log.Debug("init v_client_diversity view in psql-db")
+
+	err := c.execSchema(`
+		CREATE OR REPLACE VIEW v_client_diversity AS
+		SELECT
+			client_name,
+			client_version,
+			count(*) AS peers,
+			pubsub_version,
+			network
+		FROM peer_info
+		WHERE
+			deprecated = 'false' and
+			client_name IS NOT NULL
+		GROUP BY client_name, client_version, pubsub_version, network;
+	`)
+	if err != nil {
+		return errors.Wrap(err, "initializing v_client_diversity view")
+	}
+	return nil
+}
+
+// InitDailySessionsView creates the v_daily_sessions view, with the connection events of each
+// network bucketed by the day in which the connection started.
+func (c *DBClient) InitDailySessionsView() error {
+	log.Debug("init v_daily_sessions view in psql-db")
+
+	err := c.execSchema(`
+		CREATE OR REPLACE VIEW v_daily_sessions AS
+		SELECT
+			date_trunc('day', to_timestamp(conn_time)) AS day,
+			count(*) AS sessions,
+			count(DISTINCT peer_id) AS peers,
+			avg(duration) AS avg_duration_secs,
+			network
+		FROM conn_events
+		WHERE conn_time IS NOT NULL
+		GROUP BY 1, network;
+	`)
+	if err != nil {
+		return errors.Wrap(err, "initializing v_daily_sessions view")
+	}
+	return nil
+}
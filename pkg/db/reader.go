@@ -0,0 +1,178 @@
+package db
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/pkg/errors"
+)
+
+const (
+	// DefaultPeerQueryLimit is the number of peers of a PeerQuery that doesn't set any
+	DefaultPeerQueryLimit = 100
+	// MaxPeerQueryLimit is the maximum number of peers of a PeerQuery
+	MaxPeerQueryLimit = 1000
+)
+
+// ErrPeerNotFound is returned when the peer is not in the peer_info table.
+var ErrPeerNotFound = errors.New("peer not found")
+
+// ErrInvalidCursor is returned for the cursors that weren't generated by a Reader.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Reader is the read path of the storages of the crawl that can be queried while crawling
+// (the postgresql DBClient and the SQLite DB). The queries are bound by the given context.
+type Reader interface {
+	// QueryPeers returns the page of the peers that match the query, sorted by last activity (newest first)
+	QueryPeers(ctx context.Context, query PeerQuery) (PeerPage, error)
+	// GetPeerDetails returns the peer_info, latest ENR and Status of the peer, or ErrPeerNotFound
+	GetPeerDetails(ctx context.Context, pID peer.ID) (*PeerDetails, error)
+	// GetCrawlSummary returns the aggregate counts of the stored peers
+	GetCrawlSummary(ctx context.Context) (CrawlSummary, error)
+}
+
+// PeerQuery selects and pages the peers of QueryPeers. The zero value of each filter doesn't filter.
+// The pages are either given by Offset, or by the Cursor of the previous page (which is stable
+// while new peers are stored), but not by both.
+type PeerQuery struct {
+	// exact client name (i.e. "lighthouse")
+	Client string
+	// ISO code or name of the country of the peer's IP (i.e. "DE")
+	Country string
+	// whether the peer was connected at least once (it has any activity)
+	Connected *bool
+	// deprecated state of the peers
+	Deprecated *bool
+
+	// peers per page (DefaultPeerQueryLimit if unset, at most MaxPeerQueryLimit)
+	Limit  int
+	Offset int
+	// NextCursor of the previous page, empty for the first page
+	Cursor string
+}
+
+// PageLimit returns the limit of the query within (0, MaxPeerQueryLimit].
+func (q PeerQuery) PageLimit() int {
+	if q.Limit <= 0 {
+		return DefaultPeerQueryLimit
+	}
+	if q.Limit > MaxPeerQueryLimit {
+		return MaxPeerQueryLimit
+	}
+	return q.Limit
+}
+
+// PeerPage is a page of the peers that match a PeerQuery.
+type PeerPage struct {
+	Peers []PeerEntry `json:"peers"`
+	// cursor of the following page, empty if this is the last one
+	NextCursor string `json:"next_cursor"`
+}
+
+// PeerEntry is the peer_info row of a peer, along with the location of its IP.
+type PeerEntry struct {
+	PeerID             string    `json:"peer_id"`
+	Network            string    `json:"network"`
+	IP                 string    `json:"ip"`
+	Country            string    `json:"country"`
+	CountryCode        string    `json:"country_code"`
+	ClientName         string    `json:"client_name"`
+	ClientVersion      string    `json:"client_version"`
+	UserAgent          string    `json:"user_agent"`
+	LatencyMs          int64     `json:"latency_ms"`
+	Deprecated         bool      `json:"deprecated"`
+	Attempted          bool      `json:"attempted"`
+	Connected          bool      `json:"connected"`
+	Attempts           int       `json:"attempts"`
+	SuccessfulAttempts int       `json:"successful_attempts"`
+	LastError          string    `json:"last_error"`
+	FirstActivity      time.Time `json:"first_activity"`
+	LastActivity       time.Time `json:"last_activity"`
+}
+
+// PeerDetails is everything that the peer lists show about a peer, plus its Ethereum data.
+// The sections without data of the peer are nil.
+type PeerDetails struct {
+	Peer   PeerEntry    `json:"peer"`
+	Enr    *EnrEntry    `json:"enr"`
+	Status *StatusEntry `json:"status"`
+}
+
+// EnrEntry is the latest eth_nodes row (ENR) of a peer.
+type EnrEntry struct {
+	Timestamp  time.Time `json:"timestamp"`
+	NodeID     string    `json:"node_id"`
+	Seq        uint64    `json:"seq"`
+	IP         string    `json:"ip"`
+	TCP        int       `json:"tcp"`
+	UDP        int       `json:"udp"`
+	ForkDigest string    `json:"fork_digest"`
+	Attnets    string    `json:"attnets"`
+	Syncnets   string    `json:"syncnets"`
+	Enr        string    `json:"enr"`
+}
+
+// StatusEntry is the eth_status row (latest beacon Status and MetaData) of a peer.
+type StatusEntry struct {
+	Timestamp      time.Time `json:"timestamp"`
+	ForkDigest     string    `json:"fork_digest"`
+	FinalizedRoot  string    `json:"finalized_root"`
+	FinalizedEpoch uint64    `json:"finalized_epoch"`
+	HeadRoot       string    `json:"head_root"`
+	HeadSlot       uint64    `json:"head_slot"`
+	SeqNumber      uint64    `json:"seq_number"`
+	Attnets        string    `json:"attnets"`
+	Syncnets       string    `json:"syncnets"`
+}
+
+// CrawlSummary are the aggregate counts of the stored peers. The clients and countries
+// only count the non-deprecated peers that were identified or located.
+type CrawlSummary struct {
+	Peers      int64            `json:"peers"`
+	Deprecated int64            `json:"deprecated"`
+	Attempted  int64            `json:"attempted"`
+	Connected  int64            `json:"connected"`
+	Identified int64            `json:"identified"`
+	Clients    map[string]int64 `json:"clients"`
+	Countries  map[string]int64 `json:"countries"`
+}
+
+// PeerCursor is the position of a peer in the pages of QueryPeers.
+type PeerCursor struct {
+	// unix time of the last activity of the peer (0 if none)
+	LastActivity int64
+	PeerID       string
+}
+
+// NewPeerCursor returns the cursor of the page that follows the given peer.
+func NewPeerCursor(entry PeerEntry) PeerCursor {
+	cursor := PeerCursor{PeerID: entry.PeerID}
+	if !entry.LastActivity.IsZero() {
+		cursor.LastActivity = entry.LastActivity.Unix()
+	}
+	return cursor
+}
+
+// String encodes the cursor as "{last_activity}.{peer_id}".
+func (c PeerCursor) String() string {
+	return strconv.FormatInt(c.LastActivity, 10) + "." + c.PeerID
+}
+
+// ParsePeerCursor decodes a cursor encoded by PeerCursor.String, or returns ErrInvalidCursor.
+func ParsePeerCursor(s string) (PeerCursor, error) {
+	idx := strings.Index(s, ".")
+	if idx < 0 {
+		return PeerCursor{}, ErrInvalidCursor
+	}
+	lastActivity, err := strconv.ParseInt(s[:idx], 10, 64)
+	if err != nil || lastActivity < 0 {
+		return PeerCursor{}, ErrInvalidCursor
+	}
+	if _, err = peer.Decode(s[idx+1:]); err != nil {
+		return PeerCursor{}, ErrInvalidCursor
+	}
+	return PeerCursor{LastActivity: lastActivity, PeerID: s[idx+1:]}, nil
+}
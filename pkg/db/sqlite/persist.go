@@ -0,0 +1,625 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/migalabs/armiarma/pkg/db/models"
+	eth "github.com/migalabs/armiarma/pkg/networks/ethereum"
+	"github.com/migalabs/armiarma/pkg/utils"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// persistItem writes the item within the transaction of the batch.
+func (d *DB) persistItem(ctx context.Context, tx *sql.Tx, obj interface{}) error {
+	exec := func(q string, args []interface{}) error {
+		_, err := tx.ExecContext(ctx, q, args...)
+		return err
+	}
+	switch item := obj.(type) {
+	case *models.HostInfo:
+		if err := exec(upsertHostInfo(item)); err != nil {
+			return err
+		}
+		if item.IsHostIdentified() {
+			if err := exec(d.updatePeerInfo(&item.PeerInfo)); err != nil {
+				return err
+			}
+		}
+		// the peers synced from the crawler's store carry their attempt aggregates and activity
+		if item.ControlInfo.Attempted {
+			if err := exec(updateAttemptAggregates(item.ID, item.ControlInfo)); err != nil {
+				return err
+			}
+		}
+		if !item.ControlInfo.LastActivity.IsZero() {
+			if err := exec(updateLastActivity(item.ID, item.ControlInfo.LastActivity)); err != nil {
+				return err
+			}
+		}
+		item.RLock()
+		attrs := make([]interface{}, 0, len(item.Attr))
+		for _, att := range item.Attr {
+			attrs = append(attrs, att)
+		}
+		item.RUnlock()
+		for _, att := range attrs {
+			var err error
+			switch att := att.(type) {
+			case eth.BeaconStatusStamped:
+				err = exec(upsertEthStatus(att))
+			case eth.BeaconMetadataStamped:
+				err = exec(upsertEthMetadata(att))
+			case *eth.EnrNode:
+				err = exec(upsertEnr(att))
+			default:
+				log.Tracef("attribute %T of peer %s not kept in sqlite", att, item.ID.String())
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case *models.PeerInfo:
+		return exec(d.updatePeerInfo(item))
+
+	case *models.ConnectionAttempt:
+		if item.ID == "" {
+			// without an id we can't detect the replays of the attempt, but we still count it
+			item.ID = uuid.NewString()
+		}
+		// the attempt is only applied to peer_info if its id wasn't recorded before
+		res, err := tx.ExecContext(ctx, `
+			INSERT OR IGNORE INTO conn_attempts(
+				attempt_id,
+				peer_id,
+				timestamp,
+				status,
+				error)
+			VALUES (?,?,?,?,?);`,
+			item.ID, item.RemotePeer.String(), item.Timestamp.Unix(), string(item.Status), item.Error)
+		if err != nil {
+			return err
+		}
+		if inserted, err := res.RowsAffected(); err != nil || inserted == 0 {
+			return err
+		}
+		return exec(updateConnAttempt(item))
+
+	case *models.ConnEvent:
+		if d.persistConnEvents {
+			if err := exec(insertConnEvent(item)); err != nil {
+				return err
+			}
+		}
+		return exec(updateLastActivity(item.PeerID, item.DiscTime))
+
+	case models.IpInfo:
+		return exec(upsertIpInfo(item))
+
+	case *models.ClientVersion:
+		// only the first time that we saw the version is kept
+		return exec(`
+			INSERT OR IGNORE INTO client_versions(
+				client_name,
+				client_version,
+				first_peer,
+				first_seen)
+			VALUES (?,?,?,?);`,
+			[]interface{}{item.Name, item.Version, item.FirstPeer, item.FirstSeen.Unix()})
+
+	case *models.Deprecation:
+		return exec(updateDeprecation(item))
+
+	default:
+		return errors.Wrapf(ErrUnknownPersistable, "%T", obj)
+	}
+}
+
+// updateDeprecation marks the peer as deprecated, unless it was active after the InactiveSince
+// of the deprecation or it belongs to a foreign network, as the postgresql UpdateDeprecation.
+func updateDeprecation(deprecation *models.Deprecation) (string, []interface{}) {
+	q := `
+		UPDATE peer_info
+		SET deprecated=true
+		WHERE peer_id=?1 and (?3 OR COALESCE(last_activity, 0) < ?2);`
+	var inactiveSince int64
+	if !deprecation.InactiveSince.IsZero() {
+		inactiveSince = deprecation.InactiveSince.Unix()
+	}
+	return q, []interface{}{deprecation.PeerID.String(), inactiveSince, deprecation.ForeignNetwork}
+}
+
+// upsertHostInfo inserts the peer into peer_info, or updates its addresses if it already existed.
+// The new addresses are merged with the stored ones, as in the postgresql UpsertHostInfo, and
+// empty IP or port never overwrite the ones that were already stored, and neither does a missing
+// quality score.
+func upsertHostInfo(hInfo *models.HostInfo) (string, []interface{}) {
+	hInfo.RLock()
+	relayAddrs := hInfo.RelayAddrs
+	hInfo.RUnlock()
+	// direct and circuit-relay addresses are stored separately, in their canonical form
+	_, directAddrStrs := utils.CanonicalMAddrs(hInfo.DirectAddrs())
+	_, relayAddrStrs := utils.CanonicalMAddrs(relayAddrs)
+	hasAddrs := len(directAddrStrs)+len(relayAddrStrs) > 0
+
+	q := `
+		INSERT INTO peer_info(
+			peer_id,
+			network,
+			multi_addrs,
+			relay_addrs,
+			relay_only,
+			ip,
+			port,
+			deprecated,
+			quality_score)
+		VALUES (?1,?2,?3,?4,?5,?6,?7,?8,?9)
+		ON CONFLICT (peer_id)
+		DO UPDATE SET
+			multi_addrs = ` + mergedMAddrs("multi_addrs") + `,
+			relay_addrs = ` + mergedMAddrs("relay_addrs") + `,
+			relay_only = CASE WHEN ?10 THEN excluded.relay_only ELSE peer_info.relay_only END,
+			ip = COALESCE(NULLIF(excluded.ip, ''), peer_info.ip),
+			port = CASE WHEN excluded.port > 0 THEN excluded.port ELSE peer_info.port END,
+			deprecated = excluded.deprecated,
+			quality_score = COALESCE(excluded.quality_score, peer_info.quality_score);`
+	args := []interface{}{
+		hInfo.ID.String(),
+		string(hInfo.Network),
+		jsonArray(directAddrStrs),
+		jsonArray(relayAddrStrs),
+		hInfo.RelayOnly,
+		hInfo.IP,
+		hInfo.Port,
+		hInfo.ControlInfo.Deprecated,
+		hostQualityScore(hInfo),
+		hasAddrs,
+	}
+	return q, args
+}
+
+// mergedMAddrs returns the expression of the upserted multiaddresses of the column (a JSON array)
+// merged with the stored ones: the new ones first, followed by the ones that the peer stopped
+// advertising, up to models.MaxStoredMAddrs of them.
+func mergedMAddrs(column string) string {
+	return `(
+				SELECT json_group_array(maddr) FROM (
+					SELECT value AS maddr
+					FROM (
+						SELECT value, key AS i FROM json_each(excluded.` + column + `)
+						UNION ALL
+						SELECT value, ` + strconv.Itoa(math.MaxInt32) + ` + key FROM json_each(COALESCE(peer_info.` + column + `, '[]')))
+					GROUP BY value
+					ORDER BY MIN(i)
+					LIMIT ` + strconv.Itoa(models.MaxStoredMAddrs) + `))`
+}
+
+// hostQualityScore returns the quality score attribute of the host, nil (NULL) if it has none.
+func hostQualityScore(hInfo *models.HostInfo) *float64 {
+	hInfo.RLock()
+	defer hInfo.RUnlock()
+	score, ok := hInfo.Attr[models.QualityScoreAttribute].(models.QualityScore)
+	if !ok {
+		return nil
+	}
+	value := float64(score)
+	return &value
+}
+
+// updatePeerInfo updates the identification of the peer, as the postgresql UpdatePeerInfo (a
+// partial identification never overwrites the values that were already stored).
+func (d *DB) updatePeerInfo(pInfo *models.PeerInfo) (string, []interface{}) {
+	q := `
+		UPDATE peer_info
+		SET
+			user_agent=COALESCE(NULLIF(?2, ''), user_agent),
+			client_name=COALESCE(?3, client_name),
+			client_version=COALESCE(?4, client_version),
+			client_os=COALESCE(?5, client_os),
+			client_arch=COALESCE(?6, client_arch),
+			protocol_version=COALESCE(NULLIF(?7, ''), protocol_version),
+			sup_protocols=COALESCE(?8, sup_protocols),
+			latency=COALESCE(?9, latency),
+			peer_category=COALESCE(?10, peer_category),
+			user_agent_sanitized=COALESCE(?11, user_agent_sanitized),
+			client_confidence=COALESCE(?12, client_confidence)
+		WHERE peer_id=?1;`
+
+	userAgent, sanitized := utils.SanitizeUserAgent(pInfo.UserAgent)
+	sanitized = sanitized || pInfo.UserAgentSanitized
+	// the client columns follow the user agent (NULL to keep the previous ones)
+	var cliName, cliVers, cliOS, cliArch, category, confidence, uaSanitized interface{}
+	if userAgent != "" {
+		cliInfo := utils.ParseClient(d.network, userAgent)
+		cliName, cliVers, cliOS, cliArch = cliInfo.Name, cliInfo.Version, cliInfo.OS, cliInfo.Arch
+		category = string(utils.ParsePeerCategory(cliInfo.Name))
+		confidence = cliInfo.Confidence
+		uaSanitized = sanitized
+	}
+	var protocols interface{}
+	if len(pInfo.Protocols) > 0 {
+		protocols = jsonArray(pInfo.Protocols)
+	}
+	// the latency measured through a relay is the relay's, keep the previous one
+	var latency interface{}
+	if !pInfo.Relayed && pInfo.Latency > 0 {
+		latency = pInfo.Latency.Milliseconds()
+	}
+	args := []interface{}{
+		pInfo.RemotePeer.String(),
+		userAgent,
+		cliName,
+		cliVers,
+		cliOS,
+		cliArch,
+		pInfo.ProtocolVersion,
+		protocols,
+		latency,
+		category,
+		uaSanitized,
+		confidence,
+	}
+	return q, args
+}
+
+// updateConnAttempt updates the attempt status and counters of the peer (once the attempt was
+// recorded in conn_attempts). A successful attempt un-deprecates the peer. The activity and the
+// last attempt never move back in time, as in the postgresql UpdateConnAttempt.
+func updateConnAttempt(connAttempt *models.ConnectionAttempt) (string, []interface{}) {
+	var successes, failures int
+	activity := ""
+	if connAttempt.Status == models.PossitiveAttempt {
+		successes = 1
+		activity = `
+			first_activity=MIN(COALESCE(first_activity, ?3), ?3),
+			last_activity=MAX(COALESCE(last_activity, ?3), ?3),`
+	} else {
+		failures = 1
+	}
+	q := `
+		UPDATE peer_info
+		SET
+			deprecated=?2,
+			attempted=true,` + activity + `
+			last_error=CASE WHEN COALESCE(last_conn_attempt, 0) <= ?3 THEN ?4 ELSE last_error END,
+			last_conn_attempt=MAX(COALESCE(last_conn_attempt, ?3), ?3),
+			attempts=attempts + 1,
+			successful_attempts=successful_attempts + ?5,
+			failed_attempts=failed_attempts + ?6
+		WHERE peer_id=?1;`
+	args := []interface{}{
+		connAttempt.RemotePeer.String(),
+		connAttempt.Status != models.PossitiveAttempt && connAttempt.Deprecable,
+		connAttempt.Timestamp.Unix(),
+		connAttempt.Error,
+		successes,
+		failures,
+	}
+	return q, args
+}
+
+// updateAttemptAggregates updates the connection attempt aggregates of the peer with the ones of its
+// ControlInfo, never lowering the counters, as the postgresql UpdateAttemptAggregates.
+func updateAttemptAggregates(peerID peer.ID, cInfo models.ControlInfo) (string, []interface{}) {
+	q := `
+		UPDATE peer_info
+		SET
+			attempted=true,
+			attempts=MAX(attempts, ?2),
+			successful_attempts=MAX(successful_attempts, ?3),
+			failed_attempts=MAX(failed_attempts, ?4),
+			last_error=CASE WHEN COALESCE(last_conn_attempt, 0) <= ?5 THEN ?6 ELSE last_error END,
+			last_conn_attempt=MAX(COALESCE(last_conn_attempt, 0), ?5)
+		WHERE peer_id=?1;`
+	var lastAttempt int64
+	if !cInfo.LastConnAttempt.IsZero() {
+		lastAttempt = cInfo.LastConnAttempt.Unix()
+	}
+	args := []interface{}{
+		peerID.String(),
+		cInfo.Attempts,
+		cInfo.SuccessfulAttempts,
+		cInfo.Attempts - cInfo.SuccessfulAttempts,
+		lastAttempt,
+		cInfo.LastError,
+	}
+	return q, args
+}
+
+func updateLastActivity(peerID peer.ID, t time.Time) (string, []interface{}) {
+	q := `
+		UPDATE peer_info
+		SET
+			first_activity=MIN(COALESCE(first_activity, ?2), ?2),
+			last_activity=MAX(COALESCE(last_activity, ?2), ?2)
+		WHERE peer_id=?1;`
+	return q, []interface{}{peerID.String(), t.Unix()}
+}
+
+// insertConnEvent appends the connection event, ignoring the replays of the same event (event_key).
+func insertConnEvent(connEv *models.ConnEvent) (string, []interface{}) {
+	q := `
+		INSERT OR IGNORE INTO conn_events(
+			peer_id,
+			direction,
+			conn_time,
+			latency,
+			disconn_time,
+			identified,
+			error,
+			timestamp_anomaly,
+			goodbye_reason,
+			local_addr,
+			remote_addr,
+			relayed,
+			event_key)
+		VALUES (?,?,?,?,?,?,?,?,NULLIF(?, ''),NULLIF(?, ''),NULLIF(?, ''),?,?);`
+
+	// never persist a disconnection older than its connection
+	var discTime interface{} = connEv.DiscTime.Unix()
+	anomaly := connEv.TimestampAnomaly || connEv.DiscTime.Before(connEv.ConnTime)
+	if anomaly {
+		discTime = nil
+	}
+	args := []interface{}{
+		connEv.PeerID.String(),
+		models.DirectionIndexToString(connEv.Direction),
+		connEv.ConnTime.Unix(),
+		connEv.Latency.Milliseconds(),
+		discTime,
+		connEv.Identified,
+		connEv.Error,
+		anomaly,
+		connEv.GoodbyeReason,
+		connEv.LocalAddr,
+		connEv.RemoteAddr,
+		connEv.Relayed,
+		connEv.Key(),
+	}
+	return q, args
+}
+
+// upsertIpInfo inserts or updates the location of the IP, keeping the stored record (and its expiration)
+// on a sparse lookup (without country code), as the postgresql UpsertIpInfo.
+func upsertIpInfo(ipInfo models.IpInfo) (string, []interface{}) {
+	q := `
+		INSERT INTO ips(
+			ip,
+			expiration_time,
+			continent,
+			continent_code,
+			country,
+			country_code,
+			region,
+			region_name,
+			city,
+			zip,
+			lat,
+			lon,
+			isp,
+			org,
+			as_raw,
+			asname,
+			mobile,
+			proxy,
+			hosting,
+			provider,
+			source,
+			resolved_at)
+		VALUES (?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?)
+		ON CONFLICT (ip)
+		DO UPDATE SET
+			expiration_time = CASE WHEN excluded.country_code = '' THEN ips.expiration_time ELSE excluded.expiration_time END,
+			continent = CASE WHEN excluded.country_code = '' THEN ips.continent ELSE excluded.continent END,
+			continent_code = CASE WHEN excluded.country_code = '' THEN ips.continent_code ELSE excluded.continent_code END,
+			country = CASE WHEN excluded.country_code = '' THEN ips.country ELSE excluded.country END,
+			country_code = CASE WHEN excluded.country_code = '' THEN ips.country_code ELSE excluded.country_code END,
+			region = CASE WHEN excluded.country_code = '' THEN ips.region ELSE excluded.region END,
+			region_name = CASE WHEN excluded.country_code = '' THEN ips.region_name ELSE excluded.region_name END,
+			city = CASE WHEN excluded.country_code = '' THEN ips.city ELSE excluded.city END,
+			zip = CASE WHEN excluded.country_code = '' THEN ips.zip ELSE excluded.zip END,
+			lat = CASE WHEN excluded.country_code = '' THEN ips.lat ELSE excluded.lat END,
+			lon = CASE WHEN excluded.country_code = '' THEN ips.lon ELSE excluded.lon END,
+			isp = CASE WHEN excluded.country_code = '' THEN ips.isp ELSE excluded.isp END,
+			org = CASE WHEN excluded.country_code = '' THEN ips.org ELSE excluded.org END,
+			as_raw = CASE WHEN excluded.country_code = '' THEN ips.as_raw ELSE excluded.as_raw END,
+			asname = CASE WHEN excluded.country_code = '' THEN ips.asname ELSE excluded.asname END,
+			mobile = CASE WHEN excluded.country_code = '' THEN ips.mobile ELSE excluded.mobile END,
+			proxy = CASE WHEN excluded.country_code = '' THEN ips.proxy ELSE excluded.proxy END,
+			hosting = CASE WHEN excluded.country_code = '' THEN ips.hosting ELSE excluded.hosting END,
+			provider = CASE WHEN excluded.country_code = '' THEN ips.provider ELSE excluded.provider END,
+			source = CASE WHEN excluded.country_code = '' THEN ips.source ELSE excluded.source END,
+			resolved_at = CASE WHEN excluded.country_code = '' THEN ips.resolved_at ELSE excluded.resolved_at END;`
+	// NULL for the locations that don't know when they were resolved
+	var resolvedAt interface{}
+	if !ipInfo.ResolvedAt.IsZero() {
+		resolvedAt = ipInfo.ResolvedAt.Unix()
+	}
+	args := []interface{}{
+		ipInfo.IP,
+		ipInfo.ExpirationTime.Unix(),
+		ipInfo.Continent,
+		ipInfo.ContinentCode,
+		ipInfo.Country,
+		ipInfo.CountryCode,
+		ipInfo.Region,
+		ipInfo.RegionName,
+		ipInfo.City,
+		ipInfo.Zip,
+		ipInfo.Lat,
+		ipInfo.Lon,
+		ipInfo.Isp,
+		ipInfo.Org,
+		ipInfo.As,
+		ipInfo.AsName,
+		ipInfo.Mobile,
+		ipInfo.Proxy,
+		ipInfo.Hosting,
+		ipInfo.Provider,
+		ipInfo.Source,
+		resolvedAt,
+	}
+	return q, args
+}
+
+// upsertEnr inserts the ENR of the node, updating it only if the new one has a higher seq number
+// (stale copies of the ENR handed by the discovery never overwrite a newer one). The eth2, attnets
+// and syncnets columns are NULL for the ENRs that don't advertise them, as in postgresql.
+func upsertEnr(enr *eth.EnrNode) (string, []interface{}) {
+	q := `
+		INSERT INTO eth_nodes(
+			timestamp,
+			peer_id,
+			node_id,
+			seq,
+			ip,
+			tcp,
+			udp,
+			pubkey,
+			fork_digest,
+			next_fork_version,
+			next_fork_epoch,
+			attnets,
+			attnets_number,
+			attnets_mask,
+			syncnets,
+			syncnets_number,
+			syncnets_mask,
+			enr,
+			foreign_network)
+		VALUES (?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?)
+		ON CONFLICT (node_id)
+		DO UPDATE SET
+			timestamp = excluded.timestamp,
+			seq = excluded.seq,
+			ip = excluded.ip,
+			tcp = excluded.tcp,
+			udp = excluded.udp,
+			fork_digest = excluded.fork_digest,
+			next_fork_version = excluded.next_fork_version,
+			next_fork_epoch = excluded.next_fork_epoch,
+			attnets = excluded.attnets,
+			attnets_number = excluded.attnets_number,
+			attnets_mask = excluded.attnets_mask,
+			syncnets = excluded.syncnets,
+			syncnets_number = excluded.syncnets_number,
+			syncnets_mask = excluded.syncnets_mask,
+			enr = excluded.enr,
+			foreign_network = excluded.foreign_network
+		WHERE excluded.seq > eth_nodes.seq;`
+
+	var peerIDStr string
+	if peerID, err := enr.GetPeerID(); err == nil {
+		peerIDStr = peerID.String()
+	}
+	var ip string
+	if enr.IP != nil {
+		ip = enr.IP.String()
+	}
+	args := []interface{}{
+		enr.Timestamp.Unix(),
+		peerIDStr,
+		enr.ID.String(),
+		int64(enr.Seq),
+		ip,
+		enr.TCP,
+		enr.UDP,
+		enr.GetPubkeyString(),
+	}
+	if enr.HasEth2Data {
+		var nextForkEpoch interface{}
+		// the FAR_FUTURE_EPOCH (no fork scheduled) doesn't fit in a BIGINT
+		if uint64(enr.Eth2Data.NextForkEpoch) <= math.MaxInt64 {
+			nextForkEpoch = int64(enr.Eth2Data.NextForkEpoch)
+		}
+		args = append(args, enr.Eth2Data.ForkDigest.String(), enr.Eth2Data.NextForkVersion.String(), nextForkEpoch)
+	} else {
+		args = append(args, nil, nil, nil)
+	}
+	if mask, ok := enr.AttnetsMask(); ok {
+		args = append(args, enr.GetAttnetsString(), enr.Attnets.NetNumber, int64(mask))
+	} else {
+		args = append(args, nil, nil, nil)
+	}
+	if mask, ok := enr.SyncnetsMask(); ok {
+		args = append(args, enr.GetSyncnetsString(), enr.Syncnets.NetNumber, int64(mask))
+	} else {
+		args = append(args, nil, nil, nil)
+	}
+	args = append(args, enr.Raw, enr.ForeignNetwork)
+	return q, args
+}
+
+func upsertEthStatus(bstatus eth.BeaconStatusStamped) (string, []interface{}) {
+	q := `
+		INSERT INTO eth_status(
+			peer_id,
+			timestamp,
+			fork_digest,
+			finalized_root,
+			finalized_epoch,
+			head_root,
+			head_slot)
+		VALUES (?,?,?,?,?,?,?)
+		ON CONFLICT (peer_id)
+		DO UPDATE SET
+			timestamp = excluded.timestamp,
+			fork_digest = excluded.fork_digest,
+			finalized_root = excluded.finalized_root,
+			finalized_epoch = excluded.finalized_epoch,
+			head_root = excluded.head_root,
+			head_slot = excluded.head_slot;`
+	args := []interface{}{
+		bstatus.PeerID.String(),
+		bstatus.Timestamp.Unix(),
+		bstatus.Status.ForkDigest.String(),
+		bstatus.Status.FinalizedRoot.String(),
+		int64(bstatus.Status.FinalizedEpoch),
+		bstatus.Status.HeadRoot.String(),
+		int64(bstatus.Status.HeadSlot),
+	}
+	return q, args
+}
+
+func upsertEthMetadata(bmetadata eth.BeaconMetadataStamped) (string, []interface{}) {
+	q := `
+		INSERT INTO eth_status(
+			peer_id,
+			timestamp,
+			seq_number,
+			attnets,
+			syncnets)
+		VALUES (?,?,?,?,?)
+		ON CONFLICT (peer_id)
+		DO UPDATE SET
+			timestamp = excluded.timestamp,
+			seq_number = excluded.seq_number,
+			attnets = excluded.attnets,
+			syncnets = excluded.syncnets;`
+	args := []interface{}{
+		bmetadata.PeerID.String(),
+		bmetadata.Timestamp.Unix(),
+		int64(bmetadata.Metadata.SeqNumber),
+		bmetadata.Metadata.Attnets.String(),
+		bmetadata.Metadata.Syncnets.String(),
+	}
+	return q, args
+}
+
+// jsonArray returns the JSON text of the strings, the SQLite counterpart of the postgresql arrays.
+func jsonArray(strs []string) string {
+	if strs == nil {
+		strs = []string{}
+	}
+	b, _ := json.Marshal(strs)
+	return string(b)
+}
@@ -0,0 +1,217 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/migalabs/armiarma/pkg/db/models"
+	"github.com/migalabs/armiarma/pkg/utils"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// ReadIpInfo returns the location of the IP, or ErrNotFound if it wasn't located yet.
+func (d *DB) ReadIpInfo(ip string) (models.IpInfo, error) {
+	var ipInfo models.IpInfo
+	var expiration int64
+	var resolvedAt sql.NullInt64
+	err := d.sqlDB.QueryRowContext(d.ctx, `
+		SELECT
+			ip,
+			expiration_time,
+			continent,
+			continent_code,
+			country,
+			country_code,
+			region,
+			region_name,
+			city,
+			zip,
+			lat,
+			lon,
+			isp,
+			org,
+			as_raw,
+			asname,
+			mobile,
+			proxy,
+			hosting,
+			provider,
+			source,
+			resolved_at
+		FROM ips
+		WHERE ip=?;`, ip).Scan(
+		&ipInfo.IP,
+		&expiration,
+		&ipInfo.Continent,
+		&ipInfo.ContinentCode,
+		&ipInfo.Country,
+		&ipInfo.CountryCode,
+		&ipInfo.Region,
+		&ipInfo.RegionName,
+		&ipInfo.City,
+		&ipInfo.Zip,
+		&ipInfo.Lat,
+		&ipInfo.Lon,
+		&ipInfo.Isp,
+		&ipInfo.Org,
+		&ipInfo.As,
+		&ipInfo.AsName,
+		&ipInfo.Mobile,
+		&ipInfo.Proxy,
+		&ipInfo.Hosting,
+		&ipInfo.Provider,
+		&ipInfo.Source,
+		&resolvedAt,
+	)
+	if err == sql.ErrNoRows {
+		return models.IpInfo{}, errors.Wrap(ErrNotFound, "ip_info of "+ip)
+	}
+	if err != nil {
+		return models.IpInfo{}, errors.Wrap(err, "unable to read ip_info of "+ip)
+	}
+	ipInfo.Status = "success"
+	ipInfo.ExpirationTime = time.Unix(expiration, 0)
+	if resolvedAt.Valid {
+		ipInfo.ResolvedAt = time.Unix(resolvedAt.Int64, 0)
+	}
+	return ipInfo, nil
+}
+
+// CheckIpRecords checks if a given IP is already stored and whether its TTL has expired.
+// The records without a country code are reported as expired, so that they get backfilled on the next refresh.
+func (d *DB) CheckIpRecords(ip string) (exists bool, expired bool, err error) {
+	var expiration int64
+	var countryCode string
+	err = d.sqlDB.QueryRowContext(d.ctx, `
+		SELECT expiration_time, country_code
+		FROM ips
+		WHERE ip=?;`, ip).Scan(&expiration, &countryCode)
+	if err == sql.ErrNoRows {
+		return false, false, nil
+	}
+	if err != nil {
+		return false, false, errors.Wrap(err, "unable to check ip_info of "+ip)
+	}
+	return true, expiration < time.Now().Unix() || countryCode == "", nil
+}
+
+// GetExpiredIpInfo returns all the IPs whose TTL has already expired (or that were stored without a country code).
+func (d *DB) GetExpiredIpInfo() ([]string, error) {
+	expIps := make([]string, 0)
+	rows, err := d.sqlDB.QueryContext(d.ctx, `
+		SELECT ip
+		FROM ips
+		WHERE expiration_time < ? OR country_code = ''
+		ORDER BY ip;`, time.Now().Unix())
+	if err != nil {
+		return expIps, errors.Wrap(err, "unable to get expired ip records")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var ip string
+		if err := rows.Scan(&ip); err != nil {
+			return expIps, errors.Wrap(err, "error parsing readed row for expired ip records")
+		}
+		expIps = append(expIps, ip)
+	}
+	return expIps, rows.Err()
+}
+
+// ForEachNonDeprecatedPeer calls fn for each of the peers that are not deprecated, with both its
+// direct and circuit-relay addresses. The peers whose stored multiaddresses don't parse anymore
+// are skipped with a warning. Returns the number of read peers.
+func (d *DB) ForEachNonDeprecatedPeer(ctx context.Context, fn func(*models.RemoteConnectablePeer) error) (int64, error) {
+	// the peers are read before calling fn, so that fn can persist into the DB (single connection)
+	peers, err := d.nonDeprecatedPeers(ctx)
+	if err != nil {
+		return 0, errors.Wrap(err, "unable to retrieve peers in the network")
+	}
+	var total int64
+	for _, connectable := range peers {
+		if err := ctx.Err(); err != nil {
+			return total, errors.Wrap(err, "unable to retrieve peers in the network")
+		}
+		total++
+		if err := fn(connectable); err != nil {
+			return total, errors.Wrap(err, "unable to retrieve peers in the network")
+		}
+	}
+	return total, nil
+}
+
+func (d *DB) nonDeprecatedPeers(ctx context.Context) ([]*models.RemoteConnectablePeer, error) {
+	rows, err := d.sqlDB.QueryContext(ctx, `
+		SELECT
+			peer_id,
+			network,
+			multi_addrs,
+			COALESCE(relay_addrs, '[]')
+		FROM peer_info
+		WHERE NOT COALESCE(deprecated, false);`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	peers := make([]*models.RemoteConnectablePeer, 0)
+	for rows.Next() {
+		var peerIDStr, networkStr, mAddrsJson, relayAddrsJson string
+		if err := rows.Scan(&peerIDStr, &networkStr, &mAddrsJson, &relayAddrsJson); err != nil {
+			return nil, err
+		}
+		peerID, err := peer.Decode(peerIDStr)
+		if err != nil {
+			log.Errorf("unable to get peerID from DB %s \n", peerIDStr)
+			continue
+		}
+		var mAddrStrs, relayAddrStrs []string
+		if err := json.Unmarshal([]byte(mAddrsJson), &mAddrStrs); err != nil {
+			return nil, errors.Wrap(err, "unable to read the multi_addrs of peer "+peerIDStr)
+		}
+		if err := json.Unmarshal([]byte(relayAddrsJson), &relayAddrStrs); err != nil {
+			return nil, errors.Wrap(err, "unable to read the relay_addrs of peer "+peerIDStr)
+		}
+		// parse the multiaddress (the invalid ones are skipped)
+		maddrs, _, err := utils.ParseCanonicalMAddrs(append(mAddrStrs, relayAddrStrs...))
+		if err != nil {
+			if len(maddrs) == 0 {
+				log.Warn(errors.Wrap(err, "skipping peer "+peerIDStr+" without valid multiaddresses"))
+				continue
+			}
+			log.Warn(errors.Wrap(err, "dropping invalid multiaddress of peer "+peerIDStr))
+		}
+		peers = append(peers, models.NewRemoteConnectablePeer(peerID, maddrs, utils.NetworkType(networkStr)))
+	}
+	return peers, rows.Err()
+}
+
+// GetClientVersions returns the client versions that were already seen, ordered by name and version.
+func (d *DB) GetClientVersions() ([]*models.ClientVersion, error) {
+	cliVersions := make([]*models.ClientVersion, 0)
+	rows, err := d.sqlDB.QueryContext(d.ctx, `
+		SELECT
+			client_name,
+			client_version,
+			COALESCE(first_peer, ''),
+			first_seen
+		FROM client_versions
+		ORDER BY client_name, client_version;`)
+	if err != nil {
+		return cliVersions, errors.Wrap(err, "unable to get client versions")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		cliVersion := &models.ClientVersion{}
+		var firstSeen int64
+		if err := rows.Scan(&cliVersion.Name, &cliVersion.Version, &cliVersion.FirstPeer, &firstSeen); err != nil {
+			return cliVersions, errors.Wrap(err, "unable to read client version")
+		}
+		cliVersion.FirstSeen = time.Unix(firstSeen, 0)
+		cliVersions = append(cliVersions, cliVersion)
+	}
+	return cliVersions, rows.Err()
+}
@@ -0,0 +1,306 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/migalabs/armiarma/pkg/db"
+	"github.com/pkg/errors"
+)
+
+var _ db.Reader = (*DB)(nil)
+
+// QueryPeers returns the page of the peers that match the query, sorted by last activity (newest first).
+func (d *DB) QueryPeers(ctx context.Context, query db.PeerQuery) (db.PeerPage, error) {
+	q, args, err := queryPeersQuery(query)
+	if err != nil {
+		return db.PeerPage{}, err
+	}
+	rows, err := d.sqlDB.QueryContext(ctx, q, args...)
+	if err != nil {
+		return db.PeerPage{}, errors.Wrap(err, "unable to query peers")
+	}
+	defer rows.Close()
+
+	limit := query.PageLimit()
+	page := db.PeerPage{
+		Peers: make([]db.PeerEntry, 0, limit),
+	}
+	for rows.Next() {
+		entry, err := scanPeerEntry(rows)
+		if err != nil {
+			return db.PeerPage{}, errors.Wrap(err, "unable to parse queried peer")
+		}
+		page.Peers = append(page.Peers, entry)
+	}
+	if err = rows.Err(); err != nil {
+		return db.PeerPage{}, errors.Wrap(err, "unable to query peers")
+	}
+	// one peer more than the limit is read to know whether there is a following page
+	if len(page.Peers) > limit {
+		page.Peers = page.Peers[:limit]
+		page.NextCursor = db.NewPeerCursor(page.Peers[limit-1]).String()
+	}
+	return page, nil
+}
+
+// peerEntryColumns are the columns of a db.PeerEntry (see scanPeerEntry), from peer_info (p) and ips (i).
+const peerEntryColumns = `
+			p.peer_id,
+			p.network,
+			p.ip,
+			COALESCE(i.country, ''),
+			COALESCE(i.country_code, ''),
+			COALESCE(p.client_name, ''),
+			COALESCE(p.client_version, ''),
+			COALESCE(p.user_agent, ''),
+			COALESCE(p.latency, 0),
+			COALESCE(p.deprecated, false),
+			COALESCE(p.attempted, false),
+			p.attempts,
+			p.successful_attempts,
+			COALESCE(p.last_error, ''),
+			COALESCE(p.first_activity, 0),
+			COALESCE(p.last_activity, 0)`
+
+func scanPeerEntry(row interface{ Scan(...interface{}) error }) (db.PeerEntry, error) {
+	var entry db.PeerEntry
+	var firstActivity, lastActivity int64
+	err := row.Scan(
+		&entry.PeerID,
+		&entry.Network,
+		&entry.IP,
+		&entry.Country,
+		&entry.CountryCode,
+		&entry.ClientName,
+		&entry.ClientVersion,
+		&entry.UserAgent,
+		&entry.LatencyMs,
+		&entry.Deprecated,
+		&entry.Attempted,
+		&entry.Attempts,
+		&entry.SuccessfulAttempts,
+		&entry.LastError,
+		&firstActivity,
+		&lastActivity,
+	)
+	if err != nil {
+		return db.PeerEntry{}, err
+	}
+	entry.Connected = lastActivity > 0
+	entry.FirstActivity = unixOrZero(firstActivity)
+	entry.LastActivity = unixOrZero(lastActivity)
+	return entry, nil
+}
+
+// queryPeersQuery composes the query of a page of peers (plus one to detect the following page).
+func queryPeersQuery(query db.PeerQuery) (string, []interface{}, error) {
+	conditions := make([]string, 0)
+	args := make([]interface{}, 0)
+	if query.Client != "" {
+		args = append(args, query.Client)
+		conditions = append(conditions, fmt.Sprintf("p.client_name = ?%d", len(args)))
+	}
+	if query.Country != "" {
+		args = append(args, query.Country)
+		conditions = append(conditions, fmt.Sprintf("(i.country_code = UPPER(?%d) OR i.country = ?%d)", len(args), len(args)))
+	}
+	if query.Connected != nil {
+		op := "="
+		if *query.Connected {
+			op = ">"
+		}
+		conditions = append(conditions, "COALESCE(p.last_activity, 0) "+op+" 0")
+	}
+	if query.Deprecated != nil {
+		args = append(args, *query.Deprecated)
+		conditions = append(conditions, fmt.Sprintf("COALESCE(p.deprecated, false) = ?%d", len(args)))
+	}
+	if query.Cursor != "" {
+		if query.Offset > 0 {
+			return "", nil, errors.New("the pages are either given by offset or by cursor")
+		}
+		cursor, err := db.ParsePeerCursor(query.Cursor)
+		if err != nil {
+			return "", nil, err
+		}
+		args = append(args, cursor.LastActivity, cursor.PeerID)
+		conditions = append(conditions, fmt.Sprintf(
+			"(COALESCE(p.last_activity, 0) < ?%d OR (COALESCE(p.last_activity, 0) = ?%d AND p.peer_id > ?%d))",
+			len(args)-1, len(args)-1, len(args)))
+	}
+	where := ""
+	if len(conditions) > 0 {
+		where = `
+		WHERE ` + strings.Join(conditions, " AND ")
+	}
+	args = append(args, query.PageLimit()+1, query.Offset)
+	q := `
+		SELECT` + peerEntryColumns + `
+		FROM peer_info AS p
+		LEFT JOIN ips AS i ON i.ip = p.ip` + where + `
+		ORDER BY COALESCE(p.last_activity, 0) DESC, p.peer_id
+		LIMIT ?` + fmt.Sprint(len(args)-1) + ` OFFSET ?` + fmt.Sprint(len(args)) + `;`
+	return q, args, nil
+}
+
+// GetPeerDetails returns the peer_info, latest ENR and Status of the peer, or db.ErrPeerNotFound.
+func (d *DB) GetPeerDetails(ctx context.Context, pID peer.ID) (*db.PeerDetails, error) {
+	details := &db.PeerDetails{}
+	row := d.sqlDB.QueryRowContext(ctx, `
+		SELECT`+peerEntryColumns+`
+		FROM peer_info AS p
+		LEFT JOIN ips AS i ON i.ip = p.ip
+		WHERE p.peer_id=?;`, pID.String())
+	var err error
+	details.Peer, err = scanPeerEntry(row)
+	if err == sql.ErrNoRows {
+		return nil, db.ErrPeerNotFound
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to read peer_info of peer "+pID.String())
+	}
+
+	enr := &db.EnrEntry{}
+	var timestamp int64
+	err = d.sqlDB.QueryRowContext(ctx, `
+		SELECT
+			timestamp,
+			node_id,
+			seq,
+			ip,
+			COALESCE(tcp, 0),
+			COALESCE(udp, 0),
+			COALESCE(fork_digest, ''),
+			COALESCE(attnets, ''),
+			COALESCE(syncnets, ''),
+			COALESCE(enr, '')
+		FROM eth_nodes
+		WHERE peer_id=?
+		ORDER BY timestamp DESC
+		LIMIT 1;`, pID.String()).Scan(
+		&timestamp,
+		&enr.NodeID,
+		&enr.Seq,
+		&enr.IP,
+		&enr.TCP,
+		&enr.UDP,
+		&enr.ForkDigest,
+		&enr.Attnets,
+		&enr.Syncnets,
+		&enr.Enr,
+	)
+	switch {
+	case err == nil:
+		enr.Timestamp = time.Unix(timestamp, 0)
+		details.Enr = enr
+	case err != sql.ErrNoRows:
+		return nil, errors.Wrap(err, "unable to read eth_nodes of peer "+pID.String())
+	}
+
+	status := &db.StatusEntry{}
+	err = d.sqlDB.QueryRowContext(ctx, `
+		SELECT
+			COALESCE(timestamp, 0),
+			COALESCE(fork_digest, ''),
+			COALESCE(finalized_root, ''),
+			COALESCE(finalized_epoch, 0),
+			COALESCE(head_root, ''),
+			COALESCE(head_slot, 0),
+			COALESCE(seq_number, 0),
+			COALESCE(attnets, ''),
+			COALESCE(syncnets, '')
+		FROM eth_status
+		WHERE peer_id=?;`, pID.String()).Scan(
+		&timestamp,
+		&status.ForkDigest,
+		&status.FinalizedRoot,
+		&status.FinalizedEpoch,
+		&status.HeadRoot,
+		&status.HeadSlot,
+		&status.SeqNumber,
+		&status.Attnets,
+		&status.Syncnets,
+	)
+	switch {
+	case err == nil:
+		status.Timestamp = unixOrZero(timestamp)
+		details.Status = status
+	case err != sql.ErrNoRows:
+		return nil, errors.Wrap(err, "unable to read eth_status of peer "+pID.String())
+	}
+	return details, nil
+}
+
+// GetCrawlSummary returns the aggregate counts of the stored peers.
+func (d *DB) GetCrawlSummary(ctx context.Context) (db.CrawlSummary, error) {
+	summary := db.CrawlSummary{
+		Clients:   make(map[string]int64),
+		Countries: make(map[string]int64),
+	}
+	err := d.sqlDB.QueryRowContext(ctx, `
+		SELECT
+			COUNT(*),
+			COALESCE(SUM(COALESCE(deprecated, false)), 0),
+			COALESCE(SUM(COALESCE(attempted, false)), 0),
+			COALESCE(SUM(COALESCE(last_activity, 0) > 0), 0),
+			COALESCE(SUM(COALESCE(client_name, '') <> ''), 0)
+		FROM peer_info;`).Scan(
+		&summary.Peers,
+		&summary.Deprecated,
+		&summary.Attempted,
+		&summary.Connected,
+		&summary.Identified,
+	)
+	if err != nil {
+		return db.CrawlSummary{}, errors.Wrap(err, "unable to count peers")
+	}
+	err = d.scanCounts(ctx, summary.Clients, `
+		SELECT client_name, COUNT(*)
+		FROM peer_info
+		WHERE NOT COALESCE(deprecated, false) AND COALESCE(client_name, '') <> ''
+		GROUP BY client_name;`)
+	if err != nil {
+		return db.CrawlSummary{}, errors.Wrap(err, "unable to count peers per client")
+	}
+	err = d.scanCounts(ctx, summary.Countries, `
+		SELECT i.country_code, COUNT(*)
+		FROM peer_info AS p
+		JOIN ips AS i ON i.ip = p.ip
+		WHERE NOT COALESCE(p.deprecated, false) AND i.country_code <> ''
+		GROUP BY i.country_code;`)
+	if err != nil {
+		return db.CrawlSummary{}, errors.Wrap(err, "unable to count peers per country")
+	}
+	return summary, nil
+}
+
+// scanCounts reads the (key, count) rows of the query into counts.
+func (d *DB) scanCounts(ctx context.Context, counts map[string]int64, query string) error {
+	rows, err := d.sqlDB.QueryContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var key string
+		var count int64
+		if err := rows.Scan(&key, &count); err != nil {
+			return err
+		}
+		counts[key] = count
+	}
+	return rows.Err()
+}
+
+// unixOrZero returns the time of the unix timestamp, or the zero time for a 0 timestamp.
+func unixOrZero(timestamp int64) time.Time {
+	if timestamp == 0 {
+		return time.Time{}
+	}
+	return time.Unix(timestamp, 0)
+}
@@ -0,0 +1,154 @@
+package sqlite
+
+import (
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// tables are the SQLite equivalents of the postgresql tables that the DB writes to. The arrays
+// are kept as JSON texts, and the times as unix seconds.
+var tables = []struct {
+	name   string
+	schema string
+}{
+	{"peer_info", `
+		CREATE TABLE IF NOT EXISTS peer_info(
+			peer_id TEXT NOT NULL PRIMARY KEY,
+			network TEXT NOT NULL,
+			multi_addrs TEXT NOT NULL,
+			relay_addrs TEXT,
+			relay_only BOOL,
+			ip TEXT NOT NULL,
+			port INT,
+
+			user_agent TEXT,
+			client_name TEXT,
+			client_version TEXT,
+			client_os TEXT,
+			client_arch TEXT,
+			peer_category TEXT,
+			protocol_version TEXT,
+			sup_protocols TEXT,
+			latency INT,
+			user_agent_sanitized BOOL,
+			client_confidence REAL,
+
+			deprecated BOOL,
+			attempted BOOL,
+			first_activity BIGINT,
+			last_activity BIGINT,
+			last_conn_attempt BIGINT,
+			last_error TEXT,
+			quality_score REAL,
+			attempts INT NOT NULL DEFAULT 0,
+			successful_attempts INT NOT NULL DEFAULT 0,
+			failed_attempts INT NOT NULL DEFAULT 0
+		);`},
+	{"conn_events", `
+		CREATE TABLE IF NOT EXISTS conn_events(
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			peer_id TEXT NOT NULL,
+			direction TEXT NOT NULL,
+			conn_time BIGINT NOT NULL,
+			latency BIGINT,
+			disconn_time BIGINT,
+			identified BOOL,
+			error TEXT NOT NULL,
+			timestamp_anomaly BOOL,
+			goodbye_reason TEXT,
+			local_addr TEXT,
+			remote_addr TEXT,
+			relayed BOOL,
+			event_key TEXT UNIQUE
+		);`},
+	{"conn_attempts", `
+		CREATE TABLE IF NOT EXISTS conn_attempts(
+			attempt_id TEXT NOT NULL PRIMARY KEY,
+			peer_id TEXT NOT NULL,
+			timestamp BIGINT NOT NULL,
+			status TEXT NOT NULL,
+			error TEXT
+		);`},
+	{"ips", `
+		CREATE TABLE IF NOT EXISTS ips(
+			ip TEXT NOT NULL PRIMARY KEY,
+			expiration_time BIGINT NOT NULL,
+			continent TEXT NOT NULL,
+			continent_code TEXT NOT NULL,
+			country TEXT NOT NULL,
+			country_code TEXT NOT NULL,
+			region TEXT NOT NULL,
+			region_name TEXT NOT NULL,
+			city TEXT NOT NULL,
+			zip TEXT NOT NULL,
+			lat REAL NOT NULL,
+			lon REAL NOT NULL,
+			isp TEXT NOT NULL,
+			org TEXT NOT NULL,
+			as_raw TEXT NOT NULL,
+			asname TEXT NOT NULL,
+			mobile BOOL NOT NULL,
+			proxy BOOL NOT NULL,
+			hosting BOOL NOT NULL,
+			provider TEXT NOT NULL DEFAULT '',
+			source TEXT NOT NULL DEFAULT '',
+			resolved_at BIGINT
+		);`},
+	{"client_versions", `
+		CREATE TABLE IF NOT EXISTS client_versions(
+			client_name TEXT NOT NULL,
+			client_version TEXT NOT NULL,
+			first_peer TEXT,
+			first_seen BIGINT NOT NULL,
+
+			PRIMARY KEY (client_name, client_version)
+		);`},
+	{"eth_nodes", `
+		CREATE TABLE IF NOT EXISTS eth_nodes(
+			node_id TEXT NOT NULL PRIMARY KEY,
+			timestamp BIGINT NOT NULL,
+			peer_id TEXT,
+			seq BIGINT NOT NULL,
+			ip TEXT NOT NULL,
+			tcp INT,
+			udp INT,
+			pubkey TEXT NOT NULL,
+			fork_digest TEXT,
+			next_fork_version TEXT,
+			next_fork_epoch BIGINT,
+			attnets TEXT,
+			attnets_number INT,
+			attnets_mask BIGINT,
+			syncnets TEXT,
+			syncnets_number INT,
+			syncnets_mask INT,
+			enr TEXT,
+			foreign_network BOOL DEFAULT false,
+
+			UNIQUE(peer_id, pubkey)
+		);`},
+	{"eth_status", `
+		CREATE TABLE IF NOT EXISTS eth_status(
+			peer_id TEXT NOT NULL PRIMARY KEY,
+			timestamp BIGINT,
+			fork_digest TEXT,
+			finalized_root TEXT,
+			finalized_epoch BIGINT,
+			head_root TEXT,
+			head_slot BIGINT,
+			seq_number BIGINT,
+			attnets TEXT,
+			syncnets TEXT
+		);`},
+}
+
+// initTables creates the tables that are missing.
+func (d *DB) initTables() error {
+	for _, table := range tables {
+		log.Debugf("init %s table in sqlite-db", table.name)
+		if _, err := d.sqlDB.ExecContext(d.ctx, table.schema); err != nil {
+			return errors.Wrap(err, "initializing "+table.name+" table")
+		}
+	}
+	return nil
+}
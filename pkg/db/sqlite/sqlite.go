@@ -0,0 +1,366 @@
+// Package sqlite persists the crawl into a single SQLite file, for the local runs that don't
+// need a postgresql server.
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/migalabs/armiarma/pkg/db"
+	"github.com/migalabs/armiarma/pkg/db/models"
+	"github.com/migalabs/armiarma/pkg/gossipsub"
+	eth "github.com/migalabs/armiarma/pkg/networks/ethereum"
+	"github.com/migalabs/armiarma/pkg/utils"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	// registers the sqlite3 driver
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const (
+	// Scheme of the endpoints of the SQLite DBs (i.e. sqlite://crawl.db)
+	Scheme = "sqlite://"
+
+	batchFlushingTimeout = 1 * time.Second
+	batchSize            = 512
+)
+
+// ShutdownTimeout bounds the time that Close gives the persister to flush what it has left.
+var ShutdownTimeout = 10 * time.Second
+
+var _ db.Persister = (*DB)(nil)
+
+// ErrUnknownPersistable is returned when persisting an item that the DB can't store.
+var ErrUnknownPersistable = errors.New("unknown type of item to persist")
+
+// ErrClosed is returned when persisting through a DB that was already closed.
+var ErrClosed = errors.New("sqlite db closed")
+
+// ErrNotFound is returned when reading an item that isn't in the DB.
+var ErrNotFound = errors.New("not found")
+
+// DB is the SQLite implementation of the db.Persister. It keeps the equivalent of the peer_info,
+// conn_events, conn_attempts, ips, client_versions, eth_nodes and eth_status tables of the
+// postgresql DBClient, with the same upsert semantics. The rest of the peer attributes, the gossip
+// messages and the topic deltas are validated and dropped.
+// As SQLite has a single writer, the items are persisted by a single persister, which writes
+// each batch in a transaction.
+type DB struct {
+	ctx     context.Context
+	network utils.NetworkType
+	path    string
+	sqlDB   *sql.DB
+
+	persistC chan interface{}
+	doneC    chan struct{}
+	wg       sync.WaitGroup
+	// no more items are queued once closed (the senders hold the read lock)
+	closeM    sync.RWMutex
+	closed    bool
+	closeOnce sync.Once
+
+	persistConnEvents bool
+
+	// avoid flooding the logs with the same error when the file can't be written
+	errSampler *utils.ErrorSampler
+	// number of batches that failed to be persisted (atomic)
+	batchErrors int64
+	// number of items dropped for being queued after closing the DB (atomic)
+	droppedItems int64
+}
+
+// Option configures the DB.
+type Option func(*DB) error
+
+// WithConnectionEventsPersist sets whether the conn_events are stored (the last activity of
+// the peers is updated anyway).
+func WithConnectionEventsPersist(persist bool) Option {
+	return func(d *DB) error {
+		d.persistConnEvents = persist
+		return nil
+	}
+}
+
+// IsEndpoint returns whether the endpoint is the one of a SQLite DB.
+func IsEndpoint(endpoint string) bool {
+	return strings.HasPrefix(endpoint, Scheme)
+}
+
+// NewDB opens (creating it if needed) the SQLite file of the endpoint (sqlite://path), with its
+// tables, and launches the persister.
+func NewDB(ctx context.Context, network utils.NetworkType, endpoint string, opts ...Option) (*DB, error) {
+	if !IsEndpoint(endpoint) {
+		return nil, errors.New("sqlite endpoint " + endpoint + " doesn't start with " + Scheme)
+	}
+	path := strings.TrimPrefix(endpoint, Scheme)
+	if path == "" {
+		return nil, errors.New("empty path of the sqlite endpoint")
+	}
+	d := &DB{
+		ctx:               ctx,
+		network:           network,
+		path:              path,
+		persistC:          make(chan interface{}, batchSize),
+		doneC:             make(chan struct{}),
+		persistConnEvents: true,
+		errSampler:        utils.NewErrorSampler(utils.DefaultErrorSampleWindow, nil),
+	}
+	for _, opt := range opts {
+		if err := opt(d); err != nil {
+			return nil, err
+		}
+	}
+
+	sqlDB, err := sql.Open("sqlite3", "file:"+path+"?_journal_mode=WAL&_busy_timeout=5000")
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to open sqlite db "+path)
+	}
+	// a single connection, as SQLite has a single writer anyway
+	sqlDB.SetMaxOpenConns(1)
+	if err := sqlDB.PingContext(ctx); err != nil {
+		sqlDB.Close()
+		return nil, errors.Wrap(err, "unable to open sqlite db "+path)
+	}
+	d.sqlDB = sqlDB
+	if err := d.initTables(); err != nil {
+		sqlDB.Close()
+		return nil, errors.Wrap(err, "unable to initialize the tables of sqlite db "+path)
+	}
+	log.WithFields(log.Fields{
+		"path": path,
+	}).Debug("successful connection to sqlite DB")
+
+	d.launchPersister()
+	return d, nil
+}
+
+// queue sends the item to the persister. The items queued once the DB is closed (or its context
+// is done) are dropped with ErrClosed.
+func (d *DB) queue(item interface{}) error {
+	d.closeM.RLock()
+	defer d.closeM.RUnlock()
+	if d.closed || d.ctx.Err() != nil {
+		atomic.AddInt64(&d.droppedItems, 1)
+		return ErrClosed
+	}
+	select {
+	case d.persistC <- item:
+		return nil
+	case <-d.ctx.Done():
+		atomic.AddInt64(&d.droppedItems, 1)
+		return ErrClosed
+	}
+}
+
+func (d *DB) launchPersister() {
+	logEntry := log.WithFields(log.Fields{
+		"mod": "sqlite-persister",
+	})
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+
+		// items to persist in the next transaction
+		batch := make([]interface{}, 0, batchSize)
+
+		// batch flushing ticker
+		ticker := time.NewTicker(batchFlushingTimeout)
+		defer ticker.Stop()
+
+	persistingLoop:
+		for {
+			select {
+			case <-d.ctx.Done():
+				logEntry.Info("context died, clossing persister")
+				break persistingLoop
+			case <-d.doneC:
+				logEntry.Info("closed detected, clossing persister")
+				break persistingLoop
+
+			case obj := <-d.persistC:
+				batch = append(batch, obj)
+				if len(batch) >= batchSize {
+					logEntry.Debug("batch full, ready to persist")
+					batch = d.flush(context.Background(), batch)
+				}
+
+			case <-ticker.C:
+				logEntry.Trace("ticker jumped - flushing content of batch")
+				batch = d.flush(context.Background(), batch)
+				d.errSampler.FlushExpired()
+			}
+		}
+
+		// persist what is left, with its own deadline as the context might be done already
+		ctx, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+		defer cancel()
+		for len(d.persistC) > 0 && ctx.Err() == nil {
+			batch = append(batch, <-d.persistC)
+			if len(batch) >= batchSize {
+				batch = d.flush(ctx, batch)
+			}
+		}
+		d.flush(ctx, batch)
+	}()
+}
+
+// flush writes the batch in a single transaction, returning the emptied batch. The items whose
+// statements fail are logged and skipped, without discarding the rest of the batch.
+func (d *DB) flush(ctx context.Context, batch []interface{}) []interface{} {
+	if len(batch) == 0 {
+		return batch
+	}
+	err := d.persistBatch(ctx, batch)
+	if err != nil {
+		atomic.AddInt64(&d.batchErrors, 1)
+		d.errSampler.Error(err)
+	}
+	return batch[:0]
+}
+
+func (d *DB) persistBatch(ctx context.Context, batch []interface{}) error {
+	tx, err := d.sqlDB.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "unable to begin sqlite transaction")
+	}
+	for _, item := range batch {
+		if err := d.persistItem(ctx, tx, item); err != nil {
+			d.errSampler.Error(errors.Wrapf(err, "unable to persist %T", item))
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		tx.Rollback()
+		return errors.Wrap(err, "unable to commit sqlite transaction")
+	}
+	return nil
+}
+
+// Close stops the persister once it flushed the queued items (see ShutdownTimeout), and closes
+// the file. It can be called more than once, and concurrently with the Persist methods, which
+// return ErrClosed afterwards.
+func (d *DB) Close() {
+	d.closeOnce.Do(d.close)
+}
+
+func (d *DB) close() {
+	d.closeM.Lock()
+	d.closed = true
+	d.closeM.Unlock()
+
+	close(d.doneC)
+	d.wg.Wait()
+	d.errSampler.Flush()
+	atomic.AddInt64(&d.droppedItems, int64(len(d.persistC)))
+	if err := d.sqlDB.Close(); err != nil {
+		log.Error(errors.Wrap(err, "unable to close sqlite db "+d.path))
+	}
+}
+
+// PersistToDB validates the item and queues it to be persisted, dispatching the known models
+// to their typed Persist methods. Unknown types are rejected with ErrUnknownPersistable.
+func (d *DB) PersistToDB(persItem interface{}) error {
+	switch item := persItem.(type) {
+	case *models.HostInfo:
+		return d.PersistHostInfo(item)
+	case *models.PeerInfo:
+		return d.PersistPeerInfo(item)
+	case *models.ConnEvent:
+		return d.PersistConnEvent(item)
+	case *models.ConnectionAttempt:
+		return d.PersistConnAttempt(item)
+	case models.IpInfo:
+		return d.PersistIpInfo(item)
+	case *models.ClientVersion:
+		if item == nil {
+			return errors.New("nil client_version")
+		}
+		return d.queue(item)
+	case *models.Deprecation:
+		if item == nil || item.PeerID == "" {
+			return errors.New("deprecation without peer_id")
+		}
+		return d.queue(item)
+	case *models.TopicMetricsDelta:
+		if item == nil || item.PeerID == "" || item.Topic == "" {
+			return errors.New("topic_metrics_delta without peer_id or topic")
+		}
+		return nil
+	case gossipsub.PersistableMsg:
+		switch item.(type) {
+		case *eth.TrackedAttestation, *eth.TrackedBeaconBlock, *eth.TrackedLightClientUpdate:
+			return nil
+		default:
+			return errors.Wrapf(ErrUnknownPersistable, "gossip message %T", persItem)
+		}
+	default:
+		return errors.Wrapf(ErrUnknownPersistable, "%T", persItem)
+	}
+}
+
+// PersistHostInfo queues the host info (and its attributes) to be persisted.
+// Host infos without any information besides the peer.ID are skipped.
+func (d *DB) PersistHostInfo(hInfo *models.HostInfo) error {
+	if hInfo == nil || hInfo.ID == "" {
+		return errors.New("host_info without peer_id")
+	}
+	if !hInfo.HasIdentifyingInfo() {
+		log.Tracef("skipping empty host_info of peer %s", hInfo.ID.String())
+		return nil
+	}
+	return d.queue(hInfo)
+}
+
+// PersistPeerInfo queues the identification of the peer to be persisted.
+func (d *DB) PersistPeerInfo(pInfo *models.PeerInfo) error {
+	if pInfo == nil || pInfo.RemotePeer == "" {
+		return errors.New("peer_info without peer_id")
+	}
+	return d.queue(pInfo)
+}
+
+// PersistConnEvent queues the finished connection (with both the connection and the disconnection) to be persisted.
+func (d *DB) PersistConnEvent(connEvent *models.ConnEvent) error {
+	if connEvent == nil || connEvent.PeerID == "" {
+		return errors.New("conn_event without peer_id")
+	}
+	if !connEvent.IsReadyToPersist() {
+		return errors.New("incomplete conn_event of peer " + connEvent.PeerID.String())
+	}
+	return d.queue(connEvent)
+}
+
+// PersistConnAttempt queues the connection attempt to be persisted.
+func (d *DB) PersistConnAttempt(connAttempt *models.ConnectionAttempt) error {
+	if connAttempt == nil || connAttempt.RemotePeer == "" {
+		return errors.New("conn_attempt without peer_id")
+	}
+	return d.queue(connAttempt)
+}
+
+// PersistIpInfo queues the location of the IP to be persisted.
+func (d *DB) PersistIpInfo(ipInfo models.IpInfo) error {
+	if ipInfo.IP == "" {
+		return errors.New("ip_info without ip")
+	}
+	return d.queue(ipInfo)
+}
+
+// PersisterQueueDepth returns the number of items waiting to be picked by the persister.
+func (d *DB) PersisterQueueDepth() int {
+	return len(d.persistC)
+}
+
+// BatchErrors returns the number of transactions that failed to be committed since the start.
+func (d *DB) BatchErrors() int64 {
+	return atomic.LoadInt64(&d.batchErrors)
+}
+
+// DroppedItems returns the number of items that were dropped for being persisted once the DB was closed.
+func (d *DB) DroppedItems() int64 {
+	return atomic.LoadInt64(&d.droppedItems)
+}
@@ -6,12 +6,14 @@ import (
 	"sync"
 	"time"
 
-	psql "github.com/migalabs/armiarma/pkg/db/postgresql"
+	"github.com/migalabs/armiarma/pkg/db"
+	"github.com/migalabs/armiarma/pkg/metrics"
 
 	"github.com/migalabs/armiarma/pkg/utils"
 	"github.com/migalabs/armiarma/pkg/utils/apis"
 
 	"github.com/migalabs/armiarma/pkg/db/models"
+	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -43,21 +45,23 @@ type Discovery struct {
 	ctx context.Context
 
 	DiscService PeerDiscovery
-	DBClient    *psql.DBClient
+	DBClient    db.Persister
 	IpLocator   *apis.IpLocator
+	PeerStore   *metrics.PeerStore
 
 	wg    sync.WaitGroup
 	doneC chan struct{}
 }
 
 // NewDiscovery generates a new module to discover peers in the given network with the given PeerDiscovery submodule
-func NewDiscovery(ctx context.Context, discServ PeerDiscovery, db *psql.DBClient, ipLoc *apis.IpLocator) *Discovery {
+func NewDiscovery(ctx context.Context, discServ PeerDiscovery, dbClient db.Persister, ipLoc *apis.IpLocator, peerStore *metrics.PeerStore) *Discovery {
 	// return the Discovery object
 	return &Discovery{
 		ctx:         ctx,
 		DiscService: discServ,
-		DBClient:    db,
+		DBClient:    dbClient,
 		IpLocator:   ipLoc,
+		PeerStore:   peerStore,
 		doneC:       make(chan struct{}),
 	}
 }
@@ -102,7 +106,12 @@ func (d *Discovery) peerHandler(hInfo *models.HostInfo) {
 	// if the peer
 
 	// Persist to DB the hInfo
-	d.DBClient.PersistToDB(hInfo)
+	err := d.DBClient.PersistHostInfo(hInfo)
+	if err != nil {
+		log.Error(errors.Wrap(err, "unable to persist discovered peer"))
+	}
+	// keep track of the peer in the in-memory peer store
+	d.PeerStore.GetOrCreatePeer(hInfo.ID).FetchHostInfo(hInfo)
 	// if public, req location
 	if utils.IsIPPublic(net.ParseIP(hInfo.IP)) {
 		// get location from the received peer
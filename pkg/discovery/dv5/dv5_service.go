@@ -49,6 +49,10 @@ type Discovery5 struct {
 
 	// Filtering
 	FilterDigest string
+	// ENRs of other networks sharing the discv5
+	networkFilter *eth.NetworkFilter
+	// latest ENR seq per node, to avoid persisting stale ENRs
+	enrSeqs *eth.EnrSeqTracker
 }
 
 // NewDiscovery
@@ -58,6 +62,7 @@ func NewDiscovery5(
 	privkey *ecdsa.PrivateKey,
 	bootnodes []*ethenode.Node,
 	fdigest string,
+	networkFilter *eth.NetworkFilter,
 	port int) (*Discovery5, error) {
 
 	log.Infof("launching discovery5 at fork %s", fdigest)
@@ -102,12 +107,14 @@ func NewDiscovery5(
 
 	// return the Discovery object
 	return &Discovery5{
-		ctx:          ctx,
-		Node:         node,
-		Dv5Listener:  dv5Listener,
-		FilterDigest: fdigest,
-		nodeNotC:     make(chan *models.HostInfo),
-		doneF:        false,
+		ctx:           ctx,
+		Node:          node,
+		Dv5Listener:   dv5Listener,
+		FilterDigest:  fdigest,
+		networkFilter: networkFilter,
+		nodeNotC:      make(chan *models.HostInfo),
+		doneF:         false,
+		enrSeqs:       eth.NewEnrSeqTracker(),
 	}, nil
 }
 
@@ -170,9 +177,8 @@ func (d *Discovery5) handleENR(node *ethenode.Node) (*models.HostInfo, error) {
 		return nil, errors.Wrap(err, "unable to parse new discovered ENR")
 	}
 
-	// check if there is any fork digest filter only if the flag All is not set
-	if enr.Eth2Data.ForkDigest.String() != d.FilterDigest && d.FilterDigest != eth.ForkDigests[eth.AllForkDigest] {
-		log.Tracef("new node discovered - wrong fork %s - looking for %s", enr.Eth2Data.ForkDigest.String(), d.FilterDigest)
+	// check that the node belongs to the crawled network (any node if the allowlist is empty)
+	if !d.networkFilter.Keep(enr) {
 		return nil, ErrorNotValidNode
 	}
 
@@ -190,11 +196,23 @@ func (d *Discovery5) handleENR(node *ethenode.Node) (*models.HostInfo, error) {
 			enr.TCP,
 		),
 	)
-	// add the enr as an attribute
-	hInfo.AddAtt(eth.EnrHostInfoAttribute, enr)
+	// add the enr as an attribute (only if it's newer than the last one we got from the node)
+	if d.enrSeqs.IsNewer(enr) {
+		hInfo.AddAtt(eth.EnrHostInfoAttribute, enr)
+	}
 	return hInfo, nil
 }
 
+// ForeignEnrCount returns the number of discovered ENRs that belonged to a different network.
+func (d *Discovery5) ForeignEnrCount() uint64 {
+	return d.networkFilter.ForeignCount()
+}
+
+// StaleEnrCount returns the number of discovered ENRs that were discarded for having an older seq.
+func (d *Discovery5) StaleEnrCount() uint64 {
+	return d.enrSeqs.StaleCount()
+}
+
 func ParseBootnodesFromStringSlice(bNodes []string) []*ethenode.Node {
 	// where we will store the result
 	bootNodeList := make([]*ethenode.Node, 0)
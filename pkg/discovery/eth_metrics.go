@@ -27,6 +27,13 @@ var (
 	)
 )
 
+// forkDistributions is implemented by the DBs that aggregate the forks and the subnets of the peers
+// (only the postgresql one, the in-memory DB doesn't).
+type forkDistributions interface {
+	GetNodePerForkDistribution() (map[string]interface{}, error)
+	GetAttnetsDistribution() (map[string]interface{}, error)
+}
+
 func (d *Discovery) GetEthereumMetrics() *metrics.MetricsModule {
 
 	metricsMod := metrics.NewMetricsModule(
@@ -35,20 +42,24 @@ func (d *Discovery) GetEthereumMetrics() *metrics.MetricsModule {
 	)
 
 	// compose all the metrics
-	metricsMod.AddIndvMetric(d.nodesPerForkMetrics())
-	metricsMod.AddIndvMetric(d.AttnetsDistMetrics())
+	dists, ok := d.DBClient.(forkDistributions)
+	if !ok {
+		return metricsMod
+	}
+	metricsMod.AddIndvMetric(d.nodesPerForkMetrics(dists))
+	metricsMod.AddIndvMetric(d.AttnetsDistMetrics(dists))
 
 	return metricsMod
 }
 
-func (d *Discovery) nodesPerForkMetrics() *metrics.IndvMetrics {
+func (d *Discovery) nodesPerForkMetrics(dists forkDistributions) *metrics.IndvMetrics {
 	initFn := func() error {
 		prometheus.MustRegister(NodesPerForkDistribution)
 		return nil
 	}
 
 	updateFn := func() (interface{}, error) {
-		summary, err := d.DBClient.GetNodePerForkDistribution()
+		summary, err := dists.GetNodePerForkDistribution()
 		if err != nil {
 			return nil, err
 		}
@@ -69,14 +80,14 @@ func (d *Discovery) nodesPerForkMetrics() *metrics.IndvMetrics {
 	return nodeDist
 }
 
-func (c *Discovery) AttnetsDistMetrics() *metrics.IndvMetrics {
+func (c *Discovery) AttnetsDistMetrics(dists forkDistributions) *metrics.IndvMetrics {
 	initFn := func() error {
 		prometheus.MustRegister(AttnetsDistribution)
 		return nil
 	}
 
 	updateFn := func() (interface{}, error) {
-		summary, err := c.DBClient.GetAttnetsDistribution()
+		summary, err := dists.GetAttnetsDistribution()
 		if err != nil {
 			return nil, err
 		}
@@ -17,12 +17,13 @@ import (
 	pubsub "github.com/libp2p/go-libp2p-pubsub"
 	pubsub_pb "github.com/libp2p/go-libp2p-pubsub/pb"
 	"github.com/migalabs/armiarma/pkg/metrics"
+	"github.com/migalabs/armiarma/pkg/utils"
 	"github.com/minio/sha256-simd"
 	log "github.com/sirupsen/logrus"
 )
 
 type database interface {
-	PersistToDB(interface{})
+	PersistToDB(interface{}) error
 }
 
 type MessageHandler func(*pubsub.Message) (PersistableMsg, error)
@@ -31,6 +32,12 @@ type PersistableMsg interface {
 	IsZero() bool
 }
 
+// SlotMessage is implemented by the messages whose slot could be decoded,
+// which allows measuring their arrival delay relative to the start of the slot.
+type SlotMessage interface {
+	GetSlot() int64
+}
+
 // GossipSub
 // sumarizes the control fields necesary to manage and
 // govern the GossipSub internal service.
@@ -41,6 +48,8 @@ type GossipSub struct {
 	DBClient      database
 	PubsubService *pubsub.PubSub
 	Metrics       *metrics.MetricsModule
+	TopicMetrics  *TopicMetrics
+	PeerStore     *metrics.PeerStore
 	// map where the key are the topic names in string, and the values are the TopicSubscription
 	TopicArray map[string]*TopicSubscription
 }
@@ -50,7 +59,7 @@ func NewEmptyGossipSub() *GossipSub {
 }
 
 // NewGossipSub sumarizes the control fields necesary to manage and govern over a joined and subscribed topic.
-func NewGossipSub(ctx context.Context, h host.Host, dbClient database) *GossipSub {
+func NewGossipSub(ctx context.Context, h host.Host, network utils.NetworkType, dbClient database, peerStore *metrics.PeerStore) *GossipSub {
 
 	// Setup the params
 	gossipParams := pubsub.DefaultGossipSubParams()
@@ -65,6 +74,10 @@ func NewGossipSub(ctx context.Context, h host.Host, dbClient database) *GossipSu
 		pubsub.WithMessageIdFn(MsgIDFunction),
 		pubsub.WithGossipSubParams(gossipParams),
 	}
+	// report the mesh membership and the deliveries of the peers
+	if peerStore != nil {
+		psOptions = append(psOptions, pubsub.WithRawTracer(NewPeerStoreTracer(peerStore, dbClient, network)))
+	}
 	ps, err := pubsub.NewGossipSub(ctx, h, psOptions...)
 	if err != nil {
 		log.Panic(err)
@@ -76,6 +89,8 @@ func NewGossipSub(ctx context.Context, h host.Host, dbClient database) *GossipSu
 		host:          h,
 		DBClient:      dbClient,
 		PubsubService: ps,
+		TopicMetrics:  NewTopicMetrics(),
+		PeerStore:     peerStore,
 		// Metrics:        metrMod, // TODO: finish this
 		TopicArray: make(map[string]*TopicSubscription),
 	}
@@ -108,6 +123,8 @@ func (gs *GossipSub) JoinAndSubscribe(topicName string, handlerFn MessageHandler
 
 	log.Debugf("subscribed to %s", topicName)
 	topicSub := NewTopicSubscription(gs.ctx, topic, *sub, handlerFn, persistMsgs)
+	topicSub.topicMetrics = gs.TopicMetrics
+	topicSub.peerStore = gs.PeerStore
 	// Add the new Topic to the list of supported/subscribed topics in GossipSub
 	gs.TopicArray[topicName] = topicSub
 	go gs.TopicArray[topicName].MessageReadingLoop(gs.host.ID(), gs.DBClient)
@@ -39,6 +39,7 @@ func (gs *GossipSub) GetMetrics() *metrics.MetricsModule {
 	)
 
 	metricsMod.AddIndvMetric(gs.peersPerTopic())
+	metricsMod.AddIndvMetric(gs.topicThroughput())
 
 	return metricsMod
 }
@@ -69,3 +70,28 @@ func (gs *GossipSub) peersPerTopic() *metrics.IndvMetrics {
 	}
 	return peersTop
 }
+
+func (gs *GossipSub) topicThroughput() *metrics.IndvMetrics {
+
+	initFn := func() error {
+		return gs.TopicMetrics.Register(prometheus.DefaultRegisterer)
+	}
+
+	// message and byte counters are fed from the reading loops,
+	// here we only close the interval of the active senders
+	updateFn := func() (interface{}, error) {
+		summary := gs.TopicMetrics.UpdateActiveSenders()
+		return summary, nil
+	}
+
+	topicThroughput, err := metrics.NewIndvMetrics(
+		"topic_throughput",
+		initFn,
+		updateFn,
+	)
+	if err != nil {
+		log.Error(err)
+		return nil
+	}
+	return topicThroughput
+}
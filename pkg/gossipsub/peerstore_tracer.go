@@ -0,0 +1,85 @@
+package gossipsub
+
+import (
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/protocol"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/migalabs/armiarma/pkg/db/models"
+	"github.com/migalabs/armiarma/pkg/metrics"
+	"github.com/migalabs/armiarma/pkg/utils"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// PeerStoreTracer is a pubsub.RawTracer that reports into the peer store the GRAFT and PRUNE
+// events of our gossipsub mesh, which peer delivered each message first, and the pubsub
+// protocol negotiated with each peer (also persisted, if there is a database).
+// The rest of events are ignored.
+type PeerStoreTracer struct {
+	peerStore *metrics.PeerStore
+	dbClient  database
+	network   utils.NetworkType
+}
+
+var _ pubsub.RawTracer = (*PeerStoreTracer)(nil)
+
+// NewPeerStoreTracer returns a PeerStoreTracer that reports the events into the given peer store.
+func NewPeerStoreTracer(peerStore *metrics.PeerStore, dbClient database, network utils.NetworkType) *PeerStoreTracer {
+	return &PeerStoreTracer{
+		peerStore: peerStore,
+		dbClient:  dbClient,
+		network:   network,
+	}
+}
+
+// AddPeer is called when a new peer speaks pubsub with us, with the negotiated protocol.
+func (t *PeerStoreTracer) AddPeer(p peer.ID, proto protocol.ID) {
+	pubsubProto := models.NewPubsubProtocol(p, string(proto))
+	t.peerStore.GetOrCreatePeer(p).PubsubProtocolEvent(pubsubProto.Protocol)
+	if t.dbClient == nil {
+		return
+	}
+	hInfo := models.NewHostInfo(p, t.network)
+	hInfo.AddAtt(models.PubsubProtocolAttribute, pubsubProto)
+	err := t.dbClient.PersistToDB(hInfo)
+	if err != nil {
+		log.Error(errors.Wrap(err, "unable to persist pubsub protocol"))
+	}
+}
+
+// Graft is called when a peer joins our mesh of the topic.
+func (t *PeerStoreTracer) Graft(p peer.ID, topic string) {
+	t.peerStore.GetOrCreatePeer(p).MeshEvent(topic, true, time.Now())
+}
+
+// Prune is called when a peer leaves our mesh of the topic (also when it gets disconnected).
+func (t *PeerStoreTracer) Prune(p peer.ID, topic string) {
+	t.peerStore.GetOrCreatePeer(p).MeshEvent(topic, false, time.Now())
+}
+
+// DeliverMessage is called when a message is delivered for the first time, by the peer that sent it first.
+func (t *PeerStoreTracer) DeliverMessage(msg *pubsub.Message) {
+	p := t.peerStore.GetOrCreatePeer(msg.ReceivedFrom)
+	p.FirstDeliveryEvent(msg.GetTopic())
+	p.SeenEvent(msg.GetTopic(), msg.ID, time.Now())
+}
+
+// DuplicateMessage is called when a peer sends us a message that was already delivered.
+func (t *PeerStoreTracer) DuplicateMessage(msg *pubsub.Message) {
+	p := t.peerStore.GetOrCreatePeer(msg.ReceivedFrom)
+	p.DuplicateEvent(msg.GetTopic())
+	p.SeenEvent(msg.GetTopic(), msg.ID, time.Now())
+}
+
+func (t *PeerStoreTracer) RemovePeer(p peer.ID)                             {}
+func (t *PeerStoreTracer) Join(topic string)                                {}
+func (t *PeerStoreTracer) Leave(topic string)                               {}
+func (t *PeerStoreTracer) ValidateMessage(msg *pubsub.Message)              {}
+func (t *PeerStoreTracer) RejectMessage(msg *pubsub.Message, reason string) {}
+func (t *PeerStoreTracer) ThrottlePeer(p peer.ID)                           {}
+func (t *PeerStoreTracer) RecvRPC(rpc *pubsub.RPC)                          {}
+func (t *PeerStoreTracer) SendRPC(rpc *pubsub.RPC, p peer.ID)               {}
+func (t *PeerStoreTracer) DropRPC(rpc *pubsub.RPC, p peer.ID)               {}
+func (t *PeerStoreTracer) UndeliverableMessage(msg *pubsub.Message)         {}
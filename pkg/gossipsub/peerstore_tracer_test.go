@@ -0,0 +1,44 @@
+package gossipsub
+
+import (
+	"testing"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	pb "github.com/libp2p/go-libp2p-pubsub/pb"
+	"github.com/migalabs/armiarma/pkg/metrics"
+	"github.com/migalabs/armiarma/pkg/utils"
+	"github.com/stretchr/testify/require"
+)
+
+func testTracedMsg(topic string, id string, from peer.ID) *pubsub.Message {
+	return &pubsub.Message{
+		Message:      &pb.Message{Topic: &topic},
+		ID:           id,
+		ReceivedFrom: from,
+	}
+}
+
+func Test_PeerStoreTracerSeenMessages(t *testing.T) {
+	blockTopic := "/eth2/bba4da96/beacon_block/ssz_snappy"
+	store := metrics.NewPeerStore()
+	tracer := NewPeerStoreTracer(store, nil, utils.EthereumNetwork)
+	first, second, third := peer.ID("peer1"), peer.ID("peer2"), peer.ID("peer3")
+
+	// the router delivers the block once, and reports the rest of the copies as duplicates
+	tracer.DeliverMessage(testTracedMsg(blockTopic, "block-1", first))
+	tracer.DuplicateMessage(testTracedMsg(blockTopic, "block-1", second))
+	tracer.DuplicateMessage(testTracedMsg(blockTopic, "block-1", third))
+	tracer.DeliverMessage(testTracedMsg(blockTopic, "block-2", second))
+	tracer.DuplicateMessage(testTracedMsg(blockTopic, "block-2", first))
+
+	firstPeer := store.GetOrCreatePeer(first)
+	require.Equal(t, int64(1), firstPeer.GetFirstSeenCount(metrics.BeaconBlockTopicName))
+	require.Equal(t, 0.5, firstPeer.GetFirstSeenRatio(metrics.BeaconBlockTopicName))
+	require.Equal(t, 0.5, store.GetOrCreatePeer(second).GetFirstSeenRatio(metrics.BeaconBlockTopicName))
+	require.Equal(t, float64(0), store.GetOrCreatePeer(third).GetFirstSeenRatio(metrics.BeaconBlockTopicName))
+
+	// once the router forgot the block, its re-delivery is still a duplicate within the window
+	tracer.DeliverMessage(testTracedMsg(blockTopic, "block-1", third))
+	require.Equal(t, int64(0), store.GetOrCreatePeer(third).GetFirstSeenCount(metrics.BeaconBlockTopicName))
+}
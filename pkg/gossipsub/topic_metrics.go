@@ -0,0 +1,109 @@
+package gossipsub
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// matches the trailing index of the subnet topics (beacon_attestation_12, blob_sidecar_3, ...)
+var subnetIndexRegex = regexp.MustCompile(`_[0-9]+$`)
+
+// TopicMetrics keeps the live throughput counters of each of the gossip topics
+// that we are subscribed to. Metrics are labelled by the short name of the topic,
+// collapsing the indexed subnet topics into their family label.
+type TopicMetrics struct {
+	ReceivedMessages *prometheus.CounterVec
+	ReceivedBytes    *prometheus.CounterVec
+	ActiveSenders    *prometheus.GaugeVec
+
+	m sync.Mutex
+	// distinct senders per topic since the last interval
+	senders map[string]map[peer.ID]struct{}
+}
+
+// NewTopicMetrics returns the set of per-topic throughput metrics without registering them.
+func NewTopicMetrics() *TopicMetrics {
+	return &TopicMetrics{
+		ReceivedMessages: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: moduleName,
+			Name:      "topic_received_messages_total",
+			Help:      "Number of messages received on each gossip topic",
+		},
+			[]string{"topic"},
+		),
+		ReceivedBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: moduleName,
+			Name:      "topic_received_bytes_total",
+			Help:      "Number of bytes received on each gossip topic",
+		},
+			[]string{"topic"},
+		),
+		ActiveSenders: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: moduleName,
+			Name:      "topic_active_senders",
+			Help:      "Number of distinct peers that delivered at least one message on each topic in the last interval",
+		},
+			[]string{"topic"},
+		),
+		senders: make(map[string]map[peer.ID]struct{}),
+	}
+}
+
+// Register adds the topic metrics to the given prometheus registerer.
+func (tm *TopicMetrics) Register(reg prometheus.Registerer) error {
+	for _, col := range []prometheus.Collector{tm.ReceivedMessages, tm.ReceivedBytes, tm.ActiveSenders} {
+		if err := reg.Register(col); err != nil {
+			return errors.Wrap(err, "unable to register topic metrics")
+		}
+	}
+	return nil
+}
+
+// MessageEvent tracks a new message of the given size received from the sender on the given topic.
+func (tm *TopicMetrics) MessageEvent(topic string, sender peer.ID, size int) {
+	label := TopicLabel(topic)
+	tm.ReceivedMessages.WithLabelValues(label).Inc()
+	tm.ReceivedBytes.WithLabelValues(label).Add(float64(size))
+
+	tm.m.Lock()
+	defer tm.m.Unlock()
+	topicSenders, ok := tm.senders[label]
+	if !ok {
+		topicSenders = make(map[peer.ID]struct{})
+		tm.senders[label] = topicSenders
+	}
+	topicSenders[sender] = struct{}{}
+}
+
+// UpdateActiveSenders sets the active senders gauge with the distinct senders seen
+// since the last call, starting a new interval.
+// Returns the number of distinct senders per topic label.
+func (tm *TopicMetrics) UpdateActiveSenders() map[string]int {
+	tm.m.Lock()
+	defer tm.m.Unlock()
+
+	summary := make(map[string]int, len(tm.senders))
+	for label, topicSenders := range tm.senders {
+		summary[label] = len(topicSenders)
+		tm.ActiveSenders.WithLabelValues(label).Set(float64(len(topicSenders)))
+		// keep the topic in the map so that it reports 0 if it gets silent
+		tm.senders[label] = make(map[peer.ID]struct{})
+	}
+	return summary
+}
+
+// TopicLabel returns the short name of a gossip topic, used as label for the metrics.
+// It would return "beacon_attestation" out of "/eth2/b5303f2a/beacon_attestation_12/ssz_snappy".
+func TopicLabel(topic string) string {
+	name := topic
+	parts := strings.Split(topic, "/")
+	if len(parts) >= 4 && parts[3] != "" {
+		name = parts[3]
+	}
+	return subnetIndexRegex.ReplaceAllString(name, "")
+}
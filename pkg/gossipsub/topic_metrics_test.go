@@ -0,0 +1,73 @@
+package gossipsub
+
+import (
+	"testing"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_TopicLabel(t *testing.T) {
+	require.Equal(t, "beacon_block", TopicLabel("/eth2/bba4da96/beacon_block/ssz_snappy"))
+	require.Equal(t, "beacon_attestation", TopicLabel("/eth2/bba4da96/beacon_attestation_12/ssz_snappy"))
+	require.Equal(t, "blob_sidecar", TopicLabel("/eth2/bba4da96/blob_sidecar_3/ssz_snappy"))
+	require.Equal(t, "light_client_finality_update", TopicLabel("/eth2/bba4da96/light_client_finality_update/ssz_snappy"))
+	require.Equal(t, "custom_topic", TopicLabel("custom_topic"))
+}
+
+func Test_TopicMetrics(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	tm := NewTopicMetrics()
+	require.NoError(t, tm.Register(reg))
+
+	peer1 := peer.ID("peer1")
+	peer2 := peer.ID("peer2")
+
+	tm.MessageEvent("/eth2/bba4da96/beacon_block/ssz_snappy", peer1, 100)
+	tm.MessageEvent("/eth2/bba4da96/beacon_block/ssz_snappy", peer1, 50)
+	tm.MessageEvent("/eth2/bba4da96/beacon_attestation_1/ssz_snappy", peer1, 10)
+	tm.MessageEvent("/eth2/bba4da96/beacon_attestation_2/ssz_snappy", peer2, 20)
+
+	summary := tm.UpdateActiveSenders()
+	require.Equal(t, 1, summary["beacon_block"])
+	require.Equal(t, 2, summary["beacon_attestation"])
+
+	msgs, bytes, senders := scrapeTopicMetrics(t, reg)
+	require.Equal(t, float64(2), msgs["beacon_block"])
+	require.Equal(t, float64(150), bytes["beacon_block"])
+	require.Equal(t, float64(2), msgs["beacon_attestation"])
+	require.Equal(t, float64(30), bytes["beacon_attestation"])
+	require.Equal(t, float64(1), senders["beacon_block"])
+	require.Equal(t, float64(2), senders["beacon_attestation"])
+
+	// a new interval without messages resets the active senders
+	tm.UpdateActiveSenders()
+	msgs, _, senders = scrapeTopicMetrics(t, reg)
+	require.Equal(t, float64(2), msgs["beacon_block"])
+	require.Equal(t, float64(0), senders["beacon_block"])
+	require.Equal(t, float64(0), senders["beacon_attestation"])
+}
+
+func scrapeTopicMetrics(t *testing.T, reg *prometheus.Registry) (msgs, bytes, senders map[string]float64) {
+	families, err := reg.Gather()
+	require.NoError(t, err)
+
+	msgs = make(map[string]float64)
+	bytes = make(map[string]float64)
+	senders = make(map[string]float64)
+	for _, fam := range families {
+		for _, m := range fam.GetMetric() {
+			topic := m.GetLabel()[0].GetValue()
+			switch fam.GetName() {
+			case "gossipsub_topic_received_messages_total":
+				msgs[topic] = m.GetCounter().GetValue()
+			case "gossipsub_topic_received_bytes_total":
+				bytes[topic] = m.GetCounter().GetValue()
+			case "gossipsub_topic_active_senders":
+				senders[topic] = m.GetGauge().GetValue()
+			}
+		}
+	}
+	return msgs, bytes, senders
+}
@@ -5,6 +5,7 @@ import (
 
 	"github.com/libp2p/go-libp2p-core/peer"
 	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/migalabs/armiarma/pkg/metrics"
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 )
@@ -24,6 +25,9 @@ type TopicSubscription struct {
 	sub         *pubsub.Subscription
 	handlerFn   MessageHandler
 	persistMsgs bool
+
+	topicMetrics *TopicMetrics
+	peerStore    *metrics.PeerStore
 }
 
 // NewTopicSubscription sumarizes the control fields necesary to manage and
@@ -61,15 +65,22 @@ func (c *TopicSubscription) MessageReadingLoop(selfId peer.ID, dbClient database
 			// To avoid getting track of our own messages, check if we are the senders
 			if msg.ReceivedFrom != selfId {
 				log.Debugf("new message on %s from %s", c.sub.Topic(), msg.ReceivedFrom)
+				if c.topicMetrics != nil {
+					c.topicMetrics.MessageEvent(c.sub.Topic(), msg.ReceivedFrom, len(msg.Data))
+				}
 				// use the msg handler for that specific topic that we have
 				content, err := c.handlerFn(msg)
+				c.peerStoreMessageEvent(msg, content, err)
 				if err != nil {
 					log.Error(errors.Wrap(err, "unable to unwrap message on topic " + c.sub.Topic()))
 					continue
 				}
 				if !content.IsZero() && c.persistMsgs {
 					log.Debugf("msg on %s content: %+v", c.sub.Topic(), content)
-					dbClient.PersistToDB(content)
+					err = dbClient.PersistToDB(content)
+					if err != nil {
+						log.Error(errors.Wrap(err, "unable to persist message on topic "+c.sub.Topic()))
+					}
 				}
 			} else {
 				log.Debugf("message sent by ourselfs received on %s", c.sub.Topic())
@@ -79,3 +90,18 @@ func (c *TopicSubscription) MessageReadingLoop(selfId peer.ID, dbClient database
 	<-subsCtx.Done()
 	log.Debugf("ending %s reading loop", c.sub.Topic())
 }
+
+// peerStoreMessageEvent tracks the message in the peer store, including its arrival delay
+// if the handler could decode its slot.
+func (c *TopicSubscription) peerStoreMessageEvent(msg *pubsub.Message, content PersistableMsg, handlerErr error) {
+	if c.peerStore == nil {
+		return
+	}
+	p := c.peerStore.GetOrCreatePeer(msg.ReceivedFrom)
+	if slotMsg, ok := content.(SlotMessage); ok && handlerErr == nil && !content.IsZero() {
+		p.MessageEventAtSlot(c.sub.Topic(), msg.ArrivalTime, slotMsg.GetSlot(), c.peerStore.SlotClock())
+	} else {
+		p.MessageEvent(c.sub.Topic(), msg.ArrivalTime)
+	}
+	p.MessageBytesEvent(c.sub.Topic(), len(msg.Data))
+}
@@ -1,9 +1,11 @@
 package hosts
 
 import (
+	"regexp"
 	"strings"
 
 	swarm "github.com/libp2p/go-libp2p-swarm"
+	"github.com/pkg/errors"
 )
 
 // Much easier/prettier way of filtering the Error returned by the libp2p.Host.Connect
@@ -40,33 +42,71 @@ const (
 	DialErrorHostIsDown                                 = "host_is_down"
 	DialErrorTooManyOpenFiles                           = "too_many_open_files"
 	DialErrorNegotiateSecurityProtocolNoTrailingNewLine = "negotiate_security_protocol_no_trailing_new_line"
+
+	// QUIC transport errors
+	DialErrorQuicCryptoError        = "quic_crypto_error"
+	DialErrorQuicIdleTimeout        = "quic_idle_timeout"
+	DialErrorQuicHandshakeTimeout   = "quic_handshake_timeout"
+	DialErrorQuicApplicationError   = "quic_application_error"
+	DialErrorQuicVersionNegotiation = "quic_version_negotiation"
+	// security handshake errors (after the security protocol was negotiated)
+	DialErrorNoiseHandshake = "noise_handshake"
+	DialErrorTLSHandshake   = "tls_handshake"
 )
 
-var KnownErrors = map[string]string{
-	ResourceLimitError:                                  "resource limit exceeded",
-	DialErrorRoutingNotFound:                            "routing: not found",
-	DialErrorNoRecentNetworkActivity:                    "no recent network activity",
-	DialErrorStreamErrorCode0:                           "canceled with error code 0",
-	DialErrorMsgSenderInvalidated:                       "message sender has been invalidated",
-	DialErrorIoTimeout:                                  "i/o timeout",
-	DialErrorSelfAttempt:                                "dial to self attempted",
-	DialErrorConnectionRefused:                          "connection refused",
-	DialErrorConnectionResetByPeer:                      "connection reset by peer",
-	DialErrorBackOff:                                    "backoff",
-	DialErrorProtocolNotSupported:                       "protocol not supported",
-	DialErrorPeerIDMismatch:                             "peer id mismatch",
-	DialErrorNoRouteToHost:                              "no route to host",
-	DialErrorNetworkUnreachable:                         "network is unreachable",
-	DialErrorNoGoodAddresses:                            "no good addresses",
-	DialErrorNoAddress:                                  "no addresses",
-	DialErrorContextDeadlineExceeded:                    "context deadline exceeded",
-	DialErrorNoPublicIP:                                 "no public IP address",
-	DialErrorSecurityProtocolNegotiation:                "failed to negotiate security protocol: EOF",
-	DialErrorMaxDialAttemptsExceeded:                    "max dial attempts exceeded",
-	DialErrorHostIsDown:                                 "host is down",
-	DialErrorStreamReset:                                "stream reset",
-	DialErrorTooManyOpenFiles:                           "too many open files",
-	DialErrorNegotiateSecurityProtocolNoTrailingNewLine: "failed to negotiate security protocol: message did not have trailing newline",
+// ConnErrorRule maps the errors whose message contains Substr (or matches Regex, if set) into Category.
+type ConnErrorRule struct {
+	Category string
+	Substr   string
+	Regex    *regexp.Regexp
+}
+
+func (r ConnErrorRule) matches(errStr string) bool {
+	if r.Regex != nil {
+		return r.Regex.MatchString(errStr)
+	}
+	return strings.Contains(errStr, r.Substr)
+}
+
+// ConnErrorRules are checked in order, so the specific rules go before the generic ones
+// (e.g. the QUIC timeouts before the plain timeouts, the security negotiation errors before EOFs).
+var ConnErrorRules = []ConnErrorRule{
+	{Category: DialErrorSelfAttempt, Substr: "dial to self attempted"},
+	{Category: DialErrorBackOff, Substr: "backoff"},
+	{Category: DialErrorMaxDialAttemptsExceeded, Substr: "max dial attempts exceeded"},
+	{Category: DialErrorNoGoodAddresses, Substr: "no good addresses"},
+	{Category: DialErrorNoAddress, Substr: "no addresses"},
+	{Category: DialErrorNoPublicIP, Substr: "no public IP address"},
+	{Category: ResourceLimitError, Substr: "resource limit exceeded"},
+	{Category: DialErrorTooManyOpenFiles, Substr: "too many open files"},
+
+	// QUIC
+	{Category: DialErrorQuicCryptoError, Regex: regexp.MustCompile(`CRYPTO_ERROR`)},
+	{Category: DialErrorQuicHandshakeTimeout, Substr: "timeout: handshake did not complete in time"},
+	{Category: DialErrorQuicIdleTimeout, Regex: regexp.MustCompile(`NO_ERROR: timeout|timeout: no recent network activity`)},
+	{Category: DialErrorQuicVersionNegotiation, Regex: regexp.MustCompile(`(?i)no compatible QUIC version|VERSION_NEGOTIATION_ERROR`)},
+	{Category: DialErrorQuicApplicationError, Regex: regexp.MustCompile(`Application error 0x[0-9a-fA-F]+`)},
+	{Category: DialErrorNoRecentNetworkActivity, Substr: "no recent network activity"},
+
+	// security handshake
+	{Category: DialErrorPeerIDMismatch, Regex: regexp.MustCompile(`(?i)peer id mismatch|peer IDs don't match|remote key matches`)},
+	{Category: DialErrorNegotiateSecurityProtocolNoTrailingNewLine, Substr: "failed to negotiate security protocol: message did not have trailing newline"},
+	{Category: DialErrorNoiseHandshake, Regex: regexp.MustCompile(`(?i)noise.*handshake|error (reading|writing) handshake message|failed to (read|write) noise`)},
+	{Category: DialErrorTLSHandshake, Regex: regexp.MustCompile(`(?i)tls: |TLS handshake`)},
+	{Category: DialErrorSecurityProtocolNegotiation, Substr: "failed to negotiate security protocol"},
+	{Category: DialErrorProtocolNotSupported, Substr: "protocol not supported"},
+
+	{Category: DialErrorRoutingNotFound, Substr: "routing: not found"},
+	{Category: DialErrorStreamErrorCode0, Substr: "canceled with error code 0"},
+	{Category: DialErrorMsgSenderInvalidated, Substr: "message sender has been invalidated"},
+	{Category: DialErrorStreamReset, Substr: "stream reset"},
+	{Category: DialErrorConnectionRefused, Substr: "connection refused"},
+	{Category: DialErrorConnectionResetByPeer, Substr: "connection reset by peer"},
+	{Category: DialErrorNoRouteToHost, Substr: "no route to host"},
+	{Category: DialErrorNetworkUnreachable, Substr: "network is unreachable"},
+	{Category: DialErrorHostIsDown, Substr: "host is down"},
+	{Category: DialErrorIoTimeout, Substr: "i/o timeout"},
+	{Category: DialErrorContextDeadlineExceeded, Substr: "context deadline exceeded"},
 }
 
 func ParseConError(err error) string {
@@ -79,10 +119,61 @@ func ParseConError(err error) string {
 	}
 
 	// check if the connError is one of the ones that we have identified
-	for key, errStr := range KnownErrors {
-		if strings.Contains(err.Error(), errStr) {
-			return key
+	return ClassifyConnError(err.Error())
+}
+
+// ClassifyConnError returns the category of the first rule that matches the error message.
+func ClassifyConnError(errStr string) string {
+	for _, rule := range ConnErrorRules {
+		if rule.matches(errStr) {
+			return rule.Category
 		}
 	}
 	return DialErrorUnknown
 }
+
+// IsQuicError returns whether the parsed error is one of the QUIC transport errors.
+func IsQuicError(connErr string) bool {
+	switch connErr {
+	case DialErrorQuicCryptoError,
+		DialErrorQuicIdleTimeout,
+		DialErrorQuicHandshakeTimeout,
+		DialErrorQuicApplicationError,
+		DialErrorQuicVersionNegotiation:
+		return true
+	default:
+		return false
+	}
+}
+
+// IsSecurityHandshakeError returns whether the parsed error comes from the negotiation of the security protocol
+// or from the security handshake itself.
+func IsSecurityHandshakeError(connErr string) bool {
+	switch connErr {
+	case DialErrorSecurityProtocolNegotiation,
+		DialErrorNegotiateSecurityProtocolNoTrailingNewLine,
+		DialErrorNoiseHandshake,
+		DialErrorTLSHandshake:
+		return true
+	default:
+		return false
+	}
+}
+
+// ParseAddrConErrors returns the category of the error of each address that the swarm dialed,
+// keyed by the address. The addresses that don't show up weren't dialed (or their errors
+// weren't recorded, as the swarm only keeps the first ones).
+func ParseAddrConErrors(err error) map[string]string {
+	addrErrs := make(map[string]string)
+	var dialErr *swarm.DialError
+	if !errors.As(err, &dialErr) {
+		return addrErrs
+	}
+	for _, transportErr := range dialErr.DialErrors {
+		if transportErr.Address == nil || transportErr.Cause == nil {
+			continue
+		}
+		addrErrs[transportErr.Address.String()] = ParseConError(transportErr.Cause)
+	}
+	return addrErrs
+}
@@ -0,0 +1,93 @@
+package hosts
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	swarm "github.com/libp2p/go-libp2p-swarm"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/require"
+)
+
+// dial errors as they are returned by libp2p.Host.Connect
+var connErrorCorpus = []struct {
+	err      string
+	category string
+}{
+	// TCP
+	{"failed to dial 16Uiu2HAm...: all dials failed\n  * [/ip4/95.217.33.10/tcp/9000] dial tcp4 95.217.33.10:9000: connect: connection refused", DialErrorConnectionRefused},
+	{"failed to dial 16Uiu2HAm...: all dials failed\n  * [/ip4/3.120.0.5/tcp/13000] dial tcp4 0.0.0.0:9020->3.120.0.5:13000: i/o timeout", DialErrorIoTimeout},
+	{"failed to dial 16Uiu2HAm...: all dials failed\n  * [/ip4/88.99.1.2/tcp/9000] read tcp4 0.0.0.0:9020->88.99.1.2:9000: read: connection reset by peer", DialErrorConnectionResetByPeer},
+	{"failed to dial 16Uiu2HAm...: all dials failed\n  * [/ip4/10.0.0.8/tcp/9000] dial tcp4 10.0.0.8:9000: connect: no route to host", DialErrorNoRouteToHost},
+	{"failed to dial 16Uiu2HAm...: all dials failed\n  * [/ip6/2a01::1/tcp/9000] dial tcp6 [2a01::1]:9000: connect: network is unreachable", DialErrorNetworkUnreachable},
+	{"failed to dial 16Uiu2HAm...: all dials failed\n  * [/ip4/192.168.3.4/tcp/9000] dial tcp4 192.168.3.4:9000: connect: host is down", DialErrorHostIsDown},
+	{"failed to dial 16Uiu2HAm...: context deadline exceeded", DialErrorContextDeadlineExceeded},
+	{"failed to dial 16Uiu2HAm...: dial backoff", DialErrorBackOff},
+	{"failed to dial 16Uiu2HAm...: no good addresses", DialErrorNoGoodAddresses},
+	{"failed to find any peer in table", DialErrorUnknown},
+	{"dial to self attempted", DialErrorSelfAttempt},
+	{"failed to dial 16Uiu2HAm...: all dials failed\n  * [/ip4/1.2.3.4/tcp/9000] dial tcp4 0.0.0.0:9020->1.2.3.4:9000: socket: too many open files", DialErrorTooManyOpenFiles},
+	{"failed to dial 16Uiu2HAm...: all dials failed\n  * [/ip4/5.6.7.8/tcp/9000] resource limit exceeded", ResourceLimitError},
+
+	// QUIC
+	{"failed to dial 12D3KooW...: all dials failed\n  * [/ip4/65.108.1.1/udp/9001/quic] CRYPTO_ERROR 0x178 (remote): tls: no application protocol", DialErrorQuicCryptoError},
+	{"failed to dial 12D3KooW...: all dials failed\n  * [/ip4/65.108.1.1/udp/9001/quic] CRYPTO_ERROR (0x12a): peer IDs don't match", DialErrorQuicCryptoError},
+	{"failed to dial 12D3KooW...: all dials failed\n  * [/ip4/65.108.1.2/udp/9001/quic] NO_ERROR: timeout", DialErrorQuicIdleTimeout},
+	{"failed to dial 12D3KooW...: all dials failed\n  * [/ip4/65.108.1.3/udp/9001/quic] timeout: no recent network activity", DialErrorQuicIdleTimeout},
+	{"failed to dial 12D3KooW...: all dials failed\n  * [/ip4/65.108.1.4/udp/9001/quic] timeout: handshake did not complete in time", DialErrorQuicHandshakeTimeout},
+	{"failed to dial 12D3KooW...: all dials failed\n  * [/ip4/65.108.1.5/udp/9001/quic] Application error 0x0 (remote)", DialErrorQuicApplicationError},
+	{"failed to dial 12D3KooW...: all dials failed\n  * [/ip4/65.108.1.6/udp/9001/quic] no compatible QUIC version found", DialErrorQuicVersionNegotiation},
+	{"failed to dial 12D3KooW...: all dials failed\n  * [/ip4/65.108.1.7/udp/9001/quic-v1] VERSION_NEGOTIATION_ERROR", DialErrorQuicVersionNegotiation},
+
+	// security handshake
+	{"failed to dial 16Uiu2HAm...: all dials failed\n  * [/ip4/1.1.1.1/tcp/9000] failed to negotiate security protocol: EOF", DialErrorSecurityProtocolNegotiation},
+	{"failed to dial 16Uiu2HAm...: all dials failed\n  * [/ip4/1.1.1.2/tcp/9000] failed to negotiate security protocol: protocol not supported", DialErrorSecurityProtocolNegotiation},
+	{"failed to dial 16Uiu2HAm...: all dials failed\n  * [/ip4/1.1.1.3/tcp/9000] failed to negotiate security protocol: message did not have trailing newline", DialErrorNegotiateSecurityProtocolNoTrailingNewLine},
+	{"failed to dial 16Uiu2HAm...: all dials failed\n  * [/ip4/1.1.1.4/tcp/9000] failed to negotiate security protocol: peer id mismatch: expected 16Uiu2HAm..., but remote key matches 16Uiu2HAn...", DialErrorPeerIDMismatch},
+	{"failed to dial 16Uiu2HAm...: all dials failed\n  * [/ip4/1.1.1.5/tcp/9000] failed to negotiate security protocol: error reading handshake message: noise: message is too short", DialErrorNoiseHandshake},
+	{"failed to dial 16Uiu2HAm...: all dials failed\n  * [/ip4/1.1.1.6/tcp/9000] failed to negotiate security protocol: failed to read noise handshake payload", DialErrorNoiseHandshake},
+	{"failed to dial 16Uiu2HAm...: all dials failed\n  * [/ip4/1.1.1.7/tcp/9000] failed to negotiate security protocol: error writing handshake message: write tcp4: broken pipe", DialErrorNoiseHandshake},
+	{"failed to dial 12D3KooW...: all dials failed\n  * [/ip4/1.1.1.8/tcp/4001] failed to negotiate security protocol: tls: first record does not look like a TLS handshake", DialErrorTLSHandshake},
+	{"failed to dial 12D3KooW...: all dials failed\n  * [/ip4/1.1.1.9/tcp/4001] failed to negotiate security protocol: remote error: tls: bad certificate", DialErrorTLSHandshake},
+
+	// protocols and streams
+	{"protocol not supported", DialErrorProtocolNotSupported},
+	{"stream reset", DialErrorStreamReset},
+	{"routing: not found", DialErrorRoutingNotFound},
+}
+
+func Test_ClassifyConnErrors(t *testing.T) {
+	for _, test := range connErrorCorpus {
+		require.Equal(t, test.category, ParseConError(errors.New(test.err)), test.err)
+	}
+}
+
+func Test_ConnErrorGroups(t *testing.T) {
+	require.True(t, IsQuicError(DialErrorQuicIdleTimeout))
+	require.False(t, IsQuicError(DialErrorIoTimeout))
+	require.True(t, IsSecurityHandshakeError(DialErrorNoiseHandshake))
+	require.False(t, IsSecurityHandshakeError(DialErrorPeerIDMismatch))
+}
+
+func Test_ParseAddrConErrors(t *testing.T) {
+	tcpAddr := ma.StringCast("/ip4/95.217.33.10/tcp/9000")
+	quicAddr := ma.StringCast("/ip4/95.217.33.10/udp/9001/quic")
+	dialErr := &swarm.DialError{
+		Cause: errors.New("all dials failed"),
+		DialErrors: []swarm.TransportError{
+			{Address: tcpAddr, Cause: errors.New("dial tcp4 95.217.33.10:9000: connect: connection refused")},
+			{Address: quicAddr, Cause: errors.New("timeout: no recent network activity")},
+		},
+	}
+	addrErrs := ParseAddrConErrors(dialErr)
+	require.Equal(t, map[string]string{
+		tcpAddr.String():  DialErrorConnectionRefused,
+		quicAddr.String(): DialErrorQuicIdleTimeout,
+	}, addrErrs)
+
+	// wrapped errors are unwrapped
+	require.Len(t, ParseAddrConErrors(fmt.Errorf("connecting: %w", dialErr)), 2)
+	require.Empty(t, ParseAddrConErrors(errors.New("dial to self attempted")))
+	require.Empty(t, ParseAddrConErrors(nil))
+}
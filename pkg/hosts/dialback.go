@@ -0,0 +1,178 @@
+package hosts
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/migalabs/armiarma/pkg/db/models"
+	"github.com/migalabs/armiarma/pkg/utils"
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// dial-back attempts that a peer gets at most, and the time between two of them
+	DefaultDialbackMaxAttempts = 3
+	DefaultDialbackInterval    = 6 * time.Hour
+	// time that each dial-back gets to reach the peer (over all its addresses)
+	DefaultDialbackTimeout = 5 * time.Second
+	// size of the pool that dials back the inbound peers
+	DefaultDialbackWorkers   = 8
+	DefaultDialbackQueueSize = 256
+)
+
+// DialbackDialer opens a short-lived connection to the given address, closing it right away.
+type DialbackDialer interface {
+	Dial(ctx context.Context, addr ma.Multiaddr) error
+}
+
+// tcpDialbackDialer dials plain TCP connections, outside the libp2p swarm, so that the dial-back
+// never reuses (nor closes) the connection that the peer opened to us.
+type tcpDialbackDialer struct {
+	dialer manet.Dialer
+}
+
+func (d *tcpDialbackDialer) Dial(ctx context.Context, addr ma.Multiaddr) error {
+	conn, err := d.dialer.DialContext(ctx, addr)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// DialbackConfig bounds the dial-backs of the Dialbacker.
+type DialbackConfig struct {
+	MaxAttempts int           // per peer
+	Interval    time.Duration // between two attempts of the same peer
+	Timeout     time.Duration // of each attempt
+	Workers     int
+	QueueSize   int
+}
+
+// DefaultDialbackConfig returns the DialbackConfig of the Default* values.
+func DefaultDialbackConfig() DialbackConfig {
+	return DialbackConfig{
+		MaxAttempts: DefaultDialbackMaxAttempts,
+		Interval:    DefaultDialbackInterval,
+		Timeout:     DefaultDialbackTimeout,
+		Workers:     DefaultDialbackWorkers,
+		QueueSize:   DefaultDialbackQueueSize,
+	}
+}
+
+type dialbackState struct {
+	attempts int
+	last     time.Time
+}
+
+// Dialbacker dials back the advertised public addresses of the peers that connected to us,
+// to find out whether they are dialable themselves (or behind a NAT). The attempts are
+// rate-limited and capped per peer, and run on their own pool, apart from the metadata requests.
+type Dialbacker struct {
+	conf   DialbackConfig
+	dialer DialbackDialer
+	pool   *utils.WorkerPool
+
+	m     sync.Mutex
+	peers map[peer.ID]*dialbackState
+	now   func() time.Time
+
+	// gets the outcome of every dial-back
+	onResult func(models.Dialback)
+}
+
+// NewDialbacker returns a Dialbacker that reports the outcome of every dial-back to onResult.
+// A nil dialer dials plain TCP connections.
+func NewDialbacker(ctx context.Context, conf DialbackConfig, dialer DialbackDialer, onResult func(models.Dialback)) *Dialbacker {
+	if dialer == nil {
+		dialer = &tcpDialbackDialer{}
+	}
+	return &Dialbacker{
+		conf:     conf,
+		dialer:   dialer,
+		pool:     utils.NewWorkerPool(ctx, conf.Workers, conf.QueueSize, conf.Timeout),
+		peers:    make(map[peer.ID]*dialbackState),
+		now:      time.Now,
+		onResult: onResult,
+	}
+}
+
+func (d *Dialbacker) Start() {
+	d.pool.Start()
+}
+
+// Close waits until the queued dial-backs finish.
+func (d *Dialbacker) Close() {
+	d.pool.Close()
+}
+
+// DialbackAddrs returns the addresses of the given ones that can be dialed back:
+// public, non-relayed and over TCP.
+func DialbackAddrs(addrs []ma.Multiaddr) []ma.Multiaddr {
+	dialable := make([]ma.Multiaddr, 0, len(addrs))
+	for _, addr := range addrs {
+		if utils.IsRelayMAddr(addr) || !manet.IsPublicAddr(addr) {
+			continue
+		}
+		if _, err := addr.ValueForProtocol(ma.P_TCP); err != nil {
+			continue
+		}
+		dialable = append(dialable, addr)
+	}
+	return dialable
+}
+
+// Schedule queues a dial-back to the given addresses of the peer, unless it ran out of attempts,
+// its last attempt is too recent, or the pool is full. Returns whether it was queued.
+func (d *Dialbacker) Schedule(pid peer.ID, addrs []ma.Multiaddr) bool {
+	d.m.Lock()
+	state, ok := d.peers[pid]
+	if !ok {
+		state = &dialbackState{}
+		d.peers[pid] = state
+	}
+	now := d.now()
+	if state.attempts >= d.conf.MaxAttempts || (state.attempts > 0 && now.Sub(state.last) < d.conf.Interval) {
+		d.m.Unlock()
+		return false
+	}
+	state.attempts++
+	state.last = now
+	d.m.Unlock()
+
+	dialable := DialbackAddrs(addrs)
+	submitted := d.pool.TrySubmit(func(ctx context.Context) {
+		d.onResult(d.dialback(ctx, pid, dialable))
+	})
+	if !submitted {
+		// give the attempt back, it didn't happen
+		d.m.Lock()
+		state.attempts--
+		d.m.Unlock()
+		log.Debugf("dial-back pool full, skipping peer %s", pid.String())
+	}
+	return submitted
+}
+
+// dialback tries the addresses in order until one of them answers.
+func (d *Dialbacker) dialback(ctx context.Context, pid peer.ID, addrs []ma.Multiaddr) models.Dialback {
+	if len(addrs) == 0 {
+		// the peer doesn't advertise any address that we could reach
+		return models.NewDialback(pid, nil, DialErrorNoPublicIP)
+	}
+	var dialErr error
+	for _, addr := range addrs {
+		dialErr = d.dialer.Dial(ctx, addr)
+		if dialErr == nil {
+			log.Debugf("dial-back to peer %s succeeded at %s", pid.String(), addr.String())
+			return models.NewDialback(pid, addr, "")
+		}
+		log.WithFields(log.Fields{
+			"ERROR": dialErr.Error(),
+		}).Debugf("dial-back to peer %s at %s", pid.String(), addr.String())
+	}
+	return models.NewDialback(pid, addrs[len(addrs)-1], ParseConError(dialErr))
+}
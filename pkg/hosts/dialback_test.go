@@ -0,0 +1,154 @@
+package hosts
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/migalabs/armiarma/pkg/db/models"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeDialer answers from the addresses in reachable, failing with dialErr for the rest.
+type fakeDialer struct {
+	m         sync.Mutex
+	reachable map[string]bool
+	dialErr   error
+	dialed    []string
+}
+
+func (d *fakeDialer) Dial(ctx context.Context, addr ma.Multiaddr) error {
+	d.m.Lock()
+	defer d.m.Unlock()
+	d.dialed = append(d.dialed, addr.String())
+	if d.reachable[addr.String()] {
+		return nil
+	}
+	return d.dialErr
+}
+
+func (d *fakeDialer) Dialed() []string {
+	d.m.Lock()
+	defer d.m.Unlock()
+	return append([]string(nil), d.dialed...)
+}
+
+func mustMAddrs(t *testing.T, addrs ...string) []ma.Multiaddr {
+	mAddrs := make([]ma.Multiaddr, 0, len(addrs))
+	for _, addr := range addrs {
+		mAddr, err := ma.NewMultiaddr(addr)
+		require.NoError(t, err)
+		mAddrs = append(mAddrs, mAddr)
+	}
+	return mAddrs
+}
+
+// newTestDialbacker returns a started Dialbacker whose results get sent to the returned channel.
+func newTestDialbacker(t *testing.T, conf DialbackConfig, dialer DialbackDialer) (*Dialbacker, chan models.Dialback) {
+	results := make(chan models.Dialback, 16)
+	d := NewDialbacker(context.Background(), conf, dialer, func(dialback models.Dialback) {
+		results <- dialback
+	})
+	d.Start()
+	t.Cleanup(d.Close)
+	return d, results
+}
+
+func nextDialback(t *testing.T, results chan models.Dialback) models.Dialback {
+	select {
+	case dialback := <-results:
+		return dialback
+	case <-time.After(5 * time.Second):
+		t.Fatal("no dial-back result")
+		return models.Dialback{}
+	}
+}
+
+func TestDialbackAddrs(t *testing.T) {
+	addrs := mustMAddrs(t,
+		"/ip4/95.217.33.10/tcp/9000",
+		"/ip4/192.168.1.10/tcp/9000",
+		"/ip4/127.0.0.1/tcp/9000",
+		"/ip4/95.217.33.10/udp/9000/quic",
+		"/ip4/95.217.33.11/tcp/4001/p2p/QmZ4FEYA4BXTL7KomBWrk9UByYmC5fG3JEHSdbrSnZNtSn/p2p-circuit",
+		"/ip6/2a01:4f9:4a:1234::1/tcp/9000",
+	)
+	require.Equal(t, mustMAddrs(t,
+		"/ip4/95.217.33.10/tcp/9000",
+		"/ip6/2a01:4f9:4a:1234::1/tcp/9000",
+	), DialbackAddrs(addrs))
+}
+
+func TestDialbackSuccess(t *testing.T) {
+	dialer := &fakeDialer{
+		reachable: map[string]bool{"/ip4/95.217.33.11/tcp/9000": true},
+		dialErr:   errors.New("dial tcp4 95.217.33.10:9000: connect: connection refused"),
+	}
+	d, results := newTestDialbacker(t, DefaultDialbackConfig(), dialer)
+	pid := peer.ID("inbound-peer")
+
+	require.True(t, d.Schedule(pid, mustMAddrs(t,
+		"/ip4/10.0.0.2/tcp/9000",
+		"/ip4/95.217.33.10/tcp/9000",
+		"/ip4/95.217.33.11/tcp/9000",
+	)))
+	dialback := nextDialback(t, results)
+	require.Equal(t, pid, dialback.PeerID)
+	require.True(t, dialback.Success)
+	require.Empty(t, dialback.Error)
+	require.Equal(t, "/ip4/95.217.33.11/tcp/9000", dialback.AddrString())
+	// the private address is never dialed, and the rest in order until one answers
+	require.Equal(t, []string{"/ip4/95.217.33.10/tcp/9000", "/ip4/95.217.33.11/tcp/9000"}, dialer.Dialed())
+}
+
+func TestDialbackFailure(t *testing.T) {
+	dialer := &fakeDialer{dialErr: errors.New("dial tcp4 95.217.33.10:9000: connect: connection refused")}
+	d, results := newTestDialbacker(t, DefaultDialbackConfig(), dialer)
+
+	require.True(t, d.Schedule(peer.ID("natted-peer"), mustMAddrs(t, "/ip4/95.217.33.10/tcp/9000")))
+	dialback := nextDialback(t, results)
+	require.False(t, dialback.Success)
+	require.Equal(t, DialErrorConnectionRefused, dialback.Error)
+	require.Equal(t, "/ip4/95.217.33.10/tcp/9000", dialback.AddrString())
+
+	// the peers without any public address are unreachable without dialing them
+	require.True(t, d.Schedule(peer.ID("private-peer"), mustMAddrs(t, "/ip4/192.168.1.10/tcp/9000")))
+	dialback = nextDialback(t, results)
+	require.False(t, dialback.Success)
+	require.Equal(t, DialErrorNoPublicIP, dialback.Error)
+	require.Empty(t, dialback.AddrString())
+	require.Len(t, dialer.Dialed(), 1)
+}
+
+func TestDialbackRateLimit(t *testing.T) {
+	dialer := &fakeDialer{reachable: map[string]bool{"/ip4/95.217.33.10/tcp/9000": true}}
+	conf := DefaultDialbackConfig()
+	conf.MaxAttempts = 2
+	conf.Interval = time.Hour
+	d, results := newTestDialbacker(t, conf, dialer)
+	now := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+	d.now = func() time.Time { return now }
+	pid := peer.ID("reconnecting-peer")
+	addrs := mustMAddrs(t, "/ip4/95.217.33.10/tcp/9000")
+
+	require.True(t, d.Schedule(pid, addrs))
+	nextDialback(t, results)
+	// too soon after the previous one
+	now = now.Add(30 * time.Minute)
+	require.False(t, d.Schedule(pid, addrs))
+	// other peers are not affected
+	require.True(t, d.Schedule(peer.ID("other-peer"), addrs))
+	nextDialback(t, results)
+
+	now = now.Add(time.Hour)
+	require.True(t, d.Schedule(pid, addrs))
+	nextDialback(t, results)
+	// out of attempts
+	now = now.Add(24 * time.Hour)
+	require.False(t, d.Schedule(pid, addrs))
+	require.Len(t, dialer.Dialed(), 3)
+}
@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/migalabs/armiarma/pkg/db/models"
+	eth "github.com/migalabs/armiarma/pkg/networks/ethereum"
 	"github.com/migalabs/armiarma/pkg/utils"
 	"github.com/migalabs/armiarma/pkg/utils/apis"
 
@@ -13,6 +14,7 @@ import (
 	conmgr "github.com/libp2p/go-libp2p-connmgr"
 	"github.com/libp2p/go-libp2p-core/crypto"
 	"github.com/libp2p/go-libp2p-core/host"
+	p2pmetrics "github.com/libp2p/go-libp2p-core/metrics"
 	"github.com/libp2p/go-libp2p-core/peer"
 	noise "github.com/libp2p/go-libp2p-noise"
 	"github.com/libp2p/go-libp2p/p2p/protocol/identify"
@@ -29,6 +31,14 @@ var (
 	ConnNotChannSize = 256
 )
 
+const (
+	// size of the pool that identifies and requests the metadata of the new connections,
+	// and the time that each connection gets to answer them
+	DefaultMetadataWorkers   = 64
+	DefaultMetadataQueueSize = 1024
+	DefaultMetadataTimeout   = 5 * time.Second
+)
+
 type P2pNetwork interface {
 	Network() utils.NetworkType
 }
@@ -49,9 +59,26 @@ type BasicLibp2pHost struct {
 	connEventNotChannel chan *models.EventTrace
 	identNotChannel     chan IdentificationEvent
 	peerID              peer.ID
+
+	// bounds the concurrent identifications and metadata requests of the new connections
+	metadataPool *utils.WorkerPool
+	// dials back the inbound peers (nil if disabled)
+	dialbacker *Dialbacker
+	// flags the peers whose BeaconStatus belongs to a different network (nil accepts any)
+	networkFilter *eth.NetworkFilter
+	// decides whether the Status and MetaData of the new connections get requested (nil requests them always)
+	reqScheduler RequestScheduler
+}
+
+// RequestScheduler decides whether the Status and MetaData of a peer that got connected have to be
+// requested again (i.e. metrics.PeerStore, which backs off the requests that keep failing).
+type RequestScheduler interface {
+	ShouldRequestStatus(pid peer.ID, now time.Time) bool
+	ShouldRequestMetadata(pid peer.ID, now time.Time) bool
 }
 
 // NewBasicLibp2pEth2Host generate a new Libp2p host from the given context and Options, for Eth2 network (or similar).
+// The traffic of the streams gets reported to bwReporter (if any).
 func NewBasicLibp2pEth2Host(
 	ctx context.Context,
 	ip string,
@@ -59,7 +86,8 @@ func NewBasicLibp2pEth2Host(
 	privKey *crypto.Secp256k1PrivateKey,
 	userAgent string,
 	netNode P2pNetwork,
-	ipLocator *apis.IpLocator) (*BasicLibp2pHost, error) {
+	ipLocator *apis.IpLocator,
+	bwReporter p2pmetrics.Reporter) (*BasicLibp2pHost, error) {
 
 	// generate de multiaddress
 	multiaddr, err := ma.NewMultiaddr(fmt.Sprintf("/ip4/%s/tcp/%d", ip, port))
@@ -74,7 +102,7 @@ func NewBasicLibp2pEth2Host(
 	conMngr := conmgr.NewConnManager(low, hi, graceTime)
 
 	// Generate the main Libp2p host that will be exposed to the network
-	host, err := libp2p.New(
+	opts := []libp2p.Option{
 		libp2p.ListenAddrs(multiaddr),
 		libp2p.Identity(privKey),
 		libp2p.UserAgent(userAgent),
@@ -82,7 +110,11 @@ func NewBasicLibp2pEth2Host(
 		libp2p.Security(noise.ID, noise.New),
 		libp2p.NATPortMap(),
 		libp2p.ConnectionManager(conMngr),
-	)
+	}
+	if bwReporter != nil {
+		opts = append(opts, libp2p.BandwidthReporter(bwReporter))
+	}
+	host, err := libp2p.New(opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -112,6 +144,7 @@ func NewBasicLibp2pEth2Host(
 		peerID:              host.ID(),
 		connEventNotChannel: make(chan *models.EventTrace, ConnNotChannSize),
 		identNotChannel:     make(chan IdentificationEvent, ConnNotChannSize),
+		metadataPool:        utils.NewWorkerPool(ctx, DefaultMetadataWorkers, DefaultMetadataQueueSize, DefaultMetadataTimeout),
 	}
 	log.Debug("setting custom notification functions")
 	basicHost.SetCustomNotifications()
@@ -185,10 +218,57 @@ func (b *BasicLibp2pHost) Host() host.Host {
 
 // Start spawns the libp2pHost module
 // So far, start listening on the multiAddrs.
+// SetMetadataPool replaces the pool that identifies and requests the metadata of the new connections
+// (the connections that don't fit in its queue are recorded without them). It has to be set before Start.
+func (b *BasicLibp2pHost) SetMetadataPool(workers, queueSize int, timeout time.Duration) {
+	b.metadataPool = utils.NewWorkerPool(b.ctx, workers, queueSize, timeout)
+}
+
+// EnableDialback makes the host dial back the advertised public addresses of the peers that connect to us,
+// reporting the outcome as an identification event (models.DialbackAttribute). A nil dialer dials
+// plain TCP connections. It has to be set before Start.
+func (b *BasicLibp2pHost) EnableDialback(conf DialbackConfig, dialer DialbackDialer) {
+	b.dialbacker = NewDialbacker(b.ctx, conf, dialer, func(dialback models.Dialback) {
+		hInfo := models.NewHostInfo(dialback.PeerID, b.NetworkNode.Network())
+		hInfo.AddAtt(models.DialbackAttribute, dialback)
+		b.RecIdentEvent(IdentificationEvent{
+			HostInfo:  hInfo,
+			Timestamp: dialback.Timestamp,
+		})
+	})
+}
+
+// SetNetworkFilter makes the host flag the peers whose BeaconStatus has a fork digest that the filter doesn't
+// allow (eth.ForeignNetworkAttribute), instead of recording their status. It has to be set before Start.
+func (b *BasicLibp2pHost) SetNetworkFilter(filter *eth.NetworkFilter) {
+	b.networkFilter = filter
+}
+
+// SetRequestScheduler makes the host only request the Status and MetaData of the new connections
+// when the scheduler decides so. It has to be set before Start.
+func (b *BasicLibp2pHost) SetRequestScheduler(scheduler RequestScheduler) {
+	b.reqScheduler = scheduler
+}
+
 func (b *BasicLibp2pHost) Start() error {
+	b.metadataPool.Start()
+	if b.dialbacker != nil {
+		b.dialbacker.Start()
+	}
 	return b.host.Network().Listen()
 }
 
+// Close closes the libp2p host, and waits until the metadata of the already queued connections is fetched
+// (and the queued dial-backs are done).
+func (b *BasicLibp2pHost) Close() error {
+	err := b.host.Close()
+	b.metadataPool.Close()
+	if b.dialbacker != nil {
+		b.dialbacker.Close()
+	}
+	return err
+}
+
 func (b *BasicLibp2pHost) Ctx() context.Context {
 	return b.ctx
 }
@@ -2,6 +2,7 @@ package hosts
 
 import (
 	"context"
+	"net"
 	"sync"
 	"time"
 
@@ -9,10 +10,12 @@ import (
 
 	"github.com/libp2p/go-libp2p-core/host"
 	"github.com/migalabs/armiarma/pkg/db/models"
+	"github.com/migalabs/armiarma/pkg/utils"
 	"github.com/migalabs/armiarma/pkg/utils/apis"
 
 	"github.com/libp2p/go-libp2p-core/network"
 	"github.com/libp2p/go-libp2p/p2p/protocol/identify"
+	ma "github.com/multiformats/go-multiaddr"
 )
 
 // Identify the peer from the Libp2p Identify Service
@@ -68,12 +71,19 @@ func ReqHostInfo(ctx context.Context, wg *sync.WaitGroup, h host.Host, ipLoc *ap
 	}
 	// Update the values of the
 	hInfo.PeerInfo.Latency = rtt
+	hInfo.PeerInfo.Relayed = utils.IsRelayMAddr(conn.RemoteMultiaddr())
 	hInfo.PeerInfo.RemotePeer = peerID
+	// the addresses that the peer advertised replace the ones we had, and the public one among
+	// them (if any) gets located (the address of the connection wins the ties)
+	hInfo.SetIdentifiedMAddrs(append([]ma.Multiaddr{conn.RemoteMultiaddr()}, h.Peerstore().Addrs(peerID)...))
+	if ipLoc != nil && utils.IsIPPublic(net.ParseIP(hInfo.IP)) {
+		ipLoc.LocateIP(hInfo.IP)
+	}
 
 	// Fulfill the hInfo struct
 	ua, err := h.Peerstore().Get(peerID, "AgentVersion")
 	if err == nil {
-		hInfo.PeerInfo.UserAgent = ua.(string)
+		hInfo.PeerInfo.SetUserAgent(ua.(string))
 	} else {
 		// EDGY CASE: when peers refuse the connection, the callback gets called and the identify protocol
 		// returns an empty struct (we are unable to identify them)
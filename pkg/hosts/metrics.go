@@ -22,6 +22,16 @@ var (
 	},
 		[]string{"protocol"},
 	)
+	MetadataQueueDepth = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: moduleName,
+		Name:      "metadata_queue_depth",
+		Help:      "The number of new connections waiting to be identified",
+	})
+	MetadataDroppedTasks = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: moduleName,
+		Name:      "metadata_dropped_tasks",
+		Help:      "The number of new connections recorded without identification because the metadata queue was full",
+	})
 )
 
 func (bh *BasicLibp2pHost) GetMetrics() *metrics.MetricsModule {
@@ -31,6 +41,8 @@ func (bh *BasicLibp2pHost) GetMetrics() *metrics.MetricsModule {
 	)
 	metricsMod.AddIndvMetric(bh.connectedPeers())
 	metricsMod.AddIndvMetric(bh.supportedProtocols())
+	metricsMod.AddIndvMetric(bh.metadataQueueDepth())
+	metricsMod.AddIndvMetric(bh.metadataDroppedTasks())
 	return metricsMod
 }
 
@@ -82,3 +94,47 @@ func (bh *BasicLibp2pHost) supportedProtocols() *metrics.IndvMetrics {
 	}
 	return peersTop
 }
+
+func (bh *BasicLibp2pHost) metadataQueueDepth() *metrics.IndvMetrics {
+	initFn := func() error {
+		prometheus.Register(MetadataQueueDepth)
+		return nil
+	}
+	updateFn := func() (interface{}, error) {
+		depth := bh.metadataPool.QueueDepth()
+		MetadataQueueDepth.Set(float64(depth))
+		return depth, nil
+	}
+	queueDepth, err := metrics.NewIndvMetrics(
+		"metadata_queue_depth",
+		initFn,
+		updateFn,
+	)
+	if err != nil {
+		log.Error(err)
+		return nil
+	}
+	return queueDepth
+}
+
+func (bh *BasicLibp2pHost) metadataDroppedTasks() *metrics.IndvMetrics {
+	initFn := func() error {
+		prometheus.Register(MetadataDroppedTasks)
+		return nil
+	}
+	updateFn := func() (interface{}, error) {
+		dropped := bh.metadataPool.Dropped()
+		MetadataDroppedTasks.Set(float64(dropped))
+		return dropped, nil
+	}
+	droppedTasks, err := metrics.NewIndvMetrics(
+		"metadata_dropped_tasks",
+		initFn,
+		updateFn,
+	)
+	if err != nil {
+		log.Error(err)
+		return nil
+	}
+	return droppedTasks
+}
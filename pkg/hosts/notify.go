@@ -11,6 +11,7 @@ import (
 	"github.com/migalabs/armiarma/pkg/utils"
 
 	ma "github.com/multiformats/go-multiaddr"
+	"github.com/pkg/errors"
 	"github.com/protolambda/zrnt/eth2/beacon/common"
 	log "github.com/sirupsen/logrus"
 )
@@ -19,6 +20,9 @@ import (
 	File that includes the methods to set the custom modification channels for the Libp2p host
 */
 
+// ErrMetadataDropped is the error of the connections whose identification was dropped, as the metadata pool was full.
+var ErrMetadataDropped = errors.New("metadata fetch dropped")
+
 type IdentificationEvent struct {
 	HostInfo  *models.HostInfo
 	Timestamp time.Time // Timestamp of when was the attempt done
@@ -43,9 +47,11 @@ func (c *BasicLibp2pHost) standardConnectF(net network.Network, conn network.Con
 
 	// Only locate new IP if the connection is "Inbound"
 	// if it's outbound - we should already have it in the DB
-	if conn.Stat().Direction.String() == "Inbound" {
-		ip := utils.ExtractIPFromMAddr(conn.RemoteMultiaddr()).String()
-		c.IpLocator.LocateIP(ip)
+	// (relayed connections only expose the IP of the relay, and the private ones can't be located)
+	if conn.Stat().Direction.String() == "Inbound" && !utils.IsRelayMAddr(conn.RemoteMultiaddr()) {
+		if ip := utils.ExtractIPFromMAddr(conn.RemoteMultiaddr()); utils.IsIPPublic(ip) {
+			c.IpLocator.LocateIP(ip.String())
+		}
 	}
 
 	// since se only have one multiaddress, gen the array
@@ -59,9 +65,29 @@ func (c *BasicLibp2pHost) standardConnectF(net network.Network, conn network.Con
 		models.WithMultiaddress(mAddrs),
 	)
 
-	// Aggregate timeout context for the different
-	mainCtx, cancel := context.WithTimeout(c.Ctx(), 5*time.Second)
-	defer cancel()
+	// the identification and the metadata are fetched by the pool, with its timeout
+	submitted := c.metadataPool.TrySubmit(func(ctx context.Context) {
+		c.fetchHostMetadata(ctx, conn, hInfo, t)
+	})
+	if !submitted {
+		log.Debugf("metadata pool full, skipping the identification of peer %s", conn.RemotePeer().String())
+		// the connection is still recorded, without the identification
+		connEvent := &models.ConnInfo{
+			Direction: models.ConnDirection(conn.Stat().Direction),
+			ConnTime:  t,
+			Error:     ErrMetadataDropped.Error(),
+		}
+		connEvent.SetConnAddrs(conn)
+		c.RecConnEvent(&models.EventTrace{
+			PeerID: conn.RemotePeer(),
+			Event:  connEvent,
+		})
+	}
+}
+
+// fetchHostMetadata identifies the peer of the new connection and requests its metadata (for Eth2),
+// recording the connection and the identification events.
+func (c *BasicLibp2pHost) fetchHostMetadata(mainCtx context.Context, conn network.Conn, hInfo *models.HostInfo, t time.Time) {
 	// set sync group and error groups to handle different reqresps
 	var wg sync.WaitGroup
 
@@ -78,15 +104,26 @@ func (c *BasicLibp2pHost) standardConnectF(net network.Network, conn network.Con
 	wg.Add(1)
 	go ReqHostInfo(mainCtx, &wg, h, c.IpLocator, conn, hInfo, &hinfoErr)
 
+	// the requests that keep failing on the reconnections of the peer are backed off
+	reqStatus, reqMetadata := true, true
+	if c.reqScheduler != nil {
+		reqStatus = c.reqScheduler.ShouldRequestStatus(conn.RemotePeer(), t)
+		reqMetadata = c.reqScheduler.ShouldRequestMetadata(conn.RemotePeer(), t)
+	}
+
 	switch c.NetworkNode.(type) {
 	case (*eth.LocalEthereumNode):
 		ethNet := c.NetworkNode.(*eth.LocalEthereumNode)
 		// request BeaconStatus metadata as we connect to a peer
-		wg.Add(1)
-		go ethNet.ReqBeaconStatus(mainCtx, &wg, h, conn.RemotePeer(), &bStatus, &statusErr)
+		if reqStatus {
+			wg.Add(1)
+			go ethNet.ReqBeaconStatus(mainCtx, &wg, h, conn.RemotePeer(), &bStatus, &statusErr)
+		}
 		// request the BeaconMetadata
-		wg.Add(1)
-		go ethNet.ReqBeaconMetadata(mainCtx, &wg, h, conn.RemotePeer(), &bMetadata, &metadataErr)
+		if reqMetadata {
+			wg.Add(1)
+			go ethNet.ReqBeaconMetadata(mainCtx, &wg, h, conn.RemotePeer(), &bMetadata, &metadataErr)
+		}
 	default:
 	}
 
@@ -102,28 +139,48 @@ func (c *BasicLibp2pHost) standardConnectF(net network.Network, conn network.Con
 		log.Debug("peer identified, succeed")
 	}
 
+	// dial back the inbound peers to the addresses that they advertised through identify
+	// (the relayed ones don't tell anything about their own reachability)
+	if c.dialbacker != nil && conn.Stat().Direction == network.DirInbound && !utils.IsRelayMAddr(conn.RemoteMultiaddr()) {
+		c.dialbacker.Schedule(conn.RemotePeer(), h.Peerstore().Addrs(conn.RemotePeer()))
+	}
+
 	// If the network was eth2, wait for the metadata echange to reply
 	switch c.NetworkNode.(type) {
 	case (*eth.LocalEthereumNode):
 		// Beacon Status reqresp error check
 		// if there is an error  in the channel, print error
-		if statusErr != nil {
-			log.WithFields(log.Fields{
-				"ERROR": statusErr.Error(),
-			}).Debug("ReqStatus Peer: ", conn.RemotePeer().String())
+		if reqStatus {
+			hInfo.AddAtt(models.StatusRequestAttribute, eth.StatusRequestOutcome(conn.RemotePeer(), statusErr))
+			if statusErr != nil {
+				log.WithFields(log.Fields{
+					"ERROR": statusErr.Error(),
+				}).Debug("ReqStatus Peer: ", conn.RemotePeer().String())
+			} else if c.networkFilter != nil && !c.networkFilter.KeepStatus(bStatus) {
+				// the peers of other networks are flagged (to get deprecated) without recording their status
+				log.Debugf("peer %s belongs to a foreign network, fork digest %s", conn.RemotePeer().String(), bStatus.ForkDigest.String())
+				hInfo.AddAtt(eth.ForeignNetworkAttribute, eth.NewForeignNetworkStatus(conn.RemotePeer(), bStatus))
+			} else {
+				log.Debug("peer status req, succeed", bStatus)
+				hInfo.AddAtt("beacon-status", eth.NewBeaconStatus(conn.RemotePeer(), bStatus))
+			}
 		} else {
-			log.Debug("peer status req, succeed", bStatus)
-			hInfo.AddAtt("beacon-status", eth.NewBeaconStatus(conn.RemotePeer(), bStatus))
+			log.Tracef("status request to peer %s backed off", conn.RemotePeer().String())
 		}
 		// // Beacon Metadata reqresp error check
 		// // if if there is an error  in the channel, print error
-		if metadataErr != nil {
-			log.WithFields(log.Fields{
-				"ERROR": metadataErr.Error(),
-			}).Debug("ReqMetadata Peer: ", conn.RemotePeer().String())
+		if reqMetadata {
+			hInfo.AddAtt(models.MetadataRequestAttribute, eth.MetadataRequestOutcome(conn.RemotePeer(), metadataErr))
+			if metadataErr != nil {
+				log.WithFields(log.Fields{
+					"ERROR": metadataErr.Error(),
+				}).Debug("ReqMetadata Peer: ", conn.RemotePeer().String())
+			} else {
+				log.Debug("peer metadata req, succeed", bMetadata)
+				hInfo.AddAtt(models.MetadataAttribute, eth.NewBeaconMetadata(conn.RemotePeer(), bMetadata))
+			}
 		} else {
-			log.Debug("peer metadata req, succeed", bMetadata)
-			hInfo.AddAtt("beaconmetadata", eth.NewBeaconMetadata(conn.RemotePeer(), bMetadata))
+			log.Tracef("metadata request to peer %s backed off", conn.RemotePeer().String())
 		}
 	default:
 	}
@@ -141,6 +198,7 @@ func (c *BasicLibp2pHost) standardConnectF(net network.Network, conn network.Con
 		Identified: hInfo.IsHostIdentified(),
 		Error:      hinfoErr.Error(),
 	}
+	connEvent.SetConnAddrs(conn)
 
 	// Record the connectino event
 	c.RecConnEvent(&models.EventTrace{
@@ -0,0 +1,158 @@
+package metrics
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// maximum number of delay samples kept per peer and topic to calculate the percentiles
+	maxDelaySamples = 1000
+	// short name of the beacon block topic, whose average delay is exported
+	BeaconBlockTopicName = "beacon_block"
+)
+
+// SlotClock translates the slots of the crawled network into their start time.
+type SlotClock struct {
+	Genesis      time.Time
+	SlotDuration time.Duration
+}
+
+// NewSlotClock returns the SlotClock of a network with the given genesis and slot duration.
+func NewSlotClock(genesis time.Time, slotDuration time.Duration) *SlotClock {
+	return &SlotClock{
+		Genesis:      genesis,
+		SlotDuration: slotDuration,
+	}
+}
+
+// SlotStart returns the time at which the given slot started.
+func (c *SlotClock) SlotStart(slot int64) time.Time {
+	return c.Genesis.Add(time.Duration(slot) * c.SlotDuration)
+}
+
+// DelayStats accumulates the arrival delays (in milliseconds) of the messages of a topic
+// relative to the start of their slot.
+type DelayStats struct {
+	Count   int64     `json:"count"`
+	MinMs   float64   `json:"min_ms"`
+	SumMs   float64   `json:"sum_ms"`
+	Samples []float64 `json:"samples,omitempty"` // last maxDelaySamples delays, for the percentiles
+}
+
+// Add accumulates a new delay.
+func (d *DelayStats) Add(delay time.Duration) {
+	ms := float64(delay) / float64(time.Millisecond)
+	if d.Count == 0 || ms < d.MinMs {
+		d.MinMs = ms
+	}
+	if len(d.Samples) < maxDelaySamples {
+		d.Samples = append(d.Samples, ms)
+	} else {
+		d.Samples[d.Count%maxDelaySamples] = ms
+	}
+	d.Count++
+	d.SumMs += ms
+}
+
+// merge aggregates the delays of other into d.
+func (d *DelayStats) merge(other *DelayStats) {
+	if other == nil || other.Count == 0 {
+		return
+	}
+	if d.Count == 0 || other.MinMs < d.MinMs {
+		d.MinMs = other.MinMs
+	}
+	d.Count += other.Count
+	d.SumMs += other.SumMs
+	for _, ms := range other.Samples {
+		if len(d.Samples) >= maxDelaySamples {
+			break
+		}
+		d.Samples = append(d.Samples, ms)
+	}
+}
+
+func (d *DelayStats) copy() *DelayStats {
+	if d == nil {
+		return nil
+	}
+	cp := *d
+	cp.Samples = append(make([]float64, 0, len(d.Samples)), d.Samples...)
+	return &cp
+}
+
+// ArrivalDelayStats is the summary of the arrival delays of a topic.
+type ArrivalDelayStats struct {
+	Count int64
+	MinMs float64
+	AvgMs float64
+	P95Ms float64
+}
+
+// stats summarizes the accumulated delays.
+func (d *DelayStats) stats() ArrivalDelayStats {
+	if d == nil || d.Count == 0 {
+		return ArrivalDelayStats{}
+	}
+	sorted := append(make([]float64, 0, len(d.Samples)), d.Samples...)
+	sort.Float64s(sorted)
+	p95 := 0.0
+	if len(sorted) > 0 {
+		rank := int(math.Ceil(0.95 * float64(len(sorted))))
+		p95 = sorted[rank-1]
+	}
+	return ArrivalDelayStats{
+		Count: d.Count,
+		MinMs: d.MinMs,
+		AvgMs: d.SumMs / float64(d.Count),
+		P95Ms: p95,
+	}
+}
+
+// MessageEventAtSlot tracks a new gossip message whose slot is known, accumulating
+// its arrival delay relative to the start of the slot.
+func (p *Peer) MessageEventAtSlot(topic string, t time.Time, slot int64, clock *SlotClock) {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	msgMetric := p.messageEvent(topic, t)
+	if clock == nil || slot < 0 {
+		return
+	}
+	if msgMetric.ArrivalDelays == nil {
+		msgMetric.ArrivalDelays = &DelayStats{}
+	}
+	msgMetric.ArrivalDelays.Add(t.Sub(clock.SlotStart(slot)))
+}
+
+// GetArrivalDelayStats returns the arrival delay stats of the messages received from the peer
+// on the topics with the given short name (i.e. "beacon_block").
+func (p *Peer) GetArrivalDelayStats(shortTopic string) ArrivalDelayStats {
+	p.m.RLock()
+	defer p.m.RUnlock()
+	return p.arrivalDelayStats(shortTopic)
+}
+
+// arrivalDelayStats aggregates the delays of the matching topics (needs the lock).
+func (p *Peer) arrivalDelayStats(shortTopic string) ArrivalDelayStats {
+	agg := &DelayStats{}
+	for topic, msgMetric := range p.MessageMetrics {
+		if shortTopicName(topic) == shortTopic {
+			agg.merge(msgMetric.ArrivalDelays)
+		}
+	}
+	return agg.stats()
+}
+
+// shortTopicName returns the name of the topic without the prefix and the encoding
+// (i.e. "beacon_block" for "/eth2/4a26c58b/beacon_block/ssz_snappy").
+func shortTopicName(topic string) string {
+	parts := strings.Split(topic, "/")
+	if len(parts) >= 4 && parts[3] != "" {
+		return parts[3]
+	}
+	return topic
+}
@@ -0,0 +1,96 @@
+package metrics
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testBlockTopic = "/eth2/4a26c58b/beacon_block/ssz_snappy"
+	testAttTopic   = "/eth2/4a26c58b/beacon_attestation_3/ssz_snappy"
+)
+
+func Test_ArrivalDelayStats(t *testing.T) {
+	genesis := time.Unix(1606824023, 0)
+	clock := NewSlotClock(genesis, 12*time.Second)
+	p := NewPeer(testPeerID("delays"))
+
+	// blocks of slots 100..119 arriving 100ms, 200ms, ... 2000ms after the slot start
+	for i := 0; i < 20; i++ {
+		slot := int64(100 + i)
+		arrival := genesis.Add(time.Duration(slot)*12*time.Second + time.Duration(i+1)*100*time.Millisecond)
+		p.MessageEventAtSlot(testBlockTopic, arrival, slot, clock)
+	}
+	// messages without slot count but don't contribute to the delays
+	p.MessageEvent(testBlockTopic, genesis.Add(time.Hour))
+	p.MessageEventAtSlot(testBlockTopic, genesis.Add(time.Hour), -1, clock)
+	p.MessageEventAtSlot(testBlockTopic, genesis.Add(time.Hour), 10, nil)
+
+	stats := p.GetArrivalDelayStats("beacon_block")
+	require.Equal(t, int64(20), stats.Count)
+	require.Equal(t, 100.0, stats.MinMs)
+	require.Equal(t, 1050.0, stats.AvgMs)
+	require.Equal(t, 1900.0, stats.P95Ms)
+	require.Equal(t, int64(23), p.MessageMetrics[testBlockTopic].Count)
+
+	// other topics are kept apart
+	p.MessageEventAtSlot(testAttTopic, genesis.Add(12*time.Second+4*time.Second), 1, clock)
+	require.Equal(t, 4000.0, p.GetArrivalDelayStats("beacon_attestation_3").AvgMs)
+	require.Equal(t, int64(20), p.GetArrivalDelayStats("beacon_block").Count)
+	require.Equal(t, int64(0), p.GetArrivalDelayStats("voluntary_exit").Count)
+
+	// the delays survive copies and checkpoints
+	cp := p.Copy()
+	require.Equal(t, stats, cp.GetArrivalDelayStats("beacon_block"))
+	data, err := json.Marshal(p)
+	require.NoError(t, err)
+	restored := NewPeer("")
+	require.NoError(t, json.Unmarshal(data, restored))
+	require.Equal(t, stats, restored.GetArrivalDelayStats("beacon_block"))
+
+	// merging accumulates the delays
+	restored.Merge(p)
+	merged := restored.GetArrivalDelayStats("beacon_block")
+	require.Equal(t, int64(40), merged.Count)
+	require.Equal(t, 1050.0, merged.AvgMs)
+}
+
+func Test_ArrivalDelaySamplesAreBounded(t *testing.T) {
+	genesis := time.Unix(1606824023, 0)
+	clock := NewSlotClock(genesis, 12*time.Second)
+	p := NewPeer(testPeerID("bounded-delays"))
+	for i := 0; i < 3*maxDelaySamples; i++ {
+		slot := int64(i)
+		p.MessageEventAtSlot(testBlockTopic, clock.SlotStart(slot).Add(time.Second), slot, clock)
+	}
+	require.Equal(t, maxDelaySamples, len(p.MessageMetrics[testBlockTopic].ArrivalDelays.Samples))
+	require.Equal(t, 1000.0, p.GetArrivalDelayStats("beacon_block").P95Ms)
+}
+
+func Test_BlockDelayCsvColumn(t *testing.T) {
+	genesis := time.Unix(1606824023, 0)
+	clock := NewSlotClock(genesis, 12*time.Second)
+	store := NewPeerStore()
+	withDelay := store.GetOrCreatePeer(testPeerID("csv-delay"))
+	withDelay.MessageEventAtSlot(testBlockTopic, clock.SlotStart(5).Add(1500*time.Millisecond), 5, clock)
+	store.GetOrCreatePeer(testPeerID("csv-no-delay")).MessageEvent(testBlockTopic, genesis)
+
+	var buf bytes.Buffer
+	require.NoError(t, store.ExportCsv(&buf))
+	records, err := csv.NewReader(&buf).ReadAll()
+	require.NoError(t, err)
+	delayIdx := csvColumn(t, "block_avg_delay_ms")
+	for _, record := range records[1:] {
+		expected := ""
+		if record[0] == withDelay.ID.String() {
+			expected = fmt.Sprintf("%d", 1500)
+		}
+		require.Equal(t, expected, record[delayIdx])
+	}
+}
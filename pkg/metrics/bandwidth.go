@@ -0,0 +1,220 @@
+package metrics
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	p2pmetrics "github.com/libp2p/go-libp2p-core/metrics"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/protocol"
+	"github.com/migalabs/armiarma/pkg/utils"
+)
+
+const (
+	// directions of the traffic with a peer
+	BandwidthIn  = "in"
+	BandwidthOut = "out"
+
+	// categories of the protocols of the traffic with a peer
+	GossipBandwidth  = "gossip"
+	ReqRespBandwidth = "reqresp"
+	OtherBandwidth   = "other"
+)
+
+// BandwidthCategory returns the category of the given protocol ID: gossipsub (and floodsub),
+// eth2 req/resp, or other (identify, ping, etc.).
+func BandwidthCategory(proto string) string {
+	switch {
+	case strings.HasPrefix(proto, "/meshsub/") || strings.HasPrefix(proto, "/floodsub/"):
+		return GossipBandwidth
+	case strings.HasPrefix(proto, "/eth2/beacon_chain/req/"):
+		return ReqRespBandwidth
+	default:
+		return OtherBandwidth
+	}
+}
+
+// PeerBandwidth is the traffic (in bytes) exchanged with a peer per direction and protocol category.
+// The counters are updated with atomic adds (see Peer.AddBandwidth), so they have to be read
+// with Load rather than under the lock of the peer.
+type PeerBandwidth struct {
+	GossipIn   uint64 `json:"gossip_in,omitempty"`
+	GossipOut  uint64 `json:"gossip_out,omitempty"`
+	ReqRespIn  uint64 `json:"reqresp_in,omitempty"`
+	ReqRespOut uint64 `json:"reqresp_out,omitempty"`
+	OtherIn    uint64 `json:"other_in,omitempty"`
+	OtherOut   uint64 `json:"other_out,omitempty"`
+}
+
+// counter returns the counter of the given direction and protocol category (nil for an unknown direction).
+func (b *PeerBandwidth) counter(dir, category string) *uint64 {
+	in := dir == BandwidthIn
+	if !in && dir != BandwidthOut {
+		return nil
+	}
+	switch category {
+	case GossipBandwidth:
+		if in {
+			return &b.GossipIn
+		}
+		return &b.GossipOut
+	case ReqRespBandwidth:
+		if in {
+			return &b.ReqRespIn
+		}
+		return &b.ReqRespOut
+	default:
+		if in {
+			return &b.OtherIn
+		}
+		return &b.OtherOut
+	}
+}
+
+// Load returns a consistent-enough snapshot of the counters (each of them is read atomically).
+func (b *PeerBandwidth) Load() PeerBandwidth {
+	return PeerBandwidth{
+		GossipIn:   atomic.LoadUint64(&b.GossipIn),
+		GossipOut:  atomic.LoadUint64(&b.GossipOut),
+		ReqRespIn:  atomic.LoadUint64(&b.ReqRespIn),
+		ReqRespOut: atomic.LoadUint64(&b.ReqRespOut),
+		OtherIn:    atomic.LoadUint64(&b.OtherIn),
+		OtherOut:   atomic.LoadUint64(&b.OtherOut),
+	}
+}
+
+// add accumulates the given counters.
+func (b *PeerBandwidth) add(o PeerBandwidth) {
+	atomic.AddUint64(&b.GossipIn, o.GossipIn)
+	atomic.AddUint64(&b.GossipOut, o.GossipOut)
+	atomic.AddUint64(&b.ReqRespIn, o.ReqRespIn)
+	atomic.AddUint64(&b.ReqRespOut, o.ReqRespOut)
+	atomic.AddUint64(&b.OtherIn, o.OtherIn)
+	atomic.AddUint64(&b.OtherOut, o.OtherOut)
+}
+
+// In returns the total received bytes (of a snapshot, see Load).
+func (b PeerBandwidth) In() uint64 {
+	return b.GossipIn + b.ReqRespIn + b.OtherIn
+}
+
+// Out returns the total sent bytes (of a snapshot, see Load).
+func (b PeerBandwidth) Out() uint64 {
+	return b.GossipOut + b.ReqRespOut + b.OtherOut
+}
+
+// MarshalJSON serializes a snapshot of the counters, as they can be updated concurrently.
+func (b *PeerBandwidth) MarshalJSON() ([]byte, error) {
+	type bandwidthAlias PeerBandwidth
+	snapshot := bandwidthAlias(b.Load())
+	return json.Marshal(&snapshot)
+}
+
+// AddBandwidth accounts the bytes exchanged with the peer in the given direction (BandwidthIn or
+// BandwidthOut) over the given protocol ID. It doesn't take the lock of the peer, as it gets called
+// for every chunk of traffic.
+func (p *Peer) AddBandwidth(dir string, proto string, bytes uint64) {
+	if counter := p.Bandwidth.counter(dir, BandwidthCategory(proto)); counter != nil {
+		atomic.AddUint64(counter, bytes)
+	}
+}
+
+// BandwidthTotals returns the total bytes received from and sent to the peer.
+func (p *Peer) BandwidthTotals() (in, out uint64) {
+	bw := p.Bandwidth.Load()
+	return bw.In(), bw.Out()
+}
+
+// PeerBandwidthTotal is a peer of the ranking of the heaviest peers by traffic.
+type PeerBandwidthTotal struct {
+	PeerID     peer.ID
+	ClientName string
+	In         uint64
+	Out        uint64
+}
+
+// Total returns the bytes exchanged in both directions.
+func (t PeerBandwidthTotal) Total() uint64 {
+	return t.In + t.Out
+}
+
+// GetTopTalkers returns the topN peers that exchanged the most bytes with us (in both directions).
+// The peers without traffic are not included, ties are sorted by peer ID, and topN <= 0 returns
+// the full ranking.
+func (s *PeerStore) GetTopTalkers(topN int) []PeerBandwidthTotal {
+	talkers := make([]PeerBandwidthTotal, 0)
+	s.ForEachPeer(func(p *Peer) bool {
+		in, out := p.BandwidthTotals()
+		if in+out == 0 {
+			return true
+		}
+		p.m.RLock()
+		client := p.ClientName
+		p.m.RUnlock()
+		if client == "" {
+			client = utils.Unknown
+		}
+		talkers = append(talkers, PeerBandwidthTotal{
+			PeerID:     p.ID,
+			ClientName: client,
+			In:         in,
+			Out:        out,
+		})
+		return true
+	})
+
+	sort.Slice(talkers, func(i, j int) bool {
+		if talkers[i].Total() == talkers[j].Total() {
+			return talkers[i].PeerID.String() < talkers[j].PeerID.String()
+		}
+		return talkers[i].Total() > talkers[j].Total()
+	})
+	if topN > 0 && len(talkers) > topN {
+		talkers = talkers[:topN]
+	}
+	return talkers
+}
+
+// BandwidthTotals returns the bytes exchanged with all the peers of the store per direction.
+func (s *PeerStore) BandwidthTotals() (in, out uint64) {
+	s.ForEachPeer(func(p *Peer) bool {
+		pIn, pOut := p.BandwidthTotals()
+		in += pIn
+		out += pOut
+		return true
+	})
+	return in, out
+}
+
+// BandwidthReporter is the libp2p bandwidth reporter of the host, that besides the aggregated
+// counters accounts the traffic of every stream to its peer in the PeerStore.
+type BandwidthReporter struct {
+	*p2pmetrics.BandwidthCounter
+	store *PeerStore
+}
+
+// NewBandwidthReporter returns a BandwidthReporter that feeds the given PeerStore.
+func NewBandwidthReporter(store *PeerStore) *BandwidthReporter {
+	return &BandwidthReporter{
+		BandwidthCounter: p2pmetrics.NewBandwidthCounter(),
+		store:            store,
+	}
+}
+
+// LogSentMessageStream accounts the bytes sent to the peer over a stream of the given protocol.
+func (r *BandwidthReporter) LogSentMessageStream(size int64, proto protocol.ID, p peer.ID) {
+	r.BandwidthCounter.LogSentMessageStream(size, proto, p)
+	if size > 0 {
+		r.store.GetOrCreatePeer(p).AddBandwidth(BandwidthOut, string(proto), uint64(size))
+	}
+}
+
+// LogRecvMessageStream accounts the bytes received from the peer over a stream of the given protocol.
+func (r *BandwidthReporter) LogRecvMessageStream(size int64, proto protocol.ID, p peer.ID) {
+	r.BandwidthCounter.LogRecvMessageStream(size, proto, p)
+	if size > 0 {
+		r.store.GetOrCreatePeer(p).AddBandwidth(BandwidthIn, string(proto), uint64(size))
+	}
+}
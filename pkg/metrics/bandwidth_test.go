@@ -0,0 +1,107 @@
+package metrics
+
+import (
+	"bytes"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_BandwidthCategory(t *testing.T) {
+	require.Equal(t, GossipBandwidth, BandwidthCategory("/meshsub/1.1.0"))
+	require.Equal(t, GossipBandwidth, BandwidthCategory("/floodsub/1.0.0"))
+	require.Equal(t, ReqRespBandwidth, BandwidthCategory("/eth2/beacon_chain/req/metadata/2/ssz_snappy"))
+	require.Equal(t, OtherBandwidth, BandwidthCategory("/ipfs/id/1.0.0"))
+	require.Equal(t, OtherBandwidth, BandwidthCategory(""))
+}
+
+func Test_PeerAddBandwidthConcurrent(t *testing.T) {
+	p := NewPeer(testPeerID("bandwidth-peer"))
+	const workers, adds = 8, 1000
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < adds; j++ {
+				p.AddBandwidth(BandwidthIn, "/meshsub/1.1.0", 10)
+				p.AddBandwidth(BandwidthOut, "/eth2/beacon_chain/req/status/1/ssz_snappy", 1)
+				p.AddBandwidth(BandwidthIn, "/ipfs/ping/1.0.0", 2)
+				// unknown directions are ignored
+				p.AddBandwidth("sideways", "/meshsub/1.1.0", 100)
+			}
+		}()
+		// readers of the peer while the counters are updated
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < adds/10; j++ {
+				p.Copy()
+				_, err := json.Marshal(p)
+				require.NoError(t, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	require.Equal(t, PeerBandwidth{
+		GossipIn:   workers * adds * 10,
+		ReqRespOut: workers * adds,
+		OtherIn:    workers * adds * 2,
+	}, p.Bandwidth.Load())
+	in, out := p.BandwidthTotals()
+	require.Equal(t, uint64(workers*adds*12), in)
+	require.Equal(t, uint64(workers*adds), out)
+}
+
+func Test_PeerStoreBandwidthAggregation(t *testing.T) {
+	store := NewPeerStore()
+	heavy := store.GetOrCreatePeer(testPeerID("heavy-peer"))
+	heavy.ClientName = "prysm"
+	heavy.AddBandwidth(BandwidthIn, "/meshsub/1.1.0", 5000)
+	heavy.AddBandwidth(BandwidthOut, "/meshsub/1.1.0", 1000)
+	light := store.GetOrCreatePeer(testPeerID("light-peer"))
+	light.AddBandwidth(BandwidthOut, "/eth2/beacon_chain/req/ping/1/ssz_snappy", 100)
+	// peers without traffic are not ranked
+	store.GetOrCreatePeer(testPeerID("silent-peer"))
+
+	in, out := store.BandwidthTotals()
+	require.Equal(t, uint64(5000), in)
+	require.Equal(t, uint64(1100), out)
+
+	talkers := store.GetTopTalkers(0)
+	require.Equal(t, []PeerBandwidthTotal{
+		{PeerID: heavy.ID, ClientName: "prysm", In: 5000, Out: 1000},
+		{PeerID: light.ID, ClientName: "unknown", In: 0, Out: 100},
+	}, talkers)
+	require.Len(t, store.GetTopTalkers(1), 1)
+
+	// the counters survive a checkpoint and add up on a merge
+	var buf bytes.Buffer
+	require.NoError(t, store.Checkpoint(&buf))
+	restored := NewPeerStore()
+	require.NoError(t, restored.RestoreFrom(&buf))
+	p, ok := restored.GetPeer(heavy.ID)
+	require.True(t, ok)
+	require.Equal(t, heavy.Bandwidth.Load(), p.Bandwidth.Load())
+
+	other := NewPeer(heavy.ID)
+	other.AddBandwidth(BandwidthIn, "/meshsub/1.1.0", 1)
+	p.Merge(other)
+	in, out = p.BandwidthTotals()
+	require.Equal(t, uint64(5001), in)
+	require.Equal(t, uint64(1000), out)
+}
+
+func Test_PeerCsvBandwidth(t *testing.T) {
+	p := NewPeer(testPeerID("csv-bandwidth-peer"))
+	p.AddBandwidth(BandwidthIn, "/meshsub/1.1.0", 2048)
+	p.AddBandwidth(BandwidthOut, "/ipfs/id/1.0.0", 64)
+	record := p.csvRecord(DefaultQualityWeights, time.Now())
+	require.Equal(t, "2048", record[csvColumn(t, "bytes_in")])
+	require.Equal(t, "64", record[csvColumn(t, "bytes_out")])
+}
@@ -0,0 +1,199 @@
+package metrics
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	CheckpointFormatVersion = 1
+	// MaxCheckpointPeers bounds the peers announced by the header of a checkpoint, far above any
+	// real peerstore, so that a corrupted header can't make the restore exhaust the memory.
+	MaxCheckpointPeers = 50000000
+)
+
+// CheckpointHeader is the envelope that precedes the peer records in a checkpoint.
+type CheckpointHeader struct {
+	Version   int       `json:"version"`
+	Timestamp time.Time `json:"timestamp"`
+	Peers     int       `json:"peers"`
+}
+
+// Checkpoint writes the whole store into w: a header line followed by one JSON peer record per line.
+func (s *PeerStore) Checkpoint(w io.Writer) error {
+	peers := s.SelectPeers()
+
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	err := enc.Encode(CheckpointHeader{
+		Version:   CheckpointFormatVersion,
+		Timestamp: time.Now(),
+		Peers:     len(peers),
+	})
+	if err != nil {
+		return errors.Wrap(err, "unable to write checkpoint header")
+	}
+	for _, p := range peers {
+		err = enc.Encode(p)
+		if err != nil {
+			return errors.Wrap(err, "unable to write checkpoint of peer "+p.ID.String())
+		}
+	}
+	return bw.Flush()
+}
+
+// RestoreFrom reads a checkpoint from r and merges its peers into the store, which gets marked as
+// synced at the time of the checkpoint. The fields that a checkpoint of an older version didn't have
+// are left empty, and the connection status of the peers is never restored (no connection survives
+// a restart). The checkpoint is fully read and validated before touching the store, so a
+// corrupted or truncated checkpoint returns an error without loading any peer.
+func (s *PeerStore) RestoreFrom(r io.Reader) error {
+	dec := json.NewDecoder(bufio.NewReader(r))
+
+	var header CheckpointHeader
+	err := dec.Decode(&header)
+	if err != nil {
+		return errors.Wrap(err, "unable to read checkpoint header")
+	}
+	if header.Version < 1 || header.Version > CheckpointFormatVersion {
+		return fmt.Errorf("unsupported checkpoint version %d (expected up to %d)", header.Version, CheckpointFormatVersion)
+	}
+
+	if header.Peers < 0 || header.Peers > MaxCheckpointPeers {
+		return fmt.Errorf("corrupted checkpoint, invalid number of peers %d", header.Peers)
+	}
+
+	// the header is not trusted to size the peers, they grow as they are read
+	var peers []*Peer
+	for i := 0; i < header.Peers; i++ {
+		p := NewPeer("")
+		err = dec.Decode(p)
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("corrupted checkpoint, read %d of %d peers", i, header.Peers))
+		}
+		if p.ID == "" {
+			return fmt.Errorf("corrupted checkpoint, peer %d of %d without id", i, header.Peers)
+		}
+		peers = append(peers, p)
+	}
+
+	for _, p := range peers {
+		s.GetOrCreatePeer(p.ID).Merge(p)
+	}
+	// the restored peers are already in the checkpoint
+	s.MarkSynced(header.Timestamp)
+	log.Infof("restored %d peers from checkpoint of %s", len(peers), header.Timestamp.Format(time.RFC3339))
+	return nil
+}
+
+// CheckpointToFile writes the checkpoint into a temporary file that replaces the given one once it's complete,
+// so that a crash in the middle of the checkpoint never leaves a half-written file behind.
+func (s *PeerStore) CheckpointToFile(path string) error {
+	tmpPath := path + ".tmp"
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return errors.Wrap(err, "unable to create checkpoint file")
+	}
+	err = s.Checkpoint(f)
+	if err == nil {
+		err = f.Sync()
+	}
+	closeErr := f.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// RestoreFromFile merges into the store the checkpoint at the given path.
+func (s *PeerStore) RestoreFromFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return errors.Wrap(err, "unable to open checkpoint file")
+	}
+	defer f.Close()
+	return s.RestoreFrom(f)
+}
+
+// Checkpointer periodically checkpoints the PeerStore into a file.
+type Checkpointer struct {
+	ctx context.Context
+
+	store    *PeerStore
+	path     string
+	interval time.Duration
+
+	wg     sync.WaitGroup
+	closeC chan struct{}
+}
+
+// NewCheckpointer returns a Checkpointer that will write the store into path every interval.
+func NewCheckpointer(ctx context.Context, store *PeerStore, path string, interval time.Duration) *Checkpointer {
+	return &Checkpointer{
+		ctx:      ctx,
+		store:    store,
+		path:     path,
+		interval: interval,
+		closeC:   make(chan struct{}),
+	}
+}
+
+// Start restores the existing checkpoint (if any) and spawns the checkpointing routine.
+func (c *Checkpointer) Start() {
+	if _, err := os.Stat(c.path); err == nil {
+		err = c.store.RestoreFromFile(c.path)
+		if err != nil {
+			log.Error(errors.Wrap(err, "unable to restore peer store from "+c.path))
+		}
+		// the sessions that were open when the checkpoint was taken never got their disconnection
+		c.store.RepairSessions(time.Now())
+	}
+
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		ticker := time.NewTicker(c.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				c.checkpoint()
+			case <-c.closeC:
+				return
+			case <-c.ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Close stops the checkpointing routine and writes a last checkpoint.
+func (c *Checkpointer) Close() {
+	close(c.closeC)
+	c.wg.Wait()
+	c.checkpoint()
+}
+
+func (c *Checkpointer) checkpoint() {
+	start := time.Now()
+	err := c.store.CheckpointToFile(c.path)
+	if err != nil {
+		log.Error(errors.Wrap(err, "unable to checkpoint peer store"))
+		return
+	}
+	c.store.MarkSynced(start)
+	log.Debugf("peer store checkpointed into %s in %s", c.path, time.Since(start))
+}
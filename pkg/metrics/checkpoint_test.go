@@ -0,0 +1,212 @@
+package metrics
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_CheckpointRoundTrip(t *testing.T) {
+	store := newTestPeerStore()
+	t0 := time.Unix(1000, 0).UTC()
+
+	p := store.GetOrCreatePeer(testPeerID("peer0"))
+	mAddr, err := ma.NewMultiaddr("/ip4/86.85.31.80/tcp/9000")
+	require.NoError(t, err)
+	p.MAddrs = []ma.Multiaddr{mAddr}
+	p.ConnectionAttemptEvent(true, "none")
+	p.MessageEvent("beacon_block", t0)
+	p.MessageEvent("beacon_block", t0.Add(time.Minute))
+
+	var buf bytes.Buffer
+	require.NoError(t, store.Checkpoint(&buf))
+
+	restored := NewPeerStore()
+	require.NoError(t, restored.RestoreFrom(&buf))
+	require.Equal(t, store.Len(), restored.Len())
+
+	rp, ok := restored.GetPeer(testPeerID("peer0"))
+	require.True(t, ok)
+	require.Equal(t, "prysm", rp.ClientName)
+	require.Equal(t, "Germany", rp.Country)
+	require.Equal(t, 1, rp.Attempts)
+	require.True(t, rp.Succeed)
+	require.Equal(t, "/ip4/86.85.31.80/tcp/9000", rp.MAddrs[0].String())
+	require.Equal(t, int64(2), rp.MessageMetrics["beacon_block"].Count)
+	require.True(t, t0.Equal(rp.MessageMetrics["beacon_block"].FirstMessageTime))
+	require.Equal(t, 1, len(rp.ConnectionTimes))
+	// the connection status is not kept across restarts
+	require.False(t, rp.IsConnected)
+}
+
+func Test_CheckpointRestoreMerges(t *testing.T) {
+	store := newTestPeerStore()
+	p := store.GetOrCreatePeer(testPeerID("peer0"))
+	p.ConnectionAttemptEvent(true, "")
+	p.MessageEvent("beacon_block", time.Unix(2000, 0))
+
+	var buf bytes.Buffer
+	require.NoError(t, store.Checkpoint(&buf))
+
+	// the live store already knows a newer version of one of the peers
+	live := NewPeerStore()
+	lp := live.GetOrCreatePeer(testPeerID("peer0"))
+	lp.ClientName = "lighthouse"
+	lp.ConnectionAttemptEvent(false, "timeout")
+	lp.MessageEvent("beacon_block", time.Unix(3000, 0))
+	live.GetOrCreatePeer(testPeerID("live-only"))
+
+	require.NoError(t, live.RestoreFrom(&buf))
+	require.Equal(t, 6, live.Len())
+
+	lp, _ = live.GetPeer(testPeerID("peer0"))
+	require.Equal(t, "lighthouse", lp.ClientName) // live info is kept
+	require.Equal(t, "Germany", lp.Country)       // missing info is filled
+	require.Equal(t, 2, lp.Attempts)
+	require.True(t, lp.Succeed)
+	require.Equal(t, int64(2), lp.MessageMetrics["beacon_block"].Count)
+	require.Equal(t, time.Unix(2000, 0).Unix(), lp.MessageMetrics["beacon_block"].FirstMessageTime.Unix())
+	require.Equal(t, time.Unix(3000, 0).Unix(), lp.MessageMetrics["beacon_block"].LastMessageTime.Unix())
+}
+
+func Test_CheckpointCorruption(t *testing.T) {
+	store := newTestPeerStore()
+	var buf bytes.Buffer
+	require.NoError(t, store.Checkpoint(&buf))
+	content := buf.String()
+
+	// truncated in the middle of the peer records
+	restored := NewPeerStore()
+	err := restored.RestoreFrom(strings.NewReader(content[:len(content)*2/3]))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "corrupted checkpoint")
+	require.Equal(t, 0, restored.Len())
+
+	// unknown version
+	err = restored.RestoreFrom(strings.NewReader(`{"version":99,"peers":0}`))
+	require.Error(t, err)
+	require.Equal(t, 0, restored.Len())
+
+	// empty file
+	err = restored.RestoreFrom(strings.NewReader(""))
+	require.Error(t, err)
+
+	// invalid number of peers
+	for _, peers := range []int{-1, MaxCheckpointPeers + 1} {
+		err = restored.RestoreFrom(strings.NewReader(fmt.Sprintf(`{"version":1,"peers":%d}`, peers)))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "corrupted checkpoint")
+	}
+	require.Equal(t, 0, restored.Len())
+
+	// peer without id
+	err = restored.RestoreFrom(strings.NewReader("{\"version\":1,\"peers\":1}\n{}\n"))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "corrupted checkpoint")
+	require.Equal(t, 0, restored.Len())
+}
+
+func Test_CheckpointToFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "peerstore.json")
+	store := newTestPeerStore()
+
+	require.NoError(t, store.CheckpointToFile(path))
+	// a second checkpoint replaces the first one
+	store.GetOrCreatePeer(testPeerID("another"))
+	require.NoError(t, store.CheckpointToFile(path))
+
+	restored := NewPeerStore()
+	require.NoError(t, restored.RestoreFromFile(path))
+	require.Equal(t, 6, restored.Len())
+}
+
+func Test_CheckpointManyPeers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "peerstore.json")
+	store := NewPeerStore()
+	t0 := time.Unix(1654084800, 0)
+	clients := []string{"prysm", "lighthouse", "teku", "nimbus", "lodestar"}
+	countries := []string{"Germany", "France", "United States"}
+	dialErrs := []string{"none", "i/o timeout", "connection refused"}
+
+	for i := 0; i < 3000; i++ {
+		p := store.GetOrCreatePeer(testPeerID(fmt.Sprintf("peer%d", i)))
+		p.ClientName = clients[i%len(clients)]
+		p.Country = countries[i%len(countries)]
+		p.Ip = fmt.Sprintf("18.223.%d.%d", i/256, i%256)
+		p.MAddrs = []ma.Multiaddr{ma.StringCast("/ip4/" + p.Ip + "/tcp/9000")}
+		for j := 0; j <= i%3; j++ {
+			p.ConnectionAttemptEvent(i%3 != 0, dialErrs[(i+j)%len(dialErrs)])
+		}
+		connTime := t0.Add(time.Duration(i) * time.Second)
+		p.ConnectionEvent(connTime)
+		// half of the peers are still connected when the checkpoint is taken
+		if i%2 == 0 {
+			p.DisconnectionEvent(connTime.Add(time.Minute))
+		}
+		for j := 0; j < i%5; j++ {
+			p.MessageEvent("beacon_block", connTime.Add(time.Duration(j)*12*time.Second))
+		}
+	}
+	before := time.Now()
+	require.NoError(t, store.CheckpointToFile(path))
+
+	restored := NewPeerStore()
+	require.NoError(t, restored.RestoreFromFile(path))
+	require.Equal(t, store.Len(), restored.Len())
+	require.False(t, restored.syncedAt.Before(before))
+
+	for _, p := range store.SelectPeers() {
+		rp, ok := restored.GetPeer(p.ID)
+		require.True(t, ok)
+		expected, err := json.Marshal(p)
+		require.NoError(t, err)
+		actual, err := json.Marshal(rp)
+		require.NoError(t, err)
+		require.JSONEq(t, string(expected), string(actual))
+
+		require.Equal(t, p.Attempts, rp.Attempts)
+		require.Equal(t, p.LastError, rp.LastError)
+		require.Equal(t, len(p.ConnectionTimes), len(rp.ConnectionTimes))
+		for i := range p.ConnectionTimes {
+			require.True(t, p.ConnectionTimes[i].Equal(rp.ConnectionTimes[i]))
+		}
+		require.Equal(t, len(p.DisconnectionTimes), len(rp.DisconnectionTimes))
+		for i := range p.DisconnectionTimes {
+			require.True(t, p.DisconnectionTimes[i].Equal(rp.DisconnectionTimes[i]))
+		}
+		require.Equal(t, len(p.MessageMetrics), len(rp.MessageMetrics))
+		for topic, msgMetric := range p.MessageMetrics {
+			require.Equal(t, msgMetric.Count, rp.MessageMetrics[topic].Count)
+			require.True(t, msgMetric.LastMessageTime.Equal(rp.MessageMetrics[topic].LastMessageTime))
+		}
+		// no connection survives the restart
+		require.False(t, rp.IsConnected)
+	}
+}
+
+func Test_CheckpointOlderRecords(t *testing.T) {
+	pID := testPeerID("old-peer")
+	// a record of a checkpoint written before most of the current fields existed
+	content := `{"version":1,"timestamp":"2022-06-01T12:00:00Z","peers":1}` + "\n" +
+		`{"peer_id":"` + pID.String() + `","attempted":true,"attempts":3,"succeed":false,"last_error":"i/o timeout"}` + "\n"
+
+	restored := NewPeerStore()
+	require.NoError(t, restored.RestoreFrom(strings.NewReader(content)))
+	rp, ok := restored.GetPeer(pID)
+	require.True(t, ok)
+	require.Equal(t, 3, rp.Attempts)
+	require.Equal(t, "i/o timeout", rp.LastError)
+	require.Empty(t, rp.MAddrs)
+	require.Zero(t, rp.SuccessfulAttempts)
+	require.Empty(t, rp.ConnectionTimes)
+	require.NotNil(t, rp.MessageMetrics)
+	require.NotNil(t, rp.StatusErrors)
+	require.True(t, time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC).Equal(restored.syncedAt))
+}
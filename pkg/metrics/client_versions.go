@@ -0,0 +1,129 @@
+package metrics
+
+import (
+	"context"
+	"sync"
+
+	"github.com/migalabs/armiarma/pkg/utils"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// max number of new client versions waiting to be notified to the callbacks
+	clientVersionQueueSize = 128
+)
+
+// NewClientVersionFn is called when a (client name, client version) pair is seen for the first time.
+type NewClientVersionFn func(name, version, firstPeer string)
+
+type clientVersion struct {
+	name    string
+	version string
+}
+
+type newClientVersion struct {
+	clientVersion
+	firstPeer string
+}
+
+// ClientVersionTracker keeps the set of client versions seen so far, notifying the registered
+// callbacks of the new ones from a separate go routine, so that the identification path never waits for them.
+type ClientVersionTracker struct {
+	m         sync.RWMutex
+	seen      map[clientVersion]struct{}
+	callbacks []NewClientVersionFn
+
+	notC chan newClientVersion
+}
+
+// NewClientVersionTracker returns an empty tracker, whose callbacks are called until the context dies.
+func NewClientVersionTracker(ctx context.Context) *ClientVersionTracker {
+	t := &ClientVersionTracker{
+		seen:      make(map[clientVersion]struct{}),
+		callbacks: make([]NewClientVersionFn, 0),
+		notC:      make(chan newClientVersion, clientVersionQueueSize),
+	}
+	go t.notifyRoutine(ctx)
+	return t
+}
+
+// Seed adds an already known client version, which won't be notified.
+func (t *ClientVersionTracker) Seed(name, version string) {
+	t.m.Lock()
+	defer t.m.Unlock()
+	t.seen[clientVersion{name, version}] = struct{}{}
+}
+
+// OnNewClientVersion registers a callback for the client versions seen for the first time.
+func (t *ClientVersionTracker) OnNewClientVersion(fn NewClientVersionFn) {
+	t.m.Lock()
+	defer t.m.Unlock()
+	t.callbacks = append(t.callbacks, fn)
+}
+
+// Track adds the client version of the given peer, returning whether it was seen for the first time.
+// Unknown clients or versions are ignored.
+func (t *ClientVersionTracker) Track(name, version, peerID string) bool {
+	if name == "" || name == utils.Unknown || version == "" || version == utils.Unknown {
+		return false
+	}
+	cliVersion := clientVersion{name, version}
+	t.m.Lock()
+	if _, ok := t.seen[cliVersion]; ok {
+		t.m.Unlock()
+		return false
+	}
+	t.seen[cliVersion] = struct{}{}
+	t.m.Unlock()
+
+	select {
+	case t.notC <- newClientVersion{cliVersion, peerID}:
+	default:
+		log.Warnf("client version queue full, dropping notification of %s %s", name, version)
+	}
+	return true
+}
+
+// Len returns the number of client versions seen so far.
+func (t *ClientVersionTracker) Len() int {
+	t.m.RLock()
+	defer t.m.RUnlock()
+	return len(t.seen)
+}
+
+func (t *ClientVersionTracker) notifyRoutine(ctx context.Context) {
+	for {
+		select {
+		case newVersion := <-t.notC:
+			t.m.RLock()
+			callbacks := append(make([]NewClientVersionFn, 0, len(t.callbacks)), t.callbacks...)
+			t.m.RUnlock()
+			for _, fn := range callbacks {
+				fn(newVersion.name, newVersion.version, newVersion.firstPeer)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// SetClientVersionTracker sets the tracker of the client versions of the identified peers.
+func (s *PeerStore) SetClientVersionTracker(tracker *ClientVersionTracker) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.clientVersions = tracker
+}
+
+// TrackClientVersion adds the client version of the peer to the tracker of the store (if any).
+func (s *PeerStore) TrackClientVersion(p *Peer) {
+	s.m.RLock()
+	tracker := s.clientVersions
+	s.m.RUnlock()
+	if tracker == nil {
+		return
+	}
+	p.m.RLock()
+	name, version := p.ClientName, p.ClientVersion
+	p.m.RUnlock()
+	tracker.Track(name, version, p.ID.String())
+}
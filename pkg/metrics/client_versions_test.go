@@ -0,0 +1,64 @@
+package metrics
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_NewClientVersion(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	tracker := NewClientVersionTracker(ctx)
+	tracker.Seed("lighthouse", "v3.1.0")
+
+	var m sync.Mutex
+	notified := make([][3]string, 0)
+	done := make(chan struct{}, 4)
+	tracker.OnNewClientVersion(func(name, version, firstPeer string) {
+		m.Lock()
+		defer m.Unlock()
+		notified = append(notified, [3]string{name, version, firstPeer})
+		done <- struct{}{}
+	})
+
+	store := NewPeerStore()
+	store.SetClientVersionTracker(tracker)
+	for _, name := range []string{"peer0", "peer1", "peer2", "peer3"} {
+		p := store.GetOrCreatePeer(testPeerID(name))
+		p.ClientName = "prysm"
+		p.ClientVersion = "v3.2.0"
+		switch name {
+		case "peer2":
+			// already known
+			p.ClientName = "lighthouse"
+			p.ClientVersion = "v3.1.0"
+		case "peer3":
+			// unknown versions are ignored
+			p.ClientVersion = "unknown"
+		}
+		store.TrackClientVersion(p)
+	}
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		require.Fail(t, "new client version not notified")
+	}
+	// make sure that no other notification arrives
+	select {
+	case <-done:
+		require.Fail(t, "client version notified twice")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	m.Lock()
+	defer m.Unlock()
+	require.Equal(t, 1, len(notified))
+	require.Equal(t, [3]string{"prysm", "v3.2.0", testPeerID("peer0").String()}, notified[0])
+	require.Equal(t, 2, tracker.Len())
+}
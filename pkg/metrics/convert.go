@@ -0,0 +1,125 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/migalabs/armiarma/pkg/db/models"
+	"github.com/migalabs/armiarma/pkg/utils"
+)
+
+// ToHostInfo returns the HostInfo with which the peer gets persisted into peer_info: its identity,
+// addresses, latency and connection attempt aggregates, along with its quality score, number of
+// distinct topics and last dial-back as attributes.
+// Together with PeerFromHostInfo, it is the only mapping between the in-memory peers and the persisted ones.
+func (p *Peer) ToHostInfo(weights QualityWeights, now time.Time) *models.HostInfo {
+	p.m.RLock()
+	defer p.m.RUnlock()
+
+	hInfo := models.NewHostInfo(p.ID, p.Network)
+	if len(p.MAddrs) > 0 {
+		hInfo.SetMAddrs(p.MAddrs)
+	}
+	hInfo.IP = p.Ip
+	hInfo.Port = p.port()
+
+	hInfo.PeerInfo = models.PeerInfo{
+		RemotePeer:         p.ID,
+		UserAgent:          p.UserAgent,
+		ProtocolVersion:    p.ProtocolVersion,
+		Protocols:          append(make([]string, 0, len(p.Protocols)), p.Protocols...),
+		Latency:            p.Latency,
+		UserAgentSanitized: p.UserAgentSanitized,
+		RawUserAgent:       p.RawUserAgent,
+	}
+	hInfo.ControlInfo = models.ControlInfo{
+		RemotePeer:         p.ID,
+		Deprecated:         p.Deprecated,
+		Attempted:          p.Attempted,
+		LastActivity:       p.lastActivity(),
+		LastConnAttempt:    p.LastAttempt,
+		LastError:          p.LastError,
+		Attempts:           p.Attempts,
+		SuccessfulAttempts: p.SuccessfulAttempts,
+	}
+
+	hInfo.AddAtt(models.QualityScoreAttribute, models.QualityScore(p.qualityScore(weights, now)))
+	if topics := p.distinctTopicCount(true); topics > 0 {
+		hInfo.AddAtt(models.DistinctTopicsAttribute, models.DistinctTopics(topics))
+	}
+	if p.DialbackAttempted {
+		hInfo.AddAtt(models.DialbackAttribute, models.Dialback{
+			PeerID:    p.ID,
+			Timestamp: p.DialbackTime,
+			Success:   p.DialbackSucceeded,
+			Error:     p.DialbackError,
+		})
+	}
+	return hInfo
+}
+
+// port returns the port of the first direct address of the peer with its IP, 0 if none has it.
+// Has to be called under the lock of the peer.
+func (p *Peer) port() int {
+	if p.Ip == "" {
+		return 0
+	}
+	direct, _ := utils.SplitRelayMAddrs(p.MAddrs)
+	for _, mAddr := range direct {
+		if ip := utils.ExtractIPFromMAddr(mAddr); ip != nil && ip.String() == p.Ip {
+			if port := utils.GetPortFromMaddrs(mAddr); port > 0 {
+				return port
+			}
+		}
+	}
+	return 0
+}
+
+// PeerFromHostInfo returns the Peer of a HostInfo read from the DB (see ToHostInfo), to resume
+// the crawl from it (i.e. merging it into the store). The identification and attributes are fetched
+// as from any other HostInfo, and the attempt aggregates from its ControlInfo.
+// The activity of the peer is derived from its connections, so it starts empty.
+func PeerFromHostInfo(hInfo *models.HostInfo) *Peer {
+	p := NewPeer(hInfo.ID)
+	p.FetchHostInfo(hInfo)
+
+	hInfo.RLock()
+	cInfo := hInfo.ControlInfo
+	hInfo.RUnlock()
+
+	p.m.Lock()
+	defer p.m.Unlock()
+	p.Deprecated = cInfo.Deprecated
+	p.Attempted = cInfo.Attempted || cInfo.Attempts > 0
+	p.Attempts = cInfo.Attempts
+	p.SuccessfulAttempts = cInfo.SuccessfulAttempts
+	p.Succeed = cInfo.SuccessfulAttempts > 0
+	p.LastError = cInfo.LastError
+	if !cInfo.LastConnAttempt.IsZero() && cInfo.LastConnAttempt.Unix() > 0 {
+		p.LastAttempt = cInfo.LastConnAttempt
+	}
+	return p
+}
+
+// ResumePeer adds the peer of a HostInfo read from the DB to the store (see PeerFromHostInfo),
+// unless the store already has it (i.e. restored from a checkpoint, whose counters are more recent).
+// Returns whether it was added.
+func (s *PeerStore) ResumePeer(hInfo *models.HostInfo) bool {
+	resumed := PeerFromHostInfo(hInfo)
+	sh := s.shard(resumed.ID)
+	sh.m.Lock()
+	defer sh.m.Unlock()
+	if _, ok := sh.peers[resumed.ID]; ok {
+		return false
+	}
+	// the stages were already reached without a store, count them in this one
+	resumed.m.Lock()
+	resumed.funnel = &s.funnel
+	resumed.funnelReached = 0
+	resumed.seenMessages = s.seenMessages
+	resumed.ipIndex = s.ipIndex
+	resumed.updateFunnel()
+	resumed.updateIPIndex()
+	resumed.m.Unlock()
+	sh.peers[resumed.ID] = resumed
+	return true
+}
@@ -0,0 +1,230 @@
+package metrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/migalabs/armiarma/pkg/db/models"
+	"github.com/migalabs/armiarma/pkg/utils"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/require"
+)
+
+// newConvertedTestPeer returns a peer with every field that gets persisted into peer_info.
+func newConvertedTestPeer(name string, t0 time.Time) *Peer {
+	pid := testPeerID(name)
+	p := NewPeer(pid)
+	hInfo := models.NewHostInfo(pid, utils.EthereumNetwork, models.WithIPAndPorts("95.217.33.10", 9000))
+	hInfo.PeerInfo = *models.NewPeerInfo(pid, "Prysm/v2.1.0/9c8a2d0e", "eth2/1.0.0", []string{"/meshsub/1.1.0", "/ipfs/id/1.0.0"}, 42*time.Millisecond)
+	p.FetchHostInfo(hInfo)
+	p.ConnectionAttemptEvent(false, "io_timeout")
+	p.ConnectionAttemptEvent(true, "")
+	p.ConnectionAttemptEvent(false, "connection_refused")
+	p.ConnectionEvent(t0)
+	p.DisconnectionEvent(t0.Add(time.Hour))
+	p.MessageEvent(BeaconBlockTopicName, t0.Add(time.Minute))
+	p.DialbackEvent(models.Dialback{PeerID: pid, Timestamp: t0.Add(2 * time.Minute), Error: "io_timeout"})
+	return p
+}
+
+func Test_PeerToHostInfo(t *testing.T) {
+	t0 := time.Unix(1654084800, 0)
+	now := t0.Add(2 * time.Hour)
+	p := newConvertedTestPeer("converted-peer", t0)
+
+	hInfo := p.ToHostInfo(DefaultQualityWeights, now)
+	require.Equal(t, p.ID, hInfo.ID)
+	require.Equal(t, utils.EthereumNetwork, hInfo.Network)
+	require.Equal(t, "95.217.33.10", hInfo.IP)
+	require.Equal(t, 9000, hInfo.Port)
+	require.Len(t, hInfo.MAddrs, 1)
+	require.Equal(t, "/ip4/95.217.33.10/tcp/9000", hInfo.MAddrs[0].String())
+	require.Empty(t, hInfo.RelayAddrs)
+	require.False(t, hInfo.RelayOnly)
+
+	require.Equal(t, models.PeerInfo{
+		RemotePeer:      p.ID,
+		UserAgent:       "Prysm/v2.1.0/9c8a2d0e",
+		ProtocolVersion: "eth2/1.0.0",
+		Protocols:       []string{"/meshsub/1.1.0", "/ipfs/id/1.0.0"},
+		Latency:         42 * time.Millisecond,
+	}, hInfo.PeerInfo)
+
+	require.Equal(t, models.ControlInfo{
+		RemotePeer:         p.ID,
+		Attempted:          true,
+		LastActivity:       t0.Add(time.Hour),
+		LastConnAttempt:    p.LastAttempt,
+		LastError:          "connection_refused",
+		Attempts:           3,
+		SuccessfulAttempts: 1,
+	}, hInfo.ControlInfo)
+
+	require.Equal(t, models.QualityScore(p.QualityScore(DefaultQualityWeights, now)), hInfo.Attr[models.QualityScoreAttribute])
+	require.Equal(t, models.DistinctTopics(1), hInfo.Attr[models.DistinctTopicsAttribute])
+	require.Equal(t, models.Dialback{PeerID: p.ID, Timestamp: t0.Add(2 * time.Minute), Error: "io_timeout"}, hInfo.Attr[models.DialbackAttribute])
+
+	// the deprecation of the peer too
+	p.DeprecationEvent()
+	require.True(t, p.ToHostInfo(DefaultQualityWeights, now).ControlInfo.Deprecated)
+
+	// an empty peer has no attributes besides its quality score
+	empty := NewPeer(testPeerID("empty-peer")).ToHostInfo(DefaultQualityWeights, now)
+	require.Empty(t, empty.IP)
+	require.Zero(t, empty.Port)
+	require.False(t, empty.IsHostIdentified())
+	require.Len(t, empty.Attr, 1)
+}
+
+func Test_PeerFromHostInfo(t *testing.T) {
+	t0 := time.Unix(1654084800, 0)
+	now := t0.Add(2 * time.Hour)
+	p := newConvertedTestPeer("resumed-peer", t0)
+	p.DeprecationEvent()
+
+	hInfo := p.ToHostInfo(DefaultQualityWeights, now)
+	resumed := PeerFromHostInfo(hInfo)
+	require.Equal(t, p.ID, resumed.ID)
+	require.Equal(t, p.Network, resumed.Network)
+	require.Equal(t, p.MAddrs, resumed.MAddrs)
+	require.Equal(t, p.Ip, resumed.Ip)
+	require.Equal(t, p.UserAgent, resumed.UserAgent)
+	require.Equal(t, "prysm", resumed.ClientName)
+	require.Equal(t, p.ClientVersion, resumed.ClientVersion)
+	require.Equal(t, p.ClientConfidence, resumed.ClientConfidence)
+	require.Equal(t, p.PeerCategory, resumed.PeerCategory)
+	require.Equal(t, p.ProtocolVersion, resumed.ProtocolVersion)
+	require.Equal(t, p.Protocols, resumed.Protocols)
+	require.Equal(t, p.Latency, resumed.Latency)
+	require.True(t, resumed.Deprecated)
+	require.True(t, resumed.Attempted)
+	require.True(t, resumed.Succeed)
+	require.Equal(t, 3, resumed.Attempts)
+	require.Equal(t, 1, resumed.SuccessfulAttempts)
+	require.Equal(t, p.LastAttempt, resumed.LastAttempt)
+	require.Equal(t, "connection_refused", resumed.LastError)
+	require.True(t, resumed.DialbackAttempted)
+	require.Equal(t, "io_timeout", resumed.DialbackError)
+	require.Equal(t, t0.Add(2*time.Minute), resumed.DialbackTime)
+
+	// converting it again only misses what is derived from the activity of the peer
+	again := resumed.ToHostInfo(DefaultQualityWeights, now)
+	require.Equal(t, hInfo.PeerInfo, again.PeerInfo)
+	expected := hInfo.ControlInfo
+	expected.LastActivity = time.Time{}
+	require.Equal(t, expected, again.ControlInfo)
+	require.Equal(t, hInfo.IP, again.IP)
+	require.Equal(t, hInfo.Port, again.Port)
+	require.Equal(t, hInfo.Attr[models.DialbackAttribute], again.Attr[models.DialbackAttribute])
+
+	// the unset timestamps read from the DB are the Unix epoch
+	cInfo := models.NewControlInfo()
+	cInfo.LastConnAttempt = time.Unix(0, 0)
+	fromDB := models.NewHostInfo(p.ID, utils.EthereumNetwork)
+	fromDB.ControlInfo = *cInfo
+	require.True(t, PeerFromHostInfo(fromDB).LastAttempt.IsZero())
+}
+
+func Test_PeerStoreResumePeer(t *testing.T) {
+	t0 := time.Unix(1654084800, 0)
+	p := newConvertedTestPeer("resumed-store-peer", t0)
+	hInfo := p.ToHostInfo(DefaultQualityWeights, t0.Add(time.Hour))
+
+	store := NewPeerStore()
+	require.True(t, store.ResumePeer(hInfo))
+	resumed, ok := store.GetPeer(p.ID)
+	require.True(t, ok)
+	require.Equal(t, 3, resumed.Attempts)
+	funnel := store.GetFunnel()
+	require.Equal(t, int64(1), funnel.Discovered)
+	require.Equal(t, int64(1), funnel.Attempted)
+	require.Equal(t, int64(1), funnel.Identified)
+
+	// the peers already in the store (i.e. from a checkpoint) are kept as they are
+	require.False(t, store.ResumePeer(hInfo))
+	again, _ := store.GetPeer(p.ID)
+	require.True(t, resumed == again)
+	require.Equal(t, 3, again.Attempts)
+	require.Equal(t, int64(1), store.GetFunnel().Discovered)
+}
+
+func Test_PeerCsvMatchesHostInfo(t *testing.T) {
+	t0 := time.Unix(1654084800, 0)
+	now := t0.Add(2 * time.Hour)
+	p := newConvertedTestPeer("exported-peer", t0)
+
+	record := p.csvRecord(DefaultQualityWeights, now)
+	column := func(name string) string {
+		for i, header := range PeerCsvHeader {
+			if header == name {
+				return record[i]
+			}
+		}
+		t.Fatalf("no csv column %s", name)
+		return ""
+	}
+	hInfo := p.ToHostInfo(DefaultQualityWeights, now)
+	require.Equal(t, hInfo.ID.String(), column("peer_id"))
+	require.Equal(t, string(hInfo.Network), column("network"))
+	require.Equal(t, hInfo.PeerInfo.UserAgent, column("user_agent"))
+	require.Equal(t, hInfo.IP, column("ip"))
+	require.Equal(t, "/ip4/95.217.33.10/tcp/9000", column("canonical_addr"))
+	require.Equal(t, "false", column("relay_only"))
+	require.Equal(t, "42", column("latency_ms"))
+	require.Equal(t, "true", column("attempted"))
+	require.Equal(t, "3", column("attempts"))
+	require.Equal(t, hInfo.ControlInfo.LastError, column("last_error"))
+	require.Equal(t, "1", column("distinct_topics"))
+	score := hInfo.Attr[models.QualityScoreAttribute].(models.QualityScore)
+	require.Equal(t, column("quality_score"), fmt.Sprintf("%.2f", score))
+}
+
+func Test_PeerIdentifiedMAddrs(t *testing.T) {
+	t0 := time.Unix(1654084800, 0)
+	pid := testPeerID("multi-addr-peer")
+	p := NewPeer(pid)
+	mAddrs := func(addrs ...string) []ma.Multiaddr {
+		mAddrs := make([]ma.Multiaddr, 0, len(addrs))
+		for _, addr := range addrs {
+			mAddrs = append(mAddrs, ma.StringCast(addr))
+		}
+		return mAddrs
+	}
+
+	// the private and loopback addresses of the identify are not picked to locate the peer
+	hInfo := models.NewHostInfo(pid, utils.EthereumNetwork)
+	hInfo.SetIdentifiedMAddrs(mAddrs("/ip4/127.0.0.1/tcp/9000", "/ip4/10.0.0.5/tcp/9000", "/ip4/95.217.33.10/tcp/9000", "/dns4/node.example.org/tcp/9000"))
+	require.Equal(t, "95.217.33.10", hInfo.IP)
+	require.Equal(t, 9000, hInfo.Port)
+	p.FetchHostInfo(hInfo)
+	require.Len(t, p.MAddrs, 4)
+	require.Equal(t, "95.217.33.10", p.Ip)
+	require.Equal(t, "/ip4/95.217.33.10/tcp/9000", p.csvRecord(DefaultQualityWeights, t0)[csvColumn(t, "canonical_addr")])
+
+	// the JSON export has the full list
+	raw, err := json.Marshal(p)
+	require.NoError(t, err)
+	var exported struct {
+		MAddrs []string `json:"maddrs"`
+	}
+	require.NoError(t, json.Unmarshal(raw, &exported))
+	require.Len(t, exported.MAddrs, 4)
+
+	// the most recent identify wins, even if it advertises less addresses
+	hInfo = models.NewHostInfo(pid, utils.EthereumNetwork)
+	hInfo.SetIdentifiedMAddrs(mAddrs("/ip4/10.0.0.5/tcp/9000", "/ip6/2a01:4f8::1/tcp/9001"))
+	require.Equal(t, "2a01:4f8::1", hInfo.IP)
+	require.Equal(t, 9001, hInfo.Port)
+	p.FetchHostInfo(hInfo)
+	require.Len(t, p.MAddrs, 2)
+	require.Equal(t, "2a01:4f8::1", p.Ip)
+	require.Equal(t, 9001, p.ToHostInfo(DefaultQualityWeights, t0).Port)
+
+	// with only private addresses, the private one is kept as the IP (although it's never located)
+	hInfo = models.NewHostInfo(pid, utils.EthereumNetwork)
+	hInfo.SetIdentifiedMAddrs(mAddrs("/ip4/192.168.1.20/tcp/9000"))
+	require.Equal(t, "192.168.1.20", hInfo.IP)
+	require.Len(t, hInfo.MAddrs, 1)
+}
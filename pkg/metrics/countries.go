@@ -0,0 +1,200 @@
+package metrics
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/migalabs/armiarma/pkg/utils"
+	"github.com/pkg/errors"
+)
+
+const (
+	// CountriesFile is the default name of the per-country export
+	CountriesFile = "countries.csv"
+)
+
+// CountriesCsvHeader is the list of columns of the per-country export.
+var CountriesCsvHeader = []string{
+	"country_code",
+	"country",
+	"peers",
+	"connected_peers",
+	"percentage",
+	"median_latency_ms",
+	"dominant_client",
+}
+
+// CountryStats is the summary of the peers located in a country.
+type CountryStats struct {
+	// ISO code of the country (empty if the provider only gave its name), Unknown for the peers that weren't located
+	CountryCode    string
+	Country        string
+	Peers          int
+	ConnectedPeers int
+	// over all the peers of the store (0-100)
+	Percentage float64
+	// of the peers with a measured latency (0 if none)
+	MedianLatency time.Duration
+	// most common client among the identified peers, Unknown if none was identified
+	DominantClient string
+}
+
+// countryAgg accumulates the peers of a country.
+type countryAgg struct {
+	// whether the peers are grouped by the ISO code, or by the name
+	byCode    bool
+	peers     int
+	connected int
+	latencies []time.Duration
+	clients   map[string]int
+}
+
+// GetCountryStats returns the summary of the peers per country, grouped as in CountryDistribution,
+// sorted by number of peers. The peers that weren't located are summarized in a last Unknown row,
+// which is always present.
+func (s *PeerStore) GetCountryStats() []CountryStats {
+	aggs := make(map[string]*countryAgg)
+	names := make(countryNames)
+	unknown := &countryAgg{clients: make(map[string]int)}
+	total := 0
+	s.ForEachPeer(func(p *Peer) bool {
+		p.m.RLock()
+		defer p.m.RUnlock()
+		total++
+		key := p.countryKey()
+		agg := unknown
+		if key != "" {
+			names.add(key, p.Country)
+			if agg = aggs[key]; agg == nil {
+				agg = &countryAgg{byCode: p.CountryCode != "", clients: make(map[string]int)}
+				aggs[key] = agg
+			}
+		}
+		agg.peers++
+		if p.IsConnected {
+			agg.connected++
+		}
+		if p.Latency > 0 {
+			agg.latencies = append(agg.latencies, p.Latency)
+		}
+		if p.ClientName != "" {
+			agg.clients[p.ClientName]++
+		}
+		return true
+	})
+
+	stats := make([]CountryStats, 0, len(aggs)+1)
+	for key, agg := range aggs {
+		countryStats := agg.stats(total)
+		countryStats.Country = names.name(key)
+		if agg.byCode {
+			countryStats.CountryCode = key
+		}
+		stats = append(stats, countryStats)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Peers != stats[j].Peers {
+			return stats[i].Peers > stats[j].Peers
+		}
+		return stats[i].Country < stats[j].Country
+	})
+	unknownStats := unknown.stats(total)
+	unknownStats.CountryCode = utils.Unknown
+	unknownStats.Country = utils.Unknown
+	return append(stats, unknownStats)
+}
+
+// stats summarizes the peers of the country, over the total peers of the store.
+func (a *countryAgg) stats(total int) CountryStats {
+	stats := CountryStats{
+		Peers:          a.peers,
+		ConnectedPeers: a.connected,
+		MedianLatency:  medianDuration(a.latencies),
+		DominantClient: utils.Unknown,
+	}
+	if total > 0 {
+		stats.Percentage = 100 * float64(a.peers) / float64(total)
+	}
+	top := 0
+	for client, count := range a.clients {
+		// ties go to the alphabetically first client
+		if count > top || (count == top && client < stats.DominantClient) {
+			stats.DominantClient = client
+			top = count
+		}
+	}
+	return stats
+}
+
+// medianDuration returns the median of the durations (the mean of the middle ones for an even number),
+// or 0 if there are none.
+func medianDuration(durations []time.Duration) time.Duration {
+	if len(durations) == 0 {
+		return 0
+	}
+	sorted := append([]time.Duration(nil), durations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2
+	}
+	return sorted[mid]
+}
+
+// ExportCountriesCsv writes into w the header and one row per country (see GetCountryStats).
+func (s *PeerStore) ExportCountriesCsv(w io.Writer) error {
+	csvW := csv.NewWriter(w)
+	err := csvW.Write(CountriesCsvHeader)
+	if err != nil {
+		return errors.Wrap(err, "unable to write csv header")
+	}
+	for _, country := range s.GetCountryStats() {
+		// empty if none of the peers has a measured latency
+		latency := ""
+		if country.MedianLatency > 0 {
+			latency = fmt.Sprintf("%d", country.MedianLatency.Milliseconds())
+		}
+		err = csvW.Write([]string{
+			country.CountryCode,
+			country.Country,
+			fmt.Sprintf("%d", country.Peers),
+			fmt.Sprintf("%d", country.ConnectedPeers),
+			fmt.Sprintf("%.2f", country.Percentage),
+			latency,
+			country.DominantClient,
+		})
+		if err != nil {
+			return errors.Wrap(err, "unable to write csv row")
+		}
+	}
+	csvW.Flush()
+	return csvW.Error()
+}
+
+// ExportCountriesFile exports the per-country summary into the CSV file at the given path (overwriting it).
+func (s *PeerStore) ExportCountriesFile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return errors.Wrap(err, "unable to create countries file")
+	}
+	defer f.Close()
+	return s.ExportCountriesCsv(f)
+}
+
+// CountriesCsvExporter returns an Exporter of the per-country export of the store (see ExportCountriesCsv).
+func (s *PeerStore) CountriesCsvExporter() Exporter {
+	return ExporterFunc(func(w io.Writer) (int64, error) {
+		lw := &lineCountWriter{w: w}
+		err := s.ExportCountriesCsv(lw)
+		// the header isn't a row
+		rows := lw.lines - 1
+		if rows < 0 {
+			rows = 0
+		}
+		return rows, err
+	})
+}
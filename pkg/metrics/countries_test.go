@@ -0,0 +1,91 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/migalabs/armiarma/pkg/utils"
+	"github.com/stretchr/testify/require"
+)
+
+const goldenCountries = `country_code,country,peers,connected_peers,percentage,median_latency_ms,dominant_client
+DE,Germany,4,2,40.00,30,lighthouse
+US,United States,3,1,30.00,120,prysm
+,France,1,0,10.00,,teku
+unknown,unknown,2,1,20.00,80,unknown
+`
+
+func newTestCountryStore() *PeerStore {
+	store := NewPeerStore()
+	t0 := time.Unix(1000, 0)
+	seeds := []struct {
+		country, code, client string
+		latencyMs             int
+		connected             bool
+	}{
+		{"Germany", "DE", "lighthouse", 20, true},
+		{"Germany", "DE", "lighthouse", 30, false},
+		{"Germany", "DE", "prysm", 0, true},
+		{"Germany", "DE", "", 40, false},
+		{"United States of America", "US", "prysm", 100, true},
+		{"United States", "US", "prysm", 120, false},
+		{"United States", "US", "teku", 150, false},
+		// located by a provider without codes
+		{"France", "", "teku", 0, false},
+		// not located
+		{"", "", "", 80, true},
+		{"", "", "", 0, false},
+	}
+	for i, seed := range seeds {
+		p := store.GetOrCreatePeer(testPeerID(fmt.Sprintf("country-stats-peer%d", i)))
+		p.Country = seed.country
+		p.CountryCode = seed.code
+		p.ClientName = seed.client
+		p.Latency = time.Duration(seed.latencyMs) * time.Millisecond
+		p.ConnectionEvent(t0)
+		if !seed.connected {
+			p.DisconnectionEvent(t0.Add(time.Minute))
+		}
+	}
+	return store
+}
+
+func Test_ExportCountriesCsv(t *testing.T) {
+	store := newTestCountryStore()
+	var buf bytes.Buffer
+	require.NoError(t, store.ExportCountriesCsv(&buf))
+	require.Equal(t, goldenCountries, buf.String())
+
+	// the percentages add up to 100 within rounding
+	var total float64
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n")[1:] {
+		pct, err := strconv.ParseFloat(strings.Split(line, ",")[4], 64)
+		require.NoError(t, err)
+		total += pct
+	}
+	require.InDelta(t, 100, total, 0.01*float64(len(store.GetCountryStats())))
+
+	// the scheduled exports count the countries as rows
+	rows, err := store.CountriesCsvExporter().Export(&bytes.Buffer{})
+	require.NoError(t, err)
+	require.Equal(t, int64(4), rows)
+}
+
+func Test_CountryStatsEmptyStore(t *testing.T) {
+	stats := NewPeerStore().GetCountryStats()
+	require.Equal(t, []CountryStats{{
+		CountryCode:    utils.Unknown,
+		Country:        utils.Unknown,
+		DominantClient: utils.Unknown,
+	}}, stats)
+}
+
+func Test_MedianDuration(t *testing.T) {
+	require.Equal(t, time.Duration(0), medianDuration(nil))
+	require.Equal(t, 2*time.Second, medianDuration([]time.Duration{3 * time.Second, time.Second, 2 * time.Second}))
+	require.Equal(t, 1500*time.Millisecond, medianDuration([]time.Duration{2 * time.Second, time.Second}))
+}
@@ -0,0 +1,285 @@
+package metrics
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/migalabs/armiarma/pkg/utils"
+	"github.com/pkg/errors"
+)
+
+// PeerCsvHeader is the list of columns of the per-peer CSV export.
+var PeerCsvHeader = []string{
+	"peer_id",
+	"network",
+	"client_name",
+	"client_version",
+	"client_os",
+	"client_arch",
+	"peer_category",
+	"user_agent",
+	"ip",
+	"canonical_addr",
+	"country",
+	"country_code",
+	"city",
+	"provider",
+	"asn",
+	"location_source",
+	"peers_on_same_ip",
+	"relay_only",
+	"latency_ms",
+	"attempted",
+	"attempts",
+	"succeed",
+	"connections",
+	"disconnections",
+	"relayed_sessions",
+	"bytes_in",
+	"bytes_out",
+	"last_error",
+	"top_error",
+	"top_error_count",
+	"longest_failure_streak",
+	"dial_delay_secs",
+	"dial_score",
+	"metadata_requests",
+	"metadata_successes",
+	"last_metadata_error",
+	"total_messages",
+	"distinct_topics",
+	"attestation_messages",
+	"attestation_subnets",
+	"block_rate_5m",
+	"block_rate_60m",
+	"attestation_rate_5m",
+	"attestation_rate_60m",
+	"block_first_seen",
+	"attestation_first_seen",
+	"block_avg_delay_ms",
+	"block_mean_gap_ms",
+	"mesh_topics",
+	"mesh_time_secs",
+	"quality_score",
+}
+
+// CsvHeader returns a copy of the columns of the per-peer CSV export (PeerCsvHeader).
+func CsvHeader() []string {
+	return append(make([]string, 0, len(PeerCsvHeader)), PeerCsvHeader...)
+}
+
+// ToCsvRecord returns the CSV record of the peer, one field per column of CsvHeader.
+func (p *Peer) ToCsvRecord() []string {
+	return p.csvRecord(DefaultQualityWeights, time.Now())
+}
+
+func (p *Peer) csvRecord(weights QualityWeights, now time.Time) []string {
+	record, _, _ := p.csvRow(weights, now)
+	return record
+}
+
+// ToCsvLine returns the CSV row of the peer (without the line break), matching PeerCsvHeader.
+// The fields are quoted and escaped as needed, see ToCsvRecord.
+func (p *Peer) ToCsvLine() string {
+	return strings.TrimSuffix(string(encodeCsvRecord(p.ToCsvRecord())), "\n")
+}
+
+// checkCsvRecord returns an error if the record doesn't have one field per column of the header.
+func checkCsvRecord(record []string, header []string) error {
+	if len(record) != len(header) {
+		return errors.Errorf("csv record has %d fields, but the header has %d columns", len(record), len(header))
+	}
+	return nil
+}
+
+// csvRow returns the CSV record of the peer, with its first and last activity read at the same time.
+func (p *Peer) csvRow(weights QualityWeights, now time.Time) (record []string, firstSeen, lastSeen time.Time) {
+	p.m.RLock()
+	defer p.m.RUnlock()
+
+	var totalMsgs int64
+	for _, msgMetric := range p.MessageMetrics {
+		totalMsgs += msgMetric.Count
+	}
+	// empty if we never decoded the slot of any of its blocks
+	blockDelay := ""
+	if delays := p.arrivalDelayStats(BeaconBlockTopicName); delays.Count > 0 {
+		blockDelay = fmt.Sprintf("%.0f", delays.AvgMs)
+	}
+	// empty until we got at least two blocks
+	blockGap := ""
+	if gaps := p.interArrivalStats(BeaconBlockTopicName); gaps.Count > 0 {
+		blockGap = fmt.Sprintf("%.0f", gaps.MeanMs)
+	}
+	attMsgs, attSubnets := attestationTotals(p.msgCountPerSubnet())
+	blockFirstSeen, _ := p.seenCounts(BeaconBlockTopicName)
+	attFirstSeen, _ := p.seenCounts(AttestationTopicName)
+	topError := p.topError()
+	bw := p.Bandwidth.Load()
+	// the full list of addresses is only in the JSON export
+	canonicalAddr := ""
+	if selected := utils.SelectMAddr(p.MAddrs); selected != nil {
+		canonicalAddr = selected.String()
+	}
+	record = []string{
+		p.ID.String(),
+		string(p.Network),
+		p.ClientName,
+		p.ClientVersion,
+		p.ClientOS,
+		p.ClientArch,
+		p.PeerCategory,
+		p.UserAgent,
+		p.Ip,
+		canonicalAddr,
+		p.Country,
+		p.CountryCode,
+		p.City,
+		p.Provider,
+		p.ASN,
+		p.LocationSource,
+		fmt.Sprintf("%d", p.PeersOnSameIP),
+		fmt.Sprintf("%t", p.RelayOnly),
+		fmt.Sprintf("%d", p.Latency.Milliseconds()),
+		fmt.Sprintf("%t", p.Attempted),
+		fmt.Sprintf("%d", p.Attempts),
+		fmt.Sprintf("%t", p.Succeed),
+		fmt.Sprintf("%d", len(p.ConnectionTimes)),
+		fmt.Sprintf("%d", len(p.DisconnectionTimes)),
+		fmt.Sprintf("%d", p.RelayedSessions),
+		fmt.Sprintf("%d", bw.In()),
+		fmt.Sprintf("%d", bw.Out()),
+		p.LastError,
+		topError,
+		fmt.Sprintf("%d", p.ErrorCounts[topError]),
+		fmt.Sprintf("%d", p.LongestFailureStreak),
+		fmt.Sprintf("%.0f", p.DialDelay.Seconds()),
+		fmt.Sprintf("%.2f", p.DialScore),
+		fmt.Sprintf("%d", p.MetadataRequests),
+		fmt.Sprintf("%d", p.MetadataSuccesses),
+		p.LastMetadataError,
+		fmt.Sprintf("%d", totalMsgs),
+		fmt.Sprintf("%d", p.distinctTopicCount(true)),
+		fmt.Sprintf("%d", attMsgs),
+		fmt.Sprintf("%d", attSubnets),
+		fmt.Sprintf("%.3f", p.messageRate(BeaconBlockTopicName, 5*time.Minute, now)),
+		fmt.Sprintf("%.3f", p.messageRate(BeaconBlockTopicName, 60*time.Minute, now)),
+		fmt.Sprintf("%.3f", p.messageRate(AttestationTopicName, 5*time.Minute, now)),
+		fmt.Sprintf("%.3f", p.messageRate(AttestationTopicName, 60*time.Minute, now)),
+		fmt.Sprintf("%d", blockFirstSeen),
+		fmt.Sprintf("%d", attFirstSeen),
+		blockDelay,
+		blockGap,
+		fmt.Sprintf("%d", len(p.meshTopics())),
+		fmt.Sprintf("%.0f", p.totalMeshTime(now).Seconds()),
+		fmt.Sprintf("%.2f", p.qualityScore(weights, now)),
+	}
+	return record, p.firstActivity(), p.lastActivity()
+}
+
+// ExportCsv writes the header and one row per peer of the store into w.
+// The derived fields (like PeersOnSameIP) are refreshed, and the sessions repaired, before the export.
+func (s *PeerStore) ExportCsv(w io.Writer) error {
+	_, err := s.exportCsvWithMeta(w)
+	return err
+}
+
+// exportCsvWithMeta is ExportCsv, returning the ExportMeta of what it wrote.
+func (s *PeerStore) exportCsvWithMeta(w io.Writer) (ExportMeta, error) {
+	s.RefreshPeersOnSameIP()
+	s.RepairSessions(time.Now())
+
+	// the quality scores are recomputed with the same time for all the peers
+	weights := s.QualityWeights()
+	now := time.Now()
+	meta := s.newPeerCsvMeta(now, false)
+
+	csvW := csv.NewWriter(w)
+	err := csvW.Write(meta.Columns)
+	if err != nil {
+		return meta, errors.Wrap(err, "unable to write csv header")
+	}
+	s.ForEachPeer(func(p *Peer) bool {
+		record, firstSeen, lastSeen := p.csvRow(weights, now)
+		err = checkCsvRecord(record, meta.Columns)
+		if err != nil {
+			return false
+		}
+		err = csvW.Write(record)
+		if err != nil {
+			return false
+		}
+		meta.addRow(firstSeen, lastSeen)
+		return true
+	})
+	if err != nil {
+		return meta, errors.Wrap(err, "unable to write csv row")
+	}
+	csvW.Flush()
+	return meta, csvW.Error()
+}
+
+// ExportCsvFile exports the store into the CSV file at the given path (overwriting it).
+// With an enabled rotation policy, the export is split into several files (each one with the header),
+// and the rotated ones are renamed with a timestamp suffix.
+// Once the export succeeds, each file gets its metadata sidecar (see ExportMeta).
+func (s *PeerStore) ExportCsvFile(path string, rotation utils.RotationPolicy) error {
+	s.RefreshPeersOnSameIP()
+	s.RepairSessions(time.Now())
+
+	weights := s.QualityWeights()
+	now := time.Now()
+	meta := s.newPeerCsvMeta(now, rotation.Compress)
+	f, err := utils.NewRotatingFile(path, rotation, encodeCsvRecord(meta.Columns), false)
+	if err != nil {
+		return errors.Wrap(err, "unable to create csv export file")
+	}
+	// metadata of the files rotated so far, in the same order as f.RotatedFiles()
+	rotatedMetas := make([]ExportMeta, 0)
+	s.ForEachPeer(func(p *Peer) bool {
+		record, firstSeen, lastSeen := p.csvRow(weights, now)
+		err = checkCsvRecord(record, meta.Columns)
+		if err != nil {
+			return false
+		}
+		// each row is written as a whole, so that it's never split between rotated files
+		err = f.WriteRecord(encodeCsvRecord(record))
+		if err != nil {
+			return false
+		}
+		// the row opened a new file if the previous one was rotated
+		if len(f.RotatedFiles()) > len(rotatedMetas) {
+			rotatedMetas = append(rotatedMetas, meta)
+			meta = s.newPeerCsvMeta(now, rotation.Compress)
+		}
+		meta.addRow(firstSeen, lastSeen)
+		return true
+	})
+	closeErr := f.Close()
+	if err != nil {
+		return errors.Wrap(err, "unable to write csv row")
+	}
+	if closeErr != nil {
+		return closeErr
+	}
+	for i, rotatedPath := range f.RotatedFiles() {
+		err = WriteExportMeta(rotatedPath, rotatedMetas[i])
+		if err != nil {
+			return err
+		}
+	}
+	return WriteExportMeta(path, meta)
+}
+
+// encodeCsvRecord returns the CSV line of the record (including the line break).
+func encodeCsvRecord(record []string) []byte {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write(record)
+	w.Flush()
+	return buf.Bytes()
+}
@@ -0,0 +1,219 @@
+package metrics
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/migalabs/armiarma/pkg/db/models"
+	"github.com/migalabs/armiarma/pkg/utils"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/require"
+)
+
+// csvColumn returns the index of the given column in the peer CSV export.
+func csvColumn(t *testing.T, name string) int {
+	for i, col := range PeerCsvHeader {
+		if col == name {
+			return i
+		}
+	}
+	require.Fail(t, "missing csv column "+name)
+	return -1
+}
+
+func Test_PeersOnSameIP(t *testing.T) {
+	store := NewPeerStore()
+	ips := []string{"95.217.33.1", "95.217.33.1", "95.217.33.1", "95.217.33.2", "95.217.33.2", "2a01:4f8::1", "", "",
+		"10.0.0.1", "10.0.0.1", "192.168.1.20", "192.168.1.20", "127.0.0.1", "127.0.0.1", "fe80::1", "fe80::1"}
+	for i, ip := range ips {
+		p := store.GetOrCreatePeer(testPeerID(fmt.Sprintf("ip-peer%d", i)))
+		p.Ip = ip
+	}
+
+	groups := store.RefreshPeersOnSameIP()
+	// peers without IP, or on private, loopback or link-local ones, are not grouped
+	require.Equal(t, 3, len(groups))
+	require.Equal(t, 3, len(groups["95.217.33.1"]))
+	require.Equal(t, 2, len(groups["95.217.33.2"]))
+	require.Equal(t, 1, len(groups["2a01:4f8::1"]))
+
+	for i, ip := range ips {
+		p, ok := store.GetPeer(testPeerID(fmt.Sprintf("ip-peer%d", i)))
+		require.True(t, ok)
+		require.Equal(t, len(groups[ip]), p.PeersOnSameIP)
+	}
+}
+
+func Test_ExportCsv(t *testing.T) {
+	store := newTestPeerStore()
+	for i := 0; i < 3; i++ {
+		p, _ := store.GetPeer(testPeerID(fmt.Sprintf("peer%d", i)))
+		p.Ip = "95.217.33.1"
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, store.ExportCsv(&buf))
+
+	records, err := csv.NewReader(&buf).ReadAll()
+	require.NoError(t, err)
+	require.Equal(t, store.Len()+1, len(records))
+	require.Equal(t, PeerCsvHeader, records[0])
+
+	sameIPIdx := csvColumn(t, "peers_on_same_ip")
+	shared := 0
+	for _, record := range records[1:] {
+		require.Equal(t, len(PeerCsvHeader), len(record))
+		if record[sameIPIdx] == "3" {
+			shared++
+		}
+	}
+	require.Equal(t, 3, shared)
+}
+
+func Test_ExportCsvEscaping(t *testing.T) {
+	store := NewPeerStore()
+	userAgents := []string{
+		"teku/v22.1.0/linux-x86_64/corretto-java-17, extra",
+		`Lighthouse/v2.3.1 "quoted"`,
+		"nimbus\nsecond line",
+	}
+	for i, ua := range userAgents {
+		p := store.GetOrCreatePeer(testPeerID(fmt.Sprintf("ua-peer%d", i)))
+		p.UserAgent = ua
+	}
+	uaIdx := csvColumn(t, "user_agent")
+
+	var buf bytes.Buffer
+	require.NoError(t, store.ExportCsv(&buf))
+	records, err := csv.NewReader(&buf).ReadAll()
+	require.NoError(t, err)
+	require.Equal(t, CsvHeader(), records[0])
+	parsed := make([]string, 0)
+	for _, record := range records[1:] {
+		require.Equal(t, len(CsvHeader()), len(record))
+		parsed = append(parsed, record[uaIdx])
+	}
+	require.ElementsMatch(t, userAgents, parsed)
+
+	// the single line of a peer parses back into its record
+	for i, ua := range userAgents {
+		p, _ := store.GetPeer(testPeerID(fmt.Sprintf("ua-peer%d", i)))
+		record, err := csv.NewReader(bytes.NewBufferString(p.ToCsvLine())).Read()
+		require.NoError(t, err)
+		require.Equal(t, len(CsvHeader()), len(record))
+		require.Equal(t, ua, record[uaIdx])
+		require.Equal(t, ua, p.ToCsvRecord()[uaIdx])
+	}
+
+	// a record that doesn't match the header fails the export
+	require.NoError(t, checkCsvRecord(make([]string, len(PeerCsvHeader)), PeerCsvHeader))
+	require.Error(t, checkCsvRecord(make([]string, len(PeerCsvHeader)-1), PeerCsvHeader))
+}
+
+func Test_RelayOnlyPeers(t *testing.T) {
+	store := NewPeerStore()
+	direct, err := ma.NewMultiaddr("/ip4/86.85.31.80/tcp/9000")
+	require.NoError(t, err)
+	relay, err := ma.NewMultiaddr("/ip4/147.75.83.83/tcp/4001/p2p/QmbLHAnMoJPWSCR5Zhtx6BHJX9KiKNN6tpvbUcqanj75Nb/p2p-circuit")
+	require.NoError(t, err)
+
+	// mixed set of addresses: the IP is taken from the direct one
+	mixedInfo := models.NewHostInfo(testPeerID("mixed"), utils.EthereumNetwork, models.WithMultiaddress([]ma.Multiaddr{relay, direct}))
+	require.Equal(t, "86.85.31.80", mixedInfo.IP)
+	mixed := store.GetOrCreatePeer(mixedInfo.ID)
+	mixed.FetchHostInfo(mixedInfo)
+	require.False(t, mixed.RelayOnly)
+	require.Equal(t, 1, len(mixed.RelayAddrs))
+	require.Equal(t, 2, len(mixed.MAddrs))
+
+	// relay-only set of addresses: no IP for the peer
+	relayInfo := models.NewHostInfo(testPeerID("relay-only"), utils.EthereumNetwork, models.WithMultiaddress([]ma.Multiaddr{relay}))
+	require.Equal(t, "", relayInfo.IP)
+	relayOnly := store.GetOrCreatePeer(relayInfo.ID)
+	relayOnly.FetchHostInfo(relayInfo)
+	require.True(t, relayOnly.RelayOnly)
+	require.Equal(t, "", relayOnly.Ip)
+
+	require.Equal(t, 1, store.RelayOnlyCount())
+	selected := store.SelectPeers(FilterRelayOnly)
+	require.Equal(t, 1, len(selected))
+	require.Equal(t, relayInfo.ID, selected[0].ID)
+
+	// the relay addresses survive the JSON round trip
+	data, err := json.Marshal(relayOnly)
+	require.NoError(t, err)
+	restored := NewPeer("")
+	require.NoError(t, json.Unmarshal(data, restored))
+	require.True(t, restored.RelayOnly)
+	require.Equal(t, relay.String(), restored.RelayAddrs[0].String())
+
+	var buf bytes.Buffer
+	require.NoError(t, store.ExportCsv(&buf))
+	records, err := csv.NewReader(&buf).ReadAll()
+	require.NoError(t, err)
+	relayIdx := csvColumn(t, "relay_only")
+	for _, record := range records[1:] {
+		require.Equal(t, fmt.Sprintf("%t", record[0] == relayInfo.ID.String()), record[relayIdx])
+	}
+}
+
+func Test_ExportCsvFileRotation(t *testing.T) {
+	store := newTestPeerStore()
+	path := filepath.Join(t.TempDir(), "peers.csv")
+
+	// a tiny size limit gets (almost) a file per peer
+	require.NoError(t, store.ExportCsvFile(path, utils.RotationPolicy{MaxSize: 512}))
+	files, err := filepath.Glob(filepath.Join(filepath.Dir(path), "peers*.csv"))
+	require.NoError(t, err)
+	require.Greater(t, len(files), 1)
+
+	peerIDs := make(map[string]bool)
+	for _, file := range files {
+		f, err := os.Open(file)
+		require.NoError(t, err)
+		records, err := csv.NewReader(f).ReadAll()
+		f.Close()
+		require.NoError(t, err)
+		// every file is a well-formed CSV with its own header
+		require.Equal(t, PeerCsvHeader, records[0])
+		for _, record := range records[1:] {
+			require.Equal(t, len(PeerCsvHeader), len(record))
+			peerIDs[record[0]] = true
+		}
+	}
+	require.Equal(t, len(store.SelectPeers()), len(peerIDs))
+}
+
+func Test_ExportCsvTopError(t *testing.T) {
+	store := NewPeerStore()
+	failing := store.GetOrCreatePeer(testPeerID("top-error-peer"))
+	for _, errStr := range []string{"io_timeout", "connection_refused", "io_timeout"} {
+		failing.ConnectionAttemptEvent(false, errStr)
+	}
+	failing.ConnectionAttemptEvent(true, "none")
+	store.GetOrCreatePeer(testPeerID("never-failed-peer"))
+
+	var buf bytes.Buffer
+	require.NoError(t, store.ExportCsv(&buf))
+	records, err := csv.NewReader(&buf).ReadAll()
+	require.NoError(t, err)
+	rows := make(map[string][]string)
+	for _, record := range records[1:] {
+		rows[record[csvColumn(t, "peer_id")]] = record
+	}
+
+	// the top error survives the successful attempt
+	record := rows[failing.ID.String()]
+	require.Equal(t, "none", record[csvColumn(t, "last_error")])
+	require.Equal(t, "io_timeout", record[csvColumn(t, "top_error")])
+	require.Equal(t, "2", record[csvColumn(t, "top_error_count")])
+
+	record = rows[testPeerID("never-failed-peer").String()]
+	require.Equal(t, "", record[csvColumn(t, "top_error")])
+	require.Equal(t, "0", record[csvColumn(t, "top_error_count")])
+}
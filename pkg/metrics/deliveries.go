@@ -0,0 +1,130 @@
+package metrics
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/migalabs/armiarma/pkg/utils"
+	"github.com/pkg/errors"
+)
+
+const (
+	// FirstDeliveryLeadersFile is the default name of the first-delivery leaderboard export
+	FirstDeliveryLeadersFile = "first_delivery_leaderboard.csv"
+)
+
+// FirstDeliveryLeader is a peer of the first-delivery leaderboard of a topic.
+type FirstDeliveryLeader struct {
+	PeerID          peer.ID
+	ClientName      string
+	FirstDeliveries int64
+	Share           float64 // over the first deliveries of all the peers (0-1)
+}
+
+// GetFirstDeliveryLeaders returns the topN peers that most often delivered first a message
+// on the topics with the given short name (i.e. "beacon_block"). The peers without first deliveries
+// are not included, ties are sorted by peer ID, and topN <= 0 returns the full leaderboard.
+func (s *PeerStore) GetFirstDeliveryLeaders(shortTopic string, topN int) []FirstDeliveryLeader {
+	leaders := make([]FirstDeliveryLeader, 0)
+	var total int64
+	s.ForEachPeer(func(p *Peer) bool {
+		p.m.RLock()
+		defer p.m.RUnlock()
+
+		var deliveries int64
+		for topic, msgMetric := range p.MessageMetrics {
+			if shortTopicName(topic) == shortTopic {
+				deliveries += msgMetric.FirstDeliveries
+			}
+		}
+		if deliveries == 0 {
+			return true
+		}
+		client := p.ClientName
+		if client == "" {
+			client = utils.Unknown
+		}
+		leaders = append(leaders, FirstDeliveryLeader{
+			PeerID:          p.ID,
+			ClientName:      client,
+			FirstDeliveries: deliveries,
+		})
+		total += deliveries
+		return true
+	})
+
+	sort.Slice(leaders, func(i, j int) bool {
+		if leaders[i].FirstDeliveries == leaders[j].FirstDeliveries {
+			return leaders[i].PeerID.String() < leaders[j].PeerID.String()
+		}
+		return leaders[i].FirstDeliveries > leaders[j].FirstDeliveries
+	})
+	for i := range leaders {
+		leaders[i].Share = float64(leaders[i].FirstDeliveries) / float64(total)
+	}
+	if topN > 0 && len(leaders) > topN {
+		leaders = leaders[:topN]
+	}
+	return leaders
+}
+
+// deliveredTopics returns the (sorted) short names of the topics with any first delivery.
+func (s *PeerStore) deliveredTopics() []string {
+	topicSet := make(map[string]struct{})
+	s.ForEachPeer(func(p *Peer) bool {
+		p.m.RLock()
+		defer p.m.RUnlock()
+		for topic, msgMetric := range p.MessageMetrics {
+			if msgMetric.FirstDeliveries > 0 {
+				topicSet[shortTopicName(topic)] = struct{}{}
+			}
+		}
+		return true
+	})
+	topics := make([]string, 0, len(topicSet))
+	for topic := range topicSet {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	return topics
+}
+
+// ExportFirstDeliveryLeadersCsv writes into w the full first-delivery leaderboard of every topic.
+func (s *PeerStore) ExportFirstDeliveryLeadersCsv(w io.Writer) error {
+	csvW := csv.NewWriter(w)
+	err := csvW.Write([]string{"topic", "rank", "peer_id", "client_name", "first_deliveries", "share"})
+	if err != nil {
+		return errors.Wrap(err, "unable to write csv header")
+	}
+	for _, topic := range s.deliveredTopics() {
+		for i, leader := range s.GetFirstDeliveryLeaders(topic, 0) {
+			err = csvW.Write([]string{
+				topic,
+				fmt.Sprintf("%d", i+1),
+				leader.PeerID.String(),
+				leader.ClientName,
+				fmt.Sprintf("%d", leader.FirstDeliveries),
+				fmt.Sprintf("%.4f", leader.Share),
+			})
+			if err != nil {
+				return errors.Wrap(err, "unable to write csv row")
+			}
+		}
+	}
+	csvW.Flush()
+	return csvW.Error()
+}
+
+// ExportFirstDeliveryLeadersFile exports the leaderboards into the CSV file at the given path (overwriting it).
+func (s *PeerStore) ExportFirstDeliveryLeadersFile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return errors.Wrap(err, "unable to create first-delivery leaderboard file")
+	}
+	defer f.Close()
+	return s.ExportFirstDeliveryLeadersCsv(f)
+}
@@ -0,0 +1,71 @@
+package metrics
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_FirstDeliveryLeaders(t *testing.T) {
+	store := NewPeerStore()
+	deliveries := []struct {
+		name   string
+		client string
+		blocks int
+	}{
+		{"leader-a", "prysm", 5},
+		{"leader-b", "lighthouse", 2},
+		{"leader-c", "", 2}, // tied with leader-b
+		{"leader-d", "teku", 1},
+		{"leader-e", "nimbus", 0}, // only duplicates
+	}
+	for _, d := range deliveries {
+		p := store.GetOrCreatePeer(testPeerID(d.name))
+		p.ClientName = d.client
+		for i := 0; i < d.blocks; i++ {
+			p.FirstDeliveryEvent(testBlockTopic)
+		}
+		p.DuplicateEvent(testBlockTopic)
+		p.FirstDeliveryEvent(testAttTopic)
+	}
+	// peers without any delivery at all
+	store.GetOrCreatePeer(testPeerID("leader-f"))
+
+	leaders := store.GetFirstDeliveryLeaders("beacon_block", 0)
+	require.Equal(t, 4, len(leaders))
+	require.Equal(t, testPeerID("leader-a"), leaders[0].PeerID)
+	require.Equal(t, int64(5), leaders[0].FirstDeliveries)
+	require.Equal(t, 0.5, leaders[0].Share)
+	require.Equal(t, "prysm", leaders[0].ClientName)
+	// ties are sorted by peer ID
+	require.Equal(t, int64(2), leaders[1].FirstDeliveries)
+	require.Equal(t, int64(2), leaders[2].FirstDeliveries)
+	require.True(t, leaders[1].PeerID.String() < leaders[2].PeerID.String())
+	require.Equal(t, testPeerID("leader-d"), leaders[3].PeerID)
+	require.Equal(t, 0.1, leaders[3].Share)
+
+	top := store.GetFirstDeliveryLeaders("beacon_block", 2)
+	require.Equal(t, leaders[:2], top)
+	require.Equal(t, 5, len(store.GetFirstDeliveryLeaders("beacon_attestation_3", 10)))
+	require.Equal(t, 0, len(store.GetFirstDeliveryLeaders("voluntary_exit", 3)))
+
+	// the duplicates are tracked apart, and don't affect the message times
+	p, _ := store.GetPeer(testPeerID("leader-e"))
+	require.Equal(t, int64(1), p.MessageMetrics[testBlockTopic].Duplicates)
+	require.Equal(t, int64(0), p.MessageMetrics[testBlockTopic].Count)
+	p.MessageEvent(testBlockTopic, time.Unix(1000, 0))
+	require.Equal(t, time.Unix(1000, 0), p.MessageMetrics[testBlockTopic].FirstMessageTime)
+
+	var buf bytes.Buffer
+	require.NoError(t, store.ExportFirstDeliveryLeadersCsv(&buf))
+	records, err := csv.NewReader(&buf).ReadAll()
+	require.NoError(t, err)
+	// header + 5 attestation leaders + 4 block leaders
+	require.Equal(t, 1+5+4, len(records))
+	require.Equal(t, []string{"beacon_block", "1", testPeerID("leader-a").String(), "prysm", "5", "0.5000"}, records[6])
+	require.Equal(t, fmt.Sprintf("%d", 4), records[9][1])
+}
@@ -0,0 +1,128 @@
+package metrics
+
+import (
+	"math"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// DialBackoff spaces out the connection attempts to the same peer after the failed ones. The first
+// failure delays the next attempt by the delay of its (filtered) error, or Default if the error isn't
+// in Errors, and each consecutive failure doubles it up to Max. The errors that leave less hope of
+// connecting the peer get the longer delays (i.e. a refused connection waits more than a timeout,
+// and an unreachable host more than both). A successful attempt resets the delay to Success.
+type DialBackoff struct {
+	Success time.Duration
+	Default time.Duration
+	Errors  map[string]time.Duration
+	Max     time.Duration
+}
+
+// DefaultDialBackoff is the backoff used unless the PeerStore is configured with another one.
+// Its errors are the ones of hosts.ConnErrorRules (not imported, as hosts depends on metrics).
+var DefaultDialBackoff = DialBackoff{
+	Success: 2 * time.Minute,
+	Default: 4 * time.Minute,
+	Errors: map[string]time.Duration{
+		"io_timeout":                2 * time.Minute,
+		"context_deadline_exceeded": 2 * time.Minute,
+		"connection_reset_by_peer":  4 * time.Minute,
+		"connection_refused":        8 * time.Minute,
+		"no_good_addresses":         16 * time.Minute,
+		"no_route_to_host":          32 * time.Minute,
+		"network_unreachable":       32 * time.Minute,
+		"host_is_down":              32 * time.Minute,
+		"peer_id_mismatch":          64 * time.Minute,
+	},
+	Max: 24 * time.Hour,
+}
+
+// Copy returns a copy of the backoff that doesn't share its Errors.
+func (b DialBackoff) Copy() DialBackoff {
+	errs := make(map[string]time.Duration, len(b.Errors))
+	for errKey, delay := range b.Errors {
+		errs[errKey] = delay
+	}
+	b.Errors = errs
+	return b
+}
+
+// Delay returns the time to wait after an attempt that closed the given number of consecutive
+// failures, the last one with the given error.
+func (b DialBackoff) Delay(failures int, err string) time.Duration {
+	if failures == 0 {
+		return b.Success
+	}
+	base, ok := b.Errors[err]
+	if !ok {
+		base = b.Default
+	}
+	return RequestBackoff{Base: base, Max: b.Max}.Interval(failures - 1)
+}
+
+// dialScore needs the lock. It returns how likely the peer is to accept the next connection attempt,
+// from 0 to 100: the success rate of its attempts (starting from 1/2 for the peers that weren't attempted),
+// divided by the consecutive failures up to the last one, rounded to 2 decimals.
+func (p *Peer) dialScore() float64 {
+	rate := float64(p.SuccessfulAttempts+1) / float64(p.Attempts+2)
+	return math.Round(rate/float64(1+p.FailureStreak)*100*100) / 100
+}
+
+// ScheduleDial chooses the delay until the next connection attempt to the peer with the given backoff,
+// after its last attempt, and returns it along with the dial score of the peer (both kept until the
+// next attempt, see NextDialTime).
+func (p *Peer) ScheduleDial(backoff DialBackoff) (time.Duration, float64) {
+	p.m.Lock()
+	defer p.m.Unlock()
+	p.DialDelay = backoff.Delay(p.FailureStreak, p.LastAttemptError)
+	p.DialScore = p.dialScore()
+	return p.DialDelay, p.DialScore
+}
+
+// NextDialTime returns when the peer becomes eligible for a new connection attempt, the zero time if
+// it can be attempted right away (i.e. it was never attempted).
+func (p *Peer) NextDialTime() time.Time {
+	p.m.RLock()
+	defer p.m.RUnlock()
+	if !p.Attempted || p.LastAttempt.IsZero() {
+		return time.Time{}
+	}
+	return p.LastAttempt.Add(p.DialDelay)
+}
+
+// SetDialBackoff configures the backoff of the connection attempts scheduled by the store.
+func (s *PeerStore) SetDialBackoff(backoff DialBackoff) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.dialBackoff = backoff.Copy()
+}
+
+// DialBackoff returns the backoff of the connection attempts scheduled by the store.
+func (s *PeerStore) DialBackoff() DialBackoff {
+	s.m.RLock()
+	defer s.m.RUnlock()
+	return s.dialBackoff
+}
+
+// ScheduleDial chooses the delay until the next connection attempt to the given peer, after its last
+// attempt, with the backoff of the store, and returns it along with the dial score of the peer.
+func (s *PeerStore) ScheduleDial(pid peer.ID) (time.Duration, float64) {
+	return s.GetOrCreatePeer(pid).ScheduleDial(s.DialBackoff())
+}
+
+// NextDialTime returns when the given peer becomes eligible for a new connection attempt, the zero
+// time for the peers that aren't in the store.
+func (s *PeerStore) NextDialTime(pid peer.ID) time.Time {
+	p, ok := s.GetPeer(pid)
+	if !ok {
+		return time.Time{}
+	}
+	return p.NextDialTime()
+}
+
+// ShouldDial returns whether the given peer is eligible for a connection attempt at now
+// (always for the peers that aren't in the store).
+func (s *PeerStore) ShouldDial(pid peer.ID, now time.Time) bool {
+	return !now.Before(s.NextDialTime(pid))
+}
@@ -0,0 +1,136 @@
+package metrics
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_DialBackoffDelay(t *testing.T) {
+	backoff := DialBackoff{
+		Success: time.Minute,
+		Default: 4 * time.Minute,
+		Errors: map[string]time.Duration{
+			"io_timeout":         2 * time.Minute,
+			"connection_refused": 8 * time.Minute,
+			"no_route_to_host":   16 * time.Minute,
+		},
+		Max: time.Hour,
+	}
+	require.Equal(t, time.Minute, backoff.Delay(0, ""))
+
+	// the errors with less hope wait more, and the unknown ones get the default delay
+	require.Equal(t, 2*time.Minute, backoff.Delay(1, "io_timeout"))
+	require.Equal(t, 8*time.Minute, backoff.Delay(1, "connection_refused"))
+	require.Equal(t, 16*time.Minute, backoff.Delay(1, "no_route_to_host"))
+	require.Equal(t, 4*time.Minute, backoff.Delay(1, "unknown"))
+
+	// each consecutive failure doubles the delay, up to the max
+	delays := make([]time.Duration, 0)
+	for failures := 1; failures <= 6; failures++ {
+		delays = append(delays, backoff.Delay(failures, "connection_refused"))
+	}
+	require.Equal(t, []time.Duration{
+		8 * time.Minute, 16 * time.Minute, 32 * time.Minute, time.Hour, time.Hour, time.Hour,
+	}, delays)
+	require.Equal(t, time.Hour, backoff.Delay(1000, "io_timeout"))
+}
+
+func Test_PeerNextDialTime(t *testing.T) {
+	backoff := DialBackoff{
+		Success: time.Minute,
+		Default: 4 * time.Minute,
+		Errors:  map[string]time.Duration{"connection_refused": 8 * time.Minute},
+		Max:     30 * time.Minute,
+	}
+	p := NewPeer(testPeerID("dial-peer"))
+	// never attempted
+	require.True(t, p.NextDialTime().IsZero())
+
+	// the refusals keep growing the delay until the max
+	for i, expected := range []time.Duration{8 * time.Minute, 16 * time.Minute, 30 * time.Minute, 30 * time.Minute} {
+		p.ConnectionAttemptEvent(false, "connection_refused")
+		delay, score := p.ScheduleDial(backoff)
+		require.Equal(t, expected, delay, "failure %d", i+1)
+		require.Equal(t, p.LastAttempt.Add(expected), p.NextDialTime())
+		require.Equal(t, p.DialScore, score)
+	}
+	refusedScore := p.DialScore
+	// (0 + 1) / (4 + 2) successes, divided by 1 + 4 failures
+	require.Equal(t, 3.33, refusedScore)
+
+	// a different error starts from its own delay, keeping the streak
+	p.ConnectionAttemptEvent(false, "io_timeout")
+	delay, _ := p.ScheduleDial(backoff)
+	require.Equal(t, 30*time.Minute, delay)
+	require.Equal(t, "io_timeout", p.LastAttemptError)
+
+	// a successful connection resets the delay
+	p.ConnectionAttemptEvent(true, "none")
+	delay, score := p.ScheduleDial(backoff)
+	require.Equal(t, time.Minute, delay)
+	require.Equal(t, "", p.LastAttemptError)
+	require.Greater(t, score, refusedScore)
+	p.ConnectionAttemptEvent(false, "connection_refused")
+	delay, _ = p.ScheduleDial(backoff)
+	require.Equal(t, 8*time.Minute, delay)
+
+	// the copies keep the schedule
+	cp := p.Copy()
+	require.True(t, p.NextDialTime().Equal(cp.NextDialTime()))
+	require.Equal(t, p.DialScore, cp.DialScore)
+}
+
+func Test_PeerStoreShouldDial(t *testing.T) {
+	store := NewPeerStore()
+	require.Equal(t, DefaultDialBackoff, store.DialBackoff())
+	backoff := DialBackoff{Success: time.Minute, Default: time.Hour, Max: 4 * time.Hour}
+	store.SetDialBackoff(backoff)
+
+	// the unknown and the never attempted peers are always eligible
+	pid := testPeerID("store-dial-peer")
+	require.True(t, store.ShouldDial(pid, time.Now()))
+	p := store.GetOrCreatePeer(pid)
+	require.True(t, store.ShouldDial(pid, time.Now()))
+
+	p.ConnectionAttemptEvent(false, "connection_refused")
+	delay, _ := store.ScheduleDial(pid)
+	require.Equal(t, time.Hour, delay)
+	require.False(t, store.ShouldDial(pid, p.LastAttempt.Add(59*time.Minute)))
+	require.True(t, store.ShouldDial(pid, p.LastAttempt.Add(time.Hour)))
+
+	// the backoff of the store doesn't share its table with the configured one
+	backoff.Errors = map[string]time.Duration{"connection_refused": time.Minute}
+	store.SetDialBackoff(backoff)
+	backoff.Errors["connection_refused"] = 2 * time.Hour
+	require.Equal(t, time.Minute, store.DialBackoff().Errors["connection_refused"])
+}
+
+func Test_DialScheduleExport(t *testing.T) {
+	store := NewPeerStore()
+	pid := testPeerID("export-dial-peer")
+	p := store.GetOrCreatePeer(pid)
+	p.ConnectionAttemptEvent(false, "connection_refused")
+	store.ScheduleDial(pid)
+
+	var buf bytes.Buffer
+	require.NoError(t, store.ExportCsv(&buf))
+	records, err := csv.NewReader(&buf).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	require.Equal(t, "480", records[1][csvColumn(t, "dial_delay_secs")])
+	require.Equal(t, "16.67", records[1][csvColumn(t, "dial_score")])
+
+	data, err := p.ToJSON()
+	require.NoError(t, err)
+	decoded := NewPeer(pid)
+	require.NoError(t, json.Unmarshal(data, decoded))
+	require.Equal(t, 8*time.Minute, decoded.DialDelay)
+	require.Equal(t, 16.67, decoded.DialScore)
+	require.Equal(t, "connection_refused", decoded.LastAttemptError)
+	require.True(t, p.NextDialTime().Equal(decoded.NextDialTime()))
+}
@@ -0,0 +1,69 @@
+package metrics
+
+import (
+	"github.com/migalabs/armiarma/pkg/db/models"
+)
+
+// dialbackEvent keeps the outcome of the dial-back to the peer, unless the one we have is more recent.
+// Has to be called under the lock of the peer.
+func (p *Peer) dialbackEvent(dialback models.Dialback) {
+	if p.DialbackAttempted && dialback.Timestamp.Before(p.DialbackTime) {
+		return
+	}
+	p.DialbackAttempted = true
+	p.DialbackSucceeded = dialback.Success
+	p.DialbackError = dialback.Error
+	p.DialbackTime = dialback.Timestamp
+}
+
+// DialbackEvent tracks the outcome of a dial-back to the peer.
+func (p *Peer) DialbackEvent(dialback models.Dialback) {
+	p.m.Lock()
+	defer p.m.Unlock()
+	p.dialbackEvent(dialback)
+}
+
+// isInboundOnly returns whether we only saw connections from the peer, but never connected to it ourselves.
+// Has to be called under the lock of the peer.
+func (p *Peer) isInboundOnly() bool {
+	return len(p.ConnectionTimes) > 0 && p.SuccessfulAttempts == 0
+}
+
+// NatEstimate is the estimate of the peers behind a NAT: the inbound-only peers (the ones that connected
+// to us but that we never managed to connect to), the ones of them that we dialed back,
+// and the ones that didn't answer the dial-back.
+type NatEstimate struct {
+	InboundOnly int
+	DialedBack  int
+	Unreachable int
+}
+
+// UnreachableShare returns the ratio of the dialed-back inbound-only peers that were unreachable,
+// false if none of them was dialed back yet.
+func (e NatEstimate) UnreachableShare() (float64, bool) {
+	if e.DialedBack == 0 {
+		return 0, false
+	}
+	return float64(e.Unreachable) / float64(e.DialedBack), true
+}
+
+// GetNatEstimate aggregates the dial-backs of the inbound-only peers of the store.
+func (s *PeerStore) GetNatEstimate() NatEstimate {
+	var estimate NatEstimate
+	s.ForEachPeer(func(p *Peer) bool {
+		p.m.RLock()
+		defer p.m.RUnlock()
+		if !p.isInboundOnly() {
+			return true
+		}
+		estimate.InboundOnly++
+		if p.DialbackAttempted {
+			estimate.DialedBack++
+			if !p.DialbackSucceeded {
+				estimate.Unreachable++
+			}
+		}
+		return true
+	})
+	return estimate
+}
@@ -0,0 +1,84 @@
+package metrics
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/migalabs/armiarma/pkg/db/models"
+	"github.com/migalabs/armiarma/pkg/utils"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_PeerDialback(t *testing.T) {
+	pid := testPeerID("dialback-peer")
+	p := NewPeer(pid)
+	t0 := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	failed := models.Dialback{PeerID: pid, Timestamp: t0, Error: "connection_refused"}
+	hInfo := models.NewHostInfo(pid, utils.EthereumNetwork)
+	hInfo.AddAtt(models.DialbackAttribute, failed)
+	p.FetchHostInfo(hInfo)
+	require.True(t, p.DialbackAttempted)
+	require.False(t, p.DialbackSucceeded)
+	require.Equal(t, "connection_refused", p.DialbackError)
+
+	// a late outcome doesn't overwrite a more recent one
+	p.DialbackEvent(models.Dialback{PeerID: pid, Timestamp: t0.Add(time.Hour), Success: true})
+	p.DialbackEvent(models.Dialback{PeerID: pid, Timestamp: t0.Add(time.Minute), Error: "io_timeout"})
+	require.True(t, p.DialbackSucceeded)
+	require.Empty(t, p.DialbackError)
+	require.Equal(t, t0.Add(time.Hour), p.DialbackTime)
+
+	// the latest dial-back wins on a merge
+	older := NewPeer(pid)
+	older.DialbackEvent(failed)
+	older.Merge(p)
+	require.True(t, older.DialbackSucceeded)
+	require.Equal(t, t0.Add(time.Hour), older.DialbackTime)
+	fresh := NewPeer(pid)
+	fresh.Merge(older)
+	require.True(t, fresh.DialbackAttempted)
+}
+
+func Test_PeerStoreNatEstimate(t *testing.T) {
+	store := NewPeerStore()
+	now := time.Now()
+	addPeer := func(name string, connected bool, dialedOut bool, dialback *models.Dialback) {
+		p := store.GetOrCreatePeer(testPeerID(name))
+		if connected {
+			p.ConnectionTimes = append(p.ConnectionTimes, now)
+		}
+		if dialedOut {
+			p.SuccessfulAttempts = 1
+		}
+		if dialback != nil {
+			p.DialbackEvent(*dialback)
+		}
+	}
+	// peers that we connected to ourselves don't count, even if they got dialed back
+	addPeer("outbound", true, true, &models.Dialback{Timestamp: now, Success: true})
+	// inbound-only peers, reachable, natted and not dialed back yet
+	addPeer("reachable", true, false, &models.Dialback{Timestamp: now, Success: true})
+	addPeer("natted-1", true, false, &models.Dialback{Timestamp: now, Error: "connection_refused"})
+	addPeer("natted-2", true, false, &models.Dialback{Timestamp: now, Error: "io_timeout"})
+	addPeer("pending", true, false, nil)
+	// never connected
+	addPeer("discovered", false, false, nil)
+
+	estimate := store.GetNatEstimate()
+	require.Equal(t, NatEstimate{InboundOnly: 4, DialedBack: 3, Unreachable: 2}, estimate)
+	share, ok := estimate.UnreachableShare()
+	require.True(t, ok)
+	require.InDelta(t, 2.0/3.0, share, 1e-9)
+
+	_, ok = NewPeerStore().GetNatEstimate().UnreachableShare()
+	require.False(t, ok)
+
+	// the dial-backs survive a checkpoint
+	var buf bytes.Buffer
+	require.NoError(t, store.Checkpoint(&buf))
+	restored := NewPeerStore()
+	require.NoError(t, restored.RestoreFrom(&buf))
+	require.Equal(t, estimate, restored.GetNatEstimate())
+}
@@ -0,0 +1,159 @@
+package metrics
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	log "github.com/sirupsen/logrus"
+)
+
+// EvictionPolicy defines which peers get evicted from the PeerStore. A peer that is connected is
+// never evicted, and the ones that are deprecated or had no interaction with the crawler for
+// the InactiveWindow are.
+type EvictionPolicy struct {
+	Interval       time.Duration // time between the eviction rounds
+	InactiveWindow time.Duration // time without interactions to evict a peer (0 only evicts the deprecated ones)
+	// only evict the peers whose latest state is already in a checkpoint or an export (see MarkSynced)
+	RequireSync bool
+}
+
+// MarkSynced records that the whole store, as it was at t, was checkpointed or exported.
+func (s *PeerStore) MarkSynced(t time.Time) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if t.After(s.syncedAt) {
+		s.syncedAt = t
+	}
+}
+
+// Evictions returns the number of peers evicted from the store so far.
+func (s *PeerStore) Evictions() int64 {
+	return atomic.LoadInt64(&s.evictions)
+}
+
+// EvictPeers removes from the store the peers that the policy allows to evict at now, returning how many.
+// The candidates are collected without locking the store, and checked again right before
+// removing them, so a peer that showed activity in between is kept. An evicted peer that shows up
+// again is added as a new one.
+func (s *PeerStore) EvictPeers(policy EvictionPolicy, now time.Time) int {
+	s.m.RLock()
+	syncedAt := s.syncedAt
+	s.m.RUnlock()
+	if policy.RequireSync && syncedAt.IsZero() {
+		return 0
+	}
+
+	candidates := make([]peer.ID, 0)
+	s.ForEachPeer(func(p *Peer) bool {
+		if p.evictable(policy, now, syncedAt) {
+			candidates = append(candidates, p.ID)
+		}
+		return true
+	})
+
+	evicted := 0
+	for _, pid := range candidates {
+		if s.removePeer(pid, func(p *Peer) bool { return p.evictable(policy, now, syncedAt) }) {
+			evicted++
+		}
+	}
+	atomic.AddInt64(&s.evictions, int64(evicted))
+	return evicted
+}
+
+// evictable returns whether the policy allows evicting the peer at now.
+func (p *Peer) evictable(policy EvictionPolicy, now, syncedAt time.Time) bool {
+	p.m.RLock()
+	defer p.m.RUnlock()
+
+	if p.IsConnected {
+		return false
+	}
+	lastUpdate := p.lastUpdate()
+	if policy.RequireSync && !lastUpdate.Before(syncedAt) {
+		return false
+	}
+	if p.Deprecated {
+		return true
+	}
+	// the peers that were only discovered are waiting to be dialed
+	if lastUpdate.IsZero() {
+		return false
+	}
+	return policy.InactiveWindow > 0 && lastUpdate.Before(now.Add(-policy.InactiveWindow))
+}
+
+// lastUpdate returns the last time we had any interaction with the peer,
+// including the connection attempts and the Goodbyes (needs the lock).
+func (p *Peer) lastUpdate() time.Time {
+	last := p.lastActivity()
+	for _, t := range []time.Time{p.LastAttempt, p.LastGoodbyeTime} {
+		if t.After(last) {
+			last = t
+		}
+	}
+	return last
+}
+
+// Evictor periodically evicts the peers of the PeerStore that its EvictionPolicy allows to.
+type Evictor struct {
+	ctx context.Context
+
+	store  *PeerStore
+	policy EvictionPolicy
+	nowFn  func() time.Time
+
+	wg     sync.WaitGroup
+	closeC chan struct{}
+}
+
+// NewEvictor returns an Evictor that will evict the peers of the store every policy.Interval.
+func NewEvictor(ctx context.Context, store *PeerStore, policy EvictionPolicy) *Evictor {
+	return &Evictor{
+		ctx:    ctx,
+		store:  store,
+		policy: policy,
+		nowFn:  time.Now,
+		closeC: make(chan struct{}),
+	}
+}
+
+// Start spawns the eviction routine.
+func (e *Evictor) Start() {
+	e.wg.Add(1)
+	go func() {
+		defer e.wg.Done()
+		ticker := time.NewTicker(e.policy.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				e.evict()
+			case <-e.closeC:
+				return
+			case <-e.ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Close stops the eviction routine.
+func (e *Evictor) Close() {
+	close(e.closeC)
+	e.wg.Wait()
+}
+
+func (e *Evictor) evict() {
+	start := time.Now()
+	evicted := e.store.EvictPeers(e.policy, e.nowFn())
+	log.WithFields(log.Fields{
+		"evicted":  evicted,
+		"total":    e.store.Evictions(),
+		"peers":    e.store.Len(),
+		"duration": time.Since(start),
+	}).Debug("evicted peers from the peer store")
+}
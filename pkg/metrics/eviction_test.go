@@ -0,0 +1,127 @@
+package metrics
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_EvictPeers(t *testing.T) {
+	store := NewPeerStore()
+	now := time.Unix(100000, 0)
+	policy := EvictionPolicy{InactiveWindow: time.Hour}
+
+	// connected for long, even if deprecated
+	connected := store.GetOrCreatePeer(testPeerID("evict-connected"))
+	connected.ConnectionEvent(now.Add(-48 * time.Hour))
+	connected.DeprecationEvent()
+	// disconnected recently
+	recent := store.GetOrCreatePeer(testPeerID("evict-recent"))
+	recent.ConnectionEvent(now.Add(-2 * time.Hour))
+	recent.DisconnectionEvent(now.Add(-10 * time.Minute))
+	// attempted recently, but never connected
+	attempted := store.GetOrCreatePeer(testPeerID("evict-attempted"))
+	attempted.ConnectionAttemptEvent(false, "timeout")
+	attempted.LastAttempt = now.Add(-time.Minute)
+	// only discovered, waiting to be dialed
+	store.GetOrCreatePeer(testPeerID("evict-discovered"))
+	// deprecated right after the last attempt
+	deprecated := store.GetOrCreatePeer(testPeerID("evict-deprecated"))
+	deprecated.ConnectionAttemptEvent(false, "timeout")
+	deprecated.LastAttempt = now.Add(-time.Minute)
+	deprecated.DeprecationEvent()
+	// without interactions for longer than the window
+	inactive := store.GetOrCreatePeer(testPeerID("evict-inactive"))
+	inactive.ConnectionEvent(now.Add(-3 * time.Hour))
+	inactive.DisconnectionEvent(now.Add(-2 * time.Hour))
+
+	require.Equal(t, 2, store.EvictPeers(policy, now))
+	require.Equal(t, int64(2), store.Evictions())
+	require.Equal(t, 4, store.Len())
+	for _, name := range []string{"evict-connected", "evict-recent", "evict-attempted", "evict-discovered"} {
+		_, ok := store.GetPeer(testPeerID(name))
+		require.True(t, ok, name)
+	}
+
+	// the active peers are never evicted, however long the crawl lasts
+	require.Equal(t, 0, store.EvictPeers(EvictionPolicy{}, now.Add(24*time.Hour)))
+	require.Equal(t, 2, store.EvictPeers(policy, now.Add(24*time.Hour)))
+	require.Equal(t, 2, store.Len())
+	_, ok := store.GetPeer(testPeerID("evict-connected"))
+	require.True(t, ok)
+	_, ok = store.GetPeer(testPeerID("evict-discovered"))
+	require.True(t, ok)
+	require.Equal(t, int64(4), store.Evictions())
+
+	// an evicted peer that shows up again looks new
+	readmitted := store.GetOrCreatePeer(testPeerID("evict-deprecated"))
+	require.False(t, readmitted.Deprecated)
+	require.Equal(t, 0, readmitted.Attempts)
+}
+
+func Test_EvictPeersRequireSync(t *testing.T) {
+	store := NewPeerStore()
+	now := time.Unix(100000, 0)
+	policy := EvictionPolicy{InactiveWindow: time.Hour, RequireSync: true}
+
+	old := store.GetOrCreatePeer(testPeerID("sync-old"))
+	old.ConnectionEvent(now.Add(-5 * time.Hour))
+	old.DisconnectionEvent(now.Add(-4 * time.Hour))
+	updated := store.GetOrCreatePeer(testPeerID("sync-updated"))
+	updated.ConnectionEvent(now.Add(-5 * time.Hour))
+	updated.DisconnectionEvent(now.Add(-2 * time.Hour))
+
+	// nothing leaves the store before it was checkpointed or exported
+	require.Equal(t, 0, store.EvictPeers(policy, now))
+
+	// the peers updated after the sync have to wait for the next one
+	store.MarkSynced(now.Add(-3 * time.Hour))
+	require.Equal(t, 1, store.EvictPeers(policy, now))
+	_, ok := store.GetPeer(testPeerID("sync-updated"))
+	require.True(t, ok)
+
+	// a sync older than the last one doesn't move it backwards
+	store.MarkSynced(now.Add(-6 * time.Hour))
+	require.Equal(t, 0, store.EvictPeers(policy, now))
+	store.MarkSynced(now)
+	require.Equal(t, 1, store.EvictPeers(policy, now))
+	require.Equal(t, 0, store.Len())
+}
+
+func Test_PeerDeprecation(t *testing.T) {
+	p := NewPeer(testPeerID("deprecation"))
+	p.ConnectionAttemptEvent(false, "timeout")
+	p.DeprecationEvent()
+	require.True(t, p.Copy().Deprecated)
+
+	// the deprecation of the record with the latest attempt wins
+	other := NewPeer(testPeerID("deprecation"))
+	other.ConnectionAttemptEvent(true, "")
+	other.LastAttempt = p.LastAttempt.Add(time.Second)
+	merged := p.Copy()
+	merged.Merge(other)
+	require.False(t, merged.Deprecated)
+
+	// a successful attempt undeprecates the peer
+	p.ConnectionAttemptEvent(true, "")
+	require.False(t, p.Deprecated)
+}
+
+func Test_EvictorStops(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	store := NewPeerStore()
+	p := store.GetOrCreatePeer(testPeerID("evictor-deprecated"))
+	p.ConnectionAttemptEvent(false, "timeout")
+	p.DeprecationEvent()
+
+	evictor := NewEvictor(ctx, store, EvictionPolicy{Interval: 10 * time.Millisecond})
+	evictor.Start()
+	require.Eventually(t, func() bool {
+		return store.Evictions() == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	evictor.Close()
+	require.Equal(t, 0, store.Len())
+}
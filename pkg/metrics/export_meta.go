@@ -0,0 +1,109 @@
+package metrics
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/migalabs/armiarma/pkg/utils"
+	"github.com/pkg/errors"
+)
+
+const (
+	// ExportFormatVersion is the version of the layout of the peer exports, to be bumped whenever
+	// their columns change
+	ExportFormatVersion = 6
+	// ExportMetaSuffix is appended to the path of an export to name its metadata sidecar
+	// (i.e. peers.csv.meta.json for peers.csv)
+	ExportMetaSuffix = ".meta.json"
+)
+
+// ExportMeta describes the content of a peer export, so that it can be consumed without parsing it.
+// It's written next to every export file once the file is complete (see WriteExportMeta).
+type ExportMeta struct {
+	FormatVersion int    `json:"format_version"`
+	Format        string `json:"format"`
+	// "gzip" if the export file is compressed
+	Compression string    `json:"compression,omitempty"`
+	Columns     []string  `json:"columns"`
+	Network     string    `json:"network"`
+	ExportedAt  time.Time `json:"exported_at"`
+	// earliest and latest activity of the exported peers (empty if none of them had any)
+	FirstSeen *time.Time `json:"first_seen,omitempty"`
+	LastSeen  *time.Time `json:"last_seen,omitempty"`
+	// number of peer rows (without the header)
+	Rows           int64  `json:"rows"`
+	CrawlerVersion string `json:"crawler_version"`
+}
+
+// newPeerCsvMeta returns the ExportMeta of an empty per-peer CSV export of the store.
+func (s *PeerStore) newPeerCsvMeta(exportedAt time.Time, compressed bool) ExportMeta {
+	meta := ExportMeta{
+		FormatVersion:  ExportFormatVersion,
+		Format:         "csv",
+		Columns:        CsvHeader(),
+		Network:        s.Network(),
+		ExportedAt:     exportedAt,
+		CrawlerVersion: utils.CrawlerVersion,
+	}
+	if compressed {
+		meta.Compression = "gzip"
+	}
+	return meta
+}
+
+// addRow counts a peer row, widening the time window with its activity.
+func (m *ExportMeta) addRow(firstSeen, lastSeen time.Time) {
+	m.Rows++
+	if !firstSeen.IsZero() && (m.FirstSeen == nil || firstSeen.Before(*m.FirstSeen)) {
+		t := firstSeen
+		m.FirstSeen = &t
+	}
+	if !lastSeen.IsZero() && (m.LastSeen == nil || lastSeen.After(*m.LastSeen)) {
+		t := lastSeen
+		m.LastSeen = &t
+	}
+}
+
+// WriteExportMeta writes the sidecar of the export at the given path. It's written into a temporary
+// file that replaces the sidecar once complete, so that a sidecar is never half-written.
+func WriteExportMeta(exportPath string, meta ExportMeta) error {
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "unable to encode export metadata")
+	}
+	path := exportPath + ExportMetaSuffix
+	tmpPath := path + ".tmp"
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return errors.Wrap(err, "unable to create export metadata file")
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	closeErr := f.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return errors.Wrap(err, "unable to write export metadata file")
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// ReadExportMeta reads the sidecar of the export at the given path.
+func ReadExportMeta(exportPath string) (ExportMeta, error) {
+	var meta ExportMeta
+	data, err := ioutil.ReadFile(exportPath + ExportMetaSuffix)
+	if err != nil {
+		return meta, errors.Wrap(err, "unable to read export metadata file")
+	}
+	err = json.Unmarshal(data, &meta)
+	if err != nil {
+		return meta, errors.Wrap(err, "unable to decode export metadata file")
+	}
+	return meta, nil
+}
@@ -0,0 +1,102 @@
+package metrics
+
+import (
+	"context"
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/migalabs/armiarma/pkg/utils"
+	"github.com/stretchr/testify/require"
+)
+
+// requireMetaMatchesCsv checks the sidecar of the CSV export at the given path against its content,
+// returning the sidecar.
+func requireMetaMatchesCsv(t *testing.T, path string) ExportMeta {
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	records, err := csv.NewReader(f).ReadAll()
+	f.Close()
+	require.NoError(t, err)
+
+	meta, err := ReadExportMeta(path)
+	require.NoError(t, err)
+	require.Equal(t, ExportFormatVersion, meta.FormatVersion)
+	require.Equal(t, "csv", meta.Format)
+	require.Equal(t, records[0], meta.Columns)
+	require.Equal(t, int64(len(records)-1), meta.Rows)
+	require.Equal(t, utils.CrawlerVersion, meta.CrawlerVersion)
+	require.NoFileExists(t, path+ExportMetaSuffix+".tmp")
+	return meta
+}
+
+func Test_ExportCsvFileMeta(t *testing.T) {
+	store := newTestPeerStore()
+	store.SetNetwork("mainnet")
+	path := filepath.Join(t.TempDir(), "peers.csv")
+
+	require.NoError(t, store.ExportCsvFile(path, utils.RotationPolicy{}))
+	meta := requireMetaMatchesCsv(t, path)
+	require.Equal(t, int64(5), meta.Rows)
+	require.Equal(t, "mainnet", meta.Network)
+	require.Empty(t, meta.Compression)
+	// the connections of the test peers go from t0 to t0+2h
+	t0 := time.Unix(1000, 0)
+	require.True(t, t0.Equal(*meta.FirstSeen))
+	require.True(t, t0.Add(2*time.Hour).Equal(*meta.LastSeen))
+
+	// an empty store covers no time window
+	path = filepath.Join(t.TempDir(), "empty.csv")
+	require.NoError(t, NewPeerStore().ExportCsvFile(path, utils.RotationPolicy{}))
+	meta = requireMetaMatchesCsv(t, path)
+	require.Equal(t, int64(0), meta.Rows)
+	require.Nil(t, meta.FirstSeen)
+	require.Nil(t, meta.LastSeen)
+}
+
+func Test_ExportCsvFileRotationMeta(t *testing.T) {
+	store := newTestPeerStore()
+	path := filepath.Join(t.TempDir(), "peers.csv")
+	require.NoError(t, store.ExportCsvFile(path, utils.RotationPolicy{MaxSize: 512}))
+
+	files, err := filepath.Glob(filepath.Join(filepath.Dir(path), "peers*.csv"))
+	require.NoError(t, err)
+	require.Greater(t, len(files), 1)
+	// every rotated file gets its own sidecar
+	var rows int64
+	for _, file := range files {
+		rows += requireMetaMatchesCsv(t, file).Rows
+	}
+	require.Equal(t, int64(store.Len()), rows)
+}
+
+func Test_ExportSchedulerMeta(t *testing.T) {
+	store := newTestPeerStore()
+	dir := t.TempDir()
+	scheduler := NewExportScheduler(context.Background(), store.PeerCsvExporter(), dir, "peers.csv", time.Minute, 1)
+	t0 := time.Date(2023, 1, 2, 15, 4, 5, 0, time.UTC)
+	now := t0
+	scheduler.nowFn = func() time.Time {
+		return now
+	}
+
+	run := scheduler.export()
+	require.NoError(t, run.Err)
+	meta := requireMetaMatchesCsv(t, run.File)
+	require.Equal(t, run.Rows, meta.Rows)
+
+	// the sidecars don't count as exports, and leave with their export
+	now = t0.Add(time.Minute)
+	run = scheduler.export()
+	require.NoError(t, run.Err)
+	files, err := scheduler.ExportFiles()
+	require.NoError(t, err)
+	require.Equal(t, []string{run.File}, files)
+	sidecars, err := filepath.Glob(filepath.Join(dir, "*"+ExportMetaSuffix))
+	require.NoError(t, err)
+	require.Equal(t, []string{run.File + ExportMetaSuffix}, sidecars)
+	require.True(t, strings.HasSuffix(sidecars[0], ".csv.meta.json"))
+}
@@ -0,0 +1,299 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// layout of the timestamp of the scheduled export files (sortable by name)
+const exportTimeLayout = "20060102T150405Z"
+
+// Exporter writes an export into w, returning the number of rows written.
+type Exporter interface {
+	Export(w io.Writer) (rows int64, err error)
+}
+
+// ExporterFunc adapts a function into an Exporter.
+type ExporterFunc func(w io.Writer) (int64, error)
+
+// Export calls f(w).
+func (f ExporterFunc) Export(w io.Writer) (int64, error) {
+	return f(w)
+}
+
+// MetaExporter is an Exporter that also describes what it wrote, so that the ExportScheduler
+// writes the metadata sidecar of each export.
+type MetaExporter interface {
+	Exporter
+	ExportWithMeta(w io.Writer) (ExportMeta, error)
+}
+
+// PeerCsvExporter returns a MetaExporter of the per-peer CSV export of the store (see ExportCsv),
+// which marks the store as synced after each successful export.
+func (s *PeerStore) PeerCsvExporter() Exporter {
+	return &peerCsvExporter{s}
+}
+
+type peerCsvExporter struct {
+	s *PeerStore
+}
+
+func (e *peerCsvExporter) Export(w io.Writer) (int64, error) {
+	meta, err := e.ExportWithMeta(w)
+	return meta.Rows, err
+}
+
+func (e *peerCsvExporter) ExportWithMeta(w io.Writer) (ExportMeta, error) {
+	start := time.Now()
+	meta, err := e.s.exportCsvWithMeta(w)
+	if err == nil {
+		e.s.MarkSynced(start)
+	}
+	return meta, err
+}
+
+// lineCountWriter counts the lines written through it.
+type lineCountWriter struct {
+	w     io.Writer
+	lines int64
+}
+
+func (l *lineCountWriter) Write(p []byte) (int, error) {
+	n, err := l.w.Write(p)
+	l.lines += int64(bytes.Count(p[:n], []byte{'\n'}))
+	return n, err
+}
+
+// ExportRun is the result of a scheduled export.
+type ExportRun struct {
+	File     string
+	Start    time.Time
+	Duration time.Duration
+	Rows     int64
+	Err      error
+}
+
+// ExportScheduler periodically runs an export into a new timestamped file of a directory
+// (i.e. peers-20230102T150405Z.csv for peers.csv), keeping only the newest files.
+// A failed export is logged and retried on the next tick.
+type ExportScheduler struct {
+	ctx context.Context
+
+	exporter Exporter
+	dir      string
+	name     string
+	interval time.Duration
+	keep     int
+	nowFn    func() time.Time
+
+	m        sync.Mutex
+	lastRun  ExportRun
+	runs     int64
+	failures int64
+
+	wg     sync.WaitGroup
+	closeC chan struct{}
+}
+
+// NewExportScheduler returns an ExportScheduler that will export every interval into the given
+// directory, keeping the newest keep files named after name (0 keeps all of them).
+func NewExportScheduler(
+	ctx context.Context,
+	exporter Exporter,
+	dir, name string,
+	interval time.Duration,
+	keep int) *ExportScheduler {
+
+	return &ExportScheduler{
+		ctx:      ctx,
+		exporter: exporter,
+		dir:      dir,
+		name:     name,
+		interval: interval,
+		keep:     keep,
+		nowFn:    time.Now,
+		closeC:   make(chan struct{}),
+	}
+}
+
+// Start spawns the routine that exports on every tick, until Close is called or the context is done.
+func (e *ExportScheduler) Start() {
+	e.wg.Add(1)
+	go func() {
+		defer e.wg.Done()
+		ticker := time.NewTicker(e.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				e.export()
+			case <-e.closeC:
+				return
+			case <-e.ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Close stops the export routine, waiting for the ongoing export (if any).
+func (e *ExportScheduler) Close() {
+	close(e.closeC)
+	e.wg.Wait()
+}
+
+// LastRun returns the result of the last export.
+func (e *ExportScheduler) LastRun() ExportRun {
+	e.m.Lock()
+	defer e.m.Unlock()
+	return e.lastRun
+}
+
+// Runs returns the number of exports run so far, and how many of them failed.
+func (e *ExportScheduler) Runs() (runs int64, failures int64) {
+	e.m.Lock()
+	defer e.m.Unlock()
+	return e.runs, e.failures
+}
+
+// export runs the export into a new file and removes the files out of the retention.
+func (e *ExportScheduler) export() ExportRun {
+	start := e.nowFn()
+	run := ExportRun{
+		File:  e.filePath(start),
+		Start: start,
+	}
+	run.Rows, run.Err = e.exportToFile(run.File)
+	run.Duration = e.nowFn().Sub(start)
+
+	e.m.Lock()
+	e.lastRun = run
+	e.runs++
+	if run.Err != nil {
+		e.failures++
+	}
+	e.m.Unlock()
+
+	if run.Err != nil {
+		log.Error(errors.Wrap(run.Err, "scheduled export into "+run.File+" failed"))
+		return run
+	}
+	log.WithFields(log.Fields{
+		"file":     run.File,
+		"rows":     run.Rows,
+		"duration": run.Duration,
+	}).Debug("scheduled export done")
+
+	err := e.applyRetention()
+	if err != nil {
+		log.Error(errors.Wrap(err, "unable to remove old exports"))
+	}
+	return run
+}
+
+// exportToFile writes the export into a temporary file that replaces the given one once it's complete,
+// so that a failed export never leaves a half-written file behind. The metadata sidecar (if the
+// exporter is a MetaExporter) is only written once the export file is in place.
+func (e *ExportScheduler) exportToFile(path string) (int64, error) {
+	tmpPath := path + ".tmp"
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return 0, errors.Wrap(err, "unable to create export file")
+	}
+	var rows int64
+	var meta *ExportMeta
+	if metaExporter, ok := e.exporter.(MetaExporter); ok {
+		var exportMeta ExportMeta
+		exportMeta, err = metaExporter.ExportWithMeta(f)
+		rows, meta = exportMeta.Rows, &exportMeta
+	} else {
+		rows, err = e.exporter.Export(f)
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	closeErr := f.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return rows, err
+	}
+	err = os.Rename(tmpPath, path)
+	if err != nil || meta == nil {
+		return rows, err
+	}
+	return rows, WriteExportMeta(path, *meta)
+}
+
+// filePath returns the path of the export file of the given time.
+func (e *ExportScheduler) filePath(t time.Time) string {
+	name, ext := splitExportName(e.name)
+	return filepath.Join(e.dir, name+"-"+t.UTC().Format(exportTimeLayout)+ext)
+}
+
+// ExportFiles returns the export files of the directory, from the oldest to the newest.
+func (e *ExportScheduler) ExportFiles() ([]string, error) {
+	entries, err := os.ReadDir(e.dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to read export dir "+e.dir)
+	}
+	name, ext := splitExportName(e.name)
+	files := make([]string, 0)
+	for _, entry := range entries {
+		fileName := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(fileName, name+"-") || !strings.HasSuffix(fileName, ext) {
+			continue
+		}
+		stamp := strings.TrimSuffix(strings.TrimPrefix(fileName, name+"-"), ext)
+		if _, err := time.Parse(exportTimeLayout, stamp); err != nil {
+			continue
+		}
+		files = append(files, filepath.Join(e.dir, fileName))
+	}
+	// the timestamps sort by name
+	sort.Strings(files)
+	return files, nil
+}
+
+// applyRetention removes the oldest export files beyond the ones to keep.
+func (e *ExportScheduler) applyRetention() error {
+	if e.keep <= 0 {
+		return nil
+	}
+	files, err := e.ExportFiles()
+	if err != nil {
+		return err
+	}
+	for i := 0; i < len(files)-e.keep; i++ {
+		err = os.Remove(files[i])
+		if err != nil {
+			return errors.Wrap(err, "unable to remove export "+files[i])
+		}
+		err = os.Remove(files[i] + ExportMetaSuffix)
+		if err != nil && !os.IsNotExist(err) {
+			return errors.Wrap(err, "unable to remove export metadata of "+files[i])
+		}
+		log.Debugf("removed old export %s", files[i])
+	}
+	return nil
+}
+
+// splitExportName splits the name of the export before its extension (i.e. "peers" and ".csv.gz").
+func splitExportName(base string) (name, ext string) {
+	if idx := strings.Index(base, "."); idx > 0 {
+		return base[:idx], base[idx:]
+	}
+	return base, ""
+}
@@ -0,0 +1,121 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+// memExporter exports the given rows, or fails if it's told to.
+type memExporter struct {
+	rows int
+	fail bool
+}
+
+func (m *memExporter) Export(w io.Writer) (int64, error) {
+	if m.fail {
+		// leave a partial export behind
+		fmt.Fprintln(w, "header")
+		return 0, errors.New("export failed")
+	}
+	for i := 0; i < m.rows; i++ {
+		if _, err := fmt.Fprintf(w, "row-%d\n", i); err != nil {
+			return int64(i), err
+		}
+	}
+	return int64(m.rows), nil
+}
+
+func Test_ExportSchedulerRetention(t *testing.T) {
+	dir := t.TempDir()
+	// files that aren't exports are never removed
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "peers.csv"), []byte("final"), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "peers-notatime.csv"), []byte("other"), 0644))
+
+	exporter := &memExporter{rows: 3}
+	scheduler := NewExportScheduler(context.Background(), exporter, dir, "peers.csv", time.Minute, 2)
+	t0 := time.Date(2023, 1, 2, 15, 4, 5, 0, time.UTC)
+	now := t0
+	scheduler.nowFn = func() time.Time {
+		return now
+	}
+
+	for i := 0; i < 4; i++ {
+		now = t0.Add(time.Duration(i) * time.Minute)
+		run := scheduler.export()
+		require.NoError(t, run.Err)
+		require.Equal(t, int64(3), run.Rows)
+		require.Equal(t, filepath.Join(dir, fmt.Sprintf("peers-20230102T15%02d05Z.csv", 4+i)), run.File)
+	}
+	files, err := scheduler.ExportFiles()
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		filepath.Join(dir, "peers-20230102T150605Z.csv"),
+		filepath.Join(dir, "peers-20230102T150705Z.csv"),
+	}, files)
+	content, err := ioutil.ReadFile(files[1])
+	require.NoError(t, err)
+	require.Equal(t, "row-0\nrow-1\nrow-2\n", string(content))
+	require.FileExists(t, filepath.Join(dir, "peers.csv"))
+	require.FileExists(t, filepath.Join(dir, "peers-notatime.csv"))
+
+	// a failed export is recorded, leaves no file and doesn't remove any of the kept ones
+	exporter.fail = true
+	now = t0.Add(10 * time.Minute)
+	run := scheduler.export()
+	require.Error(t, run.Err)
+	require.NoFileExists(t, run.File)
+	require.NoFileExists(t, run.File+".tmp")
+	failedFiles, err := scheduler.ExportFiles()
+	require.NoError(t, err)
+	require.Equal(t, files, failedFiles)
+
+	// and the next tick retries it
+	exporter.fail = false
+	now = t0.Add(11 * time.Minute)
+	require.NoError(t, scheduler.export().Err)
+	files, err = scheduler.ExportFiles()
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		filepath.Join(dir, "peers-20230102T150705Z.csv"),
+		filepath.Join(dir, "peers-20230102T151505Z.csv"),
+	}, files)
+
+	runs, failures := scheduler.Runs()
+	require.Equal(t, int64(6), runs)
+	require.Equal(t, int64(1), failures)
+	require.Equal(t, t0.Add(11*time.Minute), scheduler.LastRun().Start)
+}
+
+func Test_ExportSchedulerStops(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	scheduler := NewExportScheduler(ctx, &memExporter{rows: 1}, t.TempDir(), "peers.csv", 10*time.Millisecond, 0)
+	scheduler.Start()
+	require.Eventually(t, func() bool {
+		runs, _ := scheduler.Runs()
+		return runs >= 2
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// no export runs once the context is done
+	cancel()
+	scheduler.wg.Wait()
+	runs, _ := scheduler.Runs()
+	time.Sleep(50 * time.Millisecond)
+	stoppedRuns, _ := scheduler.Runs()
+	require.Equal(t, runs, stoppedRuns)
+	scheduler.Close()
+}
+
+func Test_PeerCsvExporterRows(t *testing.T) {
+	store := newTestPeerStore()
+	rows, err := store.PeerCsvExporter().Export(io.Discard)
+	require.NoError(t, err)
+	require.Equal(t, int64(store.Len()), rows)
+}
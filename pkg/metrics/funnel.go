@@ -0,0 +1,142 @@
+package metrics
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// FunnelEndpoint is the HTTP path where the crawl funnel is served.
+const FunnelEndpoint = "/funnel"
+
+// FunnelStage is a stage of the crawl funnel that a peer reached.
+// The stages are not strictly nested (i.e. the inbound connections skip our attempts).
+type FunnelStage uint8
+
+const (
+	// the peer is known at all
+	FunnelDiscovered FunnelStage = 1 << iota
+	// the crawler tried to connect it
+	FunnelAttempted
+	// it was connected at least once
+	FunnelConnected
+	// its user agent is known
+	FunnelIdentified
+	// its metadata was obtained
+	FunnelMetadata
+)
+
+// Funnel is the number of peers of the store that reached each stage of the crawl funnel.
+type Funnel struct {
+	Discovered int64 `json:"discovered"`
+	Attempted  int64 `json:"attempted"`
+	Connected  int64 `json:"connected"`
+	Identified int64 `json:"identified"`
+	Metadata   int64 `json:"metadata"`
+}
+
+// funnelCounters are the store-level counters of the funnel, updated by the peers as they
+// reach each stage (atomic).
+type funnelCounters struct {
+	discovered int64
+	attempted  int64
+	connected  int64
+	identified int64
+	metadata   int64
+}
+
+// add adds delta to the counters of the given stages.
+func (f *funnelCounters) add(stages FunnelStage, delta int64) {
+	if stages&FunnelDiscovered != 0 {
+		atomic.AddInt64(&f.discovered, delta)
+	}
+	if stages&FunnelAttempted != 0 {
+		atomic.AddInt64(&f.attempted, delta)
+	}
+	if stages&FunnelConnected != 0 {
+		atomic.AddInt64(&f.connected, delta)
+	}
+	if stages&FunnelIdentified != 0 {
+		atomic.AddInt64(&f.identified, delta)
+	}
+	if stages&FunnelMetadata != 0 {
+		atomic.AddInt64(&f.metadata, delta)
+	}
+}
+
+func (f *funnelCounters) snapshot() Funnel {
+	return Funnel{
+		Discovered: atomic.LoadInt64(&f.discovered),
+		Attempted:  atomic.LoadInt64(&f.attempted),
+		Connected:  atomic.LoadInt64(&f.connected),
+		Identified: atomic.LoadInt64(&f.identified),
+		Metadata:   atomic.LoadInt64(&f.metadata),
+	}
+}
+
+// funnelStages returns the stages of the funnel that the peer reached (needs the lock).
+func (p *Peer) funnelStages() FunnelStage {
+	stages := FunnelDiscovered
+	if p.Attempted {
+		stages |= FunnelAttempted
+	}
+	if p.Succeed || len(p.ConnectionTimes) > 0 {
+		stages |= FunnelConnected
+	}
+	if p.UserAgent != "" {
+		stages |= FunnelIdentified
+	}
+	if p.MetadataObtained {
+		stages |= FunnelMetadata
+	}
+	return stages
+}
+
+// updateFunnel counts the stages that the peer reached for the first time in the counters of
+// its store, so each transition is counted once no matter how many times the event repeats
+// (needs the lock).
+func (p *Peer) updateFunnel() {
+	reached := p.funnelStages() &^ p.funnelReached
+	if reached == 0 {
+		return
+	}
+	p.funnelReached |= reached
+	if p.funnel != nil {
+		p.funnel.add(reached, 1)
+	}
+}
+
+// GetFunnel returns the number of peers of the store in each stage of the crawl funnel.
+func (s *PeerStore) GetFunnel() Funnel {
+	return s.funnel.snapshot()
+}
+
+// FunnelHandler serves the crawl funnel of the store as JSON.
+func (s *PeerStore) FunnelHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		err := json.NewEncoder(w).Encode(s.GetFunnel())
+		if err != nil {
+			log.Debug(errors.Wrap(err, "unable to write funnel"))
+		}
+	})
+}
+
+// leaveFunnel discounts the stages of the peer from the counters of its store, once it's
+// removed from it.
+func (p *Peer) leaveFunnel() {
+	p.m.Lock()
+	defer p.m.Unlock()
+	if p.funnel != nil {
+		p.funnel.add(p.funnelReached, -1)
+		p.funnel = nil
+	}
+	p.funnelReached = 0
+}
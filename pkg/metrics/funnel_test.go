@@ -0,0 +1,98 @@
+package metrics
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/migalabs/armiarma/pkg/db/models"
+	"github.com/migalabs/armiarma/pkg/utils"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_FunnelTransitions(t *testing.T) {
+	store := NewPeerStore()
+	t0 := time.Unix(1000, 0)
+	pid := testPeerID("funnel-peer")
+
+	// discovered, several times
+	p := store.GetOrCreatePeer(pid)
+	store.GetOrCreatePeer(pid)
+	require.Equal(t, Funnel{Discovered: 1}, store.GetFunnel())
+
+	// attempted, twice
+	p.ConnectionAttemptEvent(false, "timeout")
+	p.ConnectionAttemptEvent(false, "timeout")
+	require.Equal(t, Funnel{Discovered: 1, Attempted: 1}, store.GetFunnel())
+
+	// connected, twice
+	p.ConnectionEvent(t0)
+	p.DisconnectionEvent(t0.Add(time.Minute))
+	p.ConnectionEvent(t0.Add(2 * time.Minute))
+	require.Equal(t, Funnel{Discovered: 1, Attempted: 1, Connected: 1}, store.GetFunnel())
+
+	// identified, and then the metadata, with the identification repeated
+	hInfo := models.NewHostInfo(pid, utils.EthereumNetwork)
+	hInfo.IdentifyHost(models.NewPeerInfo(pid, "Lighthouse/v3.5.1-319cc61/x86_64-linux", "", nil, 0))
+	p.FetchHostInfo(hInfo)
+	require.Equal(t, Funnel{Discovered: 1, Attempted: 1, Connected: 1, Identified: 1}, store.GetFunnel())
+	hInfo.AddAtt(models.MetadataAttribute, struct{}{})
+	p.FetchHostInfo(hInfo)
+	p.FetchHostInfo(hInfo)
+	expected := Funnel{Discovered: 1, Attempted: 1, Connected: 1, Identified: 1, Metadata: 1}
+	require.Equal(t, expected, store.GetFunnel())
+
+	// a second peer connected from outside, without our attempt
+	inbound := store.GetOrCreatePeer(testPeerID("funnel-inbound"))
+	inbound.ConnectionEvent(t0)
+	require.Equal(t, Funnel{Discovered: 2, Attempted: 1, Connected: 2, Identified: 1, Metadata: 1}, store.GetFunnel())
+
+	// the copies don't count
+	cp := p.Copy()
+	cp.ConnectionAttemptEvent(true, "")
+	require.Equal(t, int64(1), store.GetFunnel().Attempted)
+}
+
+func Test_FunnelEvictionAndRestore(t *testing.T) {
+	store := NewPeerStore()
+	now := time.Unix(100000, 0)
+	p := store.GetOrCreatePeer(testPeerID("funnel-evicted"))
+	p.ConnectionAttemptEvent(false, "timeout")
+	p.DeprecationEvent()
+	require.Equal(t, Funnel{Discovered: 1, Attempted: 1}, store.GetFunnel())
+
+	var buf bytes.Buffer
+	require.NoError(t, store.Checkpoint(&buf))
+
+	// the evicted peers leave the funnel
+	require.Equal(t, 1, store.EvictPeers(EvictionPolicy{}, now))
+	require.Equal(t, Funnel{}, store.GetFunnel())
+	// and an evicted peer doesn't touch it anymore
+	p.ConnectionEvent(now)
+	require.Equal(t, Funnel{}, store.GetFunnel())
+
+	// the restored peers count as soon as they are merged, once
+	require.NoError(t, store.RestoreFrom(bytes.NewReader(buf.Bytes())))
+	require.Equal(t, Funnel{Discovered: 1, Attempted: 1}, store.GetFunnel())
+	require.NoError(t, store.RestoreFrom(bytes.NewReader(buf.Bytes())))
+	require.Equal(t, Funnel{Discovered: 1, Attempted: 1}, store.GetFunnel())
+}
+
+func Test_FunnelHandler(t *testing.T) {
+	store := NewPeerStore()
+	store.GetOrCreatePeer(testPeerID("funnel-served")).ConnectionAttemptEvent(false, "timeout")
+
+	rec := httptest.NewRecorder()
+	store.FunnelHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, FunnelEndpoint, nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	var funnel Funnel
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &funnel))
+	require.Equal(t, Funnel{Discovered: 1, Attempted: 1}, funnel)
+
+	rec = httptest.NewRecorder()
+	store.FunnelHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, FunnelEndpoint, nil))
+	require.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
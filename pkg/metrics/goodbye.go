@@ -0,0 +1,87 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/migalabs/armiarma/pkg/db/models"
+	"github.com/migalabs/armiarma/pkg/utils"
+)
+
+// GoodbyeDisconnectionWindow is the time between a Goodbye and the following disconnection
+// for the disconnection to be attributed to the reason of the Goodbye.
+var GoodbyeDisconnectionWindow = 10 * time.Second
+
+// GoodbyeEvent tracks a Goodbye received from the peer.
+func (p *Peer) GoodbyeEvent(goodbye models.Goodbye) {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	if p.Goodbyes == nil {
+		p.Goodbyes = make(map[string]int64)
+	}
+	p.Goodbyes[goodbye.Reason()]++
+	p.LastGoodbye = goodbye.Reason()
+	p.LastGoodbyeTime = goodbye.Timestamp
+	p.goodbyePending = true
+}
+
+// disconnectReason returns the reason of the pending Goodbye if it was received within the
+// GoodbyeDisconnectionWindow of the disconnection at t, consuming it (needs the lock).
+func (p *Peer) disconnectReason(t time.Time) string {
+	if !p.goodbyePending {
+		return ""
+	}
+	p.goodbyePending = false
+	gap := t.Sub(p.LastGoodbyeTime)
+	if gap > GoodbyeDisconnectionWindow || gap < -GoodbyeDisconnectionWindow {
+		return ""
+	}
+	p.LastDisconnectReason = p.LastGoodbye
+	return p.LastGoodbye
+}
+
+// GetGoodbyes returns the number of Goodbyes received from the peer per reason.
+func (p *Peer) GetGoodbyes() map[string]int64 {
+	p.m.RLock()
+	defer p.m.RUnlock()
+
+	goodbyes := make(map[string]int64, len(p.Goodbyes))
+	for reason, count := range p.Goodbyes {
+		goodbyes[reason] = count
+	}
+	return goodbyes
+}
+
+// GetLastGoodbye returns the reason and the time of the last Goodbye received from the peer.
+func (p *Peer) GetLastGoodbye() (string, time.Time) {
+	p.m.RLock()
+	defer p.m.RUnlock()
+	return p.LastGoodbye, p.LastGoodbyeTime
+}
+
+// GoodbyeReasonsByClient returns the number of Goodbyes received per client name and reason.
+// The Goodbyes of the peers that weren't identified are counted under the unknown client.
+func (s *PeerStore) GoodbyeReasonsByClient() map[string]map[string]int64 {
+	reasons := make(map[string]map[string]int64)
+	s.ForEachPeer(func(p *Peer) bool {
+		p.m.RLock()
+		defer p.m.RUnlock()
+		if len(p.Goodbyes) == 0 {
+			return true
+		}
+		cliName := p.ClientName
+		if cliName == "" {
+			cliName = utils.Unknown
+		}
+		cliReasons, ok := reasons[cliName]
+		if !ok {
+			cliReasons = make(map[string]int64)
+			reasons[cliName] = cliReasons
+		}
+		for reason, count := range p.Goodbyes {
+			cliReasons[reason] += count
+		}
+		return true
+	})
+	return reasons
+}
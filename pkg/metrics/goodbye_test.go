@@ -0,0 +1,96 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/migalabs/armiarma/pkg/db/models"
+	"github.com/stretchr/testify/require"
+)
+
+func testGoodbye(p *Peer, code uint64, t time.Time) models.Goodbye {
+	goodbye := models.NewGoodbye(p.ID, code)
+	goodbye.Timestamp = t
+	return goodbye
+}
+
+func Test_PeerGoodbyes(t *testing.T) {
+	p := NewPeer(testPeerID("goodbye-peer"))
+	t0 := time.Unix(1000, 0)
+
+	// the standard reasons plus an unknown code
+	for i, code := range []uint64{1, 2, 3, 129, 129, 42} {
+		p.GoodbyeEvent(testGoodbye(p, code, t0.Add(time.Duration(i)*time.Minute)))
+	}
+	require.Equal(t, map[string]int64{
+		"client_shutdown":    1,
+		"irrelevant_network": 1,
+		"fault_error":        1,
+		"too_many_peers":     2,
+		"unknown_42":         1,
+	}, p.GetGoodbyes())
+	reason, at := p.GetLastGoodbye()
+	require.Equal(t, "unknown_42", reason)
+	require.Equal(t, t0.Add(5*time.Minute), at)
+}
+
+func Test_DisconnectionAfterGoodbye(t *testing.T) {
+	p := NewPeer(testPeerID("goodbye-disconnection"))
+	t0 := time.Unix(1000, 0)
+
+	// a disconnection right after the goodbye carries its reason
+	p.ConnectionEvent(t0)
+	p.GoodbyeEvent(testGoodbye(p, uint64(models.GoodbyeTooManyPeers), t0.Add(time.Minute)))
+	require.Equal(t, "too_many_peers", p.DisconnectionEvent(t0.Add(time.Minute+time.Second)))
+	require.Equal(t, "too_many_peers", p.LastDisconnectReason)
+
+	// the goodbye is consumed by the first disconnection
+	p.ConnectionEvent(t0.Add(2 * time.Minute))
+	require.Equal(t, "", p.DisconnectionEvent(t0.Add(3*time.Minute)))
+
+	// a disconnection long after the goodbye isn't attributed to it
+	p.ConnectionEvent(t0.Add(4 * time.Minute))
+	p.GoodbyeEvent(testGoodbye(p, uint64(models.GoodbyeClientShutdown), t0.Add(4*time.Minute)))
+	require.Equal(t, "", p.DisconnectionEvent(t0.Add(4*time.Minute).Add(GoodbyeDisconnectionWindow+time.Second)))
+	require.Equal(t, "too_many_peers", p.LastDisconnectReason)
+}
+
+func Test_PeerMergeGoodbyes(t *testing.T) {
+	t0 := time.Unix(1000, 0)
+	p1 := NewPeer(testPeerID("goodbye-merge"))
+	p1.GoodbyeEvent(testGoodbye(p1, 1, t0))
+	p2 := NewPeer(testPeerID("goodbye-merge"))
+	p2.GoodbyeEvent(testGoodbye(p2, 1, t0.Add(time.Hour)))
+	p2.GoodbyeEvent(testGoodbye(p2, 3, t0.Add(2*time.Hour)))
+
+	cp := p1.Copy()
+	p1.Merge(p2)
+	require.Equal(t, map[string]int64{"client_shutdown": 2, "fault_error": 1}, p1.GetGoodbyes())
+	reason, at := p1.GetLastGoodbye()
+	require.Equal(t, "fault_error", reason)
+	require.Equal(t, t0.Add(2*time.Hour), at)
+	require.Equal(t, map[string]int64{"client_shutdown": 1}, cp.GetGoodbyes())
+}
+
+func Test_GoodbyeReasonsByClient(t *testing.T) {
+	store := NewPeerStore()
+	t0 := time.Unix(1000, 0)
+
+	lh1 := store.GetOrCreatePeer(testPeerID("goodbye-lh1"))
+	lh1.ClientName = "lighthouse"
+	lh1.GoodbyeEvent(testGoodbye(lh1, 129, t0))
+	lh2 := store.GetOrCreatePeer(testPeerID("goodbye-lh2"))
+	lh2.ClientName = "lighthouse"
+	lh2.GoodbyeEvent(testGoodbye(lh2, 129, t0))
+	lh2.GoodbyeEvent(testGoodbye(lh2, 2, t0))
+	unidentified := store.GetOrCreatePeer(testPeerID("goodbye-unknown"))
+	unidentified.GoodbyeEvent(testGoodbye(unidentified, 200, t0))
+	// peers without goodbyes don't show up
+	prysm := store.GetOrCreatePeer(testPeerID("goodbye-prysm"))
+	prysm.ClientName = "prysm"
+
+	require.Equal(t, map[string]map[string]int64{
+		"lighthouse": {"too_many_peers": 2, "irrelevant_network": 1},
+		"unknown":    {"unknown_200": 1},
+	}, store.GoodbyeReasonsByClient())
+}
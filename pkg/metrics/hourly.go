@@ -0,0 +1,192 @@
+package metrics
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// HourlyMessagesFile is the default name of the hourly messages export
+	HourlyMessagesFile = "hourly_messages.csv"
+)
+
+// HourlyRetention is the number of hourly buckets kept per topic (14 days). The buckets older than
+// the retention (relative to the newest bucket of the topic) are evicted, so the memory of each
+// MessageMetric stays bounded over arbitrarily long crawls.
+var HourlyRetention = 14 * 24
+
+// HourBucket is the number of messages received within the hour that starts at Hour.
+type HourBucket struct {
+	Hour  time.Time `json:"hour"`
+	Count int64     `json:"count"`
+}
+
+// addHourly counts a message received at t in its hourly bucket, evicting the expired buckets.
+// The buckets are sorted by hour, and late messages are counted in their (past) bucket
+// unless it was already evicted.
+func addHourly(buckets []HourBucket, t time.Time) []HourBucket {
+	if t.IsZero() {
+		return buckets
+	}
+	hour := t.UTC().Truncate(time.Hour)
+	idx := sort.Search(len(buckets), func(i int) bool {
+		return !buckets[i].Hour.Before(hour)
+	})
+	switch {
+	case idx < len(buckets) && buckets[idx].Hour.Equal(hour):
+		buckets[idx].Count++
+		return buckets
+	case idx == 0 && len(buckets) > 0 && isExpiredHour(hour, buckets[len(buckets)-1].Hour):
+		return buckets
+	}
+	buckets = append(buckets, HourBucket{})
+	copy(buckets[idx+1:], buckets[idx:])
+	buckets[idx] = HourBucket{Hour: hour, Count: 1}
+	return evictHourly(buckets)
+}
+
+// isExpiredHour returns whether the bucket of the hour is out of the retention from the newest one.
+func isExpiredHour(hour, newest time.Time) bool {
+	return !hour.After(newest.Add(-time.Duration(HourlyRetention) * time.Hour))
+}
+
+// evictHourly drops the buckets out of the retention (reusing the slice once it grows twice the retention).
+func evictHourly(buckets []HourBucket) []HourBucket {
+	if len(buckets) == 0 {
+		return buckets
+	}
+	newest := buckets[len(buckets)-1].Hour
+	expired := 0
+	for expired < len(buckets) && isExpiredHour(buckets[expired].Hour, newest) {
+		expired++
+	}
+	if expired == 0 {
+		return buckets
+	}
+	if cap(buckets) > 2*HourlyRetention {
+		return append(make([]HourBucket, 0, HourlyRetention+1), buckets[expired:]...)
+	}
+	return append(buckets[:0], buckets[expired:]...)
+}
+
+// mergeHourly adds up the buckets of both lists, keeping the result within the retention.
+func mergeHourly(a, b []HourBucket) []HourBucket {
+	if len(b) == 0 {
+		return a
+	}
+	counts := make(map[time.Time]int64, len(a)+len(b))
+	for _, bucket := range a {
+		counts[bucket.Hour] += bucket.Count
+	}
+	for _, bucket := range b {
+		counts[bucket.Hour] += bucket.Count
+	}
+	return evictHourly(sortedBuckets(counts))
+}
+
+// sortedBuckets returns the counts per hour as buckets sorted by hour.
+func sortedBuckets(counts map[time.Time]int64) []HourBucket {
+	buckets := make([]HourBucket, 0, len(counts))
+	for hour, count := range counts {
+		buckets = append(buckets, HourBucket{Hour: hour, Count: count})
+	}
+	sort.Slice(buckets, func(i, j int) bool {
+		return buckets[i].Hour.Before(buckets[j].Hour)
+	})
+	return buckets
+}
+
+// GetHourlyCounts returns the messages received from the peer per hour on the given topic,
+// which can be a short topic name or the family of the subnet topics (as in GetNumOfMsgFromTopic).
+func (p *Peer) GetHourlyCounts(shortTopic string) []HourBucket {
+	p.m.RLock()
+	defer p.m.RUnlock()
+
+	counts := make(map[time.Time]int64)
+	for topic, msgMetric := range p.MessageMetrics {
+		family, _ := topicFamily(topic)
+		if family != shortTopic && shortTopicName(topic) != shortTopic {
+			continue
+		}
+		for _, bucket := range msgMetric.HourlyCounts {
+			counts[bucket.Hour] += bucket.Count
+		}
+	}
+	return sortedBuckets(counts)
+}
+
+// HourlyCounts returns the messages received from all the peers per hour and short topic name.
+func (s *PeerStore) HourlyCounts() map[string][]HourBucket {
+	counts := make(map[string]map[time.Time]int64)
+	s.ForEachPeer(func(p *Peer) bool {
+		p.m.RLock()
+		defer p.m.RUnlock()
+		for topic, msgMetric := range p.MessageMetrics {
+			if len(msgMetric.HourlyCounts) == 0 {
+				continue
+			}
+			short := shortTopicName(topic)
+			topicCounts, ok := counts[short]
+			if !ok {
+				topicCounts = make(map[time.Time]int64)
+				counts[short] = topicCounts
+			}
+			for _, bucket := range msgMetric.HourlyCounts {
+				topicCounts[bucket.Hour] += bucket.Count
+			}
+		}
+		return true
+	})
+	hourly := make(map[string][]HourBucket, len(counts))
+	for topic, topicCounts := range counts {
+		hourly[topic] = sortedBuckets(topicCounts)
+	}
+	return hourly
+}
+
+// ExportHourlyCountsCsv writes into w the hourly message counts of every topic in long format,
+// one row per topic and hour (sorted by topic and hour).
+func (s *PeerStore) ExportHourlyCountsCsv(w io.Writer) error {
+	hourly := s.HourlyCounts()
+	topics := make([]string, 0, len(hourly))
+	for topic := range hourly {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+
+	csvW := csv.NewWriter(w)
+	err := csvW.Write([]string{"hour", "topic", "count"})
+	if err != nil {
+		return errors.Wrap(err, "unable to write csv header")
+	}
+	for _, topic := range topics {
+		for _, bucket := range hourly[topic] {
+			err = csvW.Write([]string{
+				bucket.Hour.Format(time.RFC3339),
+				topic,
+				fmt.Sprintf("%d", bucket.Count),
+			})
+			if err != nil {
+				return errors.Wrap(err, "unable to write csv row")
+			}
+		}
+	}
+	csvW.Flush()
+	return csvW.Error()
+}
+
+// ExportHourlyCountsFile exports the hourly message counts into the CSV file at the given path (overwriting it).
+func (s *PeerStore) ExportHourlyCountsFile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return errors.Wrap(err, "unable to create hourly messages file")
+	}
+	defer f.Close()
+	return s.ExportHourlyCountsCsv(f)
+}
@@ -0,0 +1,113 @@
+package metrics
+
+import (
+	"bytes"
+	"encoding/csv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_PeerHourlyCounts(t *testing.T) {
+	p := NewPeer(testPeerID("hourly-peer"))
+	clock := time.Date(2023, 4, 1, 10, 15, 0, 0, time.UTC)
+
+	// 3 messages in the first hour, 1 in the next one, and 2 a day later
+	for i := 0; i < 3; i++ {
+		p.MessageEvent(testBlockTopic, clock)
+		clock = clock.Add(10 * time.Minute)
+	}
+	clock = clock.Add(time.Hour)
+	p.MessageEvent(testBlockTopic, clock)
+	clock = clock.Add(24 * time.Hour)
+	p.MessageEvent(testBlockTopic, clock)
+	p.MessageEvent(testBlockTopic, clock.Add(time.Minute))
+	// a late message is counted in its hour
+	p.MessageEvent(testBlockTopic, time.Date(2023, 4, 1, 10, 59, 0, 0, time.UTC))
+
+	require.Equal(t, []HourBucket{
+		{Hour: time.Date(2023, 4, 1, 10, 0, 0, 0, time.UTC), Count: 4},
+		{Hour: time.Date(2023, 4, 1, 11, 0, 0, 0, time.UTC), Count: 1},
+		{Hour: time.Date(2023, 4, 2, 11, 0, 0, 0, time.UTC), Count: 2},
+	}, p.GetHourlyCounts("beacon_block"))
+	require.Equal(t, 0, len(p.GetHourlyCounts("voluntary_exit")))
+
+	// the subnet topics aggregate under their family
+	p.MessageEvent(testAttSubnet17Topic, clock)
+	p.MessageEvent(testAttTopic, clock)
+	require.Equal(t, []HourBucket{
+		{Hour: time.Date(2023, 4, 2, 11, 0, 0, 0, time.UTC), Count: 2},
+	}, p.GetHourlyCounts(AttestationTopicName))
+	require.Equal(t, int64(1), p.GetHourlyCounts("beacon_attestation_17")[0].Count)
+}
+
+func Test_HourlyCountsRetention(t *testing.T) {
+	p := NewPeer(testPeerID("hourly-retention"))
+	start := time.Date(2023, 4, 1, 0, 30, 0, 0, time.UTC)
+
+	// a message every hour for 30 days keeps only the last HourlyRetention hours
+	clock := start
+	for i := 0; i < 30*24; i++ {
+		p.MessageEvent(testBlockTopic, clock)
+		clock = clock.Add(time.Hour)
+	}
+	buckets := p.GetHourlyCounts("beacon_block")
+	require.Equal(t, HourlyRetention, len(buckets))
+	newest := clock.Add(-time.Hour).Truncate(time.Hour)
+	require.Equal(t, newest, buckets[len(buckets)-1].Hour)
+	require.Equal(t, newest.Add(-time.Duration(HourlyRetention-1)*time.Hour), buckets[0].Hour)
+	require.True(t, cap(p.MessageMetrics[testBlockTopic].HourlyCounts) <= 2*HourlyRetention)
+
+	// messages older than the retention are not counted anymore
+	p.MessageEvent(testBlockTopic, start)
+	require.Equal(t, buckets, p.GetHourlyCounts("beacon_block"))
+	// but the total count is kept
+	require.Equal(t, int64(30*24+1), p.GetNumOfMsgFromTopic("beacon_block"))
+
+	// a jump of several days evicts all the previous buckets
+	p.MessageEvent(testBlockTopic, clock.Add(20*24*time.Hour))
+	require.Equal(t, 1, len(p.GetHourlyCounts("beacon_block")))
+}
+
+func Test_HourlyCountsMerge(t *testing.T) {
+	hour := time.Date(2023, 4, 1, 10, 0, 0, 0, time.UTC)
+	p1 := NewPeer(testPeerID("hourly-merge"))
+	p1.MessageEvent(testBlockTopic, hour)
+	p1.MessageEvent(testBlockTopic, hour.Add(time.Hour))
+	p2 := NewPeer(testPeerID("hourly-merge"))
+	p2.MessageEvent(testBlockTopic, hour.Add(time.Hour))
+	p2.MessageEvent(testBlockTopic, hour.Add(2*time.Hour))
+
+	cp := p1.Copy()
+	p1.Merge(p2)
+	require.Equal(t, []HourBucket{
+		{Hour: hour, Count: 1},
+		{Hour: hour.Add(time.Hour), Count: 2},
+		{Hour: hour.Add(2 * time.Hour), Count: 1},
+	}, p1.GetHourlyCounts("beacon_block"))
+	// the copy is not affected by the merge
+	require.Equal(t, 2, len(cp.GetHourlyCounts("beacon_block")))
+}
+
+func Test_ExportHourlyCountsCsv(t *testing.T) {
+	store := NewPeerStore()
+	hour := time.Date(2023, 4, 1, 10, 0, 0, 0, time.UTC)
+	p1 := store.GetOrCreatePeer(testPeerID("hourly-csv1"))
+	p1.MessageEvent(testBlockTopic, hour.Add(time.Minute))
+	p1.MessageEvent(testBlockTopic, hour.Add(time.Hour))
+	p1.MessageEvent(testAttTopic, hour)
+	p2 := store.GetOrCreatePeer(testPeerID("hourly-csv2"))
+	p2.MessageEvent(testBlockTopic, hour.Add(30*time.Minute))
+
+	var buf bytes.Buffer
+	require.NoError(t, store.ExportHourlyCountsCsv(&buf))
+	rows, err := csv.NewReader(&buf).ReadAll()
+	require.NoError(t, err)
+	require.Equal(t, [][]string{
+		{"hour", "topic", "count"},
+		{"2023-04-01T10:00:00Z", "beacon_attestation_3", "1"},
+		{"2023-04-01T10:00:00Z", "beacon_block", "2"},
+		{"2023-04-01T11:00:00Z", "beacon_block", "1"},
+	}, rows)
+}
@@ -0,0 +1,123 @@
+package metrics
+
+import (
+	"math"
+	"time"
+)
+
+// GapStats accumulates the gaps (in milliseconds) between consecutive messages of a topic,
+// using Welford's online algorithm so that the mean and the variance need no samples.
+type GapStats struct {
+	Count  int64   `json:"count"`
+	MeanMs float64 `json:"mean_ms"`
+	// sum of the squared differences from the mean
+	M2    float64 `json:"m2"`
+	MinMs float64 `json:"min_ms"`
+	MaxMs float64 `json:"max_ms"`
+	// messages that arrived with a time before the previous one, left out of the stats
+	NegativeGaps int64 `json:"negative_gaps,omitempty"`
+}
+
+// Add accumulates a new gap, counting the negative ones (clock anomalies) apart.
+func (g *GapStats) Add(gap time.Duration) {
+	if gap < 0 {
+		g.NegativeGaps++
+		return
+	}
+	ms := float64(gap) / float64(time.Millisecond)
+	if g.Count == 0 || ms < g.MinMs {
+		g.MinMs = ms
+	}
+	if g.Count == 0 || ms > g.MaxMs {
+		g.MaxMs = ms
+	}
+	g.Count++
+	delta := ms - g.MeanMs
+	g.MeanMs += delta / float64(g.Count)
+	g.M2 += delta * (ms - g.MeanMs)
+}
+
+// merge aggregates the gaps of other into g (Chan's parallel variant of Welford's algorithm).
+func (g *GapStats) merge(other *GapStats) {
+	if other == nil {
+		return
+	}
+	g.NegativeGaps += other.NegativeGaps
+	if other.Count == 0 {
+		return
+	}
+	if g.Count == 0 {
+		negativeGaps := g.NegativeGaps
+		*g = *other
+		g.NegativeGaps = negativeGaps
+		return
+	}
+	if other.MinMs < g.MinMs {
+		g.MinMs = other.MinMs
+	}
+	if other.MaxMs > g.MaxMs {
+		g.MaxMs = other.MaxMs
+	}
+	count := g.Count + other.Count
+	delta := other.MeanMs - g.MeanMs
+	g.MeanMs += delta * float64(other.Count) / float64(count)
+	g.M2 += other.M2 + delta*delta*float64(g.Count)*float64(other.Count)/float64(count)
+	g.Count = count
+}
+
+func (g *GapStats) copy() *GapStats {
+	if g == nil {
+		return nil
+	}
+	cp := *g
+	return &cp
+}
+
+// InterArrivalStats is the summary of the gaps between consecutive messages of a topic.
+type InterArrivalStats struct {
+	Count        int64
+	MeanMs       float64
+	VarianceMs   float64 // sample variance, in squared milliseconds
+	StdDevMs     float64
+	MinMs        float64
+	MaxMs        float64
+	NegativeGaps int64
+}
+
+// stats summarizes the accumulated gaps.
+func (g *GapStats) stats() InterArrivalStats {
+	if g == nil {
+		return InterArrivalStats{}
+	}
+	stats := InterArrivalStats{
+		Count:        g.Count,
+		MeanMs:       g.MeanMs,
+		MinMs:        g.MinMs,
+		MaxMs:        g.MaxMs,
+		NegativeGaps: g.NegativeGaps,
+	}
+	if g.Count > 1 {
+		stats.VarianceMs = g.M2 / float64(g.Count-1)
+		stats.StdDevMs = math.Sqrt(stats.VarianceMs)
+	}
+	return stats
+}
+
+// GetInterArrivalStats returns the stats of the gaps between consecutive messages received from the peer
+// on the topics with the given short name (i.e. "beacon_block").
+func (p *Peer) GetInterArrivalStats(shortTopic string) InterArrivalStats {
+	p.m.RLock()
+	defer p.m.RUnlock()
+	return p.interArrivalStats(shortTopic)
+}
+
+// interArrivalStats aggregates the gaps of the matching topics (needs the lock).
+func (p *Peer) interArrivalStats(shortTopic string) InterArrivalStats {
+	agg := &GapStats{}
+	for topic, msgMetric := range p.MessageMetrics {
+		if shortTopicName(topic) == shortTopic {
+			agg.merge(msgMetric.InterArrival)
+		}
+	}
+	return agg.stats()
+}
@@ -0,0 +1,92 @@
+package metrics
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_InterArrivalStats(t *testing.T) {
+	t0 := time.Unix(1606824023, 0)
+	p := NewPeer(testPeerID("gaps"))
+
+	// gaps of 1s, 2s, 3s and 4s
+	for _, secs := range []int{0, 1, 3, 6, 10} {
+		p.MessageEvent(testBlockTopic, t0.Add(time.Duration(secs)*time.Second))
+	}
+	stats := p.GetInterArrivalStats("beacon_block")
+	require.Equal(t, int64(4), stats.Count)
+	require.Equal(t, 2500.0, stats.MeanMs)
+	require.InDelta(t, 5e6/3, stats.VarianceMs, 1e-6)
+	require.InDelta(t, 1290.994, stats.StdDevMs, 1e-3)
+	require.Equal(t, 1000.0, stats.MinMs)
+	require.Equal(t, 4000.0, stats.MaxMs)
+	require.Equal(t, int64(0), stats.NegativeGaps)
+
+	// the first message of a topic has no gap
+	p.MessageEvent(testAttTopic, t0)
+	require.Equal(t, int64(0), p.GetInterArrivalStats("beacon_attestation_3").Count)
+	require.Equal(t, InterArrivalStats{}, p.GetInterArrivalStats("voluntary_exit"))
+
+	// a message from the past is counted apart, and the next gap is taken from the latest message
+	p.MessageEvent(testBlockTopic, t0.Add(5*time.Second))
+	p.MessageEvent(testBlockTopic, t0.Add(12*time.Second))
+	stats = p.GetInterArrivalStats("beacon_block")
+	require.Equal(t, int64(5), stats.Count)
+	require.Equal(t, int64(1), stats.NegativeGaps)
+	require.Equal(t, 2400.0, stats.MeanMs)
+	require.Equal(t, t0.Add(12*time.Second), p.MessageMetrics[testBlockTopic].LastMessageTime)
+	require.Equal(t, int64(7), p.MessageMetrics[testBlockTopic].Count)
+
+	// the gaps survive copies and checkpoints
+	require.Equal(t, stats, p.Copy().GetInterArrivalStats("beacon_block"))
+	data, err := json.Marshal(p)
+	require.NoError(t, err)
+	restored := NewPeer("")
+	require.NoError(t, json.Unmarshal(data, restored))
+	require.Equal(t, stats, restored.GetInterArrivalStats("beacon_block"))
+}
+
+func Test_MergeInterArrivalStats(t *testing.T) {
+	t0 := time.Unix(1606824023, 0)
+	p1 := NewPeer(testPeerID("gaps"))
+	p2 := NewPeer(testPeerID("gaps"))
+	// gaps of 1s and 2s in one record, 3s and 4s in the other
+	for _, secs := range []int{0, 1, 3} {
+		p1.MessageEvent(testBlockTopic, t0.Add(time.Duration(secs)*time.Second))
+	}
+	for _, secs := range []int{100, 103, 107} {
+		p2.MessageEvent(testBlockTopic, t0.Add(time.Duration(secs)*time.Second))
+	}
+	p2.MessageEvent(testBlockTopic, t0)
+
+	p1.Merge(p2)
+	stats := p1.GetInterArrivalStats("beacon_block")
+	require.Equal(t, int64(4), stats.Count)
+	require.InDelta(t, 2500.0, stats.MeanMs, 1e-9)
+	require.InDelta(t, 5e6/3, stats.VarianceMs, 1e-6)
+	require.Equal(t, 1000.0, stats.MinMs)
+	require.Equal(t, 4000.0, stats.MaxMs)
+	require.Equal(t, int64(1), stats.NegativeGaps)
+
+	// merging into a topic without gaps keeps the anomalies of both
+	empty := &GapStats{NegativeGaps: 2}
+	empty.merge(p2.MessageMetrics[testBlockTopic].InterArrival)
+	require.Equal(t, int64(3), empty.NegativeGaps)
+	require.Equal(t, int64(2), empty.Count)
+	require.Equal(t, 3500.0, empty.MeanMs)
+}
+
+func Test_CsvBlockMeanGap(t *testing.T) {
+	t0 := time.Unix(1606824023, 0)
+	p := NewPeer(testPeerID("gaps"))
+	gapIdx := csvColumn(t, "block_mean_gap_ms")
+
+	p.MessageEvent(testBlockTopic, t0)
+	require.Equal(t, "", p.csvRecord(DefaultQualityWeights, t0)[gapIdx])
+	p.MessageEvent(testBlockTopic, t0.Add(12*time.Second))
+	p.MessageEvent(testBlockTopic, t0.Add(36*time.Second))
+	require.Equal(t, "18000", p.csvRecord(DefaultQualityWeights, t0)[gapIdx])
+}
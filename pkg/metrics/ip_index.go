@@ -0,0 +1,72 @@
+package metrics
+
+import (
+	"sync"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// peerIPIndex keeps the peers of a store by their IP, so that locating an IP only visits the
+// peers on it (see PeerStore.LocatePeers) instead of the whole store.
+type peerIPIndex struct {
+	m     sync.RWMutex
+	peers map[string]map[peer.ID]struct{}
+}
+
+func newPeerIPIndex() *peerIPIndex {
+	return &peerIPIndex{
+		peers: make(map[string]map[peer.ID]struct{}),
+	}
+}
+
+// move re-indexes the peer from its previous IP to the new one (empty for none).
+func (idx *peerIPIndex) move(pid peer.ID, from, to string) {
+	idx.m.Lock()
+	defer idx.m.Unlock()
+	if pids, ok := idx.peers[from]; ok && from != "" {
+		delete(pids, pid)
+		if len(pids) == 0 {
+			delete(idx.peers, from)
+		}
+	}
+	if to == "" {
+		return
+	}
+	pids, ok := idx.peers[to]
+	if !ok {
+		pids = make(map[peer.ID]struct{})
+		idx.peers[to] = pids
+	}
+	pids[pid] = struct{}{}
+}
+
+// lookup returns the peers indexed on the IP.
+func (idx *peerIPIndex) lookup(ip string) []peer.ID {
+	idx.m.RLock()
+	defer idx.m.RUnlock()
+	pids := make([]peer.ID, 0, len(idx.peers[ip]))
+	for pid := range idx.peers[ip] {
+		pids = append(pids, pid)
+	}
+	return pids
+}
+
+// updateIPIndex re-indexes the peer in the index of its store if its IP changed (needs the lock).
+func (p *Peer) updateIPIndex() {
+	if p.ipIndex == nil || p.indexedIp == p.Ip {
+		return
+	}
+	p.ipIndex.move(p.ID, p.indexedIp, p.Ip)
+	p.indexedIp = p.Ip
+}
+
+// leaveIPIndex drops the peer from the index of its store, once it's removed from it.
+func (p *Peer) leaveIPIndex() {
+	p.m.Lock()
+	defer p.m.Unlock()
+	if p.ipIndex != nil {
+		p.ipIndex.move(p.ID, p.indexedIp, "")
+		p.ipIndex = nil
+		p.indexedIp = ""
+	}
+}
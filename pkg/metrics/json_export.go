@@ -0,0 +1,95 @@
+package metrics
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// JsonExportFormat is the layout of the JSON export of the peer store.
+type JsonExportFormat string
+
+const (
+	// JsonArrayFormat writes the peers as a single JSON array.
+	JsonArrayFormat JsonExportFormat = "json"
+	// NdjsonFormat writes one JSON peer per line (newline-delimited JSON), so that
+	// it can be streamed into jq or ClickHouse.
+	NdjsonFormat JsonExportFormat = "ndjson"
+)
+
+// ParseJsonExportFormat returns the JsonExportFormat of the given name.
+func ParseJsonExportFormat(format string) (JsonExportFormat, error) {
+	switch JsonExportFormat(format) {
+	case JsonArrayFormat, NdjsonFormat:
+		return JsonExportFormat(format), nil
+	default:
+		return "", fmt.Errorf("unknown json export format %q (expected %q or %q)", format, JsonArrayFormat, NdjsonFormat)
+	}
+}
+
+// ToJSON returns the full JSON serialization of the peer (see MarshalJSON): the per-topic message metrics,
+// the raw connection and disconnection times, and the timestamps in RFC3339.
+func (p *Peer) ToJSON() ([]byte, error) {
+	return json.Marshal(p)
+}
+
+// ExportJson writes every peer of the store into w, with the given format.
+// The derived fields (like PeersOnSameIP) are refreshed, and the sessions repaired, before the export.
+func (s *PeerStore) ExportJson(w io.Writer, format JsonExportFormat) error {
+	if _, err := ParseJsonExportFormat(string(format)); err != nil {
+		return err
+	}
+	s.RefreshPeersOnSameIP()
+	s.RepairSessions(time.Now())
+
+	bw := bufio.NewWriter(w)
+	var err error
+	first := true
+	s.ForEachPeer(func(p *Peer) bool {
+		var data []byte
+		data, err = p.ToJSON()
+		if err != nil {
+			err = errors.Wrap(err, "unable to serialize peer "+p.ID.String())
+			return false
+		}
+		switch {
+		case format == NdjsonFormat:
+			data = append(data, '\n')
+		case first:
+			data = append([]byte("[\n"), data...)
+		default:
+			data = append([]byte(",\n"), data...)
+		}
+		first = false
+		_, err = bw.Write(data)
+		return err == nil
+	})
+	if err != nil {
+		return err
+	}
+	if format == JsonArrayFormat {
+		closing := "\n]\n"
+		if first {
+			closing = "[]\n"
+		}
+		if _, err = bw.WriteString(closing); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// ExportJsonFile exports the store into the JSON file at the given path (overwriting it).
+func (s *PeerStore) ExportJsonFile(path string, format JsonExportFormat) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return errors.Wrap(err, "unable to create json export file")
+	}
+	defer f.Close()
+	return s.ExportJson(f, format)
+}
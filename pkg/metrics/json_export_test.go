@@ -0,0 +1,89 @@
+package metrics
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_PeerToJSON(t *testing.T) {
+	t0 := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	// a peer that never answered a status request
+	empty := NewPeer(testPeerID("json-empty"))
+	data, err := empty.ToJSON()
+	require.NoError(t, err)
+	var fields map[string]interface{}
+	require.NoError(t, json.Unmarshal(data, &fields))
+	require.Equal(t, empty.ID.String(), fields["peer_id"])
+	require.NotContains(t, fields, "status_requests")
+	require.NotContains(t, fields, "message_metrics")
+	require.NotContains(t, fields, "error_counts")
+
+	// a peer with its messages and sessions
+	p := NewPeer(testPeerID("json-full"))
+	p.UserAgent = "teku/v22.1.0, extra"
+	p.ConnectionEvent(t0)
+	p.DisconnectionEvent(t0.Add(time.Minute))
+	p.MessageEvent(BeaconBlockTopicName, t0)
+	p.MessageEvent(BeaconBlockTopicName, t0.Add(12*time.Second))
+	p.ConnectionAttemptEvent(false, "io_timeout")
+	p.ConnectionAttemptEvent(false, "connection_refused")
+	p.ConnectionAttemptEvent(false, "io_timeout")
+	data, err = p.ToJSON()
+	require.NoError(t, err)
+	fields = nil
+	require.NoError(t, json.Unmarshal(data, &fields))
+	require.Equal(t, "teku/v22.1.0, extra", fields["user_agent"])
+	require.Equal(t, []interface{}{"2022-06-01T12:00:00Z"}, fields["connection_times"])
+	require.Equal(t, []interface{}{"2022-06-01T12:01:00Z"}, fields["disconnection_times"])
+	topics := fields["message_metrics"].(map[string]interface{})
+	block := topics[BeaconBlockTopicName].(map[string]interface{})
+	require.Equal(t, float64(2), block["count"])
+	require.Equal(t, "2022-06-01T12:00:00Z", block["first_message_time"])
+	require.Equal(t, "2022-06-01T12:00:12Z", block["last_message_time"])
+	require.Equal(t, map[string]interface{}{"io_timeout": float64(2), "connection_refused": float64(1)}, fields["error_counts"])
+	require.Len(t, fields["last_error_times"], 2)
+
+	// and back
+	restored := NewPeer(p.ID)
+	require.NoError(t, json.Unmarshal(data, restored))
+	require.Equal(t, p.GetErrorCounts(), restored.GetErrorCounts())
+	require.True(t, p.GetLastErrorOf("io_timeout").Equal(restored.GetLastErrorOf("io_timeout")))
+}
+
+func Test_ExportJson(t *testing.T) {
+	store := newTestPeerStore()
+
+	// one peer per line
+	var buf bytes.Buffer
+	require.NoError(t, store.ExportJson(&buf, NdjsonFormat))
+	lines := 0
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		var fields map[string]interface{}
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &fields))
+		require.NotEmpty(t, fields["peer_id"])
+		lines++
+	}
+	require.Equal(t, store.Len(), lines)
+
+	// a single array
+	buf.Reset()
+	require.NoError(t, store.ExportJson(&buf, JsonArrayFormat))
+	var peers []map[string]interface{}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &peers))
+	require.Len(t, peers, store.Len())
+
+	buf.Reset()
+	require.NoError(t, NewPeerStore().ExportJson(&buf, JsonArrayFormat))
+	require.Equal(t, "[]\n", buf.String())
+
+	require.Error(t, store.ExportJson(&buf, JsonExportFormat("xml")))
+	_, err := ParseJsonExportFormat("ndjson")
+	require.NoError(t, err)
+}
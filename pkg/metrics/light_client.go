@@ -0,0 +1,38 @@
+package metrics
+
+const (
+	// topics of the light client updates
+	LightClientFinalityTopicName   = "light_client_finality_update"
+	LightClientOptimisticTopicName = "light_client_optimistic_update"
+)
+
+// isLightClientTopic returns whether the topic carries light client updates.
+func isLightClientTopic(topic string) bool {
+	short := shortTopicName(topic)
+	return short == LightClientFinalityTopicName || short == LightClientOptimisticTopicName
+}
+
+// HasLightClientUpdates returns whether the peer delivered at least one light client update.
+func (p *Peer) HasLightClientUpdates() bool {
+	p.m.RLock()
+	defer p.m.RUnlock()
+
+	for topic, msgMetric := range p.MessageMetrics {
+		if isLightClientTopic(topic) && msgMetric.Count > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// LightClientSenders returns the number of distinct peers that delivered at least one light client update.
+func (s *PeerStore) LightClientSenders() int {
+	senders := 0
+	s.ForEachPeer(func(p *Peer) bool {
+		if p.HasLightClientUpdates() {
+			senders++
+		}
+		return true
+	})
+	return senders
+}
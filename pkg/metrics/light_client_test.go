@@ -0,0 +1,45 @@
+package metrics
+
+import (
+	"bytes"
+	"encoding/csv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testLcFinalityTopic   = "/eth2/bba4da96/light_client_finality_update/ssz_snappy"
+	testLcOptimisticTopic = "/eth2/bba4da96/light_client_optimistic_update/ssz_snappy"
+)
+
+func Test_LightClientSenders(t *testing.T) {
+	store := NewPeerStore()
+	t0 := time.Unix(1000, 0)
+
+	p1 := store.GetOrCreatePeer(testPeerID("lc-peer1"))
+	p1.MessageEvent(testLcFinalityTopic, t0)
+	p1.MessageEvent(testLcOptimisticTopic, t0)
+	p2 := store.GetOrCreatePeer(testPeerID("lc-peer2"))
+	p2.MessageEvent(testLcOptimisticTopic, t0)
+	p3 := store.GetOrCreatePeer(testPeerID("lc-peer3"))
+	p3.MessageEvent(testBlockTopic, t0)
+
+	require.True(t, p1.HasLightClientUpdates())
+	require.False(t, p3.HasLightClientUpdates())
+	require.Equal(t, 2, store.LightClientSenders())
+	require.Equal(t, int64(2), p1.GetNumOfMsgFromTopic(LightClientOptimisticTopicName)+p1.GetNumOfMsgFromTopic(LightClientFinalityTopicName))
+
+	// the light client topics are exported as any other topic
+	var buf bytes.Buffer
+	require.NoError(t, store.ExportTopicMessagesCsv(&buf))
+	records, err := csv.NewReader(&buf).ReadAll()
+	require.NoError(t, err)
+	require.Equal(t, [][]string{
+		{"topic", "subnet", "peers", "messages", "first_deliveries", "duplicates"},
+		{"beacon_block", "", "1", "1", "0", "0"},
+		{"light_client_finality_update", "", "1", "1", "0", "0"},
+		{"light_client_optimistic_update", "", "2", "2", "0", "0"},
+	}, records)
+}
@@ -0,0 +1,115 @@
+package metrics
+
+import (
+	"sort"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// MeshMetric tracks the membership of a peer in our gossipsub mesh of a single topic.
+type MeshMetric struct {
+	InMesh    bool          `json:"-"` // only meaningful while the crawler is running
+	LastGraft time.Time     `json:"last_graft"`
+	Grafts    int64         `json:"grafts"`
+	Prunes    int64         `json:"prunes"`
+	TotalTime time.Duration `json:"total_time"` // time in mesh of the finished memberships
+}
+
+// MeshEvent tracks a GRAFT (joined) or PRUNE (!joined) of the peer in our mesh of the given topic.
+// Like with the connections, each graft is paired with the next prune to calculate the time in mesh.
+func (p *Peer) MeshEvent(topic string, joined bool, t time.Time) {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	meshMetric, ok := p.MeshMetrics[topic]
+	if !ok {
+		meshMetric = &MeshMetric{}
+		p.MeshMetrics[topic] = meshMetric
+	}
+	if joined {
+		meshMetric.Grafts++
+		if meshMetric.InMesh {
+			// keep the time since the first graft
+			return
+		}
+		meshMetric.InMesh = true
+		meshMetric.LastGraft = t
+		return
+	}
+
+	meshMetric.Prunes++
+	if !meshMetric.InMesh {
+		log.Debugf("prune of peer %s on %s without prior graft", p.ID.String(), topic)
+		return
+	}
+	meshMetric.InMesh = false
+	if d := t.Sub(meshMetric.LastGraft); d > 0 {
+		meshMetric.TotalTime += d
+	}
+}
+
+// IsInMesh returns whether the peer is currently in our mesh of the given topic.
+func (p *Peer) IsInMesh(topic string) bool {
+	p.m.RLock()
+	defer p.m.RUnlock()
+
+	meshMetric, ok := p.MeshMetrics[topic]
+	return ok && meshMetric.InMesh
+}
+
+// MeshTopics returns the (sorted) topics in which the peer is currently in our mesh.
+func (p *Peer) MeshTopics() []string {
+	p.m.RLock()
+	defer p.m.RUnlock()
+	return p.meshTopics()
+}
+
+func (p *Peer) meshTopics() []string {
+	topics := make([]string, 0)
+	for topic, meshMetric := range p.MeshMetrics {
+		if meshMetric.InMesh {
+			topics = append(topics, topic)
+		}
+	}
+	sort.Strings(topics)
+	return topics
+}
+
+// MeshTime returns the total time that the peer has been in our mesh of the given topic,
+// with the ongoing membership counting until now.
+func (p *Peer) MeshTime(topic string, now time.Time) time.Duration {
+	p.m.RLock()
+	defer p.m.RUnlock()
+
+	meshMetric, ok := p.MeshMetrics[topic]
+	if !ok {
+		return 0
+	}
+	return meshMetric.meshTime(now)
+}
+
+// TotalMeshTime returns the time in mesh of the peer summed over all the topics.
+func (p *Peer) TotalMeshTime(now time.Time) time.Duration {
+	p.m.RLock()
+	defer p.m.RUnlock()
+	return p.totalMeshTime(now)
+}
+
+func (p *Peer) totalMeshTime(now time.Time) time.Duration {
+	var total time.Duration
+	for _, meshMetric := range p.MeshMetrics {
+		total += meshMetric.meshTime(now)
+	}
+	return total
+}
+
+func (m *MeshMetric) meshTime(now time.Time) time.Duration {
+	total := m.TotalTime
+	if m.InMesh {
+		if d := now.Sub(m.LastGraft); d > 0 {
+			total += d
+		}
+	}
+	return total
+}
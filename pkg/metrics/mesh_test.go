@@ -0,0 +1,78 @@
+package metrics
+
+import (
+	"bytes"
+	"encoding/csv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_MeshEvents(t *testing.T) {
+	t0 := time.Unix(1000, 0)
+	p := NewPeer(testPeerID("mesh"))
+
+	// join, prune, join
+	p.MeshEvent(testBlockTopic, true, t0)
+	require.True(t, p.IsInMesh(testBlockTopic))
+	require.Equal(t, 5*time.Minute, p.MeshTime(testBlockTopic, t0.Add(5*time.Minute)))
+	p.MeshEvent(testBlockTopic, false, t0.Add(10*time.Minute))
+	require.False(t, p.IsInMesh(testBlockTopic))
+	require.Equal(t, 10*time.Minute, p.MeshTime(testBlockTopic, t0.Add(time.Hour)))
+	p.MeshEvent(testBlockTopic, true, t0.Add(20*time.Minute))
+	require.True(t, p.IsInMesh(testBlockTopic))
+	// the ongoing membership counts until now
+	require.Equal(t, 15*time.Minute, p.MeshTime(testBlockTopic, t0.Add(25*time.Minute)))
+
+	// a repeated graft keeps the first one
+	p.MeshEvent(testBlockTopic, true, t0.Add(22*time.Minute))
+	require.Equal(t, 15*time.Minute, p.MeshTime(testBlockTopic, t0.Add(25*time.Minute)))
+
+	meshMetric := p.MeshMetrics[testBlockTopic]
+	require.Equal(t, int64(3), meshMetric.Grafts)
+	require.Equal(t, int64(1), meshMetric.Prunes)
+
+	// a second topic
+	p.MeshEvent(testAttTopic, true, t0)
+	require.Equal(t, []string{testAttTopic, testBlockTopic}, p.MeshTopics())
+	require.Equal(t, 15*time.Minute+25*time.Minute, p.TotalMeshTime(t0.Add(25*time.Minute)))
+}
+
+func Test_MeshPruneWithoutGraft(t *testing.T) {
+	t0 := time.Unix(1000, 0)
+	p := NewPeer(testPeerID("mesh-prune"))
+
+	p.MeshEvent(testBlockTopic, false, t0)
+	require.False(t, p.IsInMesh(testBlockTopic))
+	require.Equal(t, int64(1), p.MeshMetrics[testBlockTopic].Prunes)
+	require.Equal(t, int64(0), p.MeshMetrics[testBlockTopic].Grafts)
+	require.Equal(t, time.Duration(0), p.TotalMeshTime(t0.Add(time.Hour)))
+	require.Equal(t, 0, len(p.MeshTopics()))
+
+	// a graft before the last prune never accounts negative time
+	p.MeshEvent(testBlockTopic, true, t0.Add(time.Minute))
+	p.MeshEvent(testBlockTopic, false, t0)
+	require.Equal(t, time.Duration(0), p.TotalMeshTime(t0.Add(time.Hour)))
+}
+
+func Test_MeshCsvColumns(t *testing.T) {
+	store := NewPeerStore()
+	p := store.GetOrCreatePeer(testPeerID("mesh-csv"))
+	t0 := time.Now().Add(-time.Hour)
+	p.MeshEvent(testBlockTopic, true, t0)
+	p.MeshEvent(testBlockTopic, false, t0.Add(90*time.Second))
+	p.MeshEvent(testAttTopic, true, time.Now().Add(time.Hour)) // still in mesh, no time yet
+
+	var buf bytes.Buffer
+	require.NoError(t, store.ExportCsv(&buf))
+	records, err := csv.NewReader(&buf).ReadAll()
+	require.NoError(t, err)
+	require.Equal(t, "1", records[1][csvColumn(t, "mesh_topics")])
+	require.Equal(t, "90", records[1][csvColumn(t, "mesh_time_secs")])
+
+	// the mesh metrics survive a merge (i.e. a checkpoint restore)
+	restored := NewPeer(p.ID)
+	restored.Merge(p)
+	require.Equal(t, int64(2), restored.MeshMetrics[testBlockTopic].Grafts+restored.MeshMetrics[testAttTopic].Grafts)
+}
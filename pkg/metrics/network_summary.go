@@ -0,0 +1,424 @@
+package metrics
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/migalabs/armiarma/pkg/utils"
+	"github.com/pkg/errors"
+)
+
+const (
+	// default names of the files of the network summary
+	NetworkSummaryFile        = "network_summary.json"
+	SummaryTotalsFile         = "summary_totals.csv"
+	SummaryClientsFile        = "summary_clients.csv"
+	SummaryClientVersionsFile = "summary_client_versions.csv"
+	SummaryCountriesFile      = "summary_countries.csv"
+	SummaryErrorsFile         = "summary_errors.csv"
+)
+
+var (
+	// SummaryTotalsCsvHeader is the list of columns of the totals of the network summary.
+	SummaryTotalsCsvHeader = []string{"peers", "attempted", "succeeded", "connected", "currently_connected", "deprecated"}
+	// SummaryClientsCsvHeader is the list of columns of the per-client breakdown of the network summary.
+	SummaryClientsCsvHeader = []string{"client", "peers", "percentage", "median_latency_ms", "p90_latency_ms", "p99_latency_ms"}
+	// SummaryClientVersionsCsvHeader is the list of columns of the per-client-version breakdown of the network summary.
+	SummaryClientVersionsCsvHeader = []string{"client", "major_version", "peers", "percentage"}
+	// SummaryCountriesCsvHeader is the list of columns of the per-country breakdown of the network summary.
+	SummaryCountriesCsvHeader = []string{"country_code", "country", "peers", "percentage"}
+	// SummaryErrorsCsvHeader is the list of columns of the per-error breakdown of the network summary.
+	SummaryErrorsCsvHeader = []string{"error", "peers", "percentage"}
+)
+
+// NetworkSummary is the aggregated view of the peers of the store.
+type NetworkSummary struct {
+	Totals         SummaryTotals          `json:"totals"`
+	Clients        []ClientSummary        `json:"clients"`
+	ClientVersions []ClientVersionSummary `json:"client_versions"`
+	Countries      []CountrySummary       `json:"countries"`
+	Errors         []ErrorSummary         `json:"errors"`
+}
+
+// SummaryTotals counts the peers of the store by connection outcome.
+type SummaryTotals struct {
+	Peers int `json:"peers"`
+	// dialed by the crawler, and dialed successfully at least once
+	Attempted int `json:"attempted"`
+	Succeeded int `json:"succeeded"`
+	// connected at least once (in any direction), and right now
+	Connected          int `json:"connected"`
+	CurrentlyConnected int `json:"currently_connected"`
+	Deprecated         int `json:"deprecated"`
+}
+
+// ClientSummary is the share of a client among the non-deprecated peers (Unknown for the unidentified ones),
+// and the latency percentiles of the ones with a measured latency (0 if none).
+type ClientSummary struct {
+	Client          string  `json:"client"`
+	Peers           int     `json:"peers"`
+	Percentage      float64 `json:"percentage"`
+	MedianLatencyMs int64   `json:"median_latency_ms"`
+	P90LatencyMs    int64   `json:"p90_latency_ms"`
+	P99LatencyMs    int64   `json:"p99_latency_ms"`
+}
+
+// ClientVersionSummary is the share of a major version of a client among the non-deprecated peers
+// (Unknown if the version couldn't be parsed).
+type ClientVersionSummary struct {
+	Client       string  `json:"client"`
+	MajorVersion string  `json:"major_version"`
+	Peers        int     `json:"peers"`
+	Percentage   float64 `json:"percentage"`
+}
+
+// CountrySummary is the share of a country among all the peers (Unknown for the ones that weren't located).
+type CountrySummary struct {
+	CountryCode string  `json:"country_code"`
+	Country     string  `json:"country"`
+	Peers       int     `json:"peers"`
+	Percentage  float64 `json:"percentage"`
+}
+
+// ErrorSummary is the share of a connection error among the peers whose last attempt failed.
+type ErrorSummary struct {
+	Error      string  `json:"error"`
+	Peers      int     `json:"peers"`
+	Percentage float64 `json:"percentage"`
+}
+
+// GetNetworkSummary aggregates the peers of the store. The peers are visited one by one (see ForEachPeer),
+// so the store is never locked for the whole walk.
+// The client breakdowns skip the deprecated peers (and the non-Ethereum ones, see SetIncludeOtherLibp2p),
+// and report the unidentified ones under Unknown. All the breakdowns are sorted by number of peers.
+func (s *PeerStore) GetNetworkSummary() NetworkSummary {
+	s.m.RLock()
+	includeOthers := s.includeOtherLibp2p
+	s.m.RUnlock()
+
+	var totals SummaryTotals
+	clients := make(map[string]int)
+	latencies := make(map[string][]time.Duration)
+	versions := make(map[ClientVersion]int)
+	countries := make(map[string]int)
+	// whether the peers of each country are grouped by the ISO code, or by the name
+	byCode := make(map[string]bool)
+	names := make(countryNames)
+	failures := make(map[string]int)
+	clientPeers, failedPeers := 0, 0
+	s.ForEachPeer(func(p *Peer) bool {
+		p.m.RLock()
+		defer p.m.RUnlock()
+		totals.Peers++
+		if p.Attempted {
+			totals.Attempted++
+		}
+		if p.Succeed {
+			totals.Succeeded++
+		}
+		if p.Succeed || len(p.ConnectionTimes) > 0 {
+			totals.Connected++
+		}
+		if p.IsConnected {
+			totals.CurrentlyConnected++
+		}
+		if p.Deprecated {
+			totals.Deprecated++
+		}
+		key := p.countryKey()
+		countries[key]++
+		byCode[key] = p.CountryCode != ""
+		names.add(key, p.Country)
+		if p.Attempted && p.FailureStreak > 0 {
+			failedPeers++
+			failures[orUnknown(p.LastError)]++
+		}
+
+		if p.Deprecated || !includeOthers && p.PeerCategory == string(utils.OtherLibp2pCategory) {
+			return true
+		}
+		clientPeers++
+		client := orUnknown(p.ClientName)
+		clients[client]++
+		versions[ClientVersion{Client: client, Version: majorVersion(p.ClientVersion)}]++
+		if p.Latency > 0 {
+			latencies[client] = append(latencies[client], p.Latency)
+		}
+		return true
+	})
+
+	summary := NetworkSummary{
+		Totals:         totals,
+		Clients:        make([]ClientSummary, 0, len(clients)),
+		ClientVersions: make([]ClientVersionSummary, 0, len(versions)),
+		Countries:      make([]CountrySummary, 0, len(countries)),
+		Errors:         make([]ErrorSummary, 0, len(failures)),
+	}
+	for client, count := range clients {
+		sorted := sortedDurations(latencies[client])
+		summary.Clients = append(summary.Clients, ClientSummary{
+			Client:          client,
+			Peers:           count,
+			Percentage:      percentage(count, clientPeers),
+			MedianLatencyMs: medianDuration(sorted).Milliseconds(),
+			P90LatencyMs:    percentileDuration(sorted, 90).Milliseconds(),
+			P99LatencyMs:    percentileDuration(sorted, 99).Milliseconds(),
+		})
+	}
+	sort.Slice(summary.Clients, func(i, j int) bool {
+		return byPeers(summary.Clients[i].Peers, summary.Clients[j].Peers, summary.Clients[i].Client, summary.Clients[j].Client)
+	})
+	for version, count := range versions {
+		summary.ClientVersions = append(summary.ClientVersions, ClientVersionSummary{
+			Client:       version.Client,
+			MajorVersion: version.Version,
+			Peers:        count,
+			Percentage:   percentage(count, clientPeers),
+		})
+	}
+	sort.Slice(summary.ClientVersions, func(i, j int) bool {
+		a, b := summary.ClientVersions[i], summary.ClientVersions[j]
+		return byPeers(a.Peers, b.Peers, a.Client+"/"+a.MajorVersion, b.Client+"/"+b.MajorVersion)
+	})
+	for key, count := range countries {
+		country := CountrySummary{
+			CountryCode: utils.Unknown,
+			Country:     utils.Unknown,
+			Peers:       count,
+			Percentage:  percentage(count, totals.Peers),
+		}
+		if key != "" {
+			country.Country = names.name(key)
+			country.CountryCode = ""
+			if byCode[key] {
+				country.CountryCode = key
+			}
+		}
+		summary.Countries = append(summary.Countries, country)
+	}
+	sort.Slice(summary.Countries, func(i, j int) bool {
+		return byPeers(summary.Countries[i].Peers, summary.Countries[j].Peers, summary.Countries[i].Country, summary.Countries[j].Country)
+	})
+	for err, count := range failures {
+		summary.Errors = append(summary.Errors, ErrorSummary{
+			Error:      err,
+			Peers:      count,
+			Percentage: percentage(count, failedPeers),
+		})
+	}
+	sort.Slice(summary.Errors, func(i, j int) bool {
+		return byPeers(summary.Errors[i].Peers, summary.Errors[j].Peers, summary.Errors[i].Error, summary.Errors[j].Error)
+	})
+	return summary
+}
+
+// orUnknown returns s, or Unknown if it's empty.
+func orUnknown(s string) string {
+	if s == "" {
+		return utils.Unknown
+	}
+	return s
+}
+
+// majorVersion returns the major version of a client version (i.e. "v3" for "v3.5.1-319cc61"),
+// or Unknown if it doesn't start with a number.
+func majorVersion(version string) string {
+	trimmed := strings.TrimPrefix(strings.TrimPrefix(version, "v"), "V")
+	end := 0
+	for end < len(trimmed) && trimmed[end] >= '0' && trimmed[end] <= '9' {
+		end++
+	}
+	if end == 0 {
+		return utils.Unknown
+	}
+	return "v" + trimmed[:end]
+}
+
+// percentage returns the share of count over total (0-100), rounded to 2 decimals.
+func percentage(count, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return math.Round(10000*float64(count)/float64(total)) / 100
+}
+
+// byPeers sorts by number of peers (descending), and then by name.
+func byPeers(peersA, peersB int, nameA, nameB string) bool {
+	if peersA != peersB {
+		return peersA > peersB
+	}
+	return nameA < nameB
+}
+
+// sortedDurations returns a sorted copy of the durations.
+func sortedDurations(durations []time.Duration) []time.Duration {
+	sorted := append([]time.Duration(nil), durations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted
+}
+
+// percentileDuration returns the nearest-rank percentile (0-100) of the sorted durations, or 0 if there are none.
+func percentileDuration(sorted []time.Duration, pct float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	idx := int(math.Ceil(pct/100*float64(len(sorted)))) - 1
+	if idx < 0 {
+		idx = 0
+	}
+	return sorted[idx]
+}
+
+// ExportNetworkSummaryJson writes into w the network summary as a compact JSON document.
+func (s *PeerStore) ExportNetworkSummaryJson(w io.Writer) error {
+	err := json.NewEncoder(w).Encode(s.GetNetworkSummary())
+	return errors.Wrap(err, "unable to encode network summary")
+}
+
+// ExportSummaryTotalsCsv writes into w the header and the row of totals of the network summary.
+func (s *PeerStore) ExportSummaryTotalsCsv(w io.Writer) error {
+	totals := s.GetNetworkSummary().Totals
+	return writeSummaryCsv(w, SummaryTotalsCsvHeader, [][]string{{
+		fmt.Sprintf("%d", totals.Peers),
+		fmt.Sprintf("%d", totals.Attempted),
+		fmt.Sprintf("%d", totals.Succeeded),
+		fmt.Sprintf("%d", totals.Connected),
+		fmt.Sprintf("%d", totals.CurrentlyConnected),
+		fmt.Sprintf("%d", totals.Deprecated),
+	}})
+}
+
+// ExportSummaryClientsCsv writes into w the header and one row per client of the network summary.
+func (s *PeerStore) ExportSummaryClientsCsv(w io.Writer) error {
+	clients := s.GetNetworkSummary().Clients
+	rows := make([][]string, 0, len(clients))
+	for _, client := range clients {
+		rows = append(rows, []string{
+			client.Client,
+			fmt.Sprintf("%d", client.Peers),
+			fmt.Sprintf("%.2f", client.Percentage),
+			fmt.Sprintf("%d", client.MedianLatencyMs),
+			fmt.Sprintf("%d", client.P90LatencyMs),
+			fmt.Sprintf("%d", client.P99LatencyMs),
+		})
+	}
+	return writeSummaryCsv(w, SummaryClientsCsvHeader, rows)
+}
+
+// ExportSummaryClientVersionsCsv writes into w the header and one row per client major version of the network summary.
+func (s *PeerStore) ExportSummaryClientVersionsCsv(w io.Writer) error {
+	versions := s.GetNetworkSummary().ClientVersions
+	rows := make([][]string, 0, len(versions))
+	for _, version := range versions {
+		rows = append(rows, []string{
+			version.Client,
+			version.MajorVersion,
+			fmt.Sprintf("%d", version.Peers),
+			fmt.Sprintf("%.2f", version.Percentage),
+		})
+	}
+	return writeSummaryCsv(w, SummaryClientVersionsCsvHeader, rows)
+}
+
+// ExportSummaryCountriesCsv writes into w the header and one row per country of the network summary.
+func (s *PeerStore) ExportSummaryCountriesCsv(w io.Writer) error {
+	countries := s.GetNetworkSummary().Countries
+	rows := make([][]string, 0, len(countries))
+	for _, country := range countries {
+		rows = append(rows, []string{
+			country.CountryCode,
+			country.Country,
+			fmt.Sprintf("%d", country.Peers),
+			fmt.Sprintf("%.2f", country.Percentage),
+		})
+	}
+	return writeSummaryCsv(w, SummaryCountriesCsvHeader, rows)
+}
+
+// ExportSummaryErrorsCsv writes into w the header and one row per connection error of the network summary.
+func (s *PeerStore) ExportSummaryErrorsCsv(w io.Writer) error {
+	failures := s.GetNetworkSummary().Errors
+	rows := make([][]string, 0, len(failures))
+	for _, failure := range failures {
+		rows = append(rows, []string{
+			failure.Error,
+			fmt.Sprintf("%d", failure.Peers),
+			fmt.Sprintf("%.2f", failure.Percentage),
+		})
+	}
+	return writeSummaryCsv(w, SummaryErrorsCsvHeader, rows)
+}
+
+func writeSummaryCsv(w io.Writer, header []string, rows [][]string) error {
+	csvW := csv.NewWriter(w)
+	err := csvW.Write(header)
+	if err != nil {
+		return errors.Wrap(err, "unable to write csv header")
+	}
+	for _, row := range rows {
+		err = csvW.Write(row)
+		if err != nil {
+			return errors.Wrap(err, "unable to write csv row")
+		}
+	}
+	csvW.Flush()
+	return csvW.Error()
+}
+
+// NetworkSummaryExporters returns the Exporters of the network summary per default file name:
+// the JSON document (a single row) and the CSV breakdowns (one row per entry).
+func (s *PeerStore) NetworkSummaryExporters() map[string]Exporter {
+	return map[string]Exporter{
+		NetworkSummaryFile: ExporterFunc(func(w io.Writer) (int64, error) {
+			return 1, s.ExportNetworkSummaryJson(w)
+		}),
+		SummaryTotalsFile:         summaryCsvExporter(s.ExportSummaryTotalsCsv),
+		SummaryClientsFile:        summaryCsvExporter(s.ExportSummaryClientsCsv),
+		SummaryClientVersionsFile: summaryCsvExporter(s.ExportSummaryClientVersionsCsv),
+		SummaryCountriesFile:      summaryCsvExporter(s.ExportSummaryCountriesCsv),
+		SummaryErrorsFile:         summaryCsvExporter(s.ExportSummaryErrorsCsv),
+	}
+}
+
+// summaryCsvExporter adapts a CSV export into an Exporter that counts its rows.
+func summaryCsvExporter(export func(io.Writer) error) Exporter {
+	return ExporterFunc(func(w io.Writer) (int64, error) {
+		lw := &lineCountWriter{w: w}
+		err := export(lw)
+		// the header isn't a row
+		rows := lw.lines - 1
+		if rows < 0 {
+			rows = 0
+		}
+		return rows, err
+	})
+}
+
+// ExportNetworkSummaryFiles exports the network summary into its default files of the given directory (overwriting them).
+func (s *PeerStore) ExportNetworkSummaryFiles(dir string) error {
+	for name, exporter := range s.NetworkSummaryExporters() {
+		err := writeExportFile(exporter, filepath.Join(dir, name))
+		if err != nil {
+			return errors.Wrap(err, "unable to export "+name)
+		}
+	}
+	return nil
+}
+
+func writeExportFile(exporter Exporter, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return errors.Wrap(err, "unable to create file")
+	}
+	defer f.Close()
+	_, err = exporter.Export(f)
+	return err
+}
@@ -0,0 +1,168 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+const goldenNetworkSummary = `{"totals":{"peers":7,"attempted":6,"succeeded":2,"connected":3,"currently_connected":1,"deprecated":1},` +
+	`"clients":[{"client":"lighthouse","peers":3,"percentage":50,"median_latency_ms":40,"p90_latency_ms":100,"p99_latency_ms":100},` +
+	`{"client":"prysm","peers":2,"percentage":33.33,"median_latency_ms":60,"p90_latency_ms":60,"p99_latency_ms":60},` +
+	`{"client":"unknown","peers":1,"percentage":16.67,"median_latency_ms":0,"p90_latency_ms":0,"p99_latency_ms":0}],` +
+	`"client_versions":[{"client":"lighthouse","major_version":"v4","peers":2,"percentage":33.33},` +
+	`{"client":"lighthouse","major_version":"v3","peers":1,"percentage":16.67},` +
+	`{"client":"prysm","major_version":"unknown","peers":1,"percentage":16.67},` +
+	`{"client":"prysm","major_version":"v4","peers":1,"percentage":16.67},` +
+	`{"client":"unknown","major_version":"unknown","peers":1,"percentage":16.67}],` +
+	`"countries":[{"country_code":"DE","country":"Germany","peers":3,"percentage":42.86},` +
+	`{"country_code":"US","country":"United States","peers":2,"percentage":28.57},` +
+	`{"country_code":"","country":"France","peers":1,"percentage":14.29},` +
+	`{"country_code":"unknown","country":"unknown","peers":1,"percentage":14.29}],` +
+	`"errors":[{"error":"io_timeout","peers":3,"percentage":75},{"error":"connection_refused","peers":1,"percentage":25}]}
+`
+
+const goldenSummaryTotals = `peers,attempted,succeeded,connected,currently_connected,deprecated
+7,6,2,3,1,1
+`
+
+const goldenSummaryClients = `client,peers,percentage,median_latency_ms,p90_latency_ms,p99_latency_ms
+lighthouse,3,50.00,40,100,100
+prysm,2,33.33,60,60,60
+unknown,1,16.67,0,0,0
+`
+
+const goldenSummaryClientVersions = `client,major_version,peers,percentage
+lighthouse,v4,2,33.33
+lighthouse,v3,1,16.67
+prysm,unknown,1,16.67
+prysm,v4,1,16.67
+unknown,unknown,1,16.67
+`
+
+const goldenSummaryCountries = `country_code,country,peers,percentage
+DE,Germany,3,42.86
+US,United States,2,28.57
+,France,1,14.29
+unknown,unknown,1,14.29
+`
+
+const goldenSummaryErrors = `error,peers,percentage
+io_timeout,3,75.00
+connection_refused,1,25.00
+`
+
+func newTestSummaryStore() *PeerStore {
+	store := NewPeerStore()
+	t0 := time.Unix(1000, 0)
+	seeds := []struct {
+		client, version, country, code string
+		latencyMs                      int
+		// outcome of the dial (none if empty), and whether the peer ended up connected or deprecated
+		dial                  string
+		connected, deprecated bool
+	}{
+		{"lighthouse", "v4.0.1", "Germany", "DE", 20, "ok", true, false},
+		{"lighthouse", "v4.1.0", "Germany", "DE", 40, "ok", false, false},
+		{"lighthouse", "v3.5.1-319cc61", "United States", "US", 100, "io_timeout", false, false},
+		{"prysm", "v4.0.3", "United States of America", "US", 60, "connection_refused", false, false},
+		// inbound only, located by a provider without codes
+		{"prysm", "", "France", "", 0, "", false, false},
+		// unidentified and not located
+		{"", "", "", "", 0, "io_timeout", false, false},
+		{"teku", "v23.1.0", "Germany", "DE", 30, "io_timeout", false, true},
+	}
+	for i, seed := range seeds {
+		p := store.GetOrCreatePeer(testPeerID(fmt.Sprintf("summary-peer%d", i)))
+		p.ClientName = seed.client
+		p.ClientVersion = seed.version
+		p.Country = seed.country
+		p.CountryCode = seed.code
+		p.Latency = time.Duration(seed.latencyMs) * time.Millisecond
+		switch seed.dial {
+		case "":
+		case "ok":
+			p.ConnectionAttemptEvent(true, "none")
+		default:
+			p.ConnectionAttemptEvent(false, seed.dial)
+		}
+		if seed.dial == "ok" || seed.dial == "" && seed.client != "" {
+			p.ConnectionEvent(t0)
+			if !seed.connected {
+				p.DisconnectionEvent(t0.Add(time.Minute))
+			}
+		}
+		if seed.deprecated {
+			p.DeprecationEvent()
+		}
+	}
+	return store
+}
+
+func Test_ExportNetworkSummary(t *testing.T) {
+	store := newTestSummaryStore()
+	exports := []struct {
+		export func(w *bytes.Buffer) error
+		golden string
+	}{
+		{func(w *bytes.Buffer) error { return store.ExportNetworkSummaryJson(w) }, goldenNetworkSummary},
+		{func(w *bytes.Buffer) error { return store.ExportSummaryTotalsCsv(w) }, goldenSummaryTotals},
+		{func(w *bytes.Buffer) error { return store.ExportSummaryClientsCsv(w) }, goldenSummaryClients},
+		{func(w *bytes.Buffer) error { return store.ExportSummaryClientVersionsCsv(w) }, goldenSummaryClientVersions},
+		{func(w *bytes.Buffer) error { return store.ExportSummaryCountriesCsv(w) }, goldenSummaryCountries},
+		{func(w *bytes.Buffer) error { return store.ExportSummaryErrorsCsv(w) }, goldenSummaryErrors},
+	}
+	for _, export := range exports {
+		var buf bytes.Buffer
+		require.NoError(t, export.export(&buf))
+		require.Equal(t, export.golden, buf.String())
+	}
+
+	// the scheduled exports count the entries as rows
+	exporters := store.NetworkSummaryExporters()
+	require.Len(t, exporters, 6)
+	rows, err := exporters[SummaryClientVersionsFile].Export(&bytes.Buffer{})
+	require.NoError(t, err)
+	require.Equal(t, int64(5), rows)
+	rows, err = exporters[NetworkSummaryFile].Export(&bytes.Buffer{})
+	require.NoError(t, err)
+	require.Equal(t, int64(1), rows)
+
+	dir := t.TempDir()
+	require.NoError(t, store.ExportNetworkSummaryFiles(dir))
+	content, err := os.ReadFile(filepath.Join(dir, SummaryCountriesFile))
+	require.NoError(t, err)
+	require.Equal(t, goldenSummaryCountries, string(content))
+}
+
+func Test_NetworkSummaryEmptyStore(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, NewPeerStore().ExportNetworkSummaryJson(&buf))
+	require.Equal(t, `{"totals":{"peers":0,"attempted":0,"succeeded":0,"connected":0,"currently_connected":0,"deprecated":0},`+
+		`"clients":[],"client_versions":[],"countries":[],"errors":[]}`+"\n", buf.String())
+}
+
+func Test_MajorVersion(t *testing.T) {
+	require.Equal(t, "v3", majorVersion("v3.5.1-319cc61"))
+	require.Equal(t, "v23", majorVersion("23.1.0"))
+	require.Equal(t, "v1", majorVersion("V1"))
+	require.Equal(t, "unknown", majorVersion(""))
+	require.Equal(t, "unknown", majorVersion("unknown"))
+}
+
+func Test_PercentileDuration(t *testing.T) {
+	require.Equal(t, time.Duration(0), percentileDuration(nil, 90))
+	sorted := make([]time.Duration, 0, 100)
+	for i := 1; i <= 100; i++ {
+		sorted = append(sorted, time.Duration(i))
+	}
+	require.Equal(t, time.Duration(50), percentileDuration(sorted, 50))
+	require.Equal(t, time.Duration(90), percentileDuration(sorted, 90))
+	require.Equal(t, time.Duration(99), percentileDuration(sorted, 99))
+	require.Equal(t, time.Duration(1), percentileDuration(sorted, 0))
+}
@@ -0,0 +1,1197 @@
+package metrics
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/migalabs/armiarma/pkg/db/models"
+	"github.com/migalabs/armiarma/pkg/utils"
+	"github.com/migalabs/armiarma/pkg/utils/providers"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// Peer is the in-memory summary of everything that the crawler knows about a remote peer.
+// It gets updated from the discovery, peering and gossipsub events, and serves as
+// source for the live aggregations of the crawler (it doesn't replace the DB).
+type Peer struct {
+	m sync.RWMutex
+	// traffic exchanged with the peer, updated atomically without the lock (first after the lock,
+	// to keep its counters 64-bit aligned)
+	Bandwidth PeerBandwidth `json:"bandwidth"`
+
+	ID      peer.ID           `json:"peer_id"`
+	Network utils.NetworkType `json:"network"`
+	MAddrs  []ma.Multiaddr    `json:"-"` // serialized as strings (see MarshalJSON)
+	// circuit-relay addresses (also included in MAddrs), and whether the peer is only reachable through them
+	RelayAddrs []ma.Multiaddr `json:"-"`
+	RelayOnly  bool           `json:"relay_only,omitempty"`
+
+	// Identification
+	UserAgent       string        `json:"user_agent,omitempty"`
+	ClientName      string        `json:"client_name,omitempty"`
+	ClientVersion   string        `json:"client_version,omitempty"`
+	ClientOS        string        `json:"client_os,omitempty"`
+	ClientArch      string        `json:"client_arch,omitempty"`
+	PeerCategory    string        `json:"peer_category,omitempty"`
+	ProtocolVersion string        `json:"protocol_version,omitempty"`
+	Protocols       []string      `json:"protocols,omitempty"`
+	Latency         time.Duration `json:"latency,omitempty"`
+	// the user agent had to be sanitized, and the first bytes of the raw one hex-encoded (debug)
+	UserAgentSanitized bool   `json:"user_agent_sanitized,omitempty"`
+	RawUserAgent       string `json:"raw_user_agent,omitempty"`
+	// confidence of the client name (1 for the exact rules, lower for the fuzzy matches, see utils.ParseClient)
+	ClientConfidence float64 `json:"client_confidence,omitempty"`
+	// whether the metadata of the peer was obtained
+	MetadataObtained bool `json:"metadata_obtained,omitempty"`
+	// MetaData req/resp requests sent to the peer, the succeeded ones, the time of the last one,
+	// the error of the last one that failed, and the consecutive failures up to the last one
+	MetadataRequests      int64     `json:"metadata_requests,omitempty"`
+	MetadataSuccesses     int64     `json:"metadata_successes,omitempty"`
+	LastMetadataAttempt   time.Time `json:"last_metadata_attempt,omitempty"`
+	LastMetadataError     string    `json:"last_metadata_error,omitempty"`
+	MetadataFailureStreak int       `json:"metadata_failure_streak,omitempty"`
+	// attestation subnets of the latest metadata and ENR of the peer (SSZ bitvectors)
+	MetadataAttnets []byte `json:"metadata_attnets,omitempty"`
+	EnrAttnets      []byte `json:"enr_attnets,omitempty"`
+	// short name of the pubsub protocol negotiated with the peer (empty if we never exchanged pubsub)
+	PubsubVersion string `json:"pubsub_version,omitempty"`
+
+	// Location
+	Ip          string `json:"ip,omitempty"`
+	Country     string `json:"country,omitempty"`
+	CountryCode string `json:"country_code,omitempty"`
+	City        string `json:"city,omitempty"`
+	// cloud or hosting provider of the IP (empty if it isn't a known one)
+	Provider string `json:"provider,omitempty"`
+	// autonomous system of the IP, and the geolocation provider that located it
+	ASN            string `json:"asn,omitempty"`
+	LocationSource string `json:"location_source,omitempty"`
+	// number of peers (including this one) sharing the same IP, refreshed by PeerStore.RefreshPeersOnSameIP
+	PeersOnSameIP int `json:"peers_on_same_ip,omitempty"`
+
+	// Connection Control
+	Attempted          bool      `json:"attempted"`
+	Attempts           int       `json:"attempts"`
+	SuccessfulAttempts int       `json:"successful_attempts,omitempty"`
+	Succeed            bool      `json:"succeed"`
+	IsConnected        bool      `json:"-"` // only meaningful while the crawler is running
+	LastError          string    `json:"last_error,omitempty"`
+	LastAttempt        time.Time `json:"last_attempt,omitempty"`
+	// error of the last failed attempt (LastError is the most frequent one)
+	LastAttemptError string `json:"last_attempt_error,omitempty"`
+	// delay until the next attempt chosen after the last one, and the dial score of the peer then (see DialBackoff)
+	DialDelay time.Duration `json:"dial_delay,omitempty"`
+	DialScore float64       `json:"dial_score,omitempty"`
+	// failed attempts per (filtered) error, and the time of the last failure of each error
+	ErrorCounts    map[string]uint64    `json:"error_counts,omitempty"`
+	LastErrorTimes map[string]time.Time `json:"last_error_times,omitempty"`
+	// the crawler gave up connecting the peer (until it succeeds again)
+	Deprecated bool `json:"deprecated,omitempty"`
+	// last time a deprecated peer was rediscovered
+	rediscoveredAt time.Time
+	// consecutive failed attempts up to the last one, the longest run of them, and the consecutive succeeded ones
+	FailureStreak        int         `json:"failure_streak,omitempty"`
+	LongestFailureStreak int         `json:"longest_failure_streak,omitempty"`
+	SuccessStreak        int         `json:"success_streak,omitempty"`
+	ConnectionTimes      []time.Time `json:"connection_times,omitempty"`
+	DisconnectionTimes   []time.Time `json:"disconnection_times,omitempty"`
+	// number of out-of-order connection/disconnection events (clock adjustments, late events)
+	TimestampAnomalies uint64 `json:"timestamp_anomalies,omitempty"`
+	// our listen address and the remote address of the last connection
+	LastLocalAddr  string `json:"last_local_addr,omitempty"`
+	LastRemoteAddr string `json:"last_remote_addr,omitempty"`
+	// sessions whose remote address was direct or went through a circuit relay, and the kind of the last one
+	DirectSessions        int  `json:"direct_sessions,omitempty"`
+	RelayedSessions       int  `json:"relayed_sessions,omitempty"`
+	LastConnectionRelayed bool `json:"last_connection_relayed,omitempty"`
+	// outcome of the last dial-back to the advertised addresses of the peer, after it connected to us
+	DialbackAttempted bool      `json:"dialback_attempted,omitempty"`
+	DialbackSucceeded bool      `json:"dialback_succeeded,omitempty"`
+	DialbackError     string    `json:"dialback_error,omitempty"`
+	DialbackTime      time.Time `json:"dialback_time,omitempty"`
+
+	// Status req/resp requests sent to the peer, the succeeded ones, the failed ones per error, the time
+	// of the last one, and the consecutive failures up to the last one
+	StatusRequests      int64            `json:"status_requests,omitempty"`
+	StatusSucceeded     int64            `json:"status_succeeded,omitempty"`
+	StatusErrors        map[string]int64 `json:"status_errors,omitempty"`
+	LastStatusRequest   time.Time        `json:"last_status_request,omitempty"`
+	StatusFailureStreak int              `json:"status_failure_streak,omitempty"`
+
+	// Goodbye messages received from the peer per reason, the last one, and the reason of the last
+	// disconnection that followed a Goodbye
+	Goodbyes             map[string]int64 `json:"goodbyes,omitempty"`
+	LastGoodbye          string           `json:"last_goodbye,omitempty"`
+	LastGoodbyeTime      time.Time        `json:"last_goodbye_time,omitempty"`
+	LastDisconnectReason string           `json:"last_disconnect_reason,omitempty"`
+	// whether the last Goodbye wasn't followed by a disconnection yet
+	goodbyePending bool
+
+	// GossipSub messages received from the peer per topic
+	MessageMetrics map[string]*MessageMetric `json:"message_metrics,omitempty"`
+	// membership of the peer in our gossipsub mesh per topic
+	MeshMetrics map[string]*MeshMetric `json:"mesh_metrics,omitempty"`
+
+	// funnel stages already counted, and the counters of the store (nil if it isn't in any)
+	funnelReached FunnelStage
+	funnel        *funnelCounters
+	// message IDs shared by the peers of the store (nil if it isn't in any)
+	seenMessages *SeenMessages
+	// index of the peers of the store by IP (nil if it isn't in any), and the IP indexed in it
+	ipIndex   *peerIPIndex
+	indexedIp string
+}
+
+// MessageMetric tracks the messages that a peer sent us on a single topic.
+type MessageMetric struct {
+	Count            int64     `json:"count"`
+	Bytes            int64     `json:"bytes,omitempty"`
+	FirstMessageTime time.Time `json:"first_message_time"`
+	LastMessageTime  time.Time `json:"last_message_time"`
+	// delays relative to the slot start, only for the messages whose slot was decoded
+	ArrivalDelays *DelayStats `json:"arrival_delays,omitempty"`
+	// gaps between consecutive messages
+	InterArrival *GapStats `json:"inter_arrival,omitempty"`
+	// messages that the peer delivered before anyone else, and the ones already delivered by others,
+	// as told by the pubsub router (whose seen cache drops the duplicates before the subscriptions)
+	FirstDeliveries int64 `json:"first_deliveries,omitempty"`
+	Duplicates      int64 `json:"duplicates,omitempty"`
+	// deliveries of the peer (first or duplicated, as reported by the pubsub router) split into the
+	// message IDs that nobody delivered before within the window of the store (see SeenMessages),
+	// and the ones that others delivered first
+	FirstSeenCount int64 `json:"first_seen_count,omitempty"`
+	DuplicateCount int64 `json:"duplicate_count,omitempty"`
+	// messages per hour, sorted and limited to the last HourlyRetention hours
+	HourlyCounts []HourBucket `json:"hourly_counts,omitempty"`
+	// messages of the last RateWindowMax (see TrackMessageRates), not kept in the snapshots
+	Rates *RateWindow `json:"-"`
+}
+
+// NewPeer returns an empty Peer for the given peer.ID.
+func NewPeer(pid peer.ID) *Peer {
+	return &Peer{
+		ID:                 pid,
+		MAddrs:             make([]ma.Multiaddr, 0),
+		RelayAddrs:         make([]ma.Multiaddr, 0),
+		Protocols:          make([]string, 0),
+		ConnectionTimes:    make([]time.Time, 0),
+		DisconnectionTimes: make([]time.Time, 0),
+		ErrorCounts:        make(map[string]uint64),
+		LastErrorTimes:     make(map[string]time.Time),
+		StatusErrors:       make(map[string]int64),
+		Goodbyes:           make(map[string]int64),
+		MessageMetrics:     make(map[string]*MessageMetric),
+		MeshMetrics:        make(map[string]*MeshMetric),
+	}
+}
+
+// FetchHostInfo updates the peer with the non-empty fields of the given HostInfo.
+func (p *Peer) FetchHostInfo(hInfo *models.HostInfo) {
+	hInfo.RLock()
+	defer hInfo.RUnlock()
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	p.Network = hInfo.Network
+	// a rediscovered peer is no longer deprecated (as its host info upserted in the DB)
+	if p.Deprecated && !hInfo.ControlInfo.Deprecated {
+		p.rediscoveredAt = time.Now()
+	}
+	p.Deprecated = hInfo.ControlInfo.Deprecated
+	if len(hInfo.MAddrs) > 0 {
+		p.MAddrs, _ = utils.CanonicalMAddrs(hInfo.MAddrs)
+		p.RelayAddrs = hInfo.RelayAddrs
+		p.RelayOnly = hInfo.RelayOnly
+	}
+	if hInfo.IP != "" {
+		p.Ip = hInfo.IP
+		p.updateIPIndex()
+	}
+	if hInfo.PeerInfo.IsPeerIdentified() {
+		pInfo := hInfo.PeerInfo
+		// the user agents that didn't go through PeerInfo.SetUserAgent get sanitized here
+		if !pInfo.UserAgentSanitized {
+			pInfo.SetUserAgent(pInfo.UserAgent)
+		}
+		// a partial identification doesn't clobber the known client (as the DB upserts)
+		if pInfo.UserAgent != "" {
+			p.UserAgent = pInfo.UserAgent
+			p.UserAgentSanitized = pInfo.UserAgentSanitized
+			p.RawUserAgent = pInfo.RawUserAgent
+			cliInfo := utils.ParseClient(hInfo.Network, pInfo.UserAgent)
+			p.ClientName, p.ClientVersion, p.ClientOS, p.ClientArch = cliInfo.Name, cliInfo.Version, cliInfo.OS, cliInfo.Arch
+			p.ClientConfidence = cliInfo.Confidence
+			p.PeerCategory = string(utils.ParsePeerCategory(p.ClientName))
+		}
+		if pInfo.ProtocolVersion != "" {
+			p.ProtocolVersion = pInfo.ProtocolVersion
+		}
+		if len(pInfo.Protocols) > 0 {
+			p.Protocols = pInfo.Protocols
+		}
+		// the latency measured through a relay is the relay's
+		if !pInfo.Relayed && pInfo.Latency > 0 {
+			p.Latency = pInfo.Latency
+		}
+	}
+	if _, ok := hInfo.Attr[models.MetadataAttribute]; ok {
+		p.MetadataObtained = true
+	}
+	for attName, att := range hInfo.Attr {
+		attnetsAttr, ok := att.(models.AttnetsAttr)
+		if !ok {
+			continue
+		}
+		attnets := attnetsAttr.AttnetsBitvector()
+		if len(attnets) == 0 {
+			continue
+		}
+		if attName == models.MetadataAttribute {
+			p.MetadataAttnets = attnets
+		} else {
+			p.EnrAttnets = attnets
+		}
+	}
+	if outcome, ok := hInfo.Attr[models.StatusRequestAttribute].(models.ReqRespOutcome); ok {
+		p.statusRequestEvent(outcome)
+	}
+	if outcome, ok := hInfo.Attr[models.MetadataRequestAttribute].(models.MetadataRequest); ok {
+		p.metadataRequestEvent(outcome)
+	}
+	if dialback, ok := hInfo.Attr[models.DialbackAttribute].(models.Dialback); ok {
+		p.dialbackEvent(dialback)
+	}
+	p.updateFunnel()
+}
+
+// StatusRequestEvent tracks the outcome of a Status request sent to the peer.
+func (p *Peer) StatusRequestEvent(outcome models.ReqRespOutcome) {
+	p.m.Lock()
+	defer p.m.Unlock()
+	p.statusRequestEvent(outcome)
+}
+
+// statusRequestEvent counts the Status request by its outcome (needs the lock).
+func (p *Peer) statusRequestEvent(outcome models.ReqRespOutcome) {
+	p.StatusRequests++
+	if outcome.Timestamp.After(p.LastStatusRequest) {
+		p.LastStatusRequest = outcome.Timestamp
+	}
+	if outcome.Succeeded() {
+		p.StatusSucceeded++
+		p.StatusFailureStreak = 0
+		return
+	}
+	p.StatusErrors[outcome.ErrorKey()]++
+	p.StatusFailureStreak++
+}
+
+// StatusSuccessRate returns the ratio of Status requests that succeeded, and false if none was sent.
+func (p *Peer) StatusSuccessRate() (float64, bool) {
+	p.m.RLock()
+	defer p.m.RUnlock()
+	if p.StatusRequests == 0 {
+		return 0, false
+	}
+	return float64(p.StatusSucceeded) / float64(p.StatusRequests), true
+}
+
+// MetadataRequestEvent tracks the outcome of a MetaData request sent to the peer.
+func (p *Peer) MetadataRequestEvent(outcome models.MetadataRequest) {
+	p.m.Lock()
+	defer p.m.Unlock()
+	p.metadataRequestEvent(outcome)
+}
+
+// metadataRequestEvent counts the MetaData request by its outcome (needs the lock).
+func (p *Peer) metadataRequestEvent(outcome models.MetadataRequest) {
+	p.MetadataRequests++
+	if outcome.Timestamp.After(p.LastMetadataAttempt) {
+		p.LastMetadataAttempt = outcome.Timestamp
+	}
+	if outcome.Succeeded() {
+		p.MetadataSuccesses++
+		p.MetadataFailureStreak = 0
+		return
+	}
+	p.LastMetadataError = outcome.ErrorKey()
+	p.MetadataFailureStreak++
+}
+
+// MetadataRequested returns whether any MetaData request was sent to the peer.
+func (p *Peer) MetadataRequested() bool {
+	p.m.RLock()
+	defer p.m.RUnlock()
+	return p.MetadataRequests > 0
+}
+
+// MetadataSucceeded returns whether any MetaData request to the peer succeeded
+// (or its metadata was obtained before the requests were counted).
+func (p *Peer) MetadataSucceeded() bool {
+	p.m.RLock()
+	defer p.m.RUnlock()
+	return p.MetadataSuccesses > 0 || p.MetadataObtained
+}
+
+// FetchIpInfo updates the location of the peer with the stored one of its IP.
+func (p *Peer) FetchIpInfo(ipInfo models.IpInfo) {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	p.fetchIpInfo(ipInfo)
+}
+
+// fetchIpInfo needs the lock.
+func (p *Peer) fetchIpInfo(ipInfo models.IpInfo) {
+	p.fetchLocation(ipInfo.IP, ipInfo.Location())
+	// the IPs located before the provider detection don't have it
+	if ipInfo.Provider != "" {
+		p.Provider = ipInfo.Provider
+	}
+}
+
+// FetchLocation updates the location of the peer with the one resolved for its IP.
+func (p *Peer) FetchLocation(ip string, loc models.Location) {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	p.fetchLocation(ip, loc)
+}
+
+// fetchLocation needs the lock.
+func (p *Peer) fetchLocation(ip string, loc models.Location) {
+	p.Ip = ip
+	p.Country = loc.Country
+	p.CountryCode = loc.CountryCode
+	p.City = loc.City
+	p.ASN = loc.ASN
+	p.LocationSource = loc.Source
+	p.Provider, _ = providers.LookupProvider(ip)
+	p.updateIPIndex()
+}
+
+// ConnectionAttemptEvent tracks a connection attempt made from the crawler to the peer.
+// The errors of the failed attempts are counted, and LastError becomes the most frequent of them
+// (the most recent one on ties), while a successful attempt sets it to its own err (i.e. "none")
+// without dropping the counts.
+func (p *Peer) ConnectionAttemptEvent(succeed bool, err string) {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	p.Attempted = true
+	p.Attempts++
+	p.LastAttempt = time.Now()
+	if succeed {
+		p.Succeed = true
+		p.Deprecated = false
+		p.SuccessfulAttempts++
+		p.SuccessStreak++
+		p.FailureStreak = 0
+		p.LastAttemptError = ""
+	} else {
+		p.FailureStreak++
+		p.SuccessStreak = 0
+		if p.FailureStreak > p.LongestFailureStreak {
+			p.LongestFailureStreak = p.FailureStreak
+		}
+		p.LastAttemptError = err
+		if err != "" {
+			p.ErrorCounts[err]++
+			p.LastErrorTimes[err] = p.LastAttempt
+			err = p.topError()
+		}
+	}
+	p.LastError = err
+	p.updateFunnel()
+}
+
+// topError needs the lock. It returns the most frequent error of the failed attempts, the most recent
+// one on ties, or an empty string if none failed.
+func (p *Peer) topError() string {
+	top := ""
+	for errKey, count := range p.ErrorCounts {
+		topCount := p.ErrorCounts[top]
+		if top == "" || count > topCount ||
+			(count == topCount && p.LastErrorTimes[errKey].After(p.LastErrorTimes[top])) ||
+			(count == topCount && p.LastErrorTimes[errKey].Equal(p.LastErrorTimes[top]) && errKey < top) {
+			top = errKey
+		}
+	}
+	return top
+}
+
+// GetTopError returns the most frequent error of the failed attempts to connect the peer (the most
+// recent one on ties) and its number of occurrences, or an empty string if none failed.
+func (p *Peer) GetTopError() (string, uint64) {
+	p.m.RLock()
+	defer p.m.RUnlock()
+	top := p.topError()
+	return top, p.ErrorCounts[top]
+}
+
+// GetErrorCounts returns a copy of the number of failed attempts per error.
+func (p *Peer) GetErrorCounts() map[string]uint64 {
+	p.m.RLock()
+	defer p.m.RUnlock()
+	counts := make(map[string]uint64, len(p.ErrorCounts))
+	for errKey, count := range p.ErrorCounts {
+		counts[errKey] = count
+	}
+	return counts
+}
+
+// GetLastErrorOf returns the time of the last failed attempt with the given error, zero if it never happened.
+func (p *Peer) GetLastErrorOf(kind string) time.Time {
+	p.m.RLock()
+	defer p.m.RUnlock()
+	return p.LastErrorTimes[kind]
+}
+
+// DeprecationEvent tracks that the crawler gave up connecting the peer.
+func (p *Peer) DeprecationEvent() {
+	p.m.Lock()
+	defer p.m.Unlock()
+	p.Deprecated = true
+}
+
+// IsDeprecated returns whether the crawler gave up connecting the peer.
+func (p *Peer) IsDeprecated() bool {
+	p.m.RLock()
+	defer p.m.RUnlock()
+	return p.Deprecated
+}
+
+// GetFailureStreak returns the number of consecutive failed connection attempts up to the last one.
+func (p *Peer) GetFailureStreak() int {
+	p.m.RLock()
+	defer p.m.RUnlock()
+	return p.FailureStreak
+}
+
+// GetLongestFailureStreak returns the longest run of consecutive failed connection attempts.
+func (p *Peer) GetLongestFailureStreak() int {
+	p.m.RLock()
+	defer p.m.RUnlock()
+	return p.LongestFailureStreak
+}
+
+// GetSuccessStreak returns the number of consecutive succeeded connection attempts up to the last one.
+func (p *Peer) GetSuccessStreak() int {
+	p.m.RLock()
+	defer p.m.RUnlock()
+	return p.SuccessStreak
+}
+
+// ConnectionEvent tracks a new connection with the peer.
+// A connection older than the last disconnection is clamped to it and counted as an anomaly.
+func (p *Peer) ConnectionEvent(t time.Time) {
+	p.ConnectionEventWithAddrs(t, "", "")
+}
+
+// ConnectionEventWithAddrs tracks a new connection with the peer, along with our listen address
+// that it used and the remote address that answered (empty if unknown). The sessions with a known
+// remote address are counted as relayed (through /p2p-circuit) or direct.
+func (p *Peer) ConnectionEventWithAddrs(t time.Time, localAddr, remoteAddr string) {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	if last, ok := lastTime(p.DisconnectionTimes); ok && t.Before(last) {
+		p.timestampAnomaly("connection", t, "last disconnection", last)
+		t = last
+	}
+	p.IsConnected = true
+	p.Deprecated = false
+	p.ConnectionTimes = append(p.ConnectionTimes, t)
+	if localAddr != "" || remoteAddr != "" {
+		p.LastLocalAddr = localAddr
+		p.LastRemoteAddr = remoteAddr
+	}
+	if remoteAddr != "" {
+		p.LastConnectionRelayed = isRelayedAddr(remoteAddr)
+		if p.LastConnectionRelayed {
+			p.RelayedSessions++
+		} else {
+			p.DirectSessions++
+		}
+	}
+	p.updateFunnel()
+}
+
+// isRelayedAddr returns whether the multiaddress goes through a circuit relay.
+func isRelayedAddr(addr string) bool {
+	return strings.Contains(addr, "/p2p-circuit")
+}
+
+// DisconnectionEvent tracks the end of the connection with the peer.
+// A disconnection older than the last connection is clamped to it (zero duration session)
+// and counted as an anomaly.
+// Returns the reason of the Goodbye that the peer sent right before the disconnection (if any).
+func (p *Peer) DisconnectionEvent(t time.Time) string {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	if last, ok := lastTime(p.ConnectionTimes); ok && t.Before(last) {
+		p.timestampAnomaly("disconnection", t, "last connection", last)
+		t = last
+	}
+	p.IsConnected = false
+	p.DisconnectionTimes = append(p.DisconnectionTimes, t)
+	return p.disconnectReason(t)
+}
+
+// timestampAnomaly counts and logs an out-of-order event (needs the lock).
+func (p *Peer) timestampAnomaly(event string, t time.Time, refEvent string, ref time.Time) {
+	p.TimestampAnomalies++
+	log.Debugf("out of order %s of peer %s: %s is before the %s %s",
+		event, p.ID.String(), t.Format(time.RFC3339Nano), refEvent, ref.Format(time.RFC3339Nano))
+}
+
+// ConnectedTime returns the total time that the peer has been connected to us until the given time,
+// pairing each connection with its disconnection (see completedSessions). If the peer is still
+// connected, its open session counts until then.
+func (p *Peer) ConnectedTime(until time.Time) time.Duration {
+	p.m.RLock()
+	defer p.m.RUnlock()
+	return p.connectedTime(until)
+}
+
+// connectedTime returns the total connected time of the peer (needs the lock).
+func (p *Peer) connectedTime(until time.Time) time.Duration {
+	var total time.Duration
+	for _, d := range p.sessions(until) {
+		total += d
+	}
+	return total
+}
+
+// SessionDurations returns the duration of each completed session with the peer.
+func (p *Peer) SessionDurations() []time.Duration {
+	p.m.RLock()
+	defer p.m.RUnlock()
+	return p.completedSessions()
+}
+
+// GetConnectionSessions returns the duration of each session with the peer, in chronological order,
+// including the open one until now if the peer is still connected.
+func (p *Peer) GetConnectionSessions() []time.Duration {
+	p.m.RLock()
+	defer p.m.RUnlock()
+	return p.sessions(time.Now())
+}
+
+// sessions returns the completed sessions of the peer, plus the open one until the given time
+// if the peer is still connected (needs the lock).
+func (p *Peer) sessions(until time.Time) []time.Duration {
+	sessions, openSince, open := p.pairSessions()
+	if open && p.IsConnected {
+		d := until.Sub(openSince)
+		if d < 0 {
+			d = 0
+		}
+		sessions = append(sessions, d)
+	}
+	return sessions
+}
+
+// completedSessions returns the duration of each completed session of the peer (needs the lock).
+// This is the canonical pairing of the sessions, see pairSessions.
+func (p *Peer) completedSessions() []time.Duration {
+	sessions, _, _ := p.pairSessions()
+	return sessions
+}
+
+// pairSessions pairs, in chronological order, each connection with the first disconnection at or after it
+// that no previous connection took (needs the lock). The disconnections without a connection before them
+// are ignored, so they don't shift the pairing of the later sessions. Returns the completed sessions and,
+// if some connection got no disconnection, the latest of them (the disconnections of the earlier ones got
+// lost, so their gap isn't counted as connected time).
+func (p *Peer) pairSessions() (sessions []time.Duration, openSince time.Time, open bool) {
+	conns := sortedTimes(p.ConnectionTimes)
+	disconns := sortedTimes(p.DisconnectionTimes)
+	sessions = make([]time.Duration, 0, len(conns))
+	j := 0
+	for _, conn := range conns {
+		for j < len(disconns) && disconns[j].Before(conn) {
+			j++
+		}
+		if j == len(disconns) {
+			// the later connections can't be paired either, as the disconnections are sorted
+			return sessions, conns[len(conns)-1], true
+		}
+		sessions = append(sessions, disconns[j].Sub(conn))
+		j++
+	}
+	return sessions, time.Time{}, false
+}
+
+// sortedTimes returns a sorted copy of the timestamps.
+func sortedTimes(times []time.Time) []time.Time {
+	sorted := make([]time.Time, len(times))
+	copy(sorted, times)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Before(sorted[j]) })
+	return sorted
+}
+
+// lastTime returns the last timestamp of the list (if any).
+func lastTime(times []time.Time) (time.Time, bool) {
+	if len(times) == 0 {
+		return time.Time{}, false
+	}
+	return times[len(times)-1], true
+}
+
+// MessageEvent tracks a new gossip message received from the peer on the given topic.
+func (p *Peer) MessageEvent(topic string, t time.Time) {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	p.messageEvent(topic, t)
+}
+
+// messageEvent counts the message and returns the metric of its topic (needs the lock).
+func (p *Peer) messageEvent(topic string, t time.Time) *MessageMetric {
+	msgMetric := p.messageMetric(topic)
+	if msgMetric.Count == 0 {
+		msgMetric.FirstMessageTime = t
+		msgMetric.LastMessageTime = t
+	} else {
+		// the first message of the topic has no gap
+		if msgMetric.InterArrival == nil {
+			msgMetric.InterArrival = &GapStats{}
+		}
+		gap := t.Sub(msgMetric.LastMessageTime)
+		msgMetric.InterArrival.Add(gap)
+		// a message from the past doesn't move the last message time back
+		if gap >= 0 {
+			msgMetric.LastMessageTime = t
+		}
+	}
+	msgMetric.Count++
+	msgMetric.HourlyCounts = addHourly(msgMetric.HourlyCounts, t)
+	if TrackMessageRates {
+		if msgMetric.Rates == nil {
+			msgMetric.Rates = &RateWindow{}
+		}
+		msgMetric.Rates.Add(t)
+	}
+	return msgMetric
+}
+
+// messageMetric returns the metric of the topic, creating it if needed (needs the lock).
+func (p *Peer) messageMetric(topic string) *MessageMetric {
+	msgMetric, ok := p.MessageMetrics[topic]
+	if !ok {
+		msgMetric = &MessageMetric{}
+		p.MessageMetrics[topic] = msgMetric
+	}
+	return msgMetric
+}
+
+// MessageBytesEvent adds the size of a message received from the peer on the given topic.
+func (p *Peer) MessageBytesEvent(topic string, size int) {
+	p.m.Lock()
+	defer p.m.Unlock()
+	p.messageMetric(topic).Bytes += int64(size)
+}
+
+// FirstDeliveryEvent tracks a message of the topic that the peer delivered before anyone else.
+func (p *Peer) FirstDeliveryEvent(topic string) {
+	p.m.Lock()
+	defer p.m.Unlock()
+	p.messageMetric(topic).FirstDeliveries++
+}
+
+// DuplicateEvent tracks a message of the topic that the peer sent after someone else delivered it.
+func (p *Peer) DuplicateEvent(topic string) {
+	p.m.Lock()
+	defer p.m.Unlock()
+	p.messageMetric(topic).Duplicates++
+}
+
+// ResetTopicMetrics removes the message metrics of the topic, given by its full name or its short name
+// (i.e. "beacon_block", matching the topic of every fork digest), returning whether any existed.
+// The following messages of the topic start a new metric from zero.
+func (p *Peer) ResetTopicMetrics(shortOrFullTopic string) bool {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	existed := false
+	for topic := range p.MessageMetrics {
+		if topic == shortOrFullTopic || shortTopicName(topic) == shortOrFullTopic {
+			delete(p.MessageMetrics, topic)
+			existed = true
+		}
+	}
+	return existed
+}
+
+// IsActiveSince returns true if the peer is connected, or if it was connected
+// or sent us a message after t.
+func (p *Peer) IsActiveSince(t time.Time) bool {
+	p.m.RLock()
+	defer p.m.RUnlock()
+
+	if p.IsConnected {
+		return true
+	}
+	return p.lastActivity().After(t)
+}
+
+// Deprecable returns true if the peer can be deprecated: it isn't connected nor deprecated yet, it showed
+// no activity after inactiveSince, and (at least) its last minFailures connection attempts failed.
+func (p *Peer) Deprecable(inactiveSince time.Time, minFailures int) bool {
+	p.m.RLock()
+	defer p.m.RUnlock()
+
+	if p.Deprecated || p.IsConnected || p.FailureStreak < minFailures {
+		return false
+	}
+	// a rediscovered peer has to fail again before being deprecated
+	if p.LastAttempt.Before(p.rediscoveredAt) {
+		return false
+	}
+	return !p.lastActivity().After(inactiveSince)
+}
+
+// firstActivity returns the first time we had any interaction with the peer (needs the lock).
+func (p *Peer) firstActivity() time.Time {
+	var first time.Time
+	for _, times := range [][]time.Time{p.ConnectionTimes, p.DisconnectionTimes} {
+		if len(times) > 0 && (first.IsZero() || times[0].Before(first)) {
+			first = times[0]
+		}
+	}
+	for _, msgMetric := range p.MessageMetrics {
+		if !msgMetric.FirstMessageTime.IsZero() && (first.IsZero() || msgMetric.FirstMessageTime.Before(first)) {
+			first = msgMetric.FirstMessageTime
+		}
+	}
+	return first
+}
+
+// lastActivity returns the last time we had any interaction with the peer (needs the lock).
+func (p *Peer) lastActivity() time.Time {
+	var last time.Time
+	for _, times := range [][]time.Time{p.ConnectionTimes, p.DisconnectionTimes} {
+		if len(times) > 0 && times[len(times)-1].After(last) {
+			last = times[len(times)-1]
+		}
+	}
+	for _, msgMetric := range p.MessageMetrics {
+		if msgMetric.LastMessageTime.After(last) {
+			last = msgMetric.LastMessageTime
+		}
+	}
+	return last
+}
+
+// Copy returns a deep copy of the peer, safe to be read without locking.
+func (p *Peer) Copy() *Peer {
+	p.m.RLock()
+	defer p.m.RUnlock()
+
+	cp := &Peer{
+		ID:                    p.ID,
+		Network:               p.Network,
+		MAddrs:                append(make([]ma.Multiaddr, 0, len(p.MAddrs)), p.MAddrs...),
+		RelayAddrs:            append(make([]ma.Multiaddr, 0, len(p.RelayAddrs)), p.RelayAddrs...),
+		RelayOnly:             p.RelayOnly,
+		UserAgent:             p.UserAgent,
+		UserAgentSanitized:    p.UserAgentSanitized,
+		RawUserAgent:          p.RawUserAgent,
+		ClientName:            p.ClientName,
+		ClientVersion:         p.ClientVersion,
+		ClientOS:              p.ClientOS,
+		ClientArch:            p.ClientArch,
+		ClientConfidence:      p.ClientConfidence,
+		PeerCategory:          p.PeerCategory,
+		ProtocolVersion:       p.ProtocolVersion,
+		Protocols:             append(make([]string, 0, len(p.Protocols)), p.Protocols...),
+		Latency:               p.Latency,
+		MetadataObtained:      p.MetadataObtained,
+		MetadataRequests:      p.MetadataRequests,
+		MetadataSuccesses:     p.MetadataSuccesses,
+		LastMetadataAttempt:   p.LastMetadataAttempt,
+		LastMetadataError:     p.LastMetadataError,
+		MetadataFailureStreak: p.MetadataFailureStreak,
+		MetadataAttnets:       append([]byte(nil), p.MetadataAttnets...),
+		EnrAttnets:            append([]byte(nil), p.EnrAttnets...),
+		PubsubVersion:         p.PubsubVersion,
+		Ip:                    p.Ip,
+		Country:               p.Country,
+		CountryCode:           p.CountryCode,
+		City:                  p.City,
+		Provider:              p.Provider,
+		ASN:                   p.ASN,
+		LocationSource:        p.LocationSource,
+		PeersOnSameIP:         p.PeersOnSameIP,
+		Attempted:             p.Attempted,
+		Attempts:              p.Attempts,
+		SuccessfulAttempts:    p.SuccessfulAttempts,
+		Succeed:               p.Succeed,
+		IsConnected:           p.IsConnected,
+		LastError:             p.LastError,
+		LastAttempt:           p.LastAttempt,
+		LastAttemptError:      p.LastAttemptError,
+		DialDelay:             p.DialDelay,
+		DialScore:             p.DialScore,
+		ErrorCounts:           make(map[string]uint64, len(p.ErrorCounts)),
+		LastErrorTimes:        make(map[string]time.Time, len(p.LastErrorTimes)),
+		Deprecated:            p.Deprecated,
+		FailureStreak:         p.FailureStreak,
+		LongestFailureStreak:  p.LongestFailureStreak,
+		SuccessStreak:         p.SuccessStreak,
+		ConnectionTimes:       append(make([]time.Time, 0, len(p.ConnectionTimes)), p.ConnectionTimes...),
+		DisconnectionTimes:    append(make([]time.Time, 0, len(p.DisconnectionTimes)), p.DisconnectionTimes...),
+		TimestampAnomalies:    p.TimestampAnomalies,
+		LastLocalAddr:         p.LastLocalAddr,
+		LastRemoteAddr:        p.LastRemoteAddr,
+		DirectSessions:        p.DirectSessions,
+		RelayedSessions:       p.RelayedSessions,
+		LastConnectionRelayed: p.LastConnectionRelayed,
+		DialbackAttempted:     p.DialbackAttempted,
+		DialbackSucceeded:     p.DialbackSucceeded,
+		DialbackError:         p.DialbackError,
+		DialbackTime:          p.DialbackTime,
+		Bandwidth:             p.Bandwidth.Load(),
+		StatusRequests:        p.StatusRequests,
+		StatusSucceeded:       p.StatusSucceeded,
+		StatusErrors:          make(map[string]int64, len(p.StatusErrors)),
+		LastStatusRequest:     p.LastStatusRequest,
+		StatusFailureStreak:   p.StatusFailureStreak,
+		Goodbyes:              make(map[string]int64, len(p.Goodbyes)),
+		LastGoodbye:           p.LastGoodbye,
+		LastGoodbyeTime:       p.LastGoodbyeTime,
+		LastDisconnectReason:  p.LastDisconnectReason,
+		goodbyePending:        p.goodbyePending,
+		MessageMetrics:        make(map[string]*MessageMetric, len(p.MessageMetrics)),
+		MeshMetrics:           make(map[string]*MeshMetric, len(p.MeshMetrics)),
+	}
+	for errKey, count := range p.ErrorCounts {
+		cp.ErrorCounts[errKey] = count
+	}
+	for errKey, t := range p.LastErrorTimes {
+		cp.LastErrorTimes[errKey] = t
+	}
+	for errKey, count := range p.StatusErrors {
+		cp.StatusErrors[errKey] = count
+	}
+	for reason, count := range p.Goodbyes {
+		cp.Goodbyes[reason] = count
+	}
+	for topic, msgMetric := range p.MessageMetrics {
+		msgCopy := *msgMetric
+		msgCopy.ArrivalDelays = msgMetric.ArrivalDelays.copy()
+		msgCopy.InterArrival = msgMetric.InterArrival.copy()
+		msgCopy.HourlyCounts = append([]HourBucket(nil), msgMetric.HourlyCounts...)
+		msgCopy.Rates = msgMetric.Rates.copy()
+		cp.MessageMetrics[topic] = &msgCopy
+	}
+	for topic, meshMetric := range p.MeshMetrics {
+		meshCopy := *meshMetric
+		cp.MeshMetrics[topic] = &meshCopy
+	}
+	return cp
+}
+
+// GetClientName returns the client name of the peer, or unknown if the peer wasn't identified.
+func (p *Peer) GetClientName() string {
+	p.m.RLock()
+	defer p.m.RUnlock()
+	if p.ClientName == "" {
+		return utils.Unknown
+	}
+	return p.ClientName
+}
+
+// GetCountry returns the country of the peer, or unknown if it wasn't located.
+func (p *Peer) GetCountry() string {
+	p.m.RLock()
+	defer p.m.RUnlock()
+	if p.Country == "" {
+		return utils.Unknown
+	}
+	return p.Country
+}
+
+// peerAlias avoids the recursion of the custom JSON methods
+type peerAlias Peer
+
+type jsonPeer struct {
+	*peerAlias
+	MAddrs []string `json:"maddrs,omitempty"`
+	// derived from the message metrics (ignored when unmarshalling)
+	AttestationMessages uint64  `json:"attestation_messages,omitempty"`
+	AttestationSubnets  int     `json:"attestation_subnets,omitempty"`
+	BlockRate5m         float64 `json:"block_rate_5m,omitempty"`
+	BlockRate60m        float64 `json:"block_rate_60m,omitempty"`
+	AttestationRate5m   float64 `json:"attestation_rate_5m,omitempty"`
+	AttestationRate60m  float64 `json:"attestation_rate_60m,omitempty"`
+}
+
+// MarshalJSON serializes the peer, with the multiaddresses in their string format.
+func (p *Peer) MarshalJSON() ([]byte, error) {
+	p.m.RLock()
+	defer p.m.RUnlock()
+
+	jp := jsonPeer{
+		peerAlias: (*peerAlias)(p),
+	}
+	_, jp.MAddrs = utils.CanonicalMAddrs(p.MAddrs)
+	jp.AttestationMessages, jp.AttestationSubnets = attestationTotals(p.msgCountPerSubnet())
+	now := time.Now()
+	jp.BlockRate5m = p.messageRate(BeaconBlockTopicName, 5*time.Minute, now)
+	jp.BlockRate60m = p.messageRate(BeaconBlockTopicName, 60*time.Minute, now)
+	jp.AttestationRate5m = p.messageRate(AttestationTopicName, 5*time.Minute, now)
+	jp.AttestationRate60m = p.messageRate(AttestationTopicName, 60*time.Minute, now)
+	return json.Marshal(jp)
+}
+
+// UnmarshalJSON fills the peer from its JSON serialization.
+func (p *Peer) UnmarshalJSON(data []byte) error {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	jp := jsonPeer{
+		peerAlias: (*peerAlias)(p),
+	}
+	err := json.Unmarshal(data, &jp)
+	if err != nil {
+		return err
+	}
+	p.MAddrs, _, err = utils.ParseCanonicalMAddrs(jp.MAddrs)
+	if err != nil {
+		return errors.Wrap(err, "unable to parse multiaddress of peer "+p.ID.String())
+	}
+	// the relay addresses are derived from the full list
+	_, p.RelayAddrs = utils.SplitRelayMAddrs(p.MAddrs)
+	if p.ErrorCounts == nil {
+		p.ErrorCounts = make(map[string]uint64)
+	}
+	if p.LastErrorTimes == nil {
+		p.LastErrorTimes = make(map[string]time.Time)
+	}
+	if p.StatusErrors == nil {
+		p.StatusErrors = make(map[string]int64)
+	}
+	if p.Goodbyes == nil {
+		p.Goodbyes = make(map[string]int64)
+	}
+	if p.MessageMetrics == nil {
+		p.MessageMetrics = make(map[string]*MessageMetric)
+	}
+	if p.MeshMetrics == nil {
+		p.MeshMetrics = make(map[string]*MeshMetric)
+	}
+	return nil
+}
+
+// Merge aggregates the info of other (i.e. a restored copy of the same peer) into the peer.
+// The fields that we already have are kept, the missing ones are taken from other,
+// while the counters, connection times and message metrics get accumulated.
+func (p *Peer) Merge(other *Peer) {
+	o := other.Copy()
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	fillString := func(dst *string, src string) {
+		if *dst == "" {
+			*dst = src
+		}
+	}
+	if p.Network == "" {
+		p.Network = o.Network
+	}
+	if len(p.MAddrs) == 0 {
+		p.MAddrs = o.MAddrs
+		p.RelayAddrs = o.RelayAddrs
+		p.RelayOnly = o.RelayOnly
+	}
+	if p.UserAgent == "" {
+		p.UserAgentSanitized = o.UserAgentSanitized
+		p.RawUserAgent = o.RawUserAgent
+	}
+	if p.ClientName == "" {
+		p.ClientConfidence = o.ClientConfidence
+	}
+	fillString(&p.UserAgent, o.UserAgent)
+	fillString(&p.ClientName, o.ClientName)
+	fillString(&p.ClientVersion, o.ClientVersion)
+	fillString(&p.ClientOS, o.ClientOS)
+	fillString(&p.ClientArch, o.ClientArch)
+	fillString(&p.PeerCategory, o.PeerCategory)
+	fillString(&p.ProtocolVersion, o.ProtocolVersion)
+	if len(p.Protocols) == 0 {
+		p.Protocols = o.Protocols
+	}
+	if p.Latency == 0 {
+		p.Latency = o.Latency
+	}
+	p.MetadataObtained = p.MetadataObtained || o.MetadataObtained
+	p.MetadataRequests += o.MetadataRequests
+	p.MetadataSuccesses += o.MetadataSuccesses
+	// the last metadata error and the failure streak belong to the record with the latest request
+	if o.LastMetadataAttempt.After(p.LastMetadataAttempt) {
+		p.LastMetadataAttempt = o.LastMetadataAttempt
+		p.MetadataFailureStreak = o.MetadataFailureStreak
+		if o.LastMetadataError != "" {
+			p.LastMetadataError = o.LastMetadataError
+		}
+	}
+	fillString(&p.LastMetadataError, o.LastMetadataError)
+	if len(p.MetadataAttnets) == 0 {
+		p.MetadataAttnets = o.MetadataAttnets
+	}
+	if len(p.EnrAttnets) == 0 {
+		p.EnrAttnets = o.EnrAttnets
+	}
+	fillString(&p.PubsubVersion, o.PubsubVersion)
+	fillString(&p.Ip, o.Ip)
+	fillString(&p.Country, o.Country)
+	fillString(&p.CountryCode, o.CountryCode)
+	fillString(&p.City, o.City)
+	fillString(&p.Provider, o.Provider)
+	fillString(&p.ASN, o.ASN)
+	fillString(&p.LocationSource, o.LocationSource)
+	fillString(&p.LastError, o.LastError)
+
+	p.Attempted = p.Attempted || o.Attempted
+	p.Succeed = p.Succeed || o.Succeed
+	p.Attempts += o.Attempts
+	p.SuccessfulAttempts += o.SuccessfulAttempts
+	// the current streaks and deprecation belong to the record with the latest attempt
+	if o.LastAttempt.After(p.LastAttempt) {
+		p.LastAttempt = o.LastAttempt
+		p.LastAttemptError = o.LastAttemptError
+		p.DialDelay = o.DialDelay
+		p.DialScore = o.DialScore
+		p.FailureStreak = o.FailureStreak
+		p.SuccessStreak = o.SuccessStreak
+		p.Deprecated = o.Deprecated
+	}
+	if o.LongestFailureStreak > p.LongestFailureStreak {
+		p.LongestFailureStreak = o.LongestFailureStreak
+	}
+	for errKey, count := range o.ErrorCounts {
+		p.ErrorCounts[errKey] += count
+	}
+	for errKey, t := range o.LastErrorTimes {
+		if t.After(p.LastErrorTimes[errKey]) {
+			p.LastErrorTimes[errKey] = t
+		}
+	}
+	// while the latest attempt failed, the error is the top one of the merged counts
+	if p.FailureStreak > 0 && len(p.ErrorCounts) > 0 {
+		p.LastError = p.topError()
+	}
+	p.TimestampAnomalies += o.TimestampAnomalies
+	p.StatusRequests += o.StatusRequests
+	p.StatusSucceeded += o.StatusSucceeded
+	for errKey, count := range o.StatusErrors {
+		p.StatusErrors[errKey] += count
+	}
+	if o.LastStatusRequest.After(p.LastStatusRequest) {
+		p.LastStatusRequest = o.LastStatusRequest
+		p.StatusFailureStreak = o.StatusFailureStreak
+	}
+	for reason, count := range o.Goodbyes {
+		p.Goodbyes[reason] += count
+	}
+	// the last goodbye belongs to the record that received it the latest
+	if o.LastGoodbyeTime.After(p.LastGoodbyeTime) {
+		p.LastGoodbye = o.LastGoodbye
+		p.LastGoodbyeTime = o.LastGoodbyeTime
+		p.LastDisconnectReason = o.LastDisconnectReason
+	}
+	// the addresses belong to the record with the latest connection
+	if oLast, ok := lastTime(o.ConnectionTimes); ok && o.LastRemoteAddr != "" {
+		if pLast, ok := lastTime(p.ConnectionTimes); !ok || p.LastRemoteAddr == "" || oLast.After(pLast) {
+			p.LastLocalAddr = o.LastLocalAddr
+			p.LastRemoteAddr = o.LastRemoteAddr
+			p.LastConnectionRelayed = o.LastConnectionRelayed
+		}
+	}
+	// the dial-back belongs to the record with the latest one
+	if o.DialbackAttempted && o.DialbackTime.After(p.DialbackTime) {
+		p.DialbackAttempted = o.DialbackAttempted
+		p.DialbackSucceeded = o.DialbackSucceeded
+		p.DialbackError = o.DialbackError
+		p.DialbackTime = o.DialbackTime
+	}
+	p.DirectSessions += o.DirectSessions
+	p.RelayedSessions += o.RelayedSessions
+	p.Bandwidth.add(o.Bandwidth.Load())
+	p.ConnectionTimes = mergeTimes(o.ConnectionTimes, p.ConnectionTimes)
+	p.DisconnectionTimes = mergeTimes(o.DisconnectionTimes, p.DisconnectionTimes)
+
+	for topic, oMetric := range o.MessageMetrics {
+		msgMetric, ok := p.MessageMetrics[topic]
+		if !ok {
+			p.MessageMetrics[topic] = oMetric
+			continue
+		}
+		msgMetric.Count += oMetric.Count
+		msgMetric.Bytes += oMetric.Bytes
+		msgMetric.FirstDeliveries += oMetric.FirstDeliveries
+		msgMetric.Duplicates += oMetric.Duplicates
+		msgMetric.FirstSeenCount += oMetric.FirstSeenCount
+		msgMetric.DuplicateCount += oMetric.DuplicateCount
+		// metrics with only deliveries don't have message times
+		if !oMetric.FirstMessageTime.IsZero() &&
+			(msgMetric.FirstMessageTime.IsZero() || oMetric.FirstMessageTime.Before(msgMetric.FirstMessageTime)) {
+			msgMetric.FirstMessageTime = oMetric.FirstMessageTime
+		}
+		if oMetric.LastMessageTime.After(msgMetric.LastMessageTime) {
+			msgMetric.LastMessageTime = oMetric.LastMessageTime
+		}
+		if oMetric.ArrivalDelays != nil {
+			if msgMetric.ArrivalDelays == nil {
+				msgMetric.ArrivalDelays = &DelayStats{}
+			}
+			msgMetric.ArrivalDelays.merge(oMetric.ArrivalDelays)
+		}
+		if oMetric.InterArrival != nil {
+			if msgMetric.InterArrival == nil {
+				msgMetric.InterArrival = &GapStats{}
+			}
+			msgMetric.InterArrival.merge(oMetric.InterArrival)
+		}
+		msgMetric.HourlyCounts = mergeHourly(msgMetric.HourlyCounts, oMetric.HourlyCounts)
+		if oMetric.Rates != nil {
+			if msgMetric.Rates == nil {
+				msgMetric.Rates = &RateWindow{}
+			}
+			msgMetric.Rates.merge(oMetric.Rates)
+		}
+	}
+
+	for topic, oMetric := range o.MeshMetrics {
+		meshMetric, ok := p.MeshMetrics[topic]
+		if !ok {
+			p.MeshMetrics[topic] = oMetric
+			continue
+		}
+		meshMetric.Grafts += oMetric.Grafts
+		meshMetric.Prunes += oMetric.Prunes
+		meshMetric.TotalTime += oMetric.TotalTime
+	}
+	p.updateFunnel()
+	p.updateIPIndex()
+}
+
+// mergeTimes returns the sorted union of both lists of timestamps.
+func mergeTimes(a, b []time.Time) []time.Time {
+	merged := make([]time.Time, 0, len(a)+len(b))
+	merged = append(merged, a...)
+	merged = append(merged, b...)
+	sort.Slice(merged, func(i, j int) bool {
+		return merged[i].Before(merged[j])
+	})
+	return merged
+}
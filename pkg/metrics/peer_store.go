@@ -0,0 +1,480 @@
+package metrics
+
+import (
+	"hash/fnv"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/migalabs/armiarma/pkg/db/models"
+	"github.com/migalabs/armiarma/pkg/utils"
+)
+
+// DefaultPeerStoreShards is the number of shards of the PeerStore.
+const DefaultPeerStoreShards = 64
+
+// peerShard is a subset of the peers of the store, with its own lock.
+type peerShard struct {
+	m     sync.RWMutex
+	peers map[peer.ID]*Peer
+}
+
+// PeerStore keeps in memory the Peer summary of every peer that the crawler interacted with.
+// The peers are split into shards by the hash of their peer.ID, so that the events of different
+// peers don't contend for the same lock. The lock of the store only guards its settings.
+type PeerStore struct {
+	m      sync.RWMutex
+	shards []*peerShard
+
+	// slot clock of the crawled network (if any), to measure the arrival delays
+	slotClock *SlotClock
+	// client versions seen so far (if any)
+	clientVersions *ClientVersionTracker
+	// whether the non-Ethereum libp2p peers count in the client distribution
+	includeOtherLibp2p bool
+	// weights of the peer quality scores
+	qualityWeights QualityWeights
+	// backoff of the Status and MetaData requests to the peers
+	reqBackoff RequestBackoff
+	// backoff of the connection attempts to the peers
+	dialBackoff DialBackoff
+	// last time the whole store was checkpointed or exported, and the peers evicted so far (atomic)
+	syncedAt  time.Time
+	evictions int64
+	// peers of the store in each stage of the crawl funnel
+	funnel funnelCounters
+	// message IDs delivered by the peers of the store, to tell the first deliveries
+	seenMessages *SeenMessages
+	// peers of the store by IP, to backfill the location of the peers on a located IP
+	ipIndex *peerIPIndex
+
+	// name of the crawled network, reported in the export metadata
+	network string
+}
+
+// NewPeerStore returns an empty PeerStore.
+func NewPeerStore() *PeerStore {
+	return newShardedPeerStore(DefaultPeerStoreShards)
+}
+
+// newShardedPeerStore returns an empty PeerStore with the given number of shards (at least one).
+func newShardedPeerStore(shards int) *PeerStore {
+	if shards < 1 {
+		shards = 1
+	}
+	s := &PeerStore{
+		shards:         make([]*peerShard, shards),
+		qualityWeights: DefaultQualityWeights,
+		reqBackoff:     DefaultRequestBackoff,
+		dialBackoff:    DefaultDialBackoff.Copy(),
+		seenMessages:   NewSeenMessages(DefaultSeenMessagesWindow, DefaultSeenMessagesCapacity),
+		ipIndex:        newPeerIPIndex(),
+	}
+	for i := range s.shards {
+		s.shards[i] = &peerShard{
+			peers: make(map[peer.ID]*Peer),
+		}
+	}
+	return s
+}
+
+// shard returns the shard of the given peer.ID.
+func (s *PeerStore) shard(pid peer.ID) *peerShard {
+	if len(s.shards) == 1 {
+		return s.shards[0]
+	}
+	h := fnv.New32a()
+	h.Write([]byte(pid))
+	return s.shards[h.Sum32()%uint32(len(s.shards))]
+}
+
+// GetOrCreatePeer returns the Peer of the given peer.ID, adding a new one if it wasn't in the store yet.
+func (s *PeerStore) GetOrCreatePeer(pid peer.ID) *Peer {
+	sh := s.shard(pid)
+	sh.m.RLock()
+	p, ok := sh.peers[pid]
+	sh.m.RUnlock()
+	if ok {
+		return p
+	}
+
+	sh.m.Lock()
+	defer sh.m.Unlock()
+	// it could have been added since we checked
+	p, ok = sh.peers[pid]
+	if !ok {
+		p = NewPeer(pid)
+		p.funnel = &s.funnel
+		p.seenMessages = s.seenMessages
+		p.ipIndex = s.ipIndex
+		p.updateFunnel()
+		sh.peers[pid] = p
+	}
+	return p
+}
+
+// GetPeer returns the Peer of the given peer.ID if it exists in the store.
+func (s *PeerStore) GetPeer(pid peer.ID) (*Peer, bool) {
+	sh := s.shard(pid)
+	sh.m.RLock()
+	defer sh.m.RUnlock()
+
+	p, ok := sh.peers[pid]
+	return p, ok
+}
+
+// removePeer removes the peer from the store if check allows it (nil always does), returning
+// whether it was removed.
+func (s *PeerStore) removePeer(pid peer.ID, check func(*Peer) bool) bool {
+	sh := s.shard(pid)
+	sh.m.Lock()
+	defer sh.m.Unlock()
+
+	p, ok := sh.peers[pid]
+	if !ok || (check != nil && !check(p)) {
+		return false
+	}
+	delete(sh.peers, pid)
+	p.leaveFunnel()
+	p.leaveIPIndex()
+	return true
+}
+
+// Len returns the number of peers in the store.
+func (s *PeerStore) Len() int {
+	total := 0
+	for _, sh := range s.shards {
+		sh.m.RLock()
+		total += len(sh.peers)
+		sh.m.RUnlock()
+	}
+	return total
+}
+
+// SetSlotClock sets the slot clock of the crawled network.
+func (s *PeerStore) SetSlotClock(clock *SlotClock) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.slotClock = clock
+}
+
+// SetNetwork sets the name of the crawled network (i.e. "mainnet").
+func (s *PeerStore) SetNetwork(network string) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.network = network
+}
+
+// Network returns the name of the crawled network, empty if it wasn't set.
+func (s *PeerStore) Network() string {
+	s.m.RLock()
+	defer s.m.RUnlock()
+	return s.network
+}
+
+// SlotClock returns the slot clock of the crawled network, nil if it wasn't set.
+func (s *PeerStore) SlotClock() *SlotClock {
+	s.m.RLock()
+	defer s.m.RUnlock()
+	return s.slotClock
+}
+
+// PeerFilter selects the peers that match a given condition.
+type PeerFilter func(*Peer) bool
+
+// ForEachPeer calls fn for every peer in the store until fn returns false.
+// The keys are snapshotted first, shard by shard, so no shard is locked while fn runs.
+// Peers added during the iteration are not visited, and the removed ones are skipped.
+func (s *PeerStore) ForEachPeer(fn func(*Peer) bool) {
+	pids := make([]peer.ID, 0, s.Len())
+	for _, sh := range s.shards {
+		sh.m.RLock()
+		for pid := range sh.peers {
+			pids = append(pids, pid)
+		}
+		sh.m.RUnlock()
+	}
+
+	for _, pid := range pids {
+		p, ok := s.GetPeer(pid)
+		if !ok {
+			continue
+		}
+		if !fn(p) {
+			return
+		}
+	}
+}
+
+// SelectPeers returns a copy of the peers that match all the given filters.
+func (s *PeerStore) SelectPeers(filters ...PeerFilter) []*Peer {
+	peers := make([]*Peer, 0)
+	s.ForEachPeer(func(p *Peer) bool {
+		if matchFilters(p, filters) {
+			peers = append(peers, p.Copy())
+		}
+		return true
+	})
+	return peers
+}
+
+func matchFilters(p *Peer, filters []PeerFilter) bool {
+	for _, filter := range filters {
+		if !filter(p) {
+			return false
+		}
+	}
+	return true
+}
+
+// FilterConnected selects the peers that are currently connected.
+func FilterConnected(p *Peer) bool {
+	p.m.RLock()
+	defer p.m.RUnlock()
+	return p.IsConnected
+}
+
+// FilterClient selects the peers identified with the given client name.
+func FilterClient(name string) PeerFilter {
+	return func(p *Peer) bool {
+		p.m.RLock()
+		defer p.m.RUnlock()
+		return p.ClientName == name
+	}
+}
+
+// FilterCountry selects the peers located in the given country (name or code).
+func FilterCountry(country string) PeerFilter {
+	return func(p *Peer) bool {
+		p.m.RLock()
+		defer p.m.RUnlock()
+		return p.Country == country || p.CountryCode == country
+	}
+}
+
+// FilterRelayOnly selects the peers that are only reachable through circuit-relay addresses.
+func FilterRelayOnly(p *Peer) bool {
+	p.m.RLock()
+	defer p.m.RUnlock()
+	return p.RelayOnly
+}
+
+// FilterActiveSince selects the peers that showed any activity after t.
+func FilterActiveSince(t time.Time) PeerFilter {
+	return func(p *Peer) bool {
+		return p.IsActiveSince(t)
+	}
+}
+
+// ConnectionStats returns the number of peers that the crawler attempted to connect,
+// the ones that were connected at least once, and the ones that are currently connected.
+func (s *PeerStore) ConnectionStats() (attempted, connected, currentlyConnected int) {
+	s.ForEachPeer(func(p *Peer) bool {
+		p.m.RLock()
+		defer p.m.RUnlock()
+		if p.Attempted {
+			attempted++
+		}
+		if p.Succeed || len(p.ConnectionTimes) > 0 {
+			connected++
+		}
+		if p.IsConnected {
+			currentlyConnected++
+		}
+		return true
+	})
+	return attempted, connected, currentlyConnected
+}
+
+// RelayOnlyCount returns the number of peers that are only reachable through circuit-relay addresses.
+func (s *PeerStore) RelayOnlyCount() int {
+	count := 0
+	s.ForEachPeer(func(p *Peer) bool {
+		if FilterRelayOnly(p) {
+			count++
+		}
+		return true
+	})
+	return count
+}
+
+// SetIncludeOtherLibp2p sets whether the non-Ethereum libp2p peers (IPFS, Filecoin, etc)
+// are included in the client distribution, which by default they aren't.
+func (s *PeerStore) SetIncludeOtherLibp2p(include bool) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.includeOtherLibp2p = include
+}
+
+// ClientDistribution returns the number of identified peers per client name (the deprecated ones aren't counted).
+func (s *PeerStore) ClientDistribution() map[string]int {
+	s.m.RLock()
+	includeOthers := s.includeOtherLibp2p
+	s.m.RUnlock()
+	dist := make(map[string]int)
+	s.ForEachPeer(func(p *Peer) bool {
+		p.m.RLock()
+		defer p.m.RUnlock()
+		if p.Deprecated || !includeOthers && p.PeerCategory == string(utils.OtherLibp2pCategory) {
+			return true
+		}
+		if p.ClientName != "" {
+			dist[p.ClientName]++
+		}
+		return true
+	})
+	return dist
+}
+
+// ClientStatusSuccessRate returns the ratio of succeeded Status requests per client name,
+// aggregating the requests sent to all the identified peers of each client.
+func (s *PeerStore) ClientStatusSuccessRate() map[string]float64 {
+	requests := make(map[string]int64)
+	succeeded := make(map[string]int64)
+	s.ForEachPeer(func(p *Peer) bool {
+		p.m.RLock()
+		defer p.m.RUnlock()
+		if p.ClientName == "" || p.StatusRequests == 0 {
+			return true
+		}
+		requests[p.ClientName] += p.StatusRequests
+		succeeded[p.ClientName] += p.StatusSucceeded
+		return true
+	})
+	rates := make(map[string]float64, len(requests))
+	for cliName, reqs := range requests {
+		rates[cliName] = float64(succeeded[cliName]) / float64(reqs)
+	}
+	return rates
+}
+
+// CountryDistribution returns the number of located peers per country name (the deprecated ones aren't counted).
+// The peers are grouped by the ISO code of the country (if known), as the names differ between providers,
+// and each group is reported under the shortest (alphabetically first) name seen for it.
+func (s *PeerStore) CountryDistribution() map[string]int {
+	counts := make(map[string]int)
+	names := make(countryNames)
+	s.ForEachPeer(func(p *Peer) bool {
+		p.m.RLock()
+		defer p.m.RUnlock()
+		key := p.countryKey()
+		if key == "" || p.Deprecated {
+			return true
+		}
+		counts[key]++
+		names.add(key, p.Country)
+		return true
+	})
+	dist := make(map[string]int, len(counts))
+	for key, count := range counts {
+		dist[names.name(key)] += count
+	}
+	return dist
+}
+
+// countryKey returns the key that groups the peer by country: the ISO code of the country if known,
+// or its name otherwise. Empty if the peer wasn't located (needs the lock).
+func (p *Peer) countryKey() string {
+	if p.CountryCode != "" {
+		return p.CountryCode
+	}
+	return p.Country
+}
+
+// countryNames keeps the shortest (alphabetically first) name seen for each country key.
+type countryNames map[string]string
+
+func (n countryNames) add(key, name string) {
+	if current := n[key]; name != "" && (current == "" || name < current) {
+		n[key] = name
+	}
+}
+
+// name returns the name of the country, or the key if no name was seen for it.
+func (n countryNames) name(key string) string {
+	if name := n[key]; name != "" {
+		return name
+	}
+	return key
+}
+
+// MessageTotals returns the number of messages received per topic from all the peers.
+func (s *PeerStore) MessageTotals() map[string]int64 {
+	totals := make(map[string]int64)
+	s.ForEachPeer(func(p *Peer) bool {
+		p.m.RLock()
+		defer p.m.RUnlock()
+		for topic, msgMetric := range p.MessageMetrics {
+			totals[topic] += msgMetric.Count
+		}
+		return true
+	})
+	return totals
+}
+
+// ResetTopicMetricsAll resets the message metrics of the topic on every peer of the store
+// (see Peer.ResetTopicMetrics), returning the number of peers that had them.
+func (s *PeerStore) ResetTopicMetricsAll(topic string) int {
+	reset := 0
+	s.ForEachPeer(func(p *Peer) bool {
+		if p.ResetTopicMetrics(topic) {
+			reset++
+		}
+		return true
+	})
+	return reset
+}
+
+// GroupPeersByIP returns the peer IDs (as strings) that share each public IP.
+// Peers without a known IP, or on a private, loopback or link-local one, are ignored
+// (they share it with unrelated peers of their own networks).
+func (s *PeerStore) GroupPeersByIP() map[string][]string {
+	groups := make(map[string][]string)
+	s.ForEachPeer(func(p *Peer) bool {
+		p.m.RLock()
+		defer p.m.RUnlock()
+		if utils.IsIPPublic(net.ParseIP(p.Ip)) {
+			groups[p.Ip] = append(groups[p.Ip], p.ID.String())
+		}
+		return true
+	})
+	return groups
+}
+
+// LocatePeers updates the location of the peers on the located IP, i.e. the ones identified
+// before their IP got located. Only the peers on the IP are visited (see peerIPIndex), so it can
+// run on every located IP. Returns the number of updated peers.
+func (s *PeerStore) LocatePeers(ipInfo models.IpInfo) int {
+	if ipInfo.IP == "" {
+		return 0
+	}
+	var located int
+	for _, pid := range s.ipIndex.lookup(ipInfo.IP) {
+		p, ok := s.GetPeer(pid)
+		if !ok {
+			continue
+		}
+		p.m.Lock()
+		// it could have moved to another IP since the lookup
+		if p.Ip == ipInfo.IP {
+			p.fetchIpInfo(ipInfo)
+			located++
+		}
+		p.m.Unlock()
+	}
+	return located
+}
+
+// RefreshPeersOnSameIP updates the PeersOnSameIP field of every peer in the store.
+// Returns the grouping that was used, so that it can be reused by the caller.
+func (s *PeerStore) RefreshPeersOnSameIP() map[string][]string {
+	groups := s.GroupPeersByIP()
+	s.ForEachPeer(func(p *Peer) bool {
+		p.m.Lock()
+		defer p.m.Unlock()
+		p.PeersOnSameIP = len(groups[p.Ip])
+		return true
+	})
+	return groups
+}
@@ -0,0 +1,56 @@
+package metrics
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// The peer store benchmarks compare the store with a single lock (one shard) against the
+// sharded one, with concurrent readers and writers, i.e.:
+//
+//	go test ./pkg/metrics/ -run XXX -bench BenchmarkPeerStore -cpu 1,4,16
+
+const (
+	// peers in the store before the benchmark starts
+	benchStorePeers = 10000
+	// one of each benchNewPeerEvery operations adds a new peer (write), the rest look up existing ones
+	benchNewPeerEvery = 10
+)
+
+func BenchmarkPeerStore(b *testing.B) {
+	for _, shards := range []int{1, DefaultPeerStoreShards} {
+		name := "sharded"
+		if shards == 1 {
+			name = "single-lock"
+		}
+		b.Run(name, func(b *testing.B) {
+			store := newShardedPeerStore(shards)
+			pids := make([]peer.ID, benchStorePeers)
+			for i := range pids {
+				pids[i] = peer.ID(fmt.Sprintf("bench-peer-%d", i))
+				store.GetOrCreatePeer(pids[i])
+			}
+			var newPeers int64
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				i := 0
+				for pb.Next() {
+					i++
+					if i%benchNewPeerEvery == 0 {
+						store.GetOrCreatePeer(peer.ID(fmt.Sprintf("bench-new-%d", atomic.AddInt64(&newPeers, 1))))
+						continue
+					}
+					pid := pids[i%len(pids)]
+					if i%2 == 0 {
+						store.GetPeer(pid)
+					} else {
+						store.GetOrCreatePeer(pid)
+					}
+				}
+			})
+		})
+	}
+}
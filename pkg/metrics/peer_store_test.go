@@ -0,0 +1,303 @@
+package metrics
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/migalabs/armiarma/pkg/db/models"
+	"github.com/migalabs/armiarma/pkg/utils"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	testPeerIDsM sync.Mutex
+	testPeerIDs  = make(map[string]peer.ID)
+)
+
+// testPeerID returns a valid peer.ID that is always the same for the given name.
+func testPeerID(name string) peer.ID {
+	testPeerIDsM.Lock()
+	defer testPeerIDsM.Unlock()
+
+	if pid, ok := testPeerIDs[name]; ok {
+		return pid
+	}
+	_, pubKey, err := crypto.GenerateEd25519Key(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	pid, err := peer.IDFromPublicKey(pubKey)
+	if err != nil {
+		panic(err)
+	}
+	testPeerIDs[name] = pid
+	return pid
+}
+
+func newTestPeerStore() *PeerStore {
+	store := NewPeerStore()
+	t0 := time.Unix(1000, 0)
+
+	seeds := []struct {
+		client    string
+		country   string
+		connected bool
+		lastConn  time.Time
+	}{
+		{"prysm", "Germany", true, t0},
+		{"prysm", "France", false, t0.Add(time.Hour)},
+		{"lighthouse", "Germany", true, t0.Add(2 * time.Hour)},
+		{"lighthouse", "Germany", false, t0},
+		{"teku", "Japan", false, time.Time{}},
+	}
+	for i, seed := range seeds {
+		p := store.GetOrCreatePeer(testPeerID(fmt.Sprintf("peer%d", i)))
+		p.ClientName = seed.client
+		p.Country = seed.country
+		if !seed.lastConn.IsZero() {
+			p.ConnectionEvent(seed.lastConn)
+			if !seed.connected {
+				p.DisconnectionEvent(seed.lastConn.Add(time.Minute))
+			}
+		}
+	}
+	return store
+}
+
+func Test_PeerStoreFilters(t *testing.T) {
+	store := newTestPeerStore()
+	t0 := time.Unix(1000, 0)
+
+	require.Equal(t, 5, len(store.SelectPeers()))
+	require.Equal(t, 2, len(store.SelectPeers(FilterConnected)))
+	require.Equal(t, 2, len(store.SelectPeers(FilterClient("prysm"))))
+	require.Equal(t, 3, len(store.SelectPeers(FilterCountry("Germany"))))
+	require.Equal(t, 2, len(store.SelectPeers(FilterClient("lighthouse"), FilterCountry("Germany"))))
+	require.Equal(t, 1, len(store.SelectPeers(FilterClient("lighthouse"), FilterCountry("Germany"), FilterConnected)))
+	require.Equal(t, 0, len(store.SelectPeers(FilterClient("teku"), FilterConnected)))
+
+	// connected peers are always active, the rest depend on their last activity
+	active := store.SelectPeers(FilterActiveSince(t0.Add(30 * time.Minute)))
+	require.Equal(t, 3, len(active))
+}
+
+func Test_PeerStoreSelectReturnsCopies(t *testing.T) {
+	store := newTestPeerStore()
+
+	selected := store.SelectPeers(FilterClient("teku"))
+	require.Equal(t, 1, len(selected))
+	selected[0].ClientName = "modified"
+	selected[0].MessageEvent("topic", time.Now())
+
+	p, ok := store.GetPeer(selected[0].ID)
+	require.True(t, ok)
+	require.Equal(t, "teku", p.GetClientName())
+	require.Equal(t, 0, len(p.MessageMetrics))
+}
+
+func Test_PeerStoreMutationDuringIteration(t *testing.T) {
+	store := newTestPeerStore()
+
+	// adding peers and updating them from the iteration doesn't deadlock,
+	// and new peers are not visited in the ongoing iteration
+	visited := 0
+	store.ForEachPeer(func(p *Peer) bool {
+		visited++
+		p.MessageEvent("topic", time.Now())
+		store.GetOrCreatePeer(testPeerID(fmt.Sprintf("new-%s", p.ID)))
+		return true
+	})
+	require.Equal(t, 5, visited)
+	require.Equal(t, 10, store.Len())
+
+	// stop the iteration early
+	visited = 0
+	store.ForEachPeer(func(p *Peer) bool {
+		visited++
+		return visited < 3
+	})
+	require.Equal(t, 3, visited)
+
+	// concurrent writers while iterating
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				p := store.GetOrCreatePeer(testPeerID(fmt.Sprintf("writer%d-%d", w, i)))
+				p.ConnectionEvent(time.Now())
+				p.MessageEvent("topic", time.Now())
+			}
+		}(w)
+	}
+	for i := 0; i < 10; i++ {
+		store.SelectPeers(FilterConnected)
+		store.MessageTotals()
+	}
+	wg.Wait()
+	require.Equal(t, 410, store.Len())
+}
+
+func Test_PeerConcurrentEvents(t *testing.T) {
+	store := NewPeerStore()
+	peers := make([]*Peer, 4)
+	for i := range peers {
+		peers[i] = store.GetOrCreatePeer(testPeerID(fmt.Sprintf("busy-peer%d", i)))
+	}
+
+	// 32 goroutines counting messages on the same peers, while other ones update their
+	// connections and host info, and the store gets exported
+	const writers, msgs = 32, 200
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < msgs; i++ {
+				peers[i%len(peers)].MessageEvent(fmt.Sprintf("/eth2/4a26c58b/beacon_attestation_%d/ssz_snappy", w%4), time.Now())
+			}
+		}(w)
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 50; i++ {
+			p := peers[i%len(peers)]
+			p.ConnectionEvent(time.Now())
+			p.FetchHostInfo(models.NewHostInfo(p.ID, utils.EthereumNetwork, models.WithIPAndPorts("10.0.0.1", 9000)))
+			p.DisconnectionEvent(time.Now())
+		}
+	}()
+	exportErrs := make(chan error, 10)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 5; i++ {
+			var buf bytes.Buffer
+			exportErrs <- store.ExportCsv(&buf)
+			exportErrs <- store.ExportJson(&buf, NdjsonFormat)
+			for _, p := range peers {
+				p.ToCsvRecord()
+				p.GetNumOfMsgFromTopic("beacon_attestation")
+			}
+		}
+	}()
+	wg.Wait()
+	close(exportErrs)
+	for err := range exportErrs {
+		require.NoError(t, err)
+	}
+
+	var total int64
+	for _, p := range peers {
+		total += p.GetNumOfMsgFromTopic("beacon_attestation")
+		require.Equal(t, "10.0.0.1", p.Copy().Ip)
+	}
+	require.Equal(t, int64(writers*msgs), total)
+}
+
+func Test_PeerStoreCountryDistribution(t *testing.T) {
+	store := NewPeerStore()
+	seeds := []struct {
+		country     string
+		countryCode string
+	}{
+		{"United States of America", "US"},
+		{"United States", "US"},
+		{"United States", "US"},
+		{"Germany", "DE"},
+		// located by a provider without codes
+		{"France", ""},
+		// not located
+		{"", ""},
+	}
+	for i, seed := range seeds {
+		p := store.GetOrCreatePeer(testPeerID(fmt.Sprintf("country-peer%d", i)))
+		p.Country = seed.country
+		p.CountryCode = seed.countryCode
+	}
+
+	dist := store.CountryDistribution()
+	require.Equal(t, 3, len(dist))
+	require.Equal(t, 3, dist["United States"])
+	require.Equal(t, 1, dist["Germany"])
+	require.Equal(t, 1, dist["France"])
+}
+
+func Test_PeerStoreLocatePeers(t *testing.T) {
+	store := NewPeerStore()
+	for i, ip := range []string{"95.217.33.10", "95.217.33.10", "18.223.219.100", ""} {
+		pid := testPeerID(fmt.Sprintf("located-peer%d", i))
+		store.GetOrCreatePeer(pid).FetchHostInfo(models.NewHostInfo(pid, utils.EthereumNetwork, models.WithIPAndPorts(ip, 9000)))
+	}
+
+	// the peers identified before their IP got located get its location
+	ipInfo := models.IpInfo{IpApiMsg: models.IpApiMsg{IP: "95.217.33.10", Country: "Finland", CountryCode: "FI", City: "Helsinki"}}
+	require.Equal(t, 2, store.LocatePeers(ipInfo))
+	require.Equal(t, map[string]int{"Finland": 2}, store.CountryDistribution())
+	p, _ := store.GetPeer(testPeerID("located-peer0"))
+	require.Equal(t, "Helsinki", p.City)
+	p, _ = store.GetPeer(testPeerID("located-peer2"))
+	require.Equal(t, utils.Unknown, p.GetCountry())
+
+	require.Equal(t, 0, store.LocatePeers(models.IpInfo{IpApiMsg: models.IpApiMsg{IP: "10.0.0.1"}}))
+	require.Equal(t, 0, store.LocatePeers(models.IpInfo{}))
+
+	// the peers that moved to another IP, or left the store, are no longer on the located one
+	moved := testPeerID("located-peer1")
+	p, _ = store.GetPeer(moved)
+	p.FetchHostInfo(models.NewHostInfo(moved, utils.EthereumNetwork, models.WithIPAndPorts("18.223.219.100", 9000)))
+	store.removePeer(testPeerID("located-peer0"), nil)
+	require.Equal(t, 0, store.LocatePeers(ipInfo))
+	usInfo := models.IpInfo{IpApiMsg: models.IpApiMsg{IP: "18.223.219.100", Country: "United States", CountryCode: "US"}}
+	require.Equal(t, 2, store.LocatePeers(usInfo))
+	require.Empty(t, store.ipIndex.lookup("95.217.33.10"))
+}
+
+func Test_PeerStoreClientDistributionCategories(t *testing.T) {
+	store := NewPeerStore()
+	seeds := []struct {
+		client   string
+		category utils.PeerCategory
+	}{
+		{"lighthouse", utils.EthConsensusCategory},
+		{"prysm", utils.EthConsensusCategory},
+		{"kubo", utils.OtherLibp2pCategory},
+		{"lotus", utils.OtherLibp2pCategory},
+	}
+	for i, seed := range seeds {
+		p := store.GetOrCreatePeer(testPeerID(fmt.Sprintf("category-peer%d", i)))
+		p.ClientName = seed.client
+		p.PeerCategory = string(seed.category)
+	}
+
+	// the other libp2p peers are excluded by default
+	dist := store.ClientDistribution()
+	require.Equal(t, 2, len(dist))
+	require.Equal(t, 0, dist["kubo"])
+
+	store.SetIncludeOtherLibp2p(true)
+	dist = store.ClientDistribution()
+	require.Equal(t, 4, len(dist))
+	require.Equal(t, 1, dist["lotus"])
+}
+
+func Test_ResetTopicMetricsAll(t *testing.T) {
+	t0 := time.Unix(1606824023, 0)
+	store := NewPeerStore()
+	store.GetOrCreatePeer(testPeerID("reset-alice")).MessageEvent(testBlockTopic, t0)
+	store.GetOrCreatePeer(testPeerID("reset-bob")).MessageEvent(testBlockTopic, t0)
+	store.GetOrCreatePeer(testPeerID("reset-carol")).MessageEvent(testAttTopic, t0)
+
+	require.Equal(t, 2, store.ResetTopicMetricsAll("beacon_block"))
+	require.Equal(t, int64(0), store.MessageTotals()[testBlockTopic])
+	require.Equal(t, int64(1), store.MessageTotals()[testAttTopic])
+	require.Equal(t, 0, store.ResetTopicMetricsAll("beacon_block"))
+}
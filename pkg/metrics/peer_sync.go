@@ -0,0 +1,176 @@
+package metrics
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/migalabs/armiarma/pkg/db/models"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// peerSyncState is the part of the persisted HostInfo of a peer that makes it dirty when it changes.
+// The quality score is left out, as it drifts with time, and gets persisted along with the rest.
+type peerSyncState struct {
+	network         string
+	addrs           string
+	ip              string
+	userAgent       string
+	protocolVersion string
+	protocols       string
+	latency         time.Duration
+	deprecated      bool
+	attempted       bool
+	attempts        int
+	successful      int
+	lastAttempt     time.Time
+	lastError       string
+	lastActivity    time.Time
+	distinctTopics  models.DistinctTopics
+	dialback        models.Dialback
+}
+
+// newPeerSyncState returns the sync state of the given HostInfo (see Peer.ToHostInfo).
+func newPeerSyncState(hInfo *models.HostInfo) peerSyncState {
+	hInfo.RLock()
+	defer hInfo.RUnlock()
+	addrs := make([]string, 0, len(hInfo.MAddrs))
+	for _, mAddr := range hInfo.MAddrs {
+		addrs = append(addrs, mAddr.String())
+	}
+	state := peerSyncState{
+		network:         string(hInfo.Network),
+		addrs:           strings.Join(addrs, ","),
+		ip:              hInfo.IP,
+		userAgent:       hInfo.PeerInfo.UserAgent,
+		protocolVersion: hInfo.PeerInfo.ProtocolVersion,
+		protocols:       strings.Join(hInfo.PeerInfo.Protocols, ","),
+		latency:         hInfo.PeerInfo.Latency,
+		deprecated:      hInfo.ControlInfo.Deprecated,
+		attempted:       hInfo.ControlInfo.Attempted,
+		attempts:        hInfo.ControlInfo.Attempts,
+		successful:      hInfo.ControlInfo.SuccessfulAttempts,
+		lastAttempt:     hInfo.ControlInfo.LastConnAttempt,
+		lastError:       hInfo.ControlInfo.LastError,
+		lastActivity:    hInfo.ControlInfo.LastActivity,
+	}
+	state.distinctTopics, _ = hInfo.Attr[models.DistinctTopicsAttribute].(models.DistinctTopics)
+	if dialback, ok := hInfo.Attr[models.DialbackAttribute].(models.Dialback); ok {
+		// the address of the dial-back isn't kept in the peer
+		dialback.Addr = nil
+		state.dialback = dialback
+	}
+	return state
+}
+
+// isEmpty returns whether there is nothing about the peer worth persisting.
+func (s peerSyncState) isEmpty() bool {
+	return s.addrs == "" && s.ip == "" && s.userAgent == "" && s.protocolVersion == "" && s.protocols == "" && !s.attempted
+}
+
+// PeerSyncer periodically persists the peers of the store that changed since their last persistence
+// (the dirty ones), converted with Peer.ToHostInfo, so that peer_info keeps up with the in-memory store.
+type PeerSyncer struct {
+	ctx context.Context
+
+	store     *PeerStore
+	persister DeltaPersister
+	interval  time.Duration
+	nowFn     func() time.Time
+
+	m sync.Mutex
+	// last persisted state of each peer
+	synced map[peer.ID]peerSyncState
+
+	wg     sync.WaitGroup
+	closeC chan struct{}
+}
+
+// NewPeerSyncer returns a PeerSyncer that will persist the dirty peers of the store every interval.
+func NewPeerSyncer(ctx context.Context, store *PeerStore, persister DeltaPersister, interval time.Duration) *PeerSyncer {
+	return &PeerSyncer{
+		ctx:       ctx,
+		store:     store,
+		persister: persister,
+		interval:  interval,
+		nowFn:     time.Now,
+		synced:    make(map[peer.ID]peerSyncState),
+		closeC:    make(chan struct{}),
+	}
+}
+
+// Start spawns the routine that persists the dirty peers on every tick.
+func (s *PeerSyncer) Start() {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.sync()
+			case <-s.closeC:
+				return
+			case <-s.ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Close stops the routine and persists the last dirty peers.
+func (s *PeerSyncer) Close() {
+	close(s.closeC)
+	s.wg.Wait()
+	s.sync()
+}
+
+// sync persists the peers whose state changed since their last persistence, returning how many were accepted.
+// The state of a peer only moves forward once it was accepted, so the rejected ones stay dirty.
+func (s *PeerSyncer) sync() int {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	now := s.nowFn()
+	weights := s.store.QualityWeights()
+	// the peers are converted first, so that the store isn't locked while persisting them
+	current := make(map[peer.ID]*models.HostInfo)
+	s.store.ForEachPeer(func(p *Peer) bool {
+		current[p.ID] = p.ToHostInfo(weights, now)
+		return true
+	})
+	accepted, failed := 0, 0
+	var lastErr error
+	for pid, hInfo := range current {
+		state := newPeerSyncState(hInfo)
+		if state.isEmpty() || state == s.synced[pid] {
+			continue
+		}
+		if err := s.persister.PersistToDB(hInfo); err != nil {
+			failed++
+			lastErr = err
+			continue
+		}
+		accepted++
+		s.synced[pid] = state
+	}
+	// forget the peers that left the store
+	for pid := range s.synced {
+		if _, ok := current[pid]; !ok {
+			delete(s.synced, pid)
+		}
+	}
+
+	if failed > 0 {
+		log.Error(errors.Wrapf(lastErr, "unable to sync %d peers", failed))
+	}
+	log.WithFields(log.Fields{
+		"accepted": accepted,
+		"failed":   failed,
+	}).Debug("synced dirty peers")
+	return accepted
+}
@@ -0,0 +1,67 @@
+package metrics
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/migalabs/armiarma/pkg/db/models"
+	"github.com/migalabs/armiarma/pkg/utils"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_PeerSyncer(t *testing.T) {
+	t0 := time.Unix(1654084800, 0)
+	store := NewPeerStore()
+	fake := &fakeDeltaPersister{}
+	syncer := NewPeerSyncer(context.Background(), store, fake, time.Minute)
+	syncer.nowFn = func() time.Time { return t0 }
+
+	// the peers that we know nothing about aren't persisted
+	store.GetOrCreatePeer(testPeerID("unknown"))
+	require.Equal(t, 0, syncer.sync())
+
+	identified := store.GetOrCreatePeer(testPeerID("identified"))
+	hInfo := models.NewHostInfo(identified.ID, utils.EthereumNetwork, models.WithIPAndPorts("95.217.33.10", 9000))
+	hInfo.PeerInfo = *models.NewPeerInfo(identified.ID, "Lighthouse/v2.3.1/x86_64-linux", "eth2/1.0.0", nil, 10*time.Millisecond)
+	identified.FetchHostInfo(hInfo)
+	attempted := store.GetOrCreatePeer(testPeerID("attempted"))
+	attempted.ConnectionAttemptEvent(false, "io_timeout")
+	require.Equal(t, 2, syncer.sync())
+	require.Len(t, fake.hostInfos, 2)
+	fake.hostInfos = nil
+
+	// nothing changed
+	require.Equal(t, 0, syncer.sync())
+
+	// only the dirty peer gets persisted
+	attempted.ConnectionAttemptEvent(true, "")
+	require.Equal(t, 1, syncer.sync())
+	require.Equal(t, attempted.ID, fake.hostInfos[0].ID)
+	require.Equal(t, 2, fake.hostInfos[0].ControlInfo.Attempts)
+	fake.hostInfos = nil
+
+	// the rejected peers stay dirty until they are accepted
+	identified.ConnectionEvent(t0)
+	fake.failing = true
+	require.Equal(t, 0, syncer.sync())
+	fake.failing = false
+	require.Equal(t, 1, syncer.sync())
+	require.Equal(t, identified.ID, fake.hostInfos[0].ID)
+	require.Equal(t, t0, fake.hostInfos[0].ControlInfo.LastActivity)
+	fake.hostInfos = nil
+
+	// the evicted peers are forgotten, and persisted again if they come back
+	store.removePeer(attempted.ID, nil)
+	require.Equal(t, 0, syncer.sync())
+	require.NotContains(t, syncer.synced, attempted.ID)
+	back := store.GetOrCreatePeer(attempted.ID)
+	back.ConnectionAttemptEvent(false, "io_timeout")
+	require.Equal(t, 1, syncer.sync())
+
+	// the last dirty peers are persisted when closing
+	syncer.Start()
+	identified.ConnectionAttemptEvent(true, "")
+	syncer.Close()
+	require.Equal(t, identified.ID, fake.hostInfos[len(fake.hostInfos)-1].ID)
+}
@@ -0,0 +1,573 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/migalabs/armiarma/pkg/db/models"
+	"github.com/migalabs/armiarma/pkg/utils"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_PeerOutOfOrderEvents(t *testing.T) {
+	t0 := time.Unix(1000, 0)
+
+	// disconnection before its connection
+	p := NewPeer(testPeerID("skewed"))
+	p.ConnectionEvent(t0)
+	p.DisconnectionEvent(t0.Add(-time.Minute))
+	require.Equal(t, uint64(1), p.TimestampAnomalies)
+	require.Equal(t, time.Duration(0), p.ConnectedTime(t0.Add(time.Hour)))
+	require.False(t, p.IsConnected)
+
+	// connection older than the previous disconnection
+	p.ConnectionEvent(t0.Add(-2 * time.Minute))
+	p.DisconnectionEvent(t0.Add(10 * time.Minute))
+	require.Equal(t, uint64(2), p.TimestampAnomalies)
+	require.Equal(t, 10*time.Minute, p.ConnectedTime(t0.Add(time.Hour)))
+
+	// the stored timestamps are always monotonic
+	for i := range p.ConnectionTimes {
+		require.False(t, p.DisconnectionTimes[i].Before(p.ConnectionTimes[i]))
+	}
+
+	// the ongoing session counts until now, but never negative
+	p.ConnectionEvent(t0.Add(20 * time.Minute))
+	require.Equal(t, 20*time.Minute, p.ConnectedTime(t0.Add(30*time.Minute)))
+	require.Equal(t, 10*time.Minute, p.ConnectedTime(t0))
+	require.Equal(t, uint64(2), p.TimestampAnomalies)
+}
+
+func Test_PeerConnectedTimeNeverNegative(t *testing.T) {
+	t0 := time.Unix(1000, 0)
+
+	// a sequence with a clock that keeps jumping backwards
+	p := NewPeer(testPeerID("jumping-clock"))
+	for i := 0; i < 10; i++ {
+		ti := t0.Add(-time.Duration(i) * time.Minute)
+		p.ConnectionEvent(ti)
+		p.DisconnectionEvent(ti.Add(-30 * time.Second))
+	}
+	require.Equal(t, uint64(19), p.TimestampAnomalies)
+	require.Equal(t, time.Duration(0), p.ConnectedTime(t0))
+
+	// the anomalies are kept across checkpoints
+	cp := NewPeer(p.ID)
+	cp.Merge(p)
+	require.Equal(t, p.TimestampAnomalies, cp.TimestampAnomalies)
+	require.True(t, cp.ConnectedTime(t0) >= 0)
+}
+
+func Test_PeerConnectionSessions(t *testing.T) {
+	t0 := time.Unix(1000, 0)
+
+	// reconnection after a disconnection
+	p := NewPeer(testPeerID("reconnected"))
+	p.ConnectionEvent(t0)
+	p.DisconnectionEvent(t0.Add(10 * time.Minute))
+	p.ConnectionEvent(t0.Add(20 * time.Minute))
+	p.DisconnectionEvent(t0.Add(25 * time.Minute))
+	require.Equal(t, []time.Duration{10 * time.Minute, 5 * time.Minute}, p.SessionDurations())
+	require.Equal(t, 15*time.Minute, p.ConnectedTime(t0.Add(time.Hour)))
+
+	// still connected, the open session counts until the given time
+	p.ConnectionEvent(t0.Add(30 * time.Minute))
+	require.Equal(t, 45*time.Minute, p.ConnectedTime(t0.Add(time.Hour)))
+	require.Len(t, p.SessionDurations(), 2)
+	sessions := p.GetConnectionSessions()
+	require.Len(t, sessions, 3)
+	require.True(t, sessions[2] >= time.Since(t0.Add(30*time.Minute))-time.Second)
+
+	// events inserted out of order get paired chronologically
+	p = NewPeer(testPeerID("unsorted"))
+	p.ConnectionTimes = []time.Time{t0.Add(20 * time.Minute), t0}
+	p.DisconnectionTimes = []time.Time{t0.Add(30 * time.Minute), t0.Add(5 * time.Minute)}
+	require.Equal(t, []time.Duration{5 * time.Minute, 10 * time.Minute}, p.SessionDurations())
+	require.Equal(t, 15*time.Minute, p.ConnectedTime(t0.Add(time.Hour)))
+
+	// a disconnection without its connection doesn't shift the later sessions
+	p = NewPeer(testPeerID("orphan-disconnection"))
+	p.DisconnectionEvent(t0)
+	p.ConnectionEvent(t0.Add(10 * time.Minute))
+	p.DisconnectionEvent(t0.Add(20 * time.Minute))
+	require.Equal(t, []time.Duration{10 * time.Minute}, p.SessionDurations())
+	require.Equal(t, 10*time.Minute, p.ConnectedTime(t0.Add(time.Hour)))
+
+	// an open session of a peer that isn't connected anymore doesn't count
+	p = NewPeer(testPeerID("lost-disconnection"))
+	p.ConnectionTimes = []time.Time{t0}
+	require.Zero(t, p.ConnectedTime(t0.Add(time.Hour)))
+	require.Empty(t, p.GetConnectionSessions())
+
+	// the open session starts at the latest connection without disconnection
+	p = NewPeer(testPeerID("lost-disconnection-reconnected"))
+	p.ConnectionEvent(t0)
+	p.ConnectionEvent(t0.Add(50 * time.Minute))
+	require.Equal(t, 10*time.Minute, p.ConnectedTime(t0.Add(time.Hour)))
+}
+
+func Test_PeerStatusRequestOutcomes(t *testing.T) {
+	store := NewPeerStore()
+	pid := testPeerID("status-peer")
+	p := store.GetOrCreatePeer(pid)
+	p.ClientName = "lighthouse"
+
+	_, ok := p.StatusSuccessRate()
+	require.False(t, ok)
+
+	p.StatusRequestEvent(models.NewReqRespOutcome(pid, models.ReqRespSuccess, 0))
+	p.StatusRequestEvent(models.NewReqRespOutcome(pid, models.ReqRespStreamReset, 0))
+	p.StatusRequestEvent(models.NewReqRespOutcome(pid, models.ReqRespTimeout, 0))
+	p.StatusRequestEvent(models.NewReqRespOutcome(pid, models.ReqRespErrorResponse, 3))
+
+	require.Equal(t, int64(4), p.StatusRequests)
+	require.Equal(t, int64(1), p.StatusSucceeded)
+	require.Equal(t, map[string]int64{"stream_reset": 1, "timeout": 1, "error_response_3": 1}, p.StatusErrors)
+	rate, ok := p.StatusSuccessRate()
+	require.True(t, ok)
+	require.Equal(t, 0.25, rate)
+	require.Equal(t, map[string]float64{"lighthouse": 0.25}, store.ClientStatusSuccessRate())
+
+	// the counters survive copies and merges
+	other := NewPeer(pid)
+	other.Merge(p.Copy())
+	require.Equal(t, int64(4), other.StatusRequests)
+	require.Equal(t, int64(1), other.StatusErrors["error_response_3"])
+}
+
+func Test_PeerMetadataRequests(t *testing.T) {
+	pid := testPeerID("metadata-peer")
+	p := NewPeer(pid)
+	require.False(t, p.MetadataRequested())
+	require.False(t, p.MetadataSucceeded())
+
+	t0 := time.Unix(1654084800, 0)
+	outcome := func(category models.ReqRespCategory, t time.Time) models.MetadataRequest {
+		o := models.MetadataRequest{ReqRespOutcome: models.NewReqRespOutcome(pid, category, -1)}
+		o.Timestamp = t
+		return o
+	}
+	// several failures followed by a success, which keeps the last error
+	p.MetadataRequestEvent(outcome(models.ReqRespTimeout, t0))
+	p.MetadataRequestEvent(outcome(models.ReqRespStreamReset, t0.Add(time.Minute)))
+	require.True(t, p.MetadataRequested())
+	require.False(t, p.MetadataSucceeded())
+	hInfo := models.NewHostInfo(pid, utils.EthereumNetwork)
+	hInfo.AddAtt(models.MetadataRequestAttribute, outcome(models.ReqRespSuccess, t0.Add(2*time.Minute)))
+	p.FetchHostInfo(hInfo)
+
+	require.True(t, p.MetadataSucceeded())
+	require.Equal(t, int64(3), p.MetadataRequests)
+	require.Equal(t, int64(1), p.MetadataSuccesses)
+	require.Equal(t, t0.Add(2*time.Minute), p.LastMetadataAttempt)
+	require.Equal(t, "stream_reset", p.LastMetadataError)
+
+	record := p.csvRecord(DefaultQualityWeights, t0)
+	require.Equal(t, "3", record[csvColumn(t, "metadata_requests")])
+	require.Equal(t, "1", record[csvColumn(t, "metadata_successes")])
+	require.Equal(t, "stream_reset", record[csvColumn(t, "last_metadata_error")])
+
+	// the counters survive copies and merges, the last error being the one of the latest request
+	other := NewPeer(pid)
+	other.MetadataRequestEvent(outcome(models.ReqRespOther, t0.Add(-time.Hour)))
+	other.Merge(p.Copy())
+	require.Equal(t, int64(4), other.MetadataRequests)
+	require.Equal(t, int64(1), other.MetadataSuccesses)
+	require.Equal(t, t0.Add(2*time.Minute), other.LastMetadataAttempt)
+	require.Equal(t, "stream_reset", other.LastMetadataError)
+}
+
+func Test_PeerConnectionAttemptStreaks(t *testing.T) {
+	tests := []struct {
+		name     string
+		attempts []bool
+		failures int
+		longest  int
+		success  int
+	}{
+		{"no attempts", []bool{}, 0, 0, 0},
+		{"only failures", []bool{false, false, false}, 3, 3, 0},
+		{"only successes", []bool{true, true}, 0, 0, 2},
+		{"failures then success", []bool{false, false, true}, 0, 2, 1},
+		{"success then failures", []bool{true, false, false}, 2, 2, 0},
+		{"interleaved", []bool{false, true, false, false, false, true, false}, 1, 3, 0},
+		{"longest streak in the past", []bool{false, false, false, true, true, false}, 1, 3, 0},
+		{"longest streak ongoing", []bool{false, true, false, false}, 2, 2, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := NewPeer(testPeerID("streak-peer"))
+			for _, succeed := range test.attempts {
+				errStr := "none"
+				if !succeed {
+					errStr = "i/o timeout"
+				}
+				p.ConnectionAttemptEvent(succeed, errStr)
+			}
+			require.Equal(t, len(test.attempts), p.Attempts)
+			require.Equal(t, test.failures, p.GetFailureStreak())
+			require.Equal(t, test.longest, p.GetLongestFailureStreak())
+			require.Equal(t, test.success, p.GetSuccessStreak())
+		})
+	}
+}
+
+func Test_PeerMergeStreaks(t *testing.T) {
+	t0 := time.Unix(1000, 0)
+	pid := testPeerID("merged-streak-peer")
+
+	older := NewPeer(pid)
+	older.LastAttempt = t0
+	older.FailureStreak = 5
+	older.LongestFailureStreak = 7
+
+	newer := NewPeer(pid)
+	newer.LastAttempt = t0.Add(time.Minute)
+	newer.SuccessStreak = 2
+	newer.LongestFailureStreak = 1
+
+	// the current streaks come from the latest attempt, regardless of the merge direction
+	for _, pair := range [][2]*Peer{{older, newer}, {newer, older}} {
+		merged := pair[0].Copy()
+		merged.Merge(pair[1])
+		require.Equal(t, t0.Add(time.Minute), merged.LastAttempt)
+		require.Equal(t, 0, merged.GetFailureStreak())
+		require.Equal(t, 2, merged.GetSuccessStreak())
+		require.Equal(t, 7, merged.GetLongestFailureStreak())
+	}
+}
+
+func Test_PeerConnectionAttemptErrors(t *testing.T) {
+	p := NewPeer(testPeerID("error-peer"))
+	top, count := p.GetTopError()
+	require.Equal(t, "", top)
+	require.Equal(t, uint64(0), count)
+
+	// the most recent error wins the ties
+	p.ConnectionAttemptEvent(false, "io_timeout")
+	p.ConnectionAttemptEvent(false, "connection_refused")
+	require.Equal(t, "connection_refused", p.LastError)
+
+	// the most frequent error is kept while others show up
+	p.ConnectionAttemptEvent(false, "io_timeout")
+	p.ConnectionAttemptEvent(false, "connection_refused")
+	p.ConnectionAttemptEvent(false, "connection_refused")
+	p.ConnectionAttemptEvent(false, "no_route_to_host")
+	require.Equal(t, "connection_refused", p.LastError)
+	require.Equal(t, map[string]uint64{
+		"io_timeout":         2,
+		"connection_refused": 3,
+		"no_route_to_host":   1,
+	}, p.GetErrorCounts())
+	require.False(t, p.GetLastErrorOf("connection_refused").Before(p.GetLastErrorOf("io_timeout")))
+	require.Equal(t, p.LastAttempt, p.GetLastErrorOf("no_route_to_host"))
+	require.True(t, p.GetLastErrorOf("backoff").IsZero())
+
+	// a successful attempt resets the current error, but not the counts
+	p.ConnectionAttemptEvent(true, "none")
+	require.Equal(t, "none", p.LastError)
+	require.Equal(t, uint64(6), sumCounts(p.GetErrorCounts()))
+	top, count = p.GetTopError()
+	require.Equal(t, "connection_refused", top)
+	require.Equal(t, uint64(3), count)
+
+	// until the next failures
+	p.ConnectionAttemptEvent(false, "io_timeout")
+	p.ConnectionAttemptEvent(false, "io_timeout")
+	require.Equal(t, "io_timeout", p.LastError)
+	top, count = p.GetTopError()
+	require.Equal(t, "io_timeout", top)
+	require.Equal(t, uint64(4), count)
+
+	// the accessors return copies
+	p.GetErrorCounts()["io_timeout"] = 100
+	require.Equal(t, uint64(4), p.GetErrorCounts()["io_timeout"])
+	cp := p.Copy()
+	cp.ConnectionAttemptEvent(false, "backoff")
+	require.Equal(t, uint64(0), p.GetErrorCounts()["backoff"])
+}
+
+func Test_PeerMergeConnectionAttemptErrors(t *testing.T) {
+	t0 := time.Unix(1000, 0)
+	pid := testPeerID("merged-error-peer")
+
+	older := NewPeer(pid)
+	older.LastAttempt = t0
+	older.LastError = "io_timeout"
+	older.ErrorCounts = map[string]uint64{"io_timeout": 1, "connection_refused": 2}
+	older.LastErrorTimes = map[string]time.Time{"io_timeout": t0, "connection_refused": t0.Add(-time.Minute)}
+
+	newer := NewPeer(pid)
+	newer.LastAttempt = t0.Add(time.Minute)
+	newer.FailureStreak = 1
+	newer.LastError = "io_timeout"
+	newer.ErrorCounts = map[string]uint64{"io_timeout": 2}
+	newer.LastErrorTimes = map[string]time.Time{"io_timeout": t0.Add(time.Minute)}
+
+	for _, pair := range [][2]*Peer{{older, newer}, {newer, older}} {
+		merged := pair[0].Copy()
+		merged.Merge(pair[1])
+		require.Equal(t, map[string]uint64{"io_timeout": 3, "connection_refused": 2}, merged.GetErrorCounts())
+		require.Equal(t, t0.Add(time.Minute), merged.GetLastErrorOf("io_timeout"))
+		require.Equal(t, t0.Add(-time.Minute), merged.GetLastErrorOf("connection_refused"))
+		require.Equal(t, "io_timeout", merged.LastError)
+	}
+}
+
+func sumCounts(counts map[string]uint64) uint64 {
+	var total uint64
+	for _, count := range counts {
+		total += count
+	}
+	return total
+}
+
+func Test_PeerDeprecable(t *testing.T) {
+	t0 := time.Unix(1606824023, 0)
+	store := NewPeerStore()
+	p := store.GetOrCreatePeer(testPeerID("deprecated-peer"))
+	p.ClientName = "prysm"
+	p.Country = "Finland"
+	p.ConnectionEvent(t0)
+	p.DisconnectionEvent(t0.Add(time.Minute))
+
+	// a couple of failures are not enough, neither is a recent activity
+	p.ConnectionAttemptEvent(false, "i/o timeout")
+	p.ConnectionAttemptEvent(false, "i/o timeout")
+	require.False(t, p.Deprecable(t0.Add(time.Hour), 3))
+	p.ConnectionAttemptEvent(false, "i/o timeout")
+	require.False(t, p.Deprecable(t0, 3))
+	require.True(t, p.Deprecable(t0.Add(time.Hour), 3))
+
+	// the deprecated peers are left out of the distributions
+	p.DeprecationEvent()
+	require.Equal(t, 0, store.ClientDistribution()["prysm"])
+	require.Equal(t, 0, store.CountryDistribution()["Finland"])
+
+	// the rediscovery undeprecates it
+	p.FetchHostInfo(models.NewHostInfo(p.ID, utils.EthereumNetwork))
+	require.False(t, p.Deprecated)
+	require.Equal(t, 1, store.ClientDistribution()["prysm"])
+	// and it isn't deprecated again until it fails another attempt
+	require.False(t, p.Deprecable(t0.Add(time.Hour), 3))
+	p.ConnectionAttemptEvent(false, "i/o timeout")
+	require.True(t, p.Deprecable(t0.Add(time.Hour), 3))
+
+	// as any connection (i.e. an inbound one)
+	p.DeprecationEvent()
+	p.ConnectionEvent(t0.Add(2 * time.Hour))
+	require.False(t, p.Deprecated)
+	require.False(t, p.Deprecable(t0.Add(3*time.Hour), 3))
+	require.Equal(t, 1, store.CountryDistribution()["Finland"])
+}
+
+func Test_PeerFetchIpInfoProvider(t *testing.T) {
+	p := NewPeer(testPeerID("provider-peer"))
+	p.FetchIpInfo(models.IpInfo{
+		IpApiMsg: models.IpApiMsg{IP: "95.217.10.20", Country: "Finland", CountryCode: "FI"},
+		Provider: "Hetzner",
+	})
+	require.Equal(t, "Hetzner", p.Provider)
+
+	// the IPs located without a provider get it from the bundled ranges
+	p.FetchIpInfo(models.IpInfo{IpApiMsg: models.IpApiMsg{IP: "167.99.3.4"}})
+	require.Equal(t, "DigitalOcean", p.Provider)
+	p.FetchIpInfo(models.IpInfo{IpApiMsg: models.IpApiMsg{IP: "86.85.31.80"}})
+	require.Empty(t, p.Provider)
+
+	other := NewPeer(testPeerID("provider-peer"))
+	other.Provider = "OVH"
+	merged := p.Copy()
+	merged.Merge(other)
+	require.Equal(t, "OVH", merged.Provider)
+	require.Equal(t, "OVH", merged.Copy().Provider)
+}
+
+func Test_PeerConnectionAddrs(t *testing.T) {
+	t0 := time.Unix(1000, 0)
+	pid := testPeerID("addrs-peer")
+
+	older := NewPeer(pid)
+	older.ConnectionEventWithAddrs(t0, "/ip4/10.0.0.2/tcp/9000", "/ip4/95.217.33.10/tcp/9000")
+	// the connections without addresses keep the last known ones
+	older.ConnectionEvent(t0.Add(time.Second))
+	require.Equal(t, "/ip4/10.0.0.2/tcp/9000", older.LastLocalAddr)
+	require.Equal(t, "/ip4/95.217.33.10/tcp/9000", older.LastRemoteAddr)
+
+	newer := NewPeer(pid)
+	newer.ConnectionEventWithAddrs(t0.Add(time.Minute), "/ip4/10.0.0.2/udp/9001/quic", "/ip4/95.217.33.10/udp/9001/quic")
+
+	// the addresses come from the latest connection, regardless of the merge direction
+	for _, pair := range [][2]*Peer{{older, newer}, {newer, older}} {
+		merged := pair[0].Copy()
+		merged.Merge(pair[1])
+		require.Equal(t, "/ip4/10.0.0.2/udp/9001/quic", merged.LastLocalAddr)
+		require.Equal(t, "/ip4/95.217.33.10/udp/9001/quic", merged.LastRemoteAddr)
+	}
+}
+
+func Test_PeerResetTopicMetrics(t *testing.T) {
+	t0 := time.Unix(1606824023, 0)
+	oldBlock := "/eth2/b5303f2a/beacon_block/ssz_snappy"
+	newBlock := "/eth2/4a26c58b/beacon_block/ssz_snappy"
+	p := NewPeer(testPeerID("reset-peer"))
+	p.MessageEvent(oldBlock, t0)
+	p.MessageEvent(newBlock, t0)
+	p.MessageEvent(testAttTopic, t0)
+	p.MessageBytesEvent(testAttTopic, 100)
+
+	// the full name only resets that topic
+	require.True(t, p.ResetTopicMetrics(oldBlock))
+	require.NotContains(t, p.MessageMetrics, oldBlock)
+	require.Contains(t, p.MessageMetrics, newBlock)
+
+	// the short name resets the topic of every fork digest
+	p.MessageEvent(oldBlock, t0)
+	require.True(t, p.ResetTopicMetrics("beacon_block"))
+	require.Equal(t, int64(0), p.GetNumOfMsgFromTopic("beacon_block"))
+	require.Equal(t, int64(1), p.GetNumOfMsgFromTopic(shortTopicName(testAttTopic)))
+
+	// missing topic
+	require.False(t, p.ResetTopicMetrics("beacon_block"))
+	require.False(t, p.ResetTopicMetrics("voluntary_exit"))
+
+	// the following messages start from zero, without a gap to the ones before the reset
+	require.True(t, p.ResetTopicMetrics(testAttTopic))
+	p.MessageEvent(testAttTopic, t0.Add(time.Minute))
+	msgMetric := p.MessageMetrics[testAttTopic]
+	require.Equal(t, int64(1), msgMetric.Count)
+	require.Equal(t, int64(0), msgMetric.Bytes)
+	require.Equal(t, t0.Add(time.Minute), msgMetric.FirstMessageTime)
+	require.Nil(t, msgMetric.InterArrival)
+}
+
+func Test_PeerRelayedConnections(t *testing.T) {
+	t0 := time.Unix(1000, 0)
+	pid := testPeerID("relayed-peer")
+	direct := "/ip4/95.217.33.10/tcp/9000"
+	relayed := "/ip4/51.15.2.10/tcp/4001/p2p/" + testPeerID("relay").String() + "/p2p-circuit"
+
+	p := NewPeer(pid)
+	p.ConnectionEventWithAddrs(t0, "/ip4/10.0.0.2/tcp/9000", direct)
+	require.False(t, p.LastConnectionRelayed)
+	p.DisconnectionEvent(t0.Add(time.Minute))
+	p.ConnectionEventWithAddrs(t0.Add(2*time.Minute), "/ip4/10.0.0.2/tcp/9000", relayed)
+	require.True(t, p.LastConnectionRelayed)
+	// connections without a known remote address aren't classified
+	p.DisconnectionEvent(t0.Add(3 * time.Minute))
+	p.ConnectionEvent(t0.Add(4 * time.Minute))
+	require.Equal(t, 1, p.DirectSessions)
+	require.Equal(t, 1, p.RelayedSessions)
+	require.True(t, p.LastConnectionRelayed)
+
+	// the latency of an identification through a relay is skipped
+	identify := func(latency time.Duration, relayed bool) {
+		hInfo := models.NewHostInfo(pid, utils.EthereumNetwork)
+		hInfo.PeerInfo = models.PeerInfo{
+			RemotePeer: pid,
+			UserAgent:  "Lighthouse/v4.1.0-693886b/x86_64-linux",
+			Latency:    latency,
+			Relayed:    relayed,
+		}
+		p.FetchHostInfo(hInfo)
+	}
+	identify(40*time.Millisecond, false)
+	identify(300*time.Millisecond, true)
+	require.Equal(t, 40*time.Millisecond, p.Latency)
+	require.Equal(t, "lighthouse", p.ClientName)
+
+	// the counters add up, and the last kind comes from the latest connection
+	other := NewPeer(pid)
+	other.ConnectionEventWithAddrs(t0.Add(time.Hour), "/ip4/10.0.0.2/tcp/9000", direct)
+	merged := p.Copy()
+	merged.Merge(other)
+	require.Equal(t, 2, merged.DirectSessions)
+	require.Equal(t, 1, merged.RelayedSessions)
+	require.False(t, merged.LastConnectionRelayed)
+
+	record := p.csvRecord(DefaultQualityWeights, t0)
+	require.Equal(t, "1", record[csvColumn(t, "relayed_sessions")])
+}
+
+func Test_PeerFetchLocation(t *testing.T) {
+	resolvedAt := time.Unix(1650000000, 0)
+	full := models.IpInfo{
+		IpApiMsg: models.IpApiMsg{
+			IP: "95.217.33.10", Country: "Finland", CountryCode: "FI", City: "Helsinki",
+			As: "AS24940 Hetzner Online GmbH", AsName: "HETZNER-AS", Hosting: true,
+		},
+		Source:     models.LocationSourceIpApi,
+		ResolvedAt: resolvedAt,
+	}
+	p := NewPeer(testPeerID("location-peer"))
+	p.FetchIpInfo(full)
+	require.Equal(t, "Helsinki", p.City)
+	require.Equal(t, "AS24940", p.ASN)
+	require.Equal(t, models.LocationSourceIpApi, p.LocationSource)
+	require.Equal(t, "Hetzner", p.Provider)
+	record := p.csvRecord(DefaultQualityWeights, resolvedAt)
+	require.Equal(t, "AS24940", record[csvColumn(t, "asn")])
+	require.Equal(t, "ip-api", record[csvColumn(t, "location_source")])
+
+	// the IPs located before the source was tracked, without autonomous system
+	p = NewPeer(testPeerID("location-peer"))
+	p.FetchLocation("86.85.31.80", models.IpInfo{
+		IpApiMsg: models.IpApiMsg{IP: "86.85.31.80", Country: "Netherlands", CountryCode: "NL"},
+	}.Location())
+	require.Equal(t, "NL", p.CountryCode)
+	require.Empty(t, p.City)
+	require.Empty(t, p.ASN)
+	require.Empty(t, p.LocationSource)
+	record = p.csvRecord(DefaultQualityWeights, resolvedAt)
+	require.Equal(t, "", record[csvColumn(t, "asn")])
+
+	// the merge keeps the known location fields
+	merged := p.Copy()
+	other := NewPeer(testPeerID("location-peer"))
+	other.FetchIpInfo(full)
+	merged.Merge(other)
+	require.Equal(t, "AS24940", merged.ASN)
+	require.Equal(t, "NL", merged.CountryCode)
+}
+
+func Test_PeerFetchSanitizedUserAgent(t *testing.T) {
+	pid := testPeerID("sanitized-agent")
+	p := NewPeer(pid)
+
+	// the agents set directly into the PeerInfo get sanitized as well
+	hInfo := models.NewHostInfo(pid, utils.EthereumNetwork)
+	hInfo.PeerInfo = models.PeerInfo{
+		RemotePeer: pid,
+		UserAgent:  "Lighthouse/\x1b[1mv4.1.0\x1b[0m-693886b/x86_64-linux\x00",
+	}
+	p.FetchHostInfo(hInfo)
+	require.Equal(t, "Lighthouse/v4.1.0-693886b/x86_64-linux", p.UserAgent)
+	require.Equal(t, "lighthouse", p.ClientName)
+	require.Equal(t, utils.ExactClientConfidence, p.ClientConfidence)
+	require.True(t, p.UserAgentSanitized)
+	require.NotEmpty(t, p.RawUserAgent)
+	// the PeerInfo of the host isn't modified
+	require.False(t, hInfo.PeerInfo.UserAgentSanitized)
+
+	// a clean agent clears the flag
+	hInfo.PeerInfo = *models.NewPeerInfo(pid, "Lighthouse/v4.1.0-693886b/x86_64-linux", "", nil, 0)
+	p.FetchHostInfo(hInfo)
+	require.False(t, p.UserAgentSanitized)
+	require.Empty(t, p.RawUserAgent)
+}
+
+func Test_PeerFetchFuzzyClient(t *testing.T) {
+	pid := testPeerID("fuzzy-agent")
+	p := NewPeer(pid)
+
+	hInfo := models.NewHostInfo(pid, utils.EthereumNetwork)
+	hInfo.PeerInfo = *models.NewPeerInfo(pid, "Lighthuose/v4.1.0-693886b/x86_64-linux", "", nil, 0)
+	p.FetchHostInfo(hInfo)
+	require.Equal(t, "lighthouse", p.ClientName)
+	require.InDelta(t, 0.8, p.ClientConfidence, 1e-9)
+
+	// the confidence comes along with the client name on a merge
+	fresh := NewPeer(pid)
+	fresh.Merge(p)
+	require.Equal(t, p.ClientConfidence, fresh.ClientConfidence)
+}
@@ -0,0 +1,52 @@
+package metrics
+
+import (
+	"github.com/migalabs/armiarma/pkg/db/models"
+	"github.com/migalabs/armiarma/pkg/utils"
+)
+
+// PubsubProtocolEvent tracks the pubsub protocol negotiated with the peer.
+func (p *Peer) PubsubProtocolEvent(protocolID string) {
+	p.m.Lock()
+	defer p.m.Unlock()
+	p.PubsubVersion = models.PubsubVersion(protocolID)
+}
+
+// GetPubsubVersion returns the short name of the pubsub protocol negotiated with the peer,
+// or an empty string if we never exchanged pubsub with it.
+func (p *Peer) GetPubsubVersion() string {
+	p.m.RLock()
+	defer p.m.RUnlock()
+	return p.PubsubVersion
+}
+
+// ClientPubsubVersions returns the number of identified peers per client name and pubsub version.
+// The peers with which we never exchanged pubsub are counted under the unknown version.
+func (s *PeerStore) ClientPubsubVersions() map[string]map[string]int {
+	s.m.RLock()
+	includeOthers := s.includeOtherLibp2p
+	s.m.RUnlock()
+	versions := make(map[string]map[string]int)
+	s.ForEachPeer(func(p *Peer) bool {
+		p.m.RLock()
+		defer p.m.RUnlock()
+		if !includeOthers && p.PeerCategory == string(utils.OtherLibp2pCategory) {
+			return true
+		}
+		if p.ClientName == "" {
+			return true
+		}
+		version := p.PubsubVersion
+		if version == "" {
+			version = utils.Unknown
+		}
+		cliVersions, ok := versions[p.ClientName]
+		if !ok {
+			cliVersions = make(map[string]int)
+			versions[p.ClientName] = cliVersions
+		}
+		cliVersions[version]++
+		return true
+	})
+	return versions
+}
@@ -0,0 +1,46 @@
+package metrics
+
+import (
+	"testing"
+
+	"github.com/migalabs/armiarma/pkg/utils"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_PeerPubsubVersion(t *testing.T) {
+	p := NewPeer(testPeerID("pubsub-peer"))
+	require.Equal(t, "", p.GetPubsubVersion())
+
+	p.PubsubProtocolEvent("/meshsub/1.1.0")
+	require.Equal(t, "v1.1", p.GetPubsubVersion())
+
+	// the merge keeps the known version
+	other := NewPeer(p.ID)
+	other.PubsubProtocolEvent("/meshsub/1.2.0")
+	p.Merge(other)
+	require.Equal(t, "v1.1", p.GetPubsubVersion())
+	empty := NewPeer(testPeerID("pubsub-empty"))
+	empty.Merge(other)
+	require.Equal(t, "v1.2", empty.GetPubsubVersion())
+}
+
+func Test_ClientPubsubVersions(t *testing.T) {
+	store := NewPeerStore()
+	for name, info := range map[string][2]string{
+		"pubsub0": {"lighthouse", "/meshsub/1.1.0"},
+		"pubsub1": {"lighthouse", "/meshsub/1.1.0"},
+		"pubsub2": {"lighthouse", ""},
+		"pubsub3": {"prysm", "/meshsub/1.2.0"},
+		"pubsub4": {"", "/meshsub/1.1.0"},
+	} {
+		p := store.GetOrCreatePeer(testPeerID(name))
+		p.ClientName = info[0]
+		if info[1] != "" {
+			p.PubsubProtocolEvent(info[1])
+		}
+	}
+	require.Equal(t, map[string]map[string]int{
+		"lighthouse": {"v1.1": 2, utils.Unknown: 1},
+		"prysm":      {"v1.2": 1},
+	}, store.ClientPubsubVersions())
+}
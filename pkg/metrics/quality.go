@@ -0,0 +1,91 @@
+package metrics
+
+import (
+	"math"
+	"time"
+)
+
+// QualityMaxLatency is the latency from which a peer gets no latency score at all.
+var QualityMaxLatency = 1 * time.Second
+
+// QualityWeights are the relative weights of the signals of the peer quality score.
+// They don't need to add up to 1 (they are normalized), and negative weights count as 0.
+type QualityWeights struct {
+	Uptime         float64 // connected time over the time since the first connection
+	AttemptSuccess float64 // succeeded connection attempts over the attempts
+	Latency        float64 // latency reported by the identification (linear from 0 to QualityMaxLatency)
+	FirstDelivery  float64 // first deliveries over the deliveries (first or duplicated) of gossip messages
+	Status         float64 // succeeded Status requests over the requests sent
+}
+
+// DefaultQualityWeights are the weights used unless the PeerStore is configured with others.
+var DefaultQualityWeights = QualityWeights{
+	Uptime:         0.30,
+	AttemptSuccess: 0.25,
+	Latency:        0.15,
+	FirstDelivery:  0.15,
+	Status:         0.15,
+}
+
+// QualityScore returns the quality of the peer from 0 (worst) to 100 (best), as the weighted average
+// of the signals that we have for it (each of them from 0 to 1). The signals without data (i.e. no
+// Status request sent) are left out of the average instead of counting as 0, and a peer without any
+// signal scores 0. The score only depends on the peer, the weights and the given time, and it is
+// rounded to 2 decimals, so identical inputs always give identical scores.
+func (p *Peer) QualityScore(weights QualityWeights, now time.Time) float64 {
+	p.m.RLock()
+	defer p.m.RUnlock()
+	return p.qualityScore(weights, now)
+}
+
+// qualityScore computes the quality score of the peer (needs the lock).
+func (p *Peer) qualityScore(weights QualityWeights, now time.Time) float64 {
+	var total, weightSum float64
+	// the signals are always added in the same order to keep the floating point sums deterministic
+	addSignal := func(weight, value float64, ok bool) {
+		if !ok || weight <= 0 {
+			return
+		}
+		total += weight * math.Max(0, math.Min(1, value))
+		weightSum += weight
+	}
+
+	// uptime
+	if len(p.ConnectionTimes) > 0 {
+		window := now.Sub(p.ConnectionTimes[0])
+		addSignal(weights.Uptime, float64(p.connectedTime(now))/float64(window), window > 0)
+	}
+	// connection attempts
+	addSignal(weights.AttemptSuccess, float64(p.SuccessfulAttempts)/float64(p.Attempts), p.Attempts > 0)
+	// latency
+	addSignal(weights.Latency, 1-float64(p.Latency)/float64(QualityMaxLatency), p.Latency > 0)
+	// gossip first deliveries
+	var firstDeliveries, duplicates int64
+	for _, msgMetric := range p.MessageMetrics {
+		firstDeliveries += msgMetric.FirstDeliveries
+		duplicates += msgMetric.Duplicates
+	}
+	deliveries := firstDeliveries + duplicates
+	addSignal(weights.FirstDelivery, float64(firstDeliveries)/float64(deliveries), deliveries > 0)
+	// status availability
+	addSignal(weights.Status, float64(p.StatusSucceeded)/float64(p.StatusRequests), p.StatusRequests > 0)
+
+	if weightSum == 0 {
+		return 0
+	}
+	return math.Round(total/weightSum*100*100) / 100
+}
+
+// SetQualityWeights configures the weights of the quality scores computed by the store.
+func (s *PeerStore) SetQualityWeights(weights QualityWeights) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.qualityWeights = weights
+}
+
+// QualityWeights returns the weights of the quality scores computed by the store.
+func (s *PeerStore) QualityWeights() QualityWeights {
+	s.m.RLock()
+	defer s.m.RUnlock()
+	return s.qualityWeights
+}
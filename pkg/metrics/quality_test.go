@@ -0,0 +1,105 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// newTestQualityPeer returns a peer with all the quality signals:
+// uptime 0.5, attempt success 0.75, latency 0.8, first deliveries 0.25 and status 1.
+func newTestQualityPeer(t0 time.Time) *Peer {
+	p := NewPeer(testPeerID("quality-peer"))
+	p.ConnectionTimes = []time.Time{t0}
+	p.DisconnectionTimes = []time.Time{t0.Add(30 * time.Minute)}
+	p.Attempts = 4
+	p.SuccessfulAttempts = 3
+	p.Latency = 200 * time.Millisecond
+	p.MessageMetrics["/eth2/4a26c58b/beacon_block/ssz_snappy"] = &MessageMetric{
+		Count:           4,
+		FirstDeliveries: 1,
+		Duplicates:      3,
+	}
+	p.StatusRequests = 2
+	p.StatusSucceeded = 2
+	return p
+}
+
+func Test_PeerQualityScore(t *testing.T) {
+	t0 := time.Unix(1000, 0)
+	now := t0.Add(time.Hour)
+
+	tests := []struct {
+		name    string
+		peer    func() *Peer
+		weights QualityWeights
+		score   float64
+	}{
+		{
+			name:    "no signals",
+			peer:    func() *Peer { return NewPeer(testPeerID("empty-peer")) },
+			weights: DefaultQualityWeights,
+			score:   0,
+		},
+		{
+			name:    "all signals",
+			peer:    func() *Peer { return newTestQualityPeer(t0) },
+			weights: DefaultQualityWeights,
+			score:   64.5,
+		},
+		{
+			name:    "only latency weighted",
+			peer:    func() *Peer { return newTestQualityPeer(t0) },
+			weights: QualityWeights{Latency: 1},
+			score:   80,
+		},
+		{
+			name:    "weights are normalized",
+			peer:    func() *Peer { return newTestQualityPeer(t0) },
+			weights: QualityWeights{Uptime: 2, Status: 2, Latency: -1},
+			score:   75,
+		},
+		{
+			name: "signals without data are left out",
+			peer: func() *Peer {
+				p := NewPeer(testPeerID("status-only-peer"))
+				p.StatusRequests = 4
+				p.StatusSucceeded = 1
+				return p
+			},
+			weights: DefaultQualityWeights,
+			score:   25,
+		},
+		{
+			name: "latency over the maximum",
+			peer: func() *Peer {
+				p := NewPeer(testPeerID("slow-peer"))
+				p.Latency = 3 * time.Second
+				p.Attempts = 3
+				return p
+			},
+			weights: DefaultQualityWeights,
+			score:   0,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := test.peer()
+			require.Equal(t, test.score, p.QualityScore(test.weights, now))
+			// identical inputs give identical scores
+			require.Equal(t, test.score, p.Copy().QualityScore(test.weights, now))
+		})
+	}
+}
+
+func Test_ExportCsvQualityScore(t *testing.T) {
+	t0 := time.Unix(1000, 0)
+	store := NewPeerStore()
+	p := store.GetOrCreatePeer(testPeerID("quality-peer"))
+	p.Merge(newTestQualityPeer(t0))
+	store.SetQualityWeights(QualityWeights{Status: 1})
+
+	record := p.csvRecord(store.QualityWeights(), t0.Add(time.Hour))
+	require.Equal(t, "100.00", record[csvColumn(t, "quality_score")])
+}
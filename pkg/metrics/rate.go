@@ -0,0 +1,139 @@
+package metrics
+
+import (
+	"time"
+)
+
+const (
+	// RateBucketCount is the number of buckets of the RateWindow (one hour of one-minute buckets)
+	RateBucketCount = 60
+	// RateBucketWidth is the time covered by each bucket of the RateWindow
+	RateBucketWidth = time.Minute
+	// RateWindowMax is the longest window whose rate can be computed
+	RateWindowMax = RateBucketCount * RateBucketWidth
+)
+
+// TrackMessageRates enables the RateWindow of the message metrics of each topic.
+var TrackMessageRates = true
+
+// RateWindow counts the messages of a topic in a fixed ring of RateBucketCount buckets, so that the
+// message rate over the last minutes can be computed. The buckets are recycled as the time goes by,
+// so the window takes the same memory for any crawl length and doesn't allocate once created.
+type RateWindow struct {
+	// index of the bucket period (unix time / RateBucketWidth) of each bucket, to detect the recycled ones
+	Periods [RateBucketCount]int64
+	Counts  [RateBucketCount]int64
+}
+
+// ratePeriod returns the index of the bucket period of t.
+func ratePeriod(t time.Time) int64 {
+	return t.Unix() / int64(RateBucketWidth/time.Second)
+}
+
+// Add counts a message received at t. The messages older than the window are ignored.
+func (w *RateWindow) Add(t time.Time) {
+	if t.IsZero() {
+		return
+	}
+	w.add(ratePeriod(t), 1)
+}
+
+func (w *RateWindow) add(period int64, count int64) {
+	idx := period % RateBucketCount
+	switch {
+	case w.Periods[idx] == period:
+	case w.Periods[idx] > period:
+		// the bucket was already recycled for a newer period
+		return
+	default:
+		w.Periods[idx] = period
+		w.Counts[idx] = 0
+	}
+	w.Counts[idx] += count
+}
+
+// Rate returns the messages per second over the window that ends at now, rounded to whole
+// buckets and bounded by RateWindowMax. The bucket of now is included even if it is still ongoing.
+func (w *RateWindow) Rate(window time.Duration, now time.Time) float64 {
+	if w == nil {
+		return 0
+	}
+	buckets := int64(window / RateBucketWidth)
+	if buckets < 1 {
+		buckets = 1
+	}
+	if buckets > RateBucketCount {
+		buckets = RateBucketCount
+	}
+	return float64(w.count(ratePeriod(now), buckets)) / (float64(buckets) * RateBucketWidth.Seconds())
+}
+
+// count returns the messages of the given number of buckets up to the current period.
+func (w *RateWindow) count(current int64, buckets int64) int64 {
+	var count int64
+	for idx, period := range w.Periods {
+		if period > current-buckets && period <= current {
+			count += w.Counts[idx]
+		}
+	}
+	return count
+}
+
+// merge adds the buckets of other into the window (the ones already recycled in w are ignored).
+func (w *RateWindow) merge(other *RateWindow) {
+	if other == nil {
+		return
+	}
+	for idx, period := range other.Periods {
+		if other.Counts[idx] > 0 {
+			w.add(period, other.Counts[idx])
+		}
+	}
+}
+
+// copy returns a deep copy of the window (nil for nil).
+func (w *RateWindow) copy() *RateWindow {
+	if w == nil {
+		return nil
+	}
+	cp := *w
+	return &cp
+}
+
+// GetMessageRate returns the messages per second received from the peer on the given topic over the
+// last window (up to RateWindowMax). The topic can be a short topic name or the family of the subnet
+// topics (as in GetNumOfMsgFromTopic).
+func (p *Peer) GetMessageRate(topic string, window time.Duration) float64 {
+	p.m.RLock()
+	defer p.m.RUnlock()
+	return p.messageRate(topic, window, time.Now())
+}
+
+// messageRate is GetMessageRate at the given time, without locking (the caller must hold the lock).
+func (p *Peer) messageRate(topicName string, window time.Duration, now time.Time) float64 {
+	var rate float64
+	for topic, msgMetric := range p.MessageMetrics {
+		family, _ := topicFamily(topic)
+		if family == topicName || shortTopicName(topic) == topicName {
+			rate += msgMetric.Rates.Rate(window, now)
+		}
+	}
+	return rate
+}
+
+// GetAverageMessageRate returns the messages per second received from the peer over all the topics
+// within the last RateWindowMax.
+func (p *Peer) GetAverageMessageRate() float64 {
+	p.m.RLock()
+	defer p.m.RUnlock()
+	return p.averageMessageRate(time.Now())
+}
+
+// averageMessageRate is GetAverageMessageRate at the given time (the caller must hold the lock).
+func (p *Peer) averageMessageRate(now time.Time) float64 {
+	var rate float64
+	for _, msgMetric := range p.MessageMetrics {
+		rate += msgMetric.Rates.Rate(RateWindowMax, now)
+	}
+	return rate
+}
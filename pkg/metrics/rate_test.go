@@ -0,0 +1,108 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_RateWindow(t *testing.T) {
+	t0 := time.Unix(1654084800, 0)
+	var w RateWindow
+	for i := 0; i < 120; i++ {
+		w.Add(t0.Add(time.Duration(i) * time.Second))
+	}
+	// 60 messages in each of the last two minutes
+	require.Equal(t, float64(1), w.Rate(time.Minute, t0.Add(time.Minute)))
+	require.Equal(t, float64(1), w.Rate(2*time.Minute, t0.Add(time.Minute)))
+	require.Equal(t, float64(120)/float64(5*60), w.Rate(5*time.Minute, t0.Add(time.Minute)))
+	// the windows are bounded by the ring
+	require.Equal(t, w.Rate(RateWindowMax, t0), w.Rate(24*time.Hour, t0))
+
+	// the buckets are recycled once they are out of the window
+	later := t0.Add(RateWindowMax + time.Minute)
+	w.Add(later)
+	require.Equal(t, float64(1)/RateWindowMax.Seconds(), w.Rate(RateWindowMax, later))
+	// and the messages older than the window are ignored
+	w.Add(t0)
+	require.Equal(t, float64(1)/RateWindowMax.Seconds(), w.Rate(RateWindowMax, later))
+
+	var nilWindow *RateWindow
+	require.Equal(t, float64(0), nilWindow.Rate(time.Minute, t0))
+}
+
+func Test_RateWindowAllocs(t *testing.T) {
+	t0 := time.Unix(1654084800, 0)
+	var w RateWindow
+	i := 0
+	allocs := testing.AllocsPerRun(1000, func() {
+		w.Add(t0.Add(time.Duration(i) * time.Second))
+		i++
+	})
+	require.Equal(t, float64(0), allocs)
+}
+
+func Test_MessageRateDecays(t *testing.T) {
+	t0 := time.Unix(1654084800, 0)
+	p := NewPeer(testPeerID("rate-peer"))
+
+	// a burst of blocks and attestations within a minute
+	for i := 0; i < 30; i++ {
+		p.MessageEvent(testBlockTopic, t0.Add(time.Duration(i)*time.Second))
+		p.MessageEvent(testAttSubnet17Topic, t0.Add(time.Duration(i)*time.Second))
+		p.MessageEvent("/eth2/bba4da96/beacon_attestation_3/ssz_snappy", t0.Add(time.Duration(i)*time.Second))
+	}
+	now := t0.Add(30 * time.Second)
+	require.Equal(t, float64(30)/float64(5*60), p.messageRate(BeaconBlockTopicName, 5*time.Minute, now))
+	require.Equal(t, float64(30)/float64(60*60), p.messageRate(BeaconBlockTopicName, 60*time.Minute, now))
+	require.Equal(t, float64(60)/float64(5*60), p.messageRate(AttestationTopicName, 5*time.Minute, now))
+	require.Equal(t, float64(90)/RateWindowMax.Seconds(), p.averageMessageRate(now))
+
+	record := p.csvRecord(DefaultQualityWeights, now)
+	require.Equal(t, "0.100", record[csvColumn(t, "block_rate_5m")])
+	require.Equal(t, "0.200", record[csvColumn(t, "attestation_rate_5m")])
+
+	// followed by silence: the short window decays first, and the long one later
+	now = t0.Add(10 * time.Minute)
+	require.Equal(t, float64(0), p.messageRate(BeaconBlockTopicName, 5*time.Minute, now))
+	require.Equal(t, float64(30)/float64(60*60), p.messageRate(BeaconBlockTopicName, 60*time.Minute, now))
+	now = t0.Add(2 * time.Hour)
+	require.Equal(t, float64(0), p.messageRate(BeaconBlockTopicName, 60*time.Minute, now))
+	require.Equal(t, float64(0), p.messageRate(AttestationTopicName, 60*time.Minute, now))
+	require.Equal(t, float64(0), p.averageMessageRate(now))
+	// while the counts stay put
+	require.Equal(t, int64(30), p.GetNumOfMsgFromTopic(BeaconBlockTopicName))
+	require.Equal(t, int64(60), p.GetNumOfMsgFromTopic(AttestationTopicName))
+
+	record = p.csvRecord(DefaultQualityWeights, now)
+	require.Equal(t, "0.000", record[csvColumn(t, "block_rate_60m")])
+	require.Equal(t, "0.000", record[csvColumn(t, "attestation_rate_60m")])
+}
+
+func Test_MessageRateMerge(t *testing.T) {
+	t0 := time.Unix(1654084800, 0)
+	p := NewPeer(testPeerID("rate-merge"))
+	other := NewPeer(p.ID)
+	for i := 0; i < 6; i++ {
+		p.MessageEvent(testBlockTopic, t0)
+		other.MessageEvent(testBlockTopic, t0)
+	}
+	other.MessageEvent(testAttSubnet17Topic, t0)
+	p.Merge(other)
+	require.Equal(t, float64(12)/float64(60), p.messageRate(BeaconBlockTopicName, time.Minute, t0))
+	require.Equal(t, float64(1)/float64(60), p.messageRate(AttestationTopicName, time.Minute, t0))
+
+	// the copies don't share the windows
+	cp := p.Copy()
+	p.MessageEvent(testBlockTopic, t0)
+	require.Equal(t, float64(12)/float64(60), cp.messageRate(BeaconBlockTopicName, time.Minute, t0))
+
+	// without tracking, no window is allocated
+	TrackMessageRates = false
+	defer func() { TrackMessageRates = true }()
+	untracked := NewPeer(testPeerID("rate-untracked"))
+	untracked.MessageEvent(testBlockTopic, t0)
+	require.Nil(t, untracked.MessageMetrics[testBlockTopic].Rates)
+	require.Equal(t, float64(0), untracked.messageRate(BeaconBlockTopicName, time.Minute, t0))
+}
@@ -0,0 +1,93 @@
+package metrics
+
+import (
+	"math"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// RequestBackoff spaces out the Status and MetaData requests sent to the same peer on its reconnections.
+// A peer gets requested again once Base went by since the last request, and each consecutive failure
+// doubles that interval up to Max (uncapped if Max is 0). A successful response brings it back to Base.
+// The Status and the MetaData requests back off independently, so a peer that answers the Status
+// requests but never the MetaData ones keeps getting the former on the normal cadence.
+type RequestBackoff struct {
+	Base time.Duration
+	Max  time.Duration
+}
+
+// DefaultRequestBackoff is the backoff used unless the PeerStore is configured with another one.
+var DefaultRequestBackoff = RequestBackoff{
+	Base: 5 * time.Minute,
+	Max:  6 * time.Hour,
+}
+
+// Interval returns the time to wait after a request that closed the given number of consecutive failures.
+func (b RequestBackoff) Interval(failures int) time.Duration {
+	interval := b.Base
+	for i := 0; i < failures && interval > 0; i++ {
+		if b.Max > 0 && interval >= b.Max {
+			break
+		}
+		// the uncapped intervals stop doubling before they overflow
+		if interval > math.MaxInt64/2 {
+			break
+		}
+		interval *= 2
+	}
+	if b.Max > 0 && interval > b.Max {
+		interval = b.Max
+	}
+	return interval
+}
+
+// due returns whether a request is due at now, given the last one and the consecutive failures up to it.
+func (b RequestBackoff) due(last time.Time, failures int, now time.Time) bool {
+	if last.IsZero() {
+		return true
+	}
+	return !now.Before(last.Add(b.Interval(failures)))
+}
+
+// ShouldRequestStatus returns whether a Status request has to be sent to the peer at now.
+func (p *Peer) ShouldRequestStatus(backoff RequestBackoff, now time.Time) bool {
+	p.m.RLock()
+	defer p.m.RUnlock()
+	return backoff.due(p.LastStatusRequest, p.StatusFailureStreak, now)
+}
+
+// ShouldRequestMetadata returns whether a MetaData request has to be sent to the peer at now.
+func (p *Peer) ShouldRequestMetadata(backoff RequestBackoff, now time.Time) bool {
+	p.m.RLock()
+	defer p.m.RUnlock()
+	return backoff.due(p.LastMetadataAttempt, p.MetadataFailureStreak, now)
+}
+
+// SetRequestBackoff configures the backoff of the Status and MetaData requests decided by the store.
+func (s *PeerStore) SetRequestBackoff(backoff RequestBackoff) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.reqBackoff = backoff
+}
+
+// RequestBackoff returns the backoff of the Status and MetaData requests decided by the store.
+func (s *PeerStore) RequestBackoff() RequestBackoff {
+	s.m.RLock()
+	defer s.m.RUnlock()
+	return s.reqBackoff
+}
+
+// ShouldRequestStatus returns whether a Status request has to be sent to the given peer at now
+// (always for the peers that aren't in the store).
+func (s *PeerStore) ShouldRequestStatus(pid peer.ID, now time.Time) bool {
+	p, ok := s.GetPeer(pid)
+	return !ok || p.ShouldRequestStatus(s.RequestBackoff(), now)
+}
+
+// ShouldRequestMetadata returns whether a MetaData request has to be sent to the given peer at now
+// (always for the peers that aren't in the store).
+func (s *PeerStore) ShouldRequestMetadata(pid peer.ID, now time.Time) bool {
+	p, ok := s.GetPeer(pid)
+	return !ok || p.ShouldRequestMetadata(s.RequestBackoff(), now)
+}
@@ -0,0 +1,111 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/migalabs/armiarma/pkg/db/models"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_RequestBackoffInterval(t *testing.T) {
+	backoff := RequestBackoff{Base: time.Minute, Max: 10 * time.Minute}
+	intervals := make([]time.Duration, 0)
+	for failures := 0; failures < 6; failures++ {
+		intervals = append(intervals, backoff.Interval(failures))
+	}
+	require.Equal(t, []time.Duration{
+		time.Minute, 2 * time.Minute, 4 * time.Minute, 8 * time.Minute, 10 * time.Minute, 10 * time.Minute,
+	}, intervals)
+	// without overflowing on the long streaks
+	require.Equal(t, 10*time.Minute, backoff.Interval(1000))
+
+	// a backoff without max keeps doubling
+	uncapped := RequestBackoff{Base: time.Minute}
+	require.Equal(t, 32*time.Minute, uncapped.Interval(5))
+	require.Greater(t, uncapped.Interval(1000), uncapped.Interval(5))
+}
+
+func Test_PeerRequestBackoff(t *testing.T) {
+	backoff := RequestBackoff{Base: time.Minute, Max: 10 * time.Minute}
+	t0 := time.Unix(1654084800, 0)
+	p := NewPeer(testPeerID("backoff-peer"))
+	outcome := func(at time.Time, category models.ReqRespCategory) models.ReqRespOutcome {
+		return models.ReqRespOutcome{PeerID: p.ID, Timestamp: at, Category: category, ResponseCode: -1}
+	}
+
+	// never requested
+	require.True(t, p.ShouldRequestStatus(backoff, t0))
+	require.True(t, p.ShouldRequestMetadata(backoff, t0))
+
+	// the peer answers the Status requests, but never the MetaData ones: each time that a request
+	// is due, both get sent if they are, and the waits of the MetaData requests keep growing
+	now := t0
+	metadataWaits := make([]time.Duration, 0)
+	lastMetadata := time.Time{}
+	for len(metadataWaits) < 5 {
+		if p.ShouldRequestStatus(backoff, now) {
+			p.StatusRequestEvent(outcome(now, models.ReqRespSuccess))
+		}
+		if p.ShouldRequestMetadata(backoff, now) {
+			if !lastMetadata.IsZero() {
+				metadataWaits = append(metadataWaits, now.Sub(lastMetadata))
+			}
+			lastMetadata = now
+			p.MetadataRequestEvent(models.MetadataRequest{ReqRespOutcome: outcome(now, models.ReqRespTimeout)})
+		}
+		// the status keeps its normal cadence
+		require.False(t, p.ShouldRequestStatus(backoff, now.Add(backoff.Base-time.Second)))
+		require.True(t, p.ShouldRequestStatus(backoff, now.Add(backoff.Base)))
+		now = now.Add(backoff.Base)
+	}
+	require.Equal(t, []time.Duration{
+		2 * time.Minute, 4 * time.Minute, 8 * time.Minute, 10 * time.Minute, 10 * time.Minute,
+	}, metadataWaits)
+	require.Equal(t, 6, p.MetadataFailureStreak)
+	require.Equal(t, 0, p.StatusFailureStreak)
+	require.Equal(t, int64(0), p.MetadataSuccesses)
+
+	// a successful response resets the backoff
+	p.MetadataRequestEvent(models.MetadataRequest{ReqRespOutcome: outcome(now, models.ReqRespSuccess)})
+	require.Equal(t, 0, p.MetadataFailureStreak)
+	require.False(t, p.ShouldRequestMetadata(backoff, now.Add(backoff.Base-time.Second)))
+	require.True(t, p.ShouldRequestMetadata(backoff, now.Add(backoff.Base)))
+
+	// the failed Status requests back off on their own
+	p.StatusRequestEvent(outcome(now, models.ReqRespStreamReset))
+	require.False(t, p.ShouldRequestStatus(backoff, now.Add(backoff.Base)))
+	require.True(t, p.ShouldRequestStatus(backoff, now.Add(2*backoff.Base)))
+	require.True(t, p.ShouldRequestMetadata(backoff, now.Add(backoff.Base)))
+}
+
+func Test_PeerStoreRequestBackoff(t *testing.T) {
+	store := NewPeerStore()
+	require.Equal(t, DefaultRequestBackoff, store.RequestBackoff())
+	backoff := RequestBackoff{Base: time.Hour, Max: 4 * time.Hour}
+	store.SetRequestBackoff(backoff)
+	t0 := time.Unix(1654084800, 0)
+
+	// the unknown peers always get requested
+	pid := testPeerID("store-backoff-peer")
+	require.True(t, store.ShouldRequestStatus(pid, t0))
+	require.True(t, store.ShouldRequestMetadata(pid, t0))
+
+	// the ones that got requested through their host info wait for the backoff of the store
+	hInfo := models.NewHostInfo(pid, "")
+	hInfo.AddAtt(models.StatusRequestAttribute, models.ReqRespOutcome{PeerID: pid, Timestamp: t0, Category: models.ReqRespSuccess})
+	hInfo.AddAtt(models.MetadataRequestAttribute, models.MetadataRequest{
+		ReqRespOutcome: models.ReqRespOutcome{PeerID: pid, Timestamp: t0, Category: models.ReqRespTimeout},
+	})
+	store.GetOrCreatePeer(pid).FetchHostInfo(hInfo)
+	require.False(t, store.ShouldRequestStatus(pid, t0.Add(30*time.Minute)))
+	require.True(t, store.ShouldRequestStatus(pid, t0.Add(time.Hour)))
+	require.False(t, store.ShouldRequestMetadata(pid, t0.Add(time.Hour)))
+	require.True(t, store.ShouldRequestMetadata(pid, t0.Add(2*time.Hour)))
+
+	// and the copies keep the scheduling state
+	p, _ := store.GetPeer(pid)
+	cp := p.Copy()
+	require.Equal(t, p.LastStatusRequest, cp.LastStatusRequest)
+	require.Equal(t, 1, cp.MetadataFailureStreak)
+}
@@ -0,0 +1,167 @@
+package metrics
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultSeenMessagesWindow is how long a message ID is remembered after its first delivery
+	DefaultSeenMessagesWindow = 10 * time.Minute
+	// DefaultSeenMessagesCapacity is the number of message IDs remembered at most (~15MB)
+	DefaultSeenMessagesCapacity = 100000
+)
+
+// SeenMessages is the set of the gossip message IDs delivered within the last window, shared by
+// the peers of a store to tell which one delivered each message first. It keeps at most capacity
+// IDs, evicting the oldest first deliveries, so its memory stays constant (~150 bytes per ID).
+// It has no false positives: a re-delivery is only counted as a duplicate if the ID is still in
+// the set. The IDs that expired, or that got evicted because more than capacity messages were
+// delivered within the window, are counted as first seen again when re-delivered.
+type SeenMessages struct {
+	m sync.Mutex
+
+	window   time.Duration
+	capacity int
+	// first deliveries, from the oldest to the newest, and their elements by ID
+	order *list.List
+	ids   map[string]*list.Element
+}
+
+// seenMessage is the first delivery of a message ID.
+type seenMessage struct {
+	id string
+	t  time.Time
+}
+
+// NewSeenMessages returns an empty set that remembers up to capacity IDs for the given window.
+func NewSeenMessages(window time.Duration, capacity int) *SeenMessages {
+	return &SeenMessages{
+		window:   window,
+		capacity: capacity,
+		order:    list.New(),
+		ids:      make(map[string]*list.Element),
+	}
+}
+
+// SetLimits changes the window and the capacity of the set, evicting the IDs that exceed the new capacity.
+func (s *SeenMessages) SetLimits(window time.Duration, capacity int) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.window = window
+	s.capacity = capacity
+	for s.order.Len() > 0 && s.order.Len() > s.capacity {
+		s.remove(s.order.Front())
+	}
+}
+
+// Limits returns the window and the capacity of the set.
+func (s *SeenMessages) Limits() (time.Duration, int) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return s.window, s.capacity
+}
+
+// Seen records the delivery of the message ID at t, returning whether it was already delivered
+// within the window (i.e. it's a duplicate). The window counts from the first delivery, so the
+// duplicates don't extend it.
+func (s *SeenMessages) Seen(id string, t time.Time) bool {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	// the deliveries are recorded in arrival order, so the expired ones are at the front
+	for front := s.order.Front(); front != nil && !t.Before(front.Value.(seenMessage).t.Add(s.window)); front = s.order.Front() {
+		s.remove(front)
+	}
+	if _, ok := s.ids[id]; ok {
+		return true
+	}
+	if s.capacity <= 0 {
+		return false
+	}
+	if s.order.Len() >= s.capacity {
+		s.remove(s.order.Front())
+	}
+	s.ids[id] = s.order.PushBack(seenMessage{id: id, t: t})
+	return false
+}
+
+// remove drops the element from the set (needs the lock).
+func (s *SeenMessages) remove(e *list.Element) {
+	s.order.Remove(e)
+	delete(s.ids, e.Value.(seenMessage).id)
+}
+
+// Len returns the number of IDs in the set.
+func (s *SeenMessages) Len() int {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return s.order.Len()
+}
+
+// SetSeenMessagesLimits configures the window and the capacity of the message IDs shared by the
+// peers of the store to tell the first deliveries from the duplicates (see SeenMessages).
+func (s *PeerStore) SetSeenMessagesLimits(window time.Duration, capacity int) {
+	s.seenMessages.SetLimits(window, capacity)
+}
+
+// SeenMessagesLimits returns the window and the capacity of the message IDs of the store.
+func (s *PeerStore) SeenMessagesLimits() (time.Duration, int) {
+	return s.seenMessages.Limits()
+}
+
+// SeenEvent classifies a delivery of the message ID by the peer (reported by the pubsub router,
+// either as first delivery or duplicate) as first seen or duplicate within the window of the store.
+// The router's own seen cache drops the duplicates before the subscriptions, so the deliveries have
+// to be reported from its tracer. The messages without an ID, or of peers that don't belong to a
+// store, are not classified.
+func (p *Peer) SeenEvent(topic string, msgID string, t time.Time) {
+	if msgID == "" || p.seenMessages == nil {
+		return
+	}
+	duplicate := p.seenMessages.Seen(msgID, t)
+
+	p.m.Lock()
+	defer p.m.Unlock()
+	msgMetric := p.messageMetric(topic)
+	if duplicate {
+		msgMetric.DuplicateCount++
+	} else {
+		msgMetric.FirstSeenCount++
+	}
+}
+
+// GetFirstSeenRatio returns the share of the deliveries of the peer on the given topic that nobody
+// delivered before (see SeenEvent), 0 if it didn't deliver any message with an ID.
+// The topic can be a short topic name or the family of the subnet topics (as in GetNumOfMsgFromTopic).
+func (p *Peer) GetFirstSeenRatio(topic string) float64 {
+	p.m.RLock()
+	defer p.m.RUnlock()
+	firstSeen, duplicates := p.seenCounts(topic)
+	if firstSeen+duplicates == 0 {
+		return 0
+	}
+	return float64(firstSeen) / float64(firstSeen+duplicates)
+}
+
+// GetFirstSeenCount returns the number of messages of the given topic that the peer delivered
+// before anyone else (see GetFirstSeenRatio).
+func (p *Peer) GetFirstSeenCount(topic string) int64 {
+	p.m.RLock()
+	defer p.m.RUnlock()
+	firstSeen, _ := p.seenCounts(topic)
+	return firstSeen
+}
+
+// seenCounts adds up the first seen and duplicate messages of the matching topics (needs the lock).
+func (p *Peer) seenCounts(topicName string) (firstSeen, duplicates int64) {
+	for topic, msgMetric := range p.MessageMetrics {
+		family, _ := topicFamily(topic)
+		if family == topicName || shortTopicName(topic) == topicName {
+			firstSeen += msgMetric.FirstSeenCount
+			duplicates += msgMetric.DuplicateCount
+		}
+	}
+	return firstSeen, duplicates
+}
@@ -0,0 +1,106 @@
+package metrics
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_SeenMessagesAcrossPeers(t *testing.T) {
+	t0 := time.Unix(1654084800, 0)
+	store := NewPeerStore()
+	peers := []*Peer{
+		store.GetOrCreatePeer(testPeerID("seen-first")),
+		store.GetOrCreatePeer(testPeerID("seen-second")),
+		store.GetOrCreatePeer(testPeerID("seen-third")),
+	}
+
+	// the same block through the three peers: only the first one delivered it first
+	for i, p := range peers {
+		p.SeenEvent(testBlockTopic, "block-1", t0.Add(time.Duration(i)*time.Millisecond))
+	}
+	var firstSeen, duplicates int64
+	for _, p := range peers {
+		msgMetric := p.MessageMetrics[testBlockTopic]
+		firstSeen += msgMetric.FirstSeenCount
+		duplicates += msgMetric.DuplicateCount
+	}
+	require.Equal(t, int64(1), firstSeen)
+	require.Equal(t, int64(2), duplicates)
+	require.Equal(t, int64(1), peers[0].GetFirstSeenCount(BeaconBlockTopicName))
+	require.Equal(t, float64(1), peers[0].GetFirstSeenRatio(BeaconBlockTopicName))
+	require.Equal(t, float64(0), peers[1].GetFirstSeenRatio(BeaconBlockTopicName))
+
+	// the subnets are grouped into their family
+	peers[1].SeenEvent(testAttSubnet17Topic, "att-1", t0)
+	peers[1].SeenEvent("/eth2/bba4da96/beacon_attestation_3/ssz_snappy", "att-2", t0)
+	peers[2].SeenEvent(testAttSubnet17Topic, "att-1", t0)
+	require.Equal(t, int64(2), peers[1].GetFirstSeenCount(AttestationTopicName))
+	require.Equal(t, float64(1), peers[1].GetFirstSeenRatio(AttestationTopicName))
+	require.Equal(t, float64(0), peers[2].GetFirstSeenRatio(AttestationTopicName))
+
+	// the messages without an ID are not classified
+	peers[2].SeenEvent(testBlockTopic, "", t0)
+	require.Equal(t, int64(1), peers[2].MessageMetrics[testBlockTopic].DuplicateCount)
+	require.Equal(t, int64(0), peers[2].MessageMetrics[testBlockTopic].FirstSeenCount)
+
+	record := peers[1].csvRecord(DefaultQualityWeights, t0)
+	require.Equal(t, "0", record[csvColumn(t, "block_first_seen")])
+	require.Equal(t, "2", record[csvColumn(t, "attestation_first_seen")])
+
+	// the peers without a store don't classify their messages
+	loose := NewPeer(testPeerID("seen-loose"))
+	loose.SeenEvent(testBlockTopic, "block-1", t0)
+	require.Equal(t, float64(0), loose.GetFirstSeenRatio(BeaconBlockTopicName))
+}
+
+func Test_SeenMessagesWindow(t *testing.T) {
+	t0 := time.Unix(1654084800, 0)
+	seen := NewSeenMessages(time.Minute, 10)
+
+	require.False(t, seen.Seen("msg-1", t0))
+	require.True(t, seen.Seen("msg-1", t0.Add(30*time.Second)))
+	// the duplicates don't extend the window of the first delivery
+	require.True(t, seen.Seen("msg-1", t0.Add(59*time.Second)))
+	require.False(t, seen.Seen("msg-1", t0.Add(time.Minute)))
+	require.Equal(t, 1, seen.Len())
+
+	// the expired IDs are dropped as the new ones arrive
+	require.False(t, seen.Seen("msg-2", t0.Add(90*time.Second)))
+	require.False(t, seen.Seen("msg-3", t0.Add(3*time.Minute)))
+	require.Equal(t, 1, seen.Len())
+}
+
+func Test_SeenMessagesCapacity(t *testing.T) {
+	t0 := time.Unix(1654084800, 0)
+	seen := NewSeenMessages(time.Hour, 100)
+
+	for i := 0; i < 150; i++ {
+		require.False(t, seen.Seen(fmt.Sprintf("msg-%d", i), t0.Add(time.Duration(i)*time.Millisecond)))
+	}
+	require.Equal(t, 100, seen.Len())
+	// the oldest IDs were evicted, so they are first seen again
+	require.False(t, seen.Seen("msg-0", t0.Add(time.Second)))
+	require.True(t, seen.Seen("msg-149", t0.Add(time.Second)))
+	require.Equal(t, 100, seen.Len())
+
+	// shrinking the capacity evicts the oldest ones right away
+	seen.SetLimits(time.Hour, 10)
+	require.Equal(t, 10, seen.Len())
+	require.True(t, seen.Seen("msg-149", t0.Add(time.Second)))
+	require.False(t, seen.Seen("msg-139", t0.Add(time.Second)))
+
+	// and a capacity of 0 disables the classification
+	seen.SetLimits(time.Hour, 0)
+	require.Equal(t, 0, seen.Len())
+	require.False(t, seen.Seen("msg-149", t0.Add(time.Second)))
+	require.False(t, seen.Seen("msg-149", t0.Add(time.Second)))
+
+	store := NewPeerStore()
+	store.SetSeenMessagesLimits(5*time.Minute, 1000)
+	window, capacity := store.SeenMessagesLimits()
+	require.Equal(t, 5*time.Minute, window)
+	require.Equal(t, 1000, capacity)
+}
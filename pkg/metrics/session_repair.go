@@ -0,0 +1,119 @@
+package metrics
+
+import (
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultMaxOpenSession is the default inactivity after which an open session is considered dangling.
+const DefaultMaxOpenSession = 24 * time.Hour
+
+// MaxOpenSession is the time without any activity after which an open session is considered
+// dangling (its disconnection got lost) and gets closed by RepairSessions.
+var MaxOpenSession = DefaultMaxOpenSession
+
+// RepairReport counts the changes made by RepairSessions to the event history of the peers.
+type RepairReport struct {
+	// disconnections without an open session, dropped
+	OrphanedDisconnections int
+	// sessions that never got their disconnection, closed
+	ClosedSessions int
+	// IsConnected flags contradicted by the event history, fixed
+	FixedConnectedFlags int
+}
+
+// Changed returns whether any repair was made.
+func (r RepairReport) Changed() bool {
+	return r.OrphanedDisconnections > 0 || r.ClosedSessions > 0 || r.FixedConnectedFlags > 0
+}
+
+// Add accumulates the repairs of the given report.
+func (r *RepairReport) Add(o RepairReport) {
+	r.OrphanedDisconnections += o.OrphanedDisconnections
+	r.ClosedSessions += o.ClosedSessions
+	r.FixedConnectedFlags += o.FixedConnectedFlags
+}
+
+// RepairSessions makes the connection/disconnection history of the peer consistent,
+// so that the i-th connection is always closed by the i-th disconnection:
+//   - a disconnection without an open session is dropped
+//   - a connection over an open session closes the previous session at its time
+//   - an open session that isn't connected anymore, or that had no activity in MaxOpenSession,
+//     is closed at the last activity of the peer
+//   - the IsConnected flag follows the resulting history
+//
+// Returns what it changed.
+func (p *Peer) RepairSessions(now time.Time) RepairReport {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	var report RepairReport
+	conns := make([]time.Time, 0, len(p.ConnectionTimes))
+	disconns := make([]time.Time, 0, len(p.DisconnectionTimes))
+	open := false
+	i, j := 0, 0
+	for i < len(p.ConnectionTimes) || j < len(p.DisconnectionTimes) {
+		hasConn, hasDisconn := i < len(p.ConnectionTimes), j < len(p.DisconnectionTimes)
+		switch {
+		case !open && hasDisconn && (!hasConn || p.DisconnectionTimes[j].Before(p.ConnectionTimes[i])):
+			report.OrphanedDisconnections++
+			j++
+		case !open:
+			conns = append(conns, p.ConnectionTimes[i])
+			open = true
+			i++
+		case hasDisconn && (!hasConn || !p.ConnectionTimes[i].Before(p.DisconnectionTimes[j])):
+			disconns = append(disconns, p.DisconnectionTimes[j])
+			open = false
+			j++
+		default:
+			// the disconnection of the open session got lost before the new connection
+			disconns = append(disconns, p.ConnectionTimes[i])
+			report.ClosedSessions++
+			open = false
+		}
+	}
+	p.ConnectionTimes = conns
+	p.DisconnectionTimes = disconns
+
+	if open {
+		last := p.lastActivity()
+		if !p.IsConnected || now.Sub(last) > MaxOpenSession {
+			p.DisconnectionTimes = append(p.DisconnectionTimes, last)
+			report.ClosedSessions++
+			open = false
+		}
+	}
+	if p.IsConnected != open {
+		p.IsConnected = open
+		report.FixedConnectedFlags++
+	}
+	if report.Changed() {
+		log.WithFields(log.Fields{
+			"peer":                    p.ID.String(),
+			"orphaned-disconnections": report.OrphanedDisconnections,
+			"closed-sessions":         report.ClosedSessions,
+			"fixed-connected-flags":   report.FixedConnectedFlags,
+		}).Debug("repaired the sessions of the peer")
+	}
+	return report
+}
+
+// RepairSessions repairs the session history of every peer in the store (see Peer.RepairSessions),
+// logging the aggregate repairs.
+func (s *PeerStore) RepairSessions(now time.Time) RepairReport {
+	var report RepairReport
+	s.ForEachPeer(func(p *Peer) bool {
+		report.Add(p.RepairSessions(now))
+		return true
+	})
+	if report.Changed() {
+		log.WithFields(log.Fields{
+			"orphaned-disconnections": report.OrphanedDisconnections,
+			"closed-sessions":         report.ClosedSessions,
+			"fixed-connected-flags":   report.FixedConnectedFlags,
+		}).Info("repaired inconsistent peer sessions")
+	}
+	return report
+}
@@ -0,0 +1,131 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_PeerRepairSessions(t *testing.T) {
+	t0 := time.Unix(1000, 0)
+	at := func(minutes ...int) []time.Time {
+		times := make([]time.Time, 0, len(minutes))
+		for _, m := range minutes {
+			times = append(times, t0.Add(time.Duration(m)*time.Minute))
+		}
+		return times
+	}
+	// the histories are evaluated one hour after t0
+	now := t0.Add(time.Hour)
+
+	tests := []struct {
+		name         string
+		conns        []time.Time
+		disconns     []time.Time
+		connected    bool
+		lastMessage  time.Time
+		wantConns    []time.Time
+		wantDisconns []time.Time
+		wantConn     bool
+		want         RepairReport
+	}{
+		{
+			name:         "consistent history",
+			conns:        at(0, 10),
+			disconns:     at(5),
+			connected:    true,
+			lastMessage:  t0.Add(50 * time.Minute),
+			wantConns:    at(0, 10),
+			wantDisconns: at(5),
+			wantConn:     true,
+		},
+		{
+			name:         "orphaned disconnections",
+			conns:        at(10),
+			disconns:     at(0, 5, 20, 30),
+			wantConns:    at(10),
+			wantDisconns: at(20),
+			want:         RepairReport{OrphanedDisconnections: 3},
+		},
+		{
+			name:         "connection over an open session",
+			conns:        at(0, 10, 20),
+			disconns:     at(30),
+			wantConns:    at(0, 10, 20),
+			wantDisconns: at(10, 20, 30),
+			want:         RepairReport{ClosedSessions: 2},
+		},
+		{
+			name:         "open session of a disconnected peer",
+			conns:        at(0, 10),
+			disconns:     at(5),
+			lastMessage:  t0.Add(15 * time.Minute),
+			wantConns:    at(0, 10),
+			wantDisconns: at(5, 15),
+			want:         RepairReport{ClosedSessions: 1},
+		},
+		{
+			name:         "open session without activity for too long",
+			conns:        at(0),
+			connected:    true,
+			wantConns:    at(0),
+			wantDisconns: at(0),
+			want:         RepairReport{ClosedSessions: 1, FixedConnectedFlags: 1},
+		},
+		{
+			name:         "connected flag without open session",
+			conns:        at(0),
+			disconns:     at(5),
+			connected:    true,
+			wantConns:    at(0),
+			wantDisconns: at(5),
+			want:         RepairReport{FixedConnectedFlags: 1},
+		},
+		{
+			name:         "connection and disconnection at the same time",
+			conns:        at(0, 5),
+			disconns:     at(0, 5),
+			wantConns:    at(0, 5),
+			wantDisconns: at(0, 5),
+		},
+	}
+
+	defer func(maxOpen time.Duration) { MaxOpenSession = maxOpen }(MaxOpenSession)
+	MaxOpenSession = 30 * time.Minute
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := NewPeer(testPeerID("repair-peer"))
+			p.ConnectionTimes = append(p.ConnectionTimes, test.conns...)
+			p.DisconnectionTimes = append(p.DisconnectionTimes, test.disconns...)
+			p.IsConnected = test.connected
+			if !test.lastMessage.IsZero() {
+				p.MessageMetrics["beacon_block"] = &MessageMetric{LastMessageTime: test.lastMessage}
+			}
+
+			require.Equal(t, test.want, p.RepairSessions(now))
+			require.Equal(t, test.wantConns, p.ConnectionTimes)
+			require.Equal(t, test.wantDisconns, p.DisconnectionTimes)
+			require.Equal(t, test.wantConn, p.IsConnected)
+			// the repaired history is stable
+			require.False(t, p.RepairSessions(now).Changed())
+		})
+	}
+}
+
+func Test_PeerStoreRepairSessions(t *testing.T) {
+	store := NewPeerStore()
+	t0 := time.Unix(1000, 0)
+
+	orphaned := store.GetOrCreatePeer(testPeerID("orphaned-peer"))
+	orphaned.DisconnectionTimes = append(orphaned.DisconnectionTimes, t0)
+	dangling := store.GetOrCreatePeer(testPeerID("dangling-peer"))
+	dangling.ConnectionEvent(t0)
+	dangling.IsConnected = false
+	store.GetOrCreatePeer(testPeerID("healthy-peer")).ConnectionEvent(t0)
+
+	report := store.RepairSessions(t0.Add(time.Minute))
+	require.Equal(t, RepairReport{OrphanedDisconnections: 1, ClosedSessions: 1}, report)
+	require.Empty(t, orphaned.DisconnectionTimes)
+	require.Equal(t, []time.Duration{0}, dangling.SessionDurations())
+}
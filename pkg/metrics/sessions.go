@@ -0,0 +1,201 @@
+package metrics
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/migalabs/armiarma/pkg/utils"
+	"github.com/pkg/errors"
+)
+
+const (
+	// SessionsHistogramFile is the default name of the sessions histogram export
+	SessionsHistogramFile = "sessions_histogram.csv"
+	// AllClients is the group of the histogram that aggregates the sessions of all the peers
+	AllClients = "all"
+)
+
+// DefaultSessionBuckets are the upper bounds of the buckets of the session histogram
+// (<10s, <1m, <10m, <1h, <6h, and a last one for the longer sessions).
+var DefaultSessionBuckets = []time.Duration{
+	10 * time.Second,
+	time.Minute,
+	10 * time.Minute,
+	time.Hour,
+	6 * time.Hour,
+}
+
+// SessionHistogram is the distribution of the session durations.
+// Counts has one more item than Buckets, for the sessions longer than the last bound.
+type SessionHistogram struct {
+	Buckets []time.Duration
+	Counts  []int64
+	Total   int64
+
+	durations []time.Duration
+	sorted    bool
+}
+
+// NewSessionHistogram returns an empty histogram with the given (ascending) bucket bounds.
+func NewSessionHistogram(buckets []time.Duration) *SessionHistogram {
+	return &SessionHistogram{
+		Buckets:   buckets,
+		Counts:    make([]int64, len(buckets)+1),
+		durations: make([]time.Duration, 0),
+	}
+}
+
+// Add includes a session duration in the histogram.
+func (h *SessionHistogram) Add(d time.Duration) {
+	// first bucket whose bound is above the duration
+	idx := sort.Search(len(h.Buckets), func(i int) bool {
+		return d < h.Buckets[i]
+	})
+	h.Counts[idx]++
+	h.Total++
+	h.durations = append(h.durations, d)
+	h.sorted = false
+}
+
+// Percentile returns the duration below which lay the q (0-100) percent of the sessions
+// (nearest-rank method), or 0 if there are no sessions.
+func (h *SessionHistogram) Percentile(q float64) time.Duration {
+	if len(h.durations) == 0 {
+		return 0
+	}
+	if !h.sorted {
+		sort.Slice(h.durations, func(i, j int) bool {
+			return h.durations[i] < h.durations[j]
+		})
+		h.sorted = true
+	}
+	rank := int(math.Ceil(q / 100 * float64(len(h.durations))))
+	if rank < 1 {
+		rank = 1
+	}
+	if rank > len(h.durations) {
+		rank = len(h.durations)
+	}
+	return h.durations[rank-1]
+}
+
+// BucketLabels returns the human-readable name of each bucket (i.e. "<10s", ">=6h").
+func (h *SessionHistogram) BucketLabels() []string {
+	labels := make([]string, 0, len(h.Counts))
+	for _, bound := range h.Buckets {
+		labels = append(labels, "<"+ShortDuration(bound))
+	}
+	if len(h.Buckets) > 0 {
+		labels = append(labels, ">="+ShortDuration(h.Buckets[len(h.Buckets)-1]))
+	} else {
+		labels = append(labels, "all")
+	}
+	return labels
+}
+
+// ShortDuration formats the duration without the trailing zero units ("1h" instead of "1h0m0s").
+func ShortDuration(d time.Duration) string {
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = s[:len(s)-2]
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = s[:len(s)-2]
+	}
+	return s
+}
+
+// SessionHistogram returns the histogram of the completed sessions of all the peers.
+func (s *PeerStore) SessionHistogram(buckets []time.Duration) *SessionHistogram {
+	return s.SessionHistograms(buckets, false)[AllClients]
+}
+
+// SessionHistograms returns the histogram of the completed sessions of all the peers under AllClients,
+// plus one histogram per client name if groupByClient is set.
+func (s *PeerStore) SessionHistograms(buckets []time.Duration, groupByClient bool) map[string]*SessionHistogram {
+	histograms := map[string]*SessionHistogram{
+		AllClients: NewSessionHistogram(buckets),
+	}
+	s.ForEachPeer(func(p *Peer) bool {
+		p.m.RLock()
+		defer p.m.RUnlock()
+
+		var clientHist *SessionHistogram
+		if groupByClient {
+			client := p.ClientName
+			if client == "" {
+				client = utils.Unknown
+			}
+			var ok bool
+			clientHist, ok = histograms[client]
+			if !ok {
+				clientHist = NewSessionHistogram(buckets)
+				histograms[client] = clientHist
+			}
+		}
+		for _, d := range p.completedSessions() {
+			histograms[AllClients].Add(d)
+			if clientHist != nil {
+				clientHist.Add(d)
+			}
+		}
+		return true
+	})
+	return histograms
+}
+
+// ExportSessionHistogramCsv writes the histograms (per client) into w, one row per client and bucket,
+// including the p50, p90 and p99 of each client.
+func (s *PeerStore) ExportSessionHistogramCsv(w io.Writer, buckets []time.Duration) error {
+	histograms := s.SessionHistograms(buckets, true)
+	clients := make([]string, 0, len(histograms))
+	for client := range histograms {
+		if client != AllClients {
+			clients = append(clients, client)
+		}
+	}
+	sort.Strings(clients)
+	clients = append([]string{AllClients}, clients...)
+
+	csvW := csv.NewWriter(w)
+	err := csvW.Write([]string{"client", "bucket", "count", "p50_secs", "p90_secs", "p99_secs"})
+	if err != nil {
+		return errors.Wrap(err, "unable to write csv header")
+	}
+	for _, client := range clients {
+		h := histograms[client]
+		percentiles := []string{
+			formatSecs(h.Percentile(50)),
+			formatSecs(h.Percentile(90)),
+			formatSecs(h.Percentile(99)),
+		}
+		for i, label := range h.BucketLabels() {
+			err = csvW.Write(append([]string{client, label, fmt.Sprintf("%d", h.Counts[i])}, percentiles...))
+			if err != nil {
+				return errors.Wrap(err, "unable to write csv row")
+			}
+		}
+	}
+	csvW.Flush()
+	return csvW.Error()
+}
+
+// ExportSessionHistogramFile exports the sessions histogram into the CSV file at the given path (overwriting it).
+func (s *PeerStore) ExportSessionHistogramFile(path string, buckets []time.Duration) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return errors.Wrap(err, "unable to create sessions histogram file")
+	}
+	defer f.Close()
+	return s.ExportSessionHistogramCsv(f, buckets)
+}
+
+func formatSecs(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
@@ -0,0 +1,96 @@
+package metrics
+
+import (
+	"bytes"
+	"encoding/csv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_SessionHistogramBuckets(t *testing.T) {
+	h := NewSessionHistogram(DefaultSessionBuckets)
+	durations := []time.Duration{
+		0,
+		5 * time.Second,
+		10 * time.Second, // the bound belongs to the next bucket
+		30 * time.Second,
+		5 * time.Minute,
+		59 * time.Minute,
+		2 * time.Hour,
+		6 * time.Hour,
+		48 * time.Hour,
+		9 * time.Second,
+	}
+	for _, d := range durations {
+		h.Add(d)
+	}
+	require.Equal(t, []string{"<10s", "<1m", "<10m", "<1h", "<6h", ">=6h"}, h.BucketLabels())
+	require.Equal(t, []int64{3, 2, 1, 1, 1, 2}, h.Counts)
+	require.Equal(t, int64(10), h.Total)
+
+	// nearest-rank percentiles over the sorted durations
+	require.Equal(t, 30*time.Second, h.Percentile(50))
+	require.Equal(t, 6*time.Hour, h.Percentile(90))
+	require.Equal(t, 48*time.Hour, h.Percentile(99))
+	require.Equal(t, time.Duration(0), h.Percentile(0))
+	require.Equal(t, 48*time.Hour, h.Percentile(100))
+
+	require.Equal(t, time.Duration(0), NewSessionHistogram(DefaultSessionBuckets).Percentile(50))
+}
+
+func Test_PeerStoreSessionHistograms(t *testing.T) {
+	store := NewPeerStore()
+	t0 := time.Unix(1000, 0)
+
+	addSessions := func(name, client string, durations ...time.Duration) {
+		p := store.GetOrCreatePeer(testPeerID(name))
+		p.ClientName = client
+		ti := t0
+		for _, d := range durations {
+			p.ConnectionEvent(ti)
+			p.DisconnectionEvent(ti.Add(d))
+			ti = ti.Add(d + time.Minute)
+		}
+	}
+	addSessions("session-peer0", "prysm", 5*time.Second, 2*time.Minute)
+	addSessions("session-peer1", "prysm", 2*time.Hour)
+	addSessions("session-peer2", "", 20*time.Second)
+	// ongoing sessions are not completed
+	store.GetOrCreatePeer(testPeerID("session-peer3")).ConnectionEvent(t0)
+
+	all := store.SessionHistogram(DefaultSessionBuckets)
+	require.Equal(t, int64(4), all.Total)
+	require.Equal(t, []int64{1, 1, 1, 0, 1, 0}, all.Counts)
+
+	byClient := store.SessionHistograms(DefaultSessionBuckets, true)
+	require.Equal(t, 3, len(byClient))
+	require.Equal(t, int64(3), byClient["prysm"].Total)
+	require.Equal(t, int64(1), byClient["unknown"].Total)
+	require.Equal(t, 2*time.Minute, byClient["prysm"].Percentile(50))
+
+	var buf bytes.Buffer
+	require.NoError(t, store.ExportSessionHistogramCsv(&buf, DefaultSessionBuckets))
+	records, err := csv.NewReader(&buf).ReadAll()
+	require.NoError(t, err)
+	// header + 6 buckets for all, prysm and unknown
+	require.Equal(t, 1+3*6, len(records))
+	require.Equal(t, []string{"all", "<10m", "1", "20.000", "7200.000", "7200.000"}, records[3])
+}
+
+func Test_SessionHistogramManySessions(t *testing.T) {
+	store := NewPeerStore()
+	t0 := time.Unix(1000, 0)
+	p := store.GetOrCreatePeer(testPeerID("long-lived"))
+	for i := 0; i < 20000; i++ {
+		ti := t0.Add(time.Duration(i) * time.Hour)
+		p.ConnectionEvent(ti)
+		p.DisconnectionEvent(ti.Add(time.Duration(i%60) * time.Second))
+	}
+	start := time.Now()
+	h := store.SessionHistogram(DefaultSessionBuckets)
+	require.Equal(t, int64(20000), h.Total)
+	require.Equal(t, 59*time.Second, h.Percentile(100))
+	require.Less(t, int64(time.Since(start)), int64(5*time.Second))
+}
@@ -0,0 +1,141 @@
+package metrics
+
+import (
+	"sort"
+
+	"github.com/migalabs/armiarma/pkg/utils"
+)
+
+// DefaultLatencyBuckets are the upper bounds (in secs) of the latency histograms of the snapshots,
+// from 10ms up to 5s.
+var DefaultLatencyBuckets = []float64{0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5}
+
+// ClientVersion identifies the peers of a client release.
+type ClientVersion struct {
+	Client  string
+	Version string
+}
+
+// LatencyHistogram is the distribution of the latencies (in secs) of a group of peers,
+// with cumulative bucket counts as Prometheus expects them.
+type LatencyHistogram struct {
+	Count   uint64
+	Sum     float64
+	Buckets map[float64]uint64
+}
+
+func newLatencyHistogram(buckets []float64) *LatencyHistogram {
+	h := &LatencyHistogram{
+		Buckets: make(map[float64]uint64, len(buckets)),
+	}
+	for _, bound := range buckets {
+		h.Buckets[bound] = 0
+	}
+	return h
+}
+
+// observe adds a latency (in secs) to the histogram.
+func (h *LatencyHistogram) observe(secs float64) {
+	h.Count++
+	h.Sum += secs
+	for bound := range h.Buckets {
+		if secs <= bound {
+			h.Buckets[bound]++
+		}
+	}
+}
+
+// PeerStoreSnapshot is the state of the peer store aggregated in a single pass, so that it can be
+// served (i.e. to Prometheus) without walking the peers on every read.
+type PeerStoreSnapshot struct {
+	Peers     int
+	Connected int
+	// connection attempts made to the peers, and the failed ones
+	Attempts       int64
+	FailedAttempts int64
+	// peers whose last connection attempt failed, per filtered error
+	FailedPeers map[string]int
+	// identified peers per client and version (as in ClientDistribution)
+	Clients map[ClientVersion]int
+	// located peers per country (as in CountryDistribution)
+	Countries map[string]int
+	// messages received per topic (as in MessageTotals)
+	Messages map[string]int64
+	// latency of the peers per client name (the unidentified ones as unknown)
+	Latencies map[string]*LatencyHistogram
+}
+
+// Snapshot aggregates the current state of the store, with the latencies of the peers
+// distributed in the given buckets (in secs).
+func (s *PeerStore) Snapshot(latencyBuckets []float64) PeerStoreSnapshot {
+	s.m.RLock()
+	includeOthers := s.includeOtherLibp2p
+	s.m.RUnlock()
+
+	snapshot := PeerStoreSnapshot{
+		FailedPeers: make(map[string]int),
+		Clients:     make(map[ClientVersion]int),
+		Countries:   make(map[string]int),
+		Messages:    make(map[string]int64),
+		Latencies:   make(map[string]*LatencyHistogram),
+	}
+	countryCounts := make(map[string]int)
+	names := make(countryNames)
+	s.ForEachPeer(func(p *Peer) bool {
+		p.m.RLock()
+		defer p.m.RUnlock()
+
+		snapshot.Peers++
+		if p.IsConnected {
+			snapshot.Connected++
+		}
+		snapshot.Attempts += int64(p.Attempts)
+		if failed := p.Attempts - p.SuccessfulAttempts; failed > 0 {
+			snapshot.FailedAttempts += int64(failed)
+		}
+		if p.Attempted && p.FailureStreak > 0 {
+			snapshot.FailedPeers[p.LastError]++
+		}
+		if p.ClientName != "" && (includeOthers || p.PeerCategory != string(utils.OtherLibp2pCategory)) {
+			version := p.ClientVersion
+			if version == "" {
+				version = utils.Unknown
+			}
+			snapshot.Clients[ClientVersion{Client: p.ClientName, Version: version}]++
+		}
+		if key := p.countryKey(); key != "" {
+			countryCounts[key]++
+			names.add(key, p.Country)
+		}
+		for topic, msgMetric := range p.MessageMetrics {
+			snapshot.Messages[topic] += msgMetric.Count
+		}
+		if p.Latency > 0 {
+			client := p.ClientName
+			if client == "" {
+				client = utils.Unknown
+			}
+			h, ok := snapshot.Latencies[client]
+			if !ok {
+				h = newLatencyHistogram(latencyBuckets)
+				snapshot.Latencies[client] = h
+			}
+			h.observe(p.Latency.Seconds())
+		}
+		return true
+	})
+	for key, count := range countryCounts {
+		snapshot.Countries[names.name(key)] += count
+	}
+	return snapshot
+}
+
+// LatencyClients returns the clients of the latency histograms, sorted.
+func (s PeerStoreSnapshot) LatencyClients() []string {
+	clients := make([]string, 0, len(s.Latencies))
+	for client := range s.Latencies {
+		clients = append(clients, client)
+	}
+	sort.Strings(clients)
+	return clients
+}
@@ -0,0 +1,64 @@
+package metrics
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/migalabs/armiarma/pkg/utils"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_PeerStoreSnapshot(t *testing.T) {
+	store := NewPeerStore()
+	seeds := []struct {
+		client, version, countryCode string
+		latency                      time.Duration
+		connected, failed            bool
+	}{
+		{"prysm", "v2.1.0", "US", 20 * time.Millisecond, true, false},
+		{"prysm", "v2.1.0", "US", 200 * time.Millisecond, false, true},
+		{"lighthouse", "", "DE", 0, true, false},
+		{"", "", "", 3 * time.Second, false, true},
+	}
+	for i, seed := range seeds {
+		p := store.GetOrCreatePeer(testPeerID(fmt.Sprintf("snapshot-peer%d", i)))
+		p.ClientName = seed.client
+		p.ClientVersion = seed.version
+		p.CountryCode = seed.countryCode
+		p.Country = seed.countryCode
+		p.Latency = seed.latency
+		p.ConnectionAttemptEvent(!seed.failed, "")
+		if seed.failed {
+			p.ConnectionAttemptEvent(false, "connection refused")
+		}
+		if seed.connected {
+			p.ConnectionEvent(time.Now())
+		}
+		p.MessageEvent("/eth2/4a26c58b/beacon_block/ssz_snappy", time.Now())
+	}
+
+	s := store.Snapshot(DefaultLatencyBuckets)
+	require.Equal(t, 4, s.Peers)
+	require.Equal(t, 2, s.Connected)
+	require.Equal(t, int64(6), s.Attempts)
+	require.Equal(t, int64(4), s.FailedAttempts)
+	require.Equal(t, map[string]int{"connection refused": 2}, s.FailedPeers)
+	require.Equal(t, map[ClientVersion]int{
+		{Client: "prysm", Version: "v2.1.0"}:           2,
+		{Client: "lighthouse", Version: utils.Unknown}: 1,
+	}, s.Clients)
+	require.Equal(t, map[string]int{"US": 2, "DE": 1}, s.Countries)
+	require.Equal(t, map[string]int64{"/eth2/4a26c58b/beacon_block/ssz_snappy": 4}, s.Messages)
+
+	// the peers without latency are left out, the unidentified ones count as unknown
+	require.Equal(t, []string{"prysm", utils.Unknown}, s.LatencyClients())
+	prysm := s.Latencies["prysm"]
+	require.Equal(t, uint64(2), prysm.Count)
+	require.InDelta(t, 0.22, prysm.Sum, 1e-9)
+	require.Equal(t, uint64(0), prysm.Buckets[0.01])
+	require.Equal(t, uint64(1), prysm.Buckets[0.025])
+	require.Equal(t, uint64(2), prysm.Buckets[0.25])
+	require.Equal(t, uint64(0), s.Latencies[utils.Unknown].Buckets[2.5])
+	require.Equal(t, uint64(1), s.Latencies[utils.Unknown].Buckets[5])
+}
@@ -0,0 +1,90 @@
+package metrics
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// SubnetCoverageEndpoint is the HTTP path where the connected peers per attestation subnet are served.
+const SubnetCoverageEndpoint = "/stats/subnets"
+
+// SubnetCoverage is the number of connected peers that claim each attestation subnet.
+type SubnetCoverage [AttestationSubnetCount]int
+
+// attnets returns the attestation subnets that the peer claims, from its metadata or,
+// if it wasn't obtained, from its ENR (needs the lock).
+func (p *Peer) attnets() []byte {
+	if len(p.MetadataAttnets) > 0 {
+		return p.MetadataAttnets
+	}
+	return p.EnrAttnets
+}
+
+// GetSubnetCoverage returns the number of currently connected peers that claim each attestation subnet.
+func (s *PeerStore) GetSubnetCoverage() SubnetCoverage {
+	var coverage SubnetCoverage
+	s.ForEachPeer(func(p *Peer) bool {
+		p.m.RLock()
+		defer p.m.RUnlock()
+		if !p.IsConnected {
+			return true
+		}
+		// SSZ bitvector, the bit i of the vector is the bit i%8 of the byte i/8
+		attnets := p.attnets()
+		for subnet := 0; subnet < AttestationSubnetCount && subnet/8 < len(attnets); subnet++ {
+			if attnets[subnet/8]&(1<<uint(subnet%8)) != 0 {
+				coverage[subnet]++
+			}
+		}
+		return true
+	})
+	return coverage
+}
+
+// Min returns the coverage of the least covered subnet.
+func (c SubnetCoverage) Min() int {
+	min := c[0]
+	for _, peers := range c[1:] {
+		if peers < min {
+			min = peers
+		}
+	}
+	return min
+}
+
+// Median returns the median of the coverage of the subnets (rounded down).
+func (c SubnetCoverage) Median() int {
+	sorted := c[:]
+	sort.Ints(sorted)
+	return (sorted[len(sorted)/2-1] + sorted[len(sorted)/2]) / 2
+}
+
+// Below returns the subnets covered by fewer peers than the threshold.
+func (c SubnetCoverage) Below(threshold int) []int {
+	subnets := make([]int, 0)
+	for subnet, peers := range c {
+		if peers < threshold {
+			subnets = append(subnets, subnet)
+		}
+	}
+	return subnets
+}
+
+// SubnetCoverageHandler serves the number of connected peers per attestation subnet as a JSON array.
+func (s *PeerStore) SubnetCoverageHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		err := json.NewEncoder(w).Encode(s.GetSubnetCoverage())
+		if err != nil {
+			log.Debug(errors.Wrap(err, "unable to write subnet coverage"))
+		}
+	})
+}
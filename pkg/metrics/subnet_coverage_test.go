@@ -0,0 +1,112 @@
+package metrics
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/migalabs/armiarma/pkg/db/models"
+	"github.com/migalabs/armiarma/pkg/utils"
+	"github.com/stretchr/testify/require"
+)
+
+// testAttnetsAttr is a metadata or an ENR that advertises the given attnets
+type testAttnetsAttr []byte
+
+func (a testAttnetsAttr) AttnetsBitvector() []byte {
+	return a
+}
+
+// testAttnets returns the 8-byte attnets bitvector with the given subnets
+func testAttnets(subnets ...int) testAttnetsAttr {
+	attnets := make(testAttnetsAttr, AttestationSubnetCount/8)
+	for _, subnet := range subnets {
+		attnets[subnet/8] |= 1 << uint(subnet%8)
+	}
+	return attnets
+}
+
+func Test_GetSubnetCoverage(t *testing.T) {
+	store := NewPeerStore()
+	t0 := time.Unix(1000, 0)
+	addPeer := func(name string, connected bool, metadata, enr testAttnetsAttr) {
+		pid := testPeerID(name)
+		hInfo := models.NewHostInfo(pid, utils.EthereumNetwork)
+		if metadata != nil {
+			hInfo.AddAtt(models.MetadataAttribute, metadata)
+		}
+		if enr != nil {
+			hInfo.AddAtt("enr-info", enr)
+		}
+		p := store.GetOrCreatePeer(pid)
+		p.FetchHostInfo(hInfo)
+		if connected {
+			p.ConnectionEvent(t0)
+		}
+	}
+	// the metadata attnets are preferred over the ENR ones
+	addPeer("coverage-metadata", true, testAttnets(0, 1, 63), testAttnets(5))
+	// the ENR attnets are used without metadata
+	addPeer("coverage-enr", true, nil, testAttnets(1, 5))
+	addPeer("coverage-overlap", true, testAttnets(1, 40, 63), nil)
+	// the disconnected peers don't count
+	addPeer("coverage-disconnected", false, testAttnets(0, 1, 2), nil)
+	// neither the peers without attnets
+	addPeer("coverage-unknown", true, nil, nil)
+
+	coverage := store.GetSubnetCoverage()
+	var expected SubnetCoverage
+	expected[0] = 1
+	expected[1] = 3
+	expected[5] = 1
+	expected[40] = 1
+	expected[63] = 2
+	require.Equal(t, expected, coverage)
+	require.Equal(t, 0, coverage.Min())
+	require.Equal(t, 0, coverage.Median())
+	below := coverage.Below(2)
+	require.Equal(t, AttestationSubnetCount-2, len(below))
+	require.NotContains(t, below, 1)
+	require.NotContains(t, below, 63)
+
+	// the peers that disconnect leave the coverage
+	p, _ := store.GetPeer(testPeerID("coverage-overlap"))
+	p.DisconnectionEvent(t0.Add(time.Minute))
+	coverage = store.GetSubnetCoverage()
+	require.Equal(t, 2, coverage[1])
+	require.Equal(t, 0, coverage[40])
+	require.Equal(t, 1, coverage[63])
+}
+
+func Test_SubnetCoverageStats(t *testing.T) {
+	var coverage SubnetCoverage
+	for subnet := range coverage {
+		coverage[subnet] = subnet % 8
+	}
+	require.Equal(t, 0, coverage.Min())
+	require.Equal(t, 3, coverage.Median())
+	require.Equal(t, 8, len(coverage.Below(1)))
+	// the stats don't sort the coverage in place
+	require.Equal(t, 7, coverage[7])
+}
+
+func Test_SubnetCoverageHandler(t *testing.T) {
+	store := NewPeerStore()
+	pid := testPeerID("coverage-served")
+	hInfo := models.NewHostInfo(pid, utils.EthereumNetwork)
+	hInfo.AddAtt(models.MetadataAttribute, testAttnets(3))
+	p := store.GetOrCreatePeer(pid)
+	p.FetchHostInfo(hInfo)
+	p.ConnectionEvent(time.Unix(1000, 0))
+
+	rec := httptest.NewRecorder()
+	store.SubnetCoverageHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, SubnetCoverageEndpoint, nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	var coverage []int
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &coverage))
+	require.Equal(t, AttestationSubnetCount, len(coverage))
+	require.Equal(t, 1, coverage[3])
+	require.Equal(t, 0, coverage[4])
+}
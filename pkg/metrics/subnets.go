@@ -0,0 +1,242 @@
+package metrics
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// family name of the attestation subnet topics (beacon_attestation_{0..63})
+	AttestationTopicName = "beacon_attestation"
+	// number of attestation subnets
+	AttestationSubnetCount = 64
+	// TopicMessagesFile is the default name of the per-topic messages export
+	TopicMessagesFile = "topic_messages.csv"
+)
+
+// matches the indexed subnet topics (beacon_attestation_12, sync_committee_3, ...)
+var subnetTopicRegex = regexp.MustCompile(`^(.+)_([0-9]+)$`)
+
+// topicFamily splits the short name of a topic into its family and its subnet index,
+// which is -1 if the topic is not an indexed subnet topic.
+// i.e. ("beacon_attestation", 17) for "/eth2/4a26c58b/beacon_attestation_17/ssz_snappy".
+func topicFamily(topic string) (string, int) {
+	short := shortTopicName(topic)
+	match := subnetTopicRegex.FindStringSubmatch(short)
+	if match == nil {
+		return short, -1
+	}
+	subnet, err := strconv.Atoi(match[2])
+	if err != nil {
+		return short, -1
+	}
+	return match[1], subnet
+}
+
+// AttestationSubnet returns the attestation subnet of the topic, and false if it isn't an attestation subnet topic.
+func AttestationSubnet(topic string) (int, bool) {
+	family, subnet := topicFamily(topic)
+	if family != AttestationTopicName || subnet < 0 || subnet >= AttestationSubnetCount {
+		return -1, false
+	}
+	return subnet, true
+}
+
+// GetNumOfMsgFromTopic returns the number of messages received from the peer on the given topic,
+// which can be a short topic name (i.e. "beacon_attestation_17") or the family of the subnet topics
+// (i.e. "beacon_attestation", aggregating all the attestation subnets).
+func (p *Peer) GetNumOfMsgFromTopic(topicName string) int64 {
+	p.m.RLock()
+	defer p.m.RUnlock()
+
+	var count int64
+	for topic, msgMetric := range p.MessageMetrics {
+		family, _ := topicFamily(topic)
+		if family == topicName || shortTopicName(topic) == topicName {
+			count += msgMetric.Count
+		}
+	}
+	return count
+}
+
+// GetDistinctTopicCount returns the number of distinct topics on which the peer delivered messages.
+// With collapseSubnets, the indexed subnet topics count once per family (i.e. all the
+// beacon_attestation_{0..63} count as beacon_attestation).
+func (p *Peer) GetDistinctTopicCount(collapseSubnets bool) int {
+	p.m.RLock()
+	defer p.m.RUnlock()
+	return p.distinctTopicCount(collapseSubnets)
+}
+
+// distinctTopicCount is GetDistinctTopicCount without locking (the caller must hold the lock).
+func (p *Peer) distinctTopicCount(collapseSubnets bool) int {
+	topics := make(map[string]struct{}, len(p.MessageMetrics))
+	for topic, msgMetric := range p.MessageMetrics {
+		if msgMetric.Count == 0 {
+			continue
+		}
+		if collapseSubnets {
+			topic, _ = topicFamily(topic)
+		}
+		topics[topic] = struct{}{}
+	}
+	return len(topics)
+}
+
+// GetAttestationSubnetMessages returns the number of messages received from the peer on each attestation subnet.
+func (p *Peer) GetAttestationSubnetMessages() map[int]int64 {
+	p.m.RLock()
+	defer p.m.RUnlock()
+
+	subnets := make(map[int]int64)
+	for topic, msgMetric := range p.MessageMetrics {
+		if subnet, ok := AttestationSubnet(topic); ok {
+			subnets[subnet] += msgMetric.Count
+		}
+	}
+	return subnets
+}
+
+// GetMsgCountPerSubnet returns the number of messages received from the peer on each attestation subnet.
+// The fork digest of the topics is ignored, so the messages from before and after a fork add up into the same subnet.
+func (p *Peer) GetMsgCountPerSubnet() [AttestationSubnetCount]uint64 {
+	p.m.RLock()
+	defer p.m.RUnlock()
+	return p.msgCountPerSubnet()
+}
+
+// msgCountPerSubnet is GetMsgCountPerSubnet without locking (the caller must hold the lock).
+func (p *Peer) msgCountPerSubnet() [AttestationSubnetCount]uint64 {
+	var counts [AttestationSubnetCount]uint64
+	for topic, msgMetric := range p.MessageMetrics {
+		if subnet, ok := AttestationSubnet(topic); ok && msgMetric.Count > 0 {
+			counts[subnet] += uint64(msgMetric.Count)
+		}
+	}
+	return counts
+}
+
+// GetAttestationMsgCount returns the number of messages received from the peer over all the attestation subnets.
+func (p *Peer) GetAttestationMsgCount() uint64 {
+	total, _ := attestationTotals(p.GetMsgCountPerSubnet())
+	return total
+}
+
+// GetAttestationSubnetCount returns the number of distinct attestation subnets on which the peer delivered messages.
+func (p *Peer) GetAttestationSubnetCount() int {
+	_, subnets := attestationTotals(p.GetMsgCountPerSubnet())
+	return subnets
+}
+
+// attestationTotals returns the sum of the per-subnet counts, and the number of subnets with messages.
+func attestationTotals(counts [AttestationSubnetCount]uint64) (total uint64, subnets int) {
+	for _, count := range counts {
+		total += count
+		if count > 0 {
+			subnets++
+		}
+	}
+	return total, subnets
+}
+
+// AttestationSubnetMessages returns the number of messages received from all the peers on each attestation subnet.
+func (s *PeerStore) AttestationSubnetMessages() map[int]int64 {
+	subnets := make(map[int]int64)
+	s.ForEachPeer(func(p *Peer) bool {
+		for subnet, count := range p.GetAttestationSubnetMessages() {
+			subnets[subnet] += count
+		}
+		return true
+	})
+	return subnets
+}
+
+// topicMessages are the message totals of a topic (or of a subnet of a topic family) over all the peers.
+type topicMessages struct {
+	topic           string
+	subnet          int
+	peers           int
+	messages        int64
+	firstDeliveries int64
+	duplicates      int64
+}
+
+// ExportTopicMessagesCsv writes into w the message totals of every topic, one row per topic
+// and one per subnet for the indexed subnet topics (which have an empty subnet otherwise).
+func (s *PeerStore) ExportTopicMessagesCsv(w io.Writer) error {
+	type topicKey struct {
+		topic  string
+		subnet int
+	}
+	totals := make(map[topicKey]*topicMessages)
+	s.ForEachPeer(func(p *Peer) bool {
+		p.m.RLock()
+		defer p.m.RUnlock()
+		for topic, msgMetric := range p.MessageMetrics {
+			family, subnet := topicFamily(topic)
+			key := topicKey{family, subnet}
+			total, ok := totals[key]
+			if !ok {
+				total = &topicMessages{topic: family, subnet: subnet}
+				totals[key] = total
+			}
+			total.peers++
+			total.messages += msgMetric.Count
+			total.firstDeliveries += msgMetric.FirstDeliveries
+			total.duplicates += msgMetric.Duplicates
+		}
+		return true
+	})
+	rows := make([]*topicMessages, 0, len(totals))
+	for _, total := range totals {
+		rows = append(rows, total)
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].topic == rows[j].topic {
+			return rows[i].subnet < rows[j].subnet
+		}
+		return rows[i].topic < rows[j].topic
+	})
+
+	csvW := csv.NewWriter(w)
+	err := csvW.Write([]string{"topic", "subnet", "peers", "messages", "first_deliveries", "duplicates"})
+	if err != nil {
+		return errors.Wrap(err, "unable to write csv header")
+	}
+	for _, row := range rows {
+		subnet := ""
+		if row.subnet >= 0 {
+			subnet = fmt.Sprintf("%d", row.subnet)
+		}
+		err = csvW.Write([]string{
+			row.topic,
+			subnet,
+			fmt.Sprintf("%d", row.peers),
+			fmt.Sprintf("%d", row.messages),
+			fmt.Sprintf("%d", row.firstDeliveries),
+			fmt.Sprintf("%d", row.duplicates),
+		})
+		if err != nil {
+			return errors.Wrap(err, "unable to write csv row")
+		}
+	}
+	csvW.Flush()
+	return csvW.Error()
+}
+
+// ExportTopicMessagesFile exports the per-topic message totals into the CSV file at the given path (overwriting it).
+func (s *PeerStore) ExportTopicMessagesFile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return errors.Wrap(err, "unable to create topic messages file")
+	}
+	defer f.Close()
+	return s.ExportTopicMessagesCsv(f)
+}
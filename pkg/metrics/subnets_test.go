@@ -0,0 +1,122 @@
+package metrics
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testAttSubnet17Topic = "/eth2/4a26c58b/beacon_attestation_17/ssz_snappy"
+
+func Test_AttestationSubnet(t *testing.T) {
+	subnet, ok := AttestationSubnet(testAttSubnet17Topic)
+	require.True(t, ok)
+	require.Equal(t, 17, subnet)
+
+	_, ok = AttestationSubnet(testBlockTopic)
+	require.False(t, ok)
+	_, ok = AttestationSubnet("/eth2/4a26c58b/beacon_attestation_64/ssz_snappy")
+	require.False(t, ok)
+}
+
+func Test_DistinctTopicCount(t *testing.T) {
+	t0 := time.Unix(1000, 0)
+	p := NewPeer(testPeerID("distinct-topics"))
+	require.Equal(t, 0, p.GetDistinctTopicCount(false))
+	require.Equal(t, 0, p.GetDistinctTopicCount(true))
+
+	p.MessageEvent(testAttSubnet17Topic, t0)
+	p.MessageEvent(testAttTopic, t0)
+	p.MessageEvent(testBlockTopic, t0)
+	p.MessageEvent(testBlockTopic, t0)
+	// the topics without messages don't count
+	p.DuplicateEvent("/eth2/4a26c58b/voluntary_exit/ssz_snappy")
+
+	// each subnet counts, or only their family
+	require.Equal(t, 3, p.GetDistinctTopicCount(false))
+	require.Equal(t, 2, p.GetDistinctTopicCount(true))
+
+	record := p.csvRecord(DefaultQualityWeights, t0)
+	require.Equal(t, "2", record[csvColumn(t, "distinct_topics")])
+}
+
+func Test_AttestationSubnetMessages(t *testing.T) {
+	store := NewPeerStore()
+	t0 := time.Unix(1000, 0)
+
+	p1 := store.GetOrCreatePeer(testPeerID("subnet-peer1"))
+	for i := 0; i < 3; i++ {
+		p1.MessageEvent(testAttSubnet17Topic, t0)
+	}
+	p1.MessageEvent(testAttTopic, t0)
+	p1.MessageEvent(testBlockTopic, t0)
+	p2 := store.GetOrCreatePeer(testPeerID("subnet-peer2"))
+	p2.MessageEvent(testAttSubnet17Topic, t0)
+
+	// per subnet and aggregated
+	require.Equal(t, int64(3), p1.GetNumOfMsgFromTopic("beacon_attestation_17"))
+	require.Equal(t, int64(4), p1.GetNumOfMsgFromTopic(AttestationTopicName))
+	require.Equal(t, int64(1), p1.GetNumOfMsgFromTopic(BeaconBlockTopicName))
+	require.Equal(t, map[int]int64{3: 1, 17: 3}, p1.GetAttestationSubnetMessages())
+	require.Equal(t, map[int]int64{3: 1, 17: 4}, store.AttestationSubnetMessages())
+
+	var buf bytes.Buffer
+	require.NoError(t, store.ExportTopicMessagesCsv(&buf))
+	records, err := csv.NewReader(&buf).ReadAll()
+	require.NoError(t, err)
+	require.Equal(t, [][]string{
+		{"topic", "subnet", "peers", "messages", "first_deliveries", "duplicates"},
+		{"beacon_attestation", "3", "1", "1", "0", "0"},
+		{"beacon_attestation", "17", "2", "4", "0", "0"},
+		{"beacon_block", "", "1", "1", "0", "0"},
+	}, records)
+}
+
+func Test_MsgCountPerSubnet(t *testing.T) {
+	t0 := time.Unix(1000, 0)
+	p := NewPeer(testPeerID("subnet-counts"))
+	require.Equal(t, uint64(0), p.GetAttestationMsgCount())
+	require.Equal(t, 0, p.GetAttestationSubnetCount())
+
+	// the same subnet before (altair) and after (bellatrix) the mainnet fork
+	p.MessageEvent(testAttSubnet17Topic, t0)
+	p.MessageEvent(testAttSubnet17Topic, t0)
+	p.MessageEvent("/eth2/4a26c58b/beacon_attestation_0/ssz_snappy", t0)
+	p.MessageEvent("/eth2/bba4da96/beacon_attestation_17/ssz_snappy", t0)
+	p.MessageEvent("/eth2/bba4da96/beacon_attestation_63/ssz_snappy", t0)
+	// a testnet fork digest
+	p.MessageEvent("/eth2/c2ce3aa8/beacon_attestation_63/ssz_snappy", t0)
+	// neither the other topics nor the out of range subnets count
+	p.MessageEvent(testBlockTopic, t0)
+	p.MessageEvent("/eth2/bba4da96/beacon_attestation_64/ssz_snappy", t0)
+	p.MessageEvent("/eth2/bba4da96/sync_committee_3/ssz_snappy", t0)
+	p.DuplicateEvent("/eth2/bba4da96/beacon_attestation_5/ssz_snappy")
+
+	counts := p.GetMsgCountPerSubnet()
+	require.Equal(t, uint64(1), counts[0])
+	require.Equal(t, uint64(3), counts[17])
+	require.Equal(t, uint64(2), counts[63])
+	require.Equal(t, uint64(0), counts[5])
+	require.Equal(t, uint64(6), p.GetAttestationMsgCount())
+	require.Equal(t, 3, p.GetAttestationSubnetCount())
+
+	record := p.csvRecord(DefaultQualityWeights, t0)
+	require.Equal(t, "6", record[csvColumn(t, "attestation_messages")])
+	require.Equal(t, "3", record[csvColumn(t, "attestation_subnets")])
+
+	data, err := p.ToJSON()
+	require.NoError(t, err)
+	var fields map[string]interface{}
+	require.NoError(t, json.Unmarshal(data, &fields))
+	require.Equal(t, float64(6), fields["attestation_messages"])
+	require.Equal(t, float64(3), fields["attestation_subnets"])
+
+	// the derived fields are ignored when restoring the peer
+	restored := NewPeer(p.ID)
+	require.NoError(t, json.Unmarshal(data, restored))
+	require.Equal(t, counts, restored.GetMsgCountPerSubnet())
+}
@@ -0,0 +1,224 @@
+package metrics
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/migalabs/armiarma/pkg/db/models"
+	"github.com/migalabs/armiarma/pkg/utils"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// DeltaPersister accepts the items to be persisted (i.e. the DB client).
+type DeltaPersister interface {
+	PersistToDB(item interface{}) error
+}
+
+// topicCounters are the counters of a topic of a peer at its last persistence.
+type topicCounters struct {
+	messages        int64
+	bytes           int64
+	firstDeliveries int64
+	duplicates      int64
+	persistedAt     time.Time
+	// metric the counters were read from, replaced when the topic is reset
+	metric *MessageMetric
+}
+
+// topicBreadth is the number of distinct topics (subnets collapsed) on which a peer delivered messages.
+type topicBreadth struct {
+	network utils.NetworkType
+	topics  int
+}
+
+// TopicDeltaPersister periodically persists the increments of the message metrics of every peer and topic
+// since the previous persistence, so that the gossip counts survive a crash of the crawler.
+// Along with them, it persists the number of distinct topics of the peers whose number changed.
+type TopicDeltaPersister struct {
+	ctx context.Context
+
+	store     *PeerStore
+	persister DeltaPersister
+	interval  time.Duration
+	nowFn     func() time.Time
+
+	m sync.Mutex
+	// last persisted counters of each peer and topic
+	snapshot map[peer.ID]map[string]topicCounters
+	// last persisted number of distinct topics of each peer
+	distinctTopics map[peer.ID]int
+
+	wg     sync.WaitGroup
+	closeC chan struct{}
+}
+
+// NewTopicDeltaPersister returns a TopicDeltaPersister that will persist the deltas of the store every interval.
+func NewTopicDeltaPersister(ctx context.Context, store *PeerStore, persister DeltaPersister, interval time.Duration) *TopicDeltaPersister {
+	return &TopicDeltaPersister{
+		ctx:       ctx,
+		store:     store,
+		persister: persister,
+		interval:  interval,
+		nowFn:     time.Now,
+		snapshot:  make(map[peer.ID]map[string]topicCounters),
+		closeC:    make(chan struct{}),
+
+		distinctTopics: make(map[peer.ID]int),
+	}
+}
+
+// Start spawns the routine that persists the deltas on every tick.
+func (d *TopicDeltaPersister) Start() {
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		ticker := time.NewTicker(d.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				d.persist()
+			case <-d.closeC:
+				return
+			case <-d.ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Close stops the routine and persists the last deltas.
+func (d *TopicDeltaPersister) Close() {
+	close(d.closeC)
+	d.wg.Wait()
+	d.persist()
+}
+
+// persist emits the non-zero deltas since the last persistence, returning how many were accepted.
+// The snapshot of a peer and topic only moves forward once its delta was accepted, so the rejected
+// ones are included again in the next delta.
+func (d *TopicDeltaPersister) persist() int {
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	now := d.nowFn()
+	current, breadths := d.collect()
+	accepted, failed := 0, 0
+	var lastErr error
+	for pid, topics := range current {
+		prevTopics := d.snapshot[pid]
+		for topic, counters := range topics {
+			prev := prevTopics[topic]
+			// the counters of a reset topic, or of a peer that was evicted and added again, start from zero
+			if counters.metric != prev.metric || counters.messages < prev.messages || counters.bytes < prev.bytes ||
+				counters.firstDeliveries < prev.firstDeliveries || counters.duplicates < prev.duplicates {
+				prev = topicCounters{}
+			}
+			delta := &models.TopicMetricsDelta{
+				PeerID:          pid,
+				Topic:           topic,
+				From:            prev.persistedAt,
+				To:              now,
+				Messages:        counters.messages - prev.messages,
+				Bytes:           counters.bytes - prev.bytes,
+				FirstDeliveries: counters.firstDeliveries - prev.firstDeliveries,
+				Duplicates:      counters.duplicates - prev.duplicates,
+			}
+			if delta.IsZero() {
+				continue
+			}
+			if err := d.persister.PersistToDB(delta); err != nil {
+				failed++
+				lastErr = err
+				continue
+			}
+			accepted++
+			if prevTopics == nil {
+				prevTopics = make(map[string]topicCounters)
+				d.snapshot[pid] = prevTopics
+			}
+			counters.persistedAt = now
+			prevTopics[topic] = counters
+		}
+	}
+	// forget the peers that left the store, and the topics that were reset without new messages
+	for pid, prevTopics := range d.snapshot {
+		topics, ok := current[pid]
+		if !ok {
+			delete(d.snapshot, pid)
+			continue
+		}
+		for topic := range prevTopics {
+			if _, ok := topics[topic]; !ok {
+				delete(prevTopics, topic)
+			}
+		}
+	}
+
+	if failed > 0 {
+		log.Error(errors.Wrapf(lastErr, "unable to persist %d topic metrics deltas", failed))
+	}
+	log.WithFields(log.Fields{
+		"accepted": accepted,
+		"failed":   failed,
+	}).Debug("persisted topic metrics deltas")
+
+	d.persistDistinctTopics(breadths)
+	return accepted
+}
+
+// persistDistinctTopics persists the number of distinct topics of the peers whose number changed
+// since its last persistence (the caller must hold the lock).
+func (d *TopicDeltaPersister) persistDistinctTopics(breadths map[peer.ID]topicBreadth) {
+	failed := 0
+	var lastErr error
+	for pid, breadth := range breadths {
+		if breadth.topics == 0 || breadth.topics == d.distinctTopics[pid] {
+			continue
+		}
+		hInfo := models.NewHostInfo(pid, breadth.network)
+		hInfo.AddAtt(models.DistinctTopicsAttribute, models.DistinctTopics(breadth.topics))
+		if err := d.persister.PersistToDB(hInfo); err != nil {
+			failed++
+			lastErr = err
+			continue
+		}
+		d.distinctTopics[pid] = breadth.topics
+	}
+	for pid := range d.distinctTopics {
+		if _, ok := breadths[pid]; !ok {
+			delete(d.distinctTopics, pid)
+		}
+	}
+	if failed > 0 {
+		log.Error(errors.Wrapf(lastErr, "unable to persist the distinct topics of %d peers", failed))
+	}
+}
+
+// collect returns the current counters of every peer and topic of the store,
+// and the number of distinct topics of every peer.
+func (d *TopicDeltaPersister) collect() (map[peer.ID]map[string]topicCounters, map[peer.ID]topicBreadth) {
+	current := make(map[peer.ID]map[string]topicCounters)
+	breadths := make(map[peer.ID]topicBreadth)
+	d.store.ForEachPeer(func(p *Peer) bool {
+		p.m.RLock()
+		defer p.m.RUnlock()
+		topics := make(map[string]topicCounters, len(p.MessageMetrics))
+		for topic, msgMetric := range p.MessageMetrics {
+			topics[topic] = topicCounters{
+				messages:        msgMetric.Count,
+				bytes:           msgMetric.Bytes,
+				firstDeliveries: msgMetric.FirstDeliveries,
+				duplicates:      msgMetric.Duplicates,
+				metric:          msgMetric,
+			}
+		}
+		current[p.ID] = topics
+		breadths[p.ID] = topicBreadth{network: p.Network, topics: p.distinctTopicCount(true)}
+		return true
+	})
+	return current, breadths
+}
@@ -0,0 +1,244 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/migalabs/armiarma/pkg/db/models"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeDeltaPersister records the accepted deltas and host infos, rejecting them while failing is set
+type fakeDeltaPersister struct {
+	m         sync.Mutex
+	failing   bool
+	deltas    []*models.TopicMetricsDelta
+	hostInfos []*models.HostInfo
+}
+
+func (f *fakeDeltaPersister) PersistToDB(item interface{}) error {
+	f.m.Lock()
+	defer f.m.Unlock()
+	if f.failing {
+		return errors.New("persister queue closed")
+	}
+	switch item := item.(type) {
+	case *models.TopicMetricsDelta:
+		f.deltas = append(f.deltas, item)
+	case *models.HostInfo:
+		f.hostInfos = append(f.hostInfos, item)
+	}
+	return nil
+}
+
+// takeDistinctTopics returns the persisted number of distinct topics by peer name, resetting them
+func (f *fakeDeltaPersister) takeDistinctTopics(names ...string) map[string]models.DistinctTopics {
+	f.m.Lock()
+	defer f.m.Unlock()
+	byPeer := make(map[string]models.DistinctTopics)
+	for _, hInfo := range f.hostInfos {
+		for _, name := range names {
+			if testPeerID(name) == hInfo.ID {
+				byPeer[name] = hInfo.Attr[models.DistinctTopicsAttribute].(models.DistinctTopics)
+			}
+		}
+	}
+	f.hostInfos = nil
+	return byPeer
+}
+
+// take returns the accepted deltas by peer name and topic, resetting them
+func (f *fakeDeltaPersister) take(t *testing.T, names ...string) map[string]map[string]models.TopicMetricsDelta {
+	f.m.Lock()
+	defer f.m.Unlock()
+	byPeer := make(map[string]map[string]models.TopicMetricsDelta)
+	for _, delta := range f.deltas {
+		for _, name := range names {
+			if testPeerID(name) != delta.PeerID {
+				continue
+			}
+			if byPeer[name] == nil {
+				byPeer[name] = make(map[string]models.TopicMetricsDelta)
+			}
+			_, dup := byPeer[name][delta.Topic]
+			require.False(t, dup, "more than one delta of %s %s", name, delta.Topic)
+			byPeer[name][delta.Topic] = *delta
+		}
+	}
+	f.deltas = nil
+	return byPeer
+}
+
+func Test_TopicDeltaPersister(t *testing.T) {
+	t0 := time.Unix(1606824023, 0)
+	store := NewPeerStore()
+	fake := &fakeDeltaPersister{}
+	deltas := NewTopicDeltaPersister(context.Background(), store, fake, time.Minute)
+	now := t0
+	deltas.nowFn = func() time.Time { return now }
+
+	alice := store.GetOrCreatePeer(testPeerID("delta-alice"))
+	bob := store.GetOrCreatePeer(testPeerID("delta-bob"))
+	// a peer without messages never has deltas
+	store.GetOrCreatePeer(testPeerID("delta-quiet"))
+
+	alice.MessageEvent(testBlockTopic, t0)
+	alice.MessageBytesEvent(testBlockTopic, 100)
+	alice.FirstDeliveryEvent(testBlockTopic)
+	alice.MessageEvent(testAttTopic, t0)
+	bob.MessageEvent(testBlockTopic, t0)
+	bob.DuplicateEvent(testBlockTopic)
+
+	// first tick: the whole counters
+	now = t0.Add(time.Minute)
+	require.Equal(t, 3, deltas.persist())
+	got := fake.take(t, "delta-alice", "delta-bob", "delta-quiet")
+	require.Equal(t, 2, len(got))
+	require.Equal(t, models.TopicMetricsDelta{
+		PeerID: testPeerID("delta-alice"), Topic: testBlockTopic, To: now,
+		Messages: 1, Bytes: 100, FirstDeliveries: 1,
+	}, got["delta-alice"][testBlockTopic])
+	require.Equal(t, int64(1), got["delta-alice"][testAttTopic].Messages)
+	require.Equal(t, int64(1), got["delta-bob"][testBlockTopic].Duplicates)
+
+	// messages interleaved between the ticks, only some peers and topics move
+	alice.MessageEvent(testBlockTopic, t0.Add(70*time.Second))
+	bob.MessageEvent(testAttTopic, t0.Add(80*time.Second))
+	alice.MessageEvent(testBlockTopic, t0.Add(90*time.Second))
+	alice.MessageBytesEvent(testBlockTopic, 250)
+
+	// second tick: only the increments, and no zero deltas
+	now = t0.Add(2 * time.Minute)
+	require.Equal(t, 2, deltas.persist())
+	got = fake.take(t, "delta-alice", "delta-bob")
+	require.Equal(t, models.TopicMetricsDelta{
+		PeerID: testPeerID("delta-alice"), Topic: testBlockTopic, From: t0.Add(time.Minute), To: now,
+		Messages: 2, Bytes: 250,
+	}, got["delta-alice"][testBlockTopic])
+	require.NotContains(t, got["delta-alice"], testAttTopic)
+	require.NotContains(t, got["delta-bob"], testBlockTopic)
+	require.Equal(t, int64(1), got["delta-bob"][testAttTopic].Messages)
+	require.True(t, got["delta-bob"][testAttTopic].From.IsZero())
+
+	// nothing moved
+	now = t0.Add(3 * time.Minute)
+	require.Equal(t, 0, deltas.persist())
+	require.Empty(t, fake.take(t, "delta-alice", "delta-bob"))
+}
+
+func Test_TopicDeltaPersisterRejected(t *testing.T) {
+	t0 := time.Unix(1606824023, 0)
+	store := NewPeerStore()
+	fake := &fakeDeltaPersister{failing: true}
+	deltas := NewTopicDeltaPersister(context.Background(), store, fake, time.Minute)
+	now := t0
+	deltas.nowFn = func() time.Time { return now }
+
+	p := store.GetOrCreatePeer(testPeerID("delta-rejected"))
+	p.MessageEvent(testBlockTopic, t0)
+	now = t0.Add(time.Minute)
+	require.Equal(t, 0, deltas.persist())
+
+	// the rejected delta is included in the next one
+	p.MessageEvent(testBlockTopic, t0.Add(90*time.Second))
+	fake.failing = false
+	now = t0.Add(2 * time.Minute)
+	require.Equal(t, 1, deltas.persist())
+	got := fake.take(t, "delta-rejected")
+	require.Equal(t, int64(2), got["delta-rejected"][testBlockTopic].Messages)
+	require.True(t, got["delta-rejected"][testBlockTopic].From.IsZero())
+
+	// an evicted peer that shows up again starts from zero
+	store.removePeer(p.ID, nil)
+	now = t0.Add(3 * time.Minute)
+	require.Equal(t, 0, deltas.persist())
+	p = store.GetOrCreatePeer(testPeerID("delta-rejected"))
+	p.MessageEvent(testBlockTopic, t0.Add(200*time.Second))
+	now = t0.Add(4 * time.Minute)
+	require.Equal(t, 1, deltas.persist())
+	require.Equal(t, int64(1), fake.take(t, "delta-rejected")["delta-rejected"][testBlockTopic].Messages)
+}
+
+func Test_TopicDeltaPersisterClose(t *testing.T) {
+	store := NewPeerStore()
+	fake := &fakeDeltaPersister{}
+	deltas := NewTopicDeltaPersister(context.Background(), store, fake, time.Hour)
+	deltas.Start()
+	store.GetOrCreatePeer(testPeerID("delta-close")).MessageEvent(testBlockTopic, time.Now())
+
+	// the last deltas are persisted on close
+	deltas.Close()
+	require.Equal(t, int64(1), fake.take(t, "delta-close")["delta-close"][testBlockTopic].Messages)
+}
+
+func Test_TopicDeltaPersisterReset(t *testing.T) {
+	t0 := time.Unix(1606824023, 0)
+	store := NewPeerStore()
+	fake := &fakeDeltaPersister{}
+	deltas := NewTopicDeltaPersister(context.Background(), store, fake, time.Minute)
+	now := t0
+	deltas.nowFn = func() time.Time { return now }
+
+	alice := store.GetOrCreatePeer(testPeerID("reset-alice"))
+	for i := 0; i < 3; i++ {
+		alice.MessageEvent(testBlockTopic, t0)
+	}
+	now = t0.Add(time.Minute)
+	require.Equal(t, 1, deltas.persist())
+	fake.take(t, "reset-alice")
+
+	// more messages after the reset than before it: the delta counts them all
+	alice.ResetTopicMetrics(testBlockTopic)
+	for i := 0; i < 5; i++ {
+		alice.MessageEvent(testBlockTopic, t0.Add(70*time.Second))
+	}
+	now = t0.Add(2 * time.Minute)
+	require.Equal(t, 1, deltas.persist())
+	got := fake.take(t, "reset-alice")
+	require.Equal(t, int64(5), got["reset-alice"][testBlockTopic].Messages)
+	require.True(t, got["reset-alice"][testBlockTopic].From.IsZero())
+
+	// reset without new messages: nothing to persist, and the next messages start from zero
+	alice.ResetTopicMetrics(testBlockTopic)
+	now = t0.Add(3 * time.Minute)
+	require.Equal(t, 0, deltas.persist())
+	alice.MessageEvent(testBlockTopic, t0.Add(190*time.Second))
+	now = t0.Add(4 * time.Minute)
+	require.Equal(t, 1, deltas.persist())
+	got = fake.take(t, "reset-alice")
+	require.Equal(t, int64(1), got["reset-alice"][testBlockTopic].Messages)
+}
+
+func Test_TopicDeltaPersisterDistinctTopics(t *testing.T) {
+	t0 := time.Unix(1606824023, 0)
+	store := NewPeerStore()
+	fake := &fakeDeltaPersister{}
+	deltas := NewTopicDeltaPersister(context.Background(), store, fake, time.Minute)
+
+	alice := store.GetOrCreatePeer(testPeerID("breadth-alice"))
+	bob := store.GetOrCreatePeer(testPeerID("breadth-bob"))
+	store.GetOrCreatePeer(testPeerID("breadth-quiet"))
+
+	alice.MessageEvent(testBlockTopic, t0)
+	alice.MessageEvent("/eth2/4a26c58b/beacon_attestation_1/ssz_snappy", t0)
+	alice.MessageEvent("/eth2/4a26c58b/beacon_attestation_2/ssz_snappy", t0)
+	bob.MessageEvent(testBlockTopic, t0)
+
+	// the subnets are collapsed, and the peers without messages are skipped
+	deltas.persist()
+	require.Equal(t, map[string]models.DistinctTopics{
+		"breadth-alice": 2,
+		"breadth-bob":   1,
+	}, fake.takeDistinctTopics("breadth-alice", "breadth-bob", "breadth-quiet"))
+
+	// only the changes are persisted again
+	bob.MessageEvent("/eth2/4a26c58b/voluntary_exit/ssz_snappy", t0)
+	alice.MessageEvent("/eth2/4a26c58b/beacon_attestation_3/ssz_snappy", t0)
+	deltas.persist()
+	require.Equal(t, map[string]models.DistinctTopics{
+		"breadth-bob": 2,
+	}, fake.takeDistinctTopics("breadth-alice", "breadth-bob"))
+}
@@ -0,0 +1,94 @@
+package metrics
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// TopicTotalsEndpoint is the HTTP path where the per-topic message totals are served.
+const TopicTotalsEndpoint = "/topics"
+
+// TopicTotal are the messages received on a topic from all the peers.
+type TopicTotal struct {
+	Messages         int64     `json:"messages"`
+	Bytes            int64     `json:"bytes"`
+	Peers            int       `json:"peers"`
+	FirstMessageTime time.Time `json:"first_message_time"`
+	LastMessageTime  time.Time `json:"last_message_time"`
+}
+
+// GetTopicTotals returns the network-wide message totals per short topic name, summing the
+// MessageMetrics of all the peers. The indexed subnet topics are collapsed into their family
+// (i.e. "beacon_attestation") unless keepSubnets is set. A peer that sent messages on several
+// subnets of a family only counts once in the peers of the family.
+func (s *PeerStore) GetTopicTotals(keepSubnets bool) map[string]TopicTotal {
+	totals := make(map[string]*TopicTotal)
+	s.ForEachPeer(func(p *Peer) bool {
+		p.m.RLock()
+		defer p.m.RUnlock()
+		peerTopics := make(map[string]struct{})
+		for topic, msgMetric := range p.MessageMetrics {
+			// metrics with only deliveries have no messages
+			if msgMetric.Count == 0 {
+				continue
+			}
+			key := shortTopicName(topic)
+			if !keepSubnets {
+				key, _ = topicFamily(topic)
+			}
+			total, ok := totals[key]
+			if !ok {
+				total = &TopicTotal{}
+				totals[key] = total
+			}
+			total.Messages += msgMetric.Count
+			total.Bytes += msgMetric.Bytes
+			if total.FirstMessageTime.IsZero() || msgMetric.FirstMessageTime.Before(total.FirstMessageTime) {
+				total.FirstMessageTime = msgMetric.FirstMessageTime
+			}
+			if msgMetric.LastMessageTime.After(total.LastMessageTime) {
+				total.LastMessageTime = msgMetric.LastMessageTime
+			}
+			if _, ok := peerTopics[key]; !ok {
+				peerTopics[key] = struct{}{}
+				total.Peers++
+			}
+		}
+		return true
+	})
+	topicTotals := make(map[string]TopicTotal, len(totals))
+	for key, total := range totals {
+		topicTotals[key] = *total
+	}
+	return topicTotals
+}
+
+// TopicTotalsHandler serves the per-topic message totals of the store as JSON.
+// The subnet topics are collapsed into their families unless the "subnets" query parameter is true.
+func (s *PeerStore) TopicTotalsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		keepSubnets := false
+		if param := r.URL.Query().Get("subnets"); param != "" {
+			var err error
+			keepSubnets, err = strconv.ParseBool(param)
+			if err != nil {
+				http.Error(w, "invalid subnets parameter", http.StatusBadRequest)
+				return
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		err := json.NewEncoder(w).Encode(s.GetTopicTotals(keepSubnets))
+		if err != nil {
+			log.Debug(errors.Wrap(err, "unable to write topic totals"))
+		}
+	})
+}
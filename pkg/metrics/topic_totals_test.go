@@ -0,0 +1,92 @@
+package metrics
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTopicTotalsTestStore() *PeerStore {
+	store := NewPeerStore()
+	t0 := time.Unix(1000, 0)
+
+	p1 := store.GetOrCreatePeer(testPeerID("totals-peer1"))
+	for i := 0; i < 3; i++ {
+		p1.MessageEvent(testAttSubnet17Topic, t0.Add(time.Duration(i)*time.Second))
+		p1.MessageBytesEvent(testAttSubnet17Topic, 100)
+	}
+	p1.MessageEvent(testAttTopic, t0)
+	p1.MessageBytesEvent(testAttTopic, 50)
+	p1.MessageEvent(testBlockTopic, t0.Add(time.Minute))
+	p1.MessageBytesEvent(testBlockTopic, 1000)
+
+	p2 := store.GetOrCreatePeer(testPeerID("totals-peer2"))
+	p2.MessageEvent(testAttSubnet17Topic, t0.Add(-time.Second))
+	p2.MessageBytesEvent(testAttSubnet17Topic, 120)
+	// deliveries without messages don't count
+	p2.FirstDeliveryEvent(testBlockTopic)
+
+	store.GetOrCreatePeer(testPeerID("totals-peer3"))
+	return store
+}
+
+func Test_GetTopicTotals(t *testing.T) {
+	store := newTopicTotalsTestStore()
+	t0 := time.Unix(1000, 0)
+
+	// the families match the sum of the per-peer metrics
+	totals := store.GetTopicTotals(false)
+	require.Equal(t, 2, len(totals))
+	for _, topic := range []string{AttestationTopicName, "beacon_block"} {
+		var msgs int64
+		store.ForEachPeer(func(p *Peer) bool {
+			msgs += p.GetNumOfMsgFromTopic(topic)
+			return true
+		})
+		require.Equal(t, msgs, totals[topic].Messages, topic)
+	}
+	att := totals[AttestationTopicName]
+	require.Equal(t, int64(470), att.Bytes)
+	require.Equal(t, 2, att.Peers)
+	require.Equal(t, t0.Add(-time.Second), att.FirstMessageTime)
+	require.Equal(t, t0.Add(2*time.Second), att.LastMessageTime)
+	block := totals["beacon_block"]
+	require.Equal(t, TopicTotal{
+		Messages:         1,
+		Bytes:            1000,
+		Peers:            1,
+		FirstMessageTime: t0.Add(time.Minute),
+		LastMessageTime:  t0.Add(time.Minute),
+	}, block)
+
+	// the subnets kept separate add up to the family
+	totals = store.GetTopicTotals(true)
+	require.Equal(t, 3, len(totals))
+	sub17, sub3 := totals["beacon_attestation_17"], totals["beacon_attestation_3"]
+	require.Equal(t, int64(4), sub17.Messages)
+	require.Equal(t, 2, sub17.Peers)
+	require.Equal(t, int64(1), sub3.Messages)
+	require.Equal(t, 1, sub3.Peers)
+	require.Equal(t, att.Messages, sub17.Messages+sub3.Messages)
+	require.Equal(t, att.Bytes, sub17.Bytes+sub3.Bytes)
+}
+
+func Test_TopicTotalsHandler(t *testing.T) {
+	store := newTopicTotalsTestStore()
+	handler := store.TopicTotalsHandler()
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, TopicTotalsEndpoint+"?subnets=true", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	expected, err := json.Marshal(store.GetTopicTotals(true))
+	require.NoError(t, err)
+	require.JSONEq(t, string(expected), rec.Body.String())
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, TopicTotalsEndpoint+"?subnets=maybe", nil))
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+}
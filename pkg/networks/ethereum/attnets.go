@@ -0,0 +1,105 @@
+package ethereum
+
+import (
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// AttnetsMismatch is the result of comparing the attnets that a peer advertises in its ENR
+// with the ones in its beacon MetaData.
+type AttnetsMismatch struct {
+	Timestamp time.Time
+	PeerID    peer.ID
+	Mismatch  bool
+	Subnets   []int // subnets that are set in only one of both bitfields
+}
+
+// AttnetsSubnets returns the indices of the subnets set in the given attnets bitvector
+// (SSZ bitvector, the bit i of the vector is the bit i%8 of the byte i/8).
+func AttnetsSubnets(attnets []byte) []int {
+	subnets := make([]int, 0)
+	for i := 0; i < len(attnets)*8; i++ {
+		if attnets[i/8]&(1<<uint(i%8)) != 0 {
+			subnets = append(subnets, i)
+		}
+	}
+	return subnets
+}
+
+// CompareAttnets returns the subnets that differ between both attnets bitvectors.
+// Bitvectors of different lengths are compared as if the shorter one was padded with zeros.
+// If any of the bitvectors is missing ok will be false.
+func CompareAttnets(enrAttnets, mdAttnets []byte) (diff []int, ok bool) {
+	if len(enrAttnets) == 0 || len(mdAttnets) == 0 {
+		return nil, false
+	}
+	longest := len(enrAttnets)
+	if len(mdAttnets) > longest {
+		longest = len(mdAttnets)
+	}
+	xor := make([]byte, longest)
+	for i := range xor {
+		if i < len(enrAttnets) {
+			xor[i] ^= enrAttnets[i]
+		}
+		if i < len(mdAttnets) {
+			xor[i] ^= mdAttnets[i]
+		}
+	}
+	return AttnetsSubnets(xor), true
+}
+
+// AttnetsChecker keeps the last attnets received from the ENR and the MetaData of each peer,
+// comparing them once both are available.
+type AttnetsChecker struct {
+	m          sync.Mutex
+	enrAttnets map[peer.ID][]byte
+	mdAttnets  map[peer.ID][]byte
+}
+
+func NewAttnetsChecker() *AttnetsChecker {
+	return &AttnetsChecker{
+		enrAttnets: make(map[peer.ID][]byte),
+		mdAttnets:  make(map[peer.ID][]byte),
+	}
+}
+
+// AddEnr tracks the attnets of the given ENR, returning the comparison with the MetaData attnets
+// of the same peer (ok is false if there is nothing to compare with).
+func (c *AttnetsChecker) AddEnr(enr *EnrNode) (mismatch *AttnetsMismatch, ok bool) {
+	peerID, err := enr.GetPeerID()
+	if err != nil || enr.Attnets == nil || len(enr.Attnets.Raw) == 0 {
+		return nil, false
+	}
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.enrAttnets[peerID] = append([]byte{}, enr.Attnets.Raw...)
+	return c.compare(peerID)
+}
+
+// AddMetadata tracks the attnets of the given beacon MetaData, returning the comparison with
+// the ENR attnets of the same peer (ok is false if there is nothing to compare with).
+func (c *AttnetsChecker) AddMetadata(bmetadata BeaconMetadataStamped) (mismatch *AttnetsMismatch, ok bool) {
+	if bmetadata.IsEmpty() {
+		return nil, false
+	}
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.mdAttnets[bmetadata.PeerID] = append([]byte{}, bmetadata.Metadata.Attnets[:]...)
+	return c.compare(bmetadata.PeerID)
+}
+
+func (c *AttnetsChecker) compare(peerID peer.ID) (*AttnetsMismatch, bool) {
+	diff, ok := CompareAttnets(c.enrAttnets[peerID], c.mdAttnets[peerID])
+	if !ok {
+		return nil, false
+	}
+	return &AttnetsMismatch{
+		Timestamp: time.Now(),
+		PeerID:    peerID,
+		Mismatch:  len(diff) > 0,
+		Subnets:   diff,
+	}, true
+}
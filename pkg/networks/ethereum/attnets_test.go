@@ -0,0 +1,61 @@
+package ethereum
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompareAttnets(t *testing.T) {
+	// subnets 0 and 9 set
+	require.Equal(t, []int{0, 9}, AttnetsSubnets([]byte{0x01, 0x02}))
+
+	diff, ok := CompareAttnets([]byte{0x01, 0x02}, []byte{0x01, 0x02, 0x00, 0x00})
+	require.True(t, ok)
+	require.Equal(t, 0, len(diff))
+
+	// different lengths, the missing bytes count as unset
+	diff, ok = CompareAttnets([]byte{0x01}, []byte{0x03, 0x00, 0x80})
+	require.True(t, ok)
+	require.Equal(t, []int{1, 23}, diff)
+
+	// missing data is never flagged
+	_, ok = CompareAttnets(nil, []byte{0x01})
+	require.False(t, ok)
+	_, ok = CompareAttnets([]byte{0x01}, []byte{})
+	require.False(t, ok)
+}
+
+func TestAttnetsChecker(t *testing.T) {
+	node, err := enode.Parse(enode.ValidSchemes, "enr:-Ku4QImhMc1z8yCiNJ1TyUxdcfNucje3BGwEHzodEZUan8PherEo4sF7pPHPSIB1NNuSg5fZy7qFsjmUKs2ea1Whi0EBh2F0dG5ldHOIAAAAAAAAAACEZXRoMpD1pf1CAAAAAP__________gmlkgnY0gmlwhBLf22SJc2VjcDI1NmsxoQOVphkDqal4QzPMksc5wnpuC3gvSC8AfbFOnZY_On34wIN1ZHCCIyg")
+	require.NoError(t, err)
+	enrNode, err := ParseEnr(node)
+	require.NoError(t, err)
+	peerID, err := enrNode.GetPeerID()
+	require.NoError(t, err)
+
+	checker := NewAttnetsChecker()
+	enrNode.Attnets.Raw = AttnetsENREntry{0x05, 0, 0, 0, 0, 0, 0, 0}
+	// nothing to compare with yet
+	_, ok := checker.AddEnr(enrNode)
+	require.False(t, ok)
+
+	var md common.MetaData
+	md.Attnets[0] = 0x05
+	mismatch, ok := checker.AddMetadata(NewBeaconMetadata(peerID, md))
+	require.True(t, ok)
+	require.False(t, mismatch.Mismatch)
+	require.Equal(t, peerID, mismatch.PeerID)
+
+	md.Attnets[1] = 0x01
+	mismatch, ok = checker.AddMetadata(NewBeaconMetadata(peerID, md))
+	require.True(t, ok)
+	require.True(t, mismatch.Mismatch)
+	require.Equal(t, []int{8}, mismatch.Subnets)
+
+	// empty metadata is ignored
+	_, ok = checker.AddMetadata(BeaconMetadataStamped{})
+	require.False(t, ok)
+}
@@ -7,6 +7,7 @@ import (
 
 	"github.com/pkg/errors"
 
+	"github.com/migalabs/armiarma/pkg/db/models"
 	"github.com/migalabs/armiarma/pkg/networks/ethereum/rpc/methods"
 	"github.com/migalabs/armiarma/pkg/networks/ethereum/rpc/reqresp"
 
@@ -68,6 +69,11 @@ func (en *LocalEthereumNode) ReqBeaconMetadata(
 	*result = metadata
 }
 
+// MetadataRequestOutcome classifies the result of a MetaData request to the given peer.
+func MetadataRequestOutcome(peerID peer.ID, reqErr error) models.MetadataRequest {
+	return models.MetadataRequest{ReqRespOutcome: reqRespOutcome(peerID, reqErr)}
+}
+
 func (en *LocalEthereumNode) ServeBeaconMetadata(h host.Host) {
 
 	go func() {
@@ -5,6 +5,7 @@ import (
 
 	"github.com/libp2p/go-libp2p-core/host"
 	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/migalabs/armiarma/pkg/db/models"
 	"github.com/migalabs/armiarma/pkg/networks/ethereum/rpc/methods"
 	"github.com/migalabs/armiarma/pkg/networks/ethereum/rpc/reqresp"
 	"github.com/protolambda/zrnt/eth2/beacon/common"
@@ -42,6 +43,12 @@ func (en *LocalEthereumNode) ServeBeaconPing(h host.Host) {
 	}()
 }
 
+// OnGoodbye sets the handler notified of each Goodbye received from a remote peer
+// (needs to be set before serving the Goodbye requests).
+func (en *LocalEthereumNode) OnGoodbye(fn func(models.Goodbye)) {
+	en.goodbyeHandler = fn
+}
+
 func (en *LocalEthereumNode) ServeBeaconGoodbye(h host.Host) {
 	go func() {
 		sCtxFn := func() context.Context {
@@ -56,6 +63,9 @@ func (en *LocalEthereumNode) ServeBeaconGoodbye(h host.Host) {
 				_ = handler.WriteErrorChunk(reqresp.InvalidReqCode, "could not parse goodbye request")
 				log.Tracef("failed to read goodbye request: %v from %s", err, peerId.String())
 			} else {
+				if en.goodbyeHandler != nil {
+					en.goodbyeHandler(models.NewGoodbye(peerId, uint64(goodbye)))
+				}
 				if err := handler.WriteResponseChunk(reqresp.SuccessCode, &goodbye); err != nil {
 					log.Tracef("failed to respond to goodbye request: %v", err)
 				} else {
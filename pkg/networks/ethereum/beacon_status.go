@@ -2,8 +2,10 @@ package ethereum
 
 import (
 	"context"
+	"strings"
 	"sync"
 
+	"github.com/migalabs/armiarma/pkg/db/models"
 	"github.com/migalabs/armiarma/pkg/networks/ethereum/rpc/methods"
 	"github.com/migalabs/armiarma/pkg/networks/ethereum/rpc/reqresp"
 	"github.com/pkg/errors"
@@ -36,18 +38,19 @@ func (en *LocalEthereumNode) ReqBeaconStatus(
 		},
 		func(chunk reqresp.ChunkedResponseHandler) error {
 			resCode = chunk.ResultCode()
-			switch resCode {
-			case reqresp.ServerErrCode, reqresp.InvalidReqCode:
+			switch {
+			case resCode == reqresp.SuccessCode:
+				if err := chunk.ReadObj(&remoteStatus); err != nil {
+					return err
+				}
+			case resCode.IsErrorCode():
 				msg, err := chunk.ReadErrMsg()
 				if err != nil {
 					return errors.Wrap(err, msg)
 				}
-			case reqresp.SuccessCode:
-				if err := chunk.ReadObj(&remoteStatus); err != nil {
-					return err
-				}
+				return &reqresp.ResponseError{Code: resCode, Msg: msg}
 			default:
-				return errors.New("unexpected result code")
+				return &reqresp.ResponseError{Code: resCode, Msg: "unexpected result code"}
 			}
 			return nil
 		})
@@ -57,6 +60,33 @@ func (en *LocalEthereumNode) ReqBeaconStatus(
 	en.UpdateStatus(remoteStatus)
 }
 
+// StatusRequestOutcome classifies the result of a Status request to the given peer.
+func StatusRequestOutcome(peerID peer.ID, reqErr error) models.ReqRespOutcome {
+	return reqRespOutcome(peerID, reqErr)
+}
+
+// reqRespOutcome classifies the result of a req/resp request to the given peer.
+func reqRespOutcome(peerID peer.ID, reqErr error) models.ReqRespOutcome {
+	if reqErr == nil {
+		return models.NewReqRespOutcome(peerID, models.ReqRespSuccess, -1)
+	}
+	var respErr *reqresp.ResponseError
+	if errors.As(reqErr, &respErr) {
+		return models.NewReqRespOutcome(peerID, models.ReqRespErrorResponse, int(respErr.Code))
+	}
+	errStr := reqErr.Error()
+	switch {
+	case strings.Contains(errStr, "stream reset"):
+		return models.NewReqRespOutcome(peerID, models.ReqRespStreamReset, -1)
+	case errors.Is(reqErr, context.DeadlineExceeded),
+		strings.Contains(errStr, "deadline exceeded"),
+		strings.Contains(errStr, "timeout"):
+		return models.NewReqRespOutcome(peerID, models.ReqRespTimeout, -1)
+	default:
+		return models.NewReqRespOutcome(peerID, models.ReqRespOther, -1)
+	}
+}
+
 func (en *LocalEthereumNode) ServeBeaconStatus(h host.Host) {
 
 	go func() {
@@ -0,0 +1,41 @@
+package ethereum
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/migalabs/armiarma/pkg/db/models"
+	"github.com/migalabs/armiarma/pkg/networks/ethereum/rpc/reqresp"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatusRequestOutcome(t *testing.T) {
+	pid := peer.ID("status-peer")
+
+	outcome := StatusRequestOutcome(pid, nil)
+	require.True(t, outcome.Succeeded())
+	require.Equal(t, "", outcome.ErrorKey())
+
+	// the remote peer reset the stream
+	outcome = StatusRequestOutcome(pid, errors.New("failed to read chunk 0 result byte: stream reset"))
+	require.Equal(t, models.ReqRespStreamReset, outcome.Category)
+	require.Equal(t, "stream_reset", outcome.ErrorKey())
+
+	// the request timed out
+	outcome = StatusRequestOutcome(pid, errors.Wrap(context.DeadlineExceeded, "failed to open stream"))
+	require.Equal(t, models.ReqRespTimeout, outcome.Category)
+
+	// the remote peer answered with an error (wrapped as the req/resp layer does)
+	respErr := &reqresp.ResponseError{Code: reqresp.ResourceUnavailableCode, Msg: "rate limited"}
+	outcome = StatusRequestOutcome(pid, fmt.Errorf("made request: %w", respErr))
+	require.Equal(t, models.ReqRespErrorResponse, outcome.Category)
+	require.Equal(t, 3, outcome.ResponseCode)
+	require.Equal(t, "error_response_3", outcome.ErrorKey())
+	require.False(t, outcome.Succeeded())
+
+	outcome = StatusRequestOutcome(pid, errors.New("protocol not supported"))
+	require.Equal(t, models.ReqRespOther, outcome.Category)
+}
@@ -10,6 +10,7 @@ import (
 	"github.com/ethereum/go-ethereum/p2p/enr"
 	"github.com/protolambda/zrnt/eth2/beacon/common"
 
+	"github.com/migalabs/armiarma/pkg/db/models"
 	"github.com/migalabs/armiarma/pkg/utils"
 	log "github.com/sirupsen/logrus"
 )
@@ -27,6 +28,8 @@ type LocalEthereumNode struct {
 	LocalMetadata common.MetaData
 	// Network Details
 	networkGenesis time.Time
+	// notified of the Goodbye messages received from the remote peers
+	goodbyeHandler func(models.Goodbye)
 }
 
 // NewLocalNode will create a LocalNode object using the given arguments.
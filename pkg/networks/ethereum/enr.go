@@ -2,24 +2,30 @@ package ethereum
 
 import (
 	"crypto/ecdsa"
-	"encoding/binary"
 	"encoding/hex"
 	"math/bits"
 	"net"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/libp2p/go-libp2p-core/peer"
 	"github.com/migalabs/armiarma/pkg/utils"
 	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
 
 	gcrypto "github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/p2p/enr"
+	"github.com/ethereum/go-ethereum/rlp"
 	"github.com/protolambda/zrnt/eth2/beacon/common"
 )
 
 var (
-	EnrValidationError   error = errors.New("error validating ENR")
-	Eth2DataParsingError error = errors.New("error parsing eth2 data")
+	EnrValidationError    error = errors.New("error validating ENR")
+	Eth2DataParsingError  error = errors.New("error parsing eth2 data")
+	UnknownForeignPolicy  error = errors.New("unknown foreign network policy")
+	UnknownEth2lessPolicy error = errors.New("unknown eth2less ENR policy")
 )
 
 var (
@@ -36,6 +42,14 @@ type EnrNode struct {
 	Pubkey    *ecdsa.PublicKey
 	Eth2Data  *common.Eth2Data
 	Attnets   *Attnets
+	Syncnets  *Syncnets
+	// whether the ENR has the eth2 entry (plain discv5 nodes don't)
+	HasEth2Data bool
+	// raw text of the ENR, and all its key-values (hex encoded values)
+	Raw     string
+	Entries map[string]string
+	// whether the fork digest of the eth2 entry belongs to a different network than the crawled one
+	ForeignNetwork bool
 }
 
 func NewEnrNode(nodeID enode.ID) *EnrNode {
@@ -46,6 +60,8 @@ func NewEnrNode(nodeID enode.ID) *EnrNode {
 		Pubkey:    new(ecdsa.PublicKey),
 		Eth2Data:  new(common.Eth2Data),
 		Attnets:   new(Attnets),
+		Syncnets:  new(Syncnets),
+		Entries:   make(map[string]string),
 	}
 }
 
@@ -66,6 +82,8 @@ func ParseEnr(node *enode.Node) (*EnrNode, error) {
 	enrNode.UDP = node.UDP()
 	enrNode.TCP = node.TCP()
 	enrNode.Pubkey = node.Pubkey()
+	enrNode.Raw = node.String()
+	enrNode.Entries = ParseEnrEntries(node.Record())
 
 	// Retrieve the Fork Digest and the attestnets
 	eth2Data, ok, err := ParseNodeEth2Data(*node)
@@ -77,14 +95,248 @@ func ParseEnr(node *enode.Node) (*EnrNode, error) {
 		}
 	}
 	enrNode.Eth2Data = eth2Data
+	enrNode.HasEth2Data = ok
 
 	// in this case ParseAttnets will always return or a new(attnets) or a filled one
 	attnets, _, _ := ParseAttnets(*node)
 	enrNode.Attnets = attnets
+	// as well as ParseSyncnets
+	syncnets, _, _ := ParseSyncnets(*node)
+	enrNode.Syncnets = syncnets
 
 	return enrNode, nil
 }
 
+// ParseEnrEntries returns all the key-value pairs of the record with their values hex encoded.
+// The values that are RLP strings are decoded first, while the rest (i.e. lists)
+// are kept as the hex of their raw RLP, so a single unparseable entry never fails the record.
+func ParseEnrEntries(record *enr.Record) map[string]string {
+	entries := make(map[string]string)
+	// the elements are the seq followed by the key-value pairs
+	elements := record.AppendElements(nil)
+	for i := 1; i+1 < len(elements); i += 2 {
+		key, ok := elements[i].(string)
+		if !ok {
+			continue
+		}
+		rawValue, ok := elements[i+1].(rlp.RawValue)
+		if !ok {
+			continue
+		}
+		var value []byte
+		err := rlp.DecodeBytes(rawValue, &value)
+		if err != nil {
+			value = rawValue
+		}
+		entries[key] = hex.EncodeToString(value)
+	}
+	return entries
+}
+
+// EnrSeqTracker keeps the highest seq seen per node, so that the stale copies
+// of an ENR (lower seq than the one we already have) can be discarded.
+type EnrSeqTracker struct {
+	m     sync.Mutex
+	seqs  map[enode.ID]uint64
+	stale uint64
+}
+
+func NewEnrSeqTracker() *EnrSeqTracker {
+	return &EnrSeqTracker{
+		seqs: make(map[enode.ID]uint64),
+	}
+}
+
+// IsNewer returns true if the ENR has a higher seq than any previous ENR of the same node
+// (or if the node is new), tracking it as the latest one. ENRs with a lower seq are counted as stale.
+func (t *EnrSeqTracker) IsNewer(enr *EnrNode) bool {
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	lastSeq, ok := t.seqs[enr.ID]
+	if ok && enr.Seq <= lastSeq {
+		if enr.Seq < lastSeq {
+			t.stale++
+			log.Debugf("stale ENR of node %s - seq %d (latest %d)", enr.ID.String(), enr.Seq, lastSeq)
+		}
+		return false
+	}
+	t.seqs[enr.ID] = enr.Seq
+	return true
+}
+
+// StaleCount returns the number of ENRs that were rejected for having an older seq.
+func (t *EnrSeqTracker) StaleCount() uint64 {
+	t.m.Lock()
+	defer t.m.Unlock()
+	return t.stale
+}
+
+// ForeignNetworkPolicy defines what to do with the ENRs that belong to a different network.
+type ForeignNetworkPolicy string
+
+const (
+	// the foreign ENRs are only counted
+	DropForeignNetwork ForeignNetworkPolicy = "drop"
+	// the foreign ENRs are persisted with the foreign_network flag
+	FlagForeignNetwork ForeignNetworkPolicy = "flag"
+)
+
+// ParseForeignNetworkPolicy returns the ForeignNetworkPolicy of the given name.
+func ParseForeignNetworkPolicy(policy string) (ForeignNetworkPolicy, error) {
+	switch ForeignNetworkPolicy(strings.ToLower(policy)) {
+	case DropForeignNetwork:
+		return DropForeignNetwork, nil
+	case FlagForeignNetwork:
+		return FlagForeignNetwork, nil
+	default:
+		return "", errors.Wrap(UnknownForeignPolicy, policy)
+	}
+}
+
+// Eth2lessPolicy defines what to do with the ENRs without eth2 entry (plain discv5 nodes),
+// that don't advertise any network.
+type Eth2lessPolicy string
+
+const (
+	// the ENRs without eth2 entry are persisted and dialed
+	KeepEth2less Eth2lessPolicy = "keep"
+	// the ENRs without eth2 entry are only counted
+	DropEth2less Eth2lessPolicy = "drop"
+)
+
+// ParseEth2lessPolicy returns the Eth2lessPolicy of the given name.
+func ParseEth2lessPolicy(policy string) (Eth2lessPolicy, error) {
+	switch Eth2lessPolicy(strings.ToLower(policy)) {
+	case KeepEth2less:
+		return KeepEth2less, nil
+	case DropEth2less:
+		return DropEth2less, nil
+	default:
+		return "", errors.Wrap(UnknownEth2lessPolicy, policy)
+	}
+}
+
+// NetworkFilter checks whether the discovered ENRs and the BeaconStatus of the connected peers belong
+// to the crawled network, comparing their fork digest with an allowlist of fork digests.
+// An empty allowlist accepts any fork digest.
+type NetworkFilter struct {
+	m        sync.Mutex
+	digests  map[string]struct{}
+	policy   ForeignNetworkPolicy
+	eth2less Eth2lessPolicy
+	// foreign ENRs and statuses per fork digest
+	foreign       map[string]uint64
+	foreignStatus map[string]uint64
+	// dropped ENRs without eth2 entry
+	droppedEth2less uint64
+}
+
+// NewNetworkFilter returns a NetworkFilter that only allows the fork digests of the allowlist
+// (any of them if it's empty).
+func NewNetworkFilter(allowlist []string, policy ForeignNetworkPolicy, eth2less Eth2lessPolicy) *NetworkFilter {
+	f := &NetworkFilter{
+		digests:       make(map[string]struct{}),
+		policy:        policy,
+		eth2less:      eth2less,
+		foreign:       make(map[string]uint64),
+		foreignStatus: make(map[string]uint64),
+	}
+	for _, digest := range allowlist {
+		f.digests[strings.ToLower(digest)] = struct{}{}
+	}
+	return f
+}
+
+// Allows returns whether the fork digest belongs to the crawled network.
+func (f *NetworkFilter) Allows(forkDigest string) bool {
+	if len(f.digests) == 0 {
+		return true
+	}
+	_, ok := f.digests[strings.ToLower(forkDigest)]
+	return ok
+}
+
+// Keep returns whether the ENR has to be persisted, flagging it as ForeignNetwork
+// if it belongs to a different network. Foreign ENRs are counted regardless of the policy.
+// The ENRs without eth2 entry (plain discv5 nodes) don't advertise any network, so they follow the eth2less policy.
+func (f *NetworkFilter) Keep(enr *EnrNode) bool {
+	if !enr.HasEth2Data {
+		if f.eth2less == KeepEth2less {
+			return true
+		}
+		f.m.Lock()
+		f.droppedEth2less++
+		f.m.Unlock()
+		log.Tracef("new node discovered - no eth2 entry")
+		return false
+	}
+	digest := enr.Eth2Data.ForkDigest.String()
+	if f.Allows(digest) {
+		return true
+	}
+	f.m.Lock()
+	f.foreign[digest]++
+	f.m.Unlock()
+	log.Tracef("new node discovered - foreign network fork digest %s", digest)
+
+	enr.ForeignNetwork = true
+	return f.policy == FlagForeignNetwork
+}
+
+// KeepStatus returns whether the BeaconStatus of a connected peer belongs to the crawled network,
+// counting the foreign ones.
+func (f *NetworkFilter) KeepStatus(status common.Status) bool {
+	digest := status.ForkDigest.String()
+	if f.Allows(digest) {
+		return true
+	}
+	f.m.Lock()
+	f.foreignStatus[digest]++
+	f.m.Unlock()
+	return false
+}
+
+// ForeignCount returns the number of ENRs that belonged to a different network.
+func (f *NetworkFilter) ForeignCount() uint64 {
+	f.m.Lock()
+	defer f.m.Unlock()
+	total := uint64(0)
+	for _, cnt := range f.foreign {
+		total += cnt
+	}
+	return total
+}
+
+// ForeignDigests returns the number of ENRs that belonged to a different network per fork digest.
+func (f *NetworkFilter) ForeignDigests() map[string]uint64 {
+	f.m.Lock()
+	defer f.m.Unlock()
+	return copyDigestCounts(f.foreign)
+}
+
+// ForeignStatusDigests returns the number of BeaconStatus that belonged to a different network per fork digest.
+func (f *NetworkFilter) ForeignStatusDigests() map[string]uint64 {
+	f.m.Lock()
+	defer f.m.Unlock()
+	return copyDigestCounts(f.foreignStatus)
+}
+
+// DroppedEth2lessCount returns the number of ENRs without eth2 entry that were dropped.
+func (f *NetworkFilter) DroppedEth2lessCount() uint64 {
+	f.m.Lock()
+	defer f.m.Unlock()
+	return f.droppedEth2less
+}
+
+func copyDigestCounts(counts map[string]uint64) map[string]uint64 {
+	c := make(map[string]uint64, len(counts))
+	for digest, cnt := range counts {
+		c[digest] = cnt
+	}
+	return c
+}
+
 func (enr *EnrNode) GetPeerID() (peer.ID, error) {
 	// Get the public key and the peer.ID of the discovered peer
 	pubkey, err := utils.ConvertECDSAPubkeyToSecp2561k(enr.Pubkey)
@@ -109,6 +361,39 @@ func (enr *EnrNode) GetAttnetsString() string {
 	return hex.EncodeToString(enr.Attnets.Raw[:])
 }
 
+// AttnetsBitvector returns the attnets of the ENR (nil if it has none), see models.AttnetsAttr.
+func (enr *EnrNode) AttnetsBitvector() []byte {
+	if enr.Attnets == nil || len(enr.Attnets.Raw) == 0 {
+		return nil
+	}
+	return append([]byte{}, enr.Attnets.Raw...)
+}
+
+// AttnetsMask returns the attnets of the ENR as a mask whose bit i is the subnet i,
+// ok is false if the ENR has no attnets.
+func (enr *EnrNode) AttnetsMask() (mask uint64, ok bool) {
+	if enr.Attnets == nil || len(enr.Attnets.Raw) == 0 {
+		return 0, false
+	}
+	return BitvectorMask(enr.Attnets.Raw), true
+}
+
+func (enr *EnrNode) GetSyncnetsString() string {
+	if enr.Syncnets == nil {
+		return ""
+	}
+	return hex.EncodeToString(enr.Syncnets.Raw[:])
+}
+
+// SyncnetsMask returns the syncnets of the ENR as a mask whose bit i is the sync committee subnet i,
+// ok is false if the ENR has no syncnets.
+func (enr *EnrNode) SyncnetsMask() (mask uint64, ok bool) {
+	if enr.Syncnets == nil || len(enr.Syncnets.Raw) == 0 {
+		return 0, false
+	}
+	return BitvectorMask(enr.Syncnets.Raw), true
+}
+
 type Attnets struct {
 	Raw       AttnetsENREntry
 	NetNumber int
@@ -133,7 +418,43 @@ func ParseAttnets(node enode.Node) (attnets *Attnets, exists bool, err error) {
 	return att, true, nil
 }
 
+type Syncnets struct {
+	Raw       SyncnetsENREntry
+	NetNumber int
+}
+
+// ParseSyncnets returns always an initialized Syncnets object
+// If the Ethereum Node doesn't have the Syncnets key-value NetNumber will be -1
+func ParseSyncnets(node enode.Node) (syncnets *Syncnets, exists bool, err error) {
+	syncnets = &Syncnets{
+		Raw:       *new(SyncnetsENREntry),
+		NetNumber: -1,
+	}
+
+	err = node.Load(&syncnets.Raw)
+	if err != nil {
+		return syncnets, false, nil
+	}
+
+	syncnets.NetNumber = CountBits(syncnets.Raw[:])
+	return syncnets, true, nil
+}
+
+// CountBits returns the number of set bits of the given bitfield (of any length).
 func CountBits(byteArr []byte) int {
-	rawInt := binary.BigEndian.Uint64(byteArr)
-	return bits.OnesCount64(rawInt)
+	count := 0
+	for _, b := range byteArr {
+		count += bits.OnesCount8(b)
+	}
+	return count
+}
+
+// BitvectorMask returns the SSZ bitvector (the bit i of the vector is the bit i%8 of the byte i/8)
+// as a mask whose bit i is the bit i of the vector. Only the first 64 bits are kept.
+func BitvectorMask(bitvector []byte) uint64 {
+	var mask uint64
+	for i := 0; i < len(bitvector) && i < 8; i++ {
+		mask |= uint64(bitvector[i]) << (8 * uint(i))
+	}
+	return mask
 }
@@ -0,0 +1,236 @@
+package ethereum
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/pkg/errors"
+)
+
+// EnrAddrs are the ip/ip6/tcp/udp/quic entries that a node advertises in its ENR
+// (nil IPs and 0 ports for the missing entries).
+type EnrAddrs struct {
+	IP   net.IP
+	IP6  net.IP
+	TCP  int
+	UDP  int
+	QUIC int
+}
+
+// ParseEnrAddrs returns the addresses of the given ENR entries (see ParseEnrEntries).
+func ParseEnrAddrs(entries map[string]string) EnrAddrs {
+	var addrs EnrAddrs
+	if ip := enrEntryBytes(entries, "ip"); len(ip) == net.IPv4len {
+		addrs.IP = net.IP(ip)
+	}
+	if ip6 := enrEntryBytes(entries, "ip6"); len(ip6) == net.IPv6len {
+		addrs.IP6 = net.IP(ip6)
+	}
+	addrs.TCP = enrEntryPort(entries, "tcp")
+	addrs.UDP = enrEntryPort(entries, "udp")
+	addrs.QUIC = enrEntryPort(entries, "quic")
+	return addrs
+}
+
+// Addrs returns the addresses advertised in the ENR.
+func (e *EnrNode) Addrs() EnrAddrs {
+	return ParseEnrAddrs(e.Entries)
+}
+
+// HasIP returns whether the ENR advertises any IP.
+func (a EnrAddrs) HasIP() bool {
+	return a.IP != nil || a.IP6 != nil
+}
+
+func enrEntryBytes(entries map[string]string, key string) []byte {
+	value, ok := entries[key]
+	if !ok {
+		return nil
+	}
+	raw, err := hex.DecodeString(value)
+	if err != nil {
+		return nil
+	}
+	return raw
+}
+
+// enrEntryPort decodes the big-endian port of the entry (0 if missing or invalid).
+func enrEntryPort(entries map[string]string, key string) int {
+	raw := enrEntryBytes(entries, key)
+	if len(raw) == 0 || len(raw) > 2 {
+		return 0
+	}
+	port := 0
+	for _, b := range raw {
+		port = port<<8 | int(b)
+	}
+	return port
+}
+
+// ObservedAddr is the address from which we actually talked with a peer.
+type ObservedAddr struct {
+	IP        net.IP
+	Port      int
+	Transport string // "tcp" or "quic"
+	// the port of the inbound connections is an ephemeral one, so it is only compared for the outbound ones
+	Outbound bool
+}
+
+// ParseObservedAddr returns the ObservedAddr of the remote multiaddress of a connection.
+func ParseObservedAddr(remoteAddr string, outbound bool) (ObservedAddr, error) {
+	addr, err := ma.NewMultiaddr(remoteAddr)
+	if err != nil {
+		return ObservedAddr{}, errors.Wrap(err, "unable to parse observed address "+remoteAddr)
+	}
+	observed := ObservedAddr{Outbound: outbound}
+	for _, proto := range []int{ma.P_IP4, ma.P_IP6} {
+		if ip, err := addr.ValueForProtocol(proto); err == nil {
+			observed.IP = net.ParseIP(ip)
+			break
+		}
+	}
+	if observed.IP == nil {
+		return ObservedAddr{}, errors.New("observed address without ip " + remoteAddr)
+	}
+	if port, err := addr.ValueForProtocol(ma.P_TCP); err == nil {
+		observed.Transport = "tcp"
+		observed.Port, _ = strconv.Atoi(port)
+	} else if port, err := addr.ValueForProtocol(ma.P_UDP); err == nil {
+		if _, err := addr.ValueForProtocol(ma.P_QUIC); err == nil {
+			observed.Transport = "quic"
+			observed.Port, _ = strconv.Atoi(port)
+		}
+	}
+	return observed, nil
+}
+
+// CompareAddrs returns the differences between the advertised and the observed addresses of a peer,
+// as "entry enr=<advertised> observed=<observed>". The IP is compared with the ENR entry of its
+// family, and the port with the entry of its transport (only for outbound connections, and unless
+// ignorePorts). If the ENR has no IP there is nothing to compare and ok will be false.
+func CompareAddrs(enrAddrs EnrAddrs, observed ObservedAddr, ignorePorts bool) (diff []string, ok bool) {
+	if !enrAddrs.HasIP() || observed.IP == nil {
+		return nil, false
+	}
+	diff = make([]string, 0)
+	ipEntry, enrIP := "ip", enrAddrs.IP
+	if observed.IP.To4() == nil {
+		ipEntry, enrIP = "ip6", enrAddrs.IP6
+	}
+	if !enrIP.Equal(observed.IP) {
+		diff = append(diff, addrDiff(ipEntry, ipString(enrIP), observed.IP.String()))
+	}
+	if ignorePorts || !observed.Outbound {
+		return diff, true
+	}
+	var enrPort int
+	switch observed.Transport {
+	case "tcp":
+		enrPort = enrAddrs.TCP
+	case "quic":
+		enrPort = enrAddrs.QUIC
+	default:
+		return diff, true
+	}
+	if enrPort != observed.Port {
+		diff = append(diff, addrDiff(observed.Transport, portString(enrPort), strconv.Itoa(observed.Port)))
+	}
+	return diff, true
+}
+
+func addrDiff(entry, advertised, observed string) string {
+	return fmt.Sprintf("%s enr=%s observed=%s", entry, advertised, observed)
+}
+
+func ipString(ip net.IP) string {
+	if ip == nil {
+		return "none"
+	}
+	return ip.String()
+}
+
+func portString(port int) string {
+	if port == 0 {
+		return "none"
+	}
+	return strconv.Itoa(port)
+}
+
+// AddrMismatch is the result of comparing the addresses that a peer advertises in its ENR
+// with the address from which it talked with us.
+type AddrMismatch struct {
+	Timestamp time.Time
+	PeerID    peer.ID
+	Mismatch  bool
+	Values    []string // differing entries (see CompareAddrs)
+}
+
+// AddrChecker keeps the last ENR addresses and the last observed address of each peer,
+// comparing them once both are available.
+type AddrChecker struct {
+	m           sync.Mutex
+	ignorePorts bool
+	enrAddrs    map[peer.ID]EnrAddrs
+	observed    map[peer.ID]ObservedAddr
+}
+
+// NewAddrChecker returns an AddrChecker that doesn't flag the port-only differences if ignorePorts.
+func NewAddrChecker(ignorePorts bool) *AddrChecker {
+	return &AddrChecker{
+		ignorePorts: ignorePorts,
+		enrAddrs:    make(map[peer.ID]EnrAddrs),
+		observed:    make(map[peer.ID]ObservedAddr),
+	}
+}
+
+// AddEnr tracks the addresses of the given ENR, returning the comparison with the observed address
+// of the same peer (ok is false if there is nothing to compare with).
+func (c *AddrChecker) AddEnr(enr *EnrNode) (mismatch *AddrMismatch, ok bool) {
+	peerID, err := enr.GetPeerID()
+	if err != nil {
+		return nil, false
+	}
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.enrAddrs[peerID] = enr.Addrs()
+	return c.compare(peerID)
+}
+
+// AddObserved tracks the address from which the peer talked with us, returning the comparison
+// with the ENR addresses of the same peer (ok is false if there is nothing to compare with).
+func (c *AddrChecker) AddObserved(peerID peer.ID, observed ObservedAddr) (mismatch *AddrMismatch, ok bool) {
+	if observed.IP == nil {
+		return nil, false
+	}
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.observed[peerID] = observed
+	return c.compare(peerID)
+}
+
+func (c *AddrChecker) compare(peerID peer.ID) (*AddrMismatch, bool) {
+	enrAddrs, ok := c.enrAddrs[peerID]
+	if !ok {
+		return nil, false
+	}
+	observed, ok := c.observed[peerID]
+	if !ok {
+		return nil, false
+	}
+	diff, ok := CompareAddrs(enrAddrs, observed, c.ignorePorts)
+	if !ok {
+		return nil, false
+	}
+	return &AddrMismatch{
+		Timestamp: time.Now(),
+		PeerID:    peerID,
+		Mismatch:  len(diff) > 0,
+		Values:    diff,
+	}, true
+}
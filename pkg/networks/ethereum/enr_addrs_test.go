@@ -0,0 +1,111 @@
+package ethereum
+
+import (
+	"net"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseEnrAddrs(t *testing.T) {
+	addrs := ParseEnrAddrs(map[string]string{
+		"ip":   "12dfdb64",
+		"ip6":  "20010db8000000000000000000000001",
+		"tcp":  "2328",
+		"udp":  "2329",
+		"quic": "232a",
+	})
+	require.Equal(t, "18.223.219.100", addrs.IP.String())
+	require.Equal(t, "2001:db8::1", addrs.IP6.String())
+	require.Equal(t, 9000, addrs.TCP)
+	require.Equal(t, 9001, addrs.UDP)
+	require.Equal(t, 9002, addrs.QUIC)
+
+	// missing and malformed entries are left empty
+	addrs = ParseEnrAddrs(map[string]string{"ip": "12df", "tcp": "zz"})
+	require.False(t, addrs.HasIP())
+	require.Equal(t, EnrAddrs{}, addrs)
+}
+
+func TestParseObservedAddr(t *testing.T) {
+	observed, err := ParseObservedAddr("/ip4/18.223.219.100/tcp/9000", true)
+	require.NoError(t, err)
+	require.Equal(t, ObservedAddr{IP: net.ParseIP("18.223.219.100"), Port: 9000, Transport: "tcp", Outbound: true}, observed)
+
+	observed, err = ParseObservedAddr("/ip6/2001:db8::1/udp/9001/quic", false)
+	require.NoError(t, err)
+	require.Equal(t, "quic", observed.Transport)
+	require.Equal(t, 9001, observed.Port)
+
+	_, err = ParseObservedAddr("/dns4/example.com/tcp/9000", true)
+	require.Error(t, err)
+}
+
+func TestCompareAddrs(t *testing.T) {
+	enrAddrs := EnrAddrs{IP: net.ParseIP("18.223.219.100").To4(), TCP: 9000, QUIC: 9001}
+	observed := ObservedAddr{IP: net.ParseIP("18.223.219.100"), Port: 9000, Transport: "tcp", Outbound: true}
+
+	// match
+	diff, ok := CompareAddrs(enrAddrs, observed, false)
+	require.True(t, ok)
+	require.Empty(t, diff)
+
+	// mismatch of the ip and the port
+	observed = ObservedAddr{IP: net.ParseIP("3.3.3.3"), Port: 13000, Transport: "tcp", Outbound: true}
+	diff, ok = CompareAddrs(enrAddrs, observed, false)
+	require.True(t, ok)
+	require.Equal(t, []string{"ip enr=18.223.219.100 observed=3.3.3.3", "tcp enr=9000 observed=13000"}, diff)
+
+	// port-only differences behind the same ip can be ignored
+	observed = ObservedAddr{IP: net.ParseIP("18.223.219.100"), Port: 13000, Transport: "tcp", Outbound: true}
+	diff, ok = CompareAddrs(enrAddrs, observed, true)
+	require.True(t, ok)
+	require.Empty(t, diff)
+
+	// the inbound connections come from an ephemeral port
+	observed.Outbound = false
+	diff, ok = CompareAddrs(enrAddrs, observed, false)
+	require.True(t, ok)
+	require.Empty(t, diff)
+
+	// the ip of a family that the ENR doesn't advertise
+	observed = ObservedAddr{IP: net.ParseIP("2001:db8::1"), Port: 9001, Transport: "quic", Outbound: true}
+	diff, ok = CompareAddrs(enrAddrs, observed, false)
+	require.True(t, ok)
+	require.Equal(t, []string{"ip6 enr=none observed=2001:db8::1"}, diff)
+
+	// ENR without ip, nothing to compare with
+	_, ok = CompareAddrs(EnrAddrs{TCP: 9000}, observed, false)
+	require.False(t, ok)
+}
+
+func TestAddrChecker(t *testing.T) {
+	// ENR of 18.223.219.100, with udp 9000 and without tcp
+	node, err := enode.Parse(enode.ValidSchemes, "enr:-Ku4QImhMc1z8yCiNJ1TyUxdcfNucje3BGwEHzodEZUan8PherEo4sF7pPHPSIB1NNuSg5fZy7qFsjmUKs2ea1Whi0EBh2F0dG5ldHOIAAAAAAAAAACEZXRoMpD1pf1CAAAAAP__________gmlkgnY0gmlwhBLf22SJc2VjcDI1NmsxoQOVphkDqal4QzPMksc5wnpuC3gvSC8AfbFOnZY_On34wIN1ZHCCIyg")
+	require.NoError(t, err)
+	enrNode, err := ParseEnr(node)
+	require.NoError(t, err)
+	peerID, err := enrNode.GetPeerID()
+	require.NoError(t, err)
+
+	checker := NewAddrChecker(false)
+	// nothing to compare with yet
+	_, ok := checker.AddEnr(enrNode)
+	require.False(t, ok)
+
+	mismatch, ok := checker.AddObserved(peerID, ObservedAddr{IP: net.ParseIP("18.223.219.100"), Port: 40000, Transport: "tcp"})
+	require.True(t, ok)
+	require.False(t, mismatch.Mismatch)
+	require.Equal(t, peerID, mismatch.PeerID)
+
+	mismatch, ok = checker.AddObserved(peerID, ObservedAddr{IP: net.ParseIP("18.223.219.100"), Port: 9000, Transport: "tcp", Outbound: true})
+	require.True(t, ok)
+	require.True(t, mismatch.Mismatch)
+	require.Equal(t, []string{"tcp enr=none observed=9000"}, mismatch.Values)
+
+	// ENR without ip
+	delete(enrNode.Entries, "ip")
+	_, ok = checker.AddEnr(enrNode)
+	require.False(t, ok)
+}
@@ -0,0 +1,301 @@
+package ethereum
+
+import (
+	"encoding/hex"
+	"math"
+	"net"
+	"strings"
+	"testing"
+
+	gcrypto "github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/p2p/enr"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseEnrEntries(t *testing.T) {
+	enrStr := "enr:-Ku4QImhMc1z8yCiNJ1TyUxdcfNucje3BGwEHzodEZUan8PherEo4sF7pPHPSIB1NNuSg5fZy7qFsjmUKs2ea1Whi0EBh2F0dG5ldHOIAAAAAAAAAACEZXRoMpD1pf1CAAAAAP__________gmlkgnY0gmlwhBLf22SJc2VjcDI1NmsxoQOVphkDqal4QzPMksc5wnpuC3gvSC8AfbFOnZY_On34wIN1ZHCCIyg"
+	node, err := enode.Parse(enode.ValidSchemes, enrStr)
+	require.NoError(t, err)
+
+	enrNode, err := ParseEnr(node)
+	require.NoError(t, err)
+	require.Equal(t, enrStr, enrNode.Raw)
+	require.Equal(t, 6, len(enrNode.Entries))
+	require.Equal(t, hex.EncodeToString([]byte("v4")), enrNode.Entries["id"])
+	require.Equal(t, "0000000000000000", enrNode.Entries["attnets"])
+	require.Equal(t, "12dfdb64", enrNode.Entries["ip"])
+	require.Equal(t, "2328", enrNode.Entries["udp"])
+	require.Equal(t, "f5a5fd4200000000ffffffffffffffff", enrNode.Entries["eth2"])
+
+	// the entries that aren't RLP strings are kept as the hex of the raw RLP
+	var record enr.Record
+	record.Set(enr.WithEntry("quic", uint16(9001)))
+	record.Set(enr.WithEntry("custom", []uint{1, 2}))
+	entries := ParseEnrEntries(&record)
+	require.Equal(t, "2329", entries["quic"])
+	rawList, err := rlp.EncodeToBytes([]uint{1, 2})
+	require.NoError(t, err)
+	require.Equal(t, hex.EncodeToString(rawList), entries["custom"])
+}
+
+func TestParseEnrSubnets(t *testing.T) {
+	tests := []struct {
+		name            string
+		enr             string
+		eth2            bool
+		forkDigest      string
+		nextForkVersion string
+		nextForkEpoch   common.Epoch
+		attnets         bool
+		attnetsMask     uint64
+		attnetsNumber   int
+	}{
+		{
+			name:            "mainnet prysm bootnode",
+			enr:             "enr:-Ku4QImhMc1z8yCiNJ1TyUxdcfNucje3BGwEHzodEZUan8PherEo4sF7pPHPSIB1NNuSg5fZy7qFsjmUKs2ea1Whi0EBh2F0dG5ldHOIAAAAAAAAAACEZXRoMpD1pf1CAAAAAP__________gmlkgnY0gmlwhBLf22SJc2VjcDI1NmsxoQOVphkDqal4QzPMksc5wnpuC3gvSC8AfbFOnZY_On34wIN1ZHCCIyg",
+			eth2:            true,
+			forkDigest:      "0xf5a5fd42",
+			nextForkVersion: "0x00000000",
+			nextForkEpoch:   common.Epoch(math.MaxUint64),
+			attnets:         true,
+		},
+		{
+			name:            "mainnet teku bootnode without attnets",
+			enr:             "enr:-KG4QNTx85fjxABbSq_Rta9wy56nQ1fHK0PewJbGjLm1M4bMGx5-3Qq4ZX2-iFJ0pys_O90sVXNNOxp2E7afBsGsBrgDhGV0aDKQu6TalgMAAAD__________4JpZIJ2NIJpcIQEnfA2iXNlY3AyNTZrMaECGXWQ-rQ2KZKRH1aOW4IlPDBkY4XDphxg9pxKytFCkayDdGNwgiMog3VkcIIjKA",
+			eth2:            true,
+			forkDigest:      ForkDigests[CapellaKey],
+			nextForkVersion: "0x03000000",
+			nextForkEpoch:   common.Epoch(math.MaxUint64),
+		},
+		{
+			name:            "holesky node with a scheduled fork",
+			enr:             "enr:-LS4QG0uV4qvcpJ-HFDJRGBmnlD3TJo7yc4jwK8iP7iKaTlfQ5kZvIDspLMJhk7j9KapuL9yyHaZmwTEZqr10k9XumyCEcmHYXR0bmV0c4gAAAAABgAAAIRldGgykGm32XQEAXAAAAEAAAAAAACCaWSCdjSCaXCErK4j-YlzZWNwMjU2azGhAgfWRBEJlb7gAhXIB5ePmjj2b8io0UpEenq1Kl9cxStJg3RjcIIjKIN1ZHCCIyg",
+			eth2:            true,
+			forkDigest:      "0x69b7d974",
+			nextForkVersion: "0x04017000",
+			nextForkEpoch:   256,
+			attnets:         true,
+			attnetsMask:     1<<33 | 1<<34,
+			attnetsNumber:   2,
+		},
+		{
+			name:            "holesky bootnode",
+			enr:             "enr:-LK4QPxe-mDiSOtEB_Y82ozvxn9aQM07Ui8A-vQHNgYGMMthfsfOabaaTHhhJHFCBQQVRjBww_A5bM1rf8MlkJU_l68Eh2F0dG5ldHOIAADAAAAAAACEZXRoMpBpt9l0BAFwAAABAAAAAAAAgmlkgnY0gmlwhLKAiOmJc2VjcDI1NmsxoQJu6T9pclPObAzEVQ53DpVQqjadmVxdTLL-J3h9NFoCeIN0Y3CCIyiDdWRwgiMo",
+			eth2:            true,
+			forkDigest:      "0x69b7d974",
+			nextForkVersion: "0x04017000",
+			nextForkEpoch:   256,
+			attnets:         true,
+			attnetsMask:     1<<22 | 1<<23,
+			attnetsNumber:   2,
+		},
+		{
+			name: "sepolia execution layer bootnode (plain discv5)",
+			enr:  "enr:-Iq4QMCTfIMXnow27baRUb35Q8iiFHSIDBJh6hQM5Axohhf4b6Kr_cOCu0htQ5WvVqKvFgY28893DHAg8gnBAXsAVqmGAX53x8JggmlkgnY0gmlwhLKAlv6Jc2VjcDI1NmsxoQK6S-Cii_KmfFdUJL2TANL3ksaKUnNXvTCv1tLwXs0QgIN1ZHCCIyk",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			node, err := enode.Parse(enode.ValidSchemes, test.enr)
+			require.NoError(t, err)
+			enrNode, err := ParseEnr(node)
+			require.NoError(t, err)
+
+			require.Equal(t, test.eth2, enrNode.HasEth2Data)
+			if test.eth2 {
+				require.Equal(t, test.forkDigest, enrNode.Eth2Data.ForkDigest.String())
+				require.Equal(t, test.nextForkVersion, enrNode.Eth2Data.NextForkVersion.String())
+				require.Equal(t, test.nextForkEpoch, enrNode.Eth2Data.NextForkEpoch)
+			}
+
+			mask, ok := enrNode.AttnetsMask()
+			require.Equal(t, test.attnets, ok)
+			require.Equal(t, test.attnetsMask, mask)
+			if test.attnets {
+				require.Equal(t, test.attnetsNumber, enrNode.Attnets.NetNumber)
+			} else {
+				require.Equal(t, -1, enrNode.Attnets.NetNumber)
+			}
+
+			// none of them advertises the syncnets
+			_, ok = enrNode.SyncnetsMask()
+			require.False(t, ok)
+			require.Equal(t, -1, enrNode.Syncnets.NetNumber)
+		})
+	}
+
+	// the syncnets are a 4 bits bitvector (sync committee subnets 0 and 2 here)
+	key, err := gcrypto.GenerateKey()
+	require.NoError(t, err)
+	var record enr.Record
+	record.Set(enr.IP(net.ParseIP("18.223.219.100")))
+	record.Set(enr.UDP(9000))
+	record.Set(NewAttnetsENREntry("0100000000000080"))
+	record.Set(NewSyncnetsENREntry("05"))
+	require.NoError(t, enode.SignV4(&record, key))
+	node, err := enode.New(enode.ValidSchemes, &record)
+	require.NoError(t, err)
+	enrNode, err := ParseEnr(node)
+	require.NoError(t, err)
+	require.False(t, enrNode.HasEth2Data)
+	mask, ok := enrNode.SyncnetsMask()
+	require.True(t, ok)
+	require.Equal(t, uint64(0x05), mask)
+	require.Equal(t, 2, enrNode.Syncnets.NetNumber)
+	require.Equal(t, "05", enrNode.GetSyncnetsString())
+	// the subnet 63 is the highest bit of the mask
+	mask, ok = enrNode.AttnetsMask()
+	require.True(t, ok)
+	require.Equal(t, uint64(1|1<<63), mask)
+	require.Equal(t, 2, enrNode.Attnets.NetNumber)
+}
+
+func TestEnrSeqTracker(t *testing.T) {
+	tracker := NewEnrSeqTracker()
+	enrNode := NewEnrNode(enode.ID{1})
+	otherNode := NewEnrNode(enode.ID{2})
+
+	enrNode.Seq = 5
+	require.True(t, tracker.IsNewer(enrNode))
+	// same seq is not newer, but not stale either
+	require.False(t, tracker.IsNewer(enrNode))
+	require.Equal(t, uint64(0), tracker.StaleCount())
+
+	enrNode.Seq = 3
+	require.False(t, tracker.IsNewer(enrNode))
+	require.Equal(t, uint64(1), tracker.StaleCount())
+
+	enrNode.Seq = 6
+	require.True(t, tracker.IsNewer(enrNode))
+
+	// the seqs are tracked per node
+	otherNode.Seq = 1
+	require.True(t, tracker.IsNewer(otherNode))
+	require.Equal(t, uint64(1), tracker.StaleCount())
+}
+
+// composeTestEnr returns a signed ENR advertising the given fork digest in its eth2 entry
+func composeTestEnr(t *testing.T, forkDigest string) *EnrNode {
+	key, err := gcrypto.GenerateKey()
+	require.NoError(t, err)
+	db, err := enode.OpenDB("")
+	require.NoError(t, err)
+	defer db.Close()
+	localNode := enode.NewLocalNode(db, key)
+	localNode.SetStaticIP(net.IPv4(1, 2, 3, 4))
+	localNode.Set(enr.UDP(9000))
+	// fork_digest + next_fork_version + next_fork_epoch
+	localNode.Set(NewEth2DataEntry(strings.TrimPrefix(forkDigest, "0x") + "00000000" + "ffffffffffffffff"))
+
+	enrNode, err := ParseEnr(localNode.Node())
+	require.NoError(t, err)
+	require.Equal(t, forkDigest, enrNode.Eth2Data.ForkDigest.String())
+	return enrNode
+}
+
+func TestNetworkFilter(t *testing.T) {
+	mainnetEnr := composeTestEnr(t, ForkDigests[CapellaKey])
+	gnosisEnr := composeTestEnr(t, ForkDigests[GnosisBellatrixKey])
+	// previous fork of the crawled network
+	bellatrixEnr := composeTestEnr(t, ForkDigests[BellatrixKey])
+
+	filter := NewNetworkFilter(NetworkAllowlist(ForkDigests[CapellaKey]), DropForeignNetwork, KeepEth2less)
+	require.True(t, filter.Keep(mainnetEnr))
+	require.False(t, mainnetEnr.ForeignNetwork)
+	require.True(t, filter.Keep(bellatrixEnr))
+	require.False(t, filter.Keep(gnosisEnr))
+	require.True(t, gnosisEnr.ForeignNetwork)
+	require.False(t, filter.Keep(gnosisEnr))
+	require.Equal(t, uint64(2), filter.ForeignCount())
+	require.Equal(t, map[string]uint64{ForkDigests[GnosisBellatrixKey]: 2}, filter.ForeignDigests())
+
+	// flagged instead of dropped
+	gnosisEnr.ForeignNetwork = false
+	filter = NewNetworkFilter(NetworkAllowlist(ForkDigests[CapellaKey]), FlagForeignNetwork, KeepEth2less)
+	require.True(t, filter.Keep(gnosisEnr))
+	require.True(t, gnosisEnr.ForeignNetwork)
+	require.Equal(t, uint64(1), filter.ForeignCount())
+
+	// crawling gnosis, mainnet is the foreign one
+	filter = NewNetworkFilter(NetworkAllowlist(ForkDigests[GnosisPhase0Key]), DropForeignNetwork, KeepEth2less)
+	mainnetEnr.ForeignNetwork = false
+	require.False(t, filter.Keep(mainnetEnr))
+	require.True(t, mainnetEnr.ForeignNetwork)
+
+	// an explicit allowlist only accepts its fork digests
+	filter = NewNetworkFilter([]string{"0xBBA4DA96"}, DropForeignNetwork, KeepEth2less)
+	mainnetEnr.ForeignNetwork = false
+	require.True(t, filter.Keep(mainnetEnr))
+	require.False(t, filter.Keep(bellatrixEnr))
+
+	// the plain discv5 nodes don't advertise any network, they follow their own policy
+	filter = NewNetworkFilter(NetworkAllowlist(ForkDigests[CapellaKey]), DropForeignNetwork, KeepEth2less)
+	plainEnr := NewEnrNode(enode.ID{3})
+	require.True(t, filter.Keep(plainEnr))
+	require.False(t, plainEnr.ForeignNetwork)
+	filter = NewNetworkFilter(NetworkAllowlist(ForkDigests[CapellaKey]), DropForeignNetwork, DropEth2less)
+	require.False(t, filter.Keep(plainEnr))
+	require.False(t, plainEnr.ForeignNetwork)
+	require.Equal(t, uint64(1), filter.DroppedEth2lessCount())
+	require.Equal(t, uint64(0), filter.ForeignCount())
+
+	// any network is accepted with the all fork digest (empty allowlist)
+	require.Empty(t, NetworkAllowlist(ForkDigests[AllForkDigest]))
+	filter = NewNetworkFilter(NetworkAllowlist(ForkDigests[AllForkDigest]), DropForeignNetwork, KeepEth2less)
+	gnosisEnr.ForeignNetwork = false
+	require.True(t, filter.Keep(gnosisEnr))
+	require.False(t, gnosisEnr.ForeignNetwork)
+	require.True(t, filter.Keep(plainEnr))
+	require.Equal(t, uint64(0), filter.ForeignCount())
+}
+
+func TestNetworkFilterStatus(t *testing.T) {
+	filter := NewNetworkFilter(NetworkAllowlist(ForkDigests[CapellaKey]), DropForeignNetwork, KeepEth2less)
+	var mainnet, gnosis common.Status
+	require.NoError(t, mainnet.ForkDigest.UnmarshalText([]byte(ForkDigests[CapellaKey])))
+	require.NoError(t, gnosis.ForkDigest.UnmarshalText([]byte(ForkDigests[GnosisBellatrixKey])))
+
+	require.True(t, filter.KeepStatus(mainnet))
+	require.False(t, filter.KeepStatus(gnosis))
+	require.Equal(t, map[string]uint64{ForkDigests[GnosisBellatrixKey]: 1}, filter.ForeignStatusDigests())
+	// the statuses don't count as foreign ENRs
+	require.Equal(t, uint64(0), filter.ForeignCount())
+
+	// passthrough
+	filter = NewNetworkFilter(nil, DropForeignNetwork, KeepEth2less)
+	require.True(t, filter.KeepStatus(gnosis))
+	require.Empty(t, filter.ForeignStatusDigests())
+}
+
+func TestNormalizeForkDigest(t *testing.T) {
+	forkDigest, ok := NormalizeForkDigest("BBA4DA96")
+	require.True(t, ok)
+	require.Equal(t, "0xbba4da96", forkDigest)
+	forkDigest, ok = NormalizeForkDigest("0xbba4da96")
+	require.True(t, ok)
+	require.Equal(t, "0xbba4da96", forkDigest)
+	_, ok = NormalizeForkDigest("0xbba4da")
+	require.False(t, ok)
+	_, ok = NormalizeForkDigest("mainnet1")
+	require.False(t, ok)
+}
+
+func TestParseEth2lessPolicy(t *testing.T) {
+	policy, err := ParseEth2lessPolicy("Drop")
+	require.NoError(t, err)
+	require.Equal(t, DropEth2less, policy)
+	_, err = ParseEth2lessPolicy("flag")
+	require.Error(t, err)
+}
+
+func TestParseForeignNetworkPolicy(t *testing.T) {
+	policy, err := ParseForeignNetworkPolicy("Flag")
+	require.NoError(t, err)
+	require.Equal(t, FlagForeignNetwork, policy)
+	_, err = ParseForeignNetworkPolicy("keep")
+	require.Error(t, err)
+}
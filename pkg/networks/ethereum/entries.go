@@ -11,6 +11,7 @@ import (
 )
 
 const ATTNETS_KEY = "attnets"
+const SYNCNETS_KEY = "syncnets"
 const ETH2_ENR_KEY = "eth2"
 
 // Attended networks are the networks the node will be participating in
@@ -31,6 +32,23 @@ func (aee AttnetsENREntry) ENRKey() string {
 	return ATTNETS_KEY
 }
 
+// Sync committee subnets the node will be participating in
+type SyncnetsENREntry []byte
+
+func NewSyncnetsENREntry(input_bytes string) SyncnetsENREntry {
+	result, err := hex.DecodeString(input_bytes)
+
+	if err != nil {
+		return nil
+	}
+
+	return result
+}
+
+func (see SyncnetsENREntry) ENRKey() string {
+	return SYNCNETS_KEY
+}
+
 // With this entry we allow the node to have a registered fork digest
 type Eth2ENREntry []byte
 
@@ -34,6 +34,14 @@ func (b *BeaconMetadataStamped) IsEmpty() bool {
 	return b.Timestamp.IsZero()
 }
 
+// AttnetsBitvector returns the attnets of the Metadata (nil if it's empty), see models.AttnetsAttr.
+func (b BeaconMetadataStamped) AttnetsBitvector() []byte {
+	if b.IsEmpty() {
+		return nil
+	}
+	return append([]byte{}, b.Metadata.Attnets[:]...)
+}
+
 // Basic BeaconMetadata struct that includes The timestamp of the received beacon Status
 type BeaconStatusStamped struct {
 	Timestamp time.Time
@@ -55,6 +63,26 @@ func NewBeaconStatus(peerId peer.ID, bStatus common.Status) BeaconStatusStamped
 	}
 }
 
+// ForeignNetworkAttribute is the HostInfo attribute of the peers whose BeaconStatus belongs to a different network.
+const ForeignNetworkAttribute = "foreign-network"
+
+// ForeignNetworkStatus flags a peer whose BeaconStatus has a fork digest out of the crawled network,
+// the status itself is not persisted.
+type ForeignNetworkStatus struct {
+	Timestamp  time.Time
+	PeerID     peer.ID
+	ForkDigest string
+}
+
+// NewForeignNetworkStatus returns the ForeignNetworkStatus of the given BeaconStatus.
+func NewForeignNetworkStatus(peerId peer.ID, bStatus common.Status) ForeignNetworkStatus {
+	return ForeignNetworkStatus{
+		Timestamp:  time.Now(),
+		PeerID:     peerId,
+		ForkDigest: bStatus.ForkDigest.String(),
+	}
+}
+
 // --- Parsers ----
 
 // ParseBeaconStatusFromInterfaced returns the Timestamped beaconStatus structure from a input interface
@@ -0,0 +1,166 @@
+package ethereum
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+)
+
+// ForkReadiness is the readiness of a peer for a scheduled fork.
+type ForkReadiness string
+
+const (
+	ForkReady            ForkReadiness = "ready"
+	ForkNotReady         ForkReadiness = "not-ready"
+	ForkReadinessUnknown ForkReadiness = "unknown"
+)
+
+// ScheduledFork is a hard fork announced for the crawled network.
+type ScheduledFork struct {
+	Version string       // fork version, i.e. 0x04000000
+	Epoch   common.Epoch // activation epoch
+	// time when the fork was announced, the ENRs issued before it can't advertise the fork (zero if unknown)
+	Announced time.Time
+	// lowest version of each client (by client name) that supports the fork (optional)
+	MinClientVersions map[string]string
+}
+
+// PeerForkInfo is the information of a peer that tells its readiness for a fork.
+type PeerForkInfo struct {
+	// next fork advertised in the eth2 entry of its latest ENR (empty version if we have no ENR)
+	NextForkVersion string
+	NextForkEpoch   common.Epoch
+	// time when we got its latest ENR
+	EnrTime       time.Time
+	ClientName    string
+	ClientVersion string
+}
+
+// ClassifyForkReadiness returns the readiness of the peer for the fork.
+// A peer is ready when its ENR advertises the fork at its epoch, and not ready when its ENR
+// advertises a different fork or epoch, or when it runs a client version older than the one
+// supporting the fork. The peers without an ENR, or whose ENR predates the announcement of the
+// fork, are unknown, as they couldn't advertise it yet.
+func ClassifyForkReadiness(fork ScheduledFork, info PeerForkInfo) ForkReadiness {
+	if info.NextForkVersion != "" && SameForkVersion(info.NextForkVersion, fork.Version) {
+		if info.NextForkEpoch == fork.Epoch {
+			return ForkReady
+		}
+		return ForkNotReady
+	}
+	if fork.clientUnsupported(info.ClientName, info.ClientVersion) {
+		return ForkNotReady
+	}
+	if info.NextForkVersion == "" {
+		return ForkReadinessUnknown
+	}
+	if !fork.Announced.IsZero() && (info.EnrTime.IsZero() || info.EnrTime.Before(fork.Announced)) {
+		return ForkReadinessUnknown
+	}
+	return ForkNotReady
+}
+
+// clientUnsupported returns whether the given client version is known to be older than
+// the lowest one supporting the fork.
+func (f ScheduledFork) clientUnsupported(clientName, clientVersion string) bool {
+	minVersion, ok := f.MinClientVersions[strings.ToLower(clientName)]
+	if !ok {
+		return false
+	}
+	cmp, ok := compareVersions(clientVersion, minVersion)
+	return ok && cmp < 0
+}
+
+// SameForkVersion returns whether both fork versions are the same, regardless of their case and 0x prefix.
+func SameForkVersion(a, b string) bool {
+	return normalizeForkVersion(a) == normalizeForkVersion(b)
+}
+
+// normalizeForkVersion returns the fork version in lowercase and with the 0x prefix.
+func normalizeForkVersion(version string) string {
+	version = strings.ToLower(strings.TrimSpace(version))
+	if !strings.HasPrefix(version, ForkDigestPrefix) {
+		version = ForkDigestPrefix + version
+	}
+	return version
+}
+
+// compareVersions compares the numeric major.minor.patch of two client versions (i.e. v4.5.0-rc.1),
+// returning false if any of them can't be parsed.
+func compareVersions(a, b string) (int, bool) {
+	aNums, ok := versionNumbers(a)
+	if !ok {
+		return 0, false
+	}
+	bNums, ok := versionNumbers(b)
+	if !ok {
+		return 0, false
+	}
+	for i := range aNums {
+		switch {
+		case aNums[i] < bNums[i]:
+			return -1, true
+		case aNums[i] > bNums[i]:
+			return 1, true
+		}
+	}
+	return 0, true
+}
+
+// versionNumbers parses the numeric major.minor.patch of a version (missing parts are 0).
+func versionNumbers(version string) ([3]int, bool) {
+	var nums [3]int
+	version = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(version)), "v")
+	// drop the pre-release and build suffixes
+	if idx := strings.IndexAny(version, "-+_/ "); idx >= 0 {
+		version = version[:idx]
+	}
+	parts := strings.Split(version, ".")
+	if len(parts) > len(nums) {
+		parts = parts[:len(nums)]
+	}
+	for i, part := range parts {
+		num, err := strconv.Atoi(part)
+		if err != nil {
+			return nums, false
+		}
+		nums[i] = num
+	}
+	return nums, true
+}
+
+// ForkReadinessCount is the number of peers of each readiness class.
+type ForkReadinessCount struct {
+	Ready    int
+	NotReady int
+	Unknown  int
+}
+
+// Add counts a peer of the given readiness.
+func (c *ForkReadinessCount) Add(readiness ForkReadiness) {
+	switch readiness {
+	case ForkReady:
+		c.Ready++
+	case ForkNotReady:
+		c.NotReady++
+	default:
+		c.Unknown++
+	}
+}
+
+// Total returns the number of counted peers.
+func (c ForkReadinessCount) Total() int {
+	return c.Ready + c.NotReady + c.Unknown
+}
+
+// ReadyPercentage returns the percentage of ready peers among the ones whose readiness is known,
+// or false if there are none.
+func (c ForkReadinessCount) ReadyPercentage() (float64, bool) {
+	known := c.Ready + c.NotReady
+	if known == 0 {
+		return 0, false
+	}
+	return float64(c.Ready) * 100 / float64(known), true
+}
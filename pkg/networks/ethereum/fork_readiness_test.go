@@ -0,0 +1,71 @@
+package ethereum
+
+import (
+	"testing"
+	"time"
+
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClassifyForkReadiness(t *testing.T) {
+	announced := time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)
+	fork := ScheduledFork{
+		Version:   "0x04000000",
+		Epoch:     269568,
+		Announced: announced,
+		MinClientVersions: map[string]string{
+			"lighthouse": "v4.6.0",
+		},
+	}
+	after := announced.Add(24 * time.Hour)
+	before := announced.Add(-24 * time.Hour)
+
+	tests := []struct {
+		name      string
+		info      PeerForkInfo
+		readiness ForkReadiness
+	}{
+		{"advertises the fork", PeerForkInfo{NextForkVersion: "0x04000000", NextForkEpoch: 269568, EnrTime: after}, ForkReady},
+		{"advertises the fork without prefix", PeerForkInfo{NextForkVersion: "04000000", NextForkEpoch: 269568, EnrTime: before}, ForkReady},
+		{"advertises the fork in a supported client", PeerForkInfo{NextForkVersion: "0x04000000", NextForkEpoch: 269568, EnrTime: after, ClientName: "lighthouse", ClientVersion: "v4.6.0-1a2b3c"}, ForkReady},
+		{"advertises another epoch", PeerForkInfo{NextForkVersion: "0x04000000", NextForkEpoch: 269000, EnrTime: after}, ForkNotReady},
+		{"advertises no fork after the announcement", PeerForkInfo{NextForkVersion: "0x03000000", NextForkEpoch: common.FAR_FUTURE_EPOCH, EnrTime: after}, ForkNotReady},
+		{"runs an unsupported client", PeerForkInfo{NextForkVersion: "0x03000000", NextForkEpoch: common.FAR_FUTURE_EPOCH, EnrTime: before, ClientName: "Lighthouse", ClientVersion: "v4.5.0"}, ForkNotReady},
+		{"runs an unsupported client without ENR", PeerForkInfo{ClientName: "lighthouse", ClientVersion: "v4.5.9-rc.1"}, ForkNotReady},
+		{"advertises no fork before the announcement", PeerForkInfo{NextForkVersion: "0x03000000", NextForkEpoch: common.FAR_FUTURE_EPOCH, EnrTime: before}, ForkReadinessUnknown},
+		{"supported client with an old ENR", PeerForkInfo{NextForkVersion: "0x03000000", NextForkEpoch: common.FAR_FUTURE_EPOCH, EnrTime: before, ClientName: "lighthouse", ClientVersion: "v4.6.1"}, ForkReadinessUnknown},
+		{"client without minimum version", PeerForkInfo{NextForkVersion: "0x03000000", NextForkEpoch: common.FAR_FUTURE_EPOCH, EnrTime: before, ClientName: "prysm", ClientVersion: "v1.0.0"}, ForkReadinessUnknown},
+		{"unparseable client version", PeerForkInfo{ClientName: "lighthouse", ClientVersion: "unknown"}, ForkReadinessUnknown},
+		{"no ENR", PeerForkInfo{}, ForkReadinessUnknown},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			require.Equal(t, test.readiness, ClassifyForkReadiness(fork, test.info))
+		})
+	}
+
+	// without announcement date every ENR could advertise the fork
+	fork.Announced = time.Time{}
+	require.Equal(t, ForkNotReady, ClassifyForkReadiness(fork, PeerForkInfo{
+		NextForkVersion: "0x03000000",
+		NextForkEpoch:   common.FAR_FUTURE_EPOCH,
+		EnrTime:         before,
+	}))
+}
+
+func TestForkReadinessCount(t *testing.T) {
+	var count ForkReadinessCount
+	_, ok := count.ReadyPercentage()
+	require.False(t, ok)
+
+	for _, readiness := range []ForkReadiness{ForkReady, ForkReady, ForkReady, ForkNotReady, ForkReadinessUnknown, ForkReadinessUnknown} {
+		count.Add(readiness)
+	}
+	require.Equal(t, ForkReadinessCount{Ready: 3, NotReady: 1, Unknown: 2}, count)
+	require.Equal(t, 6, count.Total())
+	// the unknown peers don't count against the readiness
+	pct, ok := count.ReadyPercentage()
+	require.True(t, ok)
+	require.Equal(t, 75.0, pct)
+}
@@ -2,6 +2,7 @@ package ethereum
 
 import (
 	"bytes"
+	"encoding/binary"
 	"fmt"
 	"time"
 
@@ -141,3 +142,43 @@ func (mh *EthMessageHandler) BeaconBlockMessageHandler(msg *pubsub.Message) (gos
 
 	return trackedBlock, nil
 }
+
+// offsets of the signature_slot in the (capella) light client updates, which is the last fixed-size field
+// right after the offsets of the headers, the finality branch (only in finality updates) and the sync aggregate
+const (
+	lightClientFinalitySlotOffset   = 4 + 4 + 6*32 + 160
+	lightClientOptimisticSlotOffset = 4 + 160
+)
+
+// LightClientUpdateMessageHandler tracks the light client finality and optimistic updates,
+// decoding only their signature slot.
+func (mh *EthMessageHandler) LightClientUpdateMessageHandler(msg *pubsub.Message) (gossipsub.PersistableMsg, error) {
+	topic := *msg.Topic
+
+	// extract the data from the raw message
+	msgBytes, err := EthMessageBaseHandler(topic, msg)
+	if err != nil {
+		return nil, err
+	}
+
+	updateType := TopicShortName(topic)
+	slotOffset := lightClientOptimisticSlotOffset
+	if updateType == LightClientFinalityUpdateTopicBase {
+		slotOffset = lightClientFinalitySlotOffset
+	}
+	if len(msgBytes) < slotOffset+8 {
+		return nil, fmt.Errorf("light client update too short (%d bytes)", len(msgBytes))
+	}
+	slot := int64(binary.LittleEndian.Uint64(msgBytes[slotOffset : slotOffset+8]))
+
+	trackedUpdate := &TrackedLightClientUpdate{
+		MsgID:       msg.ID,
+		Sender:      msg.ReceivedFrom,
+		UpdateType:  updateType,
+		ArrivalTime: msg.ArrivalTime,
+		TimeInSlot:  GetTimeInSlot(mh.genesisTime, msg.ArrivalTime, slot),
+		Slot:        slot,
+	}
+
+	return trackedUpdate, nil
+}
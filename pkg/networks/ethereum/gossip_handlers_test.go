@@ -0,0 +1,58 @@
+package ethereum
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	pb "github.com/libp2p/go-libp2p-pubsub/pb"
+	"github.com/stretchr/testify/require"
+)
+
+// composeLightClientMsg returns a gossip message of the given light client topic with the signature slot set
+func composeLightClientMsg(topicName string, slotOffset int, slot uint64) *pubsub.Message {
+	// fixed part plus some bytes of the (variable-size) headers
+	raw := make([]byte, slotOffset+8+64)
+	binary.LittleEndian.PutUint64(raw[slotOffset:], slot)
+	topic := ComposeTopic(ForkDigests[CapellaKey], topicName)
+	return &pubsub.Message{
+		Message: &pb.Message{
+			Data:  snappy.Encode(nil, raw),
+			Topic: &topic,
+		},
+		ID:          "msg-" + topicName,
+		ArrivalTime: MainnetGenesis.Add(time.Duration(slot)*SecondsPerSlot + 4*time.Second),
+	}
+}
+
+func TestLightClientUpdateMessageHandler(t *testing.T) {
+	handler, err := NewEthMessageHandler(MainnetGenesis, []string{})
+	require.NoError(t, err)
+
+	finality := composeLightClientMsg(LightClientFinalityUpdateTopicBase, lightClientFinalitySlotOffset, 6000000)
+	content, err := handler.LightClientUpdateMessageHandler(finality)
+	require.NoError(t, err)
+	update := content.(*TrackedLightClientUpdate)
+	require.Equal(t, LightClientFinalityUpdateTopicBase, update.UpdateType)
+	require.Equal(t, int64(6000000), update.GetSlot())
+	require.Equal(t, 4*time.Second, update.TimeInSlot)
+
+	optimistic := composeLightClientMsg(LightClientOptimisticUpdateTopicBase, lightClientOptimisticSlotOffset, 6000001)
+	content, err = handler.LightClientUpdateMessageHandler(optimistic)
+	require.NoError(t, err)
+	require.Equal(t, int64(6000001), content.(*TrackedLightClientUpdate).GetSlot())
+
+	// truncated updates can't be decoded
+	optimistic.Data = snappy.Encode(nil, make([]byte, lightClientOptimisticSlotOffset))
+	_, err = handler.LightClientUpdateMessageHandler(optimistic)
+	require.Error(t, err)
+}
+
+func TestIsLightClientTopic(t *testing.T) {
+	require.True(t, IsLightClientTopic(LightClientOptimisticUpdateTopicBase))
+	require.True(t, IsLightClientTopic(ComposeTopic(ForkDigests[CapellaKey], LightClientFinalityUpdateTopicBase)))
+	require.False(t, IsLightClientTopic(ComposeTopic(ForkDigests[CapellaKey], BeaconBlockTopicBase)))
+	require.Equal(t, BeaconBlockTopicBase, TopicShortName("/eth2/bba4da96/beacon_block/ssz_snappy"))
+}
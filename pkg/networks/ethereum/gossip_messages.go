@@ -30,6 +30,10 @@ func (a *TrackedAttestation) IsZero() bool {
 	return a.Slot == 0
 }
 
+func (a *TrackedAttestation) GetSlot() int64 {
+	return a.Slot
+}
+
 type TrackedBeaconBlock struct {
 	MsgID  string
 	Sender peer.ID
@@ -45,6 +49,30 @@ func (a *TrackedBeaconBlock) IsZero() bool {
 	return a.Slot == 0
 }
 
+func (a *TrackedBeaconBlock) GetSlot() int64 {
+	return a.Slot
+}
+
+// TrackedLightClientUpdate is a light client finality or optimistic update received through gossip.
+type TrackedLightClientUpdate struct {
+	MsgID      string
+	Sender     peer.ID
+	UpdateType string // short name of the topic
+
+	ArrivalTime time.Time     // time of arrival
+	TimeInSlot  time.Duration // time since the start of the signature slot
+
+	Slot int64 // signature slot of the update
+}
+
+func (u *TrackedLightClientUpdate) IsZero() bool {
+	return u.Slot == 0
+}
+
+func (u *TrackedLightClientUpdate) GetSlot() int64 {
+	return u.Slot
+}
+
 func GetSubnetFromTopic(topic string) (int, error) {
 	re := regexp.MustCompile(`attestation_([0-9]+)`)
 	match := re.FindAllString(topic, -1)
@@ -58,21 +58,46 @@ var (
 		DenebCancunKey: "0xee7b3a32",
 	}
 
+	// networks of the known fork digests
+	MainnetNetwork string = "mainnet"
+	GnosisNetwork  string = "gnosis"
+	PraterNetwork  string = "prater"
+	SepoliaNetwork string = "sepolia"
+	HoleskyNetwork string = "holesky"
+
+	NetworkForkDigestKeys = map[string][]string{
+		MainnetNetwork: {Phase0Key, AltairKey, BellatrixKey, CapellaKey},
+		GnosisNetwork:  {GnosisPhase0Key, GnosisAltairKey, GnosisBellatrixKey},
+		PraterNetwork:  {PraterPhase0Key, PraterBellatrixKey, PraterCapellaKey},
+		SepoliaNetwork: {SepoliaCapellaKey},
+		HoleskyNetwork: {HoleskyCapellaKey},
+	}
+
 	MessageTypes = []string{
 		BeaconBlockTopicBase,
 		BeaconAggregateAndProofTopicBase,
 		VoluntaryExitTopicBase,
 		ProposerSlashingTopicBase,
 		AttesterSlashingTopicBase,
+		LightClientFinalityUpdateTopicBase,
+		LightClientOptimisticUpdateTopicBase,
+	}
+
+	// topics of the light client updates
+	LightClientTopics = []string{
+		LightClientFinalityUpdateTopicBase,
+		LightClientOptimisticUpdateTopicBase,
 	}
 
-	BeaconBlockTopicBase             string = "beacon_block"
-	BeaconAggregateAndProofTopicBase string = "beacon_aggregate_and_proof"
-	VoluntaryExitTopicBase           string = "voluntary_exit"
-	ProposerSlashingTopicBase        string = "proposer_slashing"
-	AttesterSlashingTopicBase        string = "attester_slashing"
-	AttestationTopicBase             string = "beacon_attestation_{__subnet_id__}"
-	SubnetLimit                             = 64
+	BeaconBlockTopicBase                 string = "beacon_block"
+	BeaconAggregateAndProofTopicBase     string = "beacon_aggregate_and_proof"
+	VoluntaryExitTopicBase               string = "voluntary_exit"
+	ProposerSlashingTopicBase            string = "proposer_slashing"
+	AttesterSlashingTopicBase            string = "attester_slashing"
+	AttestationTopicBase                 string = "beacon_attestation_{__subnet_id__}"
+	LightClientFinalityUpdateTopicBase   string = "light_client_finality_update"
+	LightClientOptimisticUpdateTopicBase string = "light_client_optimistic_update"
+	SubnetLimit                                 = 64
 
 	Encoding string = "ssz_snappy"
 )
@@ -168,3 +193,75 @@ func CheckValidForkDigest(inStr string) (string, bool) {
 	}
 	return inStr, true
 }
+
+// ForkDigestNetwork returns the network that the given fork digest belongs to, and false if it's not a known one.
+func ForkDigestNetwork(forkDigest string) (string, bool) {
+	forkDigest = strings.ToLower(forkDigest)
+	for network, keys := range NetworkForkDigestKeys {
+		for _, key := range keys {
+			if digest, ok := ForkDigests[key]; ok && digest == forkDigest {
+				return network, true
+			}
+		}
+	}
+	return "", false
+}
+
+// NetworkForkDigests returns all the known fork digests of the network that the given fork digest belongs to.
+// An unknown fork digest is returned as the only digest of its network.
+func NetworkForkDigests(forkDigest string) []string {
+	network, ok := ForkDigestNetwork(forkDigest)
+	if !ok {
+		return []string{strings.ToLower(forkDigest)}
+	}
+	digests := make([]string, 0, len(NetworkForkDigestKeys[network]))
+	for _, key := range NetworkForkDigestKeys[network] {
+		if digest, ok := ForkDigests[key]; ok {
+			digests = append(digests, digest)
+		}
+	}
+	return digests
+}
+
+// NormalizeForkDigest returns the given fork digest as lowercase hex with the ForkDigestPrefix,
+// and false if it isn't a valid fork digest.
+func NormalizeForkDigest(forkDigest string) (string, bool) {
+	forkDigest = strings.TrimPrefix(strings.ToLower(forkDigest), ForkDigestPrefix)
+	if len(forkDigest) != ForkDigestSize {
+		return "", false
+	}
+	if _, err := hex.DecodeString(forkDigest); err != nil {
+		return "", false
+	}
+	return ForkDigestPrefix + forkDigest, true
+}
+
+// NetworkAllowlist returns the fork digests that a NetworkFilter has to allow to crawl the network of the
+// given fork digest, none of them (any fork digest) if it's the "all" one.
+func NetworkAllowlist(forkDigest string) []string {
+	if strings.ToLower(forkDigest) == ForkDigests[AllForkDigest] {
+		return nil
+	}
+	return NetworkForkDigests(forkDigest)
+}
+
+// TopicShortName returns the short name of the given topic, which can be already a short name
+// (i.e. "light_client_finality_update" out of "/eth2/bba4da96/light_client_finality_update/ssz_snappy").
+func TopicShortName(topic string) string {
+	parts := strings.Split(topic, "/")
+	if len(parts) >= 4 && parts[1] == BlockchainName {
+		return parts[3]
+	}
+	return topic
+}
+
+// IsLightClientTopic returns whether the given topic (short or full name) carries light client updates.
+func IsLightClientTopic(topic string) bool {
+	short := TopicShortName(topic)
+	for _, lcTopic := range LightClientTopics {
+		if short == lcTopic {
+			return true
+		}
+	}
+	return false
+}
@@ -48,7 +48,7 @@ func (handleChunk ResponseChunkHandler) MakeResponseHandler(maxChunkCount uint64
 			if err != nil {
 				return err
 			}
-			if resByte == byte(InvalidReqCode) || resByte == byte(ServerErrCode) {
+			if ResponseCode(resByte).IsErrorCode() {
 				if chunkSize > MaxErrSize {
 					return fmt.Errorf("chunk size %d of chunk %d exceeds error size limit %d", chunkSize, chunkIndex, MaxErrSize)
 				}
@@ -2,7 +2,9 @@ package reqresp
 
 import (
 	"bytes"
+	"context"
 	"encoding/hex"
+	"io"
 	"testing"
 )
 
@@ -61,3 +63,36 @@ func TestStreamHeaderAndPayload(t *testing.T) {
 		t.Error("unexpected encoding output")
 	}
 }
+
+func TestResponseHandlerErrorChunk(t *testing.T) {
+	// result byte, size and message of a resource unavailable response
+	msg := []byte("rate limited")
+	var input bytes.Buffer
+	input.WriteByte(byte(ResourceUnavailableCode))
+	input.WriteByte(byte(len(msg)))
+	input.Write(msg)
+
+	var gotCode ResponseCode
+	var gotMsg bytes.Buffer
+	handler := ResponseChunkHandler(func(ctx context.Context, chunkIndex uint64, chunkSize uint64, result ResponseCode, r io.Reader, w io.Writer) error {
+		gotCode = result
+		_, err := gotMsg.ReadFrom(io.LimitReader(r, int64(chunkSize)))
+		return err
+	}).MakeResponseHandler(1, 1024, nil)
+
+	err := handler(context.Background(), &input, nopWriteCloser{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !gotCode.IsErrorCode() || gotCode != ResourceUnavailableCode {
+		t.Errorf("unexpected result code %d", gotCode)
+	}
+	if gotMsg.String() != string(msg) {
+		t.Errorf("unexpected error message %q", gotMsg.String())
+	}
+}
+
+type nopWriteCloser struct{}
+
+func (nopWriteCloser) Write(p []byte) (int, error) { return len(p), nil }
+func (nopWriteCloser) Close() error                { return nil }
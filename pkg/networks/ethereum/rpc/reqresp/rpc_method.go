@@ -99,8 +99,24 @@ const (
 	SuccessCode ResponseCode = iota
 	InvalidReqCode
 	ServerErrCode
+	ResourceUnavailableCode
 )
 
+// IsErrorCode returns whether the response code is one of the error codes, which carry an error message.
+func (c ResponseCode) IsErrorCode() bool {
+	return c == InvalidReqCode || c == ServerErrCode || c == ResourceUnavailableCode
+}
+
+// ResponseError is the error response of a remote peer to one of our requests.
+type ResponseError struct {
+	Code ResponseCode
+	Msg  string
+}
+
+func (e *ResponseError) Error() string {
+	return fmt.Sprintf("error response code %d: %s", e.Code, e.Msg)
+}
+
 // MaxErrSize holds maximum err size
 const MaxErrSize = 256
 
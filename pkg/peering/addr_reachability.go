@@ -0,0 +1,53 @@
+package peering
+
+import (
+	"time"
+
+	"github.com/migalabs/armiarma/pkg/db/models"
+	"github.com/migalabs/armiarma/pkg/hosts"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// composeAddrDials returns the outcome of a dial to each of the given addresses, from what the host
+// reported: the error of the Connect (if it failed) and the addresses of the established
+// connections (if it succeeded). The addresses that the host didn't report on are left as not attempted.
+func composeAddrDials(addrs []ma.Multiaddr, connErr error, connAddrs []ma.Multiaddr, duration time.Duration) []models.AddrDial {
+	addrErrs := hosts.ParseAddrConErrors(connErr)
+	dials := make([]models.AddrDial, 0, len(addrs))
+	for _, addr := range addrs {
+		dial := models.AddrDial{Addr: addr}
+		if containsAddr(connAddrs, addr) {
+			dial.Attempted = true
+			dial.Success = true
+			dial.Error = hosts.NoConnError
+			dial.Duration = duration
+		} else if addrErr, ok := addrErrs[addr.String()]; ok {
+			dial.Attempted = true
+			dial.Error = addrErr
+			dial.Duration = duration
+		}
+		dials = append(dials, dial)
+	}
+	// the connection could have been established through an address we didn't give (i.e. already in the peerstore)
+	for _, connAddr := range connAddrs {
+		if !containsAddr(addrs, connAddr) {
+			dials = append(dials, models.AddrDial{
+				Addr:      connAddr,
+				Attempted: true,
+				Success:   true,
+				Error:     hosts.NoConnError,
+				Duration:  duration,
+			})
+		}
+	}
+	return dials
+}
+
+func containsAddr(addrs []ma.Multiaddr, addr ma.Multiaddr) bool {
+	for _, a := range addrs {
+		if a.Equal(addr) {
+			return true
+		}
+	}
+	return false
+}
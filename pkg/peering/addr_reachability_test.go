@@ -0,0 +1,53 @@
+package peering
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	swarm "github.com/libp2p/go-libp2p-swarm"
+	"github.com/migalabs/armiarma/pkg/db/models"
+	"github.com/migalabs/armiarma/pkg/hosts"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ComposeAddrDials(t *testing.T) {
+	tcpAddr := ma.StringCast("/ip4/95.217.33.10/tcp/9000")
+	quicAddr := ma.StringCast("/ip4/95.217.33.10/udp/9001/quic")
+	ip6Addr := ma.StringCast("/ip6/2a01:4f8::1/tcp/9000")
+	addrs := []ma.Multiaddr{tcpAddr, quicAddr, ip6Addr}
+
+	// failed dial: only the reported addresses were attempted
+	connErr := &swarm.DialError{
+		Cause: errors.New("all dials failed"),
+		DialErrors: []swarm.TransportError{
+			{Address: tcpAddr, Cause: errors.New("dial tcp4 95.217.33.10:9000: connect: connection refused")},
+			{Address: quicAddr, Cause: errors.New("timeout: handshake did not complete in time")},
+		},
+	}
+	dials := composeAddrDials(addrs, connErr, nil, time.Second)
+	require.Equal(t, []models.AddrDial{
+		{Addr: tcpAddr, Attempted: true, Error: hosts.DialErrorConnectionRefused, Duration: time.Second},
+		{Addr: quicAddr, Attempted: true, Error: hosts.DialErrorQuicHandshakeTimeout, Duration: time.Second},
+		{Addr: ip6Addr},
+	}, dials)
+	require.Equal(t, models.TransportTCP, dials[0].Transport())
+	require.Equal(t, models.TransportQUIC, dials[1].Transport())
+
+	// successful dial: the address of the connection succeeded, the others weren't reported
+	dials = composeAddrDials(addrs, nil, []ma.Multiaddr{ma.StringCast("/ip4/95.217.33.10/udp/9001/quic")}, time.Second)
+	require.Equal(t, []models.AddrDial{
+		{Addr: tcpAddr},
+		{Addr: quicAddr, Attempted: true, Success: true, Error: hosts.NoConnError, Duration: time.Second},
+		{Addr: ip6Addr},
+	}, dials)
+
+	// connection through an address that wasn't given
+	otherAddr := ma.StringCast("/ip4/1.2.3.4/tcp/4001")
+	dials = composeAddrDials(addrs[:1], nil, []ma.Multiaddr{otherAddr}, time.Second)
+	require.Len(t, dials, 2)
+	require.False(t, dials[0].Attempted)
+	require.True(t, dials[1].Success)
+	require.True(t, dials[1].Addr.Equal(otherAddr))
+}
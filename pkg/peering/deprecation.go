@@ -0,0 +1,80 @@
+package peering
+
+import (
+	"time"
+
+	"github.com/migalabs/armiarma/pkg/db/models"
+	"github.com/migalabs/armiarma/pkg/metrics"
+	log "github.com/sirupsen/logrus"
+)
+
+// DeprecationPolicy defines which peers get periodically deprecated by the PruningStrategy: the ones
+// that are not connected, showed no activity for the InactiveWindow, and whose last MinFailures
+// connection attempts failed. The deprecated peers leave the dial queue until they get rediscovered.
+type DeprecationPolicy struct {
+	Interval       time.Duration // time between the deprecation rounds (0 disables them)
+	InactiveWindow time.Duration // time without activity to deprecate a peer
+	MinFailures    int           // minimum number of consecutive failed attempts to deprecate a peer
+}
+
+// SetDeprecationPolicy sets the policy of the deprecation routine, that has to be set before Run.
+func (c *PruningStrategy) SetDeprecationPolicy(policy DeprecationPolicy) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.deprecation = policy
+}
+
+// deprecationRoutine deprecates the stale peers of the PeerStore every policy.Interval.
+func (c *PruningStrategy) deprecationRoutine(policy DeprecationPolicy) {
+	logEntry := log.WithFields(log.Fields{
+		"mod": "prun-deprecation",
+	})
+	logEntry.Debug("init")
+
+	ticker := time.NewTicker(policy.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			start := time.Now()
+			deprecated := c.deprecatePeers(policy, start)
+			logEntry.WithFields(log.Fields{
+				"deprecated": deprecated,
+				"duration":   time.Since(start),
+			}).Debug("deprecated stale peers")
+
+		case <-c.ctx.Done():
+			logEntry.Debug("closing deprecation routine")
+			return
+		}
+	}
+}
+
+// deprecatePeers deprecates the peers of the PeerStore that the policy allows to at now, removing them
+// from the PeerQueue and persisting their deprecation. Returns the number of deprecated peers.
+func (c *PruningStrategy) deprecatePeers(policy DeprecationPolicy, now time.Time) int {
+	inactiveSince := now.Add(-policy.InactiveWindow)
+
+	candidates := make([]*metrics.Peer, 0)
+	c.PeerStore.ForEachPeer(func(p *metrics.Peer) bool {
+		if p.Deprecable(inactiveSince, policy.MinFailures) {
+			candidates = append(candidates, p)
+		}
+		return true
+	})
+
+	for _, p := range candidates {
+		log.Debugf("deprecating stale peer %s", p.ID.String())
+		c.deprecatePeer(models.NewDeprecation(p.ID, inactiveSince))
+	}
+	return len(candidates)
+}
+
+// deprecatePeer flags the peer as deprecated in the PeerStore and in the DB, and removes it from the PeerQueue.
+func (c *PruningStrategy) deprecatePeer(deprecation models.Deprecation) {
+	c.PeerStore.GetOrCreatePeer(deprecation.PeerID).DeprecationEvent()
+	c.PeerQueue.RemovePeer(deprecation.PeerID)
+	if err := c.DBClient.PersistToDB(&deprecation); err != nil {
+		log.Error(err)
+	}
+}
@@ -0,0 +1,140 @@
+package peering
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/migalabs/armiarma/pkg/db/memory"
+	"github.com/migalabs/armiarma/pkg/db/models"
+	"github.com/migalabs/armiarma/pkg/hosts"
+	"github.com/migalabs/armiarma/pkg/metrics"
+	eth "github.com/migalabs/armiarma/pkg/networks/ethereum"
+	"github.com/migalabs/armiarma/pkg/utils"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestStrategy(ctx context.Context, t *testing.T) (*PruningStrategy, *memory.DB, *metrics.PeerStore) {
+	memDB := memory.NewDB()
+	store := metrics.NewPeerStore()
+	strategy, err := NewPruningStrategy(ctx, utils.EthereumNetwork, memDB, store)
+	require.NoError(t, err)
+	return strategy, memDB, store
+}
+
+func Test_DeprecatePeersRediscovery(t *testing.T) {
+	strategy, memDB, store := newTestStrategy(context.Background(), t)
+	t0 := time.Now().Add(-24 * time.Hour)
+	policy := DeprecationPolicy{InactiveWindow: time.Hour, MinFailures: 3}
+
+	stale := peer.ID("stale-peer")
+	alive := peer.ID("alive-peer")
+	staleInfo := models.NewHostInfo(stale, utils.EthereumNetwork, models.WithIPAndPorts("18.223.219.100", 9000))
+	require.NoError(t, memDB.PersistHostInfo(staleInfo))
+	require.NoError(t, memDB.PersistHostInfo(models.NewHostInfo(alive, utils.EthereumNetwork, models.WithIPAndPorts("18.223.219.101", 9000))))
+	require.NoError(t, strategy.PeerQueue.UpdatePeerListFromRemoteDB())
+	require.Equal(t, 2, strategy.PeerQueue.Len())
+
+	// the stale peer was only seen a day ago, the alive one keeps failing but is connected now
+	staleMetrics := store.GetOrCreatePeer(stale)
+	staleMetrics.ConnectionEvent(t0)
+	staleMetrics.DisconnectionEvent(t0.Add(time.Minute))
+	aliveMetrics := store.GetOrCreatePeer(alive)
+	for i := 0; i < policy.MinFailures; i++ {
+		staleMetrics.ConnectionAttemptEvent(false, hosts.DialErrorIoTimeout)
+		aliveMetrics.ConnectionAttemptEvent(false, hosts.DialErrorIoTimeout)
+	}
+	aliveMetrics.ConnectionEvent(time.Now())
+
+	require.Equal(t, 1, strategy.deprecatePeers(policy, time.Now()))
+	require.True(t, staleMetrics.IsDeprecated())
+	require.False(t, aliveMetrics.IsDeprecated())
+	require.False(t, strategy.PeerQueue.IsPeerAlready(stale))
+	hInfo, err := memDB.GetHostInfo(stale)
+	require.NoError(t, err)
+	require.True(t, hInfo.ControlInfo.Deprecated)
+
+	// the deprecated peer doesn't come back with the next refresh of the queue
+	require.NoError(t, strategy.PeerQueue.UpdatePeerListFromRemoteDB())
+	require.False(t, strategy.PeerQueue.IsPeerAlready(stale))
+	require.Equal(t, 0, strategy.deprecatePeers(policy, time.Now()))
+
+	// the discovery finds it again, as the discovery service does
+	require.NoError(t, memDB.PersistHostInfo(staleInfo))
+	staleMetrics.FetchHostInfo(staleInfo)
+	require.False(t, staleMetrics.IsDeprecated())
+	require.NoError(t, strategy.PeerQueue.UpdatePeerListFromRemoteDB())
+	require.True(t, strategy.PeerQueue.IsPeerAlready(stale))
+
+	// it gets dialed before being deprecated again
+	require.Equal(t, 0, strategy.deprecatePeers(policy, time.Now()))
+	staleMetrics.ConnectionAttemptEvent(false, hosts.DialErrorIoTimeout)
+	require.Equal(t, 1, strategy.deprecatePeers(policy, time.Now()))
+	require.False(t, strategy.PeerQueue.IsPeerAlready(stale))
+}
+
+func Test_DeprecatedPeersNeverDialed(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	strategy, memDB, store := newTestStrategy(ctx, t)
+
+	deprecated := peer.ID("deprecated-peer")
+	dialable := peer.ID("dialable-peer")
+	require.NoError(t, memDB.PersistHostInfo(models.NewHostInfo(deprecated, utils.EthereumNetwork, models.WithIPAndPorts("18.223.219.102", 9000))))
+	require.NoError(t, memDB.PersistHostInfo(models.NewHostInfo(dialable, utils.EthereumNetwork, models.WithIPAndPorts("18.223.219.103", 9000))))
+	// deprecated in the peer store after the queue was filled (i.e. by a concurrent deprecation)
+	store.GetOrCreatePeer(deprecated).DeprecationEvent()
+
+	peerStream := strategy.Run()
+	for i := 0; i < 2; i++ {
+		strategy.NextPeer()
+	}
+	select {
+	case hInfo := <-peerStream:
+		require.Equal(t, dialable, hInfo.ID)
+	case <-time.After(MinIterTime):
+		t.Fatal("no peer reached the dialer")
+	}
+	select {
+	case hInfo := <-peerStream:
+		t.Fatalf("peer %s reached the dialer", hInfo.ID.String())
+	case <-time.After(time.Second):
+	}
+	require.False(t, strategy.PeerQueue.IsPeerAlready(deprecated))
+}
+
+func Test_DeprecateForeignNetworkPeers(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	strategy, memDB, store := newTestStrategy(ctx, t)
+
+	foreign := peer.ID("foreign-peer")
+	hInfo := models.NewHostInfo(foreign, utils.EthereumNetwork, models.WithIPAndPorts("18.223.219.104", 9000))
+	require.NoError(t, memDB.PersistHostInfo(hInfo))
+	require.NoError(t, strategy.PeerQueue.UpdatePeerListFromRemoteDB())
+	require.True(t, strategy.PeerQueue.IsPeerAlready(foreign))
+	// it was active right now, it doesn't matter for the foreign peers
+	store.GetOrCreatePeer(foreign).ConnectionEvent(time.Now())
+
+	strategy.Run()
+	var status common.Status
+	require.NoError(t, status.ForkDigest.UnmarshalText([]byte(eth.ForkDigests[eth.GnosisBellatrixKey])))
+	identified := models.NewHostInfo(foreign, utils.EthereumNetwork, models.WithIPAndPorts("18.223.219.104", 9000))
+	identified.AddAtt(eth.ForeignNetworkAttribute, eth.NewForeignNetworkStatus(foreign, status))
+	strategy.NewIdentificationEvent(hosts.IdentificationEvent{
+		HostInfo:  identified,
+		Timestamp: time.Now(),
+	})
+
+	require.Eventually(t, func() bool {
+		return !strategy.PeerQueue.IsPeerAlready(foreign)
+	}, time.Second, 10*time.Millisecond)
+	require.True(t, store.GetOrCreatePeer(foreign).IsDeprecated())
+	require.Eventually(t, func() bool {
+		stored, err := memDB.GetHostInfo(foreign)
+		require.NoError(t, err)
+		return stored.ControlInfo.Deprecated
+	}, time.Second, 10*time.Millisecond)
+}
@@ -1,6 +1,9 @@
 package peering
 
 import (
+	"time"
+
+	"github.com/migalabs/armiarma/pkg/hosts"
 	"github.com/migalabs/armiarma/pkg/metrics"
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
@@ -47,8 +50,65 @@ var (
 	},
 		[]string{"error_type"},
 	)
+	DialLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "peering",
+		Name:      "dial_duration_seconds",
+		Help:      "Time spent dialing remote peers, labelled by the outcome of the attempt",
+		Buckets:   DialLatencyBuckets,
+	},
+		[]string{"outcome"},
+	)
+	DialAttempts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "peering",
+		Name:      "dial_attempts_total",
+		Help:      "Number of dial attempts made by the peering service, labelled by their outcome",
+	},
+		[]string{"outcome"},
+	)
+)
+
+// Buckets (in secs) for the dial latency histogram, from 10ms up to 30s
+var DialLatencyBuckets = []float64{0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 20, 30}
+
+// Outcomes in which the dial attempts are classified
+const (
+	DialOutcomeSuccess   = "success"
+	DialOutcomeTimeout   = "timeout"
+	DialOutcomeRefused   = "refused"
+	DialOutcomeQuic      = "quic"
+	DialOutcomeHandshake = "security_handshake"
+	DialOutcomeOther     = "other"
 )
 
+// DialOutcome translates the parsed connection error of an attempt
+// into one of the outcome labels of the dial metrics.
+func DialOutcome(connErr string) string {
+	switch connErr {
+	case hosts.NoConnError:
+		return DialOutcomeSuccess
+	case hosts.DialErrorIoTimeout, hosts.DialErrorContextDeadlineExceeded:
+		return DialOutcomeTimeout
+	case hosts.DialErrorConnectionRefused:
+		return DialOutcomeRefused
+	default:
+		if hosts.IsQuicError(connErr) {
+			return DialOutcomeQuic
+		}
+		if hosts.IsSecurityHandshakeError(connErr) {
+			return DialOutcomeHandshake
+		}
+		return DialOutcomeOther
+	}
+}
+
+// ObserveDialAttempt records the measured duration of a dial attempt
+// together with its outcome.
+func ObserveDialAttempt(connErr string, dialTime time.Duration) {
+	outcome := DialOutcome(connErr)
+	DialLatency.WithLabelValues(outcome).Observe(dialTime.Seconds())
+	DialAttempts.WithLabelValues(outcome).Inc()
+}
+
 // ServeMetrics:
 // This method will serve the global peerstore values to the
 // local prometheus instance.
@@ -66,6 +126,7 @@ func (p *PeeringService) GetMetrics() *metrics.MetricsModule {
 	metricsMod.AddIndvMetric(p.getPeerstoreIterTime())
 	metricsMod.AddIndvMetric(p.getConnErrorDistribution())
 	metricsMod.AddIndvMetric(p.getTotalConnErrorDistribution())
+	metricsMod.AddIndvMetric(p.getDialLatency())
 
 	return metricsMod
 
@@ -240,3 +301,29 @@ func (p *PeeringService) getTotalConnErrorDistribution() *metrics.IndvMetrics {
 
 	return IndvMetr
 }
+
+func (p *PeeringService) getDialLatency() *metrics.IndvMetrics {
+
+	initFn := func() error {
+		prometheus.MustRegister(DialLatency)
+		prometheus.MustRegister(DialAttempts)
+		return nil
+	}
+
+	// both metrics are fed directly from the peering workers,
+	// nothing needs to be refreshed on each metrics loop
+	updateFn := func() (interface{}, error) {
+		return nil, nil
+	}
+	IndvMetr, err := metrics.NewIndvMetrics(
+		"dial_duration_seconds",
+		initFn,
+		updateFn,
+	)
+	if err != nil {
+		log.Error(errors.Wrap(err, "unable to init dial_duration_seconds"))
+		return nil
+	}
+
+	return IndvMetr
+}
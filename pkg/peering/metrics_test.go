@@ -0,0 +1,80 @@
+package peering
+
+import (
+	"testing"
+	"time"
+
+	"github.com/migalabs/armiarma/pkg/hosts"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_DialLatencyMetrics(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(DialLatency, DialAttempts)
+
+	// fake attempts
+	ObserveDialAttempt(hosts.NoConnError, 5*time.Millisecond)
+	ObserveDialAttempt(hosts.NoConnError, 300*time.Millisecond)
+	ObserveDialAttempt(hosts.DialErrorIoTimeout, 20*time.Second)
+	ObserveDialAttempt(hosts.DialErrorContextDeadlineExceeded, 40*time.Second)
+	ObserveDialAttempt(hosts.DialErrorConnectionRefused, 50*time.Millisecond)
+	ObserveDialAttempt(hosts.DialErrorPeerIDMismatch, 1*time.Second)
+
+	families, err := reg.Gather()
+	require.NoError(t, err)
+
+	histograms := make(map[string]*dto.Histogram)
+	counters := make(map[string]float64)
+	for _, fam := range families {
+		for _, m := range fam.GetMetric() {
+			outcome := m.GetLabel()[0].GetValue()
+			switch fam.GetName() {
+			case "peering_dial_duration_seconds":
+				histograms[outcome] = m.GetHistogram()
+			case "peering_dial_attempts_total":
+				counters[outcome] = m.GetCounter().GetValue()
+			}
+		}
+	}
+
+	require.Equal(t, float64(2), counters[DialOutcomeSuccess])
+	require.Equal(t, float64(2), counters[DialOutcomeTimeout])
+	require.Equal(t, float64(1), counters[DialOutcomeRefused])
+	require.Equal(t, float64(1), counters[DialOutcomeOther])
+
+	// cumulative bucket counts for the successful dials
+	success := histograms[DialOutcomeSuccess]
+	require.Equal(t, uint64(2), success.GetSampleCount())
+	require.Equal(t, len(DialLatencyBuckets), len(success.GetBucket()))
+	require.Equal(t, uint64(1), bucketCount(success, 0.01))
+	require.Equal(t, uint64(1), bucketCount(success, 0.25))
+	require.Equal(t, uint64(2), bucketCount(success, 0.5))
+
+	// the 40s timeout only falls into the implicit +Inf bucket
+	timeout := histograms[DialOutcomeTimeout]
+	require.Equal(t, uint64(2), timeout.GetSampleCount())
+	require.Equal(t, uint64(1), bucketCount(timeout, 30))
+
+	refused := histograms[DialOutcomeRefused]
+	require.Equal(t, uint64(0), bucketCount(refused, 0.025))
+	require.Equal(t, uint64(1), bucketCount(refused, 0.05))
+}
+
+func bucketCount(h *dto.Histogram, upperBound float64) uint64 {
+	for _, b := range h.GetBucket() {
+		if b.GetUpperBound() == upperBound {
+			return b.GetCumulativeCount()
+		}
+	}
+	return 0
+}
+
+func Test_DialOutcomeCategories(t *testing.T) {
+	require.Equal(t, DialOutcomeQuic, DialOutcome(hosts.DialErrorQuicCryptoError))
+	require.Equal(t, DialOutcomeQuic, DialOutcome(hosts.DialErrorQuicIdleTimeout))
+	require.Equal(t, DialOutcomeHandshake, DialOutcome(hosts.DialErrorNoiseHandshake))
+	require.Equal(t, DialOutcomeHandshake, DialOutcome(hosts.DialErrorSecurityProtocolNegotiation))
+	require.Equal(t, DialOutcomeOther, DialOutcome(hosts.DialErrorPeerIDMismatch))
+}
@@ -12,9 +12,11 @@ import (
 	"sync"
 	"time"
 
+	"github.com/migalabs/armiarma/pkg/db"
 	"github.com/migalabs/armiarma/pkg/db/models"
-	psql "github.com/migalabs/armiarma/pkg/db/postgresql"
 	"github.com/migalabs/armiarma/pkg/hosts"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -32,7 +34,7 @@ type PeeringService struct {
 	ctx context.Context
 
 	host     *hosts.BasicLibp2pHost
-	DBClient *psql.DBClient
+	DBClient db.Persister
 	strategy PeeringStrategy
 	// Control Flags
 	Timeout    time.Duration
@@ -47,7 +49,7 @@ type PeeringService struct {
 func NewPeeringService(
 	ctx context.Context,
 	h *hosts.BasicLibp2pHost,
-	dbClient *psql.DBClient,
+	dbClient db.Persister,
 	opts ...PeeringOption) (PeeringService, error) {
 
 	pServ := PeeringService{
@@ -145,22 +147,31 @@ func (c *PeeringService) peeringWorker(workerID string, peerStreamChan chan *mod
 			// try to connect the peer
 			logEntry.Debugf("%s addrs %s attempting connection to peer", workerID, addrInfo.Addrs)
 			attempts := 0
+			// outcome of the last attempt, to report the reachability of each address
+			var connErr error
+			var dialDuration time.Duration
 			timeoutctx, cancel := context.WithTimeout(c.ctx, c.Timeout)
 			for attempts < c.MaxRetries {
-				if err := h.Connect(timeoutctx, addrInfo); err != nil { // there was an error
-					logEntry.WithError(err).Debugf("%s attempts %d failed connection attempt to %+v",
+				dialStart := time.Now()
+				connErr = h.Connect(timeoutctx, addrInfo)
+				dialDuration = time.Since(dialStart)
+				if connErr != nil { // there was an error
+					logEntry.WithError(connErr).Debugf("%s attempts %d failed connection attempt to %+v",
 						workerID, attempts+1, addrInfo)
-					attError = hosts.ParseConError(err)
+					attError = hosts.ParseConError(connErr)
+					ObserveDialAttempt(attError, dialDuration)
 					attempts++
 					continue
 				} else { // connection successfuly made
 					logEntry.Debugf("successful connection to %s", nextPeer.ID.String())
 					attStatus = models.PossitiveAttempt
 					attError = hosts.NoConnError
+					ObserveDialAttempt(attError, dialDuration)
 					break
 				}
 			}
 			cancel()
+			c.persistAddrReachability(nextPeer, addrInfo.Addrs, connErr, dialDuration)
 
 			// generate the connectionAttempt
 			connAttempt := models.NewConnAttempt(
@@ -185,6 +196,27 @@ func (c *PeeringService) peeringWorker(workerID string, peerStreamChan chan *mod
 
 }
 
+// persistAddrReachability attaches the outcome of the dial to each address of the peer to a HostInfo,
+// and persists it into the DB.
+func (c *PeeringService) persistAddrReachability(hInfo *models.HostInfo, addrs []ma.Multiaddr, connErr error, duration time.Duration) {
+	connAddrs := make([]ma.Multiaddr, 0)
+	if connErr == nil {
+		for _, conn := range c.host.Host().Network().ConnsToPeer(hInfo.ID) {
+			connAddrs = append(connAddrs, conn.RemoteMultiaddr())
+		}
+	}
+	dials := composeAddrDials(addrs, connErr, connAddrs, duration)
+	if len(dials) == 0 || c.DBClient == nil {
+		return
+	}
+	dialInfo := models.NewHostInfo(hInfo.ID, hInfo.Network)
+	dialInfo.AddAtt(models.AddrReachabilityAttribute, models.NewAddrReachability(hInfo.ID, dials))
+	err := c.DBClient.PersistHostInfo(dialInfo)
+	if err != nil {
+		log.Error(errors.Wrap(err, "unable to persist the reachability of the addresses of "+hInfo.ID.String()))
+	}
+}
+
 // eventRecorderRoutine:
 // The event selector records the status of any incoming connection and disconnection and
 // notifies the strategy of any recorded conn/disconn.
@@ -6,9 +6,11 @@ import (
 	"sync"
 	"time"
 
+	"github.com/migalabs/armiarma/pkg/db"
 	"github.com/migalabs/armiarma/pkg/db/models"
-	psql "github.com/migalabs/armiarma/pkg/db/postgresql"
 	"github.com/migalabs/armiarma/pkg/hosts"
+	"github.com/migalabs/armiarma/pkg/metrics"
+	eth "github.com/migalabs/armiarma/pkg/networks/ethereum"
 	"github.com/migalabs/armiarma/pkg/utils"
 
 	"github.com/pkg/errors"
@@ -24,6 +26,8 @@ var (
 	StartExpD       = 2 * time.Minute // Starting delay that will serve for the Exponential Delay.
 	// Control variables
 	MinIterTime = 5 * time.Second // Minimum time that has to pass before iterating again.
+	// Minimum number of consecutive failed attempts to deprecate a peer (on top of the DeprecationTime).
+	MinDeprecationFailures = 3
 	//
 	PruneStrategy = "pruning"
 )
@@ -33,8 +37,9 @@ var (
 type PruningStrategy struct {
 	ctx context.Context
 
-	network  utils.NetworkType
-	DBClient *psql.DBClient
+	network   utils.NetworkType
+	DBClient  db.Persister
+	PeerStore *metrics.PeerStore
 
 	// Peer Stream and Return ConnectionStatus channels (communication between modules)
 	// both empty by default (need for initialization)
@@ -47,6 +52,9 @@ type PruningStrategy struct {
 	// List of peers sorted by the amount of time thatwe have to wait
 	PeerQueue *PeerQueue
 
+	// periodic deprecation of the stale peers (disabled by default)
+	deprecation DeprecationPolicy
+
 	// Prometheus Control Variables
 	m              sync.RWMutex
 	lastIterTime   time.Duration
@@ -59,13 +67,15 @@ type PruningStrategy struct {
 func NewPruningStrategy(
 	ctx context.Context,
 	network utils.NetworkType,
-	dbClient *psql.DBClient) (*PruningStrategy, error) {
+	dbClient db.Persister,
+	peerStore *metrics.PeerStore) (*PruningStrategy, error) {
 
 	return &PruningStrategy{
 		ctx:            ctx,
 		network:        network,
 		DBClient:       dbClient,
-		PeerQueue:      NewPeerQueue(dbClient),
+		PeerStore:      peerStore,
+		PeerQueue:      NewPeerQueue(dbClient, peerStore),
 		peerStreamChan: make(chan *models.HostInfo, DefaultWorkers),
 		nextPeerChan:   make(chan struct{}, DefaultWorkers),
 		connAttemptNot: make(chan *models.ConnectionAttempt),
@@ -88,6 +98,12 @@ func (c *PruningStrategy) Run() chan *models.HostInfo {
 	// start go routine that will notify of the full peerstore iteration and notifies it to the main strategy loop
 	go c.peerstoreIteratorRoutine()
 	go c.eventRecorderRoutine()
+	c.m.RLock()
+	deprecation := c.deprecation
+	c.m.RUnlock()
+	if deprecation.Interval > 0 {
+		go c.deprecationRoutine(deprecation)
+	}
 
 	return c.peerStreamChan
 }
@@ -164,6 +180,14 @@ func (c *PruningStrategy) peerstoreIteratorRoutine() {
 					goto pointerCheck
 				}
 
+				// the deprecated peers never reach the dialer (they are removed from the queue until their rediscovery)
+				if p, ok := c.PeerStore.GetPeer(nextPeer.iD); ok && p.IsDeprecated() {
+					logEntry.Tracef("skipping deprecated peer %s", nextPeer.iD.String())
+					c.PeerQueue.RemovePeer(nextPeer.iD)
+					callForPeer = true
+					goto pointerCheck
+				}
+
 				// add peer to the list of peers attempted in the last iter
 				attemptedPeers[nextPeer.iD] = nextPeer
 
@@ -264,15 +288,26 @@ func (c *PruningStrategy) eventRecorderRoutine() {
 					log.Errorf("we received a possitive attempt of connection to %s - but was probably deprecated", connAttempt.RemotePeer.String())
 				}
 			} else {
+				peerMetrics := c.PeerStore.GetOrCreatePeer(connAttempt.RemotePeer)
+				peerMetrics.ConnectionAttemptEvent(
+					connAttempt.Status == models.PossitiveAttempt,
+					connAttempt.Error,
+				)
 				p.ConnEventHandler(connAttempt.Error)
-				// Check if peer needs to be deprecated
-				if p.Deprecable() {
+				// the delay until the next attempt is persisted with this one
+				connAttempt.DialDelay, connAttempt.DialScore = c.PeerStore.ScheduleDial(connAttempt.RemotePeer)
+				// Check if peer needs to be deprecated (a single failure after a long silence is not enough)
+				if p.Deprecable() && peerMetrics.GetFailureStreak() >= MinDeprecationFailures {
 					logEntry.Warnf("deprecating peer %s", connAttempt.RemotePeer.String())
 					connAttempt.Deprecable = true
+					peerMetrics.DeprecationEvent()
 					// remove p from list of peers to ping (if it appears again in the discovery, it will be updated as undeprecated in the DB)
 					c.PeerQueue.RemovePeer(connAttempt.RemotePeer)
 				}
-				c.DBClient.PersistToDB(connAttempt)
+				err := c.DBClient.PersistConnAttempt(connAttempt)
+				if err != nil {
+					logEntry.Error(err)
+				}
 			}
 			// Keep track of the
 
@@ -290,8 +325,17 @@ func (c *PruningStrategy) eventRecorderRoutine() {
 			case (*models.ConnInfo):
 				cInfo := eventTrace.Event.(*models.ConnInfo)
 				bEvent.AddConnInfo(*cInfo)
+				c.PeerStore.GetOrCreatePeer(eventTrace.PeerID).ConnectionEventWithAddrs(cInfo.ConnTime, cInfo.LocalAddr, cInfo.RemoteAddr)
 			case (*models.EndConnInfo):
 				endConnInfo := eventTrace.Event.(*models.EndConnInfo)
+				// the previous disconnection never got its connection, it's persisted on its own
+				if bEvent.IsOrphanDisconn() {
+					c.persistPartialConnEvent(bEvent)
+					bEvent = models.NewConnEvent(eventTrace.PeerID)
+					connEventBuffer[eventTrace.PeerID] = bEvent
+				}
+				// the disconnection carries the reason of the Goodbye that the peer sent before it (if any)
+				endConnInfo.GoodbyeReason = c.PeerStore.GetOrCreatePeer(eventTrace.PeerID).DisconnectionEvent(endConnInfo.DiscTime)
 				bEvent.AddDisconn(*endConnInfo)
 			default:
 				logEntry.Warnf("invalid event trace for peer %s - %x\n", eventTrace.PeerID.String(), eventTrace.Event)
@@ -300,21 +344,82 @@ func (c *PruningStrategy) eventRecorderRoutine() {
 			// check if the ConnEvent is ready to be persisted
 			if bEvent.IsReadyToPersist() {
 				logEntry.Debugf("persising full conn event for peer %s", bEvent.PeerID.String())
-				c.DBClient.PersistToDB(bEvent)
+				err := c.DBClient.PersistConnEvent(bEvent)
+				if err != nil {
+					logEntry.Error(err)
+				}
+				// the next session of the peer starts from scratch, otherwise its connection
+				// would be paired with the disconnection of this one
+				delete(connEventBuffer, eventTrace.PeerID)
+			} else if bEvent.IsOpen() {
+				// the session is recorded while it lasts, its disconnection gets filled once it comes
+				c.persistPartialConnEvent(bEvent)
 			}
 
 		case identEvent := <-c.identEventNot:
 			logEntry.Debugf("new identification from peer %s", identEvent.HostInfo.ID.String())
-			c.DBClient.PersistToDB(identEvent.HostInfo)
+			// update the peer store first, so that the persisted quality score includes this identification
+			p := c.updatePeerStore(identEvent.HostInfo)
+			identEvent.HostInfo.AddAtt(
+				models.QualityScoreAttribute,
+				models.QualityScore(p.QualityScore(c.PeerStore.QualityWeights(), time.Now())),
+			)
+			err := c.DBClient.PersistHostInfo(identEvent.HostInfo)
+			if err != nil {
+				logEntry.Error(err)
+			}
+			// the peers of other networks would disconnect us on every attempt, they aren't retried
+			if foreign, ok := identEvent.HostInfo.Attr[eth.ForeignNetworkAttribute].(eth.ForeignNetworkStatus); ok {
+				logEntry.Debugf("deprecating peer %s of foreign network fork digest %s", foreign.PeerID.String(), foreign.ForkDigest)
+				c.deprecatePeer(models.NewForeignNetworkDeprecation(foreign.PeerID))
+			}
 
 		// detect if the context has been shut down to end the go routine
 		case <-c.ctx.Done():
 			logEntry.Debug("closing event recorder routine")
+			// the disconnections still waiting for their connection won't get it anymore
+			for _, bEvent := range connEventBuffer {
+				if bEvent.IsOrphanDisconn() {
+					c.persistPartialConnEvent(bEvent)
+				}
+			}
 			return
 		}
 	}
 }
 
+// persistPartialConnEvent persists a copy of the open session or of the disconnection without
+// connection (the buffered event keeps being updated), if the DB stores them (see db.SessionPersister).
+func (c *PruningStrategy) persistPartialConnEvent(connEvent *models.ConnEvent) {
+	sessionPersister, ok := c.DBClient.(db.SessionPersister)
+	if !ok {
+		return
+	}
+	partial := *connEvent
+	err := sessionPersister.PersistPartialConnEvent(&partial)
+	if err != nil {
+		log.Debugf("unable to persist partial conn_event of peer %s: %s", connEvent.PeerID.String(), err.Error())
+	}
+}
+
+// updatePeerStore fetches the identification of the peer into the in-memory peer store,
+// adding its location if the IP was already located, and returns the updated peer.
+func (c *PruningStrategy) updatePeerStore(hInfo *models.HostInfo) *metrics.Peer {
+	p := c.PeerStore.GetOrCreatePeer(hInfo.ID)
+	p.FetchHostInfo(hInfo)
+	c.PeerStore.TrackClientVersion(p)
+	if hInfo.IP == "" || p.GetCountry() != utils.Unknown {
+		return p
+	}
+	ipInfo, err := c.DBClient.ReadIpInfo(hInfo.IP)
+	if err != nil {
+		log.Tracef("ip %s not located yet: %s", hInfo.IP, err.Error())
+		return p
+	}
+	p.FetchIpInfo(ipInfo)
+	return p
+}
+
 // NextPeer notifies the peerstore iterator that a new peer has been requested.
 // After it, the peerstore iterator will put the new peer in the PeerStreamChan.
 func (c *PruningStrategy) NextPeer() {
@@ -394,7 +499,9 @@ type PeerQueue struct {
 	sync.RWMutex
 
 	// DBs
-	dbClient *psql.DBClient
+	dbClient db.Persister
+	// the peers are dialed (and sorted) by their next dial time (see metrics.DialBackoff)
+	peerStore *metrics.PeerStore
 
 	// control variables
 	peerPtr  int
@@ -403,12 +510,13 @@ type PeerQueue struct {
 }
 
 // NewPeerQueue is the constructor of a NewPeerQueue
-func NewPeerQueue(dbClient *psql.DBClient) *PeerQueue {
+func NewPeerQueue(dbClient db.Persister, peerStore *metrics.PeerStore) *PeerQueue {
 	return &PeerQueue{
-		dbClient: dbClient,
-		peerPtr:  0,
-		peerList: make([]*PrunedPeer, 0),
-		peerMap:  make(map[peer.ID]*PrunedPeer),
+		dbClient:  dbClient,
+		peerStore: peerStore,
+		peerPtr:   0,
+		peerList:  make([]*PrunedPeer, 0),
+		peerMap:   make(map[peer.ID]*PrunedPeer),
 	}
 }
 
@@ -426,7 +534,7 @@ func (c *PeerQueue) ValidNextPeer() bool {
 	if c.peerPtr >= c.Len() {
 		return false
 	} else {
-		if !c.peerList[c.peerPtr].IsReadyForConnection() {
+		if !c.peerStore.ShouldDial(c.peerList[c.peerPtr].iD, time.Now()) {
 			return false
 		}
 	}
@@ -488,6 +596,10 @@ func (c *PeerQueue) RemovePeer(id peer.ID) {
 	}
 	if idx > -1 {
 		c.peerList = append(c.peerList[:idx], c.peerList[idx+1:]...)
+		// keep the pointer on the next peer to connect
+		if idx < c.peerPtr {
+			c.peerPtr--
+		}
 	} else {
 		log.Debugf("unable to find peer %s inside queued peers", id.String())
 		return
@@ -552,9 +664,9 @@ func (c *PeerQueue) Swap(i, j int) {
 	c.peerList[i], c.peerList[j] = c.peerList[j], c.peerList[i]
 }
 
-// Less is part of sort.Interface. We use c.PeerList.NextConnection as the value to sort by.
+// Less is part of sort.Interface. We use the next dial time of the peers in the PeerStore as the value to sort by.
 func (c *PeerQueue) Less(i, j int) bool {
-	return c.peerList[i].NextConnection().Before(c.peerList[j].NextConnection())
+	return c.peerStore.NextDialTime(c.peerList[i].iD).Before(c.peerStore.NextDialTime(c.peerList[j].iD))
 }
 
 // Len is part of sort.Interface. We use the peer list to get the length of the array.
@@ -565,17 +677,12 @@ func (c *PeerQueue) Len() int {
 // UpdatePeerListFromPeerStore will refresh the peerqueue with the peerstore.
 // Basically we add those peers that did not exist before in the peerqueue.
 func (c *PeerQueue) UpdatePeerListFromRemoteDB() error {
-	// Get the list of peers from the peerstore
-	peerList, err := c.dbClient.GetNonDeprecatedPeers()
-	if err != nil {
-		return errors.Wrap(err, "fail to update pruning peer queue from DBClient")
-	}
 	// metrics
 	totcnt := 0
 	new := 0
 
-	// Fill the PeerQueue.PeerList with the missing peers from the
-	for _, connectablePeer := range peerList {
+	// Fill the PeerQueue.PeerList with the missing peers streamed from the DB
+	_, err := c.dbClient.ForEachNonDeprecatedPeer(context.Background(), func(connectablePeer *models.RemoteConnectablePeer) error {
 		totcnt++
 		if !c.IsPeerAlready(connectablePeer.ID) {
 			new++
@@ -586,6 +693,10 @@ func (c *PeerQueue) UpdatePeerListFromRemoteDB() error {
 			// add the new item to the list
 			c.AddPeer(newPrunnedPeer)
 		}
+		return nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "fail to update pruning peer queue from DBClient")
 	}
 	// Sort the list of peers based on the next connection
 	c.SortPeerList()
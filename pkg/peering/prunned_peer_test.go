@@ -4,112 +4,58 @@ import (
 	"testing"
 	"time"
 
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/migalabs/armiarma/pkg/hosts"
+	"github.com/migalabs/armiarma/pkg/utils"
 	"github.com/stretchr/testify/require"
 )
 
 func Test_PrunnedPeerDelays(t *testing.T) {
+	prunnedPeer1 := NewPrunedPeer(peer.ID("Peer1"), nil, utils.EthereumNetwork, PositiveDelay)
 
-	tNow := time.Now()
-
-	prunnedPeer1 := NewPrunedPeer("Peer1", PositiveDelayType)
-	time.Sleep(5 * time.Second)
-
-	prunnedPeer1.ConnEventHandler("None")
-	require.Equal(t, true, prunnedPeer1.NextConnection().After(tNow.Add(128*time.Minute)))
-	require.Equal(t, false, prunnedPeer1.NextConnection().After(tNow.Add(129*time.Minute)))
+	// checks that the next connection is delay after the last event (with a minute of margin)
+	requireDelay := func(recErr string, delay time.Duration) {
+		tNow := time.Now()
+		prunnedPeer1.ConnEventHandler(recErr)
+		require.True(t, !prunnedPeer1.NextConnection().Before(tNow.Add(delay)), recErr)
+		require.False(t, prunnedPeer1.NextConnection().After(tNow.Add(delay+time.Minute)), recErr)
+	}
 
+	requireDelay(hosts.NoConnError, 2*time.Minute)
 	// test that the BaseDeprecationTime has been updated
-	require.Equal(t, true, time.Now().Sub(prunnedPeer1.BaseDeprecationTimestamp) < 1*time.Second)
-
-	prunnedPeer1.ConnEventHandler("None")
-	require.Equal(t, true, prunnedPeer1.NextConnection().After(tNow.Add(128*time.Minute)))
-	require.Equal(t, false, prunnedPeer1.NextConnection().After(tNow.Add(129*time.Minute)))
-
-	tNow = time.Now()
-	prunnedPeer1.ConnEventHandler("") // this should go to NegativeWithHope
-	require.Equal(t, true, prunnedPeer1.NextConnection().After(tNow.Add(2*time.Minute)))
-	require.Equal(t, false, prunnedPeer1.NextConnection().After(tNow.Add(3*time.Minute)))
-
-	prunnedPeer1.ConnEventHandler("Connection reset by peer") // this should maintain in NegativeWithHope
-	require.Equal(t, true, prunnedPeer1.NextConnection().After(tNow.Add(4*time.Minute)))
-	require.Equal(t, false, prunnedPeer1.NextConnection().After(tNow.Add(6*time.Minute)))
-
-	prunnedPeer1.ConnEventHandler("connection refused") // this should maintain in NegativeWithHope
-	require.Equal(t, true, prunnedPeer1.NextConnection().After(tNow.Add(8*time.Minute)))
-	require.Equal(t, false, prunnedPeer1.NextConnection().After(tNow.Add(9*time.Minute)))
-
-	tNow = time.Now()
-	prunnedPeer1.ConnEventHandler("no route to host") // this should go to NegativeWithNoHope
-	require.Equal(t, true, prunnedPeer1.NextConnection().After(tNow.Add(256*time.Minute)))
-	require.Equal(t, false, prunnedPeer1.NextConnection().After(tNow.Add(257*time.Minute)))
-
-	prunnedPeer1.ConnEventHandler("unreachable network") // this should maintain in NegativeWithNoHope
-	require.Equal(t, true, prunnedPeer1.NextConnection().After(tNow.Add(512*time.Minute)))
-	require.Equal(t, false, prunnedPeer1.NextConnection().After(tNow.Add(513*time.Minute)))
-
-	prunnedPeer1.ConnEventHandler("unreachable network") // this should maintain in NegativeWithNoHope
-	require.Equal(t, true, prunnedPeer1.NextConnection().After(tNow.Add(1024*time.Minute)))
-	require.Equal(t, false, prunnedPeer1.NextConnection().After(tNow.Add(1025*time.Minute)))
-
-	prunnedPeer1.ConnEventHandler("unreachable network") // this should maintain in NegativeWithNoHope
-	require.Equal(t, true, prunnedPeer1.NextConnection().After(tNow.Add(2048*time.Minute)))
-	require.Equal(t, false, prunnedPeer1.NextConnection().After(tNow.Add(2049*time.Minute)))
-
-	// check that it maintains the max delay
-	prunnedPeer1.ConnEventHandler("unreachable network") // this should maintain in NegativeWithNoHope
-	require.Equal(t, true, prunnedPeer1.NextConnection().After(tNow.Add(2048*time.Minute)))
-	require.Equal(t, false, prunnedPeer1.NextConnection().After(tNow.Add(2049*time.Minute)))
-
-	prunnedPeer1.ConnEventHandler("peer id mismatch") // this should maintain in NegativeWithNoHope
-	require.Equal(t, true, prunnedPeer1.NextConnection().After(tNow.Add(2048*time.Minute)))
-	require.Equal(t, false, prunnedPeer1.NextConnection().After(tNow.Add(2049*time.Minute)))
-
-	prunnedPeer1.ConnEventHandler("dial to self attempted") // this should maintain in NegativeWithNoHope
-	require.Equal(t, true, prunnedPeer1.NextConnection().After(tNow.Add(2048*time.Minute)))
-	require.Equal(t, false, prunnedPeer1.NextConnection().After(tNow.Add(2049*time.Minute)))
-
-	tNow = time.Now()
-
-	prunnedPeer1.ConnEventHandler("context deadline exceeded") // this should go to NegativeWithNoHope
-	require.Equal(t, true, prunnedPeer1.NextConnection().After(tNow.Add(2*time.Minute)))
-	require.Equal(t, false, prunnedPeer1.NextConnection().After(tNow.Add(3*time.Minute)))
-
-	prunnedPeer1.ConnEventHandler("dial backoff") // this should maintain in NegativeWithNoHope
-	require.Equal(t, true, prunnedPeer1.NextConnection().After(tNow.Add(4*time.Minute)))
-	require.Equal(t, false, prunnedPeer1.NextConnection().After(tNow.Add(5*time.Minute)))
-
-	time.Sleep(5 * time.Second)
-	prunnedPeer1.ConnEventHandler("error requesting metadata") // this should maintain in NegativeWithNoHope
-	require.Equal(t, true, prunnedPeer1.NextConnection().After(tNow.Add(8*time.Minute)))
-	require.Equal(t, false, prunnedPeer1.NextConnection().After(tNow.Add(9*time.Minute)))
-
-	prunnedPeer1.ConnEventHandler("error requesting metadata") // this should maintain in NegativeWithNoHope
-	require.Equal(t, true, prunnedPeer1.NextConnection().After(tNow.Add(16*time.Minute)))
-	require.Equal(t, false, prunnedPeer1.NextConnection().After(tNow.Add(17*time.Minute)))
-
-	prunnedPeer1.ConnEventHandler("error requesting metadata") // this should maintain in NegativeWithNoHope
-	require.Equal(t, true, prunnedPeer1.NextConnection().After(tNow.Add(32*time.Minute)))
-	require.Equal(t, false, prunnedPeer1.NextConnection().After(tNow.Add(33*time.Minute)))
-
-	tNow = time.Now()
-	prunnedPeer1.ConnEventHandler("i/o timeout") // this should reset to timeoutdelay
-	require.Equal(t, true, prunnedPeer1.NextConnection().After(tNow.Add(32*time.Minute)))
-	require.Equal(t, false, prunnedPeer1.NextConnection().After(tNow.Add(33*time.Minute)))
-
-	prunnedPeer1.ConnEventHandler("i/o timeout") // this should maintain in timeoutdelay
-	require.Equal(t, true, prunnedPeer1.NextConnection().After(tNow.Add(64*time.Minute)))
-	require.Equal(t, false, prunnedPeer1.NextConnection().After(tNow.Add(65*time.Minute)))
-
-	prunnedPeer1.ConnEventHandler("i/o timeout") // this should maintain in timeoutdelay
-	require.Equal(t, true, prunnedPeer1.NextConnection().After(tNow.Add(128*time.Minute)))
-	require.Equal(t, false, prunnedPeer1.NextConnection().After(tNow.Add(129*time.Minute)))
-
-	// check this has not been refreshed
-	require.Equal(t, true, time.Now().Sub(prunnedPeer1.BaseDeprecationTimestamp) > 5*time.Second)
-
-	tNow = time.Now()
-	prunnedPeer1.ConnEventHandler("None") // this should go to Positive
-	require.Equal(t, true, prunnedPeer1.NextConnection().After(tNow.Add(128*time.Minute)))
-	require.Equal(t, false, prunnedPeer1.NextConnection().After(tNow.Add(129*time.Minute)))
-
+	require.Equal(t, true, time.Since(prunnedPeer1.baseDeprecationTimestamp) < 1*time.Second)
+	requireDelay(hosts.NoConnError, 2*time.Minute)
+	prunnedPeer1.baseDeprecationTimestamp = prunnedPeer1.baseDeprecationTimestamp.Add(-time.Hour)
+
+	// NegativeWithHope
+	requireDelay("", 2*time.Minute)
+	requireDelay(hosts.DialErrorConnectionResetByPeer, 2*time.Minute)
+	requireDelay(hosts.DialErrorConnectionRefused, 2*time.Minute)
+	requireDelay(hosts.DialErrorContextDeadlineExceeded, 2*time.Minute)
+	requireDelay(hosts.DialErrorBackOff, 2*time.Minute)
+	requireDelay(hosts.ErrorRequestingMetadta, 2*time.Minute)
+
+	// NegativeWithNoHope, they keep the delay of their type (see initialDelayTime)
+	for _, recErr := range []string{
+		hosts.DialErrorNoRouteToHost,
+		hosts.DialErrorNetworkUnreachable,
+		hosts.DialErrorNoGoodAddresses,
+		hosts.DialErrorPeerIDMismatch,
+		hosts.DialErrorSelfAttempt,
+	} {
+		require.Equal(t, NegativeWithNoHopeDelay, ErrorToDelayType(recErr))
+		requireDelay(recErr, initialDelayTime[NegativeWithNoHopeDelay])
+	}
+
+	// the timeouts double the delay, up to the max delay
+	for delay := 32 * time.Minute; delay <= MaxDelayTime; delay *= 2 {
+		requireDelay(hosts.DialErrorIoTimeout, delay)
+	}
+	requireDelay(hosts.DialErrorIoTimeout, MaxDelayTime)
+
+	// check the BaseDeprecationTime has not been refreshed by the failures
+	require.Equal(t, true, time.Since(prunnedPeer1.baseDeprecationTimestamp) > 59*time.Minute)
+
+	requireDelay(hosts.NoConnError, 2*time.Minute)
+	require.Equal(t, true, time.Since(prunnedPeer1.baseDeprecationTimestamp) < 1*time.Second)
 }
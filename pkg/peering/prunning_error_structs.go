@@ -53,7 +53,9 @@ func ErrorToDelayType(errString string) Delay {
 		hosts.DialErrorNoGoodAddresses:
 		return NegativeWithNoHopeDelay
 
-	case hosts.DialErrorIoTimeout:
+	case hosts.DialErrorIoTimeout,
+		hosts.DialErrorQuicIdleTimeout,
+		hosts.DialErrorQuicHandshakeTimeout:
 		return TimeoutDelay
 
 	default:
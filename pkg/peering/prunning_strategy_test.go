@@ -2,58 +2,64 @@ package peering
 
 import (
 	"testing"
+	"time"
 
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/migalabs/armiarma/pkg/db/memory"
+	"github.com/migalabs/armiarma/pkg/hosts"
+	"github.com/migalabs/armiarma/pkg/metrics"
+	"github.com/migalabs/armiarma/pkg/utils"
 	"github.com/stretchr/testify/require"
 )
 
 func Test_Deprecation(t *testing.T) {
 	require.Equal(t, 1, 1)
 
-	testPeer := NewPrunedPeer("test", PositiveDelayType)
-	testPeer.BaseDeprecationTimestamp = testPeer.BaseDeprecationTimestamp.Add(-DeprecationTime)
+	testPeer := NewPrunedPeer(peer.ID("test"), nil, utils.EthereumNetwork, PositiveDelay)
+	testPeer.baseDeprecationTimestamp = testPeer.baseDeprecationTimestamp.Add(-DeprecationTime)
 
 	require.Equal(t, true, testPeer.Deprecable())
 
-	testPeer.ConnEventHandler("None")
+	testPeer.ConnEventHandler(hosts.NoConnError)
 
 	require.Equal(t, false, testPeer.Deprecable())
 
-	testPeer.BaseDeprecationTimestamp = testPeer.BaseDeprecationTimestamp.Add(-DeprecationTime)
+	testPeer.baseDeprecationTimestamp = testPeer.baseDeprecationTimestamp.Add(-DeprecationTime)
 
-	testPeer.ConnEventHandler("error requesting metadata")
+	testPeer.ConnEventHandler(hosts.ErrorRequestingMetadta)
 	require.Equal(t, true, testPeer.Deprecable())
 
-	testPeer.ConnEventHandler("i/o timeout")
+	testPeer.ConnEventHandler(hosts.DialErrorIoTimeout)
 	require.Equal(t, true, testPeer.Deprecable())
 
-	testPeer.ConnEventHandler("None")
+	testPeer.ConnEventHandler(hosts.NoConnError)
 
 	require.Equal(t, false, testPeer.Deprecable())
 
-	testPeer.ConnEventHandler("connection reset by peer")
+	testPeer.ConnEventHandler(hosts.DialErrorConnectionResetByPeer)
 	require.Equal(t, false, testPeer.Deprecable())
 
-	testPeer.ConnEventHandler("dial to self attempted")
+	testPeer.ConnEventHandler(hosts.DialErrorSelfAttempt)
 	require.Equal(t, false, testPeer.Deprecable())
 
-	testPeer.BaseDeprecationTimestamp = testPeer.BaseDeprecationTimestamp.Add(-DeprecationTime)
+	testPeer.baseDeprecationTimestamp = testPeer.baseDeprecationTimestamp.Add(-DeprecationTime)
 
-	testPeer.ConnEventHandler("connection refused")
+	testPeer.ConnEventHandler(hosts.DialErrorConnectionRefused)
 	require.Equal(t, true, testPeer.Deprecable())
 
-	testPeer.ConnEventHandler("context deadline exceeded")
+	testPeer.ConnEventHandler(hosts.DialErrorContextDeadlineExceeded)
 	require.Equal(t, true, testPeer.Deprecable())
 
-	testPeer.ConnEventHandler("no route to host")
+	testPeer.ConnEventHandler(hosts.DialErrorNoRouteToHost)
 	require.Equal(t, true, testPeer.Deprecable())
 
-	testPeer.ConnEventHandler("peer id mismatch")
+	testPeer.ConnEventHandler(hosts.DialErrorPeerIDMismatch)
 	require.Equal(t, true, testPeer.Deprecable())
 
 	testPeer.ConnEventHandler("rfgdsfghsdfh")
 	require.Equal(t, true, testPeer.Deprecable())
 
-	testPeer.ConnEventHandler("None")
+	testPeer.ConnEventHandler(hosts.NoConnError)
 	require.Equal(t, false, testPeer.Deprecable())
 
 }
@@ -62,3 +68,32 @@ func Test_Deprecation(t *testing.T) {
 func Test_Pruning(t *testing.T) {
 
 }
+
+func Test_PeerQueueDialBackoff(t *testing.T) {
+	store := metrics.NewPeerStore()
+	store.SetDialBackoff(metrics.DialBackoff{Success: time.Minute, Default: time.Hour, Max: 4 * time.Hour})
+	queue := NewPeerQueue(memory.NewDB(), store)
+
+	// the legacy delay of the pruned peer doesn't hold back a peer that the backoff allows
+	refused := NewPrunedPeer(peer.ID("refused-peer"), nil, utils.EthereumNetwork, Minus1Delay)
+	refused.ConnEventHandler(hosts.DialErrorConnectionRefused)
+	require.False(t, refused.IsReadyForConnection())
+	queue.AddPeer(refused)
+	require.True(t, queue.ValidNextPeer())
+
+	// while the peer that the backoff delays is not valid, even without legacy delay
+	failing := NewPrunedPeer(peer.ID("failing-peer"), nil, utils.EthereumNetwork, Minus1Delay)
+	require.True(t, failing.IsReadyForConnection())
+	store.GetOrCreatePeer(failing.iD).ConnectionAttemptEvent(false, hosts.DialErrorConnectionRefused)
+	store.ScheduleDial(failing.iD)
+	queue.AddPeer(failing)
+	require.False(t, queue.ValidNextPeer())
+
+	// the queue is sorted by the next dial time of the backoff
+	queue.SortPeerList()
+	require.True(t, queue.ValidNextPeer())
+	require.Equal(t, refused.iD, queue.GetNextPeer().iD)
+	require.False(t, queue.ValidNextPeer())
+	require.Equal(t, failing.iD, queue.GetNextPeer().iD)
+	require.False(t, queue.ValidNextPeer())
+}
@@ -1,6 +1,7 @@
 package apis
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
@@ -13,23 +14,32 @@ import (
 	"time"
 
 	"github.com/migalabs/armiarma/pkg/db/models"
+	"github.com/migalabs/armiarma/pkg/utils"
+	"github.com/migalabs/armiarma/pkg/utils/providers"
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 )
 
 const (
-	defaultIpTTL   = 30 * 24 * time.Hour // 30 days
-	ipChanBuffSize = 45                  // number of ips that can be buffered unto the channel
-	ipBuffSize     = 8192                // number of ip queries that can be queued in the ipQueue
-	ipApiEndpoint  = "http://ip-api.com/json/{__ip__}?fields=status,continent,continentCode,country,countryCode,region,regionName,city,zip,lat,lon,isp,org,as,asname,mobile,proxy,hosting,query"
-	minIterTime    = 100 * time.Millisecond
+	defaultIpTTL       = 30 * 24 * time.Hour // 30 days
+	ipChanBuffSize     = 45                  // number of ip batches that can be buffered unto the channel
+	ipBuffSize         = 8192                // number of ip queries that can be queued in the ipQueue
+	ipApiFields        = "status,continent,continentCode,country,countryCode,region,regionName,city,zip,lat,lon,isp,org,as,asname,mobile,proxy,hosting,query"
+	ipApiEndpoint      = "http://ip-api.com/json/{__ip__}?fields=" + ipApiFields
+	ipApiBatchEndpoint = "http://ip-api.com/batch?fields=" + ipApiFields
+	ipApiBatchSize     = 100                    // max number of ips that the batch endpoint accepts per call
+	ipBatchWindow      = 500 * time.Millisecond // max time that an ip waits in the queue for the batch to fill up
+	minIterTime        = 100 * time.Millisecond
+	// quotas of the free tier of IP-API, per minute
+	ipApiBatchRate = 15
+	ipApiRate      = 45
 )
 
 var TooManyRequestError error = fmt.Errorf("error HTTP 429")
 
 // DB Interface for DBWriter
 type DBWriter interface {
-	PersistToDB(interface{})
+	PersistIpInfo(models.IpInfo) error
 	ReadIpInfo(string) (models.IpInfo, error)
 	CheckIpRecords(string) (bool, bool, error)
 	GetExpiredIpInfo() ([]string, error)
@@ -39,28 +49,147 @@ type DBWriter interface {
 type IpLocator struct {
 	ctx context.Context
 	// Request channels
-	locationRequest chan string
+	locationRequest chan []string
 
 	// dbClient
 	dbClient DBWriter
 
 	ipQueue *ipQueue
+	// IP-API endpoints (single ip and batch)
+	endpoint      string
+	batchEndpoint string
+	httpClient    *http.Client
+	// the queued ips are requested in batches of up to batchSize ips,
+	// or of the ips queued during the batchWindow
+	batchSize   int
+	batchWindow time.Duration
 	// control variables for IP-API request
 	// Control flags from prometheus
 	apiCalls *int32
+	// lookups and outcomes of the provider (see Stats)
+	counters *locatorCounters
+	// errors already reported by the last summary (only read by the queue routine)
+	loggedErrors int64
+
+	// samples the errors of the lookups of the located IPs in the DB, which fail for every queued IP
+	// while the DB is unreachable (keyed by their root cause, as they name the IP)
+	errSampler *utils.ErrorSampler
+
+	// located IPs (from the provider or the DB), and the time until they are located again
+	cache *ipCache
+	ttl   time.Duration
+	// quotas of the batch and the single IP endpoints, the calls beyond them wait for a token
+	batchLimiter  *tokenBucket
+	singleLimiter *tokenBucket
+	// notified with every located IP (i.e. to backfill the peers that were waiting for it)
+	onLocated []func(models.IpInfo)
+	// local database that locates the IPs instead of IP-API, no HTTP call is made when it is set
+	mmdb *MaxMindDB
 }
 
-func NewIpLocator(ctx context.Context, dbCli DBWriter) *IpLocator {
+// IpLocatorOption configures the IpLocator.
+type IpLocatorOption func(*IpLocator) error
+
+// WithIpTTL sets the time after which a located IP is located again.
+func WithIpTTL(ttl time.Duration) IpLocatorOption {
+	return func(c *IpLocator) error {
+		if ttl <= 0 {
+			return errors.New("non-positive ip ttl")
+		}
+		c.ttl = ttl
+		return nil
+	}
+}
+
+// WithRateLimit sets the number of requests to the batch and to the single IP endpoints that
+// the locator makes at most per period.
+func WithRateLimit(batchRequests, singleRequests int, per time.Duration) IpLocatorOption {
+	return func(c *IpLocator) error {
+		if batchRequests <= 0 || singleRequests <= 0 || per <= 0 {
+			return errors.New("non-positive rate limit")
+		}
+		c.batchLimiter = newTokenBucket(batchRequests, per)
+		c.singleLimiter = newTokenBucket(singleRequests, per)
+		return nil
+	}
+}
+
+// WithMaxMindDB locates the IPs offline with the given GeoLite2-City (or GeoIP2-City) database,
+// instead of requesting them to IP-API.
+func WithMaxMindDB(path string) IpLocatorOption {
+	return func(c *IpLocator) error {
+		mmdb, err := NewMaxMindDB(path)
+		if err != nil {
+			return err
+		}
+		c.mmdb = mmdb
+		return nil
+	}
+}
+
+func NewIpLocator(ctx context.Context, dbCli DBWriter, opts ...IpLocatorOption) (*IpLocator, error) {
 	calls := int32(0)
-	return &IpLocator{
+	ipLocator := &IpLocator{
 		ctx:             ctx,
-		locationRequest: make(chan string, ipChanBuffSize),
+		locationRequest: make(chan []string, ipChanBuffSize),
 		dbClient:        dbCli,
+		endpoint:        ipApiEndpoint,
+		batchEndpoint:   ipApiBatchEndpoint,
+		httpClient:      http.DefaultClient,
+		batchSize:       ipApiBatchSize,
+		batchWindow:     ipBatchWindow,
 		apiCalls:        &calls,
+		counters:        &locatorCounters{},
 		ipQueue:         newIpQueue(ipBuffSize),
+		errSampler:      utils.NewErrorSampler(utils.DefaultErrorSampleWindow, nil),
+		cache:           newIpCache(),
+		ttl:             defaultIpTTL,
+		batchLimiter:    newTokenBucket(ipApiBatchRate, time.Minute),
+		singleLimiter:   newTokenBucket(ipApiRate, time.Minute),
+	}
+	for _, opt := range opts {
+		if err := opt(ipLocator); err != nil {
+			return nil, err
+		}
+	}
+	return ipLocator, nil
+}
+
+// OnLocated registers a function that gets every located IP, whether it was resolved by the
+// provider or read from the DB. Meant to be called before Run.
+func (c *IpLocator) OnLocated(fn func(models.IpInfo)) {
+	c.onLocated = append(c.onLocated, fn)
+}
+
+// located persists and caches the location of the IP, and notifies it.
+func (c *IpLocator) located(ipInfo models.IpInfo) {
+	atomic.AddInt64(&c.counters.successes, 1)
+	ipInfo.ExpirationTime = ipInfo.ResolvedAt.Add(c.ttl)
+	// Upsert the IP into the db
+	err := c.dbClient.PersistIpInfo(ipInfo)
+	if err != nil {
+		log.Error(errors.Wrap(err, "unable to persist ip_info of "+ipInfo.IP))
+	}
+	c.cached(ipInfo)
+}
+
+// cached keeps the location of the IP in memory, and notifies it.
+func (c *IpLocator) cached(ipInfo models.IpInfo) {
+	c.cache.add(ipInfo)
+	for _, fn := range c.onLocated {
+		fn(ipInfo)
 	}
 }
 
+// waitToken waits until the limiter allows another request, returning false if the context died meanwhile.
+func (c *IpLocator) waitToken(limiter *tokenBucket) bool {
+	if d := limiter.reserve(); d > 0 {
+		log.Tracef("waiting %s for the rate limit of IP-API", d)
+		return c.wait(d)
+	}
+	return c.ctx.Err() == nil
+}
+
 // Run the necessary routines to locate the IPs
 func (c *IpLocator) Run() {
 	//l.SetLevel(Logrus.TraceLevel)
@@ -71,18 +200,40 @@ func (c *IpLocator) Run() {
 // or if the routine gets canceled
 func (c *IpLocator) locatorRoutine() {
 	log.Info("IP locator routine started")
-	// ip queue reading routine
+	// ip queue reading routine, that composes the batches
 	go func() {
 		ticker := time.NewTicker(minIterTime)
+		batch := make([]string, 0, c.batchSize)
+		var batchStart time.Time
+		lastSummary := time.Now()
 		for {
-			ip, err := c.ipQueue.readItem()
-			if err == nil {
-				// put the request in the Queue
-				c.locationRequest <- ip
+			for len(batch) < c.batchSize {
+				ip, err := c.ipQueue.readItem()
+				if err != nil {
+					break
+				}
+				if len(batch) == 0 {
+					batchStart = time.Now()
+				}
+				batch = append(batch, ip)
+			}
+			// the batch is full or it waited long enough
+			if len(batch) > 0 && (len(batch) >= c.batchSize || time.Since(batchStart) >= c.batchWindow) {
+				select {
+				case c.locationRequest <- batch:
+				case <-c.ctx.Done():
+					return
+				}
+				batch = make([]string, 0, c.batchSize)
 			}
 			select {
 			case <-ticker.C:
 				ticker.Reset(minIterTime)
+				c.errSampler.FlushExpired()
+				if time.Since(lastSummary) >= statsLogInterval {
+					c.logStatsSummary()
+					lastSummary = time.Now()
+				}
 
 			case <-c.ctx.Done():
 				return
@@ -92,66 +243,20 @@ func (c *IpLocator) locatorRoutine() {
 
 	// ip locating routien
 	go func() {
-		var nextDelayRequest time.Duration
 		for {
 			select {
-			// New request to identify an IP
-			case reqIp := <-c.locationRequest:
-				log.Trace("new request has been received for ip:", reqIp)
-			reqLoop:
-				for {
-					// since it didn't exist or did expire, request the ip
-					// new API call needs to be done
-					log.Tracef(" making API call for %s", reqIp)
-					atomic.AddInt32(c.apiCalls, 1)
-					respC := c.locateIp(reqIp)
-					select {
-					case apiResp := <-respC:
-						nextDelayRequest = apiResp.DelayTime
-						log.WithFields(log.Fields{
-							"delay":         nextDelayRequest,
-							"attempts left": apiResp.AttemptsLeft,
-						}).Debug("got response from IP-API request ")
-						// check if there is an error
-						switch apiResp.Err {
-						case TooManyRequestError:
-							// if the error reports that we tried too many calls on the API, sleep given time and try again
-							log.Debug("call ", reqIp, " -> error received: ", apiResp.Err.Error(), "\nwaiting ", nextDelayRequest+(5*time.Second))
-							ticker := time.NewTicker(nextDelayRequest + (5 * time.Second))
-							select {
-							case <-ticker.C:
-								continue
-							case <-c.ctx.Done():
-								log.Info("context closure has been detecting, closing IpApi caller")
-								return
-							}
-						case nil:
-							// if the error is different from TooManyRequestError break loop and store the request
-							log.Debugf("call %s-> api req success", reqIp)
-							// Upsert the IP into the db
-							c.dbClient.PersistToDB(apiResp.IpInfo)
-							break reqLoop
-
-						default:
-							log.Debug("call ", reqIp, " -> diff error received: ", apiResp.Err.Error())
-							break reqLoop
-
-						}
-
-					case <-c.ctx.Done():
-						log.Info("context closure has been detecting, closing IpApi caller")
-						return
-					}
+			// New request to identify a batch of IPs
+			case reqIps := <-c.locationRequest:
+				log.Tracef("new request has been received for %d ips", len(reqIps))
+				nextDelayRequest, ok := c.locateBatch(reqIps)
+				if !ok {
+					log.Info("context closure has been detecting, closing IpApi caller")
+					return
 				}
 				// check if there is any waiting time that we have to respect before next connection
 				if nextDelayRequest != time.Duration(0) {
 					log.Debug("number of allowed requests has been exceed, waiting ", nextDelayRequest+(2*time.Second))
-					// set req delay to true, noone can make requests
-					ticker := time.NewTicker(nextDelayRequest + (2 * time.Second))
-					select {
-					case <-ticker.C:
-						continue
-					case <-c.ctx.Done():
+					if !c.wait(nextDelayRequest + (2 * time.Second)) {
 						log.Info("context closure has been detecting, closing IpApi caller")
 						return
 					}
@@ -159,28 +264,172 @@ func (c *IpLocator) locatorRoutine() {
 
 			// the context has been deleted, end go routine
 			case <-c.ctx.Done():
-				// close the channels
-				close(c.locationRequest)
 				return
 			}
 		}
 	}()
 }
 
+// locateBatch resolves the IPs with a single call to the batch endpoint (a single request for the rate limit),
+// falling back to one call per IP if the batch endpoint fails.
+// Returns the delay to respect before the next call, and false if the context died meanwhile.
+func (c *IpLocator) locateBatch(ips []string) (time.Duration, bool) {
+	if c.mmdb != nil {
+		c.locateOffline(ips)
+		return time.Duration(0), c.ctx.Err() == nil
+	}
+	for {
+		if !c.waitToken(c.batchLimiter) {
+			return time.Duration(0), false
+		}
+		log.Tracef("making batch API call for %d ips", len(ips))
+		atomic.AddInt32(c.apiCalls, 1)
+		resps, delay, attemptsLeft, err := callIpApiBatch(c.ctx, c.httpClient, c.batchEndpoint, ips)
+		log.WithFields(log.Fields{
+			"delay":         delay,
+			"attempts left": attemptsLeft,
+		}).Debug("got response from IP-API batch request ")
+		switch err {
+		case TooManyRequestError:
+			c.recordError(err)
+			// if the error reports that we tried too many calls on the API, sleep given time and try again
+			log.Debug("batch call -> error received: ", err.Error(), "\nwaiting ", delay+(5*time.Second))
+			if !c.wait(delay + (5 * time.Second)) {
+				return delay, false
+			}
+			continue
+
+		case nil:
+			for i, resp := range resps {
+				if resp.Err != nil {
+					c.recordError(resp.Err)
+					log.Debugf("call %s-> batch api req failed: %s", ips[i], resp.Err.Error())
+					continue
+				}
+				c.located(resp.IpInfo)
+			}
+			return delay, true
+
+		default:
+			if c.ctx.Err() != nil {
+				return delay, false
+			}
+			c.recordError(err)
+			log.Debugf("batch call -> diff error received, falling back to single ip calls: %s", err.Error())
+			// respect the limit of the failed batch call before
+			if delay != time.Duration(0) && !c.wait(delay+(2*time.Second)) {
+				return delay, false
+			}
+			for _, ip := range ips {
+				delay, ok := c.locateSingle(ip)
+				if !ok {
+					return delay, false
+				}
+				if delay != time.Duration(0) && !c.wait(delay+(2*time.Second)) {
+					return delay, false
+				}
+			}
+			return time.Duration(0), true
+		}
+	}
+}
+
+// locateOffline resolves the IPs with the local database.
+func (c *IpLocator) locateOffline(ips []string) {
+	for _, ip := range ips {
+		ipInfo, err := c.mmdb.locate(ip)
+		if err != nil {
+			c.recordError(err)
+			log.Debugf("call %s-> maxmind lookup failed: %s", ip, err.Error())
+			continue
+		}
+		c.located(ipInfo)
+	}
+}
+
+// locateSingle resolves a single IP, retrying after the given delay if we exceeded the limit of requests.
+// Returns the delay to respect before the next call, and false if the context died meanwhile.
+func (c *IpLocator) locateSingle(ip string) (time.Duration, bool) {
+	for {
+		// since it didn't exist or did expire, request the ip
+		// new API call needs to be done
+		if !c.waitToken(c.singleLimiter) {
+			return time.Duration(0), false
+		}
+		log.Tracef(" making API call for %s", ip)
+		atomic.AddInt32(c.apiCalls, 1)
+		ipInfo, delay, attemptsLeft, err := callIpApi(c.ctx, c.httpClient, c.endpoint, ip)
+		log.WithFields(log.Fields{
+			"delay":         delay,
+			"attempts left": attemptsLeft,
+		}).Debug("got response from IP-API request ")
+		// check if there is an error
+		switch err {
+		case TooManyRequestError:
+			c.recordError(err)
+			// if the error reports that we tried too many calls on the API, sleep given time and try again
+			log.Debug("call ", ip, " -> error received: ", err.Error(), "\nwaiting ", delay+(5*time.Second))
+			if !c.wait(delay + (5 * time.Second)) {
+				return delay, false
+			}
+			continue
+
+		case nil:
+			// if the error is different from TooManyRequestError break loop and store the request
+			log.Debugf("call %s-> api req success", ip)
+			c.located(ipInfo)
+			return delay, true
+
+		default:
+			if c.ctx.Err() != nil {
+				return delay, false
+			}
+			c.recordError(err)
+			log.Debug("call ", ip, " -> diff error received: ", err.Error())
+			return delay, true
+		}
+	}
+}
+
+// wait sleeps the given time, returning false if the context died meanwhile.
+func (c *IpLocator) wait(d time.Duration) bool {
+	ticker := time.NewTicker(d)
+	defer ticker.Stop()
+	select {
+	case <-ticker.C:
+		return true
+	case <-c.ctx.Done():
+		return false
+	}
+}
+
 // LocateIP is an externa request that any module could do to identify an IP
 func (c *IpLocator) LocateIP(ip string) {
-	// check first if IP is already in queue (to queue same ip)
+	atomic.AddInt64(&c.counters.lookups, 1)
+	// check first if the IP was already located during this run
+	if _, ok := c.cache.get(ip, time.Now()); ok {
+		atomic.AddInt64(&c.counters.cacheHits, 1)
+		return
+	}
+	// check if IP is already in queue (to queue same ip)
 	if c.ipQueue.ipExists(ip) {
 		return
 	}
 
-	// Check if the IP is already in the cache
+	// Check if the IP is already in the DB
 	exists, expired, err := c.dbClient.CheckIpRecords(ip)
 	if err != nil {
-		log.Error("unable to check if IP already exists -", err.Error()) // Should it be a Panic?
+		c.errSampler.ErrorWithKey("check: "+errors.Cause(err).Error(), errors.Wrap(err, "unable to check if IP already exists")) // Should it be a Panic?
 	}
-	// if exists and it didn't expired, don't do anything
+	// if exists and it didn't expired, keep it in memory for the next lookups
 	if exists && !expired {
+		atomic.AddInt64(&c.counters.cacheHits, 1)
+		ipInfo, err := c.dbClient.ReadIpInfo(ip)
+		if err != nil {
+			c.errSampler.ErrorWithKey("read: "+errors.Cause(err).Error(), errors.Wrap(err, "unable to read the located IP"))
+			return
+		}
+		c.cached(ipInfo)
 		return
 	}
 
@@ -203,52 +452,78 @@ func (c *IpLocator) Close() {
 	log.Info("closing IP-API service")
 	// close the context for ending up the routine
 
+	if c.mmdb != nil {
+		if err := c.mmdb.Close(); err != nil {
+			log.Error(errors.Wrap(err, "unable to close the MaxMind database"))
+		}
+	}
 }
 
-func (c *IpLocator) locateIp(ip string) chan models.ApiResp {
-	respC := make(chan models.ApiResp)
-	go callIpApi(ip, respC)
-	return respC
+// Resolve locates the IP right away with the selected provider, see ResolveIP.
+func (c *IpLocator) Resolve(ip string) (country, countryCode, city string, err error) {
+	loc, err := c.ResolveIP(c.ctx, ip)
+	if err != nil {
+		return "", "", "", err
+	}
+	return loc.Country, loc.CountryCode, loc.City, nil
 }
 
-// get location country and City from the multiaddress of the peer on the peerstore
-func callIpApi(ip string, respC chan models.ApiResp) {
-	var apiResponse models.ApiResp
-	apiResponse.IpInfo, apiResponse.DelayTime, apiResponse.AttemptsLeft, apiResponse.Err = CallIpApi(ip)
-	respC <- apiResponse
-	// defer ^
+// ResolveIP locates the IP right away, without going through the queue nor the DB
+// (although respecting the rate limit).
+func (c *IpLocator) ResolveIP(ctx context.Context, ip string) (models.Location, error) {
+	if c.mmdb != nil {
+		ipInfo, err := c.mmdb.locate(ip)
+		if err != nil {
+			c.recordError(err)
+			return models.Location{}, err
+		}
+		atomic.AddInt64(&c.counters.successes, 1)
+		return ipInfo.Location(), nil
+	}
+	if d := c.singleLimiter.reserve(); d > 0 {
+		select {
+		case <-time.After(d):
+		case <-ctx.Done():
+			return models.Location{}, ctx.Err()
+		}
+	}
+	atomic.AddInt32(c.apiCalls, 1)
+	ipInfo, _, _, err := callIpApi(ctx, c.httpClient, c.endpoint, ip)
+	if err != nil {
+		c.recordError(err)
+		return models.Location{}, err
+	}
+	atomic.AddInt64(&c.counters.successes, 1)
+	return ipInfo.Location(), nil
 }
 
 func CallIpApi(ip string) (ipInfo models.IpInfo, delay time.Duration, attemptsLeft int, err error) {
+	return callIpApi(context.Background(), http.DefaultClient, ipApiEndpoint, ip)
+}
+
+// get location country and City from the multiaddress of the peer on the peerstore
+func callIpApi(ctx context.Context, client *http.Client, endpoint string, ip string) (ipInfo models.IpInfo, delay time.Duration, attemptsLeft int, err error) {
 
-	url := strings.Replace(ipApiEndpoint, "{__ip__}", ip, 1)
+	url := strings.Replace(endpoint, "{__ip__}", ip, 1)
 
 	// Make the IP-APi request
-	resp, err := http.Get(url)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
-		err = errors.Wrap(err, "unable to locate IP"+ip)
+		err = errors.Wrap(err, "unable to compose request to locate IP"+ip)
 		return
 	}
-	timeLeft, _ := strconv.Atoi(resp.Header["X-Ttl"][0])
-	// check if the error that we are receiving means that we exeeded the request limit
-	if resp.StatusCode == 429 {
-		log.Debugf("limit of requests per minute has been exeeded, wait for next call %s secs", resp.Header["X-Ttl"][0])
-		err = TooManyRequestError
-		delay = time.Duration(timeLeft) * time.Second
+	resp, err := client.Do(req)
+	if err != nil {
+		err = errors.Wrap(err, "unable to locate IP"+ip)
 		return
 	}
-
-	// Check the attempts left that we have to call the api
-	attemptsLeft, _ = strconv.Atoi(resp.Header["X-Rl"][0])
-	if attemptsLeft <= 0 {
-		// if there are no more attempts left against the api, check how much time do we have to wait
-		// until we can call it again
-		// set the delayTime that we return to the given seconds to wait
-		delay = time.Duration(timeLeft) * time.Second
+	defer resp.Body.Close()
+	delay, attemptsLeft, err = parseRateLimit(resp)
+	if err != nil {
+		return
 	}
 
 	// check if the response was success or not
-	defer resp.Body.Close()
 	bodyBytes, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		err = errors.Wrap(err, "could not read response body")
@@ -264,12 +539,114 @@ func CallIpApi(ip string) (ipInfo models.IpInfo, delay time.Duration, attemptsLe
 	}
 	// Check if the status of the request has been succesful
 	if apiMsg.Status != "success" {
-		err = errors.New(fmt.Sprintf("status from ip different than success, resp header:\n %#v \n %+v", resp, apiMsg))
+		err = errors.Wrap(ErrIpNotLocated, fmt.Sprintf("status from ip different than success, resp header:\n %#v \n %+v", resp, apiMsg))
 		return
 	}
 
-	ipInfo.ExpirationTime = time.Now().UTC().Add(defaultIpTTL)
+	now := time.Now().UTC()
+	ipInfo.ExpirationTime = now.Add(defaultIpTTL)
 	ipInfo.IpApiMsg = apiMsg
+	ipInfo.Provider, _ = providers.LookupProvider(ip)
+	ipInfo.Source = models.LocationSourceIpApi
+	ipInfo.ResolvedAt = now
+	return
+}
+
+// callIpApiBatch locates all the given ips with a single POST to the batch endpoint.
+// The responses are returned in the same order as the ips, each of them with its own error
+// if the ip couldn't be located, while err is only returned if the whole call failed.
+func callIpApiBatch(ctx context.Context, client *http.Client, endpoint string, ips []string) (resps []models.ApiResp, delay time.Duration, attemptsLeft int, err error) {
+	body, err := json.Marshal(ips)
+	if err != nil {
+		err = errors.Wrap(err, "unable to compose batch request")
+		return
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		err = errors.Wrap(err, "unable to compose batch request")
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		err = errors.Wrap(err, "unable to locate IP batch")
+		return
+	}
+	defer resp.Body.Close()
+	delay, attemptsLeft, err = parseRateLimit(resp)
+	if err != nil {
+		return
+	}
+	if resp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("batch request failed with HTTP %d", resp.StatusCode)
+		return
+	}
+
+	bodyBytes, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		err = errors.Wrap(err, "could not read batch response body")
+		return
+	}
+	var apiMsgs []models.IpApiMsg
+	err = json.Unmarshal(bodyBytes, &apiMsgs)
+	if err != nil {
+		err = errors.Wrap(err, "could not unmarshall batch response")
+		return
+	}
+
+	// match the results with the requested ips, through the query field (or the position if missing)
+	located := make(map[string]models.IpApiMsg, len(apiMsgs))
+	for i, apiMsg := range apiMsgs {
+		ip := apiMsg.IP
+		if ip == "" && i < len(ips) {
+			ip = ips[i]
+			apiMsg.IP = ip
+		}
+		located[ip] = apiMsg
+	}
+	now := time.Now().UTC()
+	resps = make([]models.ApiResp, len(ips))
+	for i, ip := range ips {
+		apiMsg, ok := located[ip]
+		switch {
+		case !ok:
+			resps[i].Err = errors.Wrap(ErrIpNotLocated, "ip "+ip+" missing in the batch response")
+		case apiMsg.Status != "success":
+			resps[i].Err = errors.Wrap(ErrIpNotLocated, fmt.Sprintf("status from ip %s different than success: %+v", ip, apiMsg))
+		default:
+			provider, _ := providers.LookupProvider(ip)
+			resps[i].IpInfo = models.IpInfo{
+				IpApiMsg:       apiMsg,
+				ExpirationTime: now.Add(defaultIpTTL),
+				Provider:       provider,
+				Source:         models.LocationSourceIpApi,
+				ResolvedAt:     now,
+			}
+		}
+	}
+	return
+}
+
+// parseRateLimit reads the rate limit headers of the IP-API response, returning the delay
+// to respect before the next request, or TooManyRequestError if the limit was already exceeded.
+func parseRateLimit(resp *http.Response) (delay time.Duration, attemptsLeft int, err error) {
+	timeLeft, _ := strconv.Atoi(resp.Header.Get("X-Ttl"))
+	// check if the error that we are receiving means that we exeeded the request limit
+	if resp.StatusCode == http.StatusTooManyRequests {
+		log.Debugf("limit of requests per minute has been exeeded, wait for next call %d secs", timeLeft)
+		err = TooManyRequestError
+		delay = time.Duration(timeLeft) * time.Second
+		return
+	}
+
+	// Check the attempts left that we have to call the api
+	attemptsLeft, _ = strconv.Atoi(resp.Header.Get("X-Rl"))
+	if attemptsLeft <= 0 {
+		// if there are no more attempts left against the api, check how much time do we have to wait
+		// until we can call it again
+		// set the delayTime that we return to the given seconds to wait
+		delay = time.Duration(timeLeft) * time.Second
+	}
 	return
 }
 
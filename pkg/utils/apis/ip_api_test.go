@@ -0,0 +1,376 @@
+package apis
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/migalabs/armiarma/pkg/db/models"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeDBWriter struct {
+	m       sync.Mutex
+	located map[string]models.IpInfo
+	checks  int
+}
+
+func newFakeDBWriter() *fakeDBWriter {
+	return &fakeDBWriter{
+		located: make(map[string]models.IpInfo),
+	}
+}
+
+func (db *fakeDBWriter) PersistIpInfo(ipInfo models.IpInfo) error {
+	db.m.Lock()
+	defer db.m.Unlock()
+	db.located[ipInfo.IP] = ipInfo
+	return nil
+}
+
+func (db *fakeDBWriter) ReadIpInfo(ip string) (models.IpInfo, error) {
+	db.m.Lock()
+	defer db.m.Unlock()
+	ipInfo, ok := db.located[ip]
+	if !ok {
+		return models.IpInfo{}, fmt.Errorf("ip %s not located", ip)
+	}
+	return ipInfo, nil
+}
+
+// CheckIpRecords reports the located ips as cached (never expired)
+func (db *fakeDBWriter) CheckIpRecords(ip string) (bool, bool, error) {
+	db.m.Lock()
+	defer db.m.Unlock()
+	db.checks++
+	_, ok := db.located[ip]
+	return ok, false, nil
+}
+
+func (db *fakeDBWriter) GetExpiredIpInfo() ([]string, error) { return nil, nil }
+
+func (db *fakeDBWriter) Located() map[string]models.IpInfo {
+	db.m.Lock()
+	defer db.m.Unlock()
+	located := make(map[string]models.IpInfo, len(db.located))
+	for ip, ipInfo := range db.located {
+		located[ip] = ipInfo
+	}
+	return located
+}
+
+// fakeIpApi serves the single and batch endpoints of IP-API, locating each ip in "city-<ip>".
+type fakeIpApi struct {
+	batchFails   bool
+	batchCalls   int32
+	batchSizes   chan int
+	singleCalls  int32
+	unlocatedIps map[string]bool
+	// full answers of some ips
+	ipMsgs map[string]models.IpApiMsg
+	// answer every request with HTTP 429, or after the given delay
+	rateLimited bool
+	delay       time.Duration
+	// arrival of every request
+	m         sync.Mutex
+	callTimes []time.Time
+}
+
+func (f *fakeIpApi) CallTimes() []time.Time {
+	f.m.Lock()
+	defer f.m.Unlock()
+	return append([]time.Time{}, f.callTimes...)
+}
+
+func (f *fakeIpApi) ipMsg(ip string) models.IpApiMsg {
+	if f.unlocatedIps[ip] {
+		return models.IpApiMsg{IP: ip, Status: "fail"}
+	}
+	if msg, ok := f.ipMsgs[ip]; ok {
+		return msg
+	}
+	return models.IpApiMsg{IP: ip, Status: "success", Country: "Testland", CountryCode: "TL", City: "city-" + ip}
+}
+
+func (f *fakeIpApi) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.m.Lock()
+	f.callTimes = append(f.callTimes, time.Now())
+	f.m.Unlock()
+	w.Header().Set("X-Rl", "14")
+	w.Header().Set("X-Ttl", "60")
+	time.Sleep(f.delay)
+	if f.rateLimited {
+		w.WriteHeader(http.StatusTooManyRequests)
+		return
+	}
+	switch {
+	case r.URL.Path == "/batch":
+		atomic.AddInt32(&f.batchCalls, 1)
+		if f.batchFails {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		var ips []string
+		if err := json.NewDecoder(r.Body).Decode(&ips); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		f.batchSizes <- len(ips)
+		// answer in reverse order, the results have to be matched by ip
+		msgs := make([]models.IpApiMsg, 0, len(ips))
+		for i := len(ips) - 1; i >= 0; i-- {
+			msgs = append(msgs, f.ipMsg(ips[i]))
+		}
+		json.NewEncoder(w).Encode(msgs)
+
+	case strings.HasPrefix(r.URL.Path, "/json/"):
+		atomic.AddInt32(&f.singleCalls, 1)
+		json.NewEncoder(w).Encode(f.ipMsg(strings.TrimPrefix(r.URL.Path, "/json/")))
+
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func newTestIpLocator(ctx context.Context, srv *httptest.Server, db DBWriter, opts ...IpLocatorOption) *IpLocator {
+	ipLocator, err := NewIpLocator(ctx, db, opts...)
+	if err != nil {
+		panic(err)
+	}
+	setTestEndpoints(ipLocator, srv)
+	return ipLocator
+}
+
+// setTestEndpoints points the locator to the fake IP-API server.
+func setTestEndpoints(ipLocator *IpLocator, srv *httptest.Server) {
+	ipLocator.endpoint = srv.URL + "/json/{__ip__}"
+	ipLocator.batchEndpoint = srv.URL + "/batch"
+	ipLocator.httpClient = srv.Client()
+}
+
+func testIps(n int) []string {
+	ips := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		ips = append(ips, fmt.Sprintf("10.0.%d.%d", i/100, i%100))
+	}
+	return ips
+}
+
+func TestIpLocatorBatches(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	api := &fakeIpApi{batchSizes: make(chan int, 10)}
+	srv := httptest.NewServer(api)
+	defer srv.Close()
+
+	db := newFakeDBWriter()
+	ipLocator := newTestIpLocator(ctx, srv, db)
+	ips := testIps(150)
+	for _, ip := range ips {
+		ipLocator.LocateIP(ip)
+	}
+	ipLocator.Run()
+
+	// a full batch, and the remaining ones once the window expires
+	require.Equal(t, ipApiBatchSize, <-api.batchSizes)
+	require.Equal(t, 50, <-api.batchSizes)
+	require.Eventually(t, func() bool {
+		return len(db.Located()) == len(ips)
+	}, 5*time.Second, 50*time.Millisecond)
+
+	located := db.Located()
+	for _, ip := range ips {
+		require.Equal(t, "city-"+ip, located[ip].City)
+		require.Equal(t, "TL", located[ip].CountryCode)
+	}
+	require.Equal(t, int32(2), atomic.LoadInt32(&api.batchCalls))
+	require.Equal(t, int32(0), atomic.LoadInt32(&api.singleCalls))
+	// each batch is a single request for the rate limit
+	require.Equal(t, int32(2), atomic.LoadInt32(ipLocator.apiCalls))
+}
+
+func TestIpLocatorBatchFallback(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	api := &fakeIpApi{batchFails: true, batchSizes: make(chan int, 10)}
+	srv := httptest.NewServer(api)
+	defer srv.Close()
+
+	db := newFakeDBWriter()
+	ipLocator := newTestIpLocator(ctx, srv, db)
+	ips := testIps(5)
+	for _, ip := range ips {
+		ipLocator.LocateIP(ip)
+	}
+	ipLocator.Run()
+
+	require.Eventually(t, func() bool {
+		return len(db.Located()) == len(ips)
+	}, 5*time.Second, 50*time.Millisecond)
+	require.Equal(t, int32(1), atomic.LoadInt32(&api.batchCalls))
+	require.Equal(t, int32(len(ips)), atomic.LoadInt32(&api.singleCalls))
+}
+
+func TestCallIpApiBatchRouting(t *testing.T) {
+	api := &fakeIpApi{
+		batchSizes:   make(chan int, 10),
+		unlocatedIps: map[string]bool{"10.0.0.1": true},
+	}
+	srv := httptest.NewServer(api)
+	defer srv.Close()
+
+	ips := []string{"10.0.0.0", "10.0.0.1", "10.0.0.2"}
+	resps, delay, attemptsLeft, err := callIpApiBatch(context.Background(), srv.Client(), srv.URL+"/batch", ips)
+	require.NoError(t, err)
+	require.Equal(t, time.Duration(0), delay)
+	require.Equal(t, 14, attemptsLeft)
+	require.Equal(t, len(ips), len(resps))
+
+	require.NoError(t, resps[0].Err)
+	require.Equal(t, "city-10.0.0.0", resps[0].IpInfo.City)
+	require.Equal(t, "TL", resps[0].IpInfo.CountryCode)
+	require.Error(t, resps[1].Err)
+	require.NoError(t, resps[2].Err)
+	require.Equal(t, "city-10.0.0.2", resps[2].IpInfo.City)
+}
+
+func TestResolveIP(t *testing.T) {
+	api := &fakeIpApi{
+		unlocatedIps: map[string]bool{"10.0.0.1": true},
+		ipMsgs: map[string]models.IpApiMsg{
+			"95.217.33.10": {
+				IP: "95.217.33.10", Status: "success",
+				Country: "Finland", CountryCode: "FI", City: "Helsinki", Lat: 60.1719, Lon: 24.9347,
+				Isp: "Hetzner Online GmbH", Org: "Hetzner", As: "AS24940 Hetzner Online GmbH", AsName: "HETZNER-AS",
+				Hosting: true,
+			},
+		},
+	}
+	srv := httptest.NewServer(api)
+	defer srv.Close()
+	ipLocator := newTestIpLocator(context.Background(), srv, newFakeDBWriter())
+
+	before := time.Now().UTC()
+	loc, err := ipLocator.ResolveIP(context.Background(), "95.217.33.10")
+	require.NoError(t, err)
+	require.False(t, loc.ResolvedAt.Before(before))
+	loc.ResolvedAt = time.Time{}
+	require.Equal(t, models.Location{
+		Country: "Finland", CountryCode: "FI", City: "Helsinki", Lat: 60.1719, Lon: 24.9347,
+		ASN: "AS24940", ASOrg: "Hetzner Online GmbH", ISP: "Hetzner Online GmbH", Hosting: true,
+		Source: models.LocationSourceIpApi,
+	}, loc)
+
+	// the provider only gave the country and the city
+	loc, err = ipLocator.ResolveIP(context.Background(), "10.0.0.2")
+	require.NoError(t, err)
+	require.Equal(t, "city-10.0.0.2", loc.City)
+	require.Equal(t, "TL", loc.CountryCode)
+	require.Empty(t, loc.ASN)
+	require.Empty(t, loc.ASOrg)
+	require.Equal(t, models.LocationSourceIpApi, loc.Source)
+
+	_, err = ipLocator.ResolveIP(context.Background(), "10.0.0.1")
+	require.ErrorIs(t, err, ErrIpNotLocated)
+	require.Equal(t, int64(2), ipLocator.Stats().Successes)
+	require.Equal(t, int64(1), ipLocator.Stats().Errors[LocateErrorNotFound])
+}
+
+func TestIpLocatorRateLimit(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	api := &fakeIpApi{batchSizes: make(chan int, 10)}
+	srv := httptest.NewServer(api)
+	defer srv.Close()
+
+	// 2 requests every 500ms, one ip per request
+	db := newFakeDBWriter()
+	per := 500 * time.Millisecond
+	ipLocator := newTestIpLocator(ctx, srv, db, WithRateLimit(2, 2, per))
+	ipLocator.batchSize = 1
+	ips := testIps(6)
+	for _, ip := range ips {
+		ipLocator.LocateIP(ip)
+	}
+	ipLocator.Run()
+
+	// the lookups beyond the quota are delayed, not failed
+	require.Eventually(t, func() bool {
+		return len(db.Located()) == len(ips)
+	}, 5*time.Second, 50*time.Millisecond)
+	require.Zero(t, ipLocator.Stats().TotalErrors())
+
+	callTimes := api.CallTimes()
+	require.Len(t, callTimes, len(ips))
+	for i := 2; i < len(callTimes); i++ {
+		// no more than 2 requests in any window of the period (with some slack for the network)
+		require.GreaterOrEqual(t, int64(callTimes[i].Sub(callTimes[i-2])), int64(per-20*time.Millisecond), "request %d", i)
+	}
+}
+
+func TestIpLocatorCache(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	api := &fakeIpApi{batchSizes: make(chan int, 10)}
+	srv := httptest.NewServer(api)
+	defer srv.Close()
+
+	db := newFakeDBWriter()
+	ttl := 24 * time.Hour
+	ipLocator := newTestIpLocator(ctx, srv, db, WithIpTTL(ttl))
+	var m sync.Mutex
+	notified := make([]string, 0)
+	ipLocator.OnLocated(func(ipInfo models.IpInfo) {
+		m.Lock()
+		defer m.Unlock()
+		notified = append(notified, ipInfo.IP)
+	})
+	ipLocator.Run()
+
+	ip := "10.0.0.1"
+	ipLocator.LocateIP(ip)
+	require.Eventually(t, func() bool {
+		return len(db.Located()) == 1
+	}, 5*time.Second, 50*time.Millisecond)
+	located := db.Located()[ip]
+	require.Equal(t, located.ResolvedAt.Add(ttl), located.ExpirationTime)
+
+	// the repeated ips hit the cache, without reaching the DB nor the provider
+	checks := db.checks
+	for i := 0; i < 5; i++ {
+		ipLocator.LocateIP(ip)
+	}
+	require.Equal(t, checks, db.checks)
+	require.Equal(t, int64(5), ipLocator.Stats().CacheHits)
+	require.Equal(t, 1, ipLocator.Stats().CachedIps)
+	require.Equal(t, int32(1), atomic.LoadInt32(&api.batchCalls))
+
+	// after a restart, the located ips are read from the DB once
+	restarted := newTestIpLocator(ctx, srv, db)
+	restarted.OnLocated(func(ipInfo models.IpInfo) {
+		m.Lock()
+		defer m.Unlock()
+		notified = append(notified, ipInfo.IP)
+	})
+	restarted.LocateIP(ip)
+	restarted.LocateIP(ip)
+	require.Equal(t, checks+1, db.checks)
+	require.Equal(t, int64(2), restarted.Stats().CacheHits)
+	require.Equal(t, int32(1), atomic.LoadInt32(&api.batchCalls))
+
+	// and the waiting peers are notified both times
+	m.Lock()
+	defer m.Unlock()
+	require.Equal(t, []string{ip, ip}, notified)
+}
@@ -0,0 +1,49 @@
+package apis
+
+import (
+	"sync"
+	"time"
+
+	"github.com/migalabs/armiarma/pkg/db/models"
+)
+
+// ipCache keeps in memory the located IPs, so that the repeated ones don't reach the DB.
+type ipCache struct {
+	m   sync.RWMutex
+	ips map[string]models.IpInfo
+}
+
+func newIpCache() *ipCache {
+	return &ipCache{
+		ips: make(map[string]models.IpInfo),
+	}
+}
+
+// get returns the location of the IP, if it is cached and it didn't expire at the given time.
+func (c *ipCache) get(ip string, now time.Time) (models.IpInfo, bool) {
+	c.m.RLock()
+	defer c.m.RUnlock()
+	ipInfo, ok := c.ips[ip]
+	if !ok || ipInfoExpired(ipInfo, now) {
+		return models.IpInfo{}, false
+	}
+	return ipInfo, true
+}
+
+func (c *ipCache) add(ipInfo models.IpInfo) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.ips[ipInfo.IP] = ipInfo
+}
+
+func (c *ipCache) len() int {
+	c.m.RLock()
+	defer c.m.RUnlock()
+	return len(c.ips)
+}
+
+// ipInfoExpired returns whether the location of the IP has to be resolved again: its TTL expired,
+// or it was stored without a country code.
+func ipInfoExpired(ipInfo models.IpInfo, now time.Time) bool {
+	return ipInfo.CountryCode == "" || !ipInfo.ExpirationTime.After(now)
+}
@@ -0,0 +1,139 @@
+package apis
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// categories of the errors of the geolocation provider
+const (
+	LocateErrorRateLimited = "rate-limited"
+	LocateErrorTimeout     = "timeout"
+	LocateErrorNotFound    = "not-found"
+	LocateErrorOther       = "other"
+)
+
+// LocateErrorCategories lists all the categories of the errors of the geolocation provider.
+var LocateErrorCategories = []string{
+	LocateErrorRateLimited,
+	LocateErrorTimeout,
+	LocateErrorNotFound,
+	LocateErrorOther,
+}
+
+const statsLogInterval = 5 * time.Minute
+
+// ErrIpNotLocated is returned when the provider answered, but it couldn't locate the IP
+// (i.e. private or reserved ranges).
+var ErrIpNotLocated = errors.New("ip couldn't be located")
+
+// LocatorStats are the counters of the IpLocator since it started.
+type LocatorStats struct {
+	// IPs requested to be located, and the ones that were already located (and not expired)
+	Lookups   int64
+	CacheHits int64
+	// IPs located by the provider
+	Successes int64
+	// failed responses of the provider (for a single IP, or for a whole batch), by category
+	Errors map[string]int64
+	// IPs waiting to be located
+	QueueDepth int
+	// IPs located in memory
+	CachedIps int
+}
+
+// TotalErrors returns the number of errors of all the categories.
+func (s LocatorStats) TotalErrors() int64 {
+	var total int64
+	for _, cnt := range s.Errors {
+		total += cnt
+	}
+	return total
+}
+
+// locatorCounters are updated atomically by the locating routines.
+type locatorCounters struct {
+	lookups     int64
+	cacheHits   int64
+	successes   int64
+	rateLimited int64
+	timeouts    int64
+	notFound    int64
+	otherErrors int64
+}
+
+// ClassifyLocateError returns the category of an error locating IPs.
+func ClassifyLocateError(err error) string {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, TooManyRequestError):
+		return LocateErrorRateLimited
+	case errors.Is(err, ErrIpNotLocated):
+		return LocateErrorNotFound
+	case errors.Is(err, context.DeadlineExceeded),
+		errors.As(err, &netErr) && netErr.Timeout():
+		return LocateErrorTimeout
+	default:
+		return LocateErrorOther
+	}
+}
+
+// recordError counts an error of the provider in its category.
+func (c *IpLocator) recordError(err error) {
+	switch ClassifyLocateError(err) {
+	case LocateErrorRateLimited:
+		atomic.AddInt64(&c.counters.rateLimited, 1)
+	case LocateErrorTimeout:
+		atomic.AddInt64(&c.counters.timeouts, 1)
+	case LocateErrorNotFound:
+		atomic.AddInt64(&c.counters.notFound, 1)
+	default:
+		atomic.AddInt64(&c.counters.otherErrors, 1)
+	}
+}
+
+// Stats returns the current counters of the locator.
+func (c *IpLocator) Stats() LocatorStats {
+	return LocatorStats{
+		Lookups:   atomic.LoadInt64(&c.counters.lookups),
+		CacheHits: atomic.LoadInt64(&c.counters.cacheHits),
+		Successes: atomic.LoadInt64(&c.counters.successes),
+		Errors: map[string]int64{
+			LocateErrorRateLimited: atomic.LoadInt64(&c.counters.rateLimited),
+			LocateErrorTimeout:     atomic.LoadInt64(&c.counters.timeouts),
+			LocateErrorNotFound:    atomic.LoadInt64(&c.counters.notFound),
+			LocateErrorOther:       atomic.LoadInt64(&c.counters.otherErrors),
+		},
+		QueueDepth: c.ipQueue.Len(),
+		CachedIps:  c.cache.len(),
+	}
+}
+
+// logStatsSummary logs a single line with the counters if there was any error since the last summary,
+// returning whether it did.
+func (c *IpLocator) logStatsSummary() bool {
+	stats := c.Stats()
+	totalErrors := stats.TotalErrors()
+	newErrors := totalErrors - c.loggedErrors
+	c.loggedErrors = totalErrors
+	if newErrors <= 0 {
+		return false
+	}
+	log.WithFields(log.Fields{
+		"lookups":     stats.Lookups,
+		"cache-hits":  stats.CacheHits,
+		"located":     stats.Successes,
+		"new-errors":  newErrors,
+		"rate-limit":  stats.Errors[LocateErrorRateLimited],
+		"timeout":     stats.Errors[LocateErrorTimeout],
+		"not-found":   stats.Errors[LocateErrorNotFound],
+		"other":       stats.Errors[LocateErrorOther],
+		"queue-depth": stats.QueueDepth,
+	}).Warn("ip locator errors")
+	return true
+}
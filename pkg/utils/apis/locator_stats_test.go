@@ -0,0 +1,90 @@
+package apis
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClassifyLocateError(t *testing.T) {
+	require.Equal(t, LocateErrorRateLimited, ClassifyLocateError(TooManyRequestError))
+	require.Equal(t, LocateErrorNotFound, ClassifyLocateError(errors.Wrap(ErrIpNotLocated, "status fail")))
+	require.Equal(t, LocateErrorTimeout, ClassifyLocateError(errors.Wrap(context.DeadlineExceeded, "unable to locate IP")))
+	require.Equal(t, LocateErrorOther, ClassifyLocateError(errors.New("batch request failed with HTTP 500")))
+}
+
+func TestLocatorStats(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	api := &fakeIpApi{
+		batchSizes:   make(chan int, 10),
+		unlocatedIps: map[string]bool{"10.0.0.1": true},
+	}
+	srv := httptest.NewServer(api)
+	defer srv.Close()
+	db := newFakeDBWriter()
+	ipLocator := newTestIpLocator(ctx, srv, db)
+
+	// success and not-found, through the batch and the single endpoints
+	_, ok := ipLocator.locateBatch([]string{"10.0.0.0", "10.0.0.1"})
+	require.True(t, ok)
+	_, ok = ipLocator.locateSingle("10.0.0.1")
+	require.True(t, ok)
+	_, ok = ipLocator.locateSingle("10.0.0.2")
+	require.True(t, ok)
+
+	// the located ips are cache hits, the rest gets queued
+	for _, ip := range []string{"10.0.0.0", "10.0.0.2", "10.0.0.3", "10.0.0.4"} {
+		ipLocator.LocateIP(ip)
+	}
+
+	stats := ipLocator.Stats()
+	require.Equal(t, int64(4), stats.Lookups)
+	require.Equal(t, int64(2), stats.CacheHits)
+	require.Equal(t, int64(2), stats.Successes)
+	require.Equal(t, int64(2), stats.Errors[LocateErrorNotFound])
+	require.Equal(t, int64(2), stats.TotalErrors())
+	require.Equal(t, 2, stats.QueueDepth)
+
+	// the summary is only logged when new errors occurred
+	require.True(t, ipLocator.logStatsSummary())
+	require.False(t, ipLocator.logStatsSummary())
+
+	// provider outage: the failed batch falls back to the single endpoint
+	outageSrv := httptest.NewServer(&fakeIpApi{batchFails: true})
+	defer outageSrv.Close()
+	setTestEndpoints(ipLocator, outageSrv)
+	_, ok = ipLocator.locateBatch([]string{"10.0.0.5"})
+	require.True(t, ok)
+	// and a provider that doesn't answer in time
+	slowSrv := httptest.NewServer(&fakeIpApi{delay: 200 * time.Millisecond})
+	defer slowSrv.Close()
+	setTestEndpoints(ipLocator, slowSrv)
+	ipLocator.httpClient.Timeout = 50 * time.Millisecond
+	_, ok = ipLocator.locateSingle("10.0.0.6")
+	require.True(t, ok)
+
+	stats = ipLocator.Stats()
+	require.Equal(t, int64(3), stats.Successes)
+	require.Equal(t, int64(1), stats.Errors[LocateErrorOther])
+	require.Equal(t, int64(1), stats.Errors[LocateErrorTimeout])
+	require.Equal(t, int64(4), stats.TotalErrors())
+	require.True(t, ipLocator.logStatsSummary())
+
+	// rate limited (the locator waits for the limit until the context dies)
+	limitedSrv := httptest.NewServer(&fakeIpApi{rateLimited: true})
+	defer limitedSrv.Close()
+	limitedCtx, limitedCancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer limitedCancel()
+	limitedLocator := newTestIpLocator(limitedCtx, limitedSrv, db)
+	_, ok = limitedLocator.locateSingle("10.0.0.7")
+	require.False(t, ok)
+	stats = limitedLocator.Stats()
+	require.Equal(t, int64(1), stats.Errors[LocateErrorRateLimited])
+	require.Equal(t, int64(0), stats.Successes)
+}
@@ -0,0 +1,143 @@
+package apis
+
+import (
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/migalabs/armiarma/pkg/db/models"
+	"github.com/migalabs/armiarma/pkg/utils/providers"
+	"github.com/oschwald/maxminddb-golang"
+	"github.com/pkg/errors"
+)
+
+// LocationProvider resolves where an IP is located.
+type LocationProvider interface {
+	Resolve(ip string) (country, countryCode, city string, err error)
+}
+
+var (
+	_ LocationProvider = (*IpLocator)(nil)
+	_ LocationProvider = (*MaxMindDB)(nil)
+)
+
+// MaxMindDB locates the IPs offline, from a local MaxMind GeoLite2-City (or GeoIP2-City) database.
+type MaxMindDB struct {
+	path   string
+	reader *maxminddb.Reader
+}
+
+// mmdbCity is the part of the records of the City databases that the IpInfo keeps.
+type mmdbCity struct {
+	City struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+	Continent struct {
+		Code  string            `maxminddb:"code"`
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"continent"`
+	Country struct {
+		IsoCode string            `maxminddb:"iso_code"`
+		Names   map[string]string `maxminddb:"names"`
+	} `maxminddb:"country"`
+	Location struct {
+		Latitude  float64 `maxminddb:"latitude"`
+		Longitude float64 `maxminddb:"longitude"`
+	} `maxminddb:"location"`
+	Postal struct {
+		Code string `maxminddb:"code"`
+	} `maxminddb:"postal"`
+	Subdivisions []struct {
+		IsoCode string            `maxminddb:"iso_code"`
+		Names   map[string]string `maxminddb:"names"`
+	} `maxminddb:"subdivisions"`
+}
+
+// NewMaxMindDB opens the City database at the given path.
+func NewMaxMindDB(path string) (*MaxMindDB, error) {
+	if path == "" {
+		return nil, errors.New("no path given for the MaxMind database")
+	}
+	if _, err := os.Stat(path); err != nil {
+		return nil, errors.Wrap(err, "unable to find the MaxMind database")
+	}
+	reader, err := maxminddb.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to open the MaxMind database "+path)
+	}
+	// the Country, ASN... databases don't have the cities
+	if !strings.HasSuffix(reader.Metadata.DatabaseType, "-City") {
+		reader.Close()
+		return nil, errors.Errorf("%s is a %s database, while a GeoLite2-City or GeoIP2-City one is needed", path, reader.Metadata.DatabaseType)
+	}
+	return &MaxMindDB{
+		path:   path,
+		reader: reader,
+	}, nil
+}
+
+// Resolve returns where the IP is, or the PrivateCountry if it is a private one.
+func (m *MaxMindDB) Resolve(ip string) (country, countryCode, city string, err error) {
+	ipInfo, err := m.locate(ip)
+	if err != nil {
+		return "", "", "", err
+	}
+	return ipInfo.Country, ipInfo.CountryCode, ipInfo.City, nil
+}
+
+// locate returns the IpInfo of the IP, filled as the IP-API one would be (with the english names).
+// The database doesn't have the ISP, AS and hosting fields, so they are left empty.
+func (m *MaxMindDB) locate(ip string) (models.IpInfo, error) {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return models.IpInfo{}, errors.Wrap(ErrIpNotLocated, "invalid ip "+ip)
+	}
+	now := time.Now().UTC()
+	ipInfo := models.IpInfo{
+		IpApiMsg: models.IpApiMsg{
+			IP:     ip,
+			Status: "success",
+		},
+		ExpirationTime: now.Add(defaultIpTTL),
+		Source:         models.LocationSourceMaxMind,
+		ResolvedAt:     now,
+	}
+	ipInfo.Provider, _ = providers.LookupProvider(ip)
+	if isPrivateIP(parsed) {
+		ipInfo.Country = models.PrivateCountry
+		return ipInfo, nil
+	}
+
+	var record mmdbCity
+	_, ok, err := m.reader.LookupNetwork(parsed, &record)
+	if err != nil {
+		return models.IpInfo{}, errors.Wrap(err, "unable to look up ip "+ip+" in the MaxMind database")
+	}
+	if !ok {
+		return models.IpInfo{}, errors.Wrap(ErrIpNotLocated, "ip "+ip+" missing in the MaxMind database")
+	}
+	ipInfo.Continent = record.Continent.Names["en"]
+	ipInfo.ContinentCode = record.Continent.Code
+	ipInfo.Country = record.Country.Names["en"]
+	ipInfo.CountryCode = record.Country.IsoCode
+	if len(record.Subdivisions) > 0 {
+		ipInfo.Region = record.Subdivisions[0].IsoCode
+		ipInfo.RegionName = record.Subdivisions[0].Names["en"]
+	}
+	ipInfo.City = record.City.Names["en"]
+	ipInfo.Zip = record.Postal.Code
+	ipInfo.Lat = record.Location.Latitude
+	ipInfo.Lon = record.Location.Longitude
+	return ipInfo, nil
+}
+
+// Close releases the database file.
+func (m *MaxMindDB) Close() error {
+	return m.reader.Close()
+}
+
+// isPrivateIP returns true if the IP isn't a public one (RFC1918 and RFC4193 ranges, loopback, link-local...).
+func isPrivateIP(ip net.IP) bool {
+	return ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsUnspecified()
+}
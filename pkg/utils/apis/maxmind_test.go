@@ -0,0 +1,278 @@
+package apis
+
+import (
+	"context"
+	"encoding/binary"
+	"io/ioutil"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sort"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/migalabs/armiarma/pkg/db/models"
+	"github.com/stretchr/testify/require"
+)
+
+// testCityRecord is a City record of the test databases, as the ones of MaxMind's GeoIP2-City-Test.mmdb.
+func testCityRecord(city, region, regionCode, country, countryCode string, lat, lon float64) map[string]interface{} {
+	return map[string]interface{}{
+		"city":      map[string]interface{}{"names": map[string]interface{}{"en": city}},
+		"continent": map[string]interface{}{"code": "EU", "names": map[string]interface{}{"en": "Europe"}},
+		"country":   map[string]interface{}{"iso_code": countryCode, "names": map[string]interface{}{"en": country}},
+		"location":  map[string]interface{}{"latitude": lat, "longitude": lon},
+		"postal":    map[string]interface{}{"code": "OX1"},
+		"subdivisions": []interface{}{
+			map[string]interface{}{"iso_code": regionCode, "names": map[string]interface{}{"en": region}},
+		},
+	}
+}
+
+// writeTestDB writes a MaxMind DB of the given type with the given (non overlapping) IPv4 networks,
+// following https://maxmind.github.io/MaxMind-DB/, and returns its path.
+func writeTestDB(t *testing.T, dbType string, networks map[string]map[string]interface{}) string {
+	cidrs := make([]string, 0, len(networks))
+	for cidr := range networks {
+		cidrs = append(cidrs, cidr)
+	}
+	sort.Strings(cidrs)
+
+	// search tree with the left (bit 0) and right (bit 1) records of each node, which point
+	// to another node (> 0), to the data of a network (< 0, offset+1) or to nothing (0)
+	nodes := [][2]int{{}}
+	var data []byte
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		require.NoError(t, err)
+		ones, _ := network.Mask.Size()
+		ip := network.IP.To4()
+		offset := len(data)
+		data = append(data, mmdbEncode(networks[cidr])...)
+		node := 0
+		for i := 0; i < ones; i++ {
+			bit := (ip[i/8] >> (7 - i%8)) & 1
+			if i == ones-1 {
+				nodes[node][bit] = -(offset + 1)
+				break
+			}
+			if nodes[node][bit] == 0 {
+				nodes = append(nodes, [2]int{})
+				nodes[node][bit] = len(nodes) - 1
+			}
+			node = nodes[node][bit]
+		}
+	}
+
+	nodeCount := len(nodes)
+	var db []byte
+	for _, node := range nodes {
+		for _, record := range node {
+			value := nodeCount
+			switch {
+			case record > 0:
+				value = record
+			case record < 0:
+				value = nodeCount + dataSectionSeparator + (-record - 1)
+			}
+			// 24 bits records
+			db = append(db, byte(value>>16), byte(value>>8), byte(value))
+		}
+	}
+	db = append(db, make([]byte, dataSectionSeparator)...)
+	db = append(db, data...)
+	db = append(db, "\xAB\xCD\xEFMaxMind.com"...)
+	db = append(db, mmdbEncode(map[string]interface{}{
+		"binary_format_major_version": uint16(2),
+		"binary_format_minor_version": uint16(0),
+		"build_epoch":                 uint64(time.Now().Unix()),
+		"database_type":               dbType,
+		"description":                 map[string]interface{}{"en": "armiarma test database"},
+		"ip_version":                  uint16(4),
+		"languages":                   []interface{}{"en"},
+		"node_count":                  uint32(nodeCount),
+		"record_size":                 uint16(24),
+	})...)
+
+	path := filepath.Join(t.TempDir(), dbType+"-Test.mmdb")
+	require.NoError(t, ioutil.WriteFile(path, db, 0644))
+	return path
+}
+
+const dataSectionSeparator = 16
+
+// mmdbEncode encodes the value in the data section format of the MaxMind DBs.
+func mmdbEncode(v interface{}) []byte {
+	switch v := v.(type) {
+	case string:
+		return append(mmdbControl(2, len(v)), v...)
+	case float64:
+		return append(mmdbControl(3, 8), mmdbUint(math.Float64bits(v), 8)...)
+	case uint16:
+		return append(mmdbControl(5, 2), mmdbUint(uint64(v), 2)...)
+	case uint32:
+		return append(mmdbControl(6, 4), mmdbUint(uint64(v), 4)...)
+	case uint64:
+		return append(mmdbControl(9, 8), mmdbUint(v, 8)...)
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		b := mmdbControl(7, len(v))
+		for _, key := range keys {
+			b = append(b, mmdbEncode(key)...)
+			b = append(b, mmdbEncode(v[key])...)
+		}
+		return b
+	case []interface{}:
+		b := mmdbControl(11, len(v))
+		for _, item := range v {
+			b = append(b, mmdbEncode(item)...)
+		}
+		return b
+	default:
+		panic("unsupported mmdb type")
+	}
+}
+
+// mmdbUint returns the size bytes of v, big-endian.
+func mmdbUint(v uint64, size int) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	return b[8-size:]
+}
+
+// mmdbControl returns the control byte(s) of a field of the given type and size (up to 284).
+func mmdbControl(typ, size int) []byte {
+	b := []byte{byte(typ << 5)}
+	if typ > 7 {
+		// extended types
+		b = []byte{0, byte(typ - 7)}
+	}
+	if size < 29 {
+		b[0] |= byte(size)
+		return b
+	}
+	b[0] |= 29
+	return append(b, byte(size-29))
+}
+
+func testCityDB(t *testing.T) string {
+	return writeTestDB(t, "GeoIP2-City", map[string]map[string]interface{}{
+		"81.2.69.160/27":   testCityRecord("London", "England", "ENG", "United Kingdom", "GB", 51.5142, -0.0931),
+		"2.125.160.216/29": testCityRecord("Boxford", "England", "ENG", "United Kingdom", "GB", 51.75, -1.25),
+		"89.160.20.112/28": testCityRecord("Linköping", "Östergötland County", "E", "Sweden", "SE", 58.4167, 15.6167),
+	})
+}
+
+func TestNewMaxMindDB(t *testing.T) {
+	_, err := NewMaxMindDB("")
+	require.Error(t, err)
+
+	_, err = NewMaxMindDB(filepath.Join(t.TempDir(), "missing.mmdb"))
+	require.Error(t, err)
+
+	notMmdb := filepath.Join(t.TempDir(), "GeoLite2-City.mmdb")
+	require.NoError(t, ioutil.WriteFile(notMmdb, []byte("not a maxmind db"), 0644))
+	_, err = NewMaxMindDB(notMmdb)
+	require.Error(t, err)
+
+	// the country databases don't have the cities
+	_, err = NewMaxMindDB(writeTestDB(t, "GeoLite2-Country", map[string]map[string]interface{}{}))
+	require.Error(t, err)
+
+	mmdb, err := NewMaxMindDB(testCityDB(t))
+	require.NoError(t, err)
+	require.NoError(t, mmdb.Close())
+}
+
+func TestMaxMindDBResolve(t *testing.T) {
+	mmdb, err := NewMaxMindDB(testCityDB(t))
+	require.NoError(t, err)
+	defer mmdb.Close()
+
+	country, countryCode, city, err := mmdb.Resolve("81.2.69.170")
+	require.NoError(t, err)
+	require.Equal(t, "United Kingdom", country)
+	require.Equal(t, "GB", countryCode)
+	require.Equal(t, "London", city)
+
+	// the IpInfo is filled as the IP-API one
+	ipInfo, err := mmdb.locate("89.160.20.120")
+	require.NoError(t, err)
+	require.Equal(t, models.IpApiMsg{
+		IP:            "89.160.20.120",
+		Status:        "success",
+		Continent:     "Europe",
+		ContinentCode: "EU",
+		Country:       "Sweden",
+		CountryCode:   "SE",
+		Region:        "E",
+		RegionName:    "Östergötland County",
+		City:          "Linköping",
+		Zip:           "OX1",
+		Lat:           58.4167,
+		Lon:           15.6167,
+	}, ipInfo.IpApiMsg)
+	require.Equal(t, models.LocationSourceMaxMind, ipInfo.Source)
+	require.False(t, ipInfo.ResolvedAt.IsZero())
+
+	// the private ones aren't an error
+	for _, ip := range []string{"10.0.0.1", "172.16.5.4", "192.168.1.1", "127.0.0.1", "fd00::1"} {
+		country, countryCode, city, err = mmdb.Resolve(ip)
+		require.NoError(t, err, ip)
+		require.Equal(t, models.PrivateCountry, country, ip)
+		require.Empty(t, countryCode, ip)
+		require.Empty(t, city, ip)
+	}
+
+	_, _, _, err = mmdb.Resolve("8.8.8.8")
+	require.ErrorIs(t, err, ErrIpNotLocated)
+	_, _, _, err = mmdb.Resolve("not-an-ip")
+	require.ErrorIs(t, err, ErrIpNotLocated)
+}
+
+func TestIpLocatorMaxMind(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// no request reaches IP-API
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected IP-API request %s", r.URL)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	db := newFakeDBWriter()
+	ipLocator := newTestIpLocator(ctx, srv, db, WithMaxMindDB(testCityDB(t)))
+	defer ipLocator.Close()
+	ips := []string{"81.2.69.161", "2.125.160.217", "192.168.0.10", "8.8.8.8"}
+	for _, ip := range ips {
+		ipLocator.LocateIP(ip)
+	}
+	ipLocator.Run()
+
+	require.Eventually(t, func() bool {
+		return len(db.Located()) == 3
+	}, 5*time.Second, 50*time.Millisecond)
+	located := db.Located()
+	require.Equal(t, "London", located["81.2.69.161"].City)
+	require.Equal(t, "Boxford", located["2.125.160.217"].City)
+	require.Equal(t, models.PrivateCountry, located["192.168.0.10"].Country)
+	for _, ipInfo := range located {
+		require.Equal(t, models.LocationSourceMaxMind, ipInfo.Source)
+	}
+	require.Eventually(t, func() bool {
+		return ipLocator.Stats().Errors[LocateErrorNotFound] == 1
+	}, 5*time.Second, 50*time.Millisecond)
+
+	country, _, _, err := ipLocator.Resolve("81.2.69.162")
+	require.NoError(t, err)
+	require.Equal(t, "United Kingdom", country)
+	require.Equal(t, int32(0), atomic.LoadInt32(ipLocator.apiCalls))
+}
@@ -0,0 +1,80 @@
+package apis
+
+import (
+	"github.com/migalabs/armiarma/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	modName    = "ip_locator"
+	modDetails = "geolocation of the IPs of the peers"
+
+	// List of metrics that we are going to export
+	LocatorLookups = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: modName,
+		Name:      "lookups",
+		Help:      "Number of IPs requested to be located",
+	})
+	LocatorCacheHits = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: modName,
+		Name:      "cache_hits",
+		Help:      "Number of requested IPs that were already located",
+	})
+	LocatorSuccesses = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: modName,
+		Name:      "provider_successes",
+		Help:      "Number of IPs located by the geolocation provider",
+	})
+	LocatorErrors = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: modName,
+		Name:      "provider_errors",
+		Help:      "Number of failed responses of the geolocation provider by category",
+	},
+		[]string{"category"},
+	)
+	LocatorQueueDepth = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: modName,
+		Name:      "queue_depth",
+		Help:      "Number of IPs waiting to be located",
+	})
+)
+
+func (c *IpLocator) GetMetrics() *metrics.MetricsModule {
+	metricsMod := metrics.NewMetricsModule(
+		modName,
+		modDetails,
+	)
+	metricsMod.AddIndvMetric(c.locatorStatsMetrics())
+	return metricsMod
+}
+
+func (c *IpLocator) locatorStatsMetrics() *metrics.IndvMetrics {
+	initFn := func() error {
+		prometheus.MustRegister(LocatorLookups)
+		prometheus.MustRegister(LocatorCacheHits)
+		prometheus.MustRegister(LocatorSuccesses)
+		prometheus.MustRegister(LocatorErrors)
+		prometheus.MustRegister(LocatorQueueDepth)
+		return nil
+	}
+	updateFn := func() (interface{}, error) {
+		stats := c.Stats()
+		LocatorLookups.Set(float64(stats.Lookups))
+		LocatorCacheHits.Set(float64(stats.CacheHits))
+		LocatorSuccesses.Set(float64(stats.Successes))
+		for category, cnt := range stats.Errors {
+			LocatorErrors.WithLabelValues(category).Set(float64(cnt))
+		}
+		LocatorQueueDepth.Set(float64(stats.QueueDepth))
+		return stats, nil
+	}
+	locatorStats, err := metrics.NewIndvMetrics(
+		"locator_stats",
+		initFn,
+		updateFn,
+	)
+	if err != nil {
+		return nil
+	}
+	return locatorStats
+}
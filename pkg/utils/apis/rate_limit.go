@@ -0,0 +1,50 @@
+package apis
+
+import (
+	"sync"
+	"time"
+)
+
+// tokenBucket limits the requests to a provider to its quota. The bucket holds one token per
+// request allowed in the period, and each taken token is given back a period after it was taken,
+// so that no more than the allowed requests are made in any window of the period.
+type tokenBucket struct {
+	m    sync.Mutex
+	size int
+	per  time.Duration
+	// times when the taken tokens are given back, in order
+	returns []time.Time
+	nowFn   func() time.Time
+}
+
+// newTokenBucket returns a bucket that allows the given requests per period, starting full.
+func newTokenBucket(requests int, per time.Duration) *tokenBucket {
+	return &tokenBucket{
+		size:    requests,
+		per:     per,
+		returns: make([]time.Time, 0, requests),
+		nowFn:   time.Now,
+	}
+}
+
+// reserve takes a token, returning the time to wait until it is available (zero if it already was).
+// The reserved tokens are taken even if the caller gives up waiting for them.
+func (b *tokenBucket) reserve() time.Duration {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	now := b.nowFn()
+	given := 0
+	for given < len(b.returns) && !b.returns[given].After(now) {
+		given++
+	}
+	b.returns = b.returns[given:]
+
+	at := now
+	if len(b.returns) >= b.size {
+		// the token is available once the one taken size requests ago is given back
+		at = b.returns[len(b.returns)-b.size]
+	}
+	b.returns = append(b.returns, at.Add(b.per))
+	return at.Sub(now)
+}
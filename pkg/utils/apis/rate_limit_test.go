@@ -0,0 +1,32 @@
+package apis
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Unix(1654084800, 0)
+	bucket := newTokenBucket(2, time.Minute)
+	bucket.nowFn = func() time.Time { return now }
+
+	// the bucket starts full
+	require.Zero(t, bucket.reserve())
+	require.Zero(t, bucket.reserve())
+	// the next ones wait for the first tokens to be given back
+	require.Equal(t, time.Minute, bucket.reserve())
+	require.Equal(t, time.Minute, bucket.reserve())
+	require.Equal(t, 2*time.Minute, bucket.reserve())
+
+	// the waits are relative to the current time
+	now = now.Add(30 * time.Second)
+	require.Equal(t, 90*time.Second, bucket.reserve())
+
+	// once idle, it is full again
+	now = now.Add(10 * time.Minute)
+	require.Zero(t, bucket.reserve())
+	require.Zero(t, bucket.reserve())
+	require.Equal(t, time.Minute, bucket.reserve())
+}
@@ -0,0 +1,175 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// confidence of the client names matched by the exact rules, by the token containment
+	// over the whole user agent, and the one that the fuzzy matches are scaled from
+	ExactClientConfidence = 1.0
+	TokenClientConfidence = 0.9
+
+	// known names shorter than this (i.e. teku, ioi) are too close to too many words to be fuzzy-matched
+	minFuzzyNameLen = 5
+
+	DefaultUnknownAgentSampleWindow = time.Minute
+)
+
+var (
+	// user agents that ended up unknown after all the rules, and their sampled log
+	unknownAgents       uint64
+	unknownAgentSampler = NewErrorSampler(DefaultUnknownAgentSampleWindow, func(msg string) {
+		log.Warn(msg)
+	})
+)
+
+// ClientMatch is a client name matched in a user agent, with the confidence of the match
+// (1 for the exact rules, 0 if unknown).
+type ClientMatch struct {
+	Name       ClientName
+	Confidence float64
+}
+
+// IsUnknown returns whether no known client matched.
+func (m ClientMatch) IsUnknown() bool {
+	return m.Name == ClientName(Unknown)
+}
+
+// FuzzyClientMatch is the fallback of ClientNameParser for the user agents that the exact rules
+// can't classify (i.e. forks and rebrands of the known clients). It tries the case-insensitive
+// containment of the known names in any of the '/' separated tokens of the user agent first, and then
+// the known names within a small edit distance of its words. Ambiguous matches (several clients at the
+// same distance) are left unknown, as the fallback must never misclassify a clearly distinct agent.
+func FuzzyClientMatch(validNames map[ClientName][]string, userAgent string) ClientMatch {
+	unknown := ClientMatch{Name: ClientName(Unknown)}
+
+	// token containment
+	tokens := strings.FieldsFunc(strings.ToLower(userAgent), func(r rune) bool {
+		return r == '/' || unicode.IsSpace(r)
+	})
+	if match, ok := uniqueMatch(validNames, func(subName string) (float64, bool) {
+		for _, token := range tokens {
+			if strings.Contains(token, strings.ToLower(subName)) {
+				return TokenClientConfidence, true
+			}
+		}
+		return 0, false
+	}); ok {
+		return match
+	}
+
+	// bounded edit distance
+	words := strings.FieldsFunc(strings.ToLower(userAgent), func(r rune) bool {
+		return !unicode.IsLetter(r)
+	})
+	if match, ok := uniqueMatch(validNames, func(subName string) (float64, bool) {
+		subName = strings.ToLower(subName)
+		if len(subName) < minFuzzyNameLen || strings.IndexFunc(subName, func(r rune) bool { return !unicode.IsLetter(r) }) >= 0 {
+			return 0, false
+		}
+		best, found := 0.0, false
+		for _, word := range words {
+			// typos rarely touch the first letter, while distinct names often differ only there (vortex, cortex)
+			if word[0] != subName[0] {
+				continue
+			}
+			dist := editDistance(word, subName)
+			if dist > maxClientEditDistance(subName) {
+				continue
+			}
+			confidence := 1 - float64(dist)/float64(len(subName))
+			if confidence > TokenClientConfidence {
+				confidence = TokenClientConfidence
+			}
+			if confidence > best {
+				best, found = confidence, true
+			}
+		}
+		return best, found
+	}); ok {
+		return match
+	}
+	return unknown
+}
+
+// uniqueMatch returns the client whose names score the highest with matchFn,
+// as long as no other client scores the same.
+func uniqueMatch(validNames map[ClientName][]string, matchFn func(subName string) (float64, bool)) (ClientMatch, bool) {
+	best := ClientMatch{Name: ClientName(Unknown)}
+	tied := false
+	for cName, subCliNames := range validNames {
+		for _, subName := range subCliNames {
+			confidence, ok := matchFn(subName)
+			if !ok || confidence < best.Confidence {
+				continue
+			}
+			if confidence == best.Confidence && cName != best.Name {
+				tied = true
+				continue
+			}
+			if confidence > best.Confidence {
+				tied = false
+			}
+			best = ClientMatch{Name: cName, Confidence: confidence}
+		}
+	}
+	if best.IsUnknown() || tied {
+		return ClientMatch{Name: ClientName(Unknown)}, false
+	}
+	return best, true
+}
+
+// maxClientEditDistance is the edit distance tolerated for a known client name.
+func maxClientEditDistance(name string) int {
+	if len(name) <= 7 {
+		return 1
+	}
+	return 2
+}
+
+// editDistance returns the Levenshtein distance between the two strings.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = minInt(minInt(prev[j]+1, curr[j-1]+1), prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// recordUnknownAgent counts the user agent that no rule could classify, logging it (sampled),
+// so that the rules can be extended.
+func recordUnknownAgent(network NetworkType, userAgent string) {
+	atomic.AddUint64(&unknownAgents, 1)
+	unknownAgentSampler.Log(fmt.Sprintf("unable to determine client name for %s UserAgent %q", network, userAgent))
+}
+
+// UnknownAgents returns the number of user agents parsed so far that no rule could classify.
+func UnknownAgents() uint64 {
+	return atomic.LoadUint64(&unknownAgents)
+}
@@ -0,0 +1,77 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_FuzzyClientMatch(t *testing.T) {
+	tests := []struct {
+		userAgent  string
+		clientName ClientName
+		confidence float64
+	}{
+		// known names in a later token of the user agent
+		{"eth2-node/Lighthouse-fork/v4.0.1/x86_64-linux", Lighthouse, TokenClientConfidence},
+		{"acme/beacon/PRYSM/v4.0.3", Prysm, TokenClientConfidence},
+		// near-misses of the known names
+		{"Lighthuose/v4.0.1-rc.0/x86_64-linux", Lighthouse, 0.8},
+		{"Prism/v4.0.3", Prysm, 0.8},
+		{"Lodestr/v1.8.0/linux-x64", Lodestar, 0.875},
+		{"Grandne/0.3.1/x86_64-linux", Grandine, 0.875},
+	}
+	for _, test := range tests {
+		match := FuzzyClientMatch(ethFuzzyClients, test.userAgent)
+		require.Equal(t, test.clientName, match.Name, test.userAgent)
+		require.InDelta(t, test.confidence, match.Confidence, 1e-9, test.userAgent)
+	}
+}
+
+func Test_FuzzyClientMatchNegatives(t *testing.T) {
+	// clearly distinct agents never get a client
+	for _, userAgent := range []string{
+		"lotus-1.13.0+mainnet+git.7a55e8e8",
+		"lotus",
+		"vortex/v1.0.0",
+		"region/0.1.0",
+		"nethermind/v1.17.3",
+		"geth/v1.11.5-stable/linux-amd64/go1.20.2",
+		"besu/v23.1.2/linux-x86_64/openjdk-java-17",
+		"tekton/v1.0.0",
+		"some-random-agent/1.0.0",
+		"",
+	} {
+		match := FuzzyClientMatch(EthCLClients, userAgent)
+		require.True(t, match.IsUnknown(), userAgent)
+		require.Zero(t, match.Confidence, userAgent)
+	}
+	// agents close to several clients at once are ambiguous
+	require.True(t, FuzzyClientMatch(EthCLClients, "node/prysm-lighthouse/v1").IsUnknown())
+}
+
+func Test_ParseClientConfidence(t *testing.T) {
+	unknownBefore := UnknownAgents()
+
+	cliInfo := ParseClient(EthereumNetwork, "Lighthouse/v3.1.2/aarch64-macos")
+	require.Equal(t, ClientInfo{Name: "lighthouse", Version: "v3.1.2", OS: "mac", Arch: "arm", Confidence: ExactClientConfidence}, cliInfo)
+
+	// the exact rules go first: the lotus agents keep their name on the Ethereum network
+	cliInfo = ParseClient(EthereumNetwork, "lotus-1.13.0+mainnet+git.7a55e8e8")
+	require.Equal(t, "lotus", cliInfo.Name)
+	require.Equal(t, ExactClientConfidence, cliInfo.Confidence)
+
+	cliInfo = ParseClient(EthereumNetwork, "Lighthuose/v4.0.1-rc.0/x86_64-linux")
+	require.Equal(t, "lighthouse", cliInfo.Name)
+	require.Equal(t, "v4.0.1", cliInfo.Version)
+	require.InDelta(t, 0.8, cliInfo.Confidence, 1e-9)
+	require.Equal(t, unknownBefore, UnknownAgents())
+
+	// the agents that no rule classifies get counted
+	cliInfo = ParseClient(EthereumNetwork, "nethermind/v1.17.3")
+	require.Equal(t, Unknown, cliInfo.Name)
+	require.Zero(t, cliInfo.Confidence)
+	cliInfo = ParseClient(FilecoinNetwork, "venus/1.10.0")
+	require.Equal(t, Unknown, cliInfo.Name)
+	require.Equal(t, unknownBefore+2, UnknownAgents())
+}
@@ -0,0 +1,119 @@
+package utils
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	DefaultErrorSampleWindow = 10 * time.Second
+)
+
+// ErrorSampler avoids flooding the logs with the same error over and over.
+// Identical error strings (or the errors sampled under the same key, see ErrorWithKey) within a
+// window are logged only once, and the number of times they got repeated is logged when the
+// window closes.
+type ErrorSampler struct {
+	m      sync.Mutex
+	window time.Duration
+	logFn  func(string)
+	nowFn  func() time.Time
+
+	sampled map[string]*sampledError
+}
+
+type sampledError struct {
+	// first message of the window, the one reported with the repetitions
+	msg       string
+	firstSeen time.Time
+	repeated  int
+}
+
+// NewErrorSampler returns an ErrorSampler with the given window that writes through logFn.
+// If no logFn is given, messages are logged at error level through logrus.
+func NewErrorSampler(window time.Duration, logFn func(string)) *ErrorSampler {
+	if logFn == nil {
+		logFn = func(msg string) {
+			log.Error(msg)
+		}
+	}
+	return &ErrorSampler{
+		window:  window,
+		logFn:   logFn,
+		nowFn:   time.Now,
+		sampled: make(map[string]*sampledError),
+	}
+}
+
+// Error logs the given error if it wasn't already logged in the current window.
+func (s *ErrorSampler) Error(err error) {
+	if err == nil {
+		return
+	}
+	s.Log(err.Error())
+}
+
+// ErrorWithKey logs the given error if no other error was logged under the same key in the
+// current window. It samples the errors whose message changes on every occurrence (i.e. it
+// includes the failed statement) by a stable part of them, like their root cause.
+func (s *ErrorSampler) ErrorWithKey(key string, err error) {
+	if err == nil {
+		return
+	}
+	s.LogWithKey(key, err.Error())
+}
+
+// Log logs the given message if it wasn't already logged in the current window.
+func (s *ErrorSampler) Log(msg string) {
+	s.LogWithKey(msg, msg)
+}
+
+// LogWithKey logs the given message if no other message was logged under the same key in the
+// current window (see ErrorWithKey).
+func (s *ErrorSampler) LogWithKey(key string, msg string) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	now := s.nowFn()
+	s.closeWindows(now, false)
+
+	if sample, ok := s.sampled[key]; ok {
+		sample.repeated++
+		return
+	}
+	s.sampled[key] = &sampledError{
+		msg:       msg,
+		firstSeen: now,
+	}
+	s.logFn(msg)
+}
+
+// FlushExpired closes the windows that already expired, reporting the repeated errors.
+func (s *ErrorSampler) FlushExpired() {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.closeWindows(s.nowFn(), false)
+}
+
+// Flush closes all the open windows, reporting the repeated errors.
+func (s *ErrorSampler) Flush() {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.closeWindows(s.nowFn(), true)
+}
+
+func (s *ErrorSampler) closeWindows(now time.Time, force bool) {
+	for key, sample := range s.sampled {
+		elapsed := now.Sub(sample.firstSeen)
+		if !force && elapsed < s.window {
+			continue
+		}
+		if sample.repeated > 0 {
+			s.logFn(fmt.Sprintf("%s (repeated %d times in the last %.0f seconds)", sample.msg, sample.repeated, elapsed.Seconds()))
+		}
+		delete(s.sampled, key)
+	}
+}
@@ -0,0 +1,82 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestSampler(window time.Duration) (*ErrorSampler, *[]string, *time.Time) {
+	lines := make([]string, 0)
+	now := time.Unix(1000, 0)
+	sampler := NewErrorSampler(window, func(msg string) {
+		lines = append(lines, msg)
+	})
+	sampler.nowFn = func() time.Time {
+		return now
+	}
+	return sampler, &lines, &now
+}
+
+func Test_ErrorSamplerBurst(t *testing.T) {
+	sampler, lines, now := newTestSampler(10 * time.Second)
+
+	for i := 0; i < 100; i++ {
+		sampler.Error(errors.New("connection refused"))
+		*now = now.Add(50 * time.Millisecond)
+	}
+	require.Equal(t, 1, len(*lines))
+
+	// window is still open
+	sampler.FlushExpired()
+	require.Equal(t, 1, len(*lines))
+
+	*now = now.Add(10 * time.Second)
+	sampler.FlushExpired()
+	require.Equal(t, []string{
+		"connection refused",
+		"connection refused (repeated 99 times in the last 15 seconds)",
+	}, *lines)
+
+	// a new window starts after the previous one closed
+	sampler.Error(errors.New("connection refused"))
+	require.Equal(t, 3, len(*lines))
+}
+
+func Test_ErrorSamplerDistinct(t *testing.T) {
+	sampler, lines, _ := newTestSampler(10 * time.Second)
+
+	for i := 0; i < 5; i++ {
+		sampler.Error(fmt.Errorf("error %d", i))
+	}
+	sampler.Flush()
+	require.Equal(t, []string{"error 0", "error 1", "error 2", "error 3", "error 4"}, *lines)
+}
+
+func Test_ErrorSamplerWithKey(t *testing.T) {
+	sampler, lines, now := newTestSampler(10 * time.Second)
+
+	// the messages change on every occurrence, but they share their root cause
+	for i := 0; i < 50; i++ {
+		sampler.ErrorWithKey("connection refused", fmt.Errorf("statement %d of the batch: connection refused", i))
+		*now = now.Add(100 * time.Millisecond)
+	}
+	sampler.ErrorWithKey("duplicate key", errors.New("statement 3 of the batch: duplicate key"))
+	*now = now.Add(10 * time.Second)
+	sampler.FlushExpired()
+	require.Equal(t, []string{
+		"statement 0 of the batch: connection refused",
+		"statement 3 of the batch: duplicate key",
+		"statement 0 of the batch: connection refused (repeated 49 times in the last 15 seconds)",
+	}, *lines)
+}
+
+func Test_ErrorSamplerNil(t *testing.T) {
+	sampler, lines, _ := newTestSampler(10 * time.Second)
+	sampler.Error(nil)
+	sampler.Flush()
+	require.Equal(t, 0, len(*lines))
+}
@@ -0,0 +1,113 @@
+package influx
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// escapes of the measurements, and of the tag keys, tag values and field keys
+	measurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+	keyEscaper         = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+	// escapes of the string field values (that get quoted)
+	stringEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+)
+
+// Point is a single point of a time series in the InfluxDB line protocol.
+type Point struct {
+	Measurement string
+	Tags        map[string]string
+	Fields      map[string]interface{}
+	Time        time.Time
+}
+
+// NewPoint returns a Point of the measurement with a single "value" field.
+func NewPoint(measurement string, tags map[string]string, value interface{}, t time.Time) Point {
+	return Point{
+		Measurement: measurement,
+		Tags:        tags,
+		Fields:      map[string]interface{}{"value": value},
+		Time:        t,
+	}
+}
+
+// Line returns the point in line protocol (without the trailing newline), with the tags and fields
+// sorted by key and the timestamp in nanoseconds. The tags with empty values are left out,
+// as InfluxDB rejects them.
+func (p Point) Line() (string, error) {
+	if p.Measurement == "" {
+		return "", fmt.Errorf("point without measurement")
+	}
+	if len(p.Fields) == 0 {
+		return "", fmt.Errorf("point %s without fields", p.Measurement)
+	}
+	var b strings.Builder
+	b.WriteString(measurementEscaper.Replace(p.Measurement))
+	for _, key := range sortedKeys(p.Tags) {
+		if p.Tags[key] == "" {
+			continue
+		}
+		b.WriteByte(',')
+		b.WriteString(keyEscaper.Replace(key))
+		b.WriteByte('=')
+		b.WriteString(keyEscaper.Replace(p.Tags[key]))
+	}
+	fieldKeys := make([]string, 0, len(p.Fields))
+	for key := range p.Fields {
+		fieldKeys = append(fieldKeys, key)
+	}
+	sort.Strings(fieldKeys)
+	for i, key := range fieldKeys {
+		value, err := formatField(p.Fields[key])
+		if err != nil {
+			return "", fmt.Errorf("field %s of point %s: %s", key, p.Measurement, err.Error())
+		}
+		if i == 0 {
+			b.WriteByte(' ')
+		} else {
+			b.WriteByte(',')
+		}
+		b.WriteString(keyEscaper.Replace(key))
+		b.WriteByte('=')
+		b.WriteString(value)
+	}
+	b.WriteByte(' ')
+	b.WriteString(strconv.FormatInt(p.Time.UnixNano(), 10))
+	return b.String(), nil
+}
+
+// formatField returns the value of a field in line protocol.
+func formatField(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case int:
+		return strconv.FormatInt(int64(v), 10) + "i", nil
+	case int32:
+		return strconv.FormatInt(int64(v), 10) + "i", nil
+	case int64:
+		return strconv.FormatInt(v, 10) + "i", nil
+	case uint32:
+		return strconv.FormatUint(uint64(v), 10) + "u", nil
+	case uint64:
+		return strconv.FormatUint(v, 10) + "u", nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case string:
+		return `"` + stringEscaper.Replace(v) + `"`, nil
+	default:
+		return "", fmt.Errorf("unsupported type %T", value)
+	}
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,36 @@
+package influx
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPointLine(t *testing.T) {
+	ts := time.Unix(1654084800, 123)
+
+	line, err := NewPoint("peers_connected", map[string]string{"network": "mainnet", "crawler_id": "crawler-1"}, 42, ts).Line()
+	require.NoError(t, err)
+	require.Equal(t, "peers_connected,crawler_id=crawler-1,network=mainnet value=42i 1654084800000000123", line)
+
+	// escaping of every part, sorted fields, and no empty tags
+	line, err = Point{
+		Measurement: "msgs per,topic",
+		Tags:        map[string]string{"topic": "beacon block,a=b", "empty": ""},
+		Fields: map[string]interface{}{
+			"str":   `say "hi" \o/`,
+			"float": 0.25,
+			"ok":    true,
+			"count": uint64(7),
+		},
+		Time: ts,
+	}.Line()
+	require.NoError(t, err)
+	require.Equal(t, `msgs\ per\,topic,topic=beacon\ block\,a\=b count=7u,float=0.25,ok=true,str="say \"hi\" \\o/" 1654084800000000123`, line)
+
+	_, err = Point{Measurement: "no_fields", Time: ts}.Line()
+	require.Error(t, err)
+	_, err = NewPoint("bad_field", nil, []int{1}, ts).Line()
+	require.Error(t, err)
+}
@@ -0,0 +1,107 @@
+package influx
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultMaxPending is the number of lines that a Sink keeps for retrying while its writer fails.
+const DefaultMaxPending = 10000
+
+// Sink writes the points through its Writer with a set of tags common to all of them.
+// The lines that fail to be written are kept (up to maxPending, dropping the oldest ones)
+// and retried ahead of the next points.
+type Sink struct {
+	writer     Writer
+	tags       map[string]string
+	maxPending int
+
+	m       sync.Mutex
+	pending []string
+	dropped int
+}
+
+// NewSink returns a Sink that writes through the writer, adding the tags to every point.
+func NewSink(writer Writer, tags map[string]string, maxPending int) *Sink {
+	if maxPending <= 0 {
+		maxPending = DefaultMaxPending
+	}
+	return &Sink{
+		writer:     writer,
+		tags:       tags,
+		maxPending: maxPending,
+	}
+}
+
+// Write writes the pending lines and the points. On failure, all of them are kept to be retried.
+func (s *Sink) Write(points []Point) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+	for _, point := range points {
+		line, err := s.withTags(point).Line()
+		if err != nil {
+			log.Warn(errors.Wrap(err, "unable to compose influx line"))
+			continue
+		}
+		s.pending = append(s.pending, line)
+	}
+	if over := len(s.pending) - s.maxPending; over > 0 {
+		s.dropped += over
+		s.pending = append(s.pending[:0], s.pending[over:]...)
+	}
+	return s.flush()
+}
+
+// flush writes the pending lines. Has to be called under the lock of the sink.
+func (s *Sink) flush() error {
+	if len(s.pending) == 0 {
+		return nil
+	}
+	err := s.writer.Write([]byte(strings.Join(s.pending, "\n") + "\n"))
+	if err != nil {
+		return errors.Wrapf(err, "unable to write %d influx lines", len(s.pending))
+	}
+	s.pending = s.pending[:0]
+	return nil
+}
+
+// withTags returns the point with the tags of the sink, which prevail over its own ones
+// so that every series carries the same ones.
+func (s *Sink) withTags(point Point) Point {
+	tags := make(map[string]string, len(point.Tags)+len(s.tags))
+	for k, v := range point.Tags {
+		tags[k] = v
+	}
+	for k, v := range s.tags {
+		tags[k] = v
+	}
+	point.Tags = tags
+	return point
+}
+
+// Pending returns the number of lines waiting to be retried.
+func (s *Sink) Pending() int {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return len(s.pending)
+}
+
+// Dropped returns the number of lines dropped since the start because the pending ones reached the cap.
+func (s *Sink) Dropped() int {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return s.dropped
+}
+
+// Close makes a last attempt to write the pending lines and closes the writer.
+func (s *Sink) Close() error {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if err := s.flush(); err != nil {
+		log.Warnf("dropping %d influx lines: %s", len(s.pending), err.Error())
+	}
+	return s.writer.Close()
+}
@@ -0,0 +1,79 @@
+package influx
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeWriter records the payloads, failing while fail is set.
+type fakeWriter struct {
+	fail     bool
+	payloads []string
+	closed   bool
+}
+
+func (w *fakeWriter) Write(payload []byte) error {
+	if w.fail {
+		return fmt.Errorf("endpoint down")
+	}
+	w.payloads = append(w.payloads, string(payload))
+	return nil
+}
+
+func (w *fakeWriter) Close() error {
+	w.closed = true
+	return nil
+}
+
+func TestSinkRetries(t *testing.T) {
+	ts := time.Unix(1654084800, 0)
+	writer := &fakeWriter{}
+	sink := NewSink(writer, map[string]string{"network": "mainnet"}, 3)
+
+	// the tags of the sink prevail
+	require.NoError(t, sink.Write([]Point{NewPoint("peers", map[string]string{"network": "other", "client": "prysm"}, 1, ts)}))
+	require.Equal(t, []string{"peers,client=prysm,network=mainnet value=1i 1654084800000000000\n"}, writer.payloads)
+
+	// the failed lines are kept up to the cap, dropping the oldest ones
+	writer.fail = true
+	for i := 2; i <= 5; i++ {
+		require.Error(t, sink.Write([]Point{NewPoint("peers", nil, i, ts)}))
+	}
+	require.Equal(t, 3, sink.Pending())
+	require.Equal(t, 1, sink.Dropped())
+
+	// and retried ahead of the next points (which count for the cap too)
+	writer.fail = false
+	require.NoError(t, sink.Write([]Point{NewPoint("peers", nil, 6, ts)}))
+	require.Equal(t, 0, sink.Pending())
+	require.Equal(t, 2, sink.Dropped())
+	require.Len(t, writer.payloads, 2)
+	values := make([]string, 0)
+	for _, line := range strings.Split(strings.TrimSpace(writer.payloads[1]), "\n") {
+		values = append(values, strings.Fields(line)[1])
+	}
+	require.Equal(t, []string{"value=4i", "value=5i", "value=6i"}, values)
+
+	require.NoError(t, sink.Close())
+	require.True(t, writer.closed)
+}
+
+func TestFileWriter(t *testing.T) {
+	path := t.TempDir() + "/points.lp"
+	writer, err := NewWriter("file://"+path, "", "", "")
+	require.NoError(t, err)
+	sink := NewSink(writer, nil, 0)
+	ts := time.Unix(1654084800, 0)
+	require.NoError(t, sink.Write([]Point{NewPoint("a", nil, 1, ts)}))
+	require.NoError(t, sink.Write([]Point{NewPoint("b", nil, 2, ts)}))
+	require.NoError(t, sink.Close())
+
+	content, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, []byte("a value=1i 1654084800000000000\nb value=2i 1654084800000000000\n"), content)
+}
@@ -0,0 +1,159 @@
+package influx
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// time that each write gets to reach the endpoint
+	writeTimeout = 10 * time.Second
+	// bytes of the error responses of InfluxDB that get included in the errors
+	maxErrorBody = 512
+)
+
+// Writer sends a payload of lines in line protocol to its destination.
+type Writer interface {
+	Write(payload []byte) error
+	Close() error
+}
+
+// NewWriter returns the Writer of the endpoint, picked by its scheme:
+// http(s):// for the write endpoint of an InfluxDB v2 (authorized with the token),
+// udp://, tcp:// or unix:// for a socket, and anything else for a file where the lines are appended.
+func NewWriter(endpoint, org, bucket, token string) (Writer, error) {
+	switch {
+	case strings.HasPrefix(endpoint, "http://"), strings.HasPrefix(endpoint, "https://"):
+		return NewHTTPWriter(endpoint, org, bucket, token)
+	case strings.HasPrefix(endpoint, "udp://"), strings.HasPrefix(endpoint, "tcp://"), strings.HasPrefix(endpoint, "unix://"):
+		parts := strings.SplitN(endpoint, "://", 2)
+		return NewSocketWriter(parts[0], parts[1]), nil
+	default:
+		return NewFileWriter(strings.TrimPrefix(endpoint, "file://")), nil
+	}
+}
+
+// HTTPWriter writes the lines into the bucket of an InfluxDB v2 through its write API.
+type HTTPWriter struct {
+	writeURL   string
+	token      string
+	httpClient *http.Client
+}
+
+// NewHTTPWriter returns an HTTPWriter for the InfluxDB at the given base URL.
+func NewHTTPWriter(baseURL, org, bucket, token string) (*HTTPWriter, error) {
+	if bucket == "" {
+		return nil, fmt.Errorf("no bucket given for influxdb %s", baseURL)
+	}
+	u, err := url.Parse(strings.TrimSuffix(baseURL, "/") + "/api/v2/write")
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to parse influxdb url "+baseURL)
+	}
+	query := u.Query()
+	query.Set("org", org)
+	query.Set("bucket", bucket)
+	query.Set("precision", "ns")
+	u.RawQuery = query.Encode()
+	return &HTTPWriter{
+		writeURL:   u.String(),
+		token:      token,
+		httpClient: &http.Client{Timeout: writeTimeout},
+	}, nil
+}
+
+// Write posts the payload to the write endpoint.
+func (w *HTTPWriter) Write(payload []byte) error {
+	req, err := http.NewRequest(http.MethodPost, w.writeURL, bytes.NewReader(payload))
+	if err != nil {
+		return errors.Wrap(err, "unable to compose request")
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if w.token != "" {
+		req.Header.Set("Authorization", "Token "+w.token)
+	}
+	resp, err := w.httpClient.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "unable to write into influxdb")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+		return fmt.Errorf("error HTTP %d writing into influxdb: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// Close does nothing, as every write has its own request.
+func (w *HTTPWriter) Close() error {
+	return nil
+}
+
+// StreamWriter appends the lines to a stream (a file or a socket), opening it on the first write
+// and reopening it on the next one after a failure.
+type StreamWriter struct {
+	m      sync.Mutex
+	openFn func() (io.WriteCloser, error)
+	stream io.WriteCloser
+}
+
+// NewFileWriter returns a StreamWriter that appends the lines to the file.
+func NewFileWriter(path string) *StreamWriter {
+	return &StreamWriter{
+		openFn: func() (io.WriteCloser, error) {
+			return os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		},
+	}
+}
+
+// NewSocketWriter returns a StreamWriter that sends the lines to the address of the network
+// (udp, tcp or unix).
+func NewSocketWriter(network, addr string) *StreamWriter {
+	return &StreamWriter{
+		openFn: func() (io.WriteCloser, error) {
+			return net.DialTimeout(network, addr, writeTimeout)
+		},
+	}
+}
+
+// Write writes the payload into the stream.
+func (w *StreamWriter) Write(payload []byte) error {
+	w.m.Lock()
+	defer w.m.Unlock()
+	if w.stream == nil {
+		stream, err := w.openFn()
+		if err != nil {
+			return errors.Wrap(err, "unable to open influx stream")
+		}
+		w.stream = stream
+	}
+	_, err := w.stream.Write(payload)
+	if err != nil {
+		w.stream.Close()
+		w.stream = nil
+		return errors.Wrap(err, "unable to write into influx stream")
+	}
+	return nil
+}
+
+// Close closes the stream.
+func (w *StreamWriter) Close() error {
+	w.m.Lock()
+	defer w.m.Unlock()
+	if w.stream == nil {
+		return nil
+	}
+	err := w.stream.Close()
+	w.stream = nil
+	return err
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/hex"
 	"fmt"
 	"net"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -42,9 +43,15 @@ func UnmarshalMaddr(inputAddr string) (ma.Multiaddr, error) {
 	return new_ma, nil
 }
 
+// IsIPPublic returns whether the IP is publicly routable (i.e. not private, loopback, link-local
+// nor unspecified). The missing IPs (i.e. of the DNS multiaddresses) are not public.
 func IsIPPublic(ip net.IP) bool {
+	if ip == nil || ip.IsLoopback() || ip.IsUnspecified() || ip.IsPrivate() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() {
+		return false
+	}
 	for _, ipNet := range PrivateIPNetworks {
-		if ipNet.Contains(ip) || ip.IsLoopback() || ip.IsUnspecified() {
+		if ipNet.Contains(ip) {
 			return false
 		}
 	}
@@ -81,6 +88,84 @@ func ExtractIPFromMAddr(maddr ma.Multiaddr) net.IP {
 	return net.ParseIP(ip)
 }
 
+// IsRelayMAddr checks whether the multiaddress is a circuit-relay address (contains /p2p-circuit),
+// in which case the IP in it belongs to the relay and not to the peer itself.
+func IsRelayMAddr(maddr ma.Multiaddr) bool {
+	if maddr == nil {
+		return false
+	}
+	_, err := maddr.ValueForProtocol(ma.P_CIRCUIT)
+	return err == nil
+}
+
+// SplitRelayMAddrs classifies the given multiaddresses into direct and circuit-relay ones.
+func SplitRelayMAddrs(mAddrs []ma.Multiaddr) (direct []ma.Multiaddr, relay []ma.Multiaddr) {
+	direct = make([]ma.Multiaddr, 0, len(mAddrs))
+	relay = make([]ma.Multiaddr, 0)
+	for _, addr := range mAddrs {
+		if IsRelayMAddr(addr) {
+			relay = append(relay, addr)
+		} else {
+			direct = append(direct, addr)
+		}
+	}
+	return direct, relay
+}
+
+// CanonicalMAddrs normalizes a set of multiaddresses: strips their trailing /p2p component,
+// re-encodes them in their canonical form, removes the duplicates and sorts them.
+// Returns both the typed multiaddresses and their canonical strings (in the same order).
+func CanonicalMAddrs(mAddrs []ma.Multiaddr) ([]ma.Multiaddr, []string) {
+	canonical := make(map[string]ma.Multiaddr, len(mAddrs))
+	for _, mAddr := range mAddrs {
+		if mAddr == nil || len(mAddr.Bytes()) == 0 {
+			continue
+		}
+		// the /p2p of the peer itself, not the one of the relay in circuit addresses
+		if rest, last := ma.SplitLast(mAddr); last != nil && last.Protocol().Code == ma.P_P2P {
+			if rest == nil {
+				continue
+			}
+			mAddr = rest
+		}
+		// the binary form is unique, its string is the canonical one
+		cMAddr, err := ma.NewMultiaddrBytes(mAddr.Bytes())
+		if err != nil {
+			continue
+		}
+		canonical[cMAddr.String()] = cMAddr
+	}
+	strs := make([]string, 0, len(canonical))
+	for str := range canonical {
+		strs = append(strs, str)
+	}
+	sort.Strings(strs)
+	typed := make([]ma.Multiaddr, 0, len(strs))
+	for _, str := range strs {
+		typed = append(typed, canonical[str])
+	}
+	return typed, strs
+}
+
+// ParseCanonicalMAddrs parses the given multiaddresses and normalizes them as CanonicalMAddrs does.
+// The invalid ones are skipped, returning the error of the last of them.
+func ParseCanonicalMAddrs(mAddrStrs []string) ([]ma.Multiaddr, []string, error) {
+	var lastErr error
+	mAddrs := make([]ma.Multiaddr, 0, len(mAddrStrs))
+	for _, mAddrStr := range mAddrStrs {
+		// quic-v1 is unknown to our multiaddr version, handle it as the quic address of the same host and port
+		mAddrStr = strings.Replace(mAddrStr, "/quic-v1", "/quic", 1)
+		mAddr, err := ma.NewMultiaddr(mAddrStr)
+		if err != nil {
+			lastErr = errors.Wrap(err, "unable to parse multiaddress "+mAddrStr)
+			continue
+		}
+		mAddrs = append(mAddrs, mAddr)
+	}
+	typed, strs := CanonicalMAddrs(mAddrs)
+	return typed, strs, lastErr
+}
+
 func GetPortFromMaddrs(maddr ma.Multiaddr) int {
 	// check if MAddrs is empty
 	if maddr == nil {
@@ -124,10 +209,78 @@ func ParsePubkey(v string) (*ecdsa.PublicKey, error) {
 	return (*ecdsa.PublicKey)((pub).(*crypto.Secp256k1PublicKey)), nil
 }
 
+// ranks of the multiaddresses to select the one that identifies the peer (see SelectMAddr)
+const (
+	publicIPv4Rank = iota
+	publicIPv6Rank
+	dnsRank
+	privateIPRank
+	unselectableRank
+)
+
+// SelectMAddr returns the multiaddress that best tells where the peer is, among the ones that it
+// advertises: the first publicly routable IPv4 one, else the first public IPv6 one, else the first
+// one with a DNS name (which has no IP to locate), and only if there is nothing else, the first
+// private one. The circuit-relay addresses are never selected (their IP is the relay's).
+// Returns nil if none of them can be selected.
+func SelectMAddr(mAddrs []ma.Multiaddr) ma.Multiaddr {
+	var selected ma.Multiaddr
+	selectedRank := unselectableRank
+	for _, mAddr := range mAddrs {
+		if rank := mAddrRank(mAddr); rank < selectedRank {
+			selected, selectedRank = mAddr, rank
+		}
+	}
+	return selected
+}
+
+// SelectIPAndPort returns the IP and port of the multiaddress selected by SelectMAddr, empty if it
+// has no IP (0 if it has no port).
+func SelectIPAndPort(mAddrs []ma.Multiaddr) (string, int) {
+	selected := SelectMAddr(mAddrs)
+	ip := ExtractIPFromMAddr(selected)
+	if ip == nil {
+		return "", 0
+	}
+	port := GetPortFromMaddrs(selected)
+	if port < 0 {
+		port = 0
+	}
+	return ip.String(), port
+}
+
+func mAddrRank(mAddr ma.Multiaddr) int {
+	if mAddr == nil || len(mAddr.Bytes()) == 0 || IsRelayMAddr(mAddr) {
+		return unselectableRank
+	}
+	first, _ := ma.SplitFirst(mAddr)
+	if first == nil {
+		return unselectableRank
+	}
+	switch first.Protocol().Code {
+	case ma.P_IP4, ma.P_IP6:
+		ip := net.IP(first.RawValue())
+		switch {
+		case !IsIPPublic(ip):
+			return privateIPRank
+		case ip.To4() != nil:
+			return publicIPv4Rank
+		default:
+			return publicIPv6Rank
+		}
+	case ma.P_DNS, ma.P_DNS4, ma.P_DNS6, ma.P_DNSADDR:
+		return dnsRank
+	}
+	return unselectableRank
+}
+
 func GetPublicAddrsFromAddrArray(mAddrs []ma.Multiaddr) ma.Multiaddr {
-	// loop to check if which is the public ip
+	// loop to check if which is the public ip (relay addresses don't contain the IP of the peer)
 	var finalAddr ma.Multiaddr
 	for _, addr := range mAddrs {
+		if IsRelayMAddr(addr) {
+			continue
+		}
 		ip := ExtractIPFromMAddr(addr)
 		if IsIPPublic(ip) {
 			finalAddr = addr
@@ -0,0 +1,177 @@
+package utils
+
+import (
+	"math/rand"
+	"net"
+	"strings"
+	"testing"
+	"testing/quick"
+
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testDirectAddr = "/ip4/86.85.31.80/tcp/9000"
+	testRelayAddr  = "/ip4/147.75.83.83/tcp/4001/p2p/QmbLHAnMoJPWSCR5Zhtx6BHJX9KiKNN6tpvbUcqanj75Nb/p2p-circuit"
+)
+
+func parseTestMAddrs(t *testing.T, addrs ...string) []ma.Multiaddr {
+	mAddrs := make([]ma.Multiaddr, 0, len(addrs))
+	for _, addr := range addrs {
+		mAddr, err := ma.NewMultiaddr(addr)
+		require.NoError(t, err)
+		mAddrs = append(mAddrs, mAddr)
+	}
+	return mAddrs
+}
+
+func TestRelayMAddrs(t *testing.T) {
+	mixed := parseTestMAddrs(t, testRelayAddr, testDirectAddr)
+	require.True(t, IsRelayMAddr(mixed[0]))
+	require.False(t, IsRelayMAddr(mixed[1]))
+	require.False(t, IsRelayMAddr(nil))
+
+	direct, relay := SplitRelayMAddrs(mixed)
+	require.Equal(t, 1, len(direct))
+	require.Equal(t, testDirectAddr, direct[0].String())
+	require.Equal(t, 1, len(relay))
+	require.Equal(t, testRelayAddr, relay[0].String())
+
+	// the IP of the relay is never picked as the public IP of the peer
+	require.Equal(t, testDirectAddr, GetPublicAddrsFromAddrArray(mixed).String())
+
+	relayOnly := parseTestMAddrs(t, testRelayAddr)
+	direct, relay = SplitRelayMAddrs(relayOnly)
+	require.Equal(t, 0, len(direct))
+	require.Equal(t, 1, len(relay))
+	require.Nil(t, GetPublicAddrsFromAddrArray(relayOnly))
+}
+
+const testPeerSuffix = "/p2p/16Uiu2HAmQmYqS8KB3WnDmKaRoy7Fy9bhmJzSh3eDhC4wTkLqAqXy"
+
+// same addresses in different textual forms
+var testMAddrForms = [][]string{
+	{testDirectAddr, testDirectAddr + testPeerSuffix},
+	{"/ip6/2a01:4f8:0:0:0:0:0:1/tcp/9000", "/ip6/2a01:4f8::1/tcp/9000" + testPeerSuffix},
+	{"/ip4/86.85.31.80/udp/9000/quic", "/ip4/86.85.31.80/udp/9000/quic-v1" + testPeerSuffix},
+	{testRelayAddr, testRelayAddr + testPeerSuffix},
+}
+
+func TestCanonicalMAddrs(t *testing.T) {
+	for _, forms := range testMAddrForms {
+		_, strs, err := ParseCanonicalMAddrs(forms)
+		require.NoError(t, err)
+		require.Equal(t, 1, len(strs), forms)
+	}
+	// the /p2p of the relay is kept
+	_, strs, err := ParseCanonicalMAddrs([]string{testRelayAddr + testPeerSuffix})
+	require.NoError(t, err)
+	require.Equal(t, testRelayAddr, strs[0])
+
+	// invalid ones are skipped
+	typed, strs, err := ParseCanonicalMAddrs([]string{"not-a-maddr", testDirectAddr})
+	require.Error(t, err)
+	require.Equal(t, []string{testDirectAddr}, strs)
+	require.Equal(t, testDirectAddr, typed[0].String())
+}
+
+// randomTestMAddrs picks a random set of the testMAddrForms (in any of their forms and with repetitions).
+func randomTestMAddrs(rnd *rand.Rand) []string {
+	n := rnd.Intn(3 * len(testMAddrForms))
+	addrs := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		forms := testMAddrForms[rnd.Intn(len(testMAddrForms))]
+		addrs = append(addrs, forms[rnd.Intn(len(forms))])
+	}
+	return addrs
+}
+
+func TestCanonicalMAddrsProperties(t *testing.T) {
+	// dedup is idempotent
+	idempotent := func(seed int64) bool {
+		typed, strs, err := ParseCanonicalMAddrs(randomTestMAddrs(rand.New(rand.NewSource(seed))))
+		if err != nil {
+			return false
+		}
+		_, again := CanonicalMAddrs(typed)
+		return equalStrs(strs, again)
+	}
+	require.NoError(t, quick.Check(idempotent, nil))
+
+	// the order of the input doesn't matter
+	orderIndependent := func(seed int64) bool {
+		rnd := rand.New(rand.NewSource(seed))
+		addrs := randomTestMAddrs(rnd)
+		_, strs, _ := ParseCanonicalMAddrs(addrs)
+		rnd.Shuffle(len(addrs), func(i, j int) { addrs[i], addrs[j] = addrs[j], addrs[i] })
+		_, shuffled, _ := ParseCanonicalMAddrs(addrs)
+		return equalStrs(strs, shuffled)
+	}
+	require.NoError(t, quick.Check(orderIndependent, nil))
+
+	// the /p2p suffix never survives
+	noP2PSuffix := func(seed int64) bool {
+		_, strs, _ := ParseCanonicalMAddrs(randomTestMAddrs(rand.New(rand.NewSource(seed))))
+		for _, str := range strs {
+			if strings.HasSuffix(str, testPeerSuffix) {
+				return false
+			}
+		}
+		return len(strs) <= len(testMAddrForms)
+	}
+	require.NoError(t, quick.Check(noP2PSuffix, nil))
+}
+
+func equalStrs(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestSelectMAddr(t *testing.T) {
+	const (
+		privateAddr  = "/ip4/10.0.0.5/tcp/9000"
+		loopbackAddr = "/ip4/127.0.0.1/tcp/9000"
+		publicV6Addr = "/ip6/2a01:4f8::1/tcp/9000"
+		dnsAddr      = "/dns4/node.example.org/tcp/9000"
+	)
+	tests := []struct {
+		name  string
+		addrs []string
+		want  string
+		ip    string
+		port  int
+	}{
+		{"public ipv4 over the rest", []string{loopbackAddr, privateAddr, publicV6Addr, dnsAddr, testDirectAddr}, testDirectAddr, "86.85.31.80", 9000},
+		{"public ipv6 over dns and private", []string{privateAddr, dnsAddr, publicV6Addr}, publicV6Addr, "2a01:4f8::1", 9000},
+		{"dns has no ip to locate", []string{loopbackAddr, dnsAddr, "/dns6/node.example.org/tcp/9000"}, dnsAddr, "", 0},
+		{"only private ones", []string{loopbackAddr, privateAddr}, loopbackAddr, "127.0.0.1", 9000},
+		{"never the relay", []string{testRelayAddr, privateAddr}, privateAddr, "10.0.0.5", 9000},
+		{"only relays", []string{testRelayAddr}, "", "", 0},
+		{"none", nil, "", "", 0},
+	}
+	for _, test := range tests {
+		mAddrs := parseTestMAddrs(t, test.addrs...)
+		selected := SelectMAddr(mAddrs)
+		if test.want == "" {
+			require.Nil(t, selected, test.name)
+		} else {
+			require.Equal(t, test.want, selected.String(), test.name)
+		}
+		ip, port := SelectIPAndPort(mAddrs)
+		require.Equal(t, test.ip, ip, test.name)
+		require.Equal(t, test.port, port, test.name)
+	}
+
+	require.False(t, IsIPPublic(nil))
+	require.False(t, IsIPPublic(net.ParseIP("fe80::1")))
+	require.False(t, IsIPPublic(net.ParseIP("0.0.0.0")))
+	require.True(t, IsIPPublic(net.ParseIP("86.85.31.80")))
+}
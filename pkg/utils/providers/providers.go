@@ -0,0 +1,241 @@
+package providers
+
+import (
+	"bufio"
+	"bytes"
+	"embed"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+)
+
+// Cloud and hosting providers that can be detected from the IP of a peer
+const (
+	AWS          = "AWS"
+	GCP          = "GCP"
+	Azure        = "Azure"
+	Hetzner      = "Hetzner"
+	OVH          = "OVH"
+	DigitalOcean = "DigitalOcean"
+	Contabo      = "Contabo"
+)
+
+//go:embed data/*.txt
+var bundledData embed.FS
+
+// bundledFiles are the bundled CIDR lists of each provider
+var bundledFiles = map[string]string{
+	AWS:          "data/aws.txt",
+	GCP:          "data/gcp.txt",
+	Azure:        "data/azure.txt",
+	Hetzner:      "data/hetzner.txt",
+	OVH:          "data/ovh.txt",
+	DigitalOcean: "data/digitalocean.txt",
+	Contabo:      "data/contabo.txt",
+}
+
+var (
+	defaultClassifier     *Classifier
+	defaultClassifierOnce sync.Once
+)
+
+// Default returns the Classifier with the bundled CIDR lists, shared by the whole crawler.
+func Default() *Classifier {
+	defaultClassifierOnce.Do(func() {
+		c, err := NewBundledClassifier()
+		if err != nil {
+			// the bundled lists are part of the binary, they can't be wrong
+			panic(err)
+		}
+		defaultClassifier = c
+	})
+	return defaultClassifier
+}
+
+// LookupProvider returns the provider hosting the ip, using the Default Classifier.
+func LookupProvider(ip string) (string, bool) {
+	return Default().LookupProvider(ip)
+}
+
+// Classifier resolves the provider of an IP from the CIDR lists of each provider.
+// The lookups read an immutable table of sorted ranges, which is replaced as a whole when
+// the lists of a provider get updated, so that they never wait for an update.
+type Classifier struct {
+	// *rangeTable
+	table atomic.Value
+
+	// CIDR lists of each provider, to rebuild the table on updates
+	m        sync.Mutex
+	prefixes map[string][]*net.IPNet
+}
+
+// NewClassifier returns a Classifier for the given CIDR lists of each provider.
+func NewClassifier(cidrs map[string][]string) (*Classifier, error) {
+	c := &Classifier{
+		prefixes: make(map[string][]*net.IPNet, len(cidrs)),
+	}
+	for provider, list := range cidrs {
+		prefixes, err := parseCIDRs(list)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to parse the ranges of "+provider)
+		}
+		c.prefixes[provider] = prefixes
+	}
+	c.table.Store(newRangeTable(c.prefixes))
+	return c, nil
+}
+
+// NewBundledClassifier returns a Classifier with the CIDR lists bundled into the binary.
+func NewBundledClassifier() (*Classifier, error) {
+	cidrs := make(map[string][]string, len(bundledFiles))
+	for provider, file := range bundledFiles {
+		content, err := bundledData.ReadFile(file)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to read bundled ranges of "+provider)
+		}
+		cidrs[provider] = readCIDRList(content)
+	}
+	return NewClassifier(cidrs)
+}
+
+// LookupProvider returns the provider whose ranges contain the ip, or false if none does (or it isn't an IP).
+func (c *Classifier) LookupProvider(ip string) (string, bool) {
+	parsed := net.ParseIP(strings.TrimSpace(ip))
+	if parsed == nil {
+		return "", false
+	}
+	return c.table.Load().(*rangeTable).lookup(parsed)
+}
+
+// UpdateProvider replaces the CIDR list of the provider, without blocking the ongoing lookups.
+func (c *Classifier) UpdateProvider(provider string, cidrs []string) error {
+	prefixes, err := parseCIDRs(cidrs)
+	if err != nil {
+		return errors.Wrap(err, "unable to parse the ranges of "+provider)
+	}
+	if len(prefixes) == 0 {
+		return errors.New("no ranges for " + provider)
+	}
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.prefixes[provider] = prefixes
+	c.table.Store(newRangeTable(c.prefixes))
+	return nil
+}
+
+// Ranges returns the number of merged ranges of the lookup table.
+func (c *Classifier) Ranges() int {
+	return len(c.table.Load().(*rangeTable).ranges)
+}
+
+// ipRange is a range of IPs (in their 16 bytes form) that belongs to a provider.
+type ipRange struct {
+	first    [16]byte
+	last     [16]byte
+	provider string
+}
+
+// rangeTable is a list of non-overlapping ranges sorted by their first IP, looked up with a binary search.
+type rangeTable struct {
+	ranges []ipRange
+}
+
+// newRangeTable merges the prefixes of all the providers into a rangeTable.
+// Two CIDR prefixes are either disjoint or one contains the other, so the prefixes contained in a
+// wider one are dropped (in the unlikely case that they belong to another provider, the IPs stay
+// with the provider of the wider prefix).
+func newRangeTable(prefixes map[string][]*net.IPNet) *rangeTable {
+	ranges := make([]ipRange, 0)
+	for provider, list := range prefixes {
+		for _, prefix := range list {
+			first, last := prefixBounds(prefix)
+			ranges = append(ranges, ipRange{first: first, last: last, provider: provider})
+		}
+	}
+	// the wider prefix goes first among the ones starting at the same IP
+	sort.Slice(ranges, func(i, j int) bool {
+		if cmp := bytes.Compare(ranges[i].first[:], ranges[j].first[:]); cmp != 0 {
+			return cmp < 0
+		}
+		if cmp := bytes.Compare(ranges[i].last[:], ranges[j].last[:]); cmp != 0 {
+			return cmp > 0
+		}
+		return ranges[i].provider < ranges[j].provider
+	})
+
+	merged := make([]ipRange, 0, len(ranges))
+	for _, r := range ranges {
+		if len(merged) > 0 && bytes.Compare(r.first[:], merged[len(merged)-1].last[:]) <= 0 {
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return &rangeTable{ranges: merged}
+}
+
+// lookup returns the provider of the range containing the ip.
+func (t *rangeTable) lookup(ip net.IP) (string, bool) {
+	var key [16]byte
+	copy(key[:], ip.To16())
+	// first range starting after the ip, the candidate is the previous one
+	idx := sort.Search(len(t.ranges), func(i int) bool {
+		return bytes.Compare(t.ranges[i].first[:], key[:]) > 0
+	})
+	if idx == 0 {
+		return "", false
+	}
+	r := t.ranges[idx-1]
+	if bytes.Compare(key[:], r.last[:]) > 0 {
+		return "", false
+	}
+	return r.provider, true
+}
+
+// prefixBounds returns the first and the last IP of the prefix in their 16 bytes form.
+func prefixBounds(prefix *net.IPNet) (first, last [16]byte) {
+	ip := prefix.IP.To16()
+	mask := prefix.Mask
+	// the IPv4 masks apply to the last 4 bytes
+	offset := net.IPv6len - len(mask)
+	copy(first[:], ip)
+	copy(last[:], ip)
+	for i, b := range mask {
+		first[offset+i] &= b
+		last[offset+i] |= ^b
+	}
+	return first, last
+}
+
+// parseCIDRs parses a list of CIDRs.
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	prefixes := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, prefix, err := net.ParseCIDR(strings.TrimSpace(cidr))
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	return prefixes, nil
+}
+
+// readCIDRList returns the CIDRs of a data file, with one CIDR per line and # comments.
+func readCIDRList(content []byte) []string {
+	cidrs := make([]string, 0)
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if idx := strings.Index(line, "#"); idx >= 0 {
+			line = line[:idx]
+		}
+		line = strings.TrimSpace(line)
+		if line != "" {
+			cidrs = append(cidrs, line)
+		}
+	}
+	return cidrs
+}
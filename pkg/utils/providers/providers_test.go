@@ -0,0 +1,156 @@
+package providers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLookupProvider(t *testing.T) {
+	tests := []struct {
+		ip       string
+		provider string
+	}{
+		{"3.120.5.8", AWS},
+		{"54.224.0.0", AWS},
+		{"54.255.255.255", AWS},
+		{"2600:1f18::1", AWS},
+		{"34.105.3.2", GCP},
+		{"35.195.44.1", GCP},
+		{"20.50.1.1", Azure},
+		{"2603:1020::7", Azure},
+		{"95.217.10.20", Hetzner},
+		{"2a01:4f8:c17::1", Hetzner},
+		{"51.38.12.1", OVH},
+		{"54.37.1.1", OVH},
+		{"2001:41d0:8:1::1", OVH},
+		{"167.99.3.4", DigitalOcean},
+		{"2604:a880:400::1", DigitalOcean},
+		{"161.97.100.1", Contabo},
+		{"::ffff:161.97.100.1", Contabo},
+		// outside the known ranges
+		{"86.85.31.80", ""},
+		{"54.35.255.255", ""},
+		{"54.40.0.0", ""},
+		{"192.168.1.1", ""},
+		{"0.0.0.0", ""},
+		{"255.255.255.255", ""},
+		{"2a00:1450::1", ""},
+		{"not-an-ip", ""},
+		{"", ""},
+	}
+	for _, test := range tests {
+		provider, ok := LookupProvider(test.ip)
+		require.Equal(t, test.provider != "", ok, test.ip)
+		require.Equal(t, test.provider, provider, test.ip)
+	}
+}
+
+func TestClassifierOverlaps(t *testing.T) {
+	c, err := NewClassifier(map[string][]string{
+		"a": {"10.0.0.0/8", "10.1.0.0/16", "11.0.0.0/16", "11.1.0.0/16"},
+		"b": {"10.255.0.0/16", "10.255.255.0/24", "12.0.0.0/16", "12.0.0.0/16"},
+		"c": {"12.0.0.0/8", "13.0.0.0/8"},
+	})
+	require.NoError(t, err)
+	for ip, provider := range map[string]string{
+		"10.1.2.3":        "a",
+		"10.255.255.255":  "a",
+		"11.1.255.255":    "a",
+		"12.0.0.1":        "c",
+		"12.255.255.255":  "c",
+		"13.0.0.0":        "c",
+		"13.255.255.255":  "c",
+		"11.2.0.0":        "",
+		"14.0.0.0":        "",
+		"9.255.255.255":   "",
+		"::ffff:10.0.0.0": "a",
+	} {
+		found, ok := c.LookupProvider(ip)
+		require.Equal(t, provider != "", ok, ip)
+		require.Equal(t, provider, found, ip)
+	}
+	// the prefixes inside a wider one are dropped
+	require.Equal(t, 5, c.Ranges())
+
+	_, err = NewClassifier(map[string][]string{"a": {"10.0.0.0/33"}})
+	require.Error(t, err)
+}
+
+func TestRefresherUpdatesRanges(t *testing.T) {
+	aws := `{"prefixes":[{"ip_prefix":"86.85.31.0/24"}],"ipv6_prefixes":[{"ipv6_prefix":"2a00:1450::/32"}]}`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/aws":
+			fmt.Fprint(w, aws)
+		case "/gcp":
+			fmt.Fprint(w, `{"prefixes":[{"ipv4Prefix":"1.2.3.0/24"},{"ipv6Prefix":"2a00:1451::/32"}]}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	c, err := NewBundledClassifier()
+	require.NoError(t, err)
+	refresher := NewRefresher(context.Background(), c, []Source{
+		{Provider: AWS, URL: server.URL + "/aws", Parse: ParseAwsRanges},
+		{Provider: GCP, URL: server.URL + "/gcp", Parse: ParseGcpRanges},
+		{Provider: Azure, URL: server.URL + "/missing", Parse: ParseAwsRanges},
+	}, time.Hour)
+	require.Equal(t, 1, refresher.refresh())
+
+	for ip, provider := range map[string]string{
+		"86.85.31.80":   AWS,
+		"2a00:1450::1":  AWS,
+		"1.2.3.4":       GCP,
+		"2a00:1451::1":  GCP,
+		"20.50.1.1":     Azure, // the failed refresh keeps the previous ranges
+		"51.38.12.1":    OVH,
+		"3.120.5.8":     "",
+		"35.195.44.1":   "",
+		"2600:1f18::1":  "",
+		"86.85.32.1":    "",
+		"2a00:1452::11": "",
+	} {
+		found, ok := c.LookupProvider(ip)
+		require.Equal(t, provider != "", ok, ip)
+		require.Equal(t, provider, found, ip)
+	}
+
+	// an empty list never replaces the ranges
+	aws = `{"prefixes":[]}`
+	require.Equal(t, 2, refresher.refresh())
+	found, _ := c.LookupProvider("86.85.31.80")
+	require.Equal(t, AWS, found)
+}
+
+func TestLookupDuringUpdates(t *testing.T) {
+	c, err := NewBundledClassifier()
+	require.NoError(t, err)
+	var wg sync.WaitGroup
+	var updateErr error
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100 && updateErr == nil; i++ {
+			updateErr = c.UpdateProvider(AWS, []string{fmt.Sprintf("86.85.%d.0/24", i)})
+		}
+	}()
+	for i := 0; i < 1000; i++ {
+		provider, ok := c.LookupProvider("95.217.10.20")
+		require.True(t, ok)
+		require.Equal(t, Hetzner, provider)
+	}
+	wg.Wait()
+	require.NoError(t, updateErr)
+	provider, ok := c.LookupProvider("86.85.99.1")
+	require.True(t, ok)
+	require.Equal(t, AWS, provider)
+}
@@ -0,0 +1,177 @@
+package providers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	AwsRangesURL = "https://ip-ranges.amazonaws.com/ip-ranges.json"
+	GcpRangesURL = "https://www.gstatic.com/ipranges/cloud.json"
+
+	refreshTimeout = 30 * time.Second
+	maxRangesSize  = 16 << 20 // 16MB
+)
+
+// Source is a published list of ranges of a provider.
+type Source struct {
+	Provider string
+	URL      string
+	// parses the CIDRs out of the published document
+	Parse func(io.Reader) ([]string, error)
+}
+
+// DefaultSources returns the published ranges of AWS and GCP.
+func DefaultSources() []Source {
+	return []Source{
+		{Provider: AWS, URL: AwsRangesURL, Parse: ParseAwsRanges},
+		{Provider: GCP, URL: GcpRangesURL, Parse: ParseGcpRanges},
+	}
+}
+
+// ParseAwsRanges parses the CIDRs of the ip-ranges.json published by AWS.
+func ParseAwsRanges(r io.Reader) ([]string, error) {
+	var doc struct {
+		Prefixes []struct {
+			IPPrefix string `json:"ip_prefix"`
+		} `json:"prefixes"`
+		Ipv6Prefixes []struct {
+			Ipv6Prefix string `json:"ipv6_prefix"`
+		} `json:"ipv6_prefixes"`
+	}
+	err := json.NewDecoder(r).Decode(&doc)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to decode aws ranges")
+	}
+	cidrs := make([]string, 0, len(doc.Prefixes)+len(doc.Ipv6Prefixes))
+	for _, prefix := range doc.Prefixes {
+		cidrs = append(cidrs, prefix.IPPrefix)
+	}
+	for _, prefix := range doc.Ipv6Prefixes {
+		cidrs = append(cidrs, prefix.Ipv6Prefix)
+	}
+	return cidrs, nil
+}
+
+// ParseGcpRanges parses the CIDRs of the cloud.json published by GCP.
+func ParseGcpRanges(r io.Reader) ([]string, error) {
+	var doc struct {
+		Prefixes []struct {
+			Ipv4Prefix string `json:"ipv4Prefix"`
+			Ipv6Prefix string `json:"ipv6Prefix"`
+		} `json:"prefixes"`
+	}
+	err := json.NewDecoder(r).Decode(&doc)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to decode gcp ranges")
+	}
+	cidrs := make([]string, 0, len(doc.Prefixes))
+	for _, prefix := range doc.Prefixes {
+		if prefix.Ipv4Prefix != "" {
+			cidrs = append(cidrs, prefix.Ipv4Prefix)
+		}
+		if prefix.Ipv6Prefix != "" {
+			cidrs = append(cidrs, prefix.Ipv6Prefix)
+		}
+	}
+	return cidrs, nil
+}
+
+// Refresher periodically updates the ranges of a Classifier from the published lists of the providers.
+// A failed refresh keeps the previous ranges of the provider (the bundled ones at first).
+type Refresher struct {
+	ctx context.Context
+
+	classifier *Classifier
+	sources    []Source
+	interval   time.Duration
+	httpClient *http.Client
+
+	wg     sync.WaitGroup
+	closeC chan struct{}
+}
+
+// NewRefresher returns a Refresher that updates the classifier from the sources every interval.
+func NewRefresher(ctx context.Context, classifier *Classifier, sources []Source, interval time.Duration) *Refresher {
+	return &Refresher{
+		ctx:        ctx,
+		classifier: classifier,
+		sources:    sources,
+		interval:   interval,
+		httpClient: &http.Client{Timeout: refreshTimeout},
+		closeC:     make(chan struct{}),
+	}
+}
+
+// Start spawns the routine that refreshes the ranges right away and on every tick.
+func (r *Refresher) Start() {
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		r.refresh()
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				r.refresh()
+			case <-r.closeC:
+				return
+			case <-r.ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Close stops the refresh routine.
+func (r *Refresher) Close() {
+	close(r.closeC)
+	r.wg.Wait()
+}
+
+// refresh updates the ranges of every source, returning the number of sources that failed.
+func (r *Refresher) refresh() int {
+	failed := 0
+	for _, source := range r.sources {
+		cidrs, err := r.fetch(source)
+		if err == nil {
+			err = r.classifier.UpdateProvider(source.Provider, cidrs)
+		}
+		if err != nil {
+			failed++
+			log.Warn(errors.Wrap(err, "unable to refresh the ranges of "+source.Provider))
+			continue
+		}
+		log.WithFields(log.Fields{
+			"provider": source.Provider,
+			"prefixes": len(cidrs),
+		}).Debug("refreshed provider ranges")
+	}
+	return failed
+}
+
+// fetch downloads and parses the ranges of the source.
+func (r *Refresher) fetch(source Source) ([]string, error) {
+	req, err := http.NewRequestWithContext(r.ctx, http.MethodGet, source.URL, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to compose request")
+	}
+	resp, err := r.httpClient.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to fetch "+source.URL)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error HTTP %d fetching %s", resp.StatusCode, source.URL)
+	}
+	return source.Parse(io.LimitReader(resp.Body, maxRangesSize))
+}
@@ -0,0 +1,210 @@
+package utils
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// layout of the timestamp suffix of the rotated files
+const rotationTimeLayout = "20060102T150405.000000000Z"
+
+// RotationPolicy defines when a RotatingFile gets rotated. A zero limit disables it.
+type RotationPolicy struct {
+	MaxSize  int64         // bytes written into a file (before compression) to rotate it
+	MaxAge   time.Duration // time since a file was opened to rotate it
+	Compress bool          // gzip the content of the files
+}
+
+// Enabled returns whether any of the limits is set.
+func (p RotationPolicy) Enabled() bool {
+	return p.MaxSize > 0 || p.MaxAge > 0
+}
+
+// RotatingFile writes records into a file that gets rotated once it exceeds the size or the age
+// of its RotationPolicy. Rotating a file flushes, fsyncs and closes it (finishing the gzip stream
+// if compressed) before renaming it with a timestamp suffix, so a rotated file is always complete,
+// and a fresh file is opened at the original path starting with the header (if any).
+// The rotation only happens between records, so a record is never split across files.
+type RotatingFile struct {
+	m sync.Mutex
+
+	path   string
+	policy RotationPolicy
+	header []byte
+	nowFn  func() time.Time
+
+	f        *os.File
+	gzW      *gzip.Writer
+	w        io.Writer
+	size     int64
+	openedAt time.Time
+	rotated  []string
+}
+
+// NewRotatingFile opens the file at the given path, which is truncated unless appendMode is set.
+// The header is written at the beginning of every new (or empty) file.
+func NewRotatingFile(path string, policy RotationPolicy, header []byte, appendMode bool) (*RotatingFile, error) {
+	r := &RotatingFile{
+		path:    path,
+		policy:  policy,
+		header:  header,
+		nowFn:   time.Now,
+		rotated: make([]string, 0),
+	}
+	err := r.open(appendMode)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// open opens the file at the path, writing the header if it's empty (needs the lock).
+func (r *RotatingFile) open(appendMode bool) error {
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if appendMode {
+		flags = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	}
+	f, err := os.OpenFile(r.path, flags, 0644)
+	if err != nil {
+		return errors.Wrap(err, "unable to open file "+r.path)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return errors.Wrap(err, "unable to stat file "+r.path)
+	}
+	r.f = f
+	r.w = f
+	r.gzW = nil
+	if r.policy.Compress {
+		// appending to a compressed file adds a new gzip member, which is still a valid gzip stream
+		r.gzW = gzip.NewWriter(f)
+		r.w = r.gzW
+	}
+	r.size = info.Size()
+	r.openedAt = r.nowFn()
+	if r.size == 0 && len(r.header) > 0 {
+		n, err := r.w.Write(r.header)
+		r.size += int64(n)
+		if err != nil {
+			return errors.Wrap(err, "unable to write header into "+r.path)
+		}
+	}
+	return nil
+}
+
+// WriteRecord writes the whole record into the current file, rotating it first if the record
+// would exceed its size limit or if the file is older than the age limit. A file that only
+// contains the header is never rotated, so a record bigger than the limit gets its own file.
+func (r *RotatingFile) WriteRecord(record []byte) error {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if r.f == nil {
+		return errors.New("write into closed file " + r.path)
+	}
+	if r.needsRotation(int64(len(record))) {
+		err := r.rotate()
+		if err != nil {
+			return err
+		}
+	}
+	n, err := r.w.Write(record)
+	r.size += int64(n)
+	if err != nil {
+		return errors.Wrap(err, "unable to write record into "+r.path)
+	}
+	return nil
+}
+
+// needsRotation returns whether the file has to be rotated before writing recordSize bytes (needs the lock).
+func (r *RotatingFile) needsRotation(recordSize int64) bool {
+	if r.size <= int64(len(r.header)) {
+		return false
+	}
+	if r.policy.MaxSize > 0 && r.size+recordSize > r.policy.MaxSize {
+		return true
+	}
+	if r.policy.MaxAge > 0 && r.nowFn().Sub(r.openedAt) >= r.policy.MaxAge {
+		return true
+	}
+	return false
+}
+
+// rotate closes the current file, renames it with the timestamp suffix and opens a new one (needs the lock).
+func (r *RotatingFile) rotate() error {
+	err := r.closeFile()
+	if err != nil {
+		return err
+	}
+	rotatedPath := r.rotatedPath(r.nowFn())
+	err = os.Rename(r.path, rotatedPath)
+	if err != nil {
+		return errors.Wrap(err, "unable to rotate file "+r.path)
+	}
+	log.Debugf("rotated %s into %s", r.path, rotatedPath)
+	r.rotated = append(r.rotated, rotatedPath)
+	return r.open(false)
+}
+
+// rotatedPath returns a free path for the rotated file, with the timestamp before the extension
+// (i.e. peers-20230102T150405.000000000Z.csv.gz for peers.csv.gz).
+func (r *RotatingFile) rotatedPath(t time.Time) string {
+	dir, base := filepath.Split(r.path)
+	name, ext := base, ""
+	if idx := strings.Index(base, "."); idx > 0 {
+		name, ext = base[:idx], base[idx:]
+	}
+	stamp := t.UTC().Format(rotationTimeLayout)
+	rotatedPath := filepath.Join(dir, fmt.Sprintf("%s-%s%s", name, stamp, ext))
+	for i := 1; CheckFileExists(rotatedPath); i++ {
+		rotatedPath = filepath.Join(dir, fmt.Sprintf("%s-%s-%d%s", name, stamp, i, ext))
+	}
+	return rotatedPath
+}
+
+// closeFile flushes, fsyncs and closes the current file (needs the lock).
+func (r *RotatingFile) closeFile() error {
+	var err error
+	if r.gzW != nil {
+		err = r.gzW.Close()
+	}
+	if err == nil {
+		err = r.f.Sync()
+	}
+	closeErr := r.f.Close()
+	if err == nil {
+		err = closeErr
+	}
+	r.f, r.gzW, r.w = nil, nil, nil
+	if err != nil {
+		return errors.Wrap(err, "unable to close file "+r.path)
+	}
+	return nil
+}
+
+// RotatedFiles returns the paths of the files rotated so far, from the oldest to the newest.
+func (r *RotatingFile) RotatedFiles() []string {
+	r.m.Lock()
+	defer r.m.Unlock()
+	return append(make([]string, 0, len(r.rotated)), r.rotated...)
+}
+
+// Close flushes, fsyncs and closes the current file, which keeps the original path.
+func (r *RotatingFile) Close() error {
+	r.m.Lock()
+	defer r.m.Unlock()
+	if r.f == nil {
+		return nil
+	}
+	return r.closeFile()
+}
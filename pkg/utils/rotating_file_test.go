@@ -0,0 +1,124 @@
+package utils
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testRotationHeader = "id,value\n"
+
+// readRotatedFiles returns the content of the rotated files followed by the current one.
+func readRotatedFiles(t *testing.T, f *RotatingFile, path string, compressed bool) []string {
+	contents := make([]string, 0)
+	for _, filePath := range append(f.RotatedFiles(), path) {
+		raw, err := ioutil.ReadFile(filePath)
+		require.NoError(t, err)
+		if compressed {
+			gzR, err := gzip.NewReader(bytes.NewReader(raw))
+			require.NoError(t, err)
+			raw, err = ioutil.ReadAll(gzR)
+			require.NoError(t, err)
+		}
+		contents = append(contents, string(raw))
+	}
+	return contents
+}
+
+func Test_RotatingFileBySize(t *testing.T) {
+	for _, compressed := range []bool{false, true} {
+		t.Run(fmt.Sprintf("compressed=%t", compressed), func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "export.csv")
+			policy := RotationPolicy{MaxSize: 64, Compress: compressed}
+			f, err := NewRotatingFile(path, policy, []byte(testRotationHeader), false)
+			require.NoError(t, err)
+
+			var dataset strings.Builder
+			for i := 0; i < 50; i++ {
+				record := fmt.Sprintf("%d,value-%d\n", i, i)
+				dataset.WriteString(record)
+				require.NoError(t, f.WriteRecord([]byte(record)))
+			}
+			require.NoError(t, f.Close())
+			require.Greater(t, len(f.RotatedFiles()), 5)
+
+			var joined strings.Builder
+			for _, content := range readRotatedFiles(t, f, path, compressed) {
+				// every file has the header and only complete records, within the limit
+				require.True(t, strings.HasPrefix(content, testRotationHeader))
+				require.True(t, strings.HasSuffix(content, "\n"))
+				require.LessOrEqual(t, len(content), 64)
+				joined.WriteString(strings.TrimPrefix(content, testRotationHeader))
+			}
+			require.Equal(t, dataset.String(), joined.String())
+		})
+	}
+}
+
+func Test_RotatingFileByAge(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "summary.log")
+	f, err := NewRotatingFile(path, RotationPolicy{MaxAge: time.Hour}, nil, true)
+	require.NoError(t, err)
+	now := time.Unix(1000, 0)
+	f.nowFn = func() time.Time { return now }
+	f.openedAt = now
+
+	require.NoError(t, f.WriteRecord([]byte("report 1\n")))
+	now = now.Add(30 * time.Minute)
+	require.NoError(t, f.WriteRecord([]byte("report 2\n")))
+	require.Equal(t, 0, len(f.RotatedFiles()))
+
+	now = now.Add(30 * time.Minute)
+	require.NoError(t, f.WriteRecord([]byte("report 3\n")))
+	require.NoError(t, f.Close())
+
+	rotated := f.RotatedFiles()
+	require.Equal(t, 1, len(rotated))
+	require.Equal(t, filepath.Join(filepath.Dir(path), "summary-19700101T011640.000000000Z.log"), rotated[0])
+	require.Equal(t, []string{"report 1\nreport 2\n", "report 3\n"}, readRotatedFiles(t, f, path, false))
+}
+
+func Test_RotatingFileOversizedRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "export.csv")
+	f, err := NewRotatingFile(path, RotationPolicy{MaxSize: 16}, []byte(testRotationHeader), false)
+	require.NoError(t, err)
+
+	// a record bigger than the limit is never split, it gets a file on its own
+	big := strings.Repeat("x", 40) + "\n"
+	require.NoError(t, f.WriteRecord([]byte(big)))
+	require.NoError(t, f.WriteRecord([]byte("1,a\n")))
+	require.NoError(t, f.Close())
+	require.Equal(t, []string{testRotationHeader + big, testRotationHeader + "1,a\n"}, readRotatedFiles(t, f, path, false))
+
+	require.Error(t, f.WriteRecord([]byte("2,b\n")))
+}
+
+func Test_RotatingFileAppend(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "summary.log")
+	require.NoError(t, ioutil.WriteFile(path, []byte(testRotationHeader+"old\n"), 0644))
+
+	// appending keeps the existing content and doesn't repeat the header
+	f, err := NewRotatingFile(path, RotationPolicy{}, []byte(testRotationHeader), true)
+	require.NoError(t, err)
+	require.NoError(t, f.WriteRecord([]byte("new\n")))
+	require.NoError(t, f.Close())
+	content, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, testRotationHeader+"old\nnew\n", string(content))
+
+	// truncating starts over
+	f, err = NewRotatingFile(path, RotationPolicy{}, []byte(testRotationHeader), false)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	require.Equal(t, int64(len(testRotationHeader)), info.Size())
+}
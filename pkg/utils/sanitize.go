@@ -0,0 +1,91 @@
+package utils
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// DefaultMaxUserAgentLength is the default cap (in bytes) of the user agents that we keep.
+const DefaultMaxUserAgentLength = 1024
+
+// MaxUserAgentLength is the cap (in bytes) of the user agents that we keep, longer ones get truncated.
+var MaxUserAgentLength = DefaultMaxUserAgentLength
+
+const (
+	asciiEsc = 0x1b
+	asciiBel = 0x07
+)
+
+// SanitizeUserAgent returns the user agent without NULs, ANSI escape sequences, invalid UTF-8 and
+// other non-printable characters, truncated to MaxUserAgentLength bytes, and whether it got modified.
+func SanitizeUserAgent(userAgent string) (string, bool) {
+	maxLen := MaxUserAgentLength
+	if maxLen <= 0 {
+		maxLen = DefaultMaxUserAgentLength
+	}
+	clean := true
+	for i := 0; i < len(userAgent); i++ {
+		if c := userAgent[i]; c < ' ' || c >= 0x7f {
+			clean = false
+			break
+		}
+	}
+	if clean && len(userAgent) <= maxLen {
+		return userAgent, false
+	}
+
+	var b strings.Builder
+	modified := false
+	for i := 0; i < len(userAgent); {
+		r, size := utf8.DecodeRuneInString(userAgent[i:])
+		if r == asciiEsc {
+			i += escapeSequenceLen(userAgent[i:])
+			modified = true
+			continue
+		}
+		if (r == utf8.RuneError && size == 1) || (!unicode.IsPrint(r) && r != ' ') {
+			i += size
+			modified = true
+			continue
+		}
+		if b.Len()+size > maxLen {
+			// truncated, without splitting the rune
+			return b.String(), true
+		}
+		b.WriteString(userAgent[i : i+size])
+		i += size
+	}
+	return b.String(), modified
+}
+
+// escapeSequenceLen returns the length of the ANSI escape sequence at the start of s (starting with ESC):
+// CSI sequences ("ESC [" params and a final byte), OSC sequences ("ESC ]" until BEL or "ESC \"),
+// or ESC followed by a single character.
+func escapeSequenceLen(s string) int {
+	if len(s) < 2 {
+		return len(s)
+	}
+	switch s[1] {
+	case '[':
+		for i := 2; i < len(s); i++ {
+			if s[i] >= 0x40 && s[i] <= 0x7e {
+				return i + 1
+			}
+		}
+		return len(s)
+	case ']':
+		for i := 2; i < len(s); i++ {
+			if s[i] == asciiBel {
+				return i + 1
+			}
+			if s[i] == asciiEsc && i+1 < len(s) && s[i+1] == '\\' {
+				return i + 2
+			}
+		}
+		return len(s)
+	default:
+		_, size := utf8.DecodeRuneInString(s[1:])
+		return 1 + size
+	}
+}
@@ -0,0 +1,48 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSanitizeUserAgent(t *testing.T) {
+	for raw, expected := range map[string]string{
+		// untouched
+		"Lighthouse/v3.1.0-aa022f4/x86_64-linux": "Lighthouse/v3.1.0-aa022f4/x86_64-linux",
+		"nimbus/ñandú":                           "nimbus/ñandú",
+		// NULs and control characters
+		"Prysm/v3.1.1\x00\x00":      "Prysm/v3.1.1",
+		"teku\r\n/v22.9.0\t":        "teku/v22.9.0",
+		"lodestar/\x7fv1.1.0\u200b": "lodestar/v1.1.0",
+		// ANSI escape sequences (CSI, OSC and single character)
+		"\x1b[31;1mPrysm\x1b[0m/v3.1.1":              "Prysm/v3.1.1",
+		"\x1b]0;pwned\x07Prysm/\x1b]2;x\x1b\\v3.1.1": "Prysm/v3.1.1",
+		"\x1bcPrysm\x1b[":                            "Prysm",
+		// invalid UTF-8
+		"erigon/\xff\xfev2.29.0": "erigon/v2.29.0",
+	} {
+		sanitized, modified := SanitizeUserAgent(raw)
+		require.Equal(t, expected, sanitized, "%q", raw)
+		require.Equal(t, raw != expected, modified, "%q", raw)
+	}
+}
+
+func TestSanitizeLongUserAgent(t *testing.T) {
+	// a 1MB agent gets truncated to the cap
+	raw := strings.Repeat("a\x00", 512*1024)
+	sanitized, modified := SanitizeUserAgent(raw)
+	require.True(t, modified)
+	require.Equal(t, strings.Repeat("a", DefaultMaxUserAgentLength), sanitized)
+
+	// without splitting the runes
+	defer func(maxLen int) { MaxUserAgentLength = maxLen }(MaxUserAgentLength)
+	MaxUserAgentLength = 8
+	sanitized, modified = SanitizeUserAgent("nimbus/ñandú")
+	require.True(t, modified)
+	require.Equal(t, "nimbus/", sanitized)
+	sanitized, modified = SanitizeUserAgent("teku/v22")
+	require.False(t, modified)
+	require.Equal(t, "teku/v22", sanitized)
+}
@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"regexp"
 	"strings"
 
 	log "github.com/sirupsen/logrus"
@@ -10,6 +11,7 @@ type NetworkType string
 type ClientName string
 type ClientOS string
 type ClientArch string
+type PeerCategory string
 
 const (
 	// Libp2p Available Networks
@@ -52,6 +54,11 @@ const (
 	X86_64 ClientArch = "x86_64"
 
 	Unknown string = "unknown"
+
+	// Peer categories
+	EthConsensusCategory PeerCategory = "eth-consensus"
+	OtherLibp2pCategory  PeerCategory = "other-libp2p"
+	UnknownCategory      PeerCategory = PeerCategory(Unknown)
 )
 
 // Ethereum CL CLients
@@ -64,7 +71,7 @@ var EthCLClients map[ClientName][]string = map[ClientName][]string{
 	Grandine:   {"grandine", "rust-libp2p"},
 	Cortex:     {"cortex"},
 	Trinity:    {"trinity"},
-	Erigon:     {"erigon", "erigon/lightclient"},
+	Erigon:     {"erigon", "erigon/lightclient", "caplin"},
 }
 
 // IPFS Clients
@@ -82,6 +89,12 @@ var FilecoinClients map[ClientName][]string = map[ClientName][]string{
 	Lotus: {"lotus"},
 }
 
+// Non-Ethereum libp2p clients that share the DHT space with the Ethereum nodes
+var OtherLibp2pClients map[ClientName][]string = mergeClients(IpfsClients, FilecoinClients)
+
+// Clients that the user agents of the Ethereum network are fuzzy-matched against
+var ethFuzzyClients map[ClientName][]string = mergeClients(EthCLClients, OtherLibp2pClients)
+
 // Valid OS
 var ValidOs map[ClientOS][]string = map[ClientOS][]string{
 	Mac:     {"macos", "freebsd"},
@@ -91,13 +104,17 @@ var ValidOs map[ClientOS][]string = map[ClientOS][]string{
 
 // Valid Architectures
 var ValidArchs map[ClientArch][]string = map[ClientArch][]string{
-	Arm:    {"aarch64", "aarch", "aarch_64"},
-	X86_64: {"x86_64"},
+	Arm:    {"aarch64", "aarch", "aarch_64", "arm64"},
+	X86_64: {"x86_64", "amd64"},
 }
 
+// matches the fields of the user agents that hold the version (v1.5.1-b0ac346, 0.36.1, ...)
+var versionFieldRegex = regexp.MustCompile(`^v?[0-9]+(\.[0-9]+)+`)
+
 // Examples:
 // Teku: teku/teku/v21.8.2/linux-x86_64/corretto-java-16
 // Teku: teku/teku/v21.7.0+9-g77b4b9e/linux-x86_64/-ubuntu-openjdk64bitservervm-java-11
+// Teku: teku/v23.10.0/linux-x86_64/-eclipseadoptium-openjdk64bitservervm-java-17
 // Prysm: Prysm/v1.4.3/8bca66ac6408a03af52d65541f58384007ed50ef
 // Prysm: Prysm/v1.3.8-hotfix+6c0942/6c09424feb3141b96016bed817d7ade1cd75deb7
 // Lighthouse: Lighthouse/v1.5.1-b0ac346/x86_64-linux
@@ -108,6 +125,26 @@ var ValidArchs map[ClientArch][]string = map[ClientArch][]string{
 // lotus: lotus-1.13.0+mainnet+git.7a55e8e8
 
 func ParseClientType(network NetworkType, userAgent string) (cliName string, cliVersion string, cliOs string, cliArch string) {
+	cliInfo := ParseClient(network, userAgent)
+	return cliInfo.Name, cliInfo.Version, cliInfo.OS, cliInfo.Arch
+}
+
+// ClientInfo is the client parsed out of a user agent, with the confidence of the client name
+// (see ClientMatch).
+type ClientInfo struct {
+	Name       string
+	Version    string
+	OS         string
+	Arch       string
+	Confidence float64
+}
+
+// ParseClient parses the client out of the user agent. The user agents that the exact rules of the
+// network can't classify go through FuzzyClientMatch, and the ones that still end up unknown get counted
+// (see UnknownAgents) and an empty version. The version is the first field that looks like one, without
+// the git hash or build suffixes, and it is unknown for the known clients that don't share it.
+func ParseClient(network NetworkType, userAgent string) ClientInfo {
+	var cliInfo ClientInfo
 
 	// split the UserAgent into chunks divided by '/'
 	splUserAgent := strings.Split(userAgent, "/")
@@ -116,57 +153,75 @@ func ParseClientType(network NetworkType, userAgent string) (cliName string, cli
 	switch network {
 	case EthereumNetwork:
 		// parse client name from Ethereum Valid Clients
-		client := ClientNameParser(EthCLClients, splUserAgent[0])
+		match := ClientMatch{Name: ClientNameParser(EthCLClients, splUserAgent[0]), Confidence: ExactClientConfidence}
+		if match.IsUnknown() {
+			// IPFS/Filecoin nodes also show up in the discv5/DHT space, keep their name instead of unknown
+			match.Name = ClientNameParser(OtherLibp2pClients, splUserAgent[0])
+		}
+		if match.IsUnknown() {
+			match = FuzzyClientMatch(ethFuzzyClients, userAgent)
+		}
 
 		// stract the version from the user
 		var version string
-		switch client {
-		case Prysm, Lighthouse, Lodestar, Grandine, Nimbus, Cortex, Trinity, Erigon:
-			version = cleanVersion(getVersionIfAny(splUserAgent, 1))
-		case Teku:
-			version = cleanVersion(getVersionIfAny(splUserAgent, 2))
-
+		switch match.Name {
+		case Prysm, Lighthouse, Teku, Lodestar, Grandine, Nimbus, Cortex, Trinity, Erigon:
+			version = parseVersion(splUserAgent)
+		case ClientName(Unknown):
+			recordUnknownAgent(network, userAgent)
+		case Lotus:
+			version = cleanVersion(cleanVersionLotus(splUserAgent[0]))
 		default:
-			log.Errorf("unable to determine client name for UserAgent %s", userAgent)
-			version = Unknown
+			log.Debugf("non-ethereum libp2p UserAgent %s", userAgent)
+			version = parseVersion(splUserAgent)
 		}
 
-		cliName = string(client)
-		cliVersion = version
+		cliInfo.Name = string(match.Name)
+		cliInfo.Version = version
+		cliInfo.Confidence = match.Confidence
 
 	case IpfsNetwork:
 		// parse client name from Ethereum Valid Clients
-		client := ClientNameParser(IpfsClients, splUserAgent[0])
+		match := ClientMatch{Name: ClientNameParser(IpfsClients, splUserAgent[0]), Confidence: ExactClientConfidence}
+		if match.IsUnknown() {
+			match = FuzzyClientMatch(IpfsClients, userAgent)
+		}
 
 		// stract the version from the user
 		var version string
-		switch client {
+		switch match.Name {
 		case GoIpfs, Kubo, Ioi, Storm, HydraBooster:
-			version = cleanVersion(getVersionIfAny(splUserAgent, 1))
+			version = parseVersion(splUserAgent)
+		case ClientName(Unknown):
+			recordUnknownAgent(network, userAgent)
 		default:
-			log.Errorf("unable to determine client name for UserAgent %s", userAgent)
+			log.Errorf("unable to determine client version for UserAgent %s", userAgent)
 			version = Unknown
 		}
 
-		cliName = string(client)
-		cliVersion = version
+		cliInfo.Name = string(match.Name)
+		cliInfo.Version = version
+		cliInfo.Confidence = match.Confidence
 
 	case FilecoinNetwork:
 		// parse client name from Ethereum Valid Clients
-		client := ClientNameParser(FilecoinClients, splUserAgent[0])
+		match := ClientMatch{Name: ClientNameParser(FilecoinClients, splUserAgent[0]), Confidence: ExactClientConfidence}
+		if match.IsUnknown() {
+			match = FuzzyClientMatch(FilecoinClients, userAgent)
+		}
 
 		// stract the version from the user
 		var version string
-		switch client {
+		switch match.Name {
 		case Lotus:
 			version = cleanVersion(cleanVersionLotus(splUserAgent[0]))
 		default:
-			log.Errorf("unable to determine client name for UserAgent %s", userAgent)
-			version = Unknown
+			recordUnknownAgent(network, userAgent)
 		}
 
-		cliName = string(client)
-		cliVersion = version
+		cliInfo.Name = string(match.Name)
+		cliInfo.Version = version
+		cliInfo.Confidence = match.Confidence
 
 	default:
 		log.Error("unable to retrieve the user_agent from network", network)
@@ -175,10 +230,22 @@ func ParseClientType(network NetworkType, userAgent string) (cliName string, cli
 	os := ClientOSParser(ValidOs, userAgent)
 	arch := ClientArchParser(ValidArchs, userAgent)
 
-	cliOs = string(os)
-	cliArch = string(arch)
+	cliInfo.OS = string(os)
+	cliInfo.Arch = string(arch)
+
+	return cliInfo
+}
 
-	return
+// ParsePeerCategory classifies the peer from its parsed client name, telling apart
+// the Ethereum consensus clients from the other libp2p ones (IPFS, Filecoin, etc).
+func ParsePeerCategory(cliName string) PeerCategory {
+	if _, ok := EthCLClients[ClientName(cliName)]; ok {
+		return EthConsensusCategory
+	}
+	if _, ok := OtherLibp2pClients[ClientName(cliName)]; ok {
+		return OtherLibp2pCategory
+	}
+	return UnknownCategory
 }
 
 func ClientNameParser(validNames map[ClientName][]string, parsingName string) ClientName {
@@ -230,12 +297,15 @@ func strContainsLowerCaps(s string, subStr string) bool {
 	return strings.Contains(strings.ToLower(s), strings.ToLower(subStr))
 }
 
-func getVersionIfAny(fields []string, index int) string {
-	if index > (len(fields) - 1) {
-		return Unknown
-	} else {
-		return fields[index]
+// parseVersion returns the version of the first field after the client name that starts with one,
+// without its git hash or build suffixes, or unknown if there is none (i.e. "erigon/lightclient").
+func parseVersion(fields []string) string {
+	for _, field := range fields[1:] {
+		if version := versionFieldRegex.FindString(strings.ToLower(field)); version != "" {
+			return version
+		}
 	}
+	return Unknown
 }
 
 func cleanVersion(version string) string {
@@ -246,6 +316,19 @@ func cleanVersion(version string) string {
 
 func cleanVersionLotus(version string) string {
 	cleaned := strings.Split(version, "+")[0]
-	cleaned = strings.Split(cleaned, "-")[1]
-	return cleaned
+	fields := strings.Split(cleaned, "-")
+	if len(fields) < 2 {
+		return Unknown
+	}
+	return fields[1]
+}
+
+func mergeClients(clientSets ...map[ClientName][]string) map[ClientName][]string {
+	merged := make(map[ClientName][]string)
+	for _, clients := range clientSets {
+		for cName, subCliNames := range clients {
+			merged[cName] = append(merged[cName], subCliNames...)
+		}
+	}
+	return merged
 }
@@ -30,6 +30,27 @@ var Eth2TestClients []clientInfoTest = []clientInfoTest{
 		clientOS:      "linux",
 		clientArch:    "x86_64",
 	},
+	{
+		userAgent:     "teku/v23.10.0/linux-x86_64/-eclipseadoptium-openjdk64bitservervm-java-17",
+		clientName:    "teku",
+		clientVersion: "v23.10.0",
+		clientOS:      "linux",
+		clientArch:    "x86_64",
+	},
+	{
+		userAgent:     "teku/v23.9.1/linux-aarch_64/-eclipseadoptium-openjdk64bitservervm-java-17",
+		clientName:    "teku",
+		clientVersion: "v23.9.1",
+		clientOS:      "linux",
+		clientArch:    "arm",
+	},
+	{
+		userAgent:     "teku/v22.10.1/windows-x86_64/-microsoft-openjdk64bitservervm-java-17",
+		clientName:    "teku",
+		clientVersion: "v22.10.1",
+		clientOS:      "windows",
+		clientArch:    "x86_64",
+	},
 	{
 		userAgent:     "Prysm/v1.4.3/8bca66ac6408a03af52d65541f58384007ed50ef",
 		clientName:    "prysm",
@@ -44,6 +65,13 @@ var Eth2TestClients []clientInfoTest = []clientInfoTest{
 		clientOS:      "unknown",
 		clientArch:    "unknown",
 	},
+	{
+		userAgent:     "Prysm/v4.1.1/0cb0ca8d5c32f0f9bcb5c3da10ec2fd8fb53c5d6",
+		clientName:    "prysm",
+		clientVersion: "v4.1.1",
+		clientOS:      "unknown",
+		clientArch:    "unknown",
+	},
 	{
 		userAgent:     "Lighthouse/v1.5.1-b0ac346/x86_64-linux",
 		clientName:    "lighthouse",
@@ -65,6 +93,27 @@ var Eth2TestClients []clientInfoTest = []clientInfoTest{
 		clientOS:      "linux",
 		clientArch:    "arm",
 	},
+	{
+		userAgent:     "Lighthouse/v4.5.0-441fc16/x86_64-linux",
+		clientName:    "lighthouse",
+		clientVersion: "v4.5.0",
+		clientOS:      "linux",
+		clientArch:    "x86_64",
+	},
+	{
+		userAgent:     "Lighthouse/v4.5.0-441fc16/x86_64-windows",
+		clientName:    "lighthouse",
+		clientVersion: "v4.5.0",
+		clientOS:      "windows",
+		clientArch:    "x86_64",
+	},
+	{
+		userAgent:     "LIGHTHOUSE/V4.2.0-C547A11/X86_64-LINUX",
+		clientName:    "lighthouse",
+		clientVersion: "v4.2.0",
+		clientOS:      "linux",
+		clientArch:    "x86_64",
+	},
 	{
 		userAgent:     "nimbus",
 		clientName:    "nimbus",
@@ -72,6 +121,13 @@ var Eth2TestClients []clientInfoTest = []clientInfoTest{
 		clientOS:      "unknown",
 		clientArch:    "unknown",
 	},
+	{
+		userAgent:     "nim-libp2p/0.0.1",
+		clientName:    "nimbus",
+		clientVersion: "0.0.1",
+		clientOS:      "unknown",
+		clientArch:    "unknown",
+	},
 	{
 		userAgent:     "rust-libp2p/0.36.1",
 		clientName:    "grandine",
@@ -79,6 +135,13 @@ var Eth2TestClients []clientInfoTest = []clientInfoTest{
 		clientOS:      "unknown",
 		clientArch:    "unknown",
 	},
+	{
+		userAgent:     "Grandine/0.3.0-9a85b72/x86_64-linux",
+		clientName:    "grandine",
+		clientVersion: "0.3.0",
+		clientOS:      "linux",
+		clientArch:    "x86_64",
+	},
 	{
 		userAgent:     "js-libp2p/0.36.2",
 		clientName:    "lodestar",
@@ -86,6 +149,13 @@ var Eth2TestClients []clientInfoTest = []clientInfoTest{
 		clientOS:      "unknown",
 		clientArch:    "unknown",
 	},
+	{
+		userAgent:     "js-libp2p/0.42.2 UserAgent=v18.16.0",
+		clientName:    "lodestar",
+		clientVersion: "0.42.2",
+		clientOS:      "unknown",
+		clientArch:    "unknown",
+	},
 	{
 		userAgent:     "lodestar/v1.2.0",
 		clientName:    "lodestar",
@@ -94,26 +164,68 @@ var Eth2TestClients []clientInfoTest = []clientInfoTest{
 		clientArch:    "unknown",
 	},
 	{
-		userAgent:     "nim-libp2p/0.0.1",
-		clientName:    "nimbus",
-		clientVersion: "0.0.1",
+		userAgent:     "Lodestar/v1.12.0/bd7a8b0",
+		clientName:    "lodestar",
+		clientVersion: "v1.12.0",
 		clientOS:      "unknown",
 		clientArch:    "unknown",
 	},
 	{
 		userAgent:     "erigon/lightclient",
-		clientname:    "erigon",
+		clientName:    "erigon",
 		clientVersion: "unknown",
 		clientOS:      "unknown",
 		clientArch:    "unknown",
 	},
 	{
 		userAgent:     "erigon",
-		clientname:    "erigon",
+		clientName:    "erigon",
 		clientVersion: "unknown",
 		clientOS:      "unknown",
 		clientArch:    "unknown",
 	},
+	{
+		userAgent:     "erigon/caplin",
+		clientName:    "erigon",
+		clientVersion: "unknown",
+		clientOS:      "unknown",
+		clientArch:    "unknown",
+	},
+	{
+		userAgent:     "kubo/0.18.1/675f8bd",
+		clientName:    "kubo",
+		clientVersion: "0.18.1",
+		clientOS:      "unknown",
+		clientArch:    "unknown",
+	},
+	{
+		userAgent:     "lotus-1.13.0+mainnet+git.7a55e8e8",
+		clientName:    "lotus",
+		clientVersion: "1.13.0",
+		clientOS:      "unknown",
+		clientArch:    "unknown",
+	},
+	{
+		userAgent:     "",
+		clientName:    "unknown",
+		clientVersion: "",
+		clientOS:      "unknown",
+		clientArch:    "unknown",
+	},
+	{
+		userAgent:     "some-random-agent/1.0.0",
+		clientName:    "unknown",
+		clientVersion: "",
+		clientOS:      "unknown",
+		clientArch:    "unknown",
+	},
+	{
+		userAgent:     "////",
+		clientName:    "unknown",
+		clientVersion: "",
+		clientOS:      "unknown",
+		clientArch:    "unknown",
+	},
 }
 
 var IPFSTestClients []string = []string{
@@ -133,9 +245,38 @@ func Test_FilterClientType(t *testing.T) {
 	for _, cliInf := range Eth2TestClients {
 		fmt.Println(cliInf)
 		client, version, os, arch := ParseClientType(EthereumNetwork, cliInf.userAgent)
-		require.Equal(t, client, cliInf.clientName)
-		require.Equal(t, version, cliInf.clientVersion)
-		require.Equal(t, os, cliInf.clientOS)
-		require.Equal(t, arch, cliInf.clientArch)
+		require.Equal(t, cliInf.clientName, client, cliInf.userAgent)
+		require.Equal(t, cliInf.clientVersion, version, cliInf.userAgent)
+		require.Equal(t, cliInf.clientOS, os, cliInf.userAgent)
+		require.Equal(t, cliInf.clientArch, arch, cliInf.userAgent)
+	}
+}
+
+func Test_ClientArchAliases(t *testing.T) {
+	require.Equal(t, X86_64, ClientArchParser(ValidArchs, "linux-amd64"))
+	require.Equal(t, Arm, ClientArchParser(ValidArchs, "linux-arm64"))
+	require.Equal(t, ClientArch(Unknown), ClientArchParser(ValidArchs, "riscv64"))
+}
+
+func Test_PeerCategory(t *testing.T) {
+	tests := []struct {
+		userAgent     string
+		clientName    string
+		clientVersion string
+		category      PeerCategory
+	}{
+		{"Lighthouse/v3.1.2/aarch64-macos", "lighthouse", "v3.1.2", EthConsensusCategory},
+		{"teku/teku/v21.8.2/linux-x86_64/corretto-java-16", "teku", "v21.8.2", EthConsensusCategory},
+		{"kubo/0.18.1/675f8bd", "kubo", "0.18.1", OtherLibp2pCategory},
+		{"go-ipfs/0.8.0/48f94e2", "go-ipfs", "0.8.0", OtherLibp2pCategory},
+		{"lotus-1.13.0+mainnet+git.7a55e8e8", "lotus", "1.13.0", OtherLibp2pCategory},
+		{"lotus", "lotus", "unknown", OtherLibp2pCategory},
+		{"some-random-agent/1.0.0", "unknown", "", UnknownCategory},
+	}
+	for _, test := range tests {
+		client, version, _, _ := ParseClientType(EthereumNetwork, test.userAgent)
+		require.Equal(t, test.clientName, client, test.userAgent)
+		require.Equal(t, test.clientVersion, version, test.userAgent)
+		require.Equal(t, test.category, ParsePeerCategory(client), test.userAgent)
 	}
 }
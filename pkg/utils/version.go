@@ -0,0 +1,4 @@
+package utils
+
+// CrawlerVersion is the version of the running crawler (set on start), reported in the exports.
+var CrawlerVersion = "unknown"
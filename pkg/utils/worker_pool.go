@@ -0,0 +1,116 @@
+package utils
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// PoolTask is a unit of work of the WorkerPool. The context expires after the timeout of the pool,
+// or when the context of the pool dies.
+type PoolTask func(ctx context.Context)
+
+// WorkerPool runs the submitted tasks on a fixed number of workers, through a bounded queue.
+// The tasks that don't fit in the queue are dropped instead of blocking the caller.
+type WorkerPool struct {
+	ctx     context.Context
+	workers int
+	timeout time.Duration
+
+	// m guards the queue against the submissions after closing it
+	m      sync.RWMutex
+	queue  chan PoolTask
+	closed bool
+
+	startOnce sync.Once
+	wg        sync.WaitGroup
+
+	dropped uint64
+}
+
+// NewWorkerPool returns a WorkerPool of the given number of workers, that queues up to queueSize tasks
+// and gives each task up to timeout to finish (0 doesn't limit it).
+func NewWorkerPool(ctx context.Context, workers, queueSize int, timeout time.Duration) *WorkerPool {
+	if workers <= 0 {
+		workers = 1
+	}
+	if queueSize < 0 {
+		queueSize = 0
+	}
+	return &WorkerPool{
+		ctx:     ctx,
+		workers: workers,
+		timeout: timeout,
+		queue:   make(chan PoolTask, queueSize),
+	}
+}
+
+// Start spawns the workers. The tasks submitted before starting the pool wait in the queue.
+func (p *WorkerPool) Start() {
+	p.startOnce.Do(func() {
+		for i := 0; i < p.workers; i++ {
+			p.wg.Add(1)
+			go p.work()
+		}
+	})
+}
+
+func (p *WorkerPool) work() {
+	defer p.wg.Done()
+	for task := range p.queue {
+		p.run(task)
+	}
+}
+
+func (p *WorkerPool) run(task PoolTask) {
+	ctx, cancel := p.ctx, context.CancelFunc(func() {})
+	if p.timeout > 0 {
+		ctx, cancel = context.WithTimeout(p.ctx, p.timeout)
+	}
+	defer cancel()
+	task(ctx)
+}
+
+// TrySubmit queues the task without blocking. Returns false (and counts the task as dropped)
+// if the queue is full or the pool was closed.
+func (p *WorkerPool) TrySubmit(task PoolTask) bool {
+	p.m.RLock()
+	defer p.m.RUnlock()
+	if p.closed {
+		atomic.AddUint64(&p.dropped, 1)
+		return false
+	}
+	select {
+	case p.queue <- task:
+		return true
+	default:
+		atomic.AddUint64(&p.dropped, 1)
+		return false
+	}
+}
+
+// QueueDepth returns the number of tasks waiting for a worker.
+func (p *WorkerPool) QueueDepth() int {
+	return len(p.queue)
+}
+
+// Dropped returns the number of tasks that were dropped since the start.
+func (p *WorkerPool) Dropped() uint64 {
+	return atomic.LoadUint64(&p.dropped)
+}
+
+// Close stops accepting tasks and waits until the workers finish the queued ones.
+func (p *WorkerPool) Close() {
+	p.m.Lock()
+	if p.closed {
+		p.m.Unlock()
+		return
+	}
+	p.closed = true
+	close(p.queue)
+	p.m.Unlock()
+	// a pool that never started drains the queue with its workers
+	p.Start()
+	p.wg.Wait()
+}
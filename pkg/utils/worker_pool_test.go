@@ -0,0 +1,93 @@
+package utils
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_WorkerPoolBoundedConcurrency(t *testing.T) {
+	const (
+		workers = 8
+		tasks   = 10000
+	)
+	pool := NewWorkerPool(context.Background(), workers, tasks, time.Second)
+	pool.Start()
+
+	var running, highWater, done int64
+	for i := 0; i < tasks; i++ {
+		require.True(t, pool.TrySubmit(func(ctx context.Context) {
+			cur := atomic.AddInt64(&running, 1)
+			for {
+				prev := atomic.LoadInt64(&highWater)
+				if cur <= prev || atomic.CompareAndSwapInt64(&highWater, prev, cur) {
+					break
+				}
+			}
+			// give the other workers the chance to overlap
+			time.Sleep(10 * time.Microsecond)
+			atomic.AddInt64(&running, -1)
+			atomic.AddInt64(&done, 1)
+		}))
+	}
+	pool.Close()
+
+	require.Equal(t, int64(tasks), atomic.LoadInt64(&done))
+	require.LessOrEqual(t, atomic.LoadInt64(&highWater), int64(workers))
+	require.Greater(t, atomic.LoadInt64(&highWater), int64(0))
+	require.Equal(t, uint64(0), pool.Dropped())
+}
+
+func Test_WorkerPoolDrops(t *testing.T) {
+	pool := NewWorkerPool(context.Background(), 1, 2, 0)
+
+	// without workers, the queue fills up
+	for i := 0; i < 2; i++ {
+		require.True(t, pool.TrySubmit(func(ctx context.Context) {}))
+	}
+	require.Equal(t, 2, pool.QueueDepth())
+	require.False(t, pool.TrySubmit(func(ctx context.Context) {}))
+	require.Equal(t, uint64(1), pool.Dropped())
+
+	// closing drains the queued tasks, and the later ones are dropped
+	pool.Close()
+	require.Equal(t, 0, pool.QueueDepth())
+	require.False(t, pool.TrySubmit(func(ctx context.Context) {}))
+	require.Equal(t, uint64(2), pool.Dropped())
+	pool.Close()
+}
+
+func Test_WorkerPoolTimeout(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	pool := NewWorkerPool(ctx, 2, 4, 20*time.Millisecond)
+	pool.Start()
+
+	expired := make(chan error, 1)
+	require.True(t, pool.TrySubmit(func(ctx context.Context) {
+		<-ctx.Done()
+		expired <- ctx.Err()
+	}))
+	select {
+	case err := <-expired:
+		require.Equal(t, context.DeadlineExceeded, err)
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "the task didn't time out")
+	}
+
+	// the queued tasks get a dead context once the pool's one dies
+	cancel()
+	var canceled int64
+	for i := 0; i < 4; i++ {
+		pool.TrySubmit(func(ctx context.Context) {
+			if ctx.Err() != nil {
+				atomic.AddInt64(&canceled, 1)
+			}
+		})
+	}
+	pool.Close()
+	require.Equal(t, int64(4), atomic.LoadInt64(&canceled))
+}